@@ -0,0 +1,214 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/common/terminal"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var BackupCommand = Command{
+	Name:     "backup",
+	Synopsis: "Back up the database",
+	Usages: []string{"tmsu backup [OPTION]... [DEST]",
+		"tmsu backup --list [DIR]"},
+	Description: `Copies the database to DEST using Sqlite's online backup API, so that the copy is consistent even whilst the database is in use, e.g. by the virtual filesystem or another tmsu process.
+
+If DEST is a directory, or is not specified, the backup is written to a file within it named after the database and the time, e.g. 'db-20181015-093000'. DEST defaults to the 'backups' directory beside the database, e.g. '.tmsu/backups'.
+
+With --keep only the N most recent backups in the directory are kept: older ones are deleted.
+
+With --list the backups in DIR, or the default directory, are listed, most recent first.
+
+Use 'restore --backup' to restore the database from a backup.`,
+	Examples: []string{"$ tmsu backup",
+		"$ tmsu backup --keep=7 /media/backup/tmsu",
+		"$ tmsu backup ~/tags-before-reorganisation.db",
+		"$ tmsu backup --list\n2018-10-15 09:30  1261568  .tmsu/backups/db-20181015-093000"},
+	Options: Options{{"--keep", "-k", "keep only the N most recent backups", true, ""},
+		{"--list", "-l", "list the backups", false, ""}},
+	Exec: backupExec,
+}
+
+// unexported
+
+const backupTimeFormat = "20060102-150405"
+
+func backupExec(options Options, args []string, databasePath string) (error, warnings) {
+	if len(args) > 1 {
+		return fmt.Errorf("too many arguments"), nil
+	}
+
+	dest := defaultBackupDir(databasePath)
+	if len(args) == 1 {
+		dest = args[0]
+	}
+
+	if options.HasOption("--list") {
+		return listBackups(dest, databasePath), nil
+	}
+
+	keep := 0
+	if options.HasOption("--keep") {
+		value := options.Get("--keep").Argument
+		count, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || count == 0 {
+			return fmt.Errorf("invalid number of backups to keep '%v': must be a positive number", value), nil
+		}
+
+		keep = int(count)
+	}
+
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	// a DEST that is not a directory names the backup file itself
+	dir, destPath := dest, ""
+	stat, err := os.Stat(dest)
+	switch {
+	case len(args) == 0, err == nil && stat.IsDir(), strings.HasSuffix(dest, string(filepath.Separator)):
+		destPath = newBackupPath(dir, databasePath)
+	default:
+		dir, destPath = filepath.Dir(dest), dest
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("%v: could not create directory: %v", dir, err), nil
+	}
+
+	if err := store.Backup(destPath); err != nil {
+		return fmt.Errorf("%v: %v", destPath, err), nil
+	}
+
+	log.Infof("backed up database to '%v'", destPath)
+
+	if keep > 0 {
+		if err := rotateBackups(dir, databasePath, keep); err != nil {
+			return err, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// The directory backups are written to unless another is specified.
+func defaultBackupDir(databasePath string) string {
+	return filepath.Join(filepath.Dir(databasePath), "backups")
+}
+
+// The path within the directory for a backup of the database taken at the
+// time, e.g. 'default-20181015-093000.db' for 'default.db'.
+func backupPath(dir, databasePath string, when time.Time) string {
+	name := filepath.Base(databasePath)
+	extension := filepath.Ext(name)
+
+	return filepath.Join(dir, strings.TrimSuffix(name, extension)+"-"+when.Format(backupTimeFormat)+extension)
+}
+
+// The path for a new backup, named after the current time or, should there
+// already be a backup of that name, the first free second after it.
+func newBackupPath(dir, databasePath string) string {
+	when := time.Now()
+	for {
+		path := backupPath(dir, databasePath, when)
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			return path
+		}
+
+		when = when.Add(time.Second)
+	}
+}
+
+// The backups of the database in the directory, most recent first.
+func backupsIn(dir, databasePath string) ([]os.FileInfo, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%v: could not list directory: %v", dir, err)
+	}
+
+	name := filepath.Base(databasePath)
+	extension := filepath.Ext(name)
+	prefix := strings.TrimSuffix(name, extension) + "-"
+
+	backups := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), prefix) || !strings.HasSuffix(entry.Name(), extension) {
+			continue
+		}
+
+		stamp := strings.TrimSuffix(strings.TrimPrefix(entry.Name(), prefix), extension)
+		if _, err := time.Parse(backupTimeFormat, stamp); err != nil {
+			continue
+		}
+
+		backups = append(backups, entry)
+	}
+
+	// the time in the name sorts chronologically
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Name() > backups[j].Name()
+	})
+
+	return backups, nil
+}
+
+func rotateBackups(dir, databasePath string, keep int) error {
+	backups, err := backupsIn(dir, databasePath)
+	if err != nil {
+		return err
+	}
+
+	for index := keep; index < len(backups); index++ {
+		path := filepath.Join(dir, backups[index].Name())
+
+		log.Infof("deleting old backup '%v'", path)
+
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("%v: could not delete backup: %v", path, err)
+		}
+	}
+
+	return nil
+}
+
+func listBackups(dir, databasePath string) error {
+	backups, err := backupsIn(dir, databasePath)
+	if err != nil {
+		return err
+	}
+
+	table := terminal.NewTable(false, true, false)
+	for _, backup := range backups {
+		table.AddRow(backup.ModTime().Format("2006-01-02 15:04"), strconv.FormatInt(backup.Size(), 10), filepath.Join(dir, backup.Name()))
+	}
+	table.Print()
+
+	return nil
+}
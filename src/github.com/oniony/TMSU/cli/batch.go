@@ -0,0 +1,196 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/common/text"
+	"github.com/oniony/TMSU/storage"
+	"io"
+	"os"
+	"strings"
+)
+
+var BatchCommand = Command{
+	Name:     "batch",
+	Synopsis: "Runs several subcommands in a single transaction",
+	Usages:   []string{"tmsu batch [FILE]"},
+	Description: `Reads subcommands from FILE, or from standard input if no FILE is specified, and runs them within a single database transaction. If any subcommand fails then the changes made by all of them are rolled back, leaving the database as it was.
+
+Each line holds one subcommand, written as it would be on the command line but without the leading 'tmsu'. Arguments containing whitespace can be quoted or escaped as in the shell. Blank lines and lines starting with '#' are ignored.
+
+Global options such as --database cannot be used within the batch: specify them for the 'batch' subcommand itself. Only subcommands that solely change the database can be batched: those that serve, wait for changes, prompt or act upon anything but the database, such as 'init' and 'mount', cannot.`,
+	Examples: []string{`$ tmsu batch <<EOF
+rename photo picture
+tag --where=picture image
+delete picture
+EOF`,
+		"$ tmsu batch reorganise.tmsu"},
+	Options: Options{},
+	Exec:    batchExec,
+}
+
+// unexported
+
+var batchCommands []*Command
+
+// the storage shared by the subcommands of a batch
+var batchStorage *storage.Storage
+
+// the subcommands that work solely within the database transaction: those
+// that serve, wait, prompt or change anything other than the database cannot
+// be rolled back with the batch so are not permitted
+var batchableCommands = []string{"bootstrap", "bundle", "config", "copy", "copy-tags", "delete", "dupes", "expire", "extract", "files", "forget", "fsck", "history", "imply", "import", "index", "info", "matches", "merge", "normalize-tags", "ontology", "prune", "relate", "rename", "repair", "same-as", "status", "tag", "tag-def", "tag-info", "tags", "untag", "untagged", "values", "verify", "vocabulary"}
+
+type batchLine struct {
+	number  int
+	command *Command
+	options Options
+	args    []string
+}
+
+func batchExec(options Options, args []string, databasePath string) (error, warnings) {
+	var reader io.Reader
+	switch len(args) {
+	case 0:
+		reader = os.Stdin
+	case 1:
+		file, err := os.Open(args[0])
+		if err != nil {
+			return fmt.Errorf("could not open batch: %v", err), nil
+		}
+		defer file.Close()
+
+		reader = file
+	default:
+		return fmt.Errorf("too many arguments"), nil
+	}
+
+	// parse the whole batch up front so that a malformed line fails before anything is changed
+	lines, err := readBatch(reader)
+	if err != nil {
+		return err, nil
+	}
+
+	return executeBatch(lines, options, databasePath)
+}
+
+// Runs the lines against the database in a single transaction.
+func executeBatch(lines []batchLine, options Options, databasePath string) (error, warnings) {
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	if err := store.BeginBatch(); err != nil {
+		return fmt.Errorf("could not begin batch: %v", err), nil
+	}
+
+	batchStorage = store
+	err, warnings := runBatch(lines, options, databasePath)
+	batchStorage = nil
+
+	if err != nil {
+		log.Debug("rolling back batch")
+
+		if rollbackErr := store.EndBatch(false); rollbackErr != nil {
+			return fmt.Errorf("%v (could not roll back batch: %v)", err, rollbackErr), warnings
+		}
+
+		return err, warnings
+	}
+
+	log.Debug("committing batch")
+
+	if err := store.EndBatch(true); err != nil {
+		return fmt.Errorf("could not commit batch: %v", err), warnings
+	}
+
+	return nil, warnings
+}
+
+func readBatch(reader io.Reader) ([]batchLine, error) {
+	parser := NewOptionParser(globalOptions, batchCommands)
+	lines := make([]batchLine, 0, 10)
+
+	scanner := bufio.NewScanner(reader)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		command, options, args, err := parser.Parse(text.Tokenize(line)...)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", number, err)
+		}
+		if command == nil {
+			return nil, fmt.Errorf("line %v: no subcommand specified", number)
+		}
+
+		if !containsString(batchableCommands, command.Name) {
+			return nil, fmt.Errorf("line %v: the '%v' subcommand cannot be batched", number, command.Name)
+		}
+
+		for _, globalOption := range globalOptions {
+			if options.HasOption(globalOption.LongName) {
+				return nil, fmt.Errorf("line %v: global option '%v' cannot be used within a batch", number, globalOption.LongName)
+			}
+		}
+
+		lines = append(lines, batchLine{number, command, options, args})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read batch: %v", err)
+	}
+
+	return lines, nil
+}
+
+func runBatch(lines []batchLine, batchOptions Options, databasePath string) (error, warnings) {
+	warnings := make(warnings, 0, 10)
+
+	for _, line := range lines {
+		log.Debugf("line %v: running '%v'", line.number, line.command.Name)
+
+		options := append(append(make(Options, 0, len(batchOptions)+len(line.options)), batchOptions...), line.options...)
+
+		err, lineWarnings := line.command.Exec(options, line.args, databasePath)
+		for _, warning := range lineWarnings {
+			warnings = append(warnings, fmt.Sprintf("line %v: %v", line.number, warning))
+		}
+
+		if err != nil {
+			return fmt.Errorf("line %v: %v: %v", line.number, line.command.Name, err), warnings
+		}
+	}
+
+	return nil, warnings
+}
+
+func containsString(items []string, item string) bool {
+	for _, candidate := range items {
+		if candidate == item {
+			return true
+		}
+	}
+
+	return false
+}
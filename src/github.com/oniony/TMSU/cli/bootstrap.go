@@ -0,0 +1,288 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+var BootstrapCommand = Command{
+	Name:     "bootstrap",
+	Synopsis: "Tag files from their directory structure",
+	Usages:   []string{"tmsu bootstrap [OPTION]... DIR..."},
+	Description: `Tags the files within each DIR according to the directories they are organised into, such that an existing directory hierarchy can be adopted without tagging each file by hand.
+
+Each file below DIR is tagged with the names of the directories on its path, relative to DIR, down to the depth specified by --depth (default 1). Files deeper in the hierarchy are tagged with the directories down to that depth only, whilst files directly within DIR are not tagged.
+
+Directory names that are not valid tag names are skipped with a warning. Hidden files and directories are skipped unless --include-hidden is specified.
+
+With --templates, the files and directories below DIR are instead tagged according to the templates of the 'pathTemplates' setting, such that a hierarchy named after artists and albums, say, is tagged with the artist and album of each file. Those matching no template are skipped. The templates are also applied whenever files are tagged, so --templates need only be used to tag files added before a template. (See 'tmsu help config'.)`,
+	Examples: []string{"$ tmsu bootstrap ~/Music",
+		`$ tmsu bootstrap --depth=2 ~/Music
+$ tmsu tags ~/Music/Jazz/Nina\ Simone/Pastel\ Blues/Sinnerman.mp3
+/home/bob/Music/Jazz/Nina Simone/Pastel Blues/Sinnerman.mp3: Jazz Nina\ Simone`,
+		`$ tmsu config pathTemplates='/home/bob/Music/{genre}/{artist}/{album}/...'
+$ tmsu bootstrap --templates ~/Music`},
+	Options: Options{{"--depth", "-d", "the number of directory levels to convert to tags", true, ""},
+		{"--include-hidden", "-H", "don't skip hidden files/directories", false, ""},
+		{"--templates", "-t", "tag according to the 'pathTemplates' setting", false, ""}},
+	Exec: bootstrapExec,
+}
+
+// unexported
+
+func bootstrapExec(options Options, args []string, databasePath string) (error, warnings) {
+	if len(args) == 0 {
+		return fmt.Errorf("too few arguments"), nil
+	}
+
+	depth := 1
+	if options.HasOption("--depth") {
+		value, err := strconv.ParseUint(options.Get("--depth").Argument, 10, 16)
+		if err != nil || value == 0 {
+			return fmt.Errorf("invalid depth '%v': expected a positive number", options.Get("--depth").Argument), nil
+		}
+
+		depth = int(value)
+	}
+
+	includeHidden := options.HasOption("--include-hidden")
+	useTemplates := options.HasOption("--templates")
+	if useTemplates && options.HasOption("--depth") {
+		return fmt.Errorf("--depth cannot be used with --templates"), nil
+	}
+
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	tx, err := store.Begin()
+	if err != nil {
+		return err, nil
+	}
+	defer tx.Commit()
+
+	log.Debugf("loading settings")
+
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return err, nil
+	}
+
+	var templates []entities.PathTemplate
+	if useTemplates {
+		templates, err = settings.PathTemplates()
+		if err != nil {
+			return err, nil
+		}
+		if len(templates) == 0 {
+			return fmt.Errorf("no path templates: see the 'pathTemplates' setting"), nil
+		}
+	}
+
+	bootstrapper := bootstrapper{store, tx, settings, depth, includeHidden, templates, make(map[string]*entities.Tag), make(warnings, 0, 10)}
+
+	for _, path := range args {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("%v: could not get absolute path: %v", path, err), bootstrapper.warnings
+		}
+
+		stat, err := os.Stat(absPath)
+		if err != nil {
+			switch {
+			case os.IsPermission(err):
+				bootstrapper.warnings = append(bootstrapper.warnings, fmt.Sprintf("%v: permission denied", path))
+				continue
+			case os.IsNotExist(err):
+				bootstrapper.warnings = append(bootstrapper.warnings, fmt.Sprintf("%v: no such directory", path))
+				continue
+			default:
+				return fmt.Errorf("%v: could not stat directory: %v", path, err), bootstrapper.warnings
+			}
+		}
+		if !stat.IsDir() {
+			bootstrapper.warnings = append(bootstrapper.warnings, fmt.Sprintf("%v: not a directory", path))
+			continue
+		}
+
+		if err := bootstrapper.bootstrapDirectory(absPath, 0, nil); err != nil {
+			return err, bootstrapper.warnings
+		}
+	}
+
+	return nil, bootstrapper.warnings
+}
+
+type bootstrapper struct {
+	store         *storage.Storage
+	tx            *storage.Tx
+	settings      entities.Settings
+	depth         int
+	includeHidden bool
+	templates     []entities.PathTemplate
+	tags          map[string]*entities.Tag
+	warnings      warnings
+}
+
+func (bootstrapper *bootstrapper) bootstrapDirectory(path string, level int, pairs entities.TagIdValueIdPairs) error {
+	osFile, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("%v: could not open path: %v", path, err)
+	}
+
+	childNames, err := osFile.Readdirnames(0)
+	osFile.Close()
+	if err != nil {
+		return fmt.Errorf("%v: could not retrieve directory contents: %v", path, err)
+	}
+
+	for _, childName := range childNames {
+		childPath := filepath.Join(path, childName)
+		if childName[0] == '.' && !bootstrapper.includeHidden {
+			log.Debugf("%v: skipping hidden file/directory", childPath)
+			continue
+		}
+
+		stat, err := os.Stat(childPath)
+		if err != nil {
+			switch {
+			case os.IsPermission(err):
+				bootstrapper.warnings = append(bootstrapper.warnings, fmt.Sprintf("%v: permission denied", childPath))
+				continue
+			case os.IsNotExist(err):
+				bootstrapper.warnings = append(bootstrapper.warnings, fmt.Sprintf("%v: no such file", childPath))
+				continue
+			default:
+				return fmt.Errorf("%v: could not stat file: %v", childPath, err)
+			}
+		}
+
+		ignored, err := bootstrapper.store.Ignored(bootstrapper.tx, childPath, stat.IsDir())
+		if err != nil {
+			return err
+		}
+		if ignored {
+			log.Debugf("%v: skipping ignored file/directory", childPath)
+			continue
+		}
+
+		if bootstrapper.templates != nil {
+			if err := bootstrapper.bootstrapFromTemplates(childPath, stat.IsDir()); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if stat.IsDir() {
+			childPairs := pairs
+			if level < bootstrapper.depth {
+				childPairs, err = bootstrapper.appendDirectoryTag(pairs, childName, childPath)
+				if err != nil {
+					return err
+				}
+			}
+
+			if err := bootstrapper.bootstrapDirectory(childPath, level+1, childPairs); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if level == 0 {
+			log.Debugf("%v: skipping file at top level", childPath)
+			continue
+		}
+
+		if err := tagPath(bootstrapper.store, bootstrapper.tx, childPath, pairs, false, false, bootstrapper.includeHidden, false, symlinkFollow, make(directoryGuard), nil, false, newFingerprinter(bootstrapper.settings, 1), nil, bootstrapper.settings.ReportDuplicates(), bootstrapper.settings.PropagateDuplicateTags()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Tags the file or directory, if it matches a path template, with the tags the
+// templates derive from its path, then the contents of the directory.
+func (bootstrapper *bootstrapper) bootstrapFromTemplates(path string, isDir bool) error {
+	for _, template := range bootstrapper.templates {
+		if _, matched := template.Match(path, bootstrapper.store.RootPath); matched {
+			// the templates are applied to every path tagged
+			if err := tagPath(bootstrapper.store, bootstrapper.tx, path, nil, false, false, bootstrapper.includeHidden, false, symlinkFollow, make(directoryGuard), nil, false, newFingerprinter(bootstrapper.settings, 1), nil, bootstrapper.settings.ReportDuplicates(), bootstrapper.settings.PropagateDuplicateTags()); err != nil {
+				return err
+			}
+
+			break
+		}
+	}
+
+	if isDir {
+		return bootstrapper.bootstrapDirectory(path, 1, nil)
+	}
+
+	return nil
+}
+
+// Returns a copy of the pairs with the tag named after the directory appended.
+// Directories that do not make valid tag names contribute no tag.
+func (bootstrapper *bootstrapper) appendDirectoryTag(pairs entities.TagIdValueIdPairs, tagName, path string) (entities.TagIdValueIdPairs, error) {
+	tag, ok := bootstrapper.tags[tagName]
+	if !ok {
+		if err := entities.ValidateTagName(tagName); err != nil {
+			bootstrapper.warnings = append(bootstrapper.warnings, fmt.Sprintf("%v: cannot use directory name as tag: %v", path, err))
+			bootstrapper.tags[tagName] = nil
+			return pairs, nil
+		}
+
+		var err error
+		tag, err = bootstrapper.store.TagByName(bootstrapper.tx, tagName)
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve tag '%v': %v", tagName, err)
+		}
+		if tag == nil {
+			tag, err = createTag(bootstrapper.store, bootstrapper.tx, tagName)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		bootstrapper.tags[tagName] = tag
+	}
+	if tag == nil {
+		return pairs, nil
+	}
+
+	for _, pair := range pairs {
+		if pair.TagId == tag.Id {
+			return pairs, nil
+		}
+	}
+
+	childPairs := make(entities.TagIdValueIdPairs, len(pairs), len(pairs)+1)
+	copy(childPairs, pairs)
+
+	return append(childPairs, entities.TagIdValueIdPair{tag.Id, 0}), nil
+}
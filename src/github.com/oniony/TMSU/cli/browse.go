@@ -0,0 +1,553 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/oniony/TMSU/common/path"
+	"github.com/oniony/TMSU/common/terminal"
+	"github.com/oniony/TMSU/common/terminal/ansi"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+var BrowseCommand = Command{
+	Name:     "browse",
+	Synopsis: "Browse tags and files interactively",
+	Usages:   []string{"tmsu browse [QUERY]"},
+	Description: `Opens an interactive terminal browser with the database's tags listed on the left and the files matching the current QUERY on the right. The tags of the file under the cursor are shown at the foot of the screen.
+
+The following keys are recognised:
+
+  up/down, k/j  move the cursor
+  tab           switch between the tag and file panes
+  enter         in the tag pane, show the files with the tag
+  /             enter a new query (an empty query shows all files)
+  space         in the file pane, mark or unmark the file
+  t             toggle a TAG[=VALUE] on the marked files, or on the file
+                under the cursor if none are marked: the tag is removed if
+                every file has it, otherwise it is applied to those that don't
+  q, ctrl-c     quit
+
+Changes are committed as they are made.`,
+	Examples: []string{"$ tmsu browse",
+		"$ tmsu browse 'music and not mp3'"},
+	Options: Options{},
+	Exec:    browseExec,
+}
+
+// unexported
+
+const (
+	tagPane = iota
+	filePane
+)
+
+type browser struct {
+	store      *storage.Storage
+	reader     *bufio.Reader
+	query      string
+	tags       entities.Tags
+	files      entities.Files
+	fileTags   []string
+	marked     map[entities.FileId]bool
+	pane       int
+	cursors    [2]int
+	offsets    [2]int
+	message    string
+	width      int
+	height     int
+	output     *bufio.Writer
+	finished   bool
+	promptText string
+}
+
+func browseExec(options Options, args []string, databasePath string) (error, warnings) {
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	restore, err := terminal.MakeRaw()
+	if err != nil {
+		return err, nil
+	}
+	defer restore()
+
+	browser := &browser{
+		store:   store,
+		reader:  bufio.NewReader(os.Stdin),
+		query:   strings.Join(args, " "),
+		marked:  make(map[entities.FileId]bool),
+		pane:    filePane,
+		output:  bufio.NewWriter(os.Stdout),
+		message: "tab: switch pane  enter: show tag  /: query  space: mark  t: toggle tag  q: quit",
+	}
+
+	// use the alternate screen so that the user's scrollback is left intact
+	browser.output.WriteString("\x1b[?1049h\x1b[?25l")
+	defer func() {
+		browser.output.WriteString("\x1b[?25h\x1b[?1049l")
+		browser.output.Flush()
+	}()
+
+	if err := browser.refresh(); err != nil {
+		return err, nil
+	}
+
+	for !browser.finished {
+		browser.draw()
+
+		key, err := browser.readKey()
+		if err != nil {
+			return err, nil
+		}
+
+		if err := browser.handleKey(key); err != nil {
+			browser.message = err.Error()
+		}
+	}
+
+	return nil, nil
+}
+
+// Reloads the tags and the files matching the query.
+func (browser *browser) refresh() error {
+	tx, err := browser.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+
+	expression, err := parseQuery(browser.store, tx, browser.query)
+	if err != nil {
+		return err
+	}
+
+	tags, err := browser.store.Tags(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tags: %v", err)
+	}
+
+	files, err := browser.store.FilesForQuery(tx, expression, "", false, false, "name")
+	if err != nil {
+		return fmt.Errorf("could not query files: %v", err)
+	}
+
+	browser.tags = tags
+	browser.files = files
+
+	for index := range browser.cursors {
+		browser.cursors[index] = clamp(browser.cursors[index], 0, browser.itemCount(index)-1)
+	}
+
+	return browser.refreshFileTags()
+}
+
+// Reloads the tags of the file under the cursor.
+func (browser *browser) refreshFileTags() error {
+	browser.fileTags = nil
+
+	file := browser.currentFile()
+	if file == nil {
+		return nil
+	}
+
+	tx, err := browser.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+
+	fileTags, err := tagNamesForFile(browser.store, tx, file.Id, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	browser.fileTags = fileTags
+
+	return nil
+}
+
+func (browser *browser) handleKey(key string) error {
+	switch key {
+	case "q", "\x03":
+		browser.finished = true
+	case "\t":
+		browser.pane = 1 - browser.pane
+	case "up", "k":
+		return browser.moveCursor(-1)
+	case "down", "j":
+		return browser.moveCursor(1)
+	case "pgup":
+		return browser.moveCursor(-browser.paneHeight())
+	case "pgdn":
+		return browser.moveCursor(browser.paneHeight())
+	case "\r", "\n":
+		if browser.pane == tagPane && len(browser.tags) > 0 {
+			return browser.setQuery(escape(browser.tags[browser.cursors[tagPane]].Name, '=', ' ', '(', ')', '!', '<', '>'))
+		}
+	case "/":
+		text, ok, err := browser.prompt("query: ", browser.query)
+		if err != nil || !ok {
+			return err
+		}
+
+		return browser.setQuery(text)
+	case " ":
+		if file := browser.currentFile(); file != nil && browser.pane == filePane {
+			if browser.marked[file.Id] {
+				delete(browser.marked, file.Id)
+			} else {
+				browser.marked[file.Id] = true
+			}
+
+			return browser.moveCursor(1)
+		}
+	case "t":
+		files := browser.targetFiles()
+		if len(files) == 0 {
+			return fmt.Errorf("no files to tag")
+		}
+
+		tagArg, ok, err := browser.prompt(fmt.Sprintf("toggle tag on %v file(s): ", len(files)), "")
+		if err != nil || !ok || tagArg == "" {
+			return err
+		}
+
+		if err := browser.toggleTag(files, tagArg); err != nil {
+			return err
+		}
+
+		return browser.refresh()
+	}
+
+	return nil
+}
+
+func (browser *browser) setQuery(text string) error {
+	previous := browser.query
+	browser.query = text
+	browser.cursors[filePane] = 0
+	browser.offsets[filePane] = 0
+
+	if err := browser.refresh(); err != nil {
+		browser.query = previous
+		return err
+	}
+
+	browser.message = fmt.Sprintf("%v file(s)", len(browser.files))
+
+	return nil
+}
+
+func (browser *browser) moveCursor(delta int) error {
+	browser.cursors[browser.pane] = clamp(browser.cursors[browser.pane]+delta, 0, browser.itemCount(browser.pane)-1)
+
+	if browser.pane == filePane {
+		return browser.refreshFileTags()
+	}
+
+	return nil
+}
+
+// The files to act upon: those marked or, if none are, that under the cursor.
+func (browser *browser) targetFiles() entities.Files {
+	files := make(entities.Files, 0, len(browser.marked))
+	for _, file := range browser.files {
+		if browser.marked[file.Id] {
+			files = append(files, file)
+		}
+	}
+
+	if len(files) == 0 {
+		if file := browser.currentFile(); file != nil {
+			files = append(files, file)
+		}
+	}
+
+	return files
+}
+
+// Removes the tag from the files if they all have it, otherwise applies it to
+// those that do not.
+func (browser *browser) toggleTag(files entities.Files, tagArg string) error {
+	tx, err := browser.store.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+
+	settings, err := browser.store.Settings(tx)
+	if err != nil {
+		return err
+	}
+
+	pairs, warnings, err := parseTagValuePairs(browser.store, tx, settings, []string{tagArg}, nil)
+	if err != nil {
+		return err
+	}
+	if len(pairs) == 0 {
+		if len(warnings) > 0 {
+			return fmt.Errorf("%v", warnings[0])
+		}
+
+		return NoSuchTagError{tagArg, nil}
+	}
+
+	pair := pairs[0]
+
+	untagged := make(entities.Files, 0, len(files))
+	for _, file := range files {
+		exists, err := browser.store.FileTagExists(tx, file.Id, pair.TagId, pair.ValueId, true)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			untagged = append(untagged, file)
+		}
+	}
+
+	if len(untagged) == 0 {
+		for _, file := range files {
+			if err := browser.store.DeleteFileTag(tx, file.Id, pair.TagId, pair.ValueId); err != nil {
+				return fmt.Errorf("%v: could not remove tag: %v", file.Path(), err)
+			}
+		}
+
+		browser.message = fmt.Sprintf("removed '%v' from %v file(s)", tagArg, len(files))
+		return nil
+	}
+
+	for _, file := range untagged {
+		if _, err := browser.store.AddFileTag(tx, file.Id, pair.TagId, pair.ValueId); err != nil {
+			return fmt.Errorf("%v: could not apply tag: %v", file.Path(), err)
+		}
+	}
+
+	browser.message = fmt.Sprintf("applied '%v' to %v file(s)", tagArg, len(untagged))
+	return nil
+}
+
+func (browser *browser) currentFile() *entities.File {
+	if len(browser.files) == 0 {
+		return nil
+	}
+
+	return browser.files[browser.cursors[filePane]]
+}
+
+func (browser *browser) itemCount(pane int) int {
+	if pane == tagPane {
+		return len(browser.tags)
+	}
+
+	return len(browser.files)
+}
+
+func (browser *browser) paneHeight() int {
+	return browser.height - 3 // header, file tags and status lines
+}
+
+// Reads a line of text at the foot of the screen, returning false if cancelled.
+func (browser *browser) prompt(label, text string) (string, bool, error) {
+	for {
+		browser.promptText = label + text
+		browser.draw()
+
+		key, err := browser.readKey()
+		if err != nil {
+			return "", false, err
+		}
+
+		switch key {
+		case "\r", "\n":
+			browser.promptText = ""
+			return text, true, nil
+		case "esc", "\x03":
+			browser.promptText = ""
+			return "", false, nil
+		case "backspace":
+			if len(text) > 0 {
+				_, size := utf8.DecodeLastRuneInString(text)
+				text = text[:len(text)-size]
+			}
+		default:
+			if utf8.RuneCountInString(key) == 1 && key >= " " {
+				text += key
+			}
+		}
+	}
+}
+
+func (browser *browser) readKey() (string, error) {
+	r, _, err := browser.reader.ReadRune()
+	if err != nil {
+		return "", err
+	}
+
+	switch r {
+	case 127, '\b':
+		return "backspace", nil
+	case '\x1b':
+		// a lone escape is the escape key whereas a sequence arrives all at once
+		if browser.reader.Buffered() == 0 {
+			return "esc", nil
+		}
+
+		sequence := make([]byte, 0, 4)
+		for browser.reader.Buffered() > 0 {
+			b, err := browser.reader.ReadByte()
+			if err != nil {
+				return "", err
+			}
+
+			sequence = append(sequence, b)
+			if len(sequence) > 1 && (b >= 'A' && b <= 'Z' || b == '~') {
+				break
+			}
+		}
+
+		switch string(sequence) {
+		case "[A", "OA":
+			return "up", nil
+		case "[B", "OB":
+			return "down", nil
+		case "[5~":
+			return "pgup", nil
+		case "[6~":
+			return "pgdn", nil
+		}
+
+		return "", nil
+	}
+
+	return string(r), nil
+}
+
+func (browser *browser) draw() {
+	browser.width = terminal.Width()
+	browser.height = terminal.Height()
+	if browser.width <= 0 || browser.height <= 0 {
+		browser.width, browser.height = 80, 24
+	}
+
+	out := browser.output
+	out.WriteString("\x1b[H\x1b[2J")
+
+	header := fmt.Sprintf(" tmsu browse  query: %v  (%v files, %v marked)", browser.query, len(browser.files), len(browser.marked))
+	browser.writeLine(1, ansi.Invert(fit(header, browser.width)))
+
+	tagWidth := browser.width / 3
+	if tagWidth > 30 {
+		tagWidth = 30
+	}
+	fileWidth := browser.width - tagWidth - 1
+
+	rows := browser.paneHeight()
+	for pane := range browser.offsets {
+		cursor := browser.cursors[pane]
+		switch {
+		case cursor < browser.offsets[pane]:
+			browser.offsets[pane] = cursor
+		case cursor >= browser.offsets[pane]+rows:
+			browser.offsets[pane] = cursor - rows + 1
+		}
+	}
+
+	for row := 0; row < rows; row++ {
+		var line string
+
+		tagIndex := browser.offsets[tagPane] + row
+		if tagIndex < len(browser.tags) {
+			line = browser.highlight(tagPane, tagIndex, fit(" "+browser.tags[tagIndex].Name, tagWidth))
+		} else {
+			line = strings.Repeat(" ", tagWidth)
+		}
+
+		line += "|"
+
+		fileIndex := browser.offsets[filePane] + row
+		if fileIndex < len(browser.files) {
+			file := browser.files[fileIndex]
+
+			mark := "  "
+			if browser.marked[file.Id] {
+				mark = " *"
+			}
+
+			line += browser.highlight(filePane, fileIndex, fit(mark+path.Rel(file.Path()), fileWidth))
+		}
+
+		browser.writeLine(row+2, line)
+	}
+
+	browser.writeLine(browser.height-1, ansi.DarkGrey(fit(" "+strings.Join(browser.fileTags, " "), browser.width)))
+
+	if browser.promptText != "" {
+		browser.writeLine(browser.height, fit(browser.promptText, browser.width-1)+"_")
+	} else {
+		browser.writeLine(browser.height, fit(browser.message, browser.width))
+	}
+
+	out.Flush()
+}
+
+func (browser *browser) highlight(pane, index int, text string) string {
+	if index != browser.cursors[pane] {
+		return text
+	}
+
+	if pane == browser.pane {
+		return ansi.Invert(text)
+	}
+
+	return ansi.Underline(text)
+}
+
+func (browser *browser) writeLine(row int, text string) {
+	fmt.Fprintf(browser.output, "\x1b[%v;1H%v", row, text)
+}
+
+// Truncates or pads the text to the specified width.
+func fit(text string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+
+	runes := []rune(text)
+	if len(runes) > width {
+		return string(runes[:width-1]) + "~"
+	}
+
+	return text + strings.Repeat(" ", width-len(runes))
+}
+
+func clamp(value, lower, upper int) int {
+	if value > upper {
+		value = upper
+	}
+	if value < lower {
+		value = lower
+	}
+
+	return value
+}
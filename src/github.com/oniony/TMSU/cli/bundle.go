@@ -0,0 +1,259 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage"
+	"github.com/oniony/TMSU/storage/database"
+	"strings"
+)
+
+var BundleCommand = Command{
+	Name:     "bundle",
+	Synopsis: "Manage named sets of tags",
+	Usages: []string{"tmsu bundle [list] [NAME]...",
+		"tmsu bundle create NAME TAG[=VALUE]...",
+		"tmsu bundle delete NAME..."},
+	Description: `Manages bundles: named sets of tags that can be applied together.
+
+A bundle is applied by specifying its name prefixed with '@' in place of a tag to the 'tag' subcommand, or wherever else tags are applied, whereupon it is expanded to the tags it contains. A tag whose name begins with '@' must have it escaped with a backslash, else it is taken to be a bundle and, should no such bundle exist, refused.
+
+When run without arguments, or with 'list', lists the bundles along with their tags, or just those of the bundles named.
+
+With 'create' the bundle NAME is created with the tags specified, which may themselves include other bundles.
+
+With 'delete' the bundles named are removed. The tags already applied from them are unaffected.`,
+	Examples: []string{"$ tmsu bundle create camera-import exif-done needs-rating source=camera",
+		`$ tmsu bundle
+camera-import: exif-done needs-rating source=camera`,
+		"$ tmsu tag photo.jpg @camera-import",
+		"$ tmsu bundle delete camera-import"},
+	Options: Options{},
+	Exec:    bundleExec,
+}
+
+// unexported
+
+func bundleExec(options Options, args []string, databasePath string) (error, warnings) {
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	tx, err := store.Begin()
+	if err != nil {
+		return err, nil
+	}
+	defer tx.Commit()
+
+	action := "list"
+	if len(args) > 0 {
+		switch args[0] {
+		case "list", "create", "delete":
+			action = args[0]
+			args = args[1:]
+		}
+	}
+
+	switch action {
+	case "create":
+		if len(args) < 2 {
+			return fmt.Errorf("a bundle name and at least one tag must be specified"), nil
+		}
+
+		return createBundle(store, tx, args[0], args[1:]), nil
+	case "delete":
+		if len(args) == 0 {
+			return fmt.Errorf("too few arguments"), nil
+		}
+
+		return deleteBundles(store, tx, args)
+	default:
+		return listBundles(store, tx, args)
+	}
+}
+
+func listBundles(store *storage.Storage, tx *storage.Tx, names []string) (error, warnings) {
+	log.Debug("retrieving bundles")
+
+	bundles, err := store.Bundles(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve bundles: %v", err), nil
+	}
+
+	if len(names) == 0 {
+		for _, bundle := range bundles {
+			fmt.Printf("%v: %v\n", bundle.Name, strings.Join(bundle.TagArgs, " "))
+		}
+
+		return nil, nil
+	}
+
+	warnings := make(warnings, 0, 10)
+	for _, name := range names {
+		bundle := bundles.ByName(name)
+		if bundle == nil {
+			warnings = append(warnings, fmt.Sprintf("no such bundle '%v'", name))
+			continue
+		}
+
+		fmt.Printf("%v: %v\n", bundle.Name, strings.Join(bundle.TagArgs, " "))
+	}
+
+	return nil, warnings
+}
+
+func createBundle(store *storage.Storage, tx *storage.Tx, name string, tagArgs []string) error {
+	if err := validateBundleName(name); err != nil {
+		return err
+	}
+
+	bundles, err := store.Bundles(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve bundles: %v", err)
+	}
+	if bundles.ByName(name) != nil {
+		return fmt.Errorf("bundle '%v' already exists", name)
+	}
+
+	for _, tagArg := range tagArgs {
+		if bundleName, ok := bundleReference(tagArg); ok {
+			if bundleName == name {
+				return fmt.Errorf("bundle '%v' cannot include itself", name)
+			}
+			if bundles.ByName(bundleName) == nil {
+				return database.NoSuchBundleError{bundleName}
+			}
+
+			continue
+		}
+
+		tagName, _ := parseTagEqValueName(tagArg)
+		if err := entities.ValidateTagName(tagName); err != nil {
+			return fmt.Errorf("'%v': %v", tagArg, err)
+		}
+	}
+
+	log.Debugf("adding bundle '%v'", name)
+
+	if err := store.AddBundle(tx, name, tagArgs); err != nil {
+		return fmt.Errorf("could not add bundle '%v': %v", name, err)
+	}
+
+	return nil
+}
+
+func deleteBundles(store *storage.Storage, tx *storage.Tx, names []string) (error, warnings) {
+	warnings := make(warnings, 0, 10)
+
+	for _, name := range names {
+		log.Debugf("deleting bundle '%v'", name)
+
+		if err := store.DeleteBundle(tx, name); err != nil {
+			switch err.(type) {
+			case database.NoSuchBundleError:
+				warnings = append(warnings, err.Error())
+				continue
+			default:
+				return fmt.Errorf("could not delete bundle '%v': %v", name, err), warnings
+			}
+		}
+	}
+
+	return nil, warnings
+}
+
+func validateBundleName(name string) error {
+	if name == "" {
+		return fmt.Errorf("bundle name cannot be empty")
+	}
+	if strings.ContainsAny(name, "=@\\\" \t\n") {
+		return fmt.Errorf("invalid bundle name '%v'", name)
+	}
+
+	return nil
+}
+
+// Identifies a tag argument that refers to a bundle: an '@' followed by the
+// bundle name. An escaped '@' is not a bundle reference.
+func bundleReference(tagArg string) (string, bool) {
+	if len(tagArg) < 2 || tagArg[0] != '@' {
+		return "", false
+	}
+
+	return tagArg[1:], true
+}
+
+// Replaces the references to bundles amongst the tag arguments with the tags
+// of those bundles. A reference to a bundle that does not exist is an error,
+// most likely a mistyped name, so as not to create a tag of that name.
+func expandBundles(store *storage.Storage, tx *storage.Tx, tagArgs []string) ([]string, error) {
+	hasReference := false
+	for _, tagArg := range tagArgs {
+		if _, ok := bundleReference(tagArg); ok {
+			hasReference = true
+			break
+		}
+	}
+	if !hasReference {
+		return tagArgs, nil
+	}
+
+	bundles, err := store.Bundles(tx)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve bundles: %v", err)
+	}
+
+	return expandBundleReferences(bundles, tagArgs, map[string]bool{})
+}
+
+func expandBundleReferences(bundles entities.Bundles, tagArgs []string, expanding map[string]bool) ([]string, error) {
+	expanded := make([]string, 0, len(tagArgs))
+
+	for _, tagArg := range tagArgs {
+		name, ok := bundleReference(tagArg)
+		if !ok {
+			expanded = append(expanded, tagArg)
+			continue
+		}
+
+		bundle := bundles.ByName(name)
+		if bundle == nil {
+			return nil, database.NoSuchBundleError{name}
+		}
+
+		if expanding[name] {
+			return nil, fmt.Errorf("bundle '%v' includes itself", name)
+		}
+
+		log.Debugf("expanding bundle '%v'", name)
+
+		expanding[name] = true
+		bundleTagArgs, err := expandBundleReferences(bundles, bundle.TagArgs, expanding)
+		if err != nil {
+			return nil, err
+		}
+		delete(expanding, name)
+
+		expanded = append(expanded, bundleTagArgs...)
+	}
+
+	return expanded, nil
+}
@@ -16,16 +16,22 @@
 package cli
 
 import (
+	"context"
 	"fmt"
 	"github.com/oniony/TMSU/common/log"
 	_path "github.com/oniony/TMSU/common/path"
 	"os"
+	"os/signal"
 	"os/user"
 	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
 )
 
 func Run() {
 	helpCommands = commands
+	batchCommands = commands
 
 	parser := NewOptionParser(globalOptions, commands)
 	command, options, arguments, err := parser.Parse(os.Args[1:]...)
@@ -40,15 +46,38 @@ func Run() {
 		command = findCommand(commands, "help")
 	}
 
-	log.Verbosity = options.Count("--verbose") + 1
+	if err := configureLog(options); err != nil {
+		log.Fatal(err)
+	}
+	log.AddField("pid", os.Getpid())
+	log.AddField("command", command.Name)
+
+	porcelain = options.HasOption("--porcelain")
+	dryRun = options.HasOption("--dry-run")
+	readOnly = options.HasOption("--read-only")
+	commandLine = "tmsu " + command.Name
+
+	switch {
+	case options.HasOption("--user"):
+		userName = options.Get("--user").Argument
+	case os.Getenv("TMSU_USER") != "":
+		userName = os.Getenv("TMSU_USER")
+	}
+
+	if options.HasOption("--wait") {
+		lockWait, err = parseLockWait(options.Get("--wait").Argument)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	var databasePath string
 	switch {
 	case options.HasOption("--database"):
-		log.Infof(2, "using database from command-line option")
+		log.Debugf("using database from command-line option")
 		databasePath = options.Get("--database").Argument
 	case os.Getenv("TMSU_DB") != "":
-		log.Infof(2, "using database from environment variable")
+		log.Debugf("using database from environment variable")
 		databasePath = os.Getenv("TMSU_DB")
 	default:
 		databasePath, err = findDatabase()
@@ -57,20 +86,20 @@ func Run() {
 		}
 	}
 
+	stopWatching := watchForInterrupt()
 	err, warnings := command.Exec(options, arguments, databasePath)
+	stopWatching()
 
-	if warnings != nil {
-		for _, warning := range warnings {
-			log.Warn(warning)
-		}
+	if err != nil && commandContext.Err() != nil {
+		err = fmt.Errorf("interrupted: changes in progress were rolled back")
 	}
 
-	if err != nil {
-		log.Warn(err.Error())
+	if err == nil {
+		warnings = append(warnings, runPendingHooks()...)
 	}
 
-	if err != nil || (warnings != nil && len(warnings) > 0) {
-		os.Exit(1)
+	if exitCode := reportFailures(err, warnings, porcelain, !log.Enabled(log.WarnLevel)); exitCode != exitSuccess {
+		os.Exit(exitCode)
 	}
 }
 
@@ -81,6 +110,147 @@ var globalOptions = Options{Option{"--verbose", "-v", "show verbose messages", f
 	Option{"--version", "-V", "show version information and exit", false, ""},
 	Option{"--database", "-D", "use the specified database", true, ""},
 	Option{"--color", "", "colorize the output (auto/always/never)", true, ""},
+	Option{"--dry-run", "", "report the changes that would be made without making them", false, ""},
+	Option{"--read-only", "", "open the database read-only, rejecting any changes", false, ""},
+	Option{"--wait", "", "wait for another process's lock on the database to be released (--wait=SECONDS to give up after a time)", false, ""},
+	Option{"--user", "", "attribute changes to the specified user", true, ""},
+	Option{"--quiet", "-q", "report only errors, not warnings or information", false, ""},
+	Option{"--porcelain", "", "report errors and warnings as tab-separated severity, kind and message", false, ""},
+	Option{"--log-level", "", "report messages of the level (error/warn/info/debug/trace) or more severe", true, ""},
+	Option{"--log-format", "", "write messages as text or json", true, ""},
+	Option{"--log-file", "", "write messages to the specified file rather than the terminal", true, ""},
+}
+
+// the logging options given, which are passed on to the virtual filesystem
+// daemon
+var logOptions []string
+
+// whether errors and warnings are reported in a machine-readable form
+var porcelain bool
+
+// whether changes are to be reported rather than committed
+var dryRun bool
+
+// whether the database is to be opened read-only
+var readOnly bool
+
+// how long to wait for another process's database lock
+var lockWait = defaultLockWait
+
+// the command recorded against the database lock whilst it is held
+var commandLine string
+
+// the user to whom changes are attributed, if not the operating system account
+var userName string
+
+// cancelled when the user interrupts the command, abandoning its transaction
+var commandContext = context.Background()
+
+// Cancels the command context upon the first interrupt or termination signal so
+// that the command can roll back and stop cleanly. A second signal terminates
+// the process immediately, in case the command is blocked, e.g. on input.
+func watchForInterrupt() func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	commandContext = ctx
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+			log.Warn("interrupted: stopping (interrupt again to terminate immediately)")
+			signal.Reset(os.Interrupt, syscall.SIGTERM)
+			cancel()
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+		signal.Stop(signals)
+	}
+}
+
+// how long a lock is waited upon when --wait is not specified, so that brief
+// contention with other processes or the virtual filesystem is ridden out
+const defaultLockWait = 5 * time.Second
+
+// Configures the logging from the global options: by default informational
+// messages are shown, --verbose showing debug messages or, if repeated, trace
+// messages and --quiet only errors, unless --log-level is specified.
+func configureLog(options Options) error {
+	switch verbosity := options.Count("--verbose"); {
+	case options.HasOption("--quiet"):
+		log.SetLevel(log.ErrorLevel)
+	case verbosity == 1:
+		log.SetLevel(log.DebugLevel)
+	case verbosity > 1:
+		log.SetLevel(log.TraceLevel)
+	}
+
+	if options.HasOption("--log-level") {
+		argument := options.Get("--log-level").Argument
+
+		level, err := log.ParseLevel(argument)
+		if err != nil {
+			return err
+		}
+
+		log.SetLevel(level)
+		logOptions = append(logOptions, "--log-level="+argument)
+	}
+
+	if options.HasOption("--log-format") {
+		argument := options.Get("--log-format").Argument
+
+		if err := log.SetFormat(argument); err != nil {
+			return err
+		}
+
+		logOptions = append(logOptions, "--log-format="+argument)
+	}
+
+	if options.HasOption("--log-file") {
+		argument := options.Get("--log-file").Argument
+
+		absPath, err := filepath.Abs(argument)
+		if err != nil {
+			return fmt.Errorf("could not get absolute path of log file '%v': %v", argument, err)
+		}
+
+		file, err := os.OpenFile(absPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("could not open log file: %v", err)
+		}
+
+		log.SetOutput(file)
+		logOptions = append(logOptions, "--log-file="+absPath)
+	}
+
+	return nil
+}
+
+// effectively indefinite: the longest wait Sqlite supports
+const lockWaitIndefinite = time.Duration(1<<31-1) * time.Millisecond
+
+func parseLockWait(timeout string) (time.Duration, error) {
+	if timeout == "" {
+		return lockWaitIndefinite, nil
+	}
+
+	seconds, err := strconv.ParseUint(timeout, 10, 32)
+	if err != nil || seconds == 0 {
+		return 0, fmt.Errorf("invalid wait timeout '%v': must be a positive number of seconds", timeout)
+	}
+
+	wait := time.Duration(seconds) * time.Second
+	if wait > lockWaitIndefinite {
+		wait = lockWaitIndefinite
+	}
+
+	return wait, nil
 }
 
 func findDatabase() (string, error) {
@@ -110,7 +280,7 @@ func findDatabaseInPath() (string, error) {
 	for {
 		dbPath := filepath.Join(path, ".tmsu", "db")
 
-		log.Infof(2, "looking for database at '%s'", dbPath)
+		log.Debugf("looking for database at '%s'", dbPath)
 
 		_, err := os.Stat(dbPath)
 		if err == nil {
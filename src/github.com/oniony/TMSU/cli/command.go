@@ -25,4 +25,7 @@ type Command struct {
 	Options     Options
 	Exec        func(options Options, arguments []string, databasePath string) (error, warnings)
 	Hidden      bool
+
+	// whether empty arguments, such as an empty replacement, are accepted
+	EmptyArguments bool
 }
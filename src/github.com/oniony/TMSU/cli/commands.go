@@ -20,25 +20,57 @@ package cli
 // unexported
 
 var commands = []*Command{
+	&BackupCommand,
+	&BatchCommand,
+	&BootstrapCommand,
+	&BrowseCommand,
+	&BundleCommand,
 	&ConfigCommand,
 	&CopyCommand,
+	&CopyTagsCommand,
 	&DeleteCommand,
+	&DialogCommand,
 	&DupesCommand,
+	&EventsCommand,
+	&ExpireCommand,
+	&ExtractCommand,
 	&FilesCommand,
+	&ForgetCommand,
+	&FsckCommand,
 	&HelpCommand,
+	&HistoryCommand,
 	&ImplyCommand,
+	&ImportCommand,
+	&IndexCommand,
 	&InfoCommand,
+	&IntegrateCommand,
 	&InitCommand,
+	&LinkTreeCommand,
+	&MatchesCommand,
 	&MergeCommand,
 	&MountCommand,
+	&NormalizeTagsCommand,
+	&OntologyCommand,
+	&PruneCommand,
+	&RelateCommand,
 	&RenameCommand,
 	&RepairCommand,
+	&RestoreCommand,
+	&SameAsCommand,
+	&ServeCommand,
+	&SetupCommand,
 	&StatusCommand,
+	&SyncCommand,
 	&TagCommand,
+	&TagDefCommand,
+	&TagInfoCommand,
 	&TagsCommand,
 	&UnmountCommand,
 	&UntagCommand,
 	&UntaggedCommand,
 	&ValuesCommand,
+	&VerifyCommand,
 	&VersionCommand,
-	&VfsCommand}
+	&VfsCommand,
+	&VocabularyCommand,
+	&VolumeCommand}
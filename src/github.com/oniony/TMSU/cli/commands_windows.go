@@ -20,22 +20,50 @@ package cli
 // unexported
 
 var commands = []*Command{
+	&BackupCommand,
+	&BatchCommand,
+	&BootstrapCommand,
+	&BrowseCommand,
+	&BundleCommand,
 	&ConfigCommand,
 	&CopyCommand,
+	&CopyTagsCommand,
 	&DeleteCommand,
 	&DupesCommand,
+	&EventsCommand,
+	&ExpireCommand,
+	&ExtractCommand,
 	&FilesCommand,
+	&ForgetCommand,
+	&FsckCommand,
 	&HelpCommand,
+	&HistoryCommand,
 	&ImplyCommand,
+	&ImportCommand,
+	&IndexCommand,
 	&InfoCommand,
 	&InitCommand,
+	&LinkTreeCommand,
+	&MatchesCommand,
 	&MergeCommand,
+	&NormalizeTagsCommand,
+	&OntologyCommand,
+	&PruneCommand,
 	&RenameCommand,
 	&RepairCommand,
+	&RestoreCommand,
+	&SameAsCommand,
+	&ServeCommand,
+	&SetupCommand,
 	&StatusCommand,
+	&SyncCommand,
 	&TagCommand,
+	&TagDefCommand,
+	&TagInfoCommand,
 	&TagsCommand,
 	&UntagCommand,
 	&UntaggedCommand,
 	&ValuesCommand,
-	&VersionCommand}
+	&VersionCommand,
+	&VocabularyCommand,
+	&VolumeCommand}
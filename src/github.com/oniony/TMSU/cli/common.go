@@ -19,12 +19,16 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/oniony/TMSU/common/log"
+	_path "github.com/oniony/TMSU/common/path"
 	"github.com/oniony/TMSU/common/terminal"
 	"github.com/oniony/TMSU/common/terminal/ansi"
 	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/query"
 	"github.com/oniony/TMSU/storage"
 	"github.com/oniony/TMSU/storage/database"
 	"os"
+	"os/user"
+	"path/filepath"
 	"strings"
 	"time"
 )
@@ -32,11 +36,15 @@ import (
 // unexported
 
 func openDatabase(path string) (*storage.Storage, error) {
-	storage, err := storage.OpenAt(path)
+	if batchStorage != nil && batchStorage.DbPath == path {
+		return batchStorage, nil
+	}
+
+	storage, err := storage.OpenAt(path, lockWait, readOnly)
 	if err != nil {
 		switch err.(type) {
 		case database.DatabaseNotFoundError:
-			return nil, fmt.Errorf("no database found: use 'tmsu init' to create one")
+			return nil, NoDatabaseError{}
 		case database.DatabaseAccessError:
 			return nil, fmt.Errorf("cannot access database: %v", err)
 		default:
@@ -44,9 +52,60 @@ func openDatabase(path string) (*storage.Storage, error) {
 		}
 	}
 
+	// loaded before the dry run is set so that this read is not reported
+	if err := loadNameQuoting(storage); err != nil {
+		storage.Close()
+		return nil, err
+	}
+
+	storage.SetContext(commandContext)
+	storage.SetDryRun(dryRun)
+	storage.SetCommand(commandLine)
+	storage.SetUser(currentUser())
+
 	return storage, nil
 }
 
+// Loads the 'nameQuoting' setting, which determines how the tag and value
+// names output are escaped.
+func loadNameQuoting(store *storage.Storage) error {
+	tx, err := store.BeginRead()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve settings: %v", err)
+	}
+
+	nameQuoting = settings.NameQuoting()
+
+	return nil
+}
+
+// The name of the user to whom changes are attributed: that specified by the
+// --user option or TMSU_USER environment variable or else the operating
+// system account running TMSU.
+func currentUser() string {
+	if userName != "" {
+		return userName
+	}
+
+	if account, err := user.Current(); err == nil {
+		return account.Username
+	}
+
+	for _, name := range []string{"USER", "USERNAME"} {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
 func stdoutIsCharDevice() bool {
 	stat, err := os.Stdout.Stat()
 	if err != nil {
@@ -59,6 +118,18 @@ func stdoutIsCharDevice() bool {
 	return false
 }
 
+func stdinIsCharDevice() bool {
+	stat, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	if stat.Mode()&os.ModeCharDevice != 0 {
+		return true
+	}
+
+	return false
+}
+
 func useColour(options Options) (bool, error) {
 	when := "auto"
 	if options.HasOption("--color") {
@@ -78,6 +149,59 @@ func useColour(options Options) (bool, error) {
 	return false, fmt.Errorf("invalid argument '%v' for '--color'", when)
 }
 
+// Determines how paths are to be listed from the --absolute and --relative-to
+// options.
+func pathFormat(options Options) (_path.Format, error) {
+	absolute := options.HasOption("--absolute")
+	relativeTo := ""
+
+	if options.HasOption("--relative-to") {
+		if absolute {
+			return _path.Format{}, fmt.Errorf("--absolute and --relative-to cannot be used together")
+		}
+
+		dir := options.Get("--relative-to").Argument
+		if dir == "" {
+			return _path.Format{}, fmt.Errorf("directory to list paths relative to must be specified")
+		}
+
+		var err error
+		relativeTo, err = filepath.Abs(dir)
+		if err != nil {
+			return _path.Format{}, fmt.Errorf("could not get absolute path of '%v': %v", dir, err)
+		}
+	}
+
+	return _path.Format{absolute, relativeTo}, nil
+}
+
+// Determines whether the file or directory at the absolute path is ignored
+// when searching the file-system.
+func isIgnored(store *storage.Storage, tx *storage.Tx, absPath string) (bool, error) {
+	isDir := false
+	if stat, err := os.Stat(absPath); err == nil {
+		isDir = stat.IsDir()
+	}
+
+	return store.Ignored(tx, absPath, isDir)
+}
+
+// Parses the query, expanding calls to the macros defined by the 'queryMacros'
+// setting.
+func parseQuery(store *storage.Storage, tx *storage.Tx, queryText string) (query.Expression, error) {
+	macros, err := store.QueryMacros(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	expression, err := query.ParseWithMacros(queryText, macros)
+	if err != nil {
+		return nil, InvalidQueryError{err}
+	}
+
+	return expression, nil
+}
+
 type emptyStat struct {
 	name string
 }
@@ -106,6 +230,22 @@ func (emptyStat) Sys() interface{} {
 	return nil
 }
 
+// Reports a tag that does not exist along with the existing tags whose names
+// are similar.
+func noSuchTagError(store *storage.Storage, tx *storage.Tx, tagName string) NoSuchTagError {
+	suggestions, err := store.SuggestTagNames(tx, tagName)
+	if err != nil {
+		log.Warnf("could not suggest similar tags: %v", err)
+	}
+
+	return NoSuchTagError{tagName, suggestions}
+}
+
+// Reports a tag that cannot be created because the vocabulary is closed.
+func closedVocabularyError(store *storage.Storage, tx *storage.Tx, tagName string) ClosedVocabularyError {
+	return ClosedVocabularyError{tagName, noSuchTagError(store, tx, tagName).Suggestions}
+}
+
 func createTag(store *storage.Storage, tx *storage.Tx, tagName string) (*entities.Tag, error) {
 	tag, err := store.AddTag(tx, tagName)
 	if err != nil {
@@ -128,50 +268,41 @@ func createValue(store *storage.Storage, tx *storage.Tx, valueName string) (*ent
 	return value, nil
 }
 
+// Parses a tag or value name, in which a backslash escapes the following
+// character and a double-quoted section is taken literally, as in queries. A
+// quote that is not closed is an ordinary character.
 func parseTagOrValueName(name string) string {
-	buffer := new(bytes.Buffer)
-	var escaped bool
-
-	for _, r := range name {
-		if escaped {
-			buffer.WriteRune(r)
-			escaped = false
-			continue
-		}
-
-		switch r {
-		case '\\':
-			escaped = true
-		default:
-			buffer.WriteRune(r)
-		}
-	}
-
-	return buffer.String()
+	tagName, _ := parseNames(name, false)
+	return tagName
 }
 
+// Parses a TAG=VALUE argument, in which an escaped or quoted '=' is part of the
+// tag name.
 func parseTagEqValueName(tagArg string) (string, string) {
+	return parseNames(tagArg, true)
+}
+
+func parseNames(text string, splitValue bool) (string, string) {
 	tagNameBuffer := new(bytes.Buffer)
 	valueNameBuffer := new(bytes.Buffer)
 	var buffer = tagNameBuffer
-	var escaped bool
+	runes := []rune(text)
 
-	for _, r := range tagArg {
-		if escaped {
-			buffer.WriteRune(r)
-			escaped = false
-			continue
-		}
+	for index := 0; index < len(runes); index++ {
+		r := runes[index]
 
-		switch r {
-		case '\\':
-			escaped = true
-		case '=':
-			if buffer == tagNameBuffer {
-				buffer = valueNameBuffer
-			} else {
-				buffer.WriteRune(r)
+		switch {
+		case r == '\\':
+			index++
+			if index < len(runes) {
+				buffer.WriteRune(runes[index])
 			}
+		case r == '"' && closingQuoteIndex(runes, index+1) >= 0:
+			closeIndex := closingQuoteIndex(runes, index+1)
+			buffer.WriteString(unquoteName(runes[index+1 : closeIndex]))
+			index = closeIndex
+		case r == '=' && splitValue && buffer == tagNameBuffer:
+			buffer = valueNameBuffer
 		default:
 			buffer.WriteRune(r)
 		}
@@ -180,13 +311,74 @@ func parseTagEqValueName(tagArg string) (string, string) {
 	return tagNameBuffer.String(), valueNameBuffer.String()
 }
 
-func formatTagValueName(tagName, valueName string, useColour, implicit, explicit bool) string {
-	tagName = escape(tagName, '=', ' ')
-	valueName = escape(valueName, '=', ' ')
+// The index of the double quote that closes the quoted section starting at
+// the specified index, or -1 if it is not closed.
+func closingQuoteIndex(runes []rune, start int) int {
+	for index := start; index < len(runes); index++ {
+		switch runes[index] {
+		case '\\':
+			index++
+		case '"':
+			return index
+		}
+	}
+
+	return -1
+}
+
+// The content of a quoted section, within which only quotes and backslashes
+// are escaped.
+func unquoteName(runes []rune) string {
+	buffer := new(bytes.Buffer)
+
+	for index := 0; index < len(runes); index++ {
+		r := runes[index]
+		if r == '\\' && index+1 < len(runes) && (runes[index+1] == '"' || runes[index+1] == '\\') {
+			index++
+			r = runes[index]
+		}
+
+		buffer.WriteRune(r)
+	}
+
+	return buffer.String()
+}
+
+// how tag and value names are escaped when output: 'backslash' or 'quotes'
+var nameQuoting = "backslash"
+
+// The characters in tag and value names that are escaped when output so that
+// the name can be read back as a single tag or value.
+var nameSpecialChars = []rune{'\\', '"', '=', ' '}
+
+// Escapes a tag or value name according to the 'nameQuoting' setting: each
+// special character is preceded by a backslash or, with 'quotes', a name
+// containing them is enclosed in double quotes.
+func formatName(name string) string {
+	if nameQuoting != "quotes" {
+		return escape(name, nameSpecialChars...)
+	}
+
+	if !strings.ContainsAny(name, string(nameSpecialChars)) {
+		return name
+	}
+
+	return `"` + escape(name, '\\', '"') + `"`
+}
+
+func formatTagValueName(tagName, valueName, tagColour string, useColour, implicit, explicit bool) string {
+	tagName = formatName(tagName)
+	valueName = formatName(valueName)
 
+	colourCode := ""
 	if useColour {
-		colourCode := colourCodeFor(implicit, explicit)
+		colourCode = colourCodeFor(implicit, explicit)
+		if colourCode == "" {
+			colourCode = ansi.CodeByName[tagColour]
+		}
+	}
 
+	if colourCode != "" {
 		if valueName == "" {
 			return colourCode + tagName + ansi.ResetCode
 		}
@@ -213,6 +405,40 @@ func colourCodeFor(implicit, explicit bool) string {
 	return ""
 }
 
+// Colours the escaped name of a tag with the colour given to it by the
+// 'tag-info' subcommand, if any.
+func colourTagName(tagName string, tagId entities.TagId, infos entities.TagInfos) string {
+	if info := infos.ForTag(tagId); info != nil && info.Colour != "" {
+		return ansi.CodeByName[info.Colour] + tagName + ansi.ResetCode
+	}
+
+	return tagName
+}
+
+// Retrieves the details of the tags, for their colours, if the output is to be
+// coloured.
+func tagInfosForColour(store *storage.Storage, tx *storage.Tx, colour bool) (entities.TagInfos, error) {
+	if !colour {
+		return nil, nil
+	}
+
+	infos, err := store.TagInfos(tx)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve tag details: %v", err)
+	}
+
+	return infos, nil
+}
+
+// The colour given to a tag by the 'tag-info' subcommand, if any.
+func tagColour(tagId entities.TagId, infos entities.TagInfos) string {
+	if info := infos.ForTag(tagId); info != nil {
+		return info.Colour
+	}
+
+	return ""
+}
+
 func escape(text string, chars ...rune) string {
 	for _, char := range chars {
 		text = strings.Replace(text, string(char), `\`+string(char), -1)
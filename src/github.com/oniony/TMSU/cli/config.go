@@ -17,7 +17,10 @@ package cli
 
 import (
 	"fmt"
+	"github.com/oniony/TMSU/common/ignore"
+	"github.com/oniony/TMSU/entities"
 	"github.com/oniony/TMSU/storage"
+	"path/filepath"
 	"strings"
 )
 
@@ -25,13 +28,137 @@ var ConfigCommand = Command{
 	Name:     "config",
 	Synopsis: "Views or amends database settings",
 	Usages: []string{"tmsu config",
-		"tmsu config NAME[=VALUE]..."},
+		"tmsu config NAME[=VALUE]...",
+		"tmsu config --reset NAME..."},
 	Description: `Lists or views the database settings for the current database.
 
 Without arguments the complete set of settings are shown, otherwise lists the settings for the specified setting NAMEs.
 
-If a VALUE is specified then the setting is updated.`,
-	Options: Options{},
+If a VALUE is specified then the setting is updated. The --reset option instead reverts the NAMEd settings to their defaults.
+
+The settings are:
+
+  autoCreateTags                 automatically create tags when first applied
+                                 (yes/no)
+  autoCreateValues               automatically create values when first
+                                 applied (yes/no)
+  autoTags                       rules applying tags to files by path when they
+                                 are tagged, of the form PATTERN:TAG[=VALUE]
+                                 separated by commas. A PATTERN containing a
+                                 path separator is matched against the absolute
+                                 path, otherwise against the file name
+  closedVocabulary               refuse to create tags when they are first
+                                 applied, unless they match newTagPatterns,
+                                 so that a mistyped tag is reported rather
+                                 than created. Tags can still be created with
+                                 'tag --create' (yes/no)
+  contentExtractors              the commands 'index' uses to extract the text
+                                 of files by extension, of the form
+                                 EXTENSION:COMMAND separated by commas. The
+                                 command is passed the file's path as $1
+  defaultSort                    the order 'files' lists files in unless --sort
+                                 is specified (id/none/name/size/time)
+  deleteThreshold                the number of files a tag may be applied to
+                                 before 'delete' requires --force or
+                                 confirmation, or 0 to always require it
+  directoryFingerprintAlgorithm  how directories are fingerprinted
+                                 (sumSizes/dynamic:sumSizes/none)
+  fileFingerprintAlgorithm       how files are fingerprinted
+                                 (dynamic:SHA256/dynamic:SHA1/dynamic:MD5/
+                                 dynamic:BLAKE2b/SHA256/SHA1/MD5/BLAKE2b/none)
+  forgottenRetention             the number of days 'repair' retains the tags
+                                 of forgotten files, so that they can be
+                                 restored, or 0 to retain them indefinitely
+  hooks                          commands run before or after tagging changes,
+                                 of the form EVENT:COMMAND separated by commas
+  ignoreCase                     match tag and value names in queries
+                                 regardless of case (yes/no)
+  ignorePatterns                 gitignore-style patterns, separated by
+                                 commas, of files and directories skipped by
+                                 'tag --recursive', 'status' and 'repair'.
+                                 Further patterns may be listed one per line
+                                 in '.tmsuignore' files
+  journalMode                    how changes are journalled (wal/delete). Use
+                                 'delete' for a database on a network
+                                 file-system, where the write-ahead log is
+                                 unsafe. Takes effect when the database is
+                                 next opened
+  materializeImplications        also store the tags implied by each file's
+                                 tags as implied file tags, for programs that
+                                 read the database directly (yes/no)
+  metadataMapping                the tags 'extract' applies for each metadata
+                                 field, of the form FIELD:TAG separated by
+                                 commas
+  nameQuoting                    how tag and value names containing spaces,
+                                 '=', '"' or '\' are output: each character
+                                 escaped with a backslash (backslash) or the
+                                 name enclosed in double quotes (quotes)
+  newTagPatterns                 glob patterns, separated by commas, of the
+                                 names of tags that may be created when first
+                                 applied even though closedVocabulary is
+                                 enabled, e.g. 'year-*,project-*'
+  normalizeNames                 store tag and value names in Unicode
+                                 normalisation form C and match them
+                                 regardless of case (yes/no). Use
+                                 'normalize-tags' to enable this on an
+                                 existing database
+  pathTemplates                  templates deriving tags from the components
+                                 of files' paths when they are tagged,
+                                 separated by commas, e.g.
+                                 '/music/{artist}/{album}/...'. Each {TAG}
+                                 applies TAG with the corresponding text as
+                                 its value, '*' and '?' match as in a glob and
+                                 a final '...' matches the rest of the path.
+                                 Relative templates are relative to the
+                                 database root. (See 'bootstrap --templates'
+                                 and 'repair --templates'.)
+  plugins                        external commands that fingerprint and
+                                 suggest tags for files by MIME type, of the
+                                 form MIME-PATTERN:COMMAND separated by
+                                 commas, e.g. 'image/x-canon-cr2:cr2info'. The
+                                 command is passed the file's path as $1 and
+                                 writes a JSON object such as
+                                 {"fingerprint": "...", "tags": ["a", "b=c"]}
+  propagateDuplicateTags         apply the tags applied to a file to the
+                                 files in the database with identical content
+                                 too (yes/no). (See 'same-as'.)
+  queryMacros                    macros queries may call, of the form
+                                 NAME(PARAM,...) = BODY separated by
+                                 semicolons, where the BODY refers to each
+                                 PARAM as $PARAM or ${PARAM}, e.g.
+                                 'recent(n) = mtime > now-${n}d'
+  reportDuplicates               warn when a file being tagged duplicates one
+                                 already tagged (yes/no)
+  searchPaths                    the directories 'repair' searches for moved
+                                 files, separated by the path list separator
+  strictVocabularies             reject values outside of a tag's vocabulary
+                                 (yes/no)
+  symlinkFingerprintAlgorithm    how symbolic links are fingerprinted
+                                 (follow/targetName/targetNameNoExt/none)
+  symlinkPolicy                  whether 'tag', 'untag', 'status' and
+                                 'repair' use the target of a symbolic link
+                                 (follow), the link itself (link) or, when
+                                 tagging, both the link and its target (both)
+  tagPermissions                 rules restricting who may apply and remove
+                                 tags, of the form PATTERN:PRINCIPAL...
+                                 separated by commas, where each PRINCIPAL is
+                                 an operating system user or, prefixed with
+                                 '@', group. A tag matching a rule's glob
+                                 PATTERN can only be changed by the users the
+                                 matching rules permit
+
+Hooks are run for the events pre-tag, post-tag, pre-untag, post-untag, pre-repair, post-repair, pre-delete and post-delete. As well as the commands in the 'hooks' setting, the executables named after the event in the 'hooks' directory beside the database (e.g. '.tmsu/hooks/post-tag') are run. The affected paths are passed to a hook on standard input, one per line, and the event, database, tags and values in the TMSU_EVENT, TMSU_DB, TMSU_TAGS and TMSU_VALUES environment variables, the latter two one per line. A pre- hook that fails prevents the change.
+
+The 'tagPermissions' setting guards a shared database against mistaken changes: it is not a substitute for file-system permissions, as anyone who can write to the database can change the setting.`,
+	Examples: []string{"$ tmsu config fileFingerprintAlgorithm=SHA1",
+		"$ tmsu config autoTags='*.jpg:photo,*.mp3:music'",
+		"$ tmsu config pathTemplates='/media/music/{artist}/{year} - {album}/...'",
+		"$ tmsu config hooks='post-tag:notify-send \"files tagged\"'",
+		"$ tmsu config closedVocabulary=yes newTagPatterns='project-*'",
+		"$ tmsu config tagPermissions='archived/*:@librarians alice'",
+		"$ tmsu config queryMacros='recent(n) = mtime > now-${n}d; lossless() = flac or wav'",
+		"$ tmsu config --reset autoTags"},
+	Options: Options{Option{"--reset", "-r", "revert the settings to their defaults", false, ""}},
 	Exec:    configExec,
 }
 
@@ -50,6 +177,20 @@ func configExec(options Options, args []string, databasePath string) (error, war
 	}
 	defer tx.Commit()
 
+	if options.HasOption("--reset") {
+		if len(args) == 0 {
+			return fmt.Errorf("setting name must be specified"), nil
+		}
+
+		for _, name := range args {
+			if err := resetSetting(store, tx, name); err != nil {
+				return fmt.Errorf("could not reset setting '%v': %v", name, err), nil
+			}
+		}
+
+		return nil, nil
+	}
+
 	if len(args) == 0 {
 		if err := listAllSettings(store, tx); err != nil {
 			return fmt.Errorf("could not list settings"), nil
@@ -62,7 +203,7 @@ func configExec(options Options, args []string, databasePath string) (error, war
 	}
 
 	for _, arg := range args {
-		parts := strings.Split(arg, "=")
+		parts := strings.SplitN(arg, "=", 2)
 		switch len(parts) {
 		case 1:
 			name := parts[0]
@@ -153,9 +294,120 @@ func amendSetting(store *storage.Storage, tx *storage.Tx, name, value string) er
 		return fmt.Errorf("no such setting '%v'", name)
 	}
 
+	if err := validateSetting(name, value); err != nil {
+		return err
+	}
+
 	if _, err = store.UpdateSetting(tx, name, value); err != nil {
 		return fmt.Errorf("could not update setting '%v': %v", name, err)
 	}
 
 	return nil
 }
+
+func resetSetting(store *storage.Storage, tx *storage.Tx, name string) error {
+	setting, err := store.Setting(tx, name)
+	if err != nil {
+		return fmt.Errorf("could not retrieve setting '%v'", err)
+	}
+	if setting == nil {
+		return fmt.Errorf("no such setting '%v'", name)
+	}
+
+	return store.ResetSetting(tx, name)
+}
+
+var fileFingerprintAlgorithms = []string{"dynamic:SHA256", "dynamic:SHA1", "dynamic:MD5", "dynamic:BLAKE2b", "SHA256", "SHA1", "MD5", "BLAKE2b", "none"}
+var directoryFingerprintAlgorithms = []string{"sumSizes", "dynamic:sumSizes", "none"}
+var symlinkFingerprintAlgorithms = []string{"follow", "targetName", "targetNameNoExt", "none"}
+var symlinkPolicies = []string{"follow", "link", "both"}
+var journalModes = []string{"wal", "delete"}
+var nameQuotings = []string{"backslash", "quotes"}
+var sorts = []string{"id", "none", "name", "size", "time"}
+var booleanSettingValues = []string{"yes", "Yes", "YES", "true", "True", "TRUE", "no", "No", "false", "False", "FALSE"}
+
+// Checks that the value is valid for the setting, so that an invalid setting
+// is rejected now rather than breaking later commands.
+func validateSetting(name, value string) error {
+	var validValues []string
+
+	switch name {
+	case "autoCreateTags", "autoCreateValues", "closedVocabulary", "ignoreCase", "materializeImplications", "normalizeNames", "propagateDuplicateTags", "reportDuplicates", "strictVocabularies":
+		validValues = booleanSettingValues
+	case "fileFingerprintAlgorithm":
+		validValues = fileFingerprintAlgorithms
+	case "directoryFingerprintAlgorithm":
+		validValues = directoryFingerprintAlgorithms
+	case "symlinkFingerprintAlgorithm":
+		validValues = symlinkFingerprintAlgorithms
+	case "symlinkPolicy":
+		validValues = symlinkPolicies
+	case "defaultSort":
+		validValues = sorts
+	case "journalMode":
+		validValues = journalModes
+	case "nameQuoting":
+		validValues = nameQuotings
+	case "autoTags":
+		_, err := entities.ParseAutoTags(value)
+		return err
+	case "contentExtractors":
+		_, err := entities.ParseContentExtractors(value)
+		return err
+	case "hooks":
+		_, err := entities.ParseHooks(value)
+		return err
+	case "ignorePatterns":
+		return validateIgnorePatterns(value)
+	case "newTagPatterns":
+		return validateNewTagPatterns(value)
+	case "tagPermissions":
+		_, err := entities.ParseTagPermissions(value)
+		return err
+	case "pathTemplates":
+		_, err := entities.ParsePathTemplates(value)
+		return err
+	case "plugins":
+		_, err := entities.ParsePlugins(value)
+		return err
+	case "queryMacros":
+		_, err := entities.Settings{&entities.Setting{name, value}}.QueryMacros()
+		return err
+	case "deleteThreshold":
+		_, err := entities.Settings{&entities.Setting{name, value}}.DeleteThreshold()
+		return err
+	case "forgottenRetention":
+		_, err := entities.Settings{&entities.Setting{name, value}}.ForgottenRetention()
+		return err
+	default:
+		return nil
+	}
+
+	if !containsString(validValues, value) {
+		return fmt.Errorf("invalid value '%v': expected one of %v", value, strings.Join(validValues, ", "))
+	}
+
+	return nil
+}
+
+func validateNewTagPatterns(value string) error {
+	if value == "" {
+		return nil
+	}
+
+	for _, pattern := range strings.Split(value, ",") {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern '%v': %v", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+func validateIgnorePatterns(value string) error {
+	if value == "" {
+		return nil
+	}
+
+	return ignore.ValidatePatterns(strings.Split(value, ","))
+}
@@ -63,7 +63,7 @@ func copyExec(options Options, args []string, databasePath string) (error, warni
 		return fmt.Errorf("could not retrieve tag '%v': %v", sourceTagName, err), nil
 	}
 	if sourceTag == nil {
-		return fmt.Errorf("no such tag '%v'", sourceTagName), nil
+		return NoSuchTagError{sourceTagName, nil}, nil
 	}
 
 	warnings := make(warnings, 0, 10)
@@ -78,7 +78,7 @@ func copyExec(options Options, args []string, databasePath string) (error, warni
 			continue
 		}
 
-		log.Infof(2, "copying tag '%v' to '%v'.", sourceTagName, destTagName)
+		log.Debugf("copying tag '%v' to '%v'.", sourceTagName, destTagName)
 
 		if _, err = store.CopyTag(tx, sourceTag.Id, destTagName); err != nil {
 			return fmt.Errorf("could not copy tag '%v' to '%v': %v", sourceTagName, destTagName, err), warnings
@@ -0,0 +1,118 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"path/filepath"
+)
+
+var CopyTagsCommand = Command{
+	Name:     "copy-tags",
+	Synopsis: "Copy tags from one file to others",
+	Usages:   []string{"tmsu copy-tags [OPTION]... SOURCE DEST..."},
+	Description: `Applies the tags and values explicitly applied to file SOURCE to each file DEST.
+
+The tags are applied explicitly to DEST even where they are implied by its other tags, so that the tags implied by the copied tags remain implied. With --move the tags are then removed from SOURCE, which is useful when a file has been replaced by another, e.g. after re-encoding.`,
+	Examples: []string{"$ tmsu copy-tags song.flac song.mp3",
+		"$ tmsu copy-tags --move video.avi video.mkv",
+		"$ tmsu copy-tags template.jpg photo1.jpg photo2.jpg"},
+	Options: Options{{"--move", "-m", "remove the tags from SOURCE once copied", false, ""},
+		{"--force", "-F", "apply tags to non-existent or non-permissioned paths", false, ""},
+		{"--no-dereference", "-P", "do not follow symbolic links", false, ""}},
+	Exec: copyTagsExec,
+}
+
+// unexported
+
+func copyTagsExec(options Options, args []string, databasePath string) (error, warnings) {
+	if len(args) < 2 {
+		return fmt.Errorf("too few arguments"), nil
+	}
+
+	move := options.HasOption("--move")
+	force := options.HasOption("--force")
+
+	sourcePath, err := filepath.Abs(args[0])
+	if err != nil {
+		return fmt.Errorf("%v: could not get absolute path: %v", args[0], err), nil
+	}
+
+	warnings := make(warnings, 0, 10)
+
+	destPaths := make([]string, 0, len(args)-1)
+	for _, path := range args[1:] {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("%v: could not get absolute path: %v", path, err), warnings
+		}
+
+		if absPath == sourcePath {
+			warnings = append(warnings, fmt.Sprintf("%v: cannot copy tags to the source file", path))
+			continue
+		}
+
+		destPaths = append(destPaths, absPath)
+	}
+
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, warnings
+	}
+	defer store.Close()
+
+	tx, err := store.Begin()
+	if err != nil {
+		return err, warnings
+	}
+	defer tx.Commit()
+
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve settings: %v", err), warnings
+	}
+
+	symlinks := symlinkPolicyFor(options, settings)
+
+	err, tagWarnings := tagFrom(store, tx, sourcePath, destPaths, true, false, false, force, symlinks, false, 1, nil)
+	warnings = append(warnings, tagWarnings...)
+	if err != nil {
+		return err, warnings
+	}
+
+	if move {
+		if symlinks.follow() {
+			sourcePath, err = filepath.EvalSymlinks(sourcePath)
+			if err != nil {
+				return err, warnings
+			}
+		}
+
+		file, err := store.FileByPath(tx, sourcePath)
+		if err != nil {
+			return fmt.Errorf("%v: could not retrieve file: %v", args[0], err), warnings
+		}
+
+		log.Debugf("%v: removing all tags.", args[0])
+
+		if err := store.DeleteFileTagsByFileId(tx, file.Id); err != nil {
+			return fmt.Errorf("%v: could not remove file's tags: %v", args[0], err), warnings
+		}
+	}
+
+	return nil, warnings
+}
@@ -16,20 +16,35 @@
 package cli
 
 import (
+	"bufio"
 	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/entities"
 	"github.com/oniony/TMSU/storage"
+	"io"
+	"os"
+	"strings"
 )
 
 var DeleteCommand = Command{
-	Name:        "delete",
-	Aliases:     []string{"del", "rm"},
-	Synopsis:    "Delete one or more tags",
-	Usages:      []string{"tmsu delete TAG..."},
-	Description: `Permanently deletes the TAGs specified.`,
+	Name:     "delete",
+	Aliases:  []string{"del", "rm"},
+	Synopsis: "Delete one or more tags",
+	Usages:   []string{"tmsu delete [OPTION]... TAG..."},
+	Description: `Permanently deletes the TAGs specified.
+
+Before a tag is deleted the number of files it is applied to and the implications that refer to it are shown. Where a tag is applied to more files than the 'deleteThreshold' setting permits (10 by default) you are asked to confirm the deletion, or if standard input is not a terminal the command fails without deleting anything, unless --force is specified.
+
+With --replace the files the TAGs are applied to are tagged with the tag REPLACEMENT, keeping their values, before the TAGs are deleted, all within the same transaction. REPLACEMENT is created if it does not exist.`,
 	Examples: []string{"$ tmsu delete pineapple",
-		"$ tmsu delete red green blue"},
-	Options: Options{Option{"--value", "", "delete a value", false, ""}},
-	Exec:    deleteExec,
+		"$ tmsu delete red green blue",
+		`$ tmsu delete --force holiday
+tmsu: tag 'holiday' is applied to 142 file(s)`,
+		"$ tmsu delete --replace=vacation holiday"},
+	Options: Options{Option{"--value", "", "delete a value", false, ""},
+		Option{"--force", "-f", "delete tags applied to many files without confirmation", false, ""},
+		Option{"--replace", "-r", "apply the tag REPLACEMENT to the files instead", true, ""}},
+	Exec: deleteExec,
 }
 
 // unexported
@@ -52,15 +67,58 @@ func deleteExec(options Options, args []string, databasePath string) (error, war
 	defer tx.Commit()
 
 	if options.HasOption("--value") {
+		if err := fireHooks(store, tx, hookEvent{"delete", nil, nil, args}); err != nil {
+			return err, nil
+		}
+
 		return deleteValue(store, tx, args)
 	}
 
-	return deleteTag(store, tx, args)
+	if err := fireHooks(store, tx, hookEvent{"delete", nil, args, nil}); err != nil {
+		return err, nil
+	}
+
+	force := options.HasOption("--force")
+
+	replacementName := ""
+	if options.HasOption("--replace") {
+		replacementName = parseTagOrValueName(options.Get("--replace").Argument)
+	}
+
+	return deleteTag(store, tx, args, force, replacementName)
 }
 
-func deleteTag(store *storage.Storage, tx *storage.Tx, tagArgs []string) (error, warnings) {
+func deleteTag(store *storage.Storage, tx *storage.Tx, tagArgs []string, force bool, replacementName string) (error, warnings) {
 	warnings := make(warnings, 0, 10)
 
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return err, warnings
+	}
+
+	threshold, err := settings.DeleteThreshold()
+	if err != nil {
+		return err, warnings
+	}
+
+	implications, err := store.Implications(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve implications: %v", err), warnings
+	}
+
+	var replacement *entities.Tag
+	if replacementName != "" {
+		replacement, err = store.TagByName(tx, replacementName)
+		if err != nil {
+			return fmt.Errorf("could not retrieve tag '%v': %v", replacementName, err), warnings
+		}
+	}
+
+	// every tag is checked before any is deleted so that a refusal leaves the
+	// database unchanged
+	tags := make(entities.Tags, 0, len(tagArgs))
+	fileTagsByTag := make(map[entities.TagId]entities.FileTags, len(tagArgs))
+	reader := bufio.NewReader(os.Stdin)
 	for _, tagArg := range tagArgs {
 		tagName := parseTagOrValueName(tagArg)
 
@@ -69,19 +127,110 @@ func deleteTag(store *storage.Storage, tx *storage.Tx, tagArgs []string) (error,
 			return fmt.Errorf("could not retrieve tag '%v': %v", tagName, err), warnings
 		}
 		if tag == nil {
-			warnings = append(warnings, fmt.Sprintf("no such tag '%v'", tagName))
+			warnings = append(warnings, noSuchTagWarning(NoSuchTagError{tagName, nil}))
 			continue
 		}
+		if replacement != nil && tag.Id == replacement.Id {
+			return fmt.Errorf("cannot replace tag '%v' with itself", tagName), warnings
+		}
 
-		err = store.DeleteTag(tx, tag.Id)
+		fileTags, err := store.FileTagsByTagId(tx, tag.Id, true)
 		if err != nil {
-			return fmt.Errorf("could not delete tag '%v': %v", tagName, err), warnings
+			return fmt.Errorf("could not retrieve files for tag '%v': %v", tagName, err), warnings
+		}
+
+		fileCount := previewTagDeletion(tag, fileTags, implications)
+		if fileCount > threshold && !force {
+			if !stdinIsCharDevice() {
+				return fmt.Errorf("tag '%v' is applied to %v file(s): use --force to delete it", tagName, fileCount), warnings
+			}
+
+			confirmed, err := confirmDeletion(reader, tagName)
+			if err != nil {
+				return err, warnings
+			}
+			if !confirmed {
+				warnings = append(warnings, fmt.Sprintf("tag '%v' was not deleted", tagName))
+				continue
+			}
+		}
+
+		tags = append(tags, tag)
+		fileTagsByTag[tag.Id] = fileTags
+	}
+
+	if replacementName != "" && replacement == nil && len(tags) > 0 {
+		replacement, err = createTag(store, tx, replacementName)
+		if err != nil {
+			return err, warnings
+		}
+	}
+
+	for _, tag := range tags {
+		if replacement != nil {
+			log.Debugf("applying tag '%v' in place of '%v'.", replacement.Name, tag.Name)
+
+			for _, fileTag := range fileTagsByTag[tag.Id] {
+				if _, err = store.AddFileTag(tx, fileTag.FileId, replacement.Id, fileTag.ValueId); err != nil {
+					return fmt.Errorf("could not apply tag '%v' to file #%v: %v", replacement.Name, fileTag.FileId, err), warnings
+				}
+			}
+		}
+
+		if err := store.DeleteTag(tx, tag.Id); err != nil {
+			return fmt.Errorf("could not delete tag '%v': %v", tag.Name, err), warnings
 		}
 	}
 
 	return nil, warnings
 }
 
+// Reports the number of files the tag is applied to and the implications that
+// refer to it, returning the number of files.
+func previewTagDeletion(tag *entities.Tag, fileTags entities.FileTags, implications entities.Implications) uint {
+	fileIds := make(map[entities.FileId]bool, len(fileTags))
+	for _, fileTag := range fileTags {
+		fileIds[fileTag.FileId] = true
+	}
+	fileCount := uint(len(fileIds))
+
+	if fileCount > 0 {
+		log.Infof("tag '%v' is applied to %v file(s)", tag.Name, fileCount)
+	}
+
+	for _, implication := range implications {
+		if implication.ImplyingTag.Id == tag.Id || implication.ImpliedTag.Id == tag.Id {
+			implying, implied := formatImplication(implication, nil, false)
+			log.Infof("tag '%v' is referred to by implication %v -> %v", tag.Name, implying, implied)
+		}
+	}
+
+	return fileCount
+}
+
+// Asks whether the tag should be deleted.
+func confirmDeletion(reader *bufio.Reader, tagName string) (bool, error) {
+	for {
+		fmt.Printf("delete tag '%v'? [y/N]: ", tagName)
+
+		answer, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "", "n", "no":
+			return false, nil
+		case "y", "yes":
+			return true, nil
+		}
+
+		if err == io.EOF {
+			return false, nil
+		}
+	}
+}
+
 func deleteValue(store *storage.Storage, tx *storage.Tx, valueArgs []string) (error, warnings) {
 	warnings := make(warnings, 0, 10)
 
@@ -93,7 +242,7 @@ func deleteValue(store *storage.Storage, tx *storage.Tx, valueArgs []string) (er
 			return fmt.Errorf("could not retrieve value '%v': %v", valueName, err), warnings
 		}
 		if value == nil {
-			warnings = append(warnings, fmt.Sprintf("no such value '%v'", valueName))
+			warnings = append(warnings, noSuchTagWarning(NoSuchValueError{valueName}))
 			continue
 		}
 
@@ -0,0 +1,294 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/common/text"
+	"github.com/oniony/TMSU/storage"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var DialogCommand = Command{
+	Name:     "dialog",
+	Synopsis: "Tag files or show their tags in a desktop dialog",
+	Usages:   []string{"tmsu dialog [OPTION]... tag FILE...", "tmsu dialog [OPTION]... tags FILE..."},
+	Description: `Shows a desktop dialog for the FILEs, as used by the file manager actions installed by the 'integrate' subcommand.
+
+  tag   prompts for the tags to apply to the FILEs, e.g. 'music year=2018'
+  tags  shows the tags of the FILEs
+
+Unless the database is specified with --database or the TMSU_DB environment variable, it is found from the directory of the first FILE rather than the working directory, as a file manager does not necessarily run the command from there.
+
+The dialogs are shown with zenity or, under KDE or where zenity is not installed, kdialog. --toolkit chooses which. Errors are also shown in a dialog.`,
+	Examples: []string{"$ tmsu dialog tag ~/Music/song.mp3",
+		"$ tmsu dialog --toolkit=kdialog tags *.jpg"},
+	Options: Options{{"--toolkit", "-t", "the program to show the dialog with: zenity or kdialog", true, ""}},
+	Exec:    dialogExec,
+}
+
+// unexported
+
+func dialogExec(options Options, args []string, databasePath string) (error, warnings) {
+	if len(args) < 2 {
+		return fmt.Errorf("too few arguments"), nil
+	}
+
+	toolkit := ""
+	if options.HasOption("--toolkit") {
+		toolkit = options.Get("--toolkit").Argument
+	}
+
+	dialogs, err := newDialogs(toolkit)
+	if err != nil {
+		return err, nil
+	}
+
+	paths := args[1:]
+
+	if !options.HasOption("--database") && os.Getenv("TMSU_DB") == "" {
+		if databasePath, err = findDatabaseFrom(paths[0]); err != nil {
+			return dialogs.fail(fmt.Errorf("could not find database: %v", err), nil)
+		}
+	}
+
+	switch args[0] {
+	case "tag":
+		return dialogs.fail(tagDialog(dialogs, options, paths, databasePath))
+	case "tags":
+		return dialogs.fail(tagsDialog(dialogs, paths, databasePath))
+	}
+
+	return fmt.Errorf("invalid dialog '%v': expected tag or tags", args[0]), nil
+}
+
+// Finds the database as it would be found from the directory of the file.
+func findDatabaseFrom(path string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.Chdir(filepath.Dir(absPath)); err != nil {
+		return "", err
+	}
+
+	return findDatabase()
+}
+
+func tagDialog(dialogs *dialogs, options Options, paths []string, databasePath string) (error, warnings) {
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	tx, err := store.Begin()
+	if err != nil {
+		return err, nil
+	}
+	defer tx.Commit()
+
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve settings: %v", err), nil
+	}
+
+	prompt := fmt.Sprintf("Tags to apply to %v files:", len(paths))
+	if len(paths) == 1 {
+		prompt = fmt.Sprintf("Tags to apply to '%v':", filepath.Base(paths[0]))
+	}
+
+	answer, ok, err := dialogs.entry("Tag with TMSU", prompt)
+	if err != nil || !ok {
+		return err, nil
+	}
+
+	tagArgs := text.Tokenize(answer)
+	if len(tagArgs) == 0 {
+		return nil, nil
+	}
+
+	if err := fireHooks(store, tx, hookEvent{"tag", paths, tagArgs, nil}); err != nil {
+		return err, nil
+	}
+
+	return tagPaths(store, tx, tagArgs, paths, false, false, false, false, symlinkPolicyFor(options, settings), false, 1, 0, false, nil)
+}
+
+func tagsDialog(dialogs *dialogs, paths []string, databasePath string) (error, warnings) {
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	tx, err := store.Begin()
+	if err != nil {
+		return err, nil
+	}
+	defer tx.Commit()
+
+	lines := make([]string, len(paths))
+	for index, path := range paths {
+		tagNames, err := dialogTagNames(store, tx, path)
+		if err != nil {
+			return err, nil
+		}
+
+		tagging := "(not tagged)"
+		if len(tagNames) > 0 {
+			tagging = strings.Join(tagNames, " ")
+		}
+
+		lines[index] = filepath.Base(path) + ": " + tagging
+	}
+
+	return dialogs.info("TMSU tags", strings.Join(lines, "\n")), nil
+}
+
+func dialogTagNames(store *storage.Storage, tx *storage.Tx, path string) ([]string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if resolvedPath, err := filepath.EvalSymlinks(absPath); err == nil {
+		absPath = resolvedPath
+	}
+
+	file, err := store.FileByPath(tx, absPath)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, nil
+	}
+
+	return tagNamesForFile(store, tx, file.Id, false, false, nil)
+}
+
+// Shows dialogs using zenity or kdialog.
+type dialogs struct {
+	toolkit string
+}
+
+func newDialogs(toolkit string) (*dialogs, error) {
+	switch toolkit {
+	case "zenity", "kdialog":
+		return &dialogs{toolkit}, nil
+	case "":
+		// detected below
+	default:
+		return nil, fmt.Errorf("invalid toolkit '%v': expected zenity or kdialog", toolkit)
+	}
+
+	toolkits := []string{"zenity", "kdialog"}
+	if strings.Contains(os.Getenv("XDG_CURRENT_DESKTOP"), "KDE") {
+		toolkits = []string{"kdialog", "zenity"}
+	}
+
+	for _, toolkit := range toolkits {
+		if _, err := exec.LookPath(toolkit); err == nil {
+			return &dialogs{toolkit}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no dialog program found: install zenity or kdialog")
+}
+
+// Prompts for a line of text, reporting whether it was given or cancelled.
+func (dialogs *dialogs) entry(title, prompt string) (string, bool, error) {
+	var command *exec.Cmd
+	switch dialogs.toolkit {
+	case "zenity":
+		command = exec.Command("zenity", "--entry", "--title="+title, "--text="+escapeMarkup(prompt))
+	case "kdialog":
+		command = exec.Command("kdialog", "--title", title, "--inputbox", prompt)
+	}
+
+	command.Stderr = os.Stderr
+
+	output, err := command.Output()
+	if err != nil {
+		if _, cancelled := err.(*exec.ExitError); cancelled {
+			return "", false, nil
+		}
+
+		return "", false, fmt.Errorf("could not run %v: %v", dialogs.toolkit, err)
+	}
+
+	return strings.TrimRight(string(output), "\n"), true, nil
+}
+
+func (dialogs *dialogs) info(title, message string) error {
+	switch dialogs.toolkit {
+	case "zenity":
+		return dialogs.run("--info", "--no-markup", "--title="+title, "--text="+message)
+	default:
+		return dialogs.run("--title", title, "--msgbox", message)
+	}
+}
+
+// Shows the error and warnings, if any, in an error dialog, returning them.
+func (dialogs *dialogs) fail(err error, warnings warnings) (error, warnings) {
+	messages := append([]string{}, warnings...)
+	if err != nil {
+		messages = append(messages, err.Error())
+	}
+	if len(messages) == 0 {
+		return err, warnings
+	}
+
+	message := strings.Join(messages, "\n")
+
+	var dialogErr error
+	switch dialogs.toolkit {
+	case "zenity":
+		dialogErr = dialogs.run("--error", "--no-markup", "--title=TMSU", "--text="+message)
+	default:
+		dialogErr = dialogs.run("--title", "TMSU", "--error", message)
+	}
+	if dialogErr != nil {
+		log.Warnf("could not show error: %v", dialogErr)
+	}
+
+	return err, warnings
+}
+
+func (dialogs *dialogs) run(args ...string) error {
+	command := exec.Command(dialogs.toolkit, args...)
+	command.Stderr = os.Stderr
+
+	if err := command.Run(); err != nil {
+		if _, closed := err.(*exec.ExitError); closed {
+			return nil
+		}
+
+		return fmt.Errorf("could not run %v: %v", dialogs.toolkit, err)
+	}
+
+	return nil
+}
+
+// Escapes the characters zenity would otherwise interpret as Pango markup.
+func escapeMarkup(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
@@ -21,27 +21,103 @@ import (
 	"github.com/oniony/TMSU/common/fingerprint"
 	"github.com/oniony/TMSU/common/log"
 	_path "github.com/oniony/TMSU/common/path"
+	"github.com/oniony/TMSU/common/units"
 	"github.com/oniony/TMSU/entities"
 	"github.com/oniony/TMSU/storage"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 var DupesCommand = Command{
-	Name:        "dupes",
-	Synopsis:    "Identify duplicate files",
-	Usages:      []string{"tmsu dupes [FILE]..."},
-	Description: `Identifies all files in the database that are exact duplicates of FILE. If no FILE is specified then identifies duplicates between files in the database.`,
+	Name:     "dupes",
+	Synopsis: "Identify duplicate files",
+	Usages: []string{"tmsu dupes [OPTION]... [FILE]...",
+		"tmsu dupes [OPTION]... --scan DIR"},
+	Description: `Identifies all files in the database that are exact duplicates of FILE. If no FILE is specified then identifies duplicates between files in the database.
+
+With --scan, searches DIR for files that are not in the database but which duplicate the content of files that are. Only files whose size matches that of a file in the database are fingerprinted, so large trees can be scanned cheaply.
+
+Duplicates can be limited to those under a particular directory with --path and to files of at least a particular size with --min-size. The size may have a K, M, G or T unit, e.g. 10M or 1.5 GB.
+
+With --similar, identifies images that look alike rather than files that are byte-identical, such as resized or re-encoded copies of a photo. Images are compared using a perceptual hash which is calculated the first time each file is examined and stored in the database. Images are considered similar if their hashes differ by no more than the --threshold number of bits (0-64, default 10): lower values find only very close matches. With --path only the images under PATH are examined, so that hashing a large database is not a prerequisite to comparing a few images. JPEG, PNG and GIF images are supported.`,
 	Examples: []string{"$ tmsu dupes\nSet of 2 duplicates:\n  /tmp/song.mp3\n  /tmp/copy of song.mp3a",
-		"$ tmsu dupes /tmp/song.mp3\n/tmp/copy of song.mp3"},
-	Options: Options{Option{"--recursive", "-r", "recursively check directory contents", false, ""}},
-	Exec:    dupesExec,
+		"$ tmsu dupes /tmp/song.mp3\n/tmp/copy of song.mp3",
+		"$ tmsu dupes --path=/tmp/music --min-size=1M\nSet of 2 duplicates:\n  /tmp/music/song.mp3\n  /tmp/music/copy of song.mp3",
+		"$ tmsu dupes --scan=/tmp/downloads\n/tmp/downloads/song.mp3:\n  /tmp/music/song.mp3",
+		"$ tmsu dupes --similar\nSet of 2 similar images:\n  /tmp/photo.jpg\n  /tmp/photo-small.png",
+		"$ tmsu dupes --similar --threshold=4 /tmp/photo.jpg\n/tmp/photo-small.png",
+		"$ tmsu dupes --similar --path=/tmp/holiday"},
+	Options: Options{Option{"--recursive", "-r", "recursively check directory contents", false, ""},
+		Option{"--similar", "-s", "identify visually similar images", false, ""},
+		Option{"--threshold", "-t", "maximum perceptual hash difference for similar images", true, ""},
+		Option{"--path", "-p", "identify only duplicates under PATH", true, ""},
+		Option{"--min-size", "-m", "ignore files smaller than SIZE", true, ""},
+		Option{"--scan", "-S", "find files under DIR duplicating files in the database", true, ""},
+		Option{"--jobs", "-j", "fingerprint up to N files at once (default: the number of processors)", true, ""}},
+	Exec: dupesExec,
 }
 
 // unexported
 
+const defaultSimilarityThreshold = 10
+
 func dupesExec(options Options, args []string, databasePath string) (error, warnings) {
 	recursive := options.HasOption("--recursive")
+	similar := options.HasOption("--similar")
+
+	threshold := uint(defaultSimilarityThreshold)
+	if options.HasOption("--threshold") {
+		if !similar {
+			return fmt.Errorf("--threshold can only be used with --similar"), nil
+		}
+
+		value, err := strconv.ParseUint(options.Get("--threshold").Argument, 10, 8)
+		if err != nil || value > 64 {
+			return fmt.Errorf("invalid threshold '%v': expected a number of bits from 0 to 64", options.Get("--threshold").Argument), nil
+		}
+
+		threshold = uint(value)
+	}
+
+	scopePath := ""
+	if options.HasOption("--path") {
+		absPath, err := filepath.Abs(options.Get("--path").Argument)
+		if err != nil {
+			return fmt.Errorf("could not get absolute path of '%v': %v", options.Get("--path").Argument, err), nil
+		}
+
+		scopePath = absPath
+	}
+
+	minSize := int64(0)
+	if options.HasOption("--min-size") {
+		size, err := units.ParseSize(options.Get("--min-size").Argument)
+		if err != nil {
+			return err, nil
+		}
+
+		minSize = size
+	}
+
+	scanPath := ""
+	if options.HasOption("--scan") {
+		if len(args) > 0 {
+			return fmt.Errorf("--scan cannot be used with FILE arguments"), nil
+		}
+
+		scanPath = options.Get("--scan").Argument
+	}
+
+	if similar && (minSize > 0 || scanPath != "") {
+		return fmt.Errorf("--similar cannot be used with --min-size or --scan"), nil
+	}
+
+	jobs, err := parseJobs(options)
+	if err != nil {
+		return err, nil
+	}
 
 	store, err := openDatabase(databasePath)
 	if err != nil {
@@ -55,28 +131,29 @@ func dupesExec(options Options, args []string, databasePath string) (error, warn
 	}
 	defer tx.Commit()
 
-	switch len(args) {
-	case 0:
-		return findDuplicatesInDb(store, tx), nil
+	switch {
+	case similar && len(args) == 0:
+		return findSimilarInDb(store, tx, scopePath, threshold), nil
+	case similar:
+		return findSimilarTo(store, tx, args, recursive, scopePath, threshold)
+	case scanPath != "":
+		return findDuplicatesOnDisk(store, tx, scanPath, scopePath, minSize, jobs)
+	case len(args) == 0:
+		return findDuplicatesInDb(store, tx, scopePath, minSize), nil
 	default:
-		return findDuplicatesOf(store, tx, args, recursive)
+		return findDuplicatesOf(store, tx, args, recursive, scopePath, minSize, jobs)
 	}
 }
 
-func findDuplicatesInDb(store *storage.Storage, tx *storage.Tx) error {
-	log.Info(2, "identifying duplicate files.")
-
-	fileSets, err := store.DuplicateFiles(tx)
-	if err != nil {
-		return fmt.Errorf("could not identify duplicate files: %v", err)
-	}
-
-	log.Infof(2, "found %v sets of duplicate files.", len(fileSets))
+func findDuplicatesInDb(store *storage.Storage, tx *storage.Tx, scopePath string, minSize int64) error {
+	log.Debug("identifying duplicate files.")
 
-	for index, fileSet := range fileSets {
-		if index > 0 {
+	count := 0
+	err := store.DuplicateFiles(tx, scopePath, minSize, func(fileSet entities.Files) error {
+		if count > 0 {
 			fmt.Println()
 		}
+		count++
 
 		fmt.Printf("Set of %v duplicates:\n", len(fileSet))
 
@@ -84,62 +161,302 @@ func findDuplicatesInDb(store *storage.Storage, tx *storage.Tx) error {
 			relPath := _path.Rel(file.Path())
 			fmt.Printf("  %v\n", relPath)
 		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not identify duplicate files: %v", err)
 	}
 
+	log.Debugf("found %v sets of duplicate files.", count)
+
 	return nil
 }
 
-func findDuplicatesOf(store *storage.Storage, tx *storage.Tx, paths []string, recursive bool) (error, warnings) {
+func findDuplicatesOf(store *storage.Storage, tx *storage.Tx, paths []string, recursive bool, scopePath string, minSize int64, jobs uint) (error, warnings) {
 	settings, err := store.Settings(tx)
 	if err != nil {
 		return err, nil
 	}
 
+	paths, warnings, err := checkDupesPaths(paths, recursive)
+	if err != nil {
+		return err, warnings
+	}
+
+	fingerprints := newFingerprinter(settings, jobs)
+
+	first := true
+	for index, path := range paths {
+		fingerprints.preparePathBatch(paths, index)
+
+		log.Debugf("%v: identifying duplicate files.", path)
+
+		fp, err := fingerprints.create(path)
+		if err != nil {
+			return fmt.Errorf("%v: could not create fingerprint: %v", path, err), warnings
+		}
+
+		if fp == fingerprint.Fingerprint("") {
+			continue
+		}
+
+		files, err := store.FilesByFingerprint(tx, fp)
+		if err != nil {
+			return fmt.Errorf("%v: could not retrieve files matching fingerprint '%v': %v", path, fp, err), warnings
+		}
+
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("%v: could not determine absolute path: %v", path, err), warnings
+		}
+
+		// filter out the file we're searching on
+		dupes := files.Where(func(file *entities.File) bool {
+			return file.Path() != absPath && file.Size >= minSize && isUnderPath(file.Path(), scopePath)
+		})
+
+		if len(paths) > 1 && len(dupes) > 0 {
+			if first {
+				first = false
+			} else {
+				fmt.Println()
+			}
+
+			fmt.Printf("%v:\n", path)
+
+			for _, dupe := range dupes {
+				relPath := _path.Rel(dupe.Path())
+				fmt.Printf("  %v\n", relPath)
+			}
+		} else {
+			for _, dupe := range dupes {
+				relPath := _path.Rel(dupe.Path())
+				fmt.Println(relPath)
+			}
+		}
+	}
+
+	return nil, warnings
+}
+
+// Walks the directory looking for files that are not in the database but which
+// duplicate the content of those that are. Only files of the same size as a
+// file in the database are fingerprinted, a batch at a time.
+func findDuplicatesOnDisk(store *storage.Storage, tx *storage.Tx, scanPath, scopePath string, minSize int64, jobs uint) (error, warnings) {
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return err, nil
+	}
+
+	log.Debugf("%v: scanning for duplicates of files in the database.", scanPath)
+
+	fingerprints := newFingerprinter(settings, jobs)
 	warnings := make(warnings, 0, 10)
-	for _, path := range paths {
-		_, err := os.Stat(path)
+	batch := make([]string, 0, fingerprintBatchSize)
+	first := true
+
+	reportBatch := func() error {
+		fingerprints.prepare(batch)
+
+		for _, path := range batch {
+			log.Debugf("%v: identifying duplicate files.", path)
+
+			fp, err := fingerprints.create(path)
+			if err != nil {
+				return fmt.Errorf("%v: could not create fingerprint: %v", path, err)
+			}
+			if fp == fingerprint.Fingerprint("") {
+				continue
+			}
+
+			files, err := store.FilesByFingerprint(tx, fp)
+			if err != nil {
+				return fmt.Errorf("%v: could not retrieve files matching fingerprint '%v': %v", path, fp, err)
+			}
+
+			dupes := files.Where(func(file *entities.File) bool { return isUnderPath(file.Path(), scopePath) })
+			if len(dupes) == 0 {
+				continue
+			}
+
+			if first {
+				first = false
+			} else {
+				fmt.Println()
+			}
+
+			fmt.Printf("%v:\n", path)
+
+			for _, dupe := range dupes {
+				relPath := _path.Rel(dupe.Path())
+				fmt.Printf("  %v\n", relPath)
+			}
+		}
+
+		batch = batch[:0]
+
+		return nil
+	}
+
+	err = filepath.Walk(scanPath, func(path string, stat os.FileInfo, err error) error {
 		if err != nil {
 			switch {
 			case os.IsNotExist(err):
 				warnings = append(warnings, fmt.Sprintf("%v: no such file", path))
-				continue
+				return nil
 			case os.IsPermission(err):
 				warnings = append(warnings, fmt.Sprintf("%v: permission denied", path))
-				continue
+				return nil
 			default:
-				return err, warnings
+				return err
 			}
 		}
-	}
 
-	if recursive {
-		p, err := filesystem.Enumerate(paths...)
+		if !stat.Mode().IsRegular() || stat.Size() < minSize {
+			return nil
+		}
+
+		count, err := store.FileCountBySize(tx, stat.Size())
 		if err != nil {
-			return fmt.Errorf("could not enumerate paths: %v", err), warnings
+			return fmt.Errorf("%v: could not retrieve files of size %v: %v", path, stat.Size(), err)
+		}
+		if count == 0 {
+			return nil
 		}
 
-		paths = make([]string, len(p))
-		for index, path := range p {
-			paths[index] = path.Path
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("%v: could not determine absolute path: %v", path, err)
+		}
+
+		file, err := store.FileByPath(tx, absPath)
+		if err != nil {
+			return fmt.Errorf("%v: could not retrieve file: %v", path, err)
 		}
+		if file != nil {
+			return nil
+		}
+
+		if batch = append(batch, path); len(batch) == fingerprintBatchSize {
+			return reportBatch()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err, warnings
 	}
 
-	first := true
-	for _, path := range paths {
-		log.Infof(2, "%v: identifying duplicate files.", path)
+	return reportBatch(), warnings
+}
 
-		fp, err := fingerprint.Create(path, settings.FileFingerprintAlgorithm(), settings.DirectoryFingerprintAlgorithm(), settings.SymlinkFingerprintAlgorithm())
-		if err != nil {
-			return fmt.Errorf("%v: could not create fingerprint: %v", path, err), warnings
+func findSimilarInDb(store *storage.Storage, tx *storage.Tx, scopePath string, threshold uint) error {
+	log.Debug("identifying similar images.")
+
+	hashes, err := perceptualHashes(store, tx, scopePath)
+	if err != nil {
+		return err
+	}
+
+	files, err := store.Files(tx, "name")
+	if err != nil {
+		return fmt.Errorf("could not retrieve files: %v", err)
+	}
+
+	images := files.Where(func(file *entities.File) bool {
+		_, ok := hashes[file.Id]
+		return ok && isUnderPath(file.Path(), scopePath)
+	})
+
+	// group transitively similar images: each image starts in its own set
+	setIndices := make([]int, len(images))
+	for index := range images {
+		setIndices[index] = index
+	}
+
+	var root func(int) int
+	root = func(index int) int {
+		if setIndices[index] != index {
+			setIndices[index] = root(setIndices[index])
 		}
 
-		if fp == fingerprint.Fingerprint("") {
+		return setIndices[index]
+	}
+
+	for index, image := range images {
+		for otherIndex := index + 1; otherIndex < len(images); otherIndex++ {
+			if hashes[image.Id].Distance(hashes[images[otherIndex].Id]) <= threshold {
+				setIndices[root(otherIndex)] = root(index)
+			}
+		}
+	}
+
+	fileSetByRoot := make(map[int]entities.Files)
+	roots := make([]int, 0, 10)
+	for index, image := range images {
+		setRoot := root(index)
+		if _, ok := fileSetByRoot[setRoot]; !ok {
+			roots = append(roots, setRoot)
+		}
+
+		fileSetByRoot[setRoot] = append(fileSetByRoot[setRoot], image)
+	}
+
+	first := true
+	for _, setRoot := range roots {
+		fileSet := fileSetByRoot[setRoot]
+		if len(fileSet) < 2 {
 			continue
 		}
 
-		files, err := store.FilesByFingerprint(tx, fp)
+		if first {
+			first = false
+		} else {
+			fmt.Println()
+		}
+
+		fmt.Printf("Set of %v similar images:\n", len(fileSet))
+
+		for _, file := range fileSet {
+			relPath := _path.Rel(file.Path())
+			fmt.Printf("  %v\n", relPath)
+		}
+	}
+
+	return nil
+}
+
+func findSimilarTo(store *storage.Storage, tx *storage.Tx, paths []string, recursive bool, scopePath string, threshold uint) (error, warnings) {
+	paths, warnings, err := checkDupesPaths(paths, recursive)
+	if err != nil {
+		return err, warnings
+	}
+
+	hashes, err := perceptualHashes(store, tx, scopePath)
+	if err != nil {
+		return err, warnings
+	}
+
+	files, err := store.Files(tx, "name")
+	if err != nil {
+		return fmt.Errorf("could not retrieve files: %v", err), warnings
+	}
+
+	first := true
+	for _, path := range paths {
+		log.Debugf("%v: identifying similar images.", path)
+
+		hash, err := fingerprint.CreatePerceptualHash(path)
 		if err != nil {
-			return fmt.Errorf("%v: could not retrieve files matching fingerprint '%v': %v", path, fp, err), warnings
+			if err == fingerprint.ErrUnsupportedImage {
+				if !recursive {
+					warnings = append(warnings, fmt.Sprintf("%v: not a supported image", path))
+				}
+				continue
+			}
+
+			return fmt.Errorf("%v: could not create perceptual hash: %v", path, err), warnings
 		}
 
 		absPath, err := filepath.Abs(path)
@@ -147,10 +464,12 @@ func findDuplicatesOf(store *storage.Storage, tx *storage.Tx, paths []string, re
 			return fmt.Errorf("%v: could not determine absolute path: %v", path, err), warnings
 		}
 
-		// filter out the file we're searching on
-		dupes := files.Where(func(file *entities.File) bool { return file.Path() != absPath })
+		similar := files.Where(func(file *entities.File) bool {
+			fileHash, ok := hashes[file.Id]
+			return ok && file.Path() != absPath && isUnderPath(file.Path(), scopePath) && hash.Distance(fileHash) <= threshold
+		})
 
-		if len(paths) > 1 && len(dupes) > 0 {
+		if len(paths) > 1 && len(similar) > 0 {
 			if first {
 				first = false
 			} else {
@@ -159,13 +478,13 @@ func findDuplicatesOf(store *storage.Storage, tx *storage.Tx, paths []string, re
 
 			fmt.Printf("%v:\n", path)
 
-			for _, dupe := range dupes {
-				relPath := _path.Rel(dupe.Path())
+			for _, file := range similar {
+				relPath := _path.Rel(file.Path())
 				fmt.Printf("  %v\n", relPath)
 			}
 		} else {
-			for _, dupe := range dupes {
-				relPath := _path.Rel(dupe.Path())
+			for _, file := range similar {
+				relPath := _path.Rel(file.Path())
 				fmt.Println(relPath)
 			}
 		}
@@ -173,3 +492,97 @@ func findDuplicatesOf(store *storage.Storage, tx *storage.Tx, paths []string, re
 
 	return nil, warnings
 }
+
+// Retrieves the perceptual hashes of the images in the database under the scope
+// path, first calculating those of any such files that have not yet been
+// examined, so that images outside of the scope are neither read nor compared.
+// The hashes of a read-only database are calculated afresh each time as they
+// cannot be stored.
+func perceptualHashes(store *storage.Storage, tx *storage.Tx, scopePath string) (map[entities.FileId]fingerprint.PerceptualHash, error) {
+	files, err := store.FilesWithoutPerceptualHash(tx)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve files: %v", err)
+	}
+
+	calculated := make(map[entities.FileId]fingerprint.PerceptualHash)
+	for _, file := range files {
+		if !isUnderPath(file.Path(), scopePath) {
+			continue
+		}
+
+		log.Debugf("%v: calculating perceptual hash.", file.Path())
+
+		var hashPtr *fingerprint.PerceptualHash
+		hash, err := fingerprint.CreatePerceptualHash(file.Path())
+		switch {
+		case err == fingerprint.ErrUnsupportedImage:
+		case err != nil:
+			// leave unhashed so that it is tried again once the file is repaired
+			log.Debugf("%v: could not calculate perceptual hash: %v", file.Path(), err)
+			continue
+		default:
+			hashPtr = &hash
+			calculated[file.Id] = hash
+		}
+
+		if store.ReadOnly() {
+			continue
+		}
+
+		if err := store.UpdatePerceptualHash(tx, file.Id, hashPtr); err != nil {
+			return nil, fmt.Errorf("%v: could not store perceptual hash: %v", file.Path(), err)
+		}
+	}
+
+	hashes, err := store.PerceptualHashes(tx)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve perceptual hashes: %v", err)
+	}
+
+	for fileId, hash := range calculated {
+		hashes[fileId] = hash
+	}
+
+	return hashes, nil
+}
+
+func isUnderPath(path, scopePath string) bool {
+	if scopePath == "" {
+		return true
+	}
+
+	return _path.Equal(path, scopePath) || _path.HasPrefix(path, strings.TrimSuffix(scopePath, string(filepath.Separator))+string(filepath.Separator))
+}
+
+func checkDupesPaths(paths []string, recursive bool) ([]string, warnings, error) {
+	warnings := make(warnings, 0, 10)
+	for _, path := range paths {
+		_, err := os.Stat(path)
+		if err != nil {
+			switch {
+			case os.IsNotExist(err):
+				warnings = append(warnings, fmt.Sprintf("%v: no such file", path))
+				continue
+			case os.IsPermission(err):
+				warnings = append(warnings, fmt.Sprintf("%v: permission denied", path))
+				continue
+			default:
+				return nil, warnings, err
+			}
+		}
+	}
+
+	if recursive {
+		p, err := filesystem.Enumerate(paths...)
+		if err != nil {
+			return nil, warnings, fmt.Errorf("could not enumerate paths: %v", err)
+		}
+
+		paths = make([]string, len(p))
+		for index, path := range p {
+			paths[index] = path.Path
+		}
+	}
+
+	return paths, warnings, nil
+}
@@ -17,16 +17,60 @@ package cli
 
 import (
 	"fmt"
+	"github.com/oniony/TMSU/common/i18n"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/storage/database"
+	"os"
+	"strings"
 )
 
 type warnings []string
 
+// The exit codes, by which scripts can distinguish the reasons for failure.
+const (
+	exitSuccess        = 0
+	exitFailure        = 1 // any other error
+	exitPartialFailure = 2 // the command completed but reported warnings
+	exitNoDatabase     = 3
+	exitNoSuchTag      = 4 // a tag or value does not exist
+	exitLocked         = 5 // another process holds the database lock
+	exitInvalidQuery   = 6
+)
+
+type NoDatabaseError struct{}
+
+func (err NoDatabaseError) Error() string {
+	return i18n.T("no database found: use 'tmsu init' to create one")
+}
+
+type InvalidQueryError struct {
+	Err error
+}
+
+func (err InvalidQueryError) Error() string {
+	return i18n.Tf("could not parse query: %v", err.Err)
+}
+
 type NoSuchTagError struct {
-	Name string
+	Name        string
+	Suggestions []string
 }
 
 func (err NoSuchTagError) Error() string {
-	return fmt.Sprintf("no such tag '%v'", err.Name)
+	return i18n.Tf("no such tag '%v'", err.Name) + didYouMean(err.Suggestions)
+}
+
+type ClosedVocabularyError struct {
+	Name        string
+	Suggestions []string
+}
+
+func (err ClosedVocabularyError) Error() string {
+	if len(err.Suggestions) > 0 {
+		return i18n.Tf("no such tag '%v'", err.Name) + didYouMean(err.Suggestions) + i18n.T(" (the vocabulary is closed so use 'tag --create' to create it)")
+	}
+
+	return i18n.Tf("no such tag '%v': the vocabulary is closed so use 'tag --create' to create it", err.Name)
 }
 
 type NoSuchValueError struct {
@@ -34,5 +78,87 @@ type NoSuchValueError struct {
 }
 
 func (err NoSuchValueError) Error() string {
-	return fmt.Sprintf("no such value '%v'", err.Name)
+	return i18n.Tf("no such value '%v'", err.Name)
+}
+
+// unexported
+
+// the warnings that report a tag or value that does not exist, so that a
+// command failing only for these exits with exitNoSuchTag
+var noSuchTagWarnings = make(map[string]bool)
+
+// Reports a tag that does not exist as a warning.
+func noSuchTagWarning(err error) string {
+	warning := err.Error()
+	noSuchTagWarnings[warning] = true
+
+	return warning
+}
+
+// Classifies the error, returning its exit code and the name of its kind as
+// reported in porcelain mode.
+func classifyError(err error) (int, string) {
+	switch err.(type) {
+	case NoDatabaseError, database.DatabaseNotFoundError:
+		return exitNoDatabase, "no-database"
+	case NoSuchTagError, ClosedVocabularyError, NoSuchValueError:
+		return exitNoSuchTag, "no-such-tag"
+	case database.DatabaseLockedError:
+		return exitLocked, "locked"
+	case InvalidQueryError:
+		return exitInvalidQuery, "invalid-query"
+	}
+
+	return exitFailure, "error"
+}
+
+// Classifies the warning, returning its exit code and the name of its kind.
+func classifyWarning(warning string) (int, string) {
+	if noSuchTagWarnings[warning] {
+		return exitNoSuchTag, "no-such-tag"
+	}
+
+	return exitPartialFailure, "warning"
+}
+
+// Reports the error and warnings of a command, returning the exit code. In
+// porcelain mode each is written as its severity, kind and message separated
+// by tabs; in quiet mode only the error is reported.
+func reportFailures(err error, warnings warnings, porcelain, quiet bool) int {
+	exitCode := exitSuccess
+
+	for _, warning := range warnings {
+		code, kind := classifyWarning(warning)
+		if exitCode == exitSuccess || exitCode == exitNoSuchTag {
+			exitCode = code
+		}
+
+		switch {
+		case porcelain:
+			fmt.Fprintf(os.Stderr, "warning\t%v\t%v\n", kind, warning)
+		case !quiet:
+			log.Warn(warning)
+		}
+	}
+
+	if err != nil {
+		code, kind := classifyError(err)
+		exitCode = code
+
+		if porcelain {
+			fmt.Fprintf(os.Stderr, "error\t%v\t%v\n", kind, err)
+		} else {
+			log.Error(err.Error())
+		}
+	}
+
+	return exitCode
+}
+
+func didYouMean(suggestions []string) string {
+	if len(suggestions) == 0 {
+		return ""
+	}
+
+	return i18n.Tf(": did you mean '%v'?", strings.Join(suggestions, "', '"))
 }
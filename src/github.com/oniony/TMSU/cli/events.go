@@ -0,0 +1,203 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/common/units"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage"
+	"os"
+	"strconv"
+	"time"
+)
+
+var EventsCommand = Command{
+	Name:     "events",
+	Synopsis: "Stream tagging changes",
+	Usages:   []string{"tmsu events [OPTION]..."},
+	Description: `Lists the changes to the tagging of files as JSON, one event per line, so that other programs can react to them.
+
+Each event has the time of the change, the kind of event ('tag' or 'untag'), the absolute path of the file and the tag and value names. Moving a file, whether with 'repair' or otherwise, is reported as the file being untagged at its old path and tagged at its new. Renaming a tag or value is likewise reported as the old name being removed and the new added.
+
+Only the most recent change to each file tag is kept, so a tag that is applied and then removed before the events are read is reported only as removed.
+
+With --follow the command waits for and reports further changes until it is interrupted, listing only those made after it was started unless --since is also specified.`,
+	Examples: []string{`$ tmsu events --since=2017-06-01
+{"time":"2017-06-14T09:31:20.113Z","event":"tag","path":"/home/bob/mountain.jpg","tag":"landscape"}
+{"time":"2017-06-14T09:31:20.113Z","event":"tag","path":"/home/bob/mountain.jpg","tag":"year","value":"2017"}`,
+		"$ tmsu events --follow"},
+	Options: Options{Option{"--follow", "-f", "wait for and report further changes", false, ""},
+		Option{"--since", "-s", "report only changes made since the date and time specified, e.g. YYYY-MM-DD [HH:MM:SS] or '2 days ago'", true, ""},
+		Option{"--interval", "-i", "the number of seconds between checks for changes when following (default 1)", true, ""}},
+	Exec: eventsExec,
+}
+
+// unexported
+
+const defaultEventsInterval = time.Second
+
+type event struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"`
+	Path  string    `json:"path"`
+	Tag   string    `json:"tag"`
+	Value string    `json:"value,omitempty"`
+}
+
+func eventsExec(options Options, args []string, databasePath string) (error, warnings) {
+	if len(args) > 0 {
+		return fmt.Errorf("too many arguments"), nil
+	}
+
+	follow := options.HasOption("--follow")
+
+	var since time.Time
+	if options.HasOption("--since") {
+		var err error
+		since, err = units.ParseTime(options.Get("--since").Argument)
+		if err != nil {
+			return err, nil
+		}
+	} else if follow {
+		since = time.Now()
+	}
+
+	interval := defaultEventsInterval
+	if options.HasOption("--interval") {
+		seconds, err := strconv.ParseFloat(options.Get("--interval").Argument, 64)
+		if err != nil || seconds <= 0 {
+			return fmt.Errorf("invalid interval '%v': must be a positive number of seconds", options.Get("--interval").Argument), nil
+		}
+
+		interval = time.Duration(seconds * float64(time.Second))
+	}
+
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	follower := newEventFollower(store, since)
+
+	if err := follower.report(); err != nil {
+		return err, nil
+	}
+
+	for follow {
+		select {
+		case <-commandContext.Done():
+			return nil, nil
+		case <-time.After(interval):
+		}
+
+		if !follower.databaseChanged() {
+			continue
+		}
+
+		if err := follower.report(); err != nil {
+			return err, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Reports the changes made since the last report.
+type eventFollower struct {
+	store    *storage.Storage
+	since    time.Time
+	reported map[entities.FileTagChangeKey]time.Time
+	modTimes map[string]time.Time
+}
+
+func newEventFollower(store *storage.Storage, since time.Time) *eventFollower {
+	follower := &eventFollower{store, since, make(map[entities.FileTagChangeKey]time.Time), make(map[string]time.Time)}
+	follower.databaseChanged()
+
+	return follower
+}
+
+// Determines whether the database, or its write-ahead log, has been modified
+// since this was last called.
+func (follower *eventFollower) databaseChanged() bool {
+	changed := false
+
+	for _, path := range []string{follower.store.DbPath, follower.store.DbPath + "-wal"} {
+		var modTime time.Time
+		if stat, err := os.Stat(path); err == nil {
+			modTime = stat.ModTime()
+		}
+
+		if !modTime.Equal(follower.modTimes[path]) {
+			follower.modTimes[path] = modTime
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+func (follower *eventFollower) report() error {
+	tx, err := follower.store.BeginRead()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+
+	log.Debugf("retrieving changes since %v", follower.since)
+
+	changes, err := follower.store.FileTagChangesSince(tx, follower.since)
+	if err != nil {
+		return fmt.Errorf("could not retrieve changes: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false)
+	for _, change := range changes {
+		// changes at the boundary are retrieved again by the next report
+		key := change.Key()
+		if changedAt, found := follower.reported[key]; found && changedAt.Equal(change.ChangedAt) {
+			continue
+		}
+		follower.reported[key] = change.ChangedAt
+
+		eventName := "tag"
+		if change.Removed {
+			eventName = "untag"
+		}
+
+		path := follower.store.FileTagChangePath(*change)
+		if err := encoder.Encode(event{change.ChangedAt, eventName, path, change.Tag, change.Value}); err != nil {
+			return fmt.Errorf("could not write event: %v", err)
+		}
+
+		if change.ChangedAt.After(follower.since) {
+			follower.since = change.ChangedAt
+		}
+	}
+
+	for key, changedAt := range follower.reported {
+		if changedAt.Before(follower.since) {
+			delete(follower.reported, key)
+		}
+	}
+
+	return nil
+}
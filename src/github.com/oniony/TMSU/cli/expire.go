@@ -0,0 +1,220 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/common/terminal"
+	"github.com/oniony/TMSU/common/units"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage"
+	"time"
+)
+
+var ExpireCommand = Command{
+	Name:     "expire",
+	Synopsis: "Remove tags that have expired",
+	Usages:   []string{"tmsu expire [OPTION]..."},
+	Description: `Removes the tags that were applied with an expiry, using 'tag --until', once the expiry has passed, reporting each as it is removed.
+
+With --convert the files are instead tagged with TAG, which may be given a value using the TAG=VALUE syntax, in place of each expired tag. This may be used to flag files that are overdue for attention, for example.
+
+Expiry is not automatic: run this subcommand periodically, for example from cron, to remove expired tags.
+
+With --list the expiry of each tag applied with one is listed, soonest first, without removing any. Use --at to expire, or list, the tags as of a DATE other than now, e.g. 'in 2 weeks', and --pretend to report what would be removed without changing the database.`,
+	Examples: []string{`$ tmsu tag --until=2025-01-01 report.pdf review-by
+$ tmsu expire
+report.pdf: removed review-by`,
+		`$ tmsu expire --convert=overdue
+report.pdf: converted review-by to overdue`,
+		`$ tmsu expire --list
+2025-01-01 00:00:00  report.pdf: review-by`},
+	Options: Options{{"--convert", "-c", "apply TAG in place of the expired tags", true, ""},
+		{"--list", "-l", "list the expiries without removing any tags", false, ""},
+		{"--at", "", "expire the tags as of DATE rather than now", true, ""},
+		{"--pretend", "-P", "do not make any changes", false, ""}},
+	Exec: expireExec,
+}
+
+// unexported
+
+const expiryTimeFormat = "2006-01-02 15:04:05"
+
+func expireExec(options Options, args []string, databasePath string) (error, warnings) {
+	if len(args) > 0 {
+		return fmt.Errorf("too many arguments"), nil
+	}
+
+	at := time.Now()
+	if options.HasOption("--at") {
+		var err error
+		at, err = units.ParseTime(options.Get("--at").Argument)
+		if err != nil {
+			return err, nil
+		}
+	}
+
+	convertArg := ""
+	if options.HasOption("--convert") {
+		convertArg = options.Get("--convert").Argument
+	}
+
+	pretend := options.HasOption("--pretend")
+
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	tx, err := store.Begin()
+	if err != nil {
+		return err, nil
+	}
+
+	if options.HasOption("--list") {
+		defer tx.Commit()
+
+		return listExpiries(store, tx, at), nil
+	}
+
+	err, warnings := expire(store, tx, at, convertArg, pretend)
+	if err != nil {
+		tx.Rollback()
+		return err, warnings
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err, warnings
+	}
+
+	return nil, warnings
+}
+
+// Lists the expiry of each file tag that has one, marking those that have
+// expired by the time specified.
+func listExpiries(store *storage.Storage, tx *storage.Tx, at time.Time) error {
+	expiries, err := store.FileTagExpiries(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tag expiries: %v", err)
+	}
+
+	table := terminal.NewTable()
+	for _, expiry := range expiries {
+		file, tagging, err := describeExpiry(store, tx, expiry)
+		if err != nil {
+			return err
+		}
+
+		state := ""
+		if expiry.ExpiredAt(at) {
+			state = "(expired)"
+		}
+
+		table.AddRow(expiry.ExpiresAt.Local().Format(expiryTimeFormat), file.Path()+": "+tagging, state)
+	}
+	table.Print()
+
+	return nil
+}
+
+// Removes, or converts, the file tags that have expired by the time specified.
+func expire(store *storage.Storage, tx *storage.Tx, at time.Time, convertArg string, pretend bool) (error, warnings) {
+	warnings := make(warnings, 0, 10)
+
+	log.Debug("identifying expired tags")
+
+	expiries, err := store.FileTagExpiries(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tag expiries: %v", err), warnings
+	}
+
+	expired := expiries.ExpiredAt(at)
+	if len(expired) == 0 {
+		return nil, warnings
+	}
+
+	var replacement *entities.TagIdValueIdPair
+	if convertArg != "" && !pretend {
+		settings, err := store.Settings(tx)
+		if err != nil {
+			return err, warnings
+		}
+
+		var pairs entities.TagIdValueIdPairs
+		pairs, warnings, err = parseTagValuePairs(store, tx, settings, []string{convertArg}, warnings)
+		if err != nil {
+			return err, warnings
+		}
+		if len(pairs) == 0 {
+			return nil, warnings
+		}
+
+		replacement = &pairs[0]
+	}
+
+	for _, expiry := range expired {
+		file, tagging, err := describeExpiry(store, tx, expiry)
+		if err != nil {
+			return err, warnings
+		}
+
+		if replacement != nil && replacement.TagId == expiry.TagId && replacement.ValueId == expiry.ValueId {
+			return fmt.Errorf("%v: cannot convert %v to itself", file.Path(), tagging), warnings
+		}
+
+		switch {
+		case pretend:
+			fmt.Printf("%v: %v has expired\n", file.Path(), tagging)
+			continue
+		case replacement != nil:
+			fmt.Printf("%v: converted %v to %v\n", file.Path(), tagging, convertArg)
+
+			// the replacement is applied first so that the file is not
+			// removed from the database for want of tags
+			if _, err := store.AddFileTag(tx, file.Id, replacement.TagId, replacement.ValueId); err != nil {
+				return fmt.Errorf("%v: could not apply %v: %v", file.Path(), convertArg, err), warnings
+			}
+		default:
+			fmt.Printf("%v: removed %v\n", file.Path(), tagging)
+		}
+
+		if err := store.DeleteFileTag(tx, file.Id, expiry.TagId, expiry.ValueId); err != nil {
+			return fmt.Errorf("%v: could not remove %v: %v", file.Path(), tagging, err), warnings
+		}
+	}
+
+	return nil, warnings
+}
+
+// Looks up the file and the TAG=VALUE form of the file tag that expires.
+func describeExpiry(store *storage.Storage, tx *storage.Tx, expiry *entities.FileTagExpiry) (*entities.File, string, error) {
+	file, err := store.File(tx, expiry.FileId)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not retrieve file #%v: %v", expiry.FileId, err)
+	}
+	if file == nil {
+		return nil, "", fmt.Errorf("file #%v does not exist", expiry.FileId)
+	}
+
+	tagging, err := tagValueNameFor(store, tx, expiry.TagId, expiry.ValueId)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return file, tagging, nil
+}
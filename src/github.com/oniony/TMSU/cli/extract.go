@@ -0,0 +1,256 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/common/metadata"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var ExtractCommand = Command{
+	Name:     "extract",
+	Synopsis: "Tag files from their embedded metadata",
+	Usages:   []string{"tmsu extract [OPTION]... FILE..."},
+	Description: `Reads the metadata embedded within each FILE and applies it as value tags.
+
+The following metadata is read:
+
+  images     EXIF camera, artist, date and year (JPEG and TIFF)
+  audio      ID3 artist, album, title, genre and year
+  documents  PDF author, title and year
+
+Which metadata fields are applied, and as which tags, is determined by the 'metadataMapping' setting: a comma-separated list of FIELD:TAG pairs. Fields absent from the mapping are not applied. The mapping can be augmented or overridden for a single run with the --map option.
+
+The available fields are: album, artist, author, camera, date, genre, title and year.
+
+Values are subject to any tag definitions: see the 'tag-def' subcommand.`,
+	Examples: []string{"$ tmsu extract song.mp3",
+		"$ tmsu extract --recursive ~/Pictures",
+		"$ tmsu extract --map=camera:device --map=date:taken photo.jpg",
+		`$ tmsu extract --list song.mp3
+song.mp3: album=Pastel\ Blues artist=Nina\ Simone year=1965`,
+		"$ tmsu config metadataMapping=artist:artist,album:album,genre:genre"},
+	Options: Options{{"--recursive", "-r", "recursively extract from directory contents", false, ""},
+		{"--map", "-m", "map metadata FIELD to TAG (FIELD:TAG)", true, ""},
+		{"--list", "-l", "list the metadata without applying tags", false, ""},
+		{"--explicit", "-e", "explicitly apply tags even if they are already implied", false, ""}},
+	Exec: extractExec,
+}
+
+// unexported
+
+func extractExec(options Options, args []string, databasePath string) (error, warnings) {
+	if len(args) == 0 {
+		return fmt.Errorf("too few arguments"), nil
+	}
+
+	recursive := options.HasOption("--recursive")
+	list := options.HasOption("--list")
+	explicit := options.HasOption("--explicit")
+
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	tx, err := store.Begin()
+	if err != nil {
+		return err, nil
+	}
+	defer tx.Commit()
+
+	log.Debugf("loading settings")
+
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return err, nil
+	}
+
+	mapping, err := parseMetadataMapping(settings.MetadataMapping())
+	if err != nil {
+		return fmt.Errorf("invalid 'metadataMapping' setting: %v", err), nil
+	}
+
+	for _, option := range options {
+		if option.LongName != "--map" {
+			continue
+		}
+
+		overrides, err := parseMetadataMapping(option.Argument)
+		if err != nil {
+			return err, nil
+		}
+
+		for field, tagName := range overrides {
+			mapping[field] = tagName
+		}
+	}
+
+	warnings := make(warnings, 0, 10)
+
+	for _, root := range args {
+		err := filepath.Walk(root, func(path string, stat os.FileInfo, err error) error {
+			if err != nil {
+				warnings = append(warnings, fmt.Sprintf("%v: %v", path, err))
+				return nil
+			}
+
+			if path != root && stat.Name()[0] == '.' {
+				log.Debugf("%v: skipping hidden file/directory", path)
+
+				if stat.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if stat.IsDir() {
+				if !recursive {
+					warnings = append(warnings, fmt.Sprintf("%v: is a directory", path))
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+
+			var fileWarnings []string
+			if list {
+				fileWarnings = listMetadata(path)
+			} else {
+				fileWarnings, err = extractMetadata(store, tx, settings, path, mapping, explicit)
+				if err != nil {
+					return err
+				}
+			}
+
+			warnings = append(warnings, fileWarnings...)
+
+			return nil
+		})
+		if err != nil {
+			return err, warnings
+		}
+	}
+
+	return nil, warnings
+}
+
+func listMetadata(path string) warnings {
+	fields, err := metadata.Extract(path)
+	if err != nil {
+		return warnings{fmt.Sprintf("%v: could not read metadata: %v", path, err)}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	formatted := make([]string, len(names))
+	for index, name := range names {
+		formatted[index] = formatTagValueName(name, fields[name], "", false, false, false)
+	}
+
+	fmt.Printf("%v: %v\n", escape(path, ':'), strings.Join(formatted, " "))
+
+	return nil
+}
+
+func extractMetadata(store *storage.Storage, tx *storage.Tx, settings entities.Settings, path string, mapping map[string]string, explicit bool) (warnings, error) {
+	log.Debugf("%v: extracting metadata", path)
+
+	fields, err := metadata.Extract(path)
+	if err != nil {
+		return warnings{fmt.Sprintf("%v: could not read metadata: %v", path, err)}, nil
+	}
+
+	fieldNames := make([]string, 0, len(fields))
+	for fieldName := range fields {
+		fieldNames = append(fieldNames, fieldName)
+	}
+	sort.Strings(fieldNames)
+
+	warnings := make(warnings, 0, 10)
+	pairs := make(entities.TagIdValueIdPairs, 0, len(fields))
+
+	for _, fieldName := range fieldNames {
+		tagName, mapped := mapping[fieldName]
+		if !mapped || tagName == "" {
+			continue
+		}
+
+		tagArg := escape(tagName, '\\', '=') + "=" + escape(fields[fieldName], '\\', '=')
+
+		var fieldPairs entities.TagIdValueIdPairs
+		fieldPairs, warnings, err = parseTagValuePairs(store, tx, settings, []string{tagArg}, warnings)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%v: %v", path, err))
+			continue
+		}
+
+		pairs = append(pairs, fieldPairs...)
+	}
+
+	if len(pairs) == 0 {
+		log.Debugf("%v: no metadata to apply", path)
+		return warnings, nil
+	}
+
+	if err := tagPath(store, tx, path, pairs, explicit, false, false, false, symlinkFollow, make(directoryGuard), nil, false, newFingerprinter(settings, 1), nil, settings.ReportDuplicates(), settings.PropagateDuplicateTags()); err != nil {
+		switch {
+		case os.IsPermission(err):
+			warnings = append(warnings, fmt.Sprintf("%v: permission denied", path))
+		case os.IsNotExist(err):
+			warnings = append(warnings, fmt.Sprintf("%v: no such file", path))
+		default:
+			return warnings, err
+		}
+	}
+
+	return warnings, nil
+}
+
+func parseMetadataMapping(text string) (map[string]string, error) {
+	mapping := make(map[string]string)
+
+	for _, pair := range strings.Split(text, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid mapping '%v': expected FIELD:TAG", pair)
+		}
+
+		mapping[parts[0]] = parts[1]
+	}
+
+	return mapping, nil
+}
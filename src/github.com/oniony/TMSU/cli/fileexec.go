@@ -0,0 +1,294 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// unexported
+
+// Runs a command for the files listed, in the manner of find's -exec: each
+// '{}' in the command's arguments is replaced by the path of the file, or the
+// path is appended if there is no such placeholder. The command is run
+// directly rather than by a shell so that paths need no quoting.
+type fileExecutor struct {
+	words []string
+	batch bool
+	jobs  uint
+	paths []string
+}
+
+// The longest combined length of the paths passed to a single command in a
+// batch, kept well below the operating system's limit on argument length.
+const maxExecBatchLength = 65536
+
+// Parses the command of --exec or --exec-batch. A trailing ';' argument is
+// ignored whilst a trailing '+' runs the command in batches, as with find.
+func newFileExecutor(command string, batch bool, jobs uint) (*fileExecutor, error) {
+	words, err := splitCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(words) > 0 {
+		switch words[len(words)-1] {
+		case ";":
+			words = words[:len(words)-1]
+		case "+":
+			words = words[:len(words)-1]
+			batch = true
+		}
+	}
+
+	if len(words) == 0 {
+		return nil, fmt.Errorf("no command specified")
+	}
+
+	return &fileExecutor{words, batch, jobs, make([]string, 0, 100)}, nil
+}
+
+func (executor *fileExecutor) add(path string) {
+	executor.paths = append(executor.paths, path)
+}
+
+// Runs the command for the files added, reporting each command that fails.
+func (executor *fileExecutor) run() warnings {
+	commands := executor.commands()
+
+	log.Debugf("running %v command(s) using up to %v jobs", len(commands), executor.jobs)
+
+	if executor.jobs < 2 || len(commands) < 2 {
+		warnings := make(warnings, 0, 10)
+		for _, command := range commands {
+			if err := runFileCommand(command, os.Stdin, os.Stdout, os.Stderr); err != nil {
+				warnings = append(warnings, err.Error())
+			}
+		}
+
+		return warnings
+	}
+
+	// the output of each command is buffered so that commands running at
+	// the same time do not interleave their output
+	var lock sync.Mutex
+	var waitGroup sync.WaitGroup
+	failures := make([]error, len(commands))
+	indices := make(chan int)
+
+	for job := uint(0); job < executor.jobs && job < uint(len(commands)); job++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+
+			for index := range indices {
+				var stdout, stderr bytes.Buffer
+				failures[index] = runFileCommand(commands[index], nil, &stdout, &stderr)
+
+				lock.Lock()
+				os.Stdout.Write(stdout.Bytes())
+				os.Stderr.Write(stderr.Bytes())
+				lock.Unlock()
+			}
+		}()
+	}
+
+	for index := range commands {
+		indices <- index
+	}
+	close(indices)
+	waitGroup.Wait()
+
+	warnings := make(warnings, 0, 10)
+	for _, err := range failures {
+		if err != nil {
+			warnings = append(warnings, err.Error())
+		}
+	}
+
+	return warnings
+}
+
+// The command lines to run: one per file or, in a batch, as few as the
+// argument length allows.
+func (executor *fileExecutor) commands() [][]string {
+	if !executor.batch {
+		commands := make([][]string, len(executor.paths))
+		for index, path := range executor.paths {
+			commands[index] = executor.commandFor([]string{path})
+		}
+
+		return commands
+	}
+
+	commands := make([][]string, 0, 1)
+	start, length := 0, 0
+	for index, path := range executor.paths {
+		if index > start && length+len(path)+1 > maxExecBatchLength {
+			commands = append(commands, executor.commandFor(executor.paths[start:index]))
+			start, length = index, 0
+		}
+
+		length += len(path) + 1
+	}
+	if start < len(executor.paths) {
+		commands = append(commands, executor.commandFor(executor.paths[start:]))
+	}
+
+	return commands
+}
+
+// The command line for the paths. In a batch an argument that is exactly a
+// placeholder is replaced by all of the paths.
+func (executor *fileExecutor) commandFor(paths []string) []string {
+	command := make([]string, 0, len(executor.words)+len(paths))
+	substituted := false
+
+	for index, word := range executor.words {
+		if index == 0 || !strings.Contains(word, "{") {
+			command = append(command, word)
+			continue
+		}
+
+		if len(paths) == 1 {
+			replaced := replacePlaceholders(word, paths[0])
+			substituted = substituted || replaced != word
+			command = append(command, replaced)
+			continue
+		}
+
+		if isPlaceholder(word) {
+			for _, path := range paths {
+				command = append(command, replacePlaceholders(word, path))
+			}
+			substituted = true
+			continue
+		}
+
+		command = append(command, word)
+	}
+
+	if !substituted {
+		command = append(command, paths...)
+	}
+
+	return command
+}
+
+var placeholders = []string{"{}", "{/}", "{//}", "{.}", "{/.}"}
+
+func isPlaceholder(word string) bool {
+	for _, placeholder := range placeholders {
+		if word == placeholder {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Replaces the placeholders in the word:
+//
+//	{}    the path
+//	{/}   the file name
+//	{//}  the parent directory
+//	{.}   the path without its extension
+//	{/.}  the file name without its extension
+func replacePlaceholders(word, path string) string {
+	name := filepath.Base(path)
+
+	replacer := strings.NewReplacer("{}", path,
+		"{/}", name,
+		"{//}", filepath.Dir(path),
+		"{.}", strings.TrimSuffix(path, filepath.Ext(path)),
+		"{/.}", strings.TrimSuffix(name, filepath.Ext(name)))
+
+	return replacer.Replace(word)
+}
+
+func runFileCommand(command []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	log.Debugf("running '%v'", strings.Join(command, " "))
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("'%v' failed: %v", strings.Join(command, " "), err)
+	}
+
+	return nil
+}
+
+// Splits a command into its arguments at whitespace. Single quotation marks
+// preserve the text they enclose, as do double quotation marks other than for
+// backslash escapes, and a backslash escapes the character that follows.
+func splitCommand(command string) ([]string, error) {
+	words := make([]string, 0, 5)
+	word := new(bytes.Buffer)
+	inWord := false
+	quote := rune(0)
+	runes := []rune(command)
+
+	for index := 0; index < len(runes); index++ {
+		r := runes[index]
+
+		switch {
+		case quote == '\'' && r == '\'', quote == '"' && r == '"':
+			quote = 0
+		case quote == '\'':
+			word.WriteRune(r)
+		case r == '\\':
+			index++
+			if index < len(runes) {
+				word.WriteRune(runes[index])
+			}
+			inWord = true
+		case quote == '"':
+			word.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quotation in command '%v'", command)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
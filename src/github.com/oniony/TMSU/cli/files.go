@@ -16,13 +16,20 @@
 package cli
 
 import (
+	"bufio"
+	"encoding/csv"
 	"fmt"
 	"github.com/oniony/TMSU/common/log"
-	"github.com/oniony/TMSU/common/path"
+	_path "github.com/oniony/TMSU/common/path"
+	"github.com/oniony/TMSU/common/terminal"
 	"github.com/oniony/TMSU/entities"
 	"github.com/oniony/TMSU/query"
 	"github.com/oniony/TMSU/storage"
+	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -33,11 +40,74 @@ var FilesCommand = Command{
 	Usages:   []string{"tmsu files [OPTION]... [QUERY]"},
 	Description: `Lists the files in the database that match the QUERY specified. If no query is specified, all files in the database are listed.
 
-QUERY may contain tag names to match, operators and parentheses. Operators are: and or not == != < > <= >= eq ne lt gt le ge.
+QUERY may contain tag names to match, operators and parentheses. Operators are: and or not == != < > <= >= ~ eq ne lt gt le ge.
+
+The '~' operator matches a tag's values against a regular expression, e.g. 'genre ~ "^(rock|jazz)$"'. Within double quotation marks whitespace, operators and parentheses need not be escaped.
+
+A file may have several values for the same tag, e.g. 'actor=smith actor=jones', in which case a comparison matches if any of the values satisfies it. The special value '*' matches any value, e.g. 'actor=*' matches the files having at least one value for 'actor', and 'actor!=*' those with none.
+
+A negated comparison matches every file not satisfying the comparison, including those that are not tagged with the tag at all: 'month != June' matches the files without the 'month' tag, those tagged 'month' without a value and those with only other values. The postfix operator 'without value' matches the files tagged with a tag but not with any value for it, e.g. 'month without value', so that each of these may be distinguished:
+
+  not month                  not tagged 'month'
+  month without value        tagged 'month' without a value
+  month=* and month != June  tagged 'month' with values other than 'June'
+
+The following built-in pseudo-tags, when compared, refer to the attributes of the file recorded when it was last tagged or repaired:
+
+  name   the file name, which '~' matches against a glob pattern
+  ext    the file name extension, e.g. 'ext = pdf'
+  size   the file size in bytes, with an optional K, M, G or T unit,
+         e.g. 'size > 1.5G'
+  mtime  the modification time, e.g. 'mtime > 2023-01-01' or
+         'mtime < 2023-01-01T09:30', or relative to now in seconds,
+         minutes, hours, days, weeks, months or years, e.g.
+         'mtime > now-7d', 'mtime > "2 weeks ago"' or 'mtime > yesterday'
+
+The term 'related-to:PATH' matches the files related, in either direction, to the file at PATH, e.g. 'jpeg and related-to:IMG_0001.cr2'. (See the 'relate' subcommand.)
+
+Macros defined by the 'queryMacros' setting are called as NAME(ARGUMENT...), the arguments separated by commas, e.g. with 'recent(n) = mtime > now-${n}d' defined the query 'music and recent(7)' matches the music modified in the last week. (See the 'config' subcommand.)
 
 Queries are run against the database so the results may not reflect the current state of the filesystem. Only tagged files are matched: to identify untagged files use the 'untagged' subcommand.
 
-Note: If your tag or value name contains whitespace, operators (e.g. '<') or parentheses ('(' or ')'), these must be escaped with a backslash '\', e.g. '\<tag\>' matches the tag name '<tag>'. Your shell, however, may use some punctuation for its own purposes: this can normally be avoided by enclosing the query in single quotation marks or by escaping the problem characters with a backslash.`,
+The files are listed in the order given by --sort: by 'id', 'name', 'size' or modification 'time', not at all with 'none', or by the value of a tag with 'value:TAG'. Values are ordered according to the tag's type (see the 'tag-def' subcommand) or, for an untyped tag, numerically if they are numbers; files without a value for the tag are listed last. --desc reverses the order.
+
+With --failing-verification only those files whose content did not match their fingerprint when last verified are listed, so that corrupt files remain visible until they are restored or their new fingerprint is accepted.
+
+With --offline only those files that are not currently present are listed, for example those on a detached drive, along with those tagged by fingerprint (see 'tag --fingerprint') which are listed as ALGORITHM:FINGERPRINT. Queries against files tagged by fingerprint may use tags and values but not the file attribute pseudo-tags.
+
+With --volume each file on a volume is prefixed with the name of the volume. (See the 'volume' subcommand.)
+
+With --tagged-by only those files having at least one tag applied by the specified user are listed. (See the global --user option.)
+
+With --long each file is listed along with its size, its modification time and its tags, aligned in columns. The tags are coloured, where the output is coloured, by the colours given to them with the 'tag-info' subcommand, or to show those that are implied (see the 'tags' subcommand). As the columns are aligned the files are listed only once all have been found.
+
+--columns, which implies --long unless --format=csv is given, selects the columns as a comma-separated list of:
+
+  size       the file size in bytes
+  time       the modification time
+  count      the number of tags the file has
+  tags       the file's tags
+  value:TAG  the file's values for TAG
+  path       the path, which is otherwise listed last
+  database   the path of the database the file is from (see --db)
+
+The default is 'size,time,path,tags'.
+
+--format writes the files in another format:
+
+  m3u  an M3U playlist, e.g. for a music player
+  csv  comma-separated values with a header row, for a spreadsheet, with
+       the columns selected by --columns ('path,size,time,tags' by default)
+
+With --db the query is run against the database at PATH rather than the current database. --db may be given more than once, for example to search the databases kept on several drives at once: the results of each database are listed in turn, each file prefixed with its database or, in a long listing or CSV, with a 'database' column first. A database that cannot be opened, such as that of a detached drive, is reported and the others are still searched. With --count the total number of matching files is printed.
+
+With --exec the COMMAND is run for each of the files instead of listing them, in the manner of find's -exec. Each '{}' in the COMMAND is replaced by the file's path, or the path is appended if there is none, and the command is run directly rather than by the shell so the paths need no quoting. A trailing ';' is ignored. '{/}' is replaced by the file name, '{//}' by the parent directory, '{.}' by the path without its extension and '{/.}' by the file name without its extension. With --exec-batch, or a trailing '+', the COMMAND is instead run once with all of the paths, or as few times as the length of the paths allows. --jobs runs up to N commands at once, their output kept apart. A command that fails is reported once all have run. The commands are run once the query has finished so that they can themselves use TMSU.
+
+With --count only the number of matching files is printed. Unless the files must be examined individually, as with --tagged-by, --failing-verification or --offline, they are counted by the database without being retrieved, which is much quicker for queries matching many files. --directory and --file restrict the files listed or counted to directories or to files that are not directories respectively.
+
+With --explain the files are not listed: instead the SQL the query is translated to is shown along with the plan by which SQLite runs it and the time taken to retrieve the matching files. This may help to understand, or to report, a slow query.
+
+Note: If your tag or value name contains whitespace, operators (e.g. '<') or parentheses ('(' or ')'), these must be escaped with a backslash '\', e.g. '\<tag\>' matches the tag name '<tag>', or the name enclosed in double quotation marks, e.g. '"<tag>"'. Your shell, however, may use some punctuation for its own purposes: this can normally be avoided by enclosing the query in single quotation marks or by escaping the problem characters with a backslash.`,
 	Examples: []string{"$ tmsu files music mp3  # files with both 'music' and 'mp3'",
 		"$ tmsu files music and mp3  # same query but with explicit 'and'",
 		"$ tmsu files music and not mp3",
@@ -45,18 +115,54 @@ Note: If your tag or value name contains whitespace, operators (e.g. '<') or par
 		`$ tmsu files "year == 2017"`,
 		`$ tmsu files "year < 2017"`,
 		`$ tmsu files year lt 2017`,
+		`$ tmsu files 'genre ~ "^(rock|jazz)$"'`,
+		`$ tmsu files 'music and name ~ "*.flac"'`,
+		`$ tmsu files 'year without value'`,
+		`$ tmsu files 'size > 10M and mtime > 2023-01-01'`,
+		`$ tmsu files 'music and not ext = mp3'`,
 		`$ tmsu files year`,
+		`$ tmsu files "rating >= 4" --sort=value:rating --desc`,
+		`$ tmsu files --count --file music`,
 		`$ tmsu files --path=/home/bob music`,
+		`$ tmsu files --print0 --absolute music | xargs -0 mpv`,
+		`$ tmsu files --relative-to=/home/bob music`,
+		`$ tmsu files --tagged-by=alice music`,
+		`$ tmsu files --offline archive`,
+		`$ tmsu files --volume holiday`,
+		`$ tmsu files --db=/mnt/photos/.tmsu/db --db=/mnt/backup/.tmsu/db holiday`,
+		"$ tmsu files --long music\n 4096  2018-03-15 09:30  albums           music\n 5433  2018-03-16 18:02  albums/song.mp3  mp3 music",
+		"$ tmsu files --columns=count,value:year,path music\n1        albums\n3  2017  albums/song.mp3",
+		`$ tmsu files --format=m3u --absolute 'genre = jazz' >jazz.m3u`,
+		`$ tmsu files --format=csv --columns=path,value:artist,value:year music >music.csv`,
+		`$ tmsu files --exec 'mpv {} ;' 'genre = jazz'`,
+		`$ tmsu files --exec 'convert {} {.}.png' --jobs=4 'ext = gif'`,
+		`$ tmsu files --exec-batch 'tar -czf holiday.tar.gz' holiday`,
+		`$ tmsu files --explain 'music and year > 2000'`,
 		`$ tmsu files 'contains\=equals'`,
 		`$ tmsu files '\<tag\>'`},
 	Options: Options{{"--directory", "-d", "list only items that are directories", false, ""},
 		{"--file", "-f", "list only items that are files", false, ""},
 		{"--print0", "-0", "delimit files with a NUL character rather than newline.", false, ""},
+		{"--absolute", "-a", "list absolute paths", false, ""},
+		{"--relative-to", "", "list paths relative to DIR rather than the working directory", true, ""},
 		{"--count", "-c", "lists the number of files rather than their names", false, ""},
 		{"--path", "-p", "list only items under PATH", true, ""},
 		{"--explicit", "-e", "list only explicitly tagged files", false, ""},
-		{"--sort", "-s", "sort output: id, none, name, size, time", true, ""},
-		{"--ignore-case", "-i", "ignore the case of tag and value names", false, ""}},
+		{"--sort", "-s", "sort output: id, none, name, size, time, value:TAG", true, ""},
+		{"--desc", "", "sort in descending order", false, ""},
+		{"--ignore-case", "-i", "ignore the case of tag and value names", false, ""},
+		{"--failing-verification", "", "list only files that failed their last verification", false, ""},
+		{"--tagged-by", "", "list only files tagged by the specified USER", true, ""},
+		{"--offline", "", "list only files not currently present, including those tagged by fingerprint", false, ""},
+		{"--volume", "", "show the volume each file is on", false, ""},
+		{"--long", "-l", "list each file with its size, modification time and tags", false, ""},
+		{"--columns", "", "the columns of the long listing or CSV: size, time, count, tags, value:TAG, path, database", true, ""},
+		{"--format", "", "write the files as an m3u playlist or csv", true, ""},
+		{"--explain", "", "show the SQL, query plan and timing of the query instead of the files", false, ""},
+		{"--exec", "-x", "run COMMAND for each file, replacing '{}' with its path", true, ""},
+		{"--exec-batch", "-X", "run COMMAND once with all of the files", true, ""},
+		{"--jobs", "-j", "run up to N commands at once with --exec (default: 1)", true, ""},
+		{"--db", "", "query the database at PATH instead, which may be given more than once", true, ""}},
 	Exec: filesExec,
 }
 
@@ -70,50 +176,240 @@ func filesExec(options Options, args []string, databasePath string) (error, warn
 	hasPath := options.HasOption("--path")
 	explicitOnly := options.HasOption("--explicit")
 	ignoreCase := options.HasOption("--ignore-case")
+	failingVerification := options.HasOption("--failing-verification")
+	offline := options.HasOption("--offline")
+	showVolume := options.HasOption("--volume")
+	long := options.HasOption("--long")
 
-	sort := "name"
-	if options.HasOption("--sort") {
-		sort = options.Get("--sort").Argument
+	taggedBy := ""
+	if options.HasOption("--tagged-by") {
+		taggedBy = options.Get("--tagged-by").Argument
+	}
+
+	format, err := pathFormat(options)
+	if err != nil {
+		return err, nil
+	}
+
+	colour, err := useColour(options)
+	if err != nil {
+		return err, nil
+	}
+
+	output := ""
+	if long {
+		output = "long"
+	}
+
+	columns := defaultFileColumns
+	if options.HasOption("--format") {
+		output = options.Get("--format").Argument
+
+		switch output {
+		case "m3u":
+		case "csv":
+			columns = defaultCsvColumns
+		default:
+			return fmt.Errorf("invalid format '%v': expected m3u or csv", output), nil
+		}
+
+		if long {
+			return fmt.Errorf("--format cannot be used with --long"), nil
+		}
+	}
+
+	if options.HasOption("--columns") {
+		switch output {
+		case "":
+			output = "long"
+		case "m3u":
+			return fmt.Errorf("--columns cannot be used with an M3U playlist"), nil
+		}
+
+		columns, err = parseFileColumns(options.Get("--columns").Argument)
+		if err != nil {
+			return err, nil
+		}
+	}
+
+	if output != "" && print0 {
+		return fmt.Errorf("--print0 cannot be used with --long or --format"), nil
+	}
+
+	var executor *fileExecutor
+	if options.HasOption("--exec") || options.HasOption("--exec-batch") {
+		switch {
+		case options.HasOption("--exec") && options.HasOption("--exec-batch"):
+			return fmt.Errorf("--exec and --exec-batch cannot be used together"), nil
+		case output != "" || print0 || showCount || options.HasOption("--explain"):
+			return fmt.Errorf("--exec cannot be used with --long, --format, --print0, --count or --explain"), nil
+		}
+
+		jobs := uint(1)
+		if options.HasOption("--jobs") {
+			jobs, err = parseJobs(options)
+			if err != nil {
+				return err, nil
+			}
+		}
+
+		if options.HasOption("--exec") {
+			executor, err = newFileExecutor(options.Get("--exec").Argument, false, jobs)
+		} else {
+			executor, err = newFileExecutor(options.Get("--exec-batch").Argument, true, jobs)
+		}
+		if err != nil {
+			return err, nil
+		}
+	} else if options.HasOption("--jobs") {
+		return fmt.Errorf("--jobs can only be used with --exec or --exec-batch"), nil
 	}
 
 	absPath := ""
 	if hasPath {
 		relPath := options.Get("--path").Argument
 
-		var err error
 		absPath, err = filepath.Abs(relPath)
 		if err != nil {
 			return fmt.Errorf("could not get absolute path of '%v': %v'", relPath, err), nil
 		}
 	}
 
-	store, err := openDatabase(databasePath)
-	if err != nil {
-		return err, nil
+	if options.HasOption("--sort") {
+		if err := validateFileSort(options.Get("--sort").Argument); err != nil {
+			return err, nil
+		}
 	}
-	defer store.Close()
 
-	tx, err := store.Begin()
-	if err != nil {
-		return err, nil
+	explain := options.HasOption("--explain")
+
+	databasePaths := []string{databasePath}
+	federated := options.HasOption("--db")
+	if federated {
+		databasePaths = make([]string, 0, options.Count("--db"))
+		for _, option := range options {
+			if option.LongName == "--db" {
+				databasePaths = append(databasePaths, option.Argument)
+			}
+		}
+
+		if explain && len(databasePaths) > 1 {
+			return fmt.Errorf("--explain cannot be used with more than one --db"), nil
+		}
+
+		if output != "" && !containsFileColumn(columns, "database") {
+			columns = append([]fileColumn{{"database", "", 0}}, columns...)
+		}
 	}
-	defer tx.Commit()
 
 	queryText := strings.Join(args, " ")
-	return listFilesForQuery(store, tx, queryText, absPath, dirOnly, fileOnly, print0, showCount, explicitOnly, ignoreCase, sort)
+
+	lister := newFileLister(print0, showCount, showVolume, federated)
+	lister.executor = executor
+
+	if !explain && !showCount {
+		switch output {
+		case "long":
+			lister.long(colour, columns)
+		case "csv":
+			if err := lister.csv(columns); err != nil {
+				return err, nil
+			}
+		case "m3u":
+			lister.playlist()
+		}
+	}
+
+	// each database's transaction is finished before any commands are run so
+	// that they can themselves use the database
+	listDatabase := func(databasePath string) (error, warnings) {
+		store, err := openDatabase(databasePath)
+		if err != nil {
+			if federated {
+				// e.g. the database of a detached drive
+				return nil, warnings{fmt.Sprintf("%v: %v", databasePath, err)}
+			}
+
+			return err, nil
+		}
+		defer store.Close()
+
+		tx, err := store.Begin()
+		if err != nil {
+			return err, nil
+		}
+		defer tx.Commit()
+
+		settings, err := store.Settings(tx)
+		if err != nil {
+			return fmt.Errorf("could not retrieve settings: %v", err), nil
+		}
+
+		sort := settings.DefaultSort()
+		if options.HasOption("--sort") {
+			sort = options.Get("--sort").Argument
+		}
+		if options.HasOption("--desc") {
+			sort = "-" + sort
+		}
+
+		ignoreCase := ignoreCase || settings.IgnoreCase()
+
+		if explain {
+			return explainQuery(store, tx, queryText, absPath, explicitOnly, ignoreCase, sort), nil
+		}
+
+		lister.use(store, tx, databasePath)
+
+		return listFilesForQuery(store, tx, lister, queryText, absPath, dirOnly, fileOnly, explicitOnly, ignoreCase, failingVerification, offline, colour, columns, taggedBy, sort, format)
+	}
+
+	warnings := make(warnings, 0, 10)
+	for _, databasePath := range databasePaths {
+		err, databaseWarnings := listDatabase(databasePath)
+		warnings = append(warnings, databaseWarnings...)
+		if err != nil {
+			if federated {
+				err = fmt.Errorf("%v: %v", databasePath, err)
+			}
+
+			return err, warnings
+		}
+	}
+
+	warnings = append(warnings, lister.unknownWarnings()...)
+
+	if explain {
+		return nil, warnings
+	}
+
+	if err := lister.close(); err != nil {
+		return err, warnings
+	}
+
+	if executor == nil {
+		return nil, warnings
+	}
+
+	return nil, append(warnings, executor.run()...)
 }
 
 // unexported
 
-func listFilesForQuery(store *storage.Storage, tx *storage.Tx, queryText, path string, dirOnly, fileOnly, print0, showCount, explicitOnly, ignoreCase bool, sort string) (error, warnings) {
-	log.Info(2, "parsing query")
+func listFilesForQuery(store *storage.Storage, tx *storage.Tx, lister *fileLister, queryText, path string, dirOnly, fileOnly, explicitOnly, ignoreCase, failingVerification, offline, colour bool, columns []fileColumn, taggedBy, sort string, format _path.Format) (error, warnings) {
+	log.Debug("parsing query")
+
+	expression, err := parseQuery(store, tx, queryText)
+	if err != nil {
+		return err, nil
+	}
 
-	expression, err := query.Parse(queryText)
+	expression, ignoreCase, err = store.NormalizeQuery(tx, expression, ignoreCase)
 	if err != nil {
-		return fmt.Errorf("could not parse query: %v", err), nil
+		return fmt.Errorf("could not normalize query: %v", err), nil
 	}
 
-	log.Info(2, "checking tag names")
+	log.Debug("checking tag names")
 
 	warnings := make(warnings, 0, 10)
 
@@ -130,7 +426,7 @@ func listFilesForQuery(store *storage.Storage, tx *storage.Tx, queryText, path s
 		}
 
 		if !tags.ContainsCasedName(tagName, ignoreCase) {
-			warnings = append(warnings, fmt.Sprintf("no such tag '%v'", tagName))
+			warnings = append(warnings, lister.noSuchTag("tag "+tagName, noSuchTagError(store, tx, tagName))...)
 			continue
 		}
 	}
@@ -148,14 +444,119 @@ func listFilesForQuery(store *storage.Storage, tx *storage.Tx, queryText, path s
 		}
 
 		if !values.ContainsCasedName(valueName, ignoreCase) {
-			warnings = append(warnings, fmt.Sprintf("no such value '%v'", valueName))
+			warnings = append(warnings, lister.noSuchTag("value "+valueName, NoSuchValueError{valueName})...)
 			continue
 		}
 	}
 
-	log.Info(2, "querying database")
+	var verifications entities.Verifications
+	if failingVerification {
+		log.Debug("retrieving verification states")
+
+		verifications, err = store.Verifications(tx)
+		if err != nil {
+			return fmt.Errorf("could not retrieve verification states: %v", err), warnings
+		}
+	}
+
+	var taggedFileIds map[entities.FileId]bool
+	if taggedBy != "" {
+		log.Debugf("retrieving files tagged by '%v'", taggedBy)
+
+		fileIds, err := store.FileIdsTaggedBy(tx, taggedBy)
+		if err != nil {
+			return fmt.Errorf("could not retrieve files tagged by '%v': %v", taggedBy, err), warnings
+		}
+
+		taggedFileIds = make(map[entities.FileId]bool, len(fileIds))
+		for _, fileId := range fileIds {
+			taggedFileIds[fileId] = true
+		}
+	}
+
+	include := func(file *entities.File) bool {
+		switch {
+		case fileOnly && file.IsDir, dirOnly && !file.IsDir:
+			return false
+		case failingVerification && !verifications.Failed(file.Id):
+			return false
+		case taggedBy != "" && !taggedFileIds[file.Id]:
+			return false
+		case offline:
+			_, err := os.Lstat(file.Path())
+			return os.IsNotExist(err)
+		}
+
+		return true
+	}
+
+	// the files need not be read when only the database's knowledge of them
+	// determines whether they are counted
+	if lister.showCount && !failingVerification && taggedBy == "" && !offline {
+		log.Debug("counting files")
+
+		count, err := store.FileCountForQuery(tx, expression, path, dirOnly, fileOnly, explicitOnly, ignoreCase)
+		if err != nil {
+			if strings.Index(err.Error(), "parser stack overflow") > -1 {
+				return fmt.Errorf("the query is too complex (see the troubleshooting wiki for how to increase the stack size)"), warnings
+			}
+
+			return fmt.Errorf("could not count files: %v", err), warnings
+		}
+
+		lister.count += count
+
+		return nil, warnings
+	}
+
+	log.Debug("querying database")
+
+	if lister.table != nil || lister.csvWriter != nil {
+		// the tags are identified afresh in each database
+		databaseColumns := make([]fileColumn, len(columns))
+		copy(databaseColumns, columns)
 
-	files, err := store.FilesForQuery(tx, expression, path, explicitOnly, ignoreCase, sort)
+		for index, column := range databaseColumns {
+			if column.name != "value" {
+				continue
+			}
+
+			tag, err := store.TagByName(tx, column.tagName)
+			if err != nil {
+				return fmt.Errorf("could not look up tag '%v': %v", column.tagName, err), warnings
+			}
+			if tag == nil {
+				if !lister.showDatabase {
+					return noSuchTagError(store, tx, column.tagName), warnings
+				}
+
+				// the column is left empty for this database's files
+				warnings = append(warnings, lister.noSuchTag("tag "+column.tagName, noSuchTagError(store, tx, column.tagName))...)
+				continue
+			}
+
+			databaseColumns[index].tagId = tag.Id
+		}
+
+		lister.columns = databaseColumns
+
+		if lister.table != nil {
+			infos, err := tagInfosForColour(store, tx, colour)
+			if err != nil {
+				return err, warnings
+			}
+
+			lister.infos = infos
+		}
+	}
+
+	err = store.EachFileForQuery(tx, expression, path, explicitOnly, ignoreCase, sort, func(file *entities.File) error {
+		if include(file) {
+			return lister.list(format.Path(file.Path()), file)
+		}
+
+		return nil
+	})
 	if err != nil {
 		if strings.Index(err.Error(), "parser stack overflow") > -1 {
 			return fmt.Errorf("the query is too complex (see the troubleshooting wiki for how to increase the stack size)"), warnings
@@ -164,42 +565,566 @@ func listFilesForQuery(store *storage.Storage, tx *storage.Tx, queryText, path s
 		return fmt.Errorf("could not query files: %v", err), warnings
 	}
 
-	if err = listFiles(tx, files, dirOnly, fileOnly, print0, showCount); err != nil {
-		return err, warnings
+	// files tagged by fingerprint have no path, author or verification
+	if offline && path == "" && !dirOnly && !failingVerification && taggedBy == "" {
+		log.Debug("identifying offline files")
+
+		fingerprints, err := fingerprintsForQuery(store, tx, expression, explicitOnly, ignoreCase)
+		if err != nil {
+			return err, warnings
+		}
+
+		for _, fingerprint := range fingerprints {
+			if err := lister.list(fingerprint, nil); err != nil {
+				return err, warnings
+			}
+		}
 	}
 
 	return nil, warnings
 }
 
-func listFiles(tx *storage.Tx, files entities.Files, dirOnly, fileOnly, print0, showCount bool) error {
-	relPaths := make([]string, 0, len(files))
-	for _, file := range files {
-		if fileOnly && file.IsDir {
+func explainQuery(store *storage.Storage, tx *storage.Tx, queryText, path string, explicitOnly, ignoreCase bool, sort string) error {
+	log.Debug("parsing query")
+
+	expression, err := parseQuery(store, tx, queryText)
+	if err != nil {
+		return err
+	}
+
+	log.Debug("explaining query")
+
+	explanation, err := store.ExplainQuery(tx, expression, path, explicitOnly, ignoreCase, sort)
+	if err != nil {
+		return fmt.Errorf("could not explain query: %v", err)
+	}
+
+	fmt.Println("SQL:")
+	fmt.Println(strings.TrimSpace(explanation.Sql))
+
+	if len(explanation.Params) > 0 {
+		fmt.Println()
+		fmt.Println("Parameters:")
+		for index, param := range explanation.Params {
+			if text, ok := param.(string); ok {
+				fmt.Printf("  ?%v = %v\n", index+1, strconv.Quote(text))
+			} else {
+				fmt.Printf("  ?%v = %v\n", index+1, param)
+			}
+		}
+	}
+
+	fmt.Println()
+	fmt.Println("Plan:")
+	for _, step := range explanation.Plan {
+		fmt.Printf("%v- %v\n", strings.Repeat("  ", explanation.Plan.Depth(step)+1), step.Detail)
+	}
+
+	fmt.Println()
+	fmt.Printf("Files: %v\n", explanation.FileCount)
+	fmt.Printf("Time: %v\n", explanation.Duration)
+
+	return nil
+}
+
+// Writes the listed files to standard output as they are found, or just counts
+// them. Output to a terminal is written line by line so that the first results
+// appear immediately whilst output to a pipe or file is buffered. Long listings
+// are written once every file is found so that their columns can be aligned.
+//
+// The files of several databases may be listed together, each database in turn
+// being put to use, in which case the plain listing prefixes each file with its
+// database. A tag or value need not then exist in every database, so only those
+// unknown to all of them are reported.
+type fileLister struct {
+	store        *storage.Storage
+	writer       *bufio.Writer
+	flushEach    bool
+	print0       bool
+	showCount    bool
+	showVolume   bool
+	showDatabase bool
+	count        uint
+	table        *terminal.Table
+	tx           *storage.Tx
+	database     string
+	colour       bool
+	infos        entities.TagInfos
+	columns      []fileColumn
+	timeFormat   string
+	csvWriter    *csv.Writer
+	m3u          bool
+	executor     *fileExecutor
+	databases    uint
+	unknown      map[string][]error
+	unknownNames []string
+}
+
+func newFileLister(print0, showCount, showVolume, showDatabase bool) *fileLister {
+	return &fileLister{nil, bufio.NewWriter(os.Stdout), stdoutIsCharDevice(), print0, showCount, showVolume, showDatabase, 0, nil, nil, "", false, nil, nil, "", nil, false, nil, 0, make(map[string][]error), nil}
+}
+
+// Lists the files of the database at the specified path from now on.
+func (lister *fileLister) use(store *storage.Storage, tx *storage.Tx, database string) {
+	lister.store = store
+	lister.tx = tx
+	lister.database = database
+	lister.databases++
+}
+
+// Reports a tag or value, identified by the key, that does not exist in the
+// database in use. When several databases are listed it is instead recorded
+// until all have been, it being reported by unknownWarnings should it prove
+// to exist in none of them.
+func (lister *fileLister) noSuchTag(key string, err error) warnings {
+	if !lister.showDatabase {
+		return warnings{noSuchTagWarning(err)}
+	}
+
+	errs, recorded := lister.unknown[key]
+	if !recorded {
+		lister.unknownNames = append(lister.unknownNames, key)
+	}
+	if uint(len(errs)) < lister.databases {
+		// once for each database, however often it is referred to
+		lister.unknown[key] = append(errs, err)
+	}
+
+	return nil
+}
+
+// Reports the tags and values that exist in none of the databases listed.
+func (lister *fileLister) unknownWarnings() warnings {
+	warnings := make(warnings, 0, len(lister.unknownNames))
+	for _, key := range lister.unknownNames {
+		errs := lister.unknown[key]
+		if uint(len(errs)) == lister.databases {
+			warnings = append(warnings, noSuchTagWarning(errs[0]))
+		}
+	}
+
+	return warnings
+}
+
+// Switches to a long listing, showing the columns for each file.
+func (lister *fileLister) long(colour bool, columns []fileColumn) {
+	rightAligned := make([]bool, len(columns))
+	for index, column := range columns {
+		rightAligned[index] = column.name == "size" || column.name == "count"
+	}
+
+	lister.table = terminal.NewTable(rightAligned...)
+	lister.colour = colour
+	lister.columns = columns
+	lister.timeFormat = "2006-01-02 15:04"
+}
+
+// Switches to CSV output, with a header row naming the columns.
+func (lister *fileLister) csv(columns []fileColumn) error {
+	lister.csvWriter = csv.NewWriter(lister.writer)
+	lister.columns = columns
+	lister.timeFormat = "2006-01-02 15:04:05"
+
+	header := make([]string, len(columns))
+	for index, column := range columns {
+		header[index] = column.name
+		if column.name == "value" {
+			header[index] = column.tagName
+		}
+	}
+
+	return lister.csvWriter.Write(header)
+}
+
+// Switches to an M3U playlist of the files.
+func (lister *fileLister) playlist() {
+	lister.m3u = true
+	fmt.Fprintln(lister.writer, "#EXTM3U")
+}
+
+// Lists a file by its formatted path. The file, if any, identifies the volume
+// the file is on and has the details shown in a long listing: it is nil for
+// files tagged by fingerprint.
+func (lister *fileLister) list(path string, file *entities.File) error {
+	lister.count++
+
+	if lister.showCount {
+		return nil
+	}
+
+	if lister.executor != nil {
+		// files tagged by fingerprint have no path to run the command for
+		if file != nil {
+			lister.executor.add(path)
+		}
+		return nil
+	}
+
+	if lister.showVolume && file != nil {
+		if volume := lister.store.VolumeForPath(file.Path()); volume != nil {
+			path = volume.Name + ": " + path
+		}
+	}
+
+	switch {
+	case lister.table != nil:
+		cells, err := lister.cells(path, file)
+		if err != nil {
+			return err
+		}
+
+		lister.table.AddRow(cells...)
+		return nil
+	case lister.csvWriter != nil:
+		cells, err := lister.cells(path, file)
+		if err != nil {
+			return err
+		}
+
+		if err := lister.csvWriter.Write(cells); err != nil {
+			return err
+		}
+		if lister.flushEach {
+			lister.csvWriter.Flush()
+			lister.writer.Flush()
+		}
+		return nil
+	case lister.m3u && file == nil:
+		// files tagged by fingerprint cannot be played
+		return nil
+	case lister.showDatabase && !lister.m3u:
+		path = lister.database + ": " + path
+	}
+
+	if lister.print0 {
+		fmt.Fprintf(lister.writer, "%v\000", path)
+	} else {
+		fmt.Fprintln(lister.writer, path)
+	}
+
+	if lister.flushEach {
+		lister.writer.Flush()
+	}
+
+	return nil
+}
+
+// The cells of the file's row of a long listing or CSV.
+func (lister *fileLister) cells(path string, file *entities.File) ([]string, error) {
+	var fileTags entities.FileTags
+	if file != nil {
+		var err error
+		fileTags, err = lister.store.FileTagsByFileId(lister.tx, file.Id, false)
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve file-tags for file '%v': %v", file.Id, err)
+		}
+	}
+
+	cells := make([]string, len(lister.columns))
+	for index, column := range lister.columns {
+		switch column.name {
+		case "path":
+			cells[index] = path
+			continue
+		case "database":
+			cells[index] = lister.database
 			continue
 		}
-		if dirOnly && !file.IsDir {
+		if file == nil {
+			// files tagged by fingerprint have no details
 			continue
 		}
 
-		absPath := file.Path()
-		relPath := path.Rel(absPath)
+		switch column.name {
+		case "size":
+			cells[index] = strconv.FormatInt(file.Size, 10)
+		case "time":
+			cells[index] = file.ModTime.Local().Format(lister.timeFormat)
+		case "count":
+			cells[index] = strconv.Itoa(len(fileTags))
+		case "tags":
+			tagNames, err := tagNamesForFileTags(lister.store, lister.tx, fileTags, lister.colour, lister.infos)
+			if err != nil {
+				return nil, err
+			}
+
+			cells[index] = strings.Join(tagNames, " ")
+		case "value":
+			valueNames, err := valueNamesForTag(lister.store, lister.tx, fileTags, column.tagId)
+			if err != nil {
+				return nil, err
+			}
 
-		relPaths = append(relPaths, relPath)
+			cells[index] = strings.Join(valueNames, ",")
+		}
 	}
 
-	if showCount {
-		fmt.Println(len(relPaths))
-	} else {
-		for _, relPath := range relPaths {
-			if print0 {
-				fmt.Printf("%v\000", relPath)
-			} else {
-				fmt.Println(relPath)
+	return cells, nil
+}
+
+// The names of the values a file has for a tag.
+func valueNamesForTag(store *storage.Storage, tx *storage.Tx, fileTags entities.FileTags, tagId entities.TagId) ([]string, error) {
+	valueNames := make([]string, 0, 1)
+	for _, fileTag := range fileTags {
+		if fileTag.TagId != tagId || fileTag.ValueId == 0 {
+			continue
+		}
+
+		value, err := store.Value(tx, fileTag.ValueId)
+		if err != nil {
+			return nil, fmt.Errorf("could not lookup value: %v", err)
+		}
+		if value == nil {
+			return nil, fmt.Errorf("value '%v' does not exist", fileTag.ValueId)
+		}
+
+		valueNames = append(valueNames, escape(value.Name, ','))
+	}
+
+	sort.Strings(valueNames)
+
+	return valueNames, nil
+}
+
+func (lister *fileLister) close() error {
+	switch {
+	case lister.showCount:
+		fmt.Fprintln(lister.writer, lister.count)
+	case lister.table != nil:
+		lister.table.Fprint(lister.writer)
+	case lister.csvWriter != nil:
+		lister.csvWriter.Flush()
+		if err := lister.csvWriter.Error(); err != nil {
+			return err
+		}
+	}
+
+	return lister.writer.Flush()
+}
+
+// Identifies the files tagged by fingerprint that match the query, as
+// ALGORITHM:FINGERPRINT.
+func fingerprintsForQuery(store *storage.Storage, tx *storage.Tx, expression query.Expression, explicitOnly, ignoreCase bool) ([]string, error) {
+	if query.HasContent(expression) || hasFileAttribute(expression) {
+		log.Debug("query cannot be evaluated for files tagged by fingerprint")
+		return nil, nil
+	}
+
+	fingerprintTags, err := store.FingerprintTags(tx)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve fingerprint taggings: %v", err)
+	}
+	if len(fingerprintTags) == 0 {
+		return nil, nil
+	}
+
+	tags, err := store.Tags(tx)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve tags: %v", err)
+	}
+
+	values, err := store.Values(tx)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve values: %v", err)
+	}
+
+	tagNames := make(map[entities.TagId]string, len(tags))
+	for _, tag := range tags {
+		tagNames[tag.Id] = tag.Name
+	}
+
+	valueNames := make(map[entities.ValueId]string, len(values))
+	for _, value := range values {
+		valueNames[value.Id] = value.Name
+	}
+
+	// fingerprint taggings are ordered by fingerprint
+	fingerprints := make([]string, 0, 10)
+	for index := 0; index < len(fingerprintTags); {
+		first := fingerprintTags[index]
+
+		pairs := make([]entities.TagIdValueIdPair, 0, 10)
+		for ; index < len(fingerprintTags) && fingerprintTags[index].Fingerprint == first.Fingerprint; index++ {
+			pairs = append(pairs, entities.TagIdValueIdPair{fingerprintTags[index].TagId, fingerprintTags[index].ValueId})
+		}
+
+		if !explicitOnly {
+			implications, err := store.ImplicationsFor(tx, pairs...)
+			if err != nil {
+				return nil, fmt.Errorf("could not retrieve implied tags: %v", err)
+			}
+
+			for _, implication := range implications {
+				pairs = append(pairs, implication.ImpliedTagValuePair())
+			}
+		}
+
+		tagValues := make(map[string][]string, len(pairs))
+		for _, pair := range pairs {
+			tagName := tagNames[pair.TagId]
+			if ignoreCase {
+				tagName = strings.ToLower(tagName)
 			}
+
+			tagValues[tagName] = append(tagValues[tagName], valueNames[pair.ValueId])
+		}
+
+		matches, err := matchesTagValues(expression, tagValues, ignoreCase)
+		if err != nil {
+			return nil, err
+		}
+		if matches {
+			fingerprints = append(fingerprints, first.Algorithm+":"+string(first.Fingerprint))
 		}
 	}
 
-	return nil
+	return fingerprints, nil
+}
+
+// Evaluates a query against a set of tags, each with their values, where the
+// empty value denotes a tag applied without a value.
+func matchesTagValues(expression query.Expression, tagValues map[string][]string, ignoreCase bool) (bool, error) {
+	switch exp := expression.(type) {
+	case query.EmptyExpression:
+		return true, nil
+	case query.TagExpression:
+		name := exp.Name
+		if ignoreCase {
+			name = strings.ToLower(name)
+		}
+
+		_, ok := tagValues[name]
+		return ok, nil
+	case query.NotExpression:
+		matches, err := matchesTagValues(exp.Operand, tagValues, ignoreCase)
+		return !matches, err
+	case query.AndExpression:
+		matches, err := matchesTagValues(exp.LeftOperand, tagValues, ignoreCase)
+		if err != nil || !matches {
+			return false, err
+		}
+
+		return matchesTagValues(exp.RightOperand, tagValues, ignoreCase)
+	case query.OrExpression:
+		matches, err := matchesTagValues(exp.LeftOperand, tagValues, ignoreCase)
+		if err != nil || matches {
+			return matches, err
+		}
+
+		return matchesTagValues(exp.RightOperand, tagValues, ignoreCase)
+	case query.ComparisonExpression:
+		name := exp.Tag.Name
+		if ignoreCase {
+			name = strings.ToLower(name)
+		}
+
+		values, ok := tagValues[name]
+
+		if exp.AnyValue() {
+			if exp.Operator == "!=" {
+				return !ok, nil
+			}
+
+			return ok, nil
+		}
+
+		if exp.Operator == "!=" {
+			matches, err := matchesTagValues(query.ComparisonExpression{exp.Tag, "==", exp.Value}, tagValues, ignoreCase)
+			return !matches, err
+		}
+
+		for _, value := range values {
+			if value == "" {
+				continue
+			}
+
+			matches, err := compareValue(value, exp.Operator, exp.Value.Name, ignoreCase)
+			if err != nil {
+				return false, err
+			}
+			if matches {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	case query.RelatedExpression:
+		// a file known only by its fingerprint has no relations
+		return false, nil
+	default:
+		return false, fmt.Errorf("unsupported token type '%t'", exp)
+	}
+}
+
+// Compares values numerically, where both are numbers, otherwise textually.
+func compareValue(value, operator, operand string, ignoreCase bool) (bool, error) {
+	if operator == "~" {
+		pattern, err := regexp.Compile(regexpPattern(operand, ignoreCase))
+		if err != nil {
+			return false, fmt.Errorf("invalid regular expression '%v': %v", operand, err)
+		}
+
+		return pattern.MatchString(value), nil
+	}
+
+	if ignoreCase {
+		value = strings.ToLower(value)
+		operand = strings.ToLower(operand)
+	}
+
+	comparison := strings.Compare(value, operand)
+
+	number, err := strconv.ParseFloat(value, 64)
+	operandNumber, operandErr := strconv.ParseFloat(operand, 64)
+	if err == nil && operandErr == nil {
+		switch {
+		case number < operandNumber:
+			comparison = -1
+		case number > operandNumber:
+			comparison = 1
+		default:
+			comparison = 0
+		}
+	}
+
+	switch operator {
+	case "=", "==":
+		return comparison == 0, nil
+	case "<":
+		return comparison < 0, nil
+	case ">":
+		return comparison > 0, nil
+	case "<=":
+		return comparison <= 0, nil
+	case ">=":
+		return comparison >= 0, nil
+	default:
+		return false, fmt.Errorf("unsupported operator '%v'", operator)
+	}
+}
+
+func regexpPattern(pattern string, ignoreCase bool) string {
+	if ignoreCase {
+		return "(?i)" + pattern
+	}
+
+	return pattern
+}
+
+// Whether the expression compares the built-in file attributes.
+func hasFileAttribute(expression query.Expression) bool {
+	switch exp := expression.(type) {
+	case query.ComparisonExpression:
+		return query.IsFileAttribute(exp.Tag.Name)
+	case query.NotExpression:
+		return hasFileAttribute(exp.Operand)
+	case query.AndExpression:
+		return hasFileAttribute(exp.LeftOperand) || hasFileAttribute(exp.RightOperand)
+	case query.OrExpression:
+		return hasFileAttribute(exp.LeftOperand) || hasFileAttribute(exp.RightOperand)
+	default:
+		return false
+	}
 }
 
 func containsTag(tags []string, tag string) bool {
@@ -211,3 +1136,76 @@ func containsTag(tags []string, tag string) bool {
 
 	return false
 }
+
+// A column of a long listing: one of the fileColumnNames or 'value', with the
+// tag whose values are shown.
+type fileColumn struct {
+	name    string
+	tagName string
+	tagId   entities.TagId
+}
+
+var fileColumnNames = []string{"size", "time", "count", "tags", "path", "database"}
+
+var defaultFileColumns = []fileColumn{{"size", "", 0}, {"time", "", 0}, {"path", "", 0}, {"tags", "", 0}}
+
+var defaultCsvColumns = []fileColumn{{"path", "", 0}, {"size", "", 0}, {"time", "", 0}, {"tags", "", 0}}
+
+// Parses the comma-separated columns of a long listing, adding the path
+// column last if it is not specified.
+func parseFileColumns(text string) ([]fileColumn, error) {
+	columns := make([]fileColumn, 0, 5)
+	hasPath := false
+
+	for _, name := range strings.Split(text, ",") {
+		name = strings.TrimSpace(name)
+
+		switch {
+		case strings.HasPrefix(name, "value:"):
+			tagName := strings.TrimPrefix(name, "value:")
+			if err := entities.ValidateTagName(tagName); err != nil {
+				return nil, fmt.Errorf("invalid column '%v': %v", name, err)
+			}
+
+			columns = append(columns, fileColumn{"value", tagName, 0})
+		case containsString(fileColumnNames, name):
+			hasPath = hasPath || name == "path"
+			columns = append(columns, fileColumn{name, "", 0})
+		default:
+			return nil, fmt.Errorf("invalid column '%v': expected one of %v or value:TAG", name, strings.Join(fileColumnNames, ", "))
+		}
+	}
+
+	if !hasPath {
+		columns = append(columns, fileColumn{"path", "", 0})
+	}
+
+	return columns, nil
+}
+
+func containsFileColumn(columns []fileColumn, name string) bool {
+	for _, column := range columns {
+		if column.name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validateFileSort(sort string) error {
+	if strings.HasPrefix(sort, "value:") {
+		tagName := strings.TrimPrefix(sort, "value:")
+		if tagName == "" {
+			return fmt.Errorf("invalid sort '%v': expected value:TAG", sort)
+		}
+
+		return entities.ValidateTagName(tagName)
+	}
+
+	if !containsString(sorts, sort) {
+		return fmt.Errorf("invalid sort '%v': expected one of %v or value:TAG", sort, strings.Join(sorts, ", "))
+	}
+
+	return nil
+}
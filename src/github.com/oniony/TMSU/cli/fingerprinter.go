@@ -0,0 +1,170 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/fingerprint"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/entities"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// Calculates the fingerprints of files using the configured algorithms. Files
+// that are known to be needed can be prepared in advance, in which case they
+// are fingerprinted concurrently by up to the configured number of jobs. Files
+// handled by a plugin take the fingerprint the plugin gives, if any.
+type fingerprinter struct {
+	fileAlgorithm      string
+	directoryAlgorithm string
+	symlinkAlgorithm   string
+	plugins            []entities.Plugin
+	jobs               uint
+	prepared           map[string]fingerprintResult
+}
+
+// the number of files prepared at a time by prepareFileBatch and preparePathBatch
+const fingerprintBatchSize = 1000
+
+type fingerprintResult struct {
+	fingerprint fingerprint.Fingerprint
+	err         error
+}
+
+func newFingerprinter(settings entities.Settings, jobs uint) *fingerprinter {
+	plugins, err := settings.Plugins()
+	if err != nil {
+		log.Warnf("ignoring plugins: %v", err)
+	}
+
+	return &fingerprinter{settings.FileFingerprintAlgorithm(), settings.DirectoryFingerprintAlgorithm(), settings.SymlinkFingerprintAlgorithm(), plugins, jobs, make(map[string]fingerprintResult)}
+}
+
+// Fingerprints the files at the specified paths ahead of their being
+// requested, returning once all have been fingerprinted.
+func (fingerprinter *fingerprinter) prepare(paths []string) {
+	unprepared := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if _, ok := fingerprinter.prepared[path]; !ok {
+			unprepared = append(unprepared, path)
+		}
+	}
+
+	if fingerprinter.jobs < 2 || len(unprepared) < 2 {
+		// nothing to be gained over fingerprinting on request
+		return
+	}
+
+	log.Debugf("fingerprinting %v files using up to %v jobs", len(unprepared), fingerprinter.jobs)
+
+	pending := make(chan string)
+	var mutex sync.Mutex
+	var waitGroup sync.WaitGroup
+
+	for job := uint(0); job < fingerprinter.jobs && job < uint(len(unprepared)); job++ {
+		waitGroup.Add(1)
+
+		go func() {
+			defer waitGroup.Done()
+
+			for path := range pending {
+				fp, err := fingerprinter.calculate(path)
+
+				mutex.Lock()
+				fingerprinter.prepared[path] = fingerprintResult{fp, err}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for _, path := range unprepared {
+		pending <- path
+	}
+
+	close(pending)
+	waitGroup.Wait()
+}
+
+// Prepares the fingerprints of the next batch of the files upon reaching the
+// first of the batch, so that only a batch of fingerprints is held at a time.
+func (fingerprinter *fingerprinter) prepareFileBatch(files entities.Files, index int) {
+	if index%fingerprintBatchSize != 0 {
+		return
+	}
+
+	end := batchEnd(index, len(files))
+	paths := make([]string, 0, end-index)
+	for _, file := range files[index:end] {
+		paths = append(paths, file.Path())
+	}
+
+	fingerprinter.prepare(paths)
+}
+
+// Prepares the fingerprints of the next batch of the paths upon reaching the
+// first of the batch.
+func (fingerprinter *fingerprinter) preparePathBatch(paths []string, index int) {
+	if index%fingerprintBatchSize != 0 {
+		return
+	}
+
+	fingerprinter.prepare(paths[index:batchEnd(index, len(paths))])
+}
+
+// Retrieves the fingerprint of the file at the specified path, calculating it
+// now unless it was prepared.
+func (fingerprinter *fingerprinter) create(path string) (fingerprint.Fingerprint, error) {
+	if result, ok := fingerprinter.prepared[path]; ok {
+		delete(fingerprinter.prepared, path)
+		return result.fingerprint, result.err
+	}
+
+	return fingerprinter.calculate(path)
+}
+
+func (fingerprinter *fingerprinter) calculate(path string) (fingerprint.Fingerprint, error) {
+	if result := runPlugins(fingerprinter.plugins, path); result != nil && result.Fingerprint != "" {
+		return fingerprint.Fingerprint(result.Fingerprint), nil
+	}
+
+	return fingerprint.CreateContext(commandContext, path, fingerprinter.fileAlgorithm, fingerprinter.directoryAlgorithm, fingerprinter.symlinkAlgorithm)
+}
+
+func batchEnd(index, count int) int {
+	if index+fingerprintBatchSize > count {
+		return count
+	}
+
+	return index + fingerprintBatchSize
+}
+
+// The number of files to fingerprint at once, as specified by the --jobs option
+// or else the number of processors.
+func parseJobs(options Options) (uint, error) {
+	if !options.HasOption("--jobs") {
+		return uint(runtime.NumCPU()), nil
+	}
+
+	text := options.Get("--jobs").Argument
+	jobs, err := strconv.ParseUint(text, 10, 16)
+	if err != nil || jobs == 0 {
+		return 0, fmt.Errorf("invalid number of jobs '%v': expected a positive integer", text)
+	}
+
+	return uint(jobs), nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/storage"
+	"os"
+)
+
+var ForgetCommand = Command{
+	Name:     "forget",
+	Synopsis: "Remove missing files from the database, retaining their tags",
+	Usages:   []string{"tmsu forget [OPTION]... FILE..."},
+	Description: `Removes missing FILEs from the database whilst retaining their details and tags so that they can be restored, for example once the files have been recovered from a backup. Files that still exist are not forgotten: use the 'untag' subcommand to remove their tags instead.
+
+A forgotten file is restored with its tags by the 'restore' subcommand or, if it reappears with the same fingerprint at its old location or under one of the paths searched, by the 'repair' subcommand. Forgotten files are retained for the number of days given by the 'forgottenRetention' setting, after which 'repair' removes them permanently.`,
+	Examples: []string{"$ tmsu forget mountain.jpg",
+		"$ tmsu forget --recursive photos/2017"},
+	Options: Options{{"--recursive", "-r", "also forget the files beneath directories", false, ""},
+		{"--no-dereference", "-P", "do not follow symbolic links (forget the link itself)", false, ""}},
+	Exec: forgetExec,
+}
+
+// unexported
+
+func forgetExec(options Options, args []string, databasePath string) (error, warnings) {
+	if len(args) < 1 {
+		return fmt.Errorf("files to forget must be specified"), nil
+	}
+
+	recursive := options.HasOption("--recursive")
+
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	tx, err := store.Begin()
+	if err != nil {
+		return err, nil
+	}
+	defer tx.Commit()
+
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve settings: %v", err), nil
+	}
+
+	symlinks := symlinkPolicyFor(options, settings)
+
+	if err := fireHooks(store, tx, hookEvent{"untag", args, nil, nil}); err != nil {
+		return err, nil
+	}
+
+	return forgetPaths(store, tx, args, recursive, symlinks)
+}
+
+func forgetPaths(store *storage.Storage, tx *storage.Tx, paths []string, recursive bool, symlinks symlinkPolicy) (error, warnings) {
+	files, err, warnings := filesToUntag(store, tx, paths, recursive, symlinks)
+	if err != nil {
+		return err, warnings
+	}
+
+	for _, file := range files {
+		if _, err := os.Lstat(file.Path()); err == nil {
+			warnings = append(warnings, fmt.Sprintf("%v: file exists", file.Path()))
+			continue
+		}
+
+		log.Debugf("%v: forgetting file", file.Path())
+
+		if _, err := store.ForgetFile(tx, file.Id); err != nil {
+			return fmt.Errorf("%v: could not forget file: %v", file.Path(), err), warnings
+		}
+	}
+
+	return nil, warnings
+}
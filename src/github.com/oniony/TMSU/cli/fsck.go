@@ -0,0 +1,230 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage"
+)
+
+var FsckCommand = Command{
+	Name:     "fsck",
+	Synopsis: "Check the database for inconsistencies",
+	Usages:   []string{"tmsu fsck [OPTION]..."},
+	Description: `Checks the integrity of the database, reporting any problems found.
+
+The following are checked for:
+
+  * corruption of the database file itself
+  * file-tags, implications and other records referring to files, tags or
+    values that no longer exist
+  * tags sharing the same name
+  * files without any tags
+
+With --fix the problems are repaired: orphaned records and untagged files are removed and tags sharing a name are merged into the first. Corruption of the database file cannot be repaired by TMSU: restore the database from a backup or use the Sqlite3 tooling to recover what it can.
+
+With --compact the database file is rebuilt to reclaim unused space and the statistics used to plan queries are refreshed. This is worthwhile after a large number of files or tags have been removed.
+
+Use the 'repair' subcommand to check the files on disk rather than the database.`,
+	Examples: []string{`$ tmsu fsck
+3 file-tags of missing tags
+tag 'holiday' (#12): duplicate name
+tmsu: 2 problem(s) found: use --fix to repair`,
+		"$ tmsu fsck --fix --compact"},
+	Options: Options{Option{"--fix", "-f", "repair the problems found", false, ""},
+		Option{"--compact", "-c", "reclaim unused space and refresh query statistics", false, ""}},
+	Exec: fsckExec,
+}
+
+// unexported
+
+func fsckExec(options Options, args []string, databasePath string) (error, warnings) {
+	if len(args) > 0 {
+		return fmt.Errorf("too many arguments"), nil
+	}
+
+	fix := options.HasOption("--fix")
+	compact := options.HasOption("--compact")
+
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	tx, err := store.Begin()
+	if err != nil {
+		return err, nil
+	}
+
+	problems, corrupt, err := checkDatabase(store, tx, fix)
+	if err != nil {
+		tx.Rollback()
+		return err, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err, nil
+	}
+
+	if compact {
+		log.Debug("compacting the database")
+
+		if err := store.Compact(); err != nil {
+			return fmt.Errorf("could not compact database: %v", err), nil
+		}
+	}
+
+	switch {
+	case corrupt:
+		return nil, warnings{"the database file is corrupt: restore it from a backup"}
+	case problems > 0 && !fix:
+		return nil, warnings{fmt.Sprintf("%v problem(s) found: use --fix to repair", problems)}
+	}
+
+	return nil, nil
+}
+
+// Reports, and optionally fixes, the problems with the database, returning the
+// number found and whether the database file itself is corrupt.
+func checkDatabase(store *storage.Storage, tx *storage.Tx, fix bool) (uint, bool, error) {
+	var problems uint
+
+	log.Debug("checking database file integrity")
+
+	corruptions, err := store.IntegrityCheck(tx)
+	if err != nil {
+		return 0, false, fmt.Errorf("could not check database integrity: %v", err)
+	}
+
+	for _, corruption := range corruptions {
+		fmt.Printf("corruption: %v\n", corruption)
+		problems++
+	}
+	if len(corruptions) > 0 {
+		// further checks, and fixes, would be unreliable
+		return problems, true, nil
+	}
+
+	log.Debug("checking for orphaned records")
+
+	orphans, err := store.OrphanCounts(tx)
+	if err != nil {
+		return 0, false, fmt.Errorf("could not check for orphaned records: %v", err)
+	}
+
+	for _, orphan := range orphans {
+		reportProblem(fmt.Sprintf("%v %v", orphan.Count, orphan.Description), fix)
+		problems++
+	}
+
+	if fix && len(orphans) > 0 {
+		if err := store.DeleteOrphans(tx); err != nil {
+			return 0, false, fmt.Errorf("could not remove orphaned records: %v", err)
+		}
+	}
+
+	log.Debug("checking for duplicate tag names")
+
+	count, err := checkDuplicateTags(store, tx, fix)
+	if err != nil {
+		return 0, false, err
+	}
+	problems += count
+
+	log.Debug("checking for untagged files")
+
+	untaggedFiles, err := store.UntaggedFiles(tx)
+	if err != nil {
+		return 0, false, fmt.Errorf("could not retrieve untagged files: %v", err)
+	}
+
+	for _, file := range untaggedFiles {
+		reportProblem(fmt.Sprintf("%v: untagged", file.Path()), fix)
+		problems++
+	}
+
+	if fix && len(untaggedFiles) > 0 {
+		if err := deleteUntaggedFiles(store, tx, untaggedFiles); err != nil {
+			return 0, false, fmt.Errorf("could not remove untagged files: %v", err)
+		}
+	}
+
+	return problems, false, nil
+}
+
+func checkDuplicateTags(store *storage.Storage, tx *storage.Tx, fix bool) (uint, error) {
+	duplicates, err := store.DuplicateTags(tx)
+	if err != nil {
+		return 0, fmt.Errorf("could not check for duplicate tags: %v", err)
+	}
+	if len(duplicates) == 0 {
+		return 0, nil
+	}
+
+	tags, err := store.Tags(tx)
+	if err != nil {
+		return 0, fmt.Errorf("could not retrieve tags: %v", err)
+	}
+
+	firstTagIds := make(map[string]entities.TagId, len(tags))
+	for _, tag := range tags {
+		if firstTagId, found := firstTagIds[tag.Name]; !found || tag.Id < firstTagId {
+			firstTagIds[tag.Name] = tag.Id
+		}
+	}
+
+	for _, duplicate := range duplicates {
+		reportProblem(fmt.Sprintf("tag '%v' (#%v): duplicate name", duplicate.Name, duplicate.Id), fix)
+
+		if fix {
+			if err := mergeDuplicateTag(store, tx, duplicate, firstTagIds[duplicate.Name]); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return uint(len(duplicates)), nil
+}
+
+func mergeDuplicateTag(store *storage.Storage, tx *storage.Tx, duplicate *entities.Tag, firstTagId entities.TagId) error {
+	fileTags, err := store.FileTagsByTagId(tx, duplicate.Id, true)
+	if err != nil {
+		return fmt.Errorf("could not retrieve files for tag #%v: %v", duplicate.Id, err)
+	}
+
+	for _, fileTag := range fileTags {
+		if _, err = store.AddFileTag(tx, fileTag.FileId, firstTagId, fileTag.ValueId); err != nil {
+			return fmt.Errorf("could not apply tag #%v to file #%v: %v", firstTagId, fileTag.FileId, err)
+		}
+	}
+
+	if err = store.DeleteTag(tx, duplicate.Id); err != nil {
+		return fmt.Errorf("could not delete tag #%v: %v", duplicate.Id, err)
+	}
+
+	return nil
+}
+
+func reportProblem(problem string, fixed bool) {
+	if fixed {
+		fmt.Printf("%v: fixed\n", problem)
+	} else {
+		fmt.Println(problem)
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// The renames git has recorded in a repository, committed or staged.
+type gitRepository struct {
+	root    string
+	renames map[string]string
+}
+
+// The repositories of the paths looked up, whose renames are read once each.
+type gitRepositories struct {
+	byDir  map[string]*gitRepository
+	byRoot map[string]*gitRepository
+}
+
+func newGitRepositories() *gitRepositories {
+	return &gitRepositories{make(map[string]*gitRepository), make(map[string]*gitRepository)}
+}
+
+// Retrieves the repository containing the path, with its renames, or nil if it
+// is not within one.
+func (repositories *gitRepositories) forPath(path string) (*gitRepository, error) {
+	dir := filepath.Dir(path)
+	if repository, ok := repositories.byDir[dir]; ok {
+		return repository, nil
+	}
+
+	repository, err := gitRepositoryFor(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if repository != nil {
+		if known, ok := repositories.byRoot[repository.root]; ok {
+			repository = known
+		} else {
+			if err := repository.readRenames(); err != nil {
+				return nil, fmt.Errorf("%v: could not read git renames: %v", repository.root, err)
+			}
+
+			repositories.byRoot[repository.root] = repository
+		}
+	}
+
+	repositories.byDir[dir] = repository
+
+	return repository, nil
+}
+
+// Identifies the git repository containing the path, which need not exist,
+// returning nil if it is not within one.
+func gitRepositoryFor(path string) (*gitRepository, error) {
+	dir := filepath.Dir(path)
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+
+	output, err := runGit(dir, "rev-parse", "--show-prefix")
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			// not a repository
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	// the root is derived from the directory, rather than asking git for it,
+	// so that it is reached by the same (possibly symbolic) path
+	root := dir
+	prefix := strings.TrimSuffix(strings.TrimSpace(string(output)), "/")
+	if prefix != "" {
+		for range strings.Split(prefix, "/") {
+			root = filepath.Dir(root)
+		}
+	}
+
+	return &gitRepository{root, nil}, nil
+}
+
+// Reads the renames from the history and index of the repository.
+func (repository *gitRepository) readRenames() error {
+	repository.renames = make(map[string]string)
+
+	history, err := runGit(repository.root, "log", "--reverse", "--format=", "--name-status", "-M", "--diff-filter=R", "-z")
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return err
+		}
+
+		// no commits yet
+		history = nil
+	}
+	repository.addRenames(history)
+
+	staged, err := runGit(repository.root, "diff", "--cached", "--name-status", "-M", "--diff-filter=R", "-z")
+	if err != nil {
+		return err
+	}
+	repository.addRenames(staged)
+
+	return nil
+}
+
+// Adds the renames from NUL separated name-status output, in which each
+// rename is a status such as 'R097' followed by the old and new paths.
+func (repository *gitRepository) addRenames(output []byte) {
+	fields := make([]string, 0, 30)
+	for _, field := range bytes.Split(output, []byte{0}) {
+		if len(field) > 0 {
+			fields = append(fields, strings.TrimSpace(string(field)))
+		}
+	}
+
+	for index := 0; index+2 < len(fields); index++ {
+		if !strings.HasPrefix(fields[index], "R") {
+			continue
+		}
+
+		repository.renames[fields[index+1]] = fields[index+2]
+		index += 2
+	}
+}
+
+// Follows the renames of the path, which is within the repository, returning
+// its current path or false if it has not been renamed. A directory, which
+// git does not track, is followed by way of the files within it.
+func (repository *gitRepository) follow(path string) (string, bool) {
+	relPath, err := filepath.Rel(repository.root, path)
+	if err != nil || strings.HasPrefix(relPath, "..") {
+		return "", false
+	}
+	relPath = filepath.ToSlash(relPath)
+
+	seen := make(map[string]bool)
+	for !seen[relPath] {
+		seen[relPath] = true
+
+		if newPath, ok := repository.renames[relPath]; ok {
+			relPath = newPath
+			continue
+		}
+
+		if newDir, ok := repository.followDirectory(relPath); ok {
+			relPath = newDir
+			continue
+		}
+
+		break
+	}
+
+	if len(seen) == 1 {
+		return "", false
+	}
+
+	return filepath.Join(repository.root, filepath.FromSlash(relPath)), true
+}
+
+// Identifies the new path of a directory from a file within it that was
+// renamed along with it.
+func (repository *gitRepository) followDirectory(dir string) (string, bool) {
+	for oldPath, newPath := range repository.renames {
+		if !strings.HasPrefix(oldPath, dir+"/") {
+			continue
+		}
+
+		suffix := oldPath[len(dir):]
+		if strings.HasSuffix(newPath, suffix) && len(newPath) > len(suffix) {
+			return newPath[:len(newPath)-len(suffix)], true
+		}
+	}
+
+	return "", false
+}
+
+func runGit(dir string, args ...string) ([]byte, error) {
+	command := exec.Command("git", append([]string{"-C", dir}, args...)...)
+
+	output, err := command.Output()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("could not run git: %v", err)
+	}
+
+	return output, nil
+}
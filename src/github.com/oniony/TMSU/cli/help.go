@@ -17,6 +17,7 @@ package cli
 
 import (
 	"fmt"
+	"github.com/oniony/TMSU/common/i18n"
 	"github.com/oniony/TMSU/common/log"
 	"github.com/oniony/TMSU/common/terminal"
 	"github.com/oniony/TMSU/common/terminal/ansi"
@@ -27,12 +28,14 @@ import (
 )
 
 var HelpCommand = Command{
-	Name:        "help",
-	Synopsis:    "List subcommands or show help for a particular subcommand",
-	Usages:      []string{"tmsu help [OPTION]... [SUBCOMMAND]"},
-	Description: `Shows help summary or, where SUBCOMMAND is specified, help for SUBCOMMAND.`,
-	Options:     Options{{"--list", "-l", "list commands", false, ""}},
-	Exec:        helpExec,
+	Name:     "help",
+	Synopsis: "List subcommands or show help for a particular subcommand",
+	Usages:   []string{"tmsu help [OPTION]... [SUBCOMMAND]"},
+	Description: `Shows help summary or, where SUBCOMMAND is specified, help for SUBCOMMAND.
+
+Help is shown in the language of the locale given by the LC_ALL, LC_MESSAGES or LANG environment variable, where there is a translation, and otherwise in English.`,
+	Options: Options{{"--list", "-l", "list commands", false, ""}},
+	Exec:    helpExec,
 }
 
 // unexported
@@ -88,11 +91,11 @@ func summary(colour bool) {
 	}
 
 	for _, command := range helpCommands {
-		if command.Hidden && log.Verbosity < 2 {
+		if command.Hidden && !log.Enabled(log.DebugLevel) {
 			continue
 		}
 
-		synopsis := command.Synopsis
+		synopsis := i18n.T(command.Synopsis)
 		if !colour {
 			synopsis = ansi.Strip(synopsis)
 		}
@@ -108,7 +111,7 @@ func summary(colour bool) {
 
 	fmt.Println()
 
-	text = "Global options:"
+	text = i18n.T("Global options:")
 	if colour {
 		text = ansi.Bold(text)
 	}
@@ -118,14 +121,14 @@ func summary(colour bool) {
 	printOptions(globalOptions)
 
 	fmt.Println()
-	terminal.PrintWrapped("Specify subcommand name for detailed help on a particular subcommand, e.g. tmsu help files")
+	terminal.PrintWrapped(i18n.T("Specify subcommand name for detailed help on a particular subcommand, e.g. tmsu help files"))
 }
 
 func listCommands() {
 	commandNames := make([]string, 0, len(helpCommands))
 
 	for _, command := range helpCommands {
-		if command.Hidden && log.Verbosity < 2 {
+		if command.Hidden && !log.Enabled(log.DebugLevel) {
 			continue
 		}
 
@@ -142,7 +145,7 @@ func listCommands() {
 func describeCommand(commandName string, colour bool) {
 	command := findCommand(helpCommands, commandName)
 	if command == nil {
-		fmt.Printf("No such command '%v'.\n", commandName)
+		fmt.Println(i18n.Tf("No such command '%v'.", commandName))
 		return
 	}
 
@@ -157,7 +160,7 @@ func describeCommand(commandName string, colour bool) {
 
 	// description
 	fmt.Println()
-	description := colorize(command.Description)
+	description := colorize(i18n.T(command.Description))
 
 	if !colour {
 		description = ansi.Strip(description)
@@ -169,7 +172,7 @@ func describeCommand(commandName string, colour bool) {
 	if command.Examples != nil && len(command.Examples) > 0 {
 		fmt.Println()
 
-		text := "Examples:"
+		text := i18n.T("Examples:")
 		if colour {
 			text = ansi.Bold(text)
 		}
@@ -187,7 +190,7 @@ func describeCommand(commandName string, colour bool) {
 		fmt.Println()
 
 		if command.Aliases != nil {
-			text := "Aliases:"
+			text := i18n.T("Aliases:")
 			if colour {
 				text = ansi.Bold(text)
 			}
@@ -205,7 +208,7 @@ func describeCommand(commandName string, colour bool) {
 	if command.Options != nil && len(command.Options) > 0 {
 		fmt.Println()
 
-		text := "Options:"
+		text := i18n.T("Options:")
 		if colour {
 			text = ansi.Bold(text)
 		}
@@ -223,7 +226,7 @@ func printOptions(options []Option) {
 	}
 
 	for _, option := range options {
-		line := fmt.Sprintf("  %-2v %-*v   %v", option.ShortName, maxWidth, option.LongName, option.Description)
+		line := fmt.Sprintf("  %-2v %-*v   %v", option.ShortName, maxWidth, option.LongName, i18n.T(option.Description))
 		terminal.PrintWrapped(line)
 	}
 }
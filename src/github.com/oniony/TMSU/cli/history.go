@@ -0,0 +1,161 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	_path "github.com/oniony/TMSU/common/path"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+var HistoryCommand = Command{
+	Name:     "history",
+	Synopsis: "Show the history of tagging changes",
+	Usages: []string{"tmsu history [OPTION]... [FILE]...",
+		"tmsu history [OPTION]... --tag=TAG"},
+	Description: `Shows the audit trail of changes to the tagging of the FILEs specified, or of every file if none are, oldest first.
+
+Each line shows when the change was made, the user and command that made it, the action and what it applied to. The actions are 'tag' and 'untag', for tags applied to and removed from files, and 'imply' and 'unimply', for tag implications added and removed. Setting a value is recorded as the tag being applied with that value.
+
+With --tag only those changes involving TAG, including implications that imply it, are shown.
+
+The user is taken from the global --user option or the TMSU_USER environment variable, or else is the operating system account running TMSU. Changes made to the database other than by TMSU are shown with an unknown user and command.`,
+	Examples: []string{`$ tmsu history mountain.jpg
+2017-06-14 09:31:20 bob tag ./mountain.jpg landscape (tmsu tag)
+2017-06-14 09:31:20 bob tag ./mountain.jpg year=2017 (tmsu tag)
+2017-06-15 18:02:11 alice untag ./mountain.jpg landscape (tmsu untag)`,
+		`$ tmsu history --tag=landscape --limit=10`},
+	Options: Options{Option{"--tag", "-t", "show only changes involving TAG", true, ""},
+		Option{"--limit", "-n", "show only the most recent N changes", true, ""}},
+	Exec: historyExec,
+}
+
+// unexported
+
+const historyTimeFormat = "2006-01-02 15:04:05"
+
+func historyExec(options Options, args []string, databasePath string) (error, warnings) {
+	tagName := ""
+	if options.HasOption("--tag") {
+		tagName = parseTagOrValueName(options.Get("--tag").Argument)
+	}
+
+	limit := 0
+	if options.HasOption("--limit") {
+		var err error
+		limit, err = strconv.Atoi(options.Get("--limit").Argument)
+		if err != nil || limit < 1 {
+			return fmt.Errorf("invalid limit '%v': must be a positive number", options.Get("--limit").Argument), nil
+		}
+	}
+
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	tx, err := store.BeginRead()
+	if err != nil {
+		return err, nil
+	}
+	defer tx.Commit()
+
+	entries, err := auditEntriesFor(store, tx, args, tagName)
+	if err != nil {
+		return err, nil
+	}
+
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+
+	for _, entry := range entries {
+		printAuditEntry(store, entry)
+	}
+
+	return nil, nil
+}
+
+// Retrieves the audit entries for the files, or all files if none, involving
+// the tag if it is not empty.
+func auditEntriesFor(store *storage.Storage, tx *storage.Tx, paths []string, tagName string) (entities.AuditEntries, error) {
+	if len(paths) == 0 {
+		log.Debug("retrieving audit trail")
+
+		if tagName != "" {
+			return store.AuditEntriesByTag(tx, tagName)
+		}
+
+		return store.AuditEntries(tx)
+	}
+
+	entries := make(entities.AuditEntries, 0, 10)
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("%v: could not get absolute path: %v", path, err)
+		}
+
+		log.Debugf("%v: retrieving audit trail", path)
+
+		pathEntries, err := store.AuditEntriesByPath(tx, absPath)
+		if err != nil {
+			return nil, fmt.Errorf("%v: could not retrieve audit trail: %v", path, err)
+		}
+
+		for _, entry := range pathEntries {
+			if tagName == "" || entry.Tag == tagName {
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	if len(paths) > 1 {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Id < entries[j].Id })
+	}
+
+	return entries, nil
+}
+
+func printAuditEntry(store *storage.Storage, entry *entities.AuditEntry) {
+	user := entry.User
+	if user == "" {
+		user = "unknown"
+	}
+
+	tag := formatTagValueName(entry.Tag, entry.Value, "", false, false, false)
+
+	var subject string
+	switch entry.Action {
+	case entities.AuditImply, entities.AuditUnimply:
+		subject = tag + " -> " + formatTagValueName(entry.ImpliedTag, entry.ImpliedValue, "", false, false, false)
+	default:
+		subject = _path.Rel(store.AuditEntryPath(*entry)) + " " + tag
+	}
+
+	command := ""
+	if entry.Command != "" {
+		command = " (" + entry.Command + ")"
+	}
+
+	fmt.Printf("%v %v %v %v%v\n", entry.ChangedAt.Local().Format(historyTimeFormat), user, entry.Action, subject, command)
+}
@@ -0,0 +1,156 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/storage"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// A change to the tagging for which hooks are run.
+type hookEvent struct {
+	name   string
+	paths  []string
+	tags   []string
+	values []string
+}
+
+// Runs the pre- hooks for the event, failing if any of them fail, and queues
+// the post- hooks to run once the change has been committed.
+func fireHooks(store *storage.Storage, tx *storage.Tx, event hookEvent) error {
+	if dryRun {
+		log.Debugf("dry run: not running hooks for '%v'", event.name)
+		return nil
+	}
+
+	preCommands, err := hookCommands(store, tx, "pre-"+event.name)
+	if err != nil {
+		return err
+	}
+
+	for _, command := range preCommands {
+		if err := runHook(command, "pre-"+event.name, store.DbPath, event); err != nil {
+			return fmt.Errorf("pre-%v hook failed: %v", event.name, err)
+		}
+	}
+
+	postCommands, err := hookCommands(store, tx, "post-"+event.name)
+	if err != nil {
+		return err
+	}
+
+	for _, command := range postCommands {
+		pendingHooks = append(pendingHooks, pendingHook{command, "post-" + event.name, store.DbPath, event})
+	}
+
+	return nil
+}
+
+// Runs the post- hooks queued whilst the command ran, reporting any failures
+// as warnings as the change has already been made.
+func runPendingHooks() warnings {
+	warnings := make(warnings, 0, len(pendingHooks))
+
+	for _, hook := range pendingHooks {
+		if err := runHook(hook.command, hook.eventName, hook.databasePath, hook.event); err != nil {
+			warnings = append(warnings, fmt.Sprintf("%v hook failed: %v", hook.eventName, err))
+		}
+	}
+
+	pendingHooks = nil
+
+	return warnings
+}
+
+// unexported
+
+type pendingHook struct {
+	command      []string
+	eventName    string
+	databasePath string
+	event        hookEvent
+}
+
+var pendingHooks []pendingHook
+
+// Finds the commands to run for the event: the executable of that name in the
+// hooks directory beside the database, followed by those configured.
+func hookCommands(store *storage.Storage, tx *storage.Tx, eventName string) ([][]string, error) {
+	commands := make([][]string, 0, 2)
+
+	hookPath := filepath.Join(filepath.Dir(store.DbPath), "hooks", eventName)
+	if info, err := os.Stat(hookPath); err == nil && !info.IsDir() {
+		commands = append(commands, []string{hookPath})
+	}
+
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve settings: %v", err)
+	}
+
+	hooks, err := settings.Hooks()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hook := range hooks {
+		if hook.Event == eventName {
+			commands = append(commands, shellCommand(hook.Command))
+		}
+	}
+
+	return commands, nil
+}
+
+func shellCommand(command string) []string {
+	if runtime.GOOS == "windows" {
+		return []string{"cmd", "/C", command}
+	}
+
+	return []string{"/bin/sh", "-c", command}
+}
+
+func runHook(command []string, eventName, databasePath string, event hookEvent) error {
+	log.Debugf("running %v hook '%v'", eventName, strings.Join(command, " "))
+
+	hook := exec.Command(command[0], command[1:]...)
+	hook.Env = append(os.Environ(),
+		"TMSU_EVENT="+eventName,
+		"TMSU_DB="+databasePath,
+		"TMSU_TAGS="+strings.Join(event.tags, "\n"),
+		"TMSU_VALUES="+strings.Join(event.values, "\n"))
+	input := ""
+	for _, path := range event.paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			absPath = path
+		}
+
+		input += absPath + "\n"
+	}
+
+	hook.Stdin = strings.NewReader(input)
+	hook.Stdout = os.Stderr
+	hook.Stderr = os.Stderr
+
+	return hook.Run()
+}
@@ -16,18 +16,24 @@
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/common/terminal"
 	"github.com/oniony/TMSU/entities"
 	"github.com/oniony/TMSU/storage"
-	"strings"
+	"sort"
+	"strconv"
 )
 
 var ImplyCommand = Command{
 	Name:     "imply",
 	Synopsis: "Creates a tag implication",
 	Usages: []string{"tmsu imply [OPTION] TAG[=VALUE] IMPL[=VALUE]...",
-		"tmsu imply"},
+		"tmsu imply --pattern [OPTION] TAG=PATTERN IMPL[=VALUE]...",
+		"tmsu imply",
+		"tmsu imply --graph [--json] [--closure]",
+		"tmsu imply --rematerialize"},
 	Description: `Creates a tag implication such that any file tagged TAG will be implicitly tagged IMPL.
 
 When run without arguments lists the set of tag implications.
@@ -36,14 +42,40 @@ Tag implications are applied at time of file query (not at time of tag applicati
 
 By default the 'tag' subcommand will not explicitly apply tags that are already implied by the implication rules.
 
-The 'tags' subcommand can be used to identify which tags applied to a file are implied.`,
+The 'tags' subcommand can be used to identify which tags applied to a file are implied.
+
+When the 'materializeImplications' setting is enabled the implied tags are also stored in the database, flagged as implied, for the benefit of programs that read the database directly. These are kept up to date as files are tagged and implications change; --rematerialize rebuilds them should they have been disturbed, or removes them should the setting have been disabled.
+
+With --pattern the implication is conditional upon the value of TAG: it applies only to files where TAG has a value matching the glob PATTERN, in which '*' matches any sequence of characters, '?' any single character and '[...]' any one of the enclosed characters. Matching is case-sensitive. Pattern implications are listed with '~' in place of '='.
+
+With --graph the implications are output as a Graphviz DOT digraph, suitable for rendering with 'dot', or with --json as a JSON object mapping each implying tag to the list of tags it implies. With --closure the transitive implications are included too, drawn dashed in the DOT output.`,
 	Examples: []string{`$ tmsu imply mp3 music`,
 		`$ tmsu imply
 mp3 -> music`,
 		`$ tmsu imply aubergine aka=eggplant`,
-		`$ tmsu imply --delete mp3 music`},
-	Options: Options{Option{"--delete", "-d", "deletes the tag implication", false, ""}},
-	Exec:    implyExec,
+		`$ tmsu imply camera=iphone manufacturer=apple`,
+		`$ tmsu imply --pattern 'year=19*' vintage`,
+		`$ tmsu imply
+  camera=iphone -> manufacturer=apple
+      year~19* -> vintage`,
+		`$ tmsu imply --delete mp3 music`,
+		`$ tmsu imply --graph | dot -Tsvg >implications.svg`,
+		`$ tmsu config materializeImplications=yes`,
+		`$ tmsu imply --rematerialize`,
+		`$ tmsu imply --graph --json --closure
+{
+  "aubergine": [
+    "aka=eggplant",
+    "vegetable"
+  ]
+}`},
+	Options: Options{Option{"--delete", "-d", "deletes the tag implication", false, ""},
+		Option{"--pattern", "-p", "the implying tag's value is a glob pattern", false, ""},
+		Option{"--graph", "-g", "output the implications as a Graphviz DOT graph", false, ""},
+		Option{"--json", "-j", "output the graph as JSON adjacency lists", false, ""},
+		Option{"--closure", "-c", "include transitive implications in the graph", false, ""},
+		Option{"--rematerialize", "-r", "rebuild the implied tags stored in the database", false, ""}},
+	Exec: implyExec,
 }
 
 // unexported
@@ -66,12 +98,30 @@ func implyExec(options Options, args []string, databasePath string) (error, warn
 		return err, nil
 	}
 
+	pattern := options.HasOption("--pattern")
+
+	if options.HasOption("--rematerialize") {
+		if len(args) > 0 {
+			return fmt.Errorf("too many arguments"), nil
+		}
+
+		return rematerializeImplications(store, tx), nil
+	}
+
+	if options.HasOption("--graph") || options.HasOption("--json") {
+		if len(args) > 0 {
+			return fmt.Errorf("too many arguments"), nil
+		}
+
+		return graphImplications(store, tx, options.HasOption("--json"), options.HasOption("--closure")), nil
+	}
+
 	if options.HasOption("--delete") {
 		if len(args) < 2 {
 			return fmt.Errorf("too few arguments"), nil
 		}
 
-		return deleteImplications(store, tx, args)
+		return deleteImplications(store, tx, args, pattern)
 	}
 
 	switch len(args) {
@@ -80,50 +130,173 @@ func implyExec(options Options, args []string, databasePath string) (error, warn
 	case 1:
 		return fmt.Errorf("tag(s) to be implied must be specified"), nil
 	default:
-		return addImplications(store, tx, args)
+		return addImplications(store, tx, args, pattern)
 	}
 }
 
 func listImplications(store *storage.Storage, tx *storage.Tx, colour bool) error {
-	log.Infof(2, "retrieving tag implications.")
+	log.Debugf("retrieving tag implications.")
 
 	implications, err := store.Implications(tx)
 	if err != nil {
 		return fmt.Errorf("could not retrieve implications: %v", err)
 	}
 
-	width := 0
+	infos, err := tagInfosForColour(store, tx, colour)
+	if err != nil {
+		return err
+	}
+
+	table := terminal.NewTable(true)
+	table.Separator = " -> "
+
 	for _, implication := range implications {
-		length := len(implication.ImplyingTag.Name)
-		if implication.ImplyingValue.Id != 0 {
-			length += 1 + len(implication.ImplyingValue.Name)
-		}
+		table.AddRow(formatImplication(implication, infos, colour))
+	}
+
+	table.Print()
+
+	return nil
+}
+
+// Formats the implying and implied tags of the implication.
+func formatImplication(implication *entities.Implication, infos entities.TagInfos, colour bool) (string, string) {
+	implyingColour := tagColour(implication.ImplyingTag.Id, infos)
+
+	var implying string
+	if implication.ImplyingPattern != "" {
+		implying = formatTagValueName(implication.ImplyingTag.Name, "", implyingColour, colour, false, true) + "~" + escape(implication.ImplyingPattern, ' ')
+	} else {
+		implying = formatTagValueName(implication.ImplyingTag.Name, implication.ImplyingValue.Name, implyingColour, colour, false, true)
+	}
+	implied := formatTagValueName(implication.ImpliedTag.Name, implication.ImpliedValue.Name, tagColour(implication.ImpliedTag.Id, infos), colour, true, false)
+
+	return implying, implied
+}
+
+func rematerializeImplications(store *storage.Storage, tx *storage.Tx) error {
+	log.Debugf("rematerializing tag implications.")
+
+	count, err := store.RematerializeImplications(tx)
+	if err != nil {
+		return fmt.Errorf("could not rematerialize implications: %v", err)
+	}
+
+	log.Infof("materialized %v implied file tag(s)", count)
+
+	return nil
+}
+
+type implicationEdge struct {
+	from       string
+	to         string
+	transitive bool
+}
+
+func graphImplications(store *storage.Storage, tx *storage.Tx, asJson, closure bool) error {
+	log.Debugf("retrieving tag implications.")
 
-		if length > width {
-			width = length
+	implications, err := store.Implications(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve implications: %v", err)
+	}
+
+	edges := make([]implicationEdge, 0, len(implications))
+	seen := make(map[implicationEdge]bool, len(implications))
+
+	addEdge := func(from, to string, transitive bool) {
+		key := implicationEdge{from, to, false}
+		if seen[key] {
+			return
 		}
+
+		seen[key] = true
+		edges = append(edges, implicationEdge{from, to, transitive})
 	}
 
-	if len(implications) > 0 {
+	for _, implication := range implications {
+		addEdge(implyingName(*implication), impliedName(*implication), false)
+	}
+
+	if closure {
+		log.Debugf("calculating transitive implications.")
+
 		for _, implication := range implications {
-			paddingWidth := width - len(implication.ImplyingTag.Name)
-			if implication.ImplyingValue.Id != 0 {
-				paddingWidth -= 1 + len(implication.ImplyingValue.Name)
+			transitiveImplications, err := store.ImplicationsFor(tx, implication.ImpliedTagValuePair())
+			if err != nil {
+				return fmt.Errorf("could not retrieve implications for '%v': %v", impliedName(*implication), err)
 			}
-			padding := strings.Repeat(" ", paddingWidth)
 
-			implying := formatTagValueName(implication.ImplyingTag.Name, implication.ImplyingValue.Name, colour, false, true)
-			implied := formatTagValueName(implication.ImpliedTag.Name, implication.ImpliedValue.Name, colour, true, false)
+			for _, transitiveImplication := range transitiveImplications {
+				addEdge(implyingName(*implication), impliedName(*transitiveImplication), true)
+			}
+		}
+	}
 
-			fmt.Printf("%s%s -> %s\n", padding, implying, implied)
+	if asJson {
+		return printImplicationsJson(edges)
+	}
+
+	printImplicationsDot(edges)
+
+	return nil
+}
+
+func printImplicationsDot(edges []implicationEdge) {
+	fmt.Println("digraph implications {")
+
+	for _, edge := range edges {
+		if edge.transitive {
+			fmt.Printf("    %v -> %v [style=dashed];\n", strconv.Quote(edge.from), strconv.Quote(edge.to))
+		} else {
+			fmt.Printf("    %v -> %v;\n", strconv.Quote(edge.from), strconv.Quote(edge.to))
 		}
 	}
 
+	fmt.Println("}")
+}
+
+func printImplicationsJson(edges []implicationEdge) error {
+	adjacency := make(map[string][]string)
+	for _, edge := range edges {
+		adjacency[edge.from] = append(adjacency[edge.from], edge.to)
+	}
+
+	for _, implied := range adjacency {
+		sort.Strings(implied)
+	}
+
+	data, err := json.MarshalIndent(adjacency, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode implications: %v", err)
+	}
+
+	fmt.Println(string(data))
+
 	return nil
 }
 
-func addImplications(store *storage.Storage, tx *storage.Tx, tagArgs []string) (error, warnings) {
-	log.Infof(2, "loading settings")
+func implyingName(implication entities.Implication) string {
+	switch {
+	case implication.ImplyingPattern != "":
+		return implication.ImplyingTag.Name + "~" + implication.ImplyingPattern
+	case implication.ImplyingValue.Id != 0:
+		return implication.ImplyingTag.Name + "=" + implication.ImplyingValue.Name
+	default:
+		return implication.ImplyingTag.Name
+	}
+}
+
+func impliedName(implication entities.Implication) string {
+	if implication.ImpliedValue.Id != 0 {
+		return implication.ImpliedTag.Name + "=" + implication.ImpliedValue.Name
+	}
+
+	return implication.ImpliedTag.Name
+}
+
+func addImplications(store *storage.Storage, tx *storage.Tx, tagArgs []string, pattern bool) (error, warnings) {
+	log.Debugf("loading settings")
 
 	settings, err := store.Settings(tx)
 	if err != nil {
@@ -134,38 +307,54 @@ func addImplications(store *storage.Storage, tx *storage.Tx, tagArgs []string) (
 	impliedTagArgs := tagArgs[1:]
 
 	implyingTagName, implyingValueName := parseTagEqValueName(implyingTagArg)
+	if pattern && implyingValueName == "" {
+		return fmt.Errorf("pattern must be specified as TAG=PATTERN"), nil
+	}
 
 	implyingTag, err := store.TagByName(tx, implyingTagName)
 	if err != nil {
 		return err, nil
 	}
 	if implyingTag == nil {
+		if !settings.PermitsNewTag(implyingTagName) {
+			return closedVocabularyError(store, tx, implyingTagName), nil
+		}
+
 		if settings.AutoCreateTags() {
 			implyingTag, err = createTag(store, tx, implyingTagName)
 			if err != nil {
 				return err, nil
 			}
 		} else {
-			return NoSuchTagError{implyingTagName}, nil
+			return noSuchTagError(store, tx, implyingTagName), nil
 		}
 	}
 
-	implyingValue, err := store.ValueByName(tx, implyingValueName)
-	if err != nil {
-		return err, nil
-	}
-	if implyingValue == nil {
-		if settings.AutoCreateValues() {
-			implyingValue, err = createValue(store, tx, implyingValueName)
-			if err != nil {
-				return err, nil
+	warnings := make(warnings, 0, 10)
+
+	var implyingValue *entities.Value
+	if !pattern {
+		implyingValueName, warnings, err = canonicalValueName(store, tx, settings, implyingTag.Id, implyingTagArg, implyingValueName, warnings)
+		if err != nil {
+			return fmt.Errorf("cannot imply from %v", err), warnings
+		}
+
+		implyingValue, err = store.ValueByName(tx, implyingValueName)
+		if err != nil {
+			return err, warnings
+		}
+		if implyingValue == nil {
+			if settings.AutoCreateValues() {
+				implyingValue, err = createValue(store, tx, implyingValueName)
+				if err != nil {
+					return err, warnings
+				}
+			} else {
+				return NoSuchValueError{implyingValueName}, warnings
 			}
-		} else {
-			return NoSuchValueError{implyingValueName}, nil
 		}
 	}
 
-	warnings := make(warnings, 0, 10)
 	for _, impliedTagArg := range impliedTagArgs {
 		impliedTagName, impliedValueName := parseTagEqValueName(impliedTagArg)
 
@@ -174,17 +363,30 @@ func addImplications(store *storage.Storage, tx *storage.Tx, tagArgs []string) (
 			return err, warnings
 		}
 		if impliedTag == nil {
+			if !settings.PermitsNewTag(impliedTagName) {
+				return closedVocabularyError(store, tx, impliedTagName), warnings
+			}
+
 			if settings.AutoCreateTags() {
 				impliedTag, err = createTag(store, tx, impliedTagName)
 				if err != nil {
 					return err, warnings
 				}
 			} else {
-				warnings = append(warnings, fmt.Sprintf("no such tag '%v'", impliedTagName))
+				warnings = append(warnings, noSuchTagWarning(noSuchTagError(store, tx, impliedTagName)))
 				continue
 			}
 		}
 
+		impliedValueName, warnings, err = canonicalValueName(store, tx, settings, impliedTag.Id, impliedTagArg, impliedValueName, warnings)
+		if err != nil {
+			return fmt.Errorf("cannot imply %v", err), warnings
+		}
+
+		if err := store.ValidateTagValue(tx, impliedTag.Id, impliedValueName); err != nil {
+			return fmt.Errorf("cannot imply '%v': %v", impliedTagArg, err), warnings
+		}
+
 		impliedValue, err := store.ValueByName(tx, impliedValueName)
 		if err != nil {
 			return err, warnings
@@ -196,14 +398,20 @@ func addImplications(store *storage.Storage, tx *storage.Tx, tagArgs []string) (
 					return err, warnings
 				}
 			} else {
-				warnings = append(warnings, fmt.Sprintf("no such value '%v'", impliedValueName))
+				warnings = append(warnings, noSuchTagWarning(NoSuchValueError{impliedValueName}))
 				continue
 			}
 		}
 
-		log.Infof(2, "adding tag implication of '%v' to '%v'", implyingTagArg, impliedTagArg)
+		log.Debugf("adding tag implication of '%v' to '%v'", implyingTagArg, impliedTagArg)
 
-		if err = store.AddImplication(tx, entities.TagIdValueIdPair{implyingTag.Id, implyingValue.Id}, entities.TagIdValueIdPair{impliedTag.Id, impliedValue.Id}); err != nil {
+		impliedPair := entities.TagIdValueIdPair{impliedTag.Id, impliedValue.Id}
+		if pattern {
+			err = store.AddPatternImplication(tx, implyingTag.Id, implyingValueName, impliedPair)
+		} else {
+			err = store.AddImplication(tx, entities.TagIdValueIdPair{implyingTag.Id, implyingValue.Id}, impliedPair)
+		}
+		if err != nil {
 			return fmt.Errorf("cannot add implication of '%v' to '%v': %v", implyingTagArg, impliedTagArg, err), warnings
 		}
 	}
@@ -211,8 +419,8 @@ func addImplications(store *storage.Storage, tx *storage.Tx, tagArgs []string) (
 	return nil, warnings
 }
 
-func deleteImplications(store *storage.Storage, tx *storage.Tx, tagArgs []string) (error, warnings) {
-	log.Infof(2, "loading settings")
+func deleteImplications(store *storage.Storage, tx *storage.Tx, tagArgs []string, pattern bool) (error, warnings) {
+	log.Debugf("loading settings")
 
 	implyingTagArg := tagArgs[0]
 	impliedTagArgs := tagArgs[1:]
@@ -224,20 +432,23 @@ func deleteImplications(store *storage.Storage, tx *storage.Tx, tagArgs []string
 		return err, nil
 	}
 	if implyingTag == nil {
-		return NoSuchTagError{implyingTagName}, nil
+		return noSuchTagError(store, tx, implyingTagName), nil
 	}
 
-	implyingValue, err := store.ValueByName(tx, implyingValueName)
-	if err != nil {
-		return err, nil
-	}
-	if implyingValue == nil {
-		return NoSuchValueError{implyingValueName}, nil
+	var implyingValue *entities.Value
+	if !pattern {
+		implyingValue, err = store.ValueByName(tx, implyingValueName)
+		if err != nil {
+			return err, nil
+		}
+		if implyingValue == nil {
+			return NoSuchValueError{implyingValueName}, nil
+		}
 	}
 
 	warnings := make(warnings, 0, 10)
 	for _, impliedTagArg := range impliedTagArgs {
-		log.Infof(2, "removing tag implication %v -> %v.", implyingTagArg, impliedTagArg)
+		log.Debugf("removing tag implication %v -> %v.", implyingTagArg, impliedTagArg)
 
 		impliedTagName, impliedValueName := parseTagEqValueName(impliedTagArg)
 
@@ -246,7 +457,7 @@ func deleteImplications(store *storage.Storage, tx *storage.Tx, tagArgs []string
 			return err, warnings
 		}
 		if impliedTag == nil {
-			warnings = append(warnings, fmt.Sprintf("no such tag '%v'", impliedTagName))
+			warnings = append(warnings, noSuchTagWarning(noSuchTagError(store, tx, impliedTagName)))
 		}
 
 		impliedValue, err := store.ValueByName(tx, impliedValueName)
@@ -254,10 +465,16 @@ func deleteImplications(store *storage.Storage, tx *storage.Tx, tagArgs []string
 			return err, warnings
 		}
 		if impliedValue == nil {
-			warnings = append(warnings, fmt.Sprintf("no such value '%v'", impliedValueName))
+			warnings = append(warnings, noSuchTagWarning(NoSuchValueError{impliedValueName}))
 		}
 
-		if err := store.DeleteImplication(tx, entities.TagIdValueIdPair{implyingTag.Id, implyingValue.Id}, entities.TagIdValueIdPair{impliedTag.Id, impliedValue.Id}); err != nil {
+		impliedPair := entities.TagIdValueIdPair{impliedTag.Id, impliedValue.Id}
+		if pattern {
+			err = store.DeletePatternImplication(tx, implyingTag.Id, implyingValueName, impliedPair)
+		} else {
+			err = store.DeleteImplication(tx, entities.TagIdValueIdPair{implyingTag.Id, implyingValue.Id}, impliedPair)
+		}
+		if err != nil {
 			return fmt.Errorf("could not delete tag implication of %v to %v: %v", implyingTagArg, impliedTagArg, err), warnings
 		}
 	}
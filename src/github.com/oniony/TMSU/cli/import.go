@@ -0,0 +1,242 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/importer"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/common/ontology"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage"
+	"os"
+	"strings"
+)
+
+var ImportCommand = Command{
+	Name:     "import",
+	Synopsis: "Import taggings from other tools",
+	Usages:   []string{"tmsu import --from=TOOL [OPTION]... PATH"},
+	Description: `Reads the tags applied to files by another tagging tool and applies equivalent tags and values in TMSU.
+
+The following tools are supported, with PATH being:
+
+  tagspaces  a directory tree whose files have TagSpaces sidecar files
+             ('.ts/FILE.json') or tags in their names ('FILE[TAG...].EXT')
+  shotwell   the Shotwell database, e.g. ~/.local/share/shotwell/data/photo.db
+  digikam    the digiKam database, e.g. ~/Pictures/digikam4.db
+  beets      the beets library, e.g. ~/.config/beets/library.db
+
+Tags nested within other tags, as by Shotwell and digiKam, are imported by their own name with each implying its parent (see the 'imply' subcommand). Ratings are imported as the tag 'rating' with the number of stars as its value.
+
+The beets fields album, artist, genre, title and year are imported as value tags, subject to the 'metadataMapping' setting as for the 'extract' subcommand. The --map option renames an imported tag, or beets field, FROM to TO, or skips it where TO is empty.
+
+Files that no longer exist are skipped with a warning. Tags whose names are not valid tag names are likewise skipped.`,
+	Examples: []string{"$ tmsu import --from=tagspaces ~/Documents",
+		"$ tmsu import --from=shotwell ~/.local/share/shotwell/data/photo.db",
+		"$ tmsu import --from=digikam --map=rating:stars ~/Pictures/digikam4.db",
+		`$ tmsu import --from=beets --list ~/.config/beets/library.db
+/music/song.mp3: album=Pastel\ Blues artist=Nina\ Simone year=1965`},
+	Options: Options{{"--from", "-f", "the tool to import from: tagspaces, shotwell, digikam or beets", true, ""},
+		{"--map", "-m", "import tag (or beets field) FROM as TO (FROM:TO)", true, ""},
+		{"--list", "-l", "list the taggings without applying them", false, ""},
+		{"--explicit", "-e", "explicitly apply tags even if they are already implied", false, ""}},
+	Exec: importExec,
+}
+
+// unexported
+
+func importExec(options Options, args []string, databasePath string) (error, warnings) {
+	if !options.HasOption("--from") {
+		return fmt.Errorf("the tool to import from must be specified with --from"), nil
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("a single path must be specified"), nil
+	}
+
+	tool := options.Get("--from").Argument
+	list := options.HasOption("--list")
+	explicit := options.HasOption("--explicit")
+
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	tx, err := store.Begin()
+	if err != nil {
+		return err, nil
+	}
+	defer tx.Commit()
+
+	log.Debugf("loading settings")
+
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return err, nil
+	}
+
+	mapping := make(map[string]string)
+	if tool == importer.Beets {
+		if mapping, err = parseMetadataMapping(settings.MetadataMapping()); err != nil {
+			return fmt.Errorf("invalid 'metadataMapping' setting: %v", err), nil
+		}
+	}
+
+	for _, option := range options {
+		if option.LongName != "--map" {
+			continue
+		}
+
+		overrides, err := parseMetadataMapping(option.Argument)
+		if err != nil {
+			return err, nil
+		}
+
+		for from, to := range overrides {
+			mapping[from] = to
+		}
+	}
+
+	log.Debugf("%v: reading %v taggings", args[0], tool)
+
+	library, err := importer.Read(tool, args[0], storage.OpenForeignDatabase)
+	if err != nil {
+		return err, nil
+	}
+
+	library = mapLibrary(library, mapping, tool == importer.Beets)
+
+	if list {
+		listImportedTaggings(library)
+		return nil, nil
+	}
+
+	return importLibrary(store, tx, settings, library, explicit)
+}
+
+// Renames the tags of the library according to the mapping, skipping those
+// mapped to nothing and, if exclusive, those that are not mapped.
+func mapLibrary(library *importer.Library, mapping map[string]string, exclusive bool) *importer.Library {
+	mapName := func(name string) (string, bool) {
+		mapped, ok := mapping[name]
+		switch {
+		case !ok && exclusive:
+			return "", false
+		case !ok:
+			return name, true
+		}
+
+		return mapped, mapped != ""
+	}
+
+	mapped := &importer.Library{}
+	for _, tagging := range library.Taggings {
+		mappedTagging := importer.Tagging{Path: tagging.Path}
+		for _, tag := range tagging.Tags {
+			if name, ok := mapName(tag.Name); ok {
+				mappedTagging.Tags = append(mappedTagging.Tags, importer.Tag{name, tag.Value})
+			}
+		}
+
+		mapped.Taggings = append(mapped.Taggings, mappedTagging)
+	}
+
+	for _, relation := range library.Hierarchy {
+		broader, broaderOk := mapName(relation.Broader)
+		narrower, narrowerOk := mapName(relation.Narrower)
+		if broaderOk && narrowerOk {
+			mapped.Hierarchy = append(mapped.Hierarchy, ontology.Relation{broader, narrower})
+		}
+	}
+
+	return mapped
+}
+
+func listImportedTaggings(library *importer.Library) {
+	for _, tagging := range library.Taggings {
+		if len(tagging.Tags) == 0 {
+			continue
+		}
+
+		fmt.Printf("%v: %v\n", escape(tagging.Path, ':'), strings.Join(formatImportedTags(tagging.Tags), " "))
+	}
+}
+
+func formatImportedTags(tags []importer.Tag) []string {
+	formatted := make([]string, len(tags))
+	for index, tag := range tags {
+		formatted[index] = formatTagValueName(tag.Name, tag.Value, "", false, false, false)
+	}
+
+	return formatted
+}
+
+func importLibrary(store *storage.Storage, tx *storage.Tx, settings entities.Settings, library *importer.Library, explicit bool) (error, warnings) {
+	warnings := make(warnings, 0, 10)
+
+	if len(library.Hierarchy) > 0 {
+		log.Debugf("importing %v tag relations", len(library.Hierarchy))
+
+		var err error
+		if warnings, err = importRelations(store, tx, library.Hierarchy, warnings); err != nil {
+			return err, warnings
+		}
+	}
+
+	fingerprints := newFingerprinter(settings, 1)
+
+	for _, tagging := range library.Taggings {
+		if len(tagging.Tags) == 0 {
+			continue
+		}
+
+		log.Debugf("%v: importing tags", tagging.Path)
+
+		tagArgs := make([]string, len(tagging.Tags))
+		for index, tag := range tagging.Tags {
+			tagArgs[index] = escape(tag.Name, '\\', '=')
+			if tag.Value != "" {
+				tagArgs[index] += "=" + escape(tag.Value, '\\', '=')
+			}
+		}
+
+		var pairs entities.TagIdValueIdPairs
+		var err error
+		pairs, warnings, err = parseTagValuePairs(store, tx, settings, tagArgs, warnings)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%v: %v", tagging.Path, err))
+			continue
+		}
+		if len(pairs) == 0 {
+			continue
+		}
+
+		if err := tagPath(store, tx, tagging.Path, pairs, explicit, false, false, false, symlinkFollow, make(directoryGuard), nil, false, fingerprints, nil, settings.ReportDuplicates(), settings.PropagateDuplicateTags()); err != nil {
+			switch {
+			case os.IsPermission(err):
+				warnings = append(warnings, fmt.Sprintf("%v: permission denied", tagging.Path))
+			case os.IsNotExist(err):
+				warnings = append(warnings, fmt.Sprintf("%v: no such file", tagging.Path))
+			default:
+				return err, warnings
+			}
+		}
+	}
+
+	return nil, warnings
+}
@@ -0,0 +1,211 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+)
+
+var IndexCommand = Command{
+	Name:     "index",
+	Synopsis: "Index the contents of files",
+	Usages:   []string{"tmsu index [OPTION]... [FILE]..."},
+	Description: `Indexes the text content of the tagged FILEs, or of every tagged file if none are specified, so that queries can match on it with the 'content:' term, e.g. 'invoice and content:acme'.
+
+Plain text files are indexed directly. The text of other files is extracted with the external commands configured by the 'contentExtractors' setting, which by default uses 'pdftotext', 'docx2txt' and 'odt2txt' where installed. Files for which no text can be extracted are not indexed.
+
+Full-text indexing requires TMSU to have been built with Sqlite3's FTS5 extension.`,
+	Examples: []string{"$ tmsu index",
+		"$ tmsu index invoices/*.pdf",
+		`$ tmsu files "invoice and content:acme"`,
+		`$ tmsu files 'content:"acme corporation"'`},
+	Options: Options{},
+	Exec:    indexExec,
+}
+
+// unexported
+
+// the most text indexed for any one file
+const maxContentSize = 16 * 1024 * 1024
+
+func indexExec(options Options, args []string, databasePath string) (error, warnings) {
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	tx, err := store.Begin()
+	if err != nil {
+		return err, nil
+	}
+	defer tx.Commit()
+
+	if err := store.CreateContentIndex(tx); err != nil {
+		return fmt.Errorf("could not create content index: %v", err), nil
+	}
+
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve settings: %v", err), nil
+	}
+
+	extractors, err := settings.ContentExtractors()
+	if err != nil {
+		return err, nil
+	}
+
+	warnings := make(warnings, 0, 10)
+
+	var files entities.Files
+	if len(args) == 0 {
+		files, err = store.Files(tx, "name")
+		if err != nil {
+			return fmt.Errorf("could not retrieve files: %v", err), nil
+		}
+	} else {
+		files = make(entities.Files, 0, len(args))
+		for _, path := range args {
+			absPath, err := filepath.Abs(path)
+			if err != nil {
+				return fmt.Errorf("%v: could not get absolute path: %v", path, err), warnings
+			}
+
+			file, err := store.FileByPath(tx, absPath)
+			if err != nil {
+				return fmt.Errorf("%v: could not retrieve file: %v", path, err), warnings
+			}
+			if file == nil {
+				warnings = append(warnings, fmt.Sprintf("%v: file is not tagged", path))
+				continue
+			}
+
+			files = append(files, file)
+		}
+	}
+
+	for _, file := range files {
+		if file.IsDir {
+			continue
+		}
+
+		if err := indexFile(store, tx, file, extractors); err != nil {
+			switch {
+			case os.IsNotExist(err):
+				warnings = append(warnings, fmt.Sprintf("%v: no such file", file.Path()))
+			case os.IsPermission(err):
+				warnings = append(warnings, fmt.Sprintf("%v: permission denied", file.Path()))
+			default:
+				warnings = append(warnings, fmt.Sprintf("%v: could not index: %v", file.Path(), err))
+			}
+		}
+	}
+
+	return nil, warnings
+}
+
+func indexFile(store *storage.Storage, tx *storage.Tx, file *entities.File, extractors []entities.ContentExtractor) error {
+	content, err := extractContent(file.Path(), extractors)
+	if err != nil {
+		return err
+	}
+	if content == "" {
+		log.Debugf("%v: no text content", file.Path())
+		return nil
+	}
+
+	log.Debugf("%v: indexing content", file.Path())
+
+	return store.UpdateFileContent(tx, file.Id, content)
+}
+
+// Extracts the text of the file using the extractor configured for its
+// extension or, failing that, directly if it is plain text.
+func extractContent(path string, extractors []entities.ContentExtractor) (string, error) {
+	extension := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	for _, extractor := range extractors {
+		if extractor.Extension == extension {
+			return runContentExtractor(extractor.Command, path)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var buffer bytes.Buffer
+	if _, err := io.CopyN(&buffer, file, maxContentSize); err != nil && err != io.EOF {
+		return "", err
+	}
+
+	if !isText(buffer.Bytes()) {
+		return "", nil
+	}
+
+	return buffer.String(), nil
+}
+
+func runContentExtractor(command, path string) (string, error) {
+	var extractor *exec.Cmd
+	if runtime.GOOS == "windows" {
+		extractor = exec.Command("cmd", "/C", strings.Replace(command, "$1", path, -1))
+	} else {
+		extractor = exec.Command("/bin/sh", "-c", command, "sh", path)
+	}
+
+	var output bytes.Buffer
+	extractor.Stdout = &output
+	if err := extractor.Run(); err != nil {
+		return "", fmt.Errorf("'%v' failed: %v", command, err)
+	}
+
+	if output.Len() > maxContentSize {
+		output.Truncate(maxContentSize)
+	}
+
+	return output.String(), nil
+}
+
+// Whether the data looks like text: valid UTF-8 without NUL characters. A
+// multi-byte character split by truncation is tolerated.
+func isText(data []byte) bool {
+	if bytes.IndexByte(data, 0) != -1 {
+		return false
+	}
+
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size == 1 {
+			return len(data) < utf8.UTFMax && !utf8.FullRune(data)
+		}
+		data = data[size:]
+	}
+
+	return true
+}
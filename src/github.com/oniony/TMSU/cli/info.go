@@ -16,22 +16,73 @@
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
+	"github.com/oniony/TMSU/common/i18n"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/common/terminal"
 	"github.com/oniony/TMSU/common/terminal/ansi"
+	"github.com/oniony/TMSU/entities"
 	"github.com/oniony/TMSU/storage"
 	"math"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 var InfoCommand = Command{
-	Name:        "info",
-	Synopsis:    "Show database information",
-	Usages:      []string{"tmsu info"},
-	Description: "Shows the database information.",
+	Name:     "info",
+	Synopsis: "Show database or file information",
+	Usages:   []string{"tmsu info [OPTION]...", "tmsu info [OPTION]... FILE..."},
+	Description: `Shows the database information or, if FILEs are specified, a report for each file.
+
+With --value the values applied with TAG are summarised: the least and greatest values, ordered according to the tag's type (see the 'tag-def' subcommand), their mean if they are all numbers and a histogram of the number of files tagged with each value.
+
+With --history the number of tags, values, files and taggings recorded at the end of each day on which the database was changed are listed, oldest first, so that the growth of the collection and its vocabulary can be followed. With --sparkline each is instead summarised as a sparkline. The statistics are recorded whenever a change is made to the database, so days on which it was not changed are omitted.
+
+The file report lists the file's explicit tags, its implied tags along with the chain of implications responsible for each, the fingerprint, size and modification time recorded in the database, the database identifier and whether the file on disk has since been modified or is missing.`,
+	Examples: []string{"$ tmsu info --stats",
+		`$ tmsu info mountain.jpg
+Path: /home/bob/mountain.jpg
+Id: 12
+Size: 1048576
+Modified: 2017-06-14 09:31:20
+Fingerprint: 1c1bdd3a3c7fe4a0b4e9fa2b1eb8aa3d9e3ab4f1b5d4bd5ac5f2b1bcbd4a8a55
+Status: unmodified
+Explicit tags: holiday landscape
+Implied tag: photo (landscape -> photo)`,
+		"$ tmsu info --json mountain.jpg",
+		`$ tmsu stats --history
+Date        Tags  Values  Files  Taggings
+2017-06-12    10       2     40        95
+2017-06-14    12       4     52       130`,
+		`$ tmsu stats --history --sparkline
+Tags: ▁█ (10 to 12)
+Values: ▁█ (2 to 4)
+Files: ▁█ (40 to 52)
+Taggings: ▁█ (95 to 130)`,
+		`$ tmsu stats --value rating
+Tag: rating
+Taggings: 12
+Values: 4
+Minimum: 2
+Maximum: 5
+Mean: 3.75
+
+  2  #####                 1
+  3  ####################  4
+  4  ####################  4
+  5  ###############       3`},
 	Options: Options{
 		Option{"--stats", "-s", "show statistics", false, ""},
-		Option{"--usage", "-u", "show tag usage breakdown", false, ""}},
+		Option{"--usage", "-u", "show tag usage breakdown", false, ""},
+		Option{"--json", "-j", "output the file report as JSON", false, ""},
+		Option{"--value", "", "summarise the values of TAG", true, ""},
+		Option{"--history", "", "show the statistics recorded over time", false, ""},
+		Option{"--sparkline", "", "show the history as sparklines", false, ""}},
 	Exec:    infoExec,
 	Aliases: []string{"stats"},
 }
@@ -58,6 +109,26 @@ func infoExec(options Options, args []string, databasePath string) (error, warni
 	}
 	defer tx.Commit()
 
+	if options.HasOption("--value") {
+		if len(args) > 0 {
+			return fmt.Errorf("--value cannot be used with files"), nil
+		}
+
+		return showValueStatistics(store, tx, options.Get("--value").Argument, colour), nil
+	}
+
+	if options.HasOption("--history") {
+		if len(args) > 0 {
+			return fmt.Errorf("--history cannot be used with files"), nil
+		}
+
+		return showHistory(store, tx, options.HasOption("--sparkline"), colour), nil
+	}
+
+	if len(args) > 0 {
+		return showFileInfo(store, tx, args, options.HasOption("--json"), colour)
+	}
+
 	showBasic(store, tx, colour)
 
 	if stats {
@@ -72,6 +143,7 @@ func infoExec(options Options, args []string, databasePath string) (error, warni
 
 func showBasic(store *storage.Storage, tx *storage.Tx, colour bool) error {
 	printInfo("Database", store.DbPath, colour)
+	printInfo("Backend", store.Backend(), colour)
 	printInfo("Root path", store.RootPath, colour)
 
 	stat, err := os.Stat(store.DbPath)
@@ -105,6 +177,11 @@ func showStatistics(store *storage.Storage, tx *storage.Tx, colour bool) error {
 		return fmt.Errorf("could not retrieve taggings count: %v", err)
 	}
 
+	authorCounts, err := store.FileTagCountsByAuthor(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve taggings count by user: %v", err)
+	}
+
 	var averageTagsPerFile float32
 	if fileCount > 0 {
 		averageTagsPerFile = float32(fileTagCount) / float32(fileCount)
@@ -123,6 +200,18 @@ func showStatistics(store *storage.Storage, tx *storage.Tx, colour bool) error {
 	printInfof("Mean tags per file", "%1.2f", averageTagsPerFile, colour)
 	printInfof("Mean files per tag", "%1.2f", averageFilesPerTag, colour)
 
+	if len(authorCounts) > 1 || len(authorCounts) == 1 && authorCounts[0].Author != "" {
+		fmt.Println()
+		for _, authorCount := range authorCounts {
+			author := authorCount.Author
+			if author == "" {
+				author = i18n.T("(unknown)")
+			}
+
+			printInfo(i18n.Tf("Taggings by %v", author), authorCount.FileTagCount, colour)
+		}
+	}
+
 	return nil
 }
 
@@ -158,6 +247,135 @@ func showUsage(store *storage.Storage, tx *storage.Tx, colour bool) error {
 	return nil
 }
 
+func showHistory(store *storage.Storage, tx *storage.Tx, sparkline, colour bool) error {
+	snapshots, err := store.StatisticsHistory(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve statistics history: %v", err)
+	}
+
+	if sparkline {
+		if len(snapshots) == 0 {
+			return nil
+		}
+
+		for _, series := range []struct {
+			name  string
+			count func(*entities.StatisticsSnapshot) uint
+		}{
+			{"Tags", func(snapshot *entities.StatisticsSnapshot) uint { return snapshot.TagCount }},
+			{"Values", func(snapshot *entities.StatisticsSnapshot) uint { return snapshot.ValueCount }},
+			{"Files", func(snapshot *entities.StatisticsSnapshot) uint { return snapshot.FileCount }},
+			{"Taggings", func(snapshot *entities.StatisticsSnapshot) uint { return snapshot.FileTagCount }},
+		} {
+			counts := make([]uint, len(snapshots))
+			for index, snapshot := range snapshots {
+				counts[index] = series.count(snapshot)
+			}
+
+			printInfo(series.name, fmt.Sprintf("%v (%v to %v)", sparklineFor(counts), counts[0], counts[len(counts)-1]), colour)
+		}
+
+		return nil
+	}
+
+	table := terminal.NewTable(false, true, true, true, true)
+	table.AddRow(i18n.T("Date"), i18n.T("Tags"), i18n.T("Values"), i18n.T("Files"), i18n.T("Taggings"))
+	for _, snapshot := range snapshots {
+		table.AddRow(snapshot.TakenOn.Format("2006-01-02"),
+			strconv.FormatUint(uint64(snapshot.TagCount), 10),
+			strconv.FormatUint(uint64(snapshot.ValueCount), 10),
+			strconv.FormatUint(uint64(snapshot.FileCount), 10),
+			strconv.FormatUint(uint64(snapshot.FileTagCount), 10))
+	}
+	table.Print()
+
+	return nil
+}
+
+// the characters of a sparkline, from lowest to highest
+var sparklineChars = []rune("▁▂▃▄▅▆▇█")
+
+// Draws the counts as a sparkline scaled between the least and greatest.
+func sparklineFor(counts []uint) string {
+	least, greatest := counts[0], counts[0]
+	for _, count := range counts {
+		if count < least {
+			least = count
+		}
+		if count > greatest {
+			greatest = count
+		}
+	}
+
+	line := make([]rune, len(counts))
+	for index, count := range counts {
+		level := 0
+		if greatest > least {
+			level = int((count - least) * uint(len(sparklineChars)-1) / (greatest - least))
+		}
+
+		line[index] = sparklineChars[level]
+	}
+
+	return string(line)
+}
+
+// the width of the longest bar of a histogram
+const histogramWidth = 20
+
+func showValueStatistics(store *storage.Storage, tx *storage.Tx, tagName string, colour bool) error {
+	tag, err := store.TagByName(tx, tagName)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tag '%v': %v", tagName, err)
+	}
+	if tag == nil {
+		return noSuchTagError(store, tx, tagName)
+	}
+
+	statistics, err := store.ValueStatistics(tx, tag.Id)
+	if err != nil {
+		return fmt.Errorf("could not summarise values of tag '%v': %v", tagName, err)
+	}
+
+	printInfo("Tag", tag.Name, colour)
+	printInfo("Taggings", statistics.FileCount(), colour)
+	printInfo("Values", len(statistics.Counts), colour)
+
+	if len(statistics.Counts) == 0 {
+		return nil
+	}
+
+	printInfo("Minimum", statistics.Counts[0].Name, colour)
+	printInfo("Maximum", statistics.Counts[len(statistics.Counts)-1].Name, colour)
+	if statistics.HasMean {
+		printInfof("Mean", "%1.2f", statistics.Mean, colour)
+	}
+
+	nameWidth := 0
+	var maxCount uint
+	for _, count := range statistics.Counts {
+		if len(count.Name) > nameWidth {
+			nameWidth = len(count.Name)
+		}
+		if count.FileCount > maxCount {
+			maxCount = count.FileCount
+		}
+	}
+
+	fmt.Println()
+	for _, count := range statistics.Counts {
+		barLength := int(math.Ceil(float64(count.FileCount) * histogramWidth / float64(maxCount)))
+		bar := strings.Repeat("#", barLength)
+		if colour {
+			bar = ansi.Yellow(bar)
+		}
+
+		fmt.Printf("  %*s  %s%s  %v\n", nameWidth, count.Name, bar, strings.Repeat(" ", histogramWidth-barLength), count.FileCount)
+	}
+
+	return nil
+}
+
 func printInfo(name string, value interface{}, colour bool) {
 	printInfof(name, "%v", value, colour)
 }
@@ -167,5 +385,221 @@ func printInfof(name, format string, value interface{}, colour bool) {
 		format = ansi.Green(format)
 	}
 
-	fmt.Printf("%v: "+format+"\n", name, value)
+	fmt.Printf("%v: "+format+"\n", i18n.T(name), value)
+}
+
+type fileInfo struct {
+	Path         string           `json:"path"`
+	Id           entities.FileId  `json:"id"`
+	Size         int64            `json:"size"`
+	ModTime      time.Time        `json:"modTime"`
+	Fingerprint  string           `json:"fingerprint"`
+	IsDir        bool             `json:"isDir"`
+	Status       string           `json:"status"`
+	ExplicitTags []string         `json:"explicitTags"`
+	ImpliedTags  []impliedTagInfo `json:"impliedTags"`
+}
+
+type impliedTagInfo struct {
+	Tag string   `json:"tag"`
+	Via []string `json:"via"`
+}
+
+func showFileInfo(store *storage.Storage, tx *storage.Tx, paths []string, asJson, colour bool) (error, warnings) {
+	warnings := make(warnings, 0, 10)
+	infos := make([]fileInfo, 0, len(paths))
+
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("%v: could not get absolute path: %v", path, err), warnings
+		}
+
+		if resolvedPath, err := filepath.EvalSymlinks(absPath); err == nil {
+			absPath = resolvedPath
+		}
+
+		log.Debugf("%v: retrieving file", path)
+
+		file, err := store.FileByPath(tx, absPath)
+		if err != nil {
+			return fmt.Errorf("%v: could not retrieve file: %v", path, err), warnings
+		}
+		if file == nil {
+			warnings = append(warnings, fmt.Sprintf("%v: file is not tagged", path))
+			continue
+		}
+
+		info, err := buildFileInfo(store, tx, file, colour && !asJson)
+		if err != nil {
+			return err, warnings
+		}
+
+		infos = append(infos, *info)
+	}
+
+	if asJson {
+		data, err := json.MarshalIndent(infos, "", "  ")
+		if err != nil {
+			return fmt.Errorf("could not encode file information: %v", err), warnings
+		}
+
+		fmt.Println(string(data))
+
+		return nil, warnings
+	}
+
+	for index, info := range infos {
+		if index > 0 {
+			fmt.Println()
+		}
+
+		printFileInfo(info, colour)
+	}
+
+	return nil, warnings
+}
+
+func buildFileInfo(store *storage.Storage, tx *storage.Tx, file *entities.File, colour bool) (*fileInfo, error) {
+	row, err := fileStatus(file.Path(), file)
+	if err != nil {
+		return nil, err
+	}
+
+	status := "unknown"
+	if row != nil {
+		switch row.Status {
+		case TAGGED:
+			status = "unmodified"
+		case MODIFIED:
+			status = "modified"
+		case MISSING:
+			status = "missing"
+		}
+	}
+
+	fileTags, err := store.FileTagsByFileId(tx, file.Id, false)
+	if err != nil {
+		return nil, fmt.Errorf("%v: could not retrieve file-tags: %v", file.Path(), err)
+	}
+
+	// chains of implications, keyed by tag/value, leading from an explicit tag
+	via := make(map[entities.TagIdValueIdPair][]string)
+	queue := make(entities.TagIdValueIdPairs, 0, len(fileTags))
+	explicitTags := make([]string, 0, len(fileTags))
+
+	for _, fileTag := range fileTags {
+		if !fileTag.Explicit {
+			continue
+		}
+
+		name, err := tagValueNameFor(store, tx, fileTag.TagId, fileTag.ValueId)
+		if err != nil {
+			return nil, err
+		}
+
+		pair := fileTag.ToTagIdValueIdPair()
+		via[pair] = []string{name}
+		queue = append(queue, pair)
+		explicitTags = append(explicitTags, colourTagging(name, colour, fileTag.Implicit, true))
+	}
+
+	for len(queue) > 0 {
+		pair := queue[0]
+		queue = queue[1:]
+
+		implications, err := store.DirectImplicationsFor(tx, pair)
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve implications: %v", err)
+		}
+
+		for _, implication := range implications {
+			impliedPair := implication.ImpliedTagValuePair()
+			if _, seen := via[impliedPair]; seen {
+				continue
+			}
+
+			chain := make([]string, len(via[pair]), len(via[pair])+1)
+			copy(chain, via[pair])
+			via[impliedPair] = append(chain, formatTagValueName(implication.ImpliedTag.Name, implication.ImpliedValue.Name, "", false, false, false))
+			queue = append(queue, impliedPair)
+		}
+	}
+
+	impliedTags := make([]impliedTagInfo, 0, len(fileTags))
+	for _, fileTag := range fileTags {
+		if fileTag.Explicit {
+			continue
+		}
+
+		chain, found := via[fileTag.ToTagIdValueIdPair()]
+		if !found {
+			name, err := tagValueNameFor(store, tx, fileTag.TagId, fileTag.ValueId)
+			if err != nil {
+				return nil, err
+			}
+
+			chain = []string{name}
+		}
+
+		name := chain[len(chain)-1]
+		impliedTags = append(impliedTags, impliedTagInfo{colourTagging(name, colour, true, false), chain})
+	}
+
+	ansi.Sort(explicitTags)
+	sort.Slice(impliedTags, func(i, j int) bool { return impliedTags[i].Tag < impliedTags[j].Tag })
+
+	return &fileInfo{file.Path(), file.Id, file.Size, file.ModTime, string(file.Fingerprint), file.IsDir, status, explicitTags, impliedTags}, nil
+}
+
+// Looks up the tag and value names, returning them in TAG=VALUE form.
+func tagValueNameFor(store *storage.Storage, tx *storage.Tx, tagId entities.TagId, valueId entities.ValueId) (string, error) {
+	tag, err := store.Tag(tx, tagId)
+	if err != nil {
+		return "", fmt.Errorf("could not lookup tag: %v", err)
+	}
+	if tag == nil {
+		return "", fmt.Errorf("tag '%v' does not exist", tagId)
+	}
+
+	if valueId == 0 {
+		return formatTagValueName(tag.Name, "", "", false, false, false), nil
+	}
+
+	value, err := store.Value(tx, valueId)
+	if err != nil {
+		return "", fmt.Errorf("could not lookup value: %v", err)
+	}
+	if value == nil {
+		return "", fmt.Errorf("value '%v' does not exist", valueId)
+	}
+
+	return formatTagValueName(tag.Name, value.Name, "", false, false, false), nil
+}
+
+func colourTagging(tagging string, colour, implicit, explicit bool) string {
+	if !colour {
+		return tagging
+	}
+
+	colourCode := colourCodeFor(implicit, explicit)
+	if colourCode == "" {
+		return tagging
+	}
+
+	return colourCode + tagging + ansi.ResetCode
+}
+
+func printFileInfo(info fileInfo, colour bool) {
+	printInfo("Path", info.Path, colour)
+	printInfo("Id", info.Id, colour)
+	printInfo("Size", info.Size, colour)
+	printInfo("Modified", info.ModTime.Local().Format("2006-01-02 15:04:05"), colour)
+	printInfo("Fingerprint", info.Fingerprint, colour)
+	printInfo("Status", info.Status, colour)
+	printInfo("Explicit tags", strings.Join(info.ExplicitTags, " "), colour)
+
+	for _, impliedTag := range info.ImpliedTags {
+		printInfo("Implied tag", impliedTag.Tag+" ("+strings.Join(impliedTag.Via, " -> ")+")", colour)
+	}
 }
@@ -19,28 +19,68 @@ import (
 	"fmt"
 	"github.com/oniony/TMSU/common/log"
 	"github.com/oniony/TMSU/storage"
+	"github.com/oniony/TMSU/storage/database"
 	"os"
+	"os/user"
 	"path/filepath"
+	"strings"
 )
 
 var InitCommand = Command{
 	Name:     "init",
 	Synopsis: "Initializes a new database",
-	Usages:   []string{"tmsu init [PATH]"},
+	Usages:   []string{"tmsu init [OPTION]... [PATH]..."},
 	Description: `Initializes a new local database.
 
 Creates a .tmsu directory under PATH and initialises a new empty database within it.
 
 If no PATH is specified then the current working directory is assumed.
 
-The new database is used automatically whenever TMSU is invoked from a directory under PATH (unless overridden by the global --database option or the TMSU_DB environment variable.`,
-	Options: Options{},
-	Exec:    initExec,
+The new database is used automatically whenever TMSU is invoked from a directory under PATH (unless overridden by the global --database option or the TMSU_DB environment variable.
+
+The --template option bootstraps each new database from a template, so that databases created on different machines start with the same vocabulary. A template is a batch file (see the 'batch' subcommand) that may use only the subcommands that define vocabulary: 'tag --create', 'tag-def', 'imply', 'vocabulary', 'ontology' and 'config' (e.g. for the 'autoTags' setting). Files referenced by a template, such as vocabularies to load, are resolved relative to the working directory. TEMPLATE is the path to the template file or, if there is no such file, the name of a template in '~/.config/tmsu/templates' with or without its '.tmsu' extension.
+
+The database is held by the storage backend named by --backend, which is recorded in the database file itself so need not be specified again. The 'sqlite' backend is the default. The 'json' backend keeps the same Sqlite database in a plain JSON file instead, which can be read, compared and kept under version control with everyday tools. It is not an alternative to Sqlite, which still runs every query upon an in-memory copy of the database, and the file is rewritten in full by every change so it suits only small databases. '--backend help' lists the backends available in this build.`,
+	Examples: []string{"$ tmsu init",
+		"$ tmsu init /mnt/photos",
+		"$ tmsu init --backend json",
+		`$ cat ~/.config/tmsu/templates/photography.tmsu
+tag --create photo raw camera lens
+tag-def iso integer
+tag-def rating enum poor fair good excellent
+imply raw photo
+config autoTags='*.jpg:photo,*.cr2:raw'
+$ tmsu init --template photography /mnt/photos`},
+	Options: Options{{"--backend", "-b", "the storage backend to hold the database", true, ""},
+		{"--template", "-t", "bootstraps the database from the TEMPLATE file", true, ""}},
+	Exec: initExec,
 }
 
 // unexported
 
 func initExec(options Options, args []string, databasePath string) (error, warnings) {
+	backend := database.DefaultBackend
+	if options.HasOption("--backend") {
+		backend = options.Get("--backend").Argument
+	}
+
+	if backend == "help" {
+		for _, name := range storage.Backends() {
+			fmt.Println(name)
+		}
+
+		return nil, nil
+	}
+
+	var templateLines []batchLine
+	if options.HasOption("--template") {
+		var err error
+		templateLines, err = readTemplate(options.Get("--template").Argument)
+		if err != nil {
+			return err, nil
+		}
+	}
+
 	paths := args
 
 	if len(paths) == 0 {
@@ -54,15 +94,28 @@ func initExec(options Options, args []string, databasePath string) (error, warni
 
 	warnings := make(warnings, 0, 10)
 	for _, path := range paths {
-		if err := initializeDatabase(path); err != nil {
+		if err := initializeDatabase(path, backend); err != nil {
 			warnings = append(warnings, fmt.Sprintf("%v: could not initialize database: %v", path, err))
+			continue
+		}
+
+		if templateLines != nil {
+			dbPath := filepath.Join(path, ".tmsu", "db")
+
+			err, templateWarnings := executeBatch(templateLines, Options{}, dbPath)
+			for _, warning := range templateWarnings {
+				warnings = append(warnings, fmt.Sprintf("%v: template %v", path, warning))
+			}
+			if err != nil {
+				warnings = append(warnings, fmt.Sprintf("%v: could not apply template: %v", path, err))
+			}
 		}
 	}
 
 	return nil, warnings
 }
 
-func initializeDatabase(path string) error {
+func initializeDatabase(path, backend string) error {
 	log.Warnf("%v: creating database", path)
 
 	tmsuPath := filepath.Join(path, ".tmsu")
@@ -70,5 +123,57 @@ func initializeDatabase(path string) error {
 
 	dbPath := filepath.Join(tmsuPath, "db")
 
-	return storage.CreateAt(dbPath)
+	return storage.CreateAt(dbPath, backend)
+}
+
+// the subcommands that may be used within a template
+var templateCommands = []string{"config", "imply", "ontology", "tag", "tag-def", "vocabulary"}
+
+func readTemplate(name string) ([]batchLine, error) {
+	path, err := templatePath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open template: %v", err)
+	}
+	defer file.Close()
+
+	lines, err := readBatch(file)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+
+	for _, line := range lines {
+		if !containsString(templateCommands, line.command.Name) {
+			return nil, fmt.Errorf("%v: line %v: the '%v' subcommand cannot be used within a template", path, line.number, line.command.Name)
+		}
+		if line.command.Name == "tag" && !line.options.HasOption("--create") {
+			return nil, fmt.Errorf("%v: line %v: the 'tag' subcommand can only be used with --create within a template", path, line.number)
+		}
+	}
+
+	return lines, nil
+}
+
+func templatePath(name string) (string, error) {
+	if _, err := os.Stat(name); err == nil || strings.ContainsRune(name, filepath.Separator) {
+		return name, nil
+	}
+
+	u, err := user.Current()
+	if err != nil {
+		return name, nil
+	}
+
+	templatesDir := filepath.Join(u.HomeDir, ".config", "tmsu", "templates")
+	for _, path := range []string{filepath.Join(templatesDir, name), filepath.Join(templatesDir, name+".tmsu")} {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+
+	return "", fmt.Errorf("no such template '%v'", name)
 }
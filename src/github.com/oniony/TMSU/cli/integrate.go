@@ -0,0 +1,339 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+var IntegrateCommand = Command{
+	Name:     "integrate",
+	Synopsis: "Add TMSU actions to a file manager",
+	Usages:   []string{"tmsu integrate [OPTION]... gnome|kde|thunar"},
+	Description: `Installs context-menu actions into the file manager for tagging the selected files and showing their tags. The actions run the 'dialog' subcommand, which requires zenity or kdialog to be installed.
+
+  gnome   Nautilus scripts, under Scripts in the context menu
+  kde     a Dolphin service menu
+  thunar  custom actions in Thunar's uca.xml
+
+The actions find the database from the directory of the selected files unless --database is specified, in which case they always use that database.
+
+Run again with --remove to uninstall the actions.`,
+	Examples: []string{"$ tmsu integrate gnome",
+		"$ tmsu --database=~/.tmsu/photos.db integrate kde",
+		"$ tmsu integrate --remove thunar"},
+	Options: Options{{"--remove", "-r", "remove the actions instead", false, ""}},
+	Exec:    integrateExec,
+}
+
+// unexported
+
+// A file manager action.
+type fileManagerAction struct {
+	id          string
+	name        string
+	description string
+	icon        string
+	dialog      string
+}
+
+var fileManagerActions = []fileManagerAction{
+	{"tmsu-tag", "Tag with TMSU…", "Apply tags to the selected files", "tag", "tag"},
+	{"tmsu-tags", "Show TMSU tags", "Show the tags of the selected files", "dialog-information", "tags"},
+}
+
+func integrateExec(options Options, args []string, databasePath string) (error, warnings) {
+	if len(args) < 1 {
+		return fmt.Errorf("file manager to integrate with must be specified"), nil
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("too many arguments"), nil
+	}
+
+	remove := options.HasOption("--remove")
+
+	command := []string{}
+	if !remove {
+		executablePath, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("could not identify tmsu executable: %v", err), nil
+		}
+
+		command = append(command, executablePath)
+
+		if options.HasOption("--database") {
+			absDatabasePath, err := filepath.Abs(databasePath)
+			if err != nil {
+				return fmt.Errorf("%v: could not get absolute path: %v", databasePath, err), nil
+			}
+
+			command = append(command, "--database="+absDatabasePath)
+		}
+
+		command = append(command, "dialog")
+	}
+
+	switch args[0] {
+	case "gnome", "nautilus":
+		return integrateNautilus(command, remove), nil
+	case "kde", "dolphin":
+		return integrateDolphin(command, remove), nil
+	case "thunar", "xfce":
+		return integrateThunar(command, remove), nil
+	}
+
+	return fmt.Errorf("unsupported file manager '%v': expected gnome, kde or thunar", args[0]), nil
+}
+
+func integrateNautilus(command []string, remove bool) error {
+	scriptDir, err := xdgDir("XDG_DATA_HOME", ".local/share", "nautilus", "scripts")
+	if err != nil {
+		return err
+	}
+
+	for _, action := range fileManagerActions {
+		scriptPath := filepath.Join(scriptDir, action.name)
+
+		if remove {
+			if err := removeIfExists(scriptPath); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		// Nautilus passes the selected files as arguments relative to the
+		// directory shown, which is the working directory of the script
+		script := fmt.Sprintf(`#!/bin/sh
+# Installed by 'tmsu integrate gnome'.
+exec %v %v "$@"
+`, shellQuoteArgs(command), action.dialog)
+
+		if err := writeIntegrationFile(scriptPath, script, 0755); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func integrateDolphin(command []string, remove bool) error {
+	menuDir, err := xdgDir("XDG_DATA_HOME", ".local/share", "kio", "servicemenus")
+	if err != nil {
+		return err
+	}
+
+	menuPath := filepath.Join(menuDir, "tmsu.desktop")
+
+	if remove {
+		return removeIfExists(menuPath)
+	}
+
+	ids := make([]string, len(fileManagerActions))
+	for index, action := range fileManagerActions {
+		ids[index] = action.id
+	}
+
+	menu := fmt.Sprintf(`# Installed by 'tmsu integrate kde'.
+[Desktop Entry]
+Type=Service
+MimeType=all/all;
+Actions=%v;
+X-KDE-Submenu=TMSU
+`, strings.Join(ids, ";"))
+
+	for _, action := range fileManagerActions {
+		menu += fmt.Sprintf(`
+[Desktop Action %v]
+Name=%v
+Icon=%v
+Exec=%v %v %%F
+`, action.id, action.name, action.icon, desktopQuoteArgs(command), action.dialog)
+	}
+
+	// service menus are only run if they are executable
+	return writeIntegrationFile(menuPath, menu, 0755)
+}
+
+func integrateThunar(command []string, remove bool) error {
+	configDir, err := xdgDir("XDG_CONFIG_HOME", ".config", "Thunar")
+	if err != nil {
+		return err
+	}
+
+	actionsPath := filepath.Join(configDir, "uca.xml")
+
+	content, err := ioutil.ReadFile(actionsPath)
+	switch {
+	case os.IsNotExist(err):
+		if remove {
+			return nil
+		}
+
+		content = []byte("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<actions>\n</actions>\n")
+	case err != nil:
+		return fmt.Errorf("%v: could not read file: %v", actionsPath, err)
+	}
+
+	actions := removeThunarActions(string(content))
+
+	if !remove {
+		if actions, err = addThunarActions(actions, command); err != nil {
+			return fmt.Errorf("%v: %v", actionsPath, err)
+		}
+	}
+
+	return writeIntegrationFile(actionsPath, actions, 0644)
+}
+
+// Removes the TMSU actions from the content of a Thunar uca.xml file.
+func removeThunarActions(content string) string {
+	var builder strings.Builder
+
+	for {
+		start := strings.Index(content, "<action>")
+		if start == -1 {
+			break
+		}
+
+		length := strings.Index(content[start:], "</action>")
+		if length == -1 {
+			break
+		}
+		end := start + length + len("</action>")
+
+		action := content[start:end]
+		if !strings.Contains(action, "<unique-id>tmsu-") {
+			builder.WriteString(content[:end])
+		} else {
+			builder.WriteString(strings.TrimRight(content[:start], " \t"))
+			end += len(content[end:]) - len(strings.TrimLeft(content[end:], "\r\n"))
+		}
+
+		content = content[end:]
+	}
+
+	builder.WriteString(content)
+
+	return builder.String()
+}
+
+// Adds the TMSU actions to the content of a Thunar uca.xml file.
+func addThunarActions(content string, command []string) (string, error) {
+	end := strings.LastIndex(content, "</actions>")
+	if end == -1 {
+		return "", fmt.Errorf("no actions element")
+	}
+
+	var builder strings.Builder
+	builder.WriteString(content[:end])
+
+	for _, action := range fileManagerActions {
+		builder.WriteString("<action>\n")
+		writeXmlElement(&builder, "icon", action.icon)
+		writeXmlElement(&builder, "name", action.name)
+		writeXmlElement(&builder, "unique-id", action.id)
+		writeXmlElement(&builder, "command", shellQuoteArgs(command)+" "+action.dialog+" %F")
+		writeXmlElement(&builder, "description", action.description)
+		writeXmlElement(&builder, "patterns", "*")
+		builder.WriteString("\t<directories/>\n\t<audio-files/>\n\t<image-files/>\n\t<other-files/>\n\t<text-files/>\n\t<video-files/>\n</action>\n")
+	}
+
+	builder.WriteString(content[end:])
+
+	return builder.String(), nil
+}
+
+func writeXmlElement(builder *strings.Builder, name, text string) {
+	builder.WriteString("\t<" + name + ">")
+	xml.EscapeText(builder, []byte(text))
+	builder.WriteString("</" + name + ">\n")
+}
+
+// Quotes the arguments for the shell, where necessary.
+func shellQuoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for index, arg := range args {
+		if strings.ContainsAny(arg, " \t\n'\"\\$`&|;<>()*?[]{}~#!") {
+			arg = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+		}
+
+		quoted[index] = arg
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// Quotes the arguments for the Exec key of a desktop entry, where necessary.
+func desktopQuoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for index, arg := range args {
+		if strings.ContainsAny(arg, " \t\n'\"\\$`&|;<>()*?[]{}~#!%=") {
+			arg = `"` + strings.NewReplacer(`"`, `\"`, "`", "\\`", "$", `\$`, `\`, `\\`).Replace(arg) + `"`
+		}
+
+		// the value itself is unescaped before the arguments are split
+		quoted[index] = strings.NewReplacer(`\`, `\\`, "%", "%%").Replace(arg)
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// Returns the XDG base directory from the environment variable, or its
+// default under the home directory, joined with the path elements.
+func xdgDir(variable, fallback string, elements ...string) (string, error) {
+	baseDir := os.Getenv(variable)
+	if baseDir == "" {
+		u, err := user.Current()
+		if err != nil {
+			return "", fmt.Errorf("could not identify current user: %v", err)
+		}
+
+		baseDir = filepath.Join(u.HomeDir, fallback)
+	}
+
+	return filepath.Join(append([]string{baseDir}, elements...)...), nil
+}
+
+func writeIntegrationFile(path, content string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("%v: could not create directory: %v", filepath.Dir(path), err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(content), mode); err != nil {
+		return fmt.Errorf("%v: could not write file: %v", path, err)
+	}
+
+	if err := os.Chmod(path, mode); err != nil {
+		return fmt.Errorf("%v: could not set permissions: %v", path, err)
+	}
+
+	return nil
+}
+
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("%v: could not remove file: %v", path, err)
+	}
+
+	return nil
+}
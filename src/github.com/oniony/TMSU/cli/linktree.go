@@ -0,0 +1,336 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var LinkTreeCommand = Command{
+	Name:     "link-tree",
+	Synopsis: "Build a directory tree of symbolic links to tagged files",
+	Usages:   []string{"tmsu link-tree [OPTION]... DEST [QUERY]"},
+	Description: `Builds a tree of symbolic links to the tagged files under the directory DEST, as an alternative to the virtual filesystem where mounting is not possible, such as within containers, on Windows or on a directory exported over NFS.
+
+Without a QUERY the tree mirrors the tag structure: each tag has a directory holding links to the files with that tag, within which each of the tag's values has a directory holding links to the files with that value. With a QUERY the links to the matching files are placed directly in DEST.
+
+Links are named after the file with its ID before the extension, as in the virtual filesystem, so that files sharing a name do not collide. Slashes within tag and value names are replaced with similar looking Unicode characters.
+
+Running the command again refreshes the tree incrementally: links to files that no longer belong are removed, along with any directories left empty, and links for new files are added. Other files within DEST are left alone. A new DEST must be empty: a '.tmsu-link-tree' file is created to mark it as a link tree so that later refreshes may modify it.
+
+To keep the tree current, run the command from a 'post-tag' and 'post-untag' hook. See the 'config' subcommand for more information.`,
+	Examples: []string{"$ tmsu link-tree ~/tags",
+		"$ tmsu link-tree ~/holiday 'holiday and year = 2017'",
+		"$ tmsu config hooks='post-tag:tmsu link-tree ~/tags,post-untag:tmsu link-tree ~/tags'"},
+	Options: Options{Option{"--explicit", "-e", "link only explicitly tagged files", false, ""}},
+	Exec:    linkTreeExec,
+}
+
+// unexported
+
+// the file that marks a directory as a link tree
+const linkTreeMarker = ".tmsu-link-tree"
+
+func linkTreeExec(options Options, args []string, databasePath string) (error, warnings) {
+	if len(args) < 1 {
+		return fmt.Errorf("too few arguments"), nil
+	}
+
+	explicitOnly := options.HasOption("--explicit")
+
+	destPath, err := filepath.Abs(args[0])
+	if err != nil {
+		return fmt.Errorf("%v: could not get absolute path: %v", args[0], err), nil
+	}
+
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	tx, err := store.Begin()
+	if err != nil {
+		return err, nil
+	}
+	defer tx.Commit()
+
+	var links map[string]string
+	if len(args) > 1 {
+		links, err = linkTreeQueryLinks(store, tx, strings.Join(args[1:], " "), explicitOnly)
+	} else {
+		links, err = linkTreeTagLinks(store, tx, explicitOnly)
+	}
+	if err != nil {
+		return err, nil
+	}
+
+	if err := prepareLinkTree(destPath); err != nil {
+		return err, nil
+	}
+
+	return refreshLinkTree(destPath, links)
+}
+
+// The links to the files matching the query, by their path within the tree.
+func linkTreeQueryLinks(store *storage.Storage, tx *storage.Tx, queryText string, explicitOnly bool) (map[string]string, error) {
+	log.Debug("parsing query")
+
+	expression, err := parseQuery(store, tx, queryText)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve settings: %v", err)
+	}
+
+	expression, ignoreCase, err := store.NormalizeQuery(tx, expression, settings.IgnoreCase())
+	if err != nil {
+		return nil, fmt.Errorf("could not normalize query: %v", err)
+	}
+
+	log.Debug("querying database")
+
+	files, err := store.FilesForQuery(tx, expression, "", explicitOnly, ignoreCase, "none")
+	if err != nil {
+		return nil, fmt.Errorf("could not query files: %v", err)
+	}
+
+	links := make(map[string]string, len(files))
+	for _, file := range files {
+		links[linkTreeLinkName(file)] = file.Path()
+	}
+
+	return links, nil
+}
+
+// The links to the tagged files arranged by tag and value, by their path
+// within the tree.
+func linkTreeTagLinks(store *storage.Storage, tx *storage.Tx, explicitOnly bool) (map[string]string, error) {
+	log.Debug("retrieving files")
+
+	files, err := store.Files(tx, "none")
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve files: %v", err)
+	}
+
+	tags, err := store.Tags(tx)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve tags: %v", err)
+	}
+
+	tagNames := make(map[entities.TagId]string, len(tags))
+	for _, tag := range tags {
+		tagNames[tag.Id] = linkTreeDirectoryName(tag.Name)
+	}
+
+	values, err := store.Values(tx)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve values: %v", err)
+	}
+
+	valueNames := make(map[entities.ValueId]string, len(values))
+	for _, value := range values {
+		valueNames[value.Id] = linkTreeDirectoryName(value.Name)
+	}
+
+	links := make(map[string]string, len(files))
+	for _, file := range files {
+		fileTags, err := store.FileTagsByFileId(tx, file.Id, explicitOnly)
+		if err != nil {
+			return nil, fmt.Errorf("%v: could not retrieve file-tags: %v", file.Path(), err)
+		}
+
+		linkName := linkTreeLinkName(file)
+
+		for _, fileTag := range fileTags {
+			tagName := tagNames[fileTag.TagId]
+			links[filepath.Join(tagName, linkName)] = file.Path()
+
+			if fileTag.ValueId != 0 {
+				valueName := valueNames[fileTag.ValueId]
+				links[filepath.Join(tagName, valueName, linkName)] = file.Path()
+			}
+		}
+	}
+
+	return links, nil
+}
+
+// Creates the tree's directory, unless it exists already, refusing to take
+// over a directory with other contents.
+func prepareLinkTree(destPath string) error {
+	markerPath := filepath.Join(destPath, linkTreeMarker)
+
+	if _, err := os.Stat(markerPath); err == nil {
+		return nil
+	}
+
+	dir, err := os.Open(destPath)
+	switch {
+	case os.IsNotExist(err):
+		if err := os.MkdirAll(destPath, 0755); err != nil {
+			return fmt.Errorf("%v: could not create directory: %v", destPath, err)
+		}
+	case err != nil:
+		return fmt.Errorf("%v: could not open directory: %v", destPath, err)
+	default:
+		names, _ := dir.Readdirnames(1)
+		dir.Close()
+
+		if len(names) > 0 {
+			return fmt.Errorf("%v: directory is not empty and is not a link tree", destPath)
+		}
+	}
+
+	marker, err := os.Create(markerPath)
+	if err != nil {
+		return fmt.Errorf("%v: could not mark directory as a link tree: %v", destPath, err)
+	}
+
+	return marker.Close()
+}
+
+// Brings the tree in line with the links, touching only those that differ.
+func refreshLinkTree(destPath string, links map[string]string) (error, warnings) {
+	warnings := make(warnings, 0, 10)
+
+	log.Debugf("%v: examining existing links", destPath)
+
+	directories := make([]string, 0, 10)
+	err := filepath.Walk(destPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == destPath {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(destPath, path)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.IsDir():
+			directories = append(directories, path)
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return fmt.Errorf("%v: could not read link: %v", path, err)
+			}
+
+			if links[relPath] == target {
+				delete(links, relPath)
+				return nil
+			}
+
+			log.Infof("%v: removing link", path)
+
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("%v: could not remove link: %v", path, err)
+			}
+		case relPath != linkTreeMarker:
+			warnings = append(warnings, fmt.Sprintf("%v: not a symbolic link: leaving in place", path))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err, warnings
+	}
+
+	relPaths := make([]string, 0, len(links))
+	for relPath := range links {
+		relPaths = append(relPaths, relPath)
+	}
+	sort.Strings(relPaths)
+
+	for _, relPath := range relPaths {
+		path := filepath.Join(destPath, relPath)
+
+		log.Infof("%v: adding link", path)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("%v: could not create directory: %v", filepath.Dir(path), err), warnings
+		}
+		if err := os.Symlink(links[relPath], path); err != nil {
+			return fmt.Errorf("%v: could not create link: %v", path, err), warnings
+		}
+	}
+
+	// remove the deepest directories first so that their parents may be emptied
+	for index := len(directories) - 1; index >= 0; index-- {
+		if err := removeEmptyDirectory(directories[index]); err != nil {
+			return err, warnings
+		}
+	}
+
+	return nil, warnings
+}
+
+func removeEmptyDirectory(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("%v: could not open directory: %v", path, err)
+	}
+
+	names, _ := dir.Readdirnames(1)
+	dir.Close()
+
+	if len(names) > 0 {
+		return nil
+	}
+
+	log.Infof("%v: removing empty directory", path)
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("%v: could not remove directory: %v", path, err)
+	}
+
+	return nil
+}
+
+// The name of the file's link, with the file's ID before the extension so that
+// files sharing a name do not collide.
+func linkTreeLinkName(file *entities.File) string {
+	extension := filepath.Ext(file.Name)
+	name := file.Name[0 : len(file.Name)-len(extension)]
+	suffix := "." + strconv.FormatUint(uint64(file.Id), 10) + extension
+
+	if len(name)+len(suffix) > 255 {
+		name = name[0 : 255-len(suffix)]
+	}
+
+	return name + suffix
+}
+
+// The tag or value name with the path separators replaced.
+func linkTreeDirectoryName(name string) string {
+	name = strings.Replace(name, `/`, "\u200B\u2215", -1)
+	name = strings.Replace(name, `\`, "\u200B\u2216", -1)
+	return name
+}
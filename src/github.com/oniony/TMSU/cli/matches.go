@@ -0,0 +1,192 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage"
+	"path/filepath"
+	"sort"
+)
+
+var MatchesCommand = Command{
+	Name:     "matches",
+	Synopsis: "List the saved queries a file matches",
+	Usages:   []string{"tmsu matches [OPTION]... FILE..."},
+	Description: `Lists the saved queries that match each FILE, i.e. those whose query directory in the virtual filesystem contains it.
+
+Queries are evaluated as they are by the virtual filesystem: implied tags are taken into account.
+
+With --directories, lists instead the virtual filesystem directories, relative to the mountpoint, in which FILE appears: the directory of each matching query and the top-level tag and value directories of each of its tags.`,
+	Examples: []string{"$ tmsu matches song.mp3\ngenre=rock\nmusic and not live",
+		"$ tmsu matches --directories song.mp3\nqueries/genre=rock\nqueries/music and not live\ntags/genre\ntags/genre/=rock\ntags/music"},
+	Options: Options{Option{"--directories", "-d", "list the virtual filesystem directories the file appears in", false, ""}},
+	Exec:    matchesExec,
+}
+
+// unexported
+
+func matchesExec(options Options, args []string, databasePath string) (error, warnings) {
+	if len(args) == 0 {
+		return fmt.Errorf("too few arguments"), nil
+	}
+
+	showDirectories := options.HasOption("--directories")
+
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	tx, err := store.Begin()
+	if err != nil {
+		return err, nil
+	}
+	defer tx.Commit()
+
+	queries, err := store.Queries(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve queries: %v", err), nil
+	}
+
+	warnings := make(warnings, 0, 10)
+	for index, path := range args {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("%v: could not determine absolute path: %v", path, err), warnings
+		}
+
+		file, err := store.FileByPath(tx, absPath)
+		if err != nil {
+			return fmt.Errorf("%v: could not retrieve file: %v", path, err), warnings
+		}
+		if file == nil {
+			warnings = append(warnings, fmt.Sprintf("%v: file is not tagged", path))
+			continue
+		}
+
+		matches, err := matchingQueries(store, tx, file, queries)
+		if err != nil {
+			return err, warnings
+		}
+
+		if showDirectories {
+			matches, err = vfsDirectories(store, tx, file, matches)
+			if err != nil {
+				return err, warnings
+			}
+		}
+
+		if len(args) > 1 {
+			if index > 0 {
+				fmt.Println()
+			}
+
+			fmt.Printf("%v:\n", path)
+
+			for _, match := range matches {
+				fmt.Printf("  %v\n", match)
+			}
+		} else {
+			for _, match := range matches {
+				fmt.Println(match)
+			}
+		}
+	}
+
+	return nil, warnings
+}
+
+func matchingQueries(store *storage.Storage, tx *storage.Tx, file *entities.File, queries entities.Queries) ([]string, error) {
+	matches := make([]string, 0, len(queries))
+
+	for _, q := range queries {
+		log.Debugf("%v: evaluating query '%v'", file.Path(), q.Text)
+
+		expression, err := parseQuery(store, tx, q.Text)
+		if err != nil {
+			// the virtual filesystem shows no files for an invalid query
+			log.Debugf("could not parse query '%v': %v", q.Text, err)
+			continue
+		}
+
+		// restricting the query to the file's path keeps this to a single-row lookup
+		files, err := store.FilesForQuery(tx, expression, file.Path(), false, false, "none")
+		if err != nil {
+			return nil, fmt.Errorf("could not evaluate query '%v': %v", q.Text, err)
+		}
+
+		for _, match := range files {
+			if match.Id == file.Id {
+				matches = append(matches, q.Text)
+				break
+			}
+		}
+	}
+
+	sort.Strings(matches)
+
+	return matches, nil
+}
+
+func vfsDirectories(store *storage.Storage, tx *storage.Tx, file *entities.File, queryTexts []string) ([]string, error) {
+	directories := make([]string, 0, len(queryTexts)+10)
+	for _, queryText := range queryTexts {
+		directories = append(directories, filepath.Join("queries", queryText))
+	}
+
+	fileTags, err := store.FileTagsByFileId(tx, file.Id, false)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve file-tags for file '%v': %v", file.Id, err)
+	}
+
+	tagDirectories := make([]string, 0, len(fileTags))
+	seen := make(map[string]bool, len(fileTags))
+	for _, fileTag := range fileTags {
+		tag, err := store.Tag(tx, fileTag.TagId)
+		if err != nil {
+			return nil, fmt.Errorf("could not lookup tag: %v", err)
+		}
+		if tag == nil {
+			return nil, fmt.Errorf("tag '%v' does not exist", fileTag.TagId)
+		}
+
+		tagDirectory := filepath.Join("tags", tag.Name)
+		if !seen[tagDirectory] {
+			seen[tagDirectory] = true
+			tagDirectories = append(tagDirectories, tagDirectory)
+		}
+
+		if fileTag.ValueId != 0 {
+			value, err := store.Value(tx, fileTag.ValueId)
+			if err != nil {
+				return nil, fmt.Errorf("could not lookup value: %v", err)
+			}
+			if value == nil {
+				return nil, fmt.Errorf("value '%v' does not exist", fileTag.ValueId)
+			}
+
+			tagDirectories = append(tagDirectories, filepath.Join(tagDirectory, "="+value.Name))
+		}
+	}
+
+	sort.Strings(tagDirectories)
+
+	return append(directories, tagDirectories...), nil
+}
@@ -16,41 +16,125 @@
 package cli
 
 import (
+	"bufio"
 	"fmt"
 	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/entities"
 	"github.com/oniony/TMSU/storage"
+	"io"
+	"os"
+	"regexp"
+	"strings"
 )
 
 var MergeCommand = Command{
-	Name:        "merge",
-	Synopsis:    "Merge tags",
-	Usages:      []string{"tmsu merge TAG... DEST"},
-	Description: `Merges TAGs into tag DEST resulting in a single tag of name DEST.`,
+	Name:     "merge",
+	Synopsis: "Merge tags",
+	Usages: []string{"tmsu merge TAG... DEST",
+		"tmsu merge --regex PATTERN REPLACEMENT"},
+	Description: `Merges TAGs into tag DEST resulting in a single tag of name DEST.
+
+With --regex every tag, or value with --value, whose name matches the regular expression PATTERN is merged into the one named by replacing PATTERN's match with REPLACEMENT, in which $1, $2 &c. refer to the captured groups. A destination that does not yet exist is created. The mapping is printed before anything is merged.
+
+The values of the TAGs are preserved: a file tagged 'TAG=VALUE' is tagged 'DEST=VALUE'. Where a file is already tagged DEST with other values the --on-conflict option determines the outcome:
+
+  keep-both      the file keeps its DEST values and gains those of TAG
+                 (the default)
+  prefer-target  the file keeps only its DEST values
+  prompt         asks, for each such file, whether to keep both, the target's
+                 or the source's values
+
+The implications of the TAGs are carried over to DEST, again preserving their values, except where they would imply DEST itself or form a cycle.`,
 	Examples: []string{`$ tmsu merge cehese cheese`,
-		`$ tmsu merge outdoors outdoor outside`},
-	Options: Options{Option{"--value", "", "merge values", false, ""}},
-	Exec:    mergeExec,
+		`$ tmsu merge outdoors outdoor outside`,
+		`$ tmsu merge --on-conflict=prefer-target year date`,
+		`$ tmsu merge --regex '^(.*)s$' '$1'`},
+	Options: Options{Option{"--value", "", "merge values", false, ""},
+		Option{"--regex", "-r", "merge all tags or values matching a regular expression", false, ""},
+		Option{"--on-conflict", "", "how to merge the values of files already tagged DEST: keep-both, prefer-target or prompt", true, "keep-both"}},
+	Exec:           mergeExec,
+	EmptyArguments: true,
 }
 
 // unexported
 
+const (
+	keepBothOnConflict     = "keep-both"
+	preferTargetOnConflict = "prefer-target"
+	promptOnConflict       = "prompt"
+)
+
+// The resolutions of a conflict between a file's source and destination
+// tag values.
+const (
+	keepBothValues = iota
+	keepTargetValues
+	keepSourceValues
+)
+
+// How conflicting values are resolved when merging tags.
+type conflictPolicy struct {
+	mode   string
+	reader *bufio.Reader
+}
+
 func mergeExec(options Options, args []string, databasePath string) (error, warnings) {
 	if len(args) < 2 {
 		return fmt.Errorf("too few arguments"), nil
 	}
 
+	// only the replacement of a regular expression may be empty
+	for index, arg := range args {
+		if arg == "" && (index != 1 || !options.HasOption("--regex")) {
+			return fmt.Errorf("invalid empty argument"), nil
+		}
+	}
+
 	store, err := openDatabase(databasePath)
 	if err != nil {
 		return err, nil
 	}
 	defer store.Close()
 
+	policy := conflictPolicy{keepBothOnConflict, nil}
+	if options.HasOption("--on-conflict") {
+		if options.HasOption("--value") {
+			return fmt.Errorf("--on-conflict applies only when merging tags"), nil
+		}
+
+		policy.mode = options.Get("--on-conflict").Argument
+		switch policy.mode {
+		case keepBothOnConflict, preferTargetOnConflict:
+		case promptOnConflict:
+			policy.reader = bufio.NewReader(os.Stdin)
+		default:
+			return fmt.Errorf("invalid conflict resolution '%v': expected keep-both, prefer-target or prompt", policy.mode), nil
+		}
+	}
+
 	tx, err := store.Begin()
 	if err != nil {
 		return err, nil
 	}
 	defer tx.Commit()
 
+	if options.HasOption("--regex") {
+		if len(args) > 2 {
+			return fmt.Errorf("too many arguments"), nil
+		}
+
+		expression, err := regexp.Compile(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid regular expression '%v': %v", args[0], err), nil
+		}
+
+		if options.HasOption("--value") {
+			return mergeValuesMatching(store, tx, expression, args[1])
+		}
+
+		return mergeTagsMatching(store, tx, expression, args[1], policy)
+	}
+
 	sourceNames := make([]string, len(args)-1)
 	for index, name := range args[:len(args)-1] {
 		sourceNames[index] = parseTagOrValueName(name)
@@ -62,16 +146,16 @@ func mergeExec(options Options, args []string, databasePath string) (error, warn
 		return mergeValues(store, tx, sourceNames, destName)
 	}
 
-	return mergeTags(store, tx, sourceNames, destName)
+	return mergeTags(store, tx, sourceNames, destName, policy)
 }
 
-func mergeTags(store *storage.Storage, tx *storage.Tx, sourceTagNames []string, destTagName string) (error, warnings) {
+func mergeTags(store *storage.Storage, tx *storage.Tx, sourceTagNames []string, destTagName string, policy conflictPolicy) (error, warnings) {
 	destTag, err := store.TagByName(tx, destTagName)
 	if err != nil {
 		return fmt.Errorf("could not retrieve tag '%v': %v", destTagName, err), nil
 	}
 	if destTag == nil {
-		return fmt.Errorf("no such tag '%v'", destTagName), nil
+		return NoSuchTagError{destTagName, nil}, nil
 	}
 
 	warnings := make(warnings, 0, 10)
@@ -86,26 +170,34 @@ func mergeTags(store *storage.Storage, tx *storage.Tx, sourceTagNames []string,
 			return fmt.Errorf("could not retrieve tag '%v': %v", sourceTagName, err), warnings
 		}
 		if sourceTag == nil {
-			warnings = append(warnings, fmt.Sprintf("no such tag '%v'", sourceTagName))
+			warnings = append(warnings, noSuchTagWarning(NoSuchTagError{sourceTagName, nil}))
 			continue
 		}
 
-		log.Infof(2, "finding files tagged '%v'.", sourceTagName)
+		log.Debugf("finding files tagged '%v'.", sourceTagName)
 
 		fileTags, err := store.FileTagsByTagId(tx, sourceTag.Id, true)
 		if err != nil {
 			return fmt.Errorf("could not retrieve files for tag '%v': %v", sourceTagName, err), warnings
 		}
 
-		log.Infof(2, "applying tag '%v' to these files.", destTagName)
+		log.Debugf("applying tag '%v' to these files.", destTagName)
 
-		for _, fileTag := range fileTags {
-			if _, err = store.AddFileTag(tx, fileTag.FileId, destTag.Id, fileTag.ValueId); err != nil {
-				return fmt.Errorf("could not apply tag '%v' to file #%v: %v", destTagName, fileTag.FileId, err), warnings
+		for _, fileFileTags := range groupFileTagsByFileId(fileTags) {
+			if err := mergeFileTags(store, tx, fileFileTags, sourceTag, destTag, policy); err != nil {
+				return err, warnings
 			}
 		}
 
-		log.Infof(2, "deleting tag '%v'.", sourceTagName)
+		log.Debugf("carrying the implications of tag '%v' over to tag '%v'.", sourceTagName, destTagName)
+
+		implicationWarnings, err := mergeImplications(store, tx, sourceTag, destTag)
+		warnings = append(warnings, implicationWarnings...)
+		if err != nil {
+			return err, warnings
+		}
+
+		log.Debugf("deleting tag '%v'.", sourceTagName)
 
 		if err = store.DeleteTag(tx, sourceTag.Id); err != nil {
 			return fmt.Errorf("could not delete tag '%v': %v", sourceTagName, err), warnings
@@ -121,7 +213,7 @@ func mergeValues(store *storage.Storage, tx *storage.Tx, sourceValueNames []stri
 		return fmt.Errorf("could not retrieve value '%v': %v", destValueName, err), nil
 	}
 	if destValue == nil {
-		return fmt.Errorf("no such value '%v'", destValueName), nil
+		return NoSuchValueError{destValueName}, nil
 	}
 
 	warnings := make(warnings, 0, 10)
@@ -137,18 +229,18 @@ func mergeValues(store *storage.Storage, tx *storage.Tx, sourceValueNames []stri
 			return fmt.Errorf("could not retrieve value '%v': %v", sourceValueName, err), warnings
 		}
 		if sourceValue == nil {
-			warnings = append(warnings, fmt.Sprintf("no such value '%v'", sourceValueName))
+			warnings = append(warnings, noSuchTagWarning(NoSuchValueError{sourceValueName}))
 			continue
 		}
 
-		log.Infof(2, "finding files tagged with value '%v'.", sourceValueName)
+		log.Debugf("finding files tagged with value '%v'.", sourceValueName)
 
 		fileTags, err := store.FileTagsByValueId(tx, sourceValue.Id)
 		if err != nil {
 			return fmt.Errorf("could not retrieve files for value '%v': %v", sourceValueName, err), warnings
 		}
 
-		log.Infof(2, "applying value '%v' to these files.", destValueName)
+		log.Debugf("applying value '%v' to these files.", destValueName)
 
 		for _, fileTag := range fileTags {
 			if _, err = store.AddFileTag(tx, fileTag.FileId, fileTag.TagId, destValue.Id); err != nil {
@@ -156,7 +248,7 @@ func mergeValues(store *storage.Storage, tx *storage.Tx, sourceValueNames []stri
 			}
 		}
 
-		log.Infof(2, "deleting value '%v'.", sourceValueName)
+		log.Debugf("deleting value '%v'.", sourceValueName)
 
 		if err = store.DeleteValue(tx, sourceValue.Id); err != nil {
 			return fmt.Errorf("could not delete value '%v': %v", sourceValueName, err), warnings
@@ -165,3 +257,336 @@ func mergeValues(store *storage.Storage, tx *storage.Tx, sourceValueNames []stri
 
 	return nil, warnings
 }
+
+func mergeTagsMatching(store *storage.Storage, tx *storage.Tx, expression *regexp.Regexp, replacement string, policy conflictPolicy) (error, warnings) {
+	tags, err := store.Tags(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tags: %v", err), nil
+	}
+
+	names := namesOfTags(tags)
+	renamings := renamingsMatching(names, expression, replacement)
+	if len(renamings) == 0 {
+		return fmt.Errorf("no tags match '%v'", expression), nil
+	}
+
+	for _, renaming := range renamings {
+		if err := entities.ValidateTagName(renaming.to); err != nil {
+			return fmt.Errorf("cannot merge tag '%v' into '%v': %v", renaming.from, renaming.to, err), nil
+		}
+	}
+
+	printRenamings(renamings)
+
+	warnings := make(warnings, 0, 10)
+	for _, group := range groupRenamings(renamings) {
+		destName := group[0].to
+
+		destTag, err := store.TagByName(tx, destName)
+		if err != nil {
+			return fmt.Errorf("could not retrieve tag '%v': %v", destName, err), warnings
+		}
+		if destTag == nil {
+			// the first tag becomes the destination
+			if err := renameTag(store, tx, group[0].from, destName); err != nil {
+				return err, warnings
+			}
+			group = group[1:]
+		}
+
+		err, mergeWarnings := mergeTags(store, tx, renamingSources(group), destName, policy)
+		warnings = append(warnings, mergeWarnings...)
+		if err != nil {
+			return err, warnings
+		}
+	}
+
+	return nil, warnings
+}
+
+func mergeValuesMatching(store *storage.Storage, tx *storage.Tx, expression *regexp.Regexp, replacement string) (error, warnings) {
+	values, err := store.Values(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve values: %v", err), nil
+	}
+
+	names := namesOfValues(values)
+	renamings := renamingsMatching(names, expression, replacement)
+	if len(renamings) == 0 {
+		return fmt.Errorf("no values match '%v'", expression), nil
+	}
+
+	for _, renaming := range renamings {
+		if err := entities.ValidateValueName(renaming.to); err != nil {
+			return fmt.Errorf("cannot merge value '%v' into '%v': %v", renaming.from, renaming.to, err), nil
+		}
+	}
+
+	printRenamings(renamings)
+
+	warnings := make(warnings, 0, 10)
+	for _, group := range groupRenamings(renamings) {
+		destName := group[0].to
+
+		destValue, err := store.ValueByName(tx, destName)
+		if err != nil {
+			return fmt.Errorf("could not retrieve value '%v': %v", destName, err), warnings
+		}
+		if destValue == nil {
+			// the first value becomes the destination
+			if err := renameValue(store, tx, group[0].from, destName); err != nil {
+				return err, warnings
+			}
+			group = group[1:]
+		}
+
+		err, mergeWarnings := mergeValues(store, tx, renamingSources(group), destName)
+		warnings = append(warnings, mergeWarnings...)
+		if err != nil {
+			return err, warnings
+		}
+	}
+
+	return nil, warnings
+}
+
+// Groups the renamings by new name, preserving their order.
+func groupRenamings(renamings []renaming) [][]renaming {
+	groups := make([][]renaming, 0, len(renamings))
+	indices := make(map[string]int, len(renamings))
+
+	for _, renaming := range renamings {
+		index, found := indices[renaming.to]
+		if !found {
+			index = len(groups)
+			indices[renaming.to] = index
+			groups = append(groups, nil)
+		}
+
+		groups[index] = append(groups[index], renaming)
+	}
+
+	return groups
+}
+
+func renamingSources(renamings []renaming) []string {
+	names := make([]string, len(renamings))
+	for index, renaming := range renamings {
+		names[index] = renaming.from
+	}
+
+	return names
+}
+
+// Groups the file tags by file, preserving their order.
+func groupFileTagsByFileId(fileTags entities.FileTags) []entities.FileTags {
+	groups := make([]entities.FileTags, 0, len(fileTags))
+	indices := make(map[entities.FileId]int, len(fileTags))
+
+	for _, fileTag := range fileTags {
+		index, found := indices[fileTag.FileId]
+		if !found {
+			index = len(groups)
+			indices[fileTag.FileId] = index
+			groups = append(groups, nil)
+		}
+
+		groups[index] = append(groups[index], fileTag)
+	}
+
+	return groups
+}
+
+// Applies the destination tag, with the values of the source tag, to a file
+// tagged with the source tag, resolving any conflict with the destination tag
+// values the file already has according to the policy.
+func mergeFileTags(store *storage.Storage, tx *storage.Tx, sourceFileTags entities.FileTags, sourceTag, destTag *entities.Tag, policy conflictPolicy) error {
+	fileId := sourceFileTags[0].FileId
+
+	fileTags, err := store.FileTagsByFileId(tx, fileId, true)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tags for file #%v: %v", fileId, err)
+	}
+
+	destFileTags := make(entities.FileTags, 0, len(fileTags))
+	for _, fileTag := range fileTags {
+		if fileTag.TagId == destTag.Id && fileTag.Explicit {
+			destFileTags = append(destFileTags, fileTag)
+		}
+	}
+
+	resolution := keepBothValues
+	if conflictingFileTags(sourceFileTags, destFileTags) {
+		resolution, err = resolveConflict(store, tx, fileId, sourceFileTags, destFileTags, sourceTag, destTag, policy)
+		if err != nil {
+			return err
+		}
+	}
+
+	if resolution == keepTargetValues {
+		return nil
+	}
+
+	for _, fileTag := range sourceFileTags {
+		if _, err := store.AddFileTag(tx, fileId, destTag.Id, fileTag.ValueId); err != nil {
+			return fmt.Errorf("could not apply tag '%v' to file #%v: %v", destTag.Name, fileId, err)
+		}
+	}
+
+	if resolution == keepSourceValues {
+		for _, fileTag := range destFileTags {
+			if hasValueId(sourceFileTags, fileTag.ValueId) {
+				continue
+			}
+
+			if err := store.DeleteFileTag(tx, fileId, destTag.Id, fileTag.ValueId); err != nil {
+				return fmt.Errorf("could not remove tag '%v' from file #%v: %v", destTag.Name, fileId, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Whether the file is tagged with the destination tag and the source tag
+// would add values it does not already have.
+func conflictingFileTags(sourceFileTags, destFileTags entities.FileTags) bool {
+	if len(destFileTags) == 0 {
+		return false
+	}
+
+	for _, fileTag := range sourceFileTags {
+		if !hasValueId(destFileTags, fileTag.ValueId) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasValueId(fileTags entities.FileTags, valueId entities.ValueId) bool {
+	for _, fileTag := range fileTags {
+		if fileTag.ValueId == valueId {
+			return true
+		}
+	}
+
+	return false
+}
+
+func resolveConflict(store *storage.Storage, tx *storage.Tx, fileId entities.FileId, sourceFileTags, destFileTags entities.FileTags, sourceTag, destTag *entities.Tag, policy conflictPolicy) (int, error) {
+	switch policy.mode {
+	case preferTargetOnConflict:
+		return keepTargetValues, nil
+	case promptOnConflict:
+		file, err := store.File(tx, fileId)
+		if err != nil {
+			return 0, fmt.Errorf("could not retrieve file #%v: %v", fileId, err)
+		}
+
+		sourceText, err := formatFileTagValues(store, tx, sourceTag, sourceFileTags)
+		if err != nil {
+			return 0, err
+		}
+
+		destText, err := formatFileTagValues(store, tx, destTag, destFileTags)
+		if err != nil {
+			return 0, err
+		}
+
+		return promptConflict(policy.reader, file.Path(), sourceText, destText)
+	}
+
+	return keepBothValues, nil
+}
+
+func formatFileTagValues(store *storage.Storage, tx *storage.Tx, tag *entities.Tag, fileTags entities.FileTags) (string, error) {
+	names := make([]string, len(fileTags))
+	for index, fileTag := range fileTags {
+		valueName := ""
+		if fileTag.ValueId != 0 {
+			value, err := store.Value(tx, fileTag.ValueId)
+			if err != nil {
+				return "", fmt.Errorf("could not retrieve value #%v: %v", fileTag.ValueId, err)
+			}
+			if value != nil {
+				valueName = value.Name
+			}
+		}
+
+		names[index] = formatTagValueName(tag.Name, valueName, "", false, false, false)
+	}
+
+	return strings.Join(names, " "), nil
+}
+
+// Asks whether to keep both the source and target values, just the target's or
+// just the source's, defaulting to both.
+func promptConflict(reader *bufio.Reader, path, sourceText, destText string) (int, error) {
+	for {
+		fmt.Printf("%v: merge '%v' with '%v'? keep [b]oth, [t]arget or [s]ource [B/t/s]: ", path, sourceText, destText)
+
+		answer, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "", "b", "both":
+			return keepBothValues, nil
+		case "t", "target":
+			return keepTargetValues, nil
+		case "s", "source":
+			return keepSourceValues, nil
+		}
+
+		if err == io.EOF {
+			return keepBothValues, nil
+		}
+	}
+}
+
+// Carries the implications of the source tag over to the destination tag,
+// preserving their values. Those that would have the destination tag imply
+// itself, or that would form a cycle, are dropped with a warning.
+func mergeImplications(store *storage.Storage, tx *storage.Tx, sourceTag, destTag *entities.Tag) (warnings, error) {
+	implications, err := store.Implications(tx)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve implications: %v", err)
+	}
+
+	warnings := make(warnings, 0, 10)
+	for _, implication := range implications {
+		if implication.ImplyingTag.Id != sourceTag.Id && implication.ImpliedTag.Id != sourceTag.Id {
+			continue
+		}
+
+		implying, implied := formatImplication(implication, nil, false)
+
+		pair := implication.ImplyingTagValuePair()
+		if pair.TagId == sourceTag.Id {
+			pair.TagId = destTag.Id
+		}
+
+		impliedPair := implication.ImpliedTagValuePair()
+		if impliedPair.TagId == sourceTag.Id {
+			impliedPair.TagId = destTag.Id
+		}
+
+		if pair.TagId == impliedPair.TagId {
+			warnings = append(warnings, fmt.Sprintf("dropping implication %v -> %v: tag '%v' cannot imply itself", implying, implied, destTag.Name))
+			continue
+		}
+
+		if implication.ImplyingPattern != "" {
+			err = store.AddPatternImplication(tx, pair.TagId, implication.ImplyingPattern, impliedPair)
+		} else {
+			err = store.AddImplication(tx, pair, impliedPair)
+		}
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("dropping implication %v -> %v: %v", implying, implied, err))
+		}
+	}
+
+	return warnings, nil
+}
@@ -25,6 +25,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -33,7 +34,8 @@ var MountCommand = Command{
 	Name:     "mount",
 	Synopsis: "Mount the virtual filesystem",
 	Usages: []string{"tmsu mount",
-		"tmsu mount [OPTION]... [FILE] MOUNTPOINT"},
+		"tmsu mount [OPTION]... [FILE] MOUNTPOINT",
+		"tmsu mount [OPTION]... --database=FILE --database=FILE... MOUNTPOINT"},
 	Description: `Without arguments, lists the currently mounted file-systems, otherwise mounts a virtual file-system at the path MOUNTPOINT.
 
 Where FILE is specified, the database at FILE is mounted.
@@ -42,10 +44,29 @@ If FILE is not specified but the TMSU_DB environment variable is defined then th
 
 Where neither FILE is specified nor TMSU_DB defined then the default database is mounted.
 
-To allow other users access to the mounted filesystem, pass the 'allow_other' FUSE option, e.g. 'tmsu mount --options=allow_other mp'. (FUSE only allows the root user to use this option unless 'user_allow_other' is present in '/etc/fuse.conf'.)`,
+Several databases can be mounted together by specifying the global --database option more than once. Each database is then presented in a directory of its own named after the database: for a database in the default location, '.tmsu/db', the directory containing '.tmsu', otherwise the database's file name without its extension. (Where names clash a numeric suffix is added.) Each of these directories has the layout of a single mounted database.
+
+To allow other users access to the mounted filesystem, pass the 'allow_other' FUSE option, e.g. 'tmsu mount --options=allow_other mp'. (FUSE only allows the root user to use this option unless 'user_allow_other' is present in '/etc/fuse.conf'.)
+
+Every entry of the virtual filesystem is shown as owned by the user and group that mounted it. The 'uid=USER' and 'gid=GROUP' options, given by number or name, show them as owned by another user and group instead, e.g. so that a mount shared by Samba or NFS has the ownership expected by its clients. The 'ro' option mounts the virtual filesystem read-only: it can be browsed as normal but changes fail with EROFS.
+
+To hide files from the virtual filesystem pass one or more 'exclude=PATH' options: files at or under each PATH will not appear in any tag or query directory, nor be reachable by file identifier. This is applied when the database is queried so no part of the virtual filesystem can expose them.
+
+Database work for filesystem requests is performed by a bounded pool of workers so that a burst of lookups, e.g. from a desktop file indexer, cannot exhaust the database. The 'workers=N' option sets the pool size (default 4) and 'timeout=SECONDS' how long a request may wait for and run on a worker before failing with ETIMEDOUT (default 30, 0 to wait indefinitely).
+
+Directory listings, attributes and link targets are cached until the database is next changed, by this or any other process, so that a file manager examining every entry of a directory does not query the database for each. The 'nocache' option disables the cache.
+
+Changes made through the virtual filesystem are written back to the database. By default removing a file's link from a tag directory (e.g. 'rm mp/tags/holiday/files/beach.12') untags the file and removing an empty tag directory deletes the tag: the 'nountag' and 'nodeletetags' options prevent these. With the 'tagcopies' option, copying a file's link into another tag directory, without following it, (e.g. 'cp -P mp/tags/holiday/files/beach.12 mp/tags/favourite/files') applies the tags of that directory to the file.
+
+A database that cannot be written, such as one on optical media or a read-only snapshot, or that is mounted with the global --read-only option, is mounted read-only: it can be browsed as normal but attempts to create, rename or remove tags and queries fail with EROFS.`,
 	Examples: []string{"$ tmsu mount mp",
 		"$ tmsu mount /tmp/db mp",
-		"$ tmsu mount --options=allow_other mp"},
+		"$ tmsu mount --database=photos.db --database=documents.db mp",
+		"$ tmsu mount --options=allow_other mp",
+		"$ tmsu mount --options=allow_other,exclude=/home/me/private mp",
+		"$ tmsu mount -o allow_other,uid=nobody,gid=nogroup,ro mp",
+		"$ tmsu mount --options=workers=2,timeout=10 mp",
+		"$ tmsu mount --options=tagcopies,nodeletetags mp"},
 	Options: Options{Option{"--options", "-o", "mount options (passed to fusermount)", true, ""}},
 	Exec:    mountExec,
 }
@@ -58,17 +79,24 @@ func mountExec(options Options, args []string, databasePath string) (error, warn
 		mountOptions = options.Get("--options").Argument
 	}
 
-	store, err := openDatabase(databasePath)
-	if err != nil {
-		return err, nil
+	databasePaths := databaseOptionPaths(options)
+	if len(databasePaths) < 2 {
+		databasePaths = []string{databasePath}
 	}
-	defer store.Close()
 
-	tx, err := store.Begin()
-	if err != nil {
-		return err, nil
+	for _, databasePath := range databasePaths {
+		store, err := openDatabase(databasePath)
+		if err != nil {
+			return err, nil
+		}
+		defer store.Close()
+
+		tx, err := store.Begin()
+		if err != nil {
+			return err, nil
+		}
+		defer tx.Commit()
 	}
-	defer tx.Commit()
 
 	switch len(args) {
 	case 0:
@@ -78,14 +106,18 @@ func mountExec(options Options, args []string, databasePath string) (error, warn
 	case 1:
 		mountPath := args[0]
 
-		if err := mountExplicit(store.DbPath, mountPath, mountOptions); err != nil {
+		if err := mountExplicit(databasePaths, mountPath, mountOptions); err != nil {
 			return err, nil
 		}
 	case 2:
+		if len(databasePaths) > 1 {
+			return fmt.Errorf("a database cannot be specified both as an argument and with --database when mounting several"), nil
+		}
+
 		databasePath := args[0]
 		mountPath := args[1]
 
-		if err := mountExplicit(databasePath, mountPath, mountOptions); err != nil {
+		if err := mountExplicit([]string{databasePath}, mountPath, mountOptions); err != nil {
 			return err, nil
 		}
 	default:
@@ -96,7 +128,7 @@ func mountExec(options Options, args []string, databasePath string) (error, warn
 }
 
 func listMounts() error {
-	log.Info(2, "retrieving mount table.")
+	log.Debug("retrieving mount table.")
 
 	mt, err := vfs.GetMountTable()
 	if err != nil {
@@ -104,7 +136,7 @@ func listMounts() error {
 	}
 
 	if len(mt) == 0 {
-		log.Info(2, "mount table is empty.")
+		log.Debug("mount table is empty.")
 	}
 
 	dbPathWidth := 0
@@ -121,7 +153,7 @@ func listMounts() error {
 	return nil
 }
 
-func mountExplicit(databasePath string, mountPath string, mountOptions string) error {
+func mountExplicit(databasePaths []string, mountPath string, mountOptions string) error {
 	if alreadyMounted(mountPath) {
 		return fmt.Errorf("%v: mount path already in use", mountPath)
 	}
@@ -137,17 +169,31 @@ func mountExplicit(databasePath string, mountPath string, mountOptions string) e
 		return fmt.Errorf("%v: mount point is not a directory", mountPath)
 	}
 
-	stat, err = os.Stat(databasePath)
-	if err != nil {
-		return fmt.Errorf("%v: could not stat: %v", databasePath, err)
-	}
-	if stat == nil {
-		return fmt.Errorf("%v: database does not exist", databasePath)
+	args := []string{"vfs"}
+	for _, databasePath := range databasePaths {
+		stat, err = os.Stat(databasePath)
+		if err != nil {
+			return fmt.Errorf("%v: could not stat: %v", databasePath, err)
+		}
+		if stat == nil {
+			return fmt.Errorf("%v: database does not exist", databasePath)
+		}
+
+		absDatabasePath, err := filepath.Abs(databasePath)
+		if err != nil {
+			return fmt.Errorf("%v: could not get absolute path: %v", databasePath, err)
+		}
+
+		args = append(args, "--database="+absDatabasePath)
 	}
 
-	log.Infof(2, "spawning daemon to mount VFS for database '%v' at '%v'", databasePath, mountPath)
+	log.Debugf("spawning daemon to mount VFS for database '%v' at '%v'", strings.Join(databasePaths, "', '"), mountPath)
 
-	args := []string{"vfs", "--database=" + databasePath, mountPath, "--options=" + mountOptions}
+	args = append(args, mountPath, "--options="+mountOptions)
+	args = append(args, logOptions...)
+	if readOnly {
+		args = append(args, "--read-only")
+	}
 	daemon := exec.Command(os.Args[0], args...)
 
 	tempFile, err := ioutil.TempFile("", "tmsu-vfs-")
@@ -161,12 +207,12 @@ func mountExplicit(databasePath string, mountPath string, mountOptions string) e
 		return fmt.Errorf("could not start daemon: %v", err)
 	}
 
-	log.Info(2, "sleeping.")
+	log.Debug("sleeping.")
 
 	const halfSecond = 500000000
 	time.Sleep(halfSecond)
 
-	log.Info(2, "checking whether daemon started successfully.")
+	log.Debug("checking whether daemon started successfully.")
 
 	var waitStatus syscall.WaitStatus
 	var rusage syscall.Rusage
@@ -184,6 +230,19 @@ func mountExplicit(databasePath string, mountPath string, mountOptions string) e
 	return nil
 }
 
+// The databases specified with the global --database option, which may be
+// given more than once to mount several databases together.
+func databaseOptionPaths(options Options) []string {
+	databasePaths := make([]string, 0, 1)
+	for _, option := range options {
+		if option.LongName == "--database" && option.Argument != "" {
+			databasePaths = append(databasePaths, option.Argument)
+		}
+	}
+
+	return databasePaths
+}
+
 func alreadyMounted(path string) bool {
 	absPath, err := filepath.Abs(path)
 	if err != nil {
@@ -0,0 +1,211 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage"
+	"sort"
+	"strings"
+)
+
+var NormalizeTagsCommand = Command{
+	Name:     "normalize-tags",
+	Synopsis: "Normalize tag and value names",
+	Usages:   []string{"tmsu normalize-tags"},
+	Description: `Converts the tag and value names in the database to Unicode normalisation form C and enables the 'normalizeNames' setting, after which names are normalised when stored and matched without regard to case.
+
+Tags whose names become indistinguishable under these rules are merged into the earliest created of them, as are such values.`,
+	Examples: []string{"$ tmsu normalize-tags",
+		"$ tmsu --dry-run normalize-tags"},
+	Options: Options{},
+	Exec:    normalizeTagsExec,
+}
+
+// unexported
+
+func normalizeTagsExec(options Options, args []string, databasePath string) (error, warnings) {
+	if len(args) > 0 {
+		return fmt.Errorf("too many arguments"), nil
+	}
+
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	tx, err := store.Begin()
+	if err != nil {
+		return err, nil
+	}
+	defer tx.Commit()
+
+	// disable normalisation whilst the names are rewritten so that colliding
+	// tags and values can still be told apart
+	if _, err := store.UpdateSetting(tx, "normalizeNames", "no"); err != nil {
+		return fmt.Errorf("could not update setting 'normalizeNames': %v", err), nil
+	}
+
+	if err := normalizeTags(store, tx); err != nil {
+		return err, nil
+	}
+
+	if err := normalizeValues(store, tx); err != nil {
+		return err, nil
+	}
+
+	if _, err := store.UpdateSetting(tx, "normalizeNames", "yes"); err != nil {
+		return fmt.Errorf("could not update setting 'normalizeNames': %v", err), nil
+	}
+
+	return nil, nil
+}
+
+func normalizeTags(store *storage.Storage, tx *storage.Tx) error {
+	tags, err := store.Tags(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tags: %v", err)
+	}
+
+	sorted := tagsById(tags)
+
+	survivors := make(map[string]*entities.Tag, len(tags))
+	for _, tag := range sorted {
+		key := normalizedNameKey(tag.Name)
+
+		survivor, found := survivors[key]
+		if !found {
+			survivors[key] = tag
+			continue
+		}
+
+		log.Infof("merging tag '%v' into '%v'", tag.Name, survivor.Name)
+
+		fileTags, err := store.FileTagsByTagId(tx, tag.Id, true)
+		if err != nil {
+			return fmt.Errorf("could not retrieve files for tag '%v': %v", tag.Name, err)
+		}
+
+		for _, fileTag := range fileTags {
+			if _, err = store.AddFileTag(tx, fileTag.FileId, survivor.Id, fileTag.ValueId); err != nil {
+				return fmt.Errorf("could not apply tag '%v' to file #%v: %v", survivor.Name, fileTag.FileId, err)
+			}
+		}
+
+		if err = store.DeleteTag(tx, tag.Id); err != nil {
+			return fmt.Errorf("could not delete tag '%v': %v", tag.Name, err)
+		}
+	}
+
+	for _, survivor := range sorted {
+		if survivors[normalizedNameKey(survivor.Name)] != survivor {
+			continue
+		}
+
+		normalizedName := entities.NormalizeName(survivor.Name)
+		if normalizedName == survivor.Name {
+			continue
+		}
+
+		log.Infof("renaming tag '%v' to '%v'", survivor.Name, normalizedName)
+
+		if _, err := store.RenameTag(tx, survivor.Id, normalizedName); err != nil {
+			return fmt.Errorf("could not rename tag '%v': %v", survivor.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func normalizeValues(store *storage.Storage, tx *storage.Tx) error {
+	values, err := store.Values(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve values: %v", err)
+	}
+
+	sorted := valuesById(values)
+
+	survivors := make(map[string]*entities.Value, len(values))
+	for _, value := range sorted {
+		key := normalizedNameKey(value.Name)
+
+		survivor, found := survivors[key]
+		if !found {
+			survivors[key] = value
+			continue
+		}
+
+		log.Infof("merging value '%v' into '%v'", value.Name, survivor.Name)
+
+		fileTags, err := store.FileTagsByValueId(tx, value.Id)
+		if err != nil {
+			return fmt.Errorf("could not retrieve files for value '%v': %v", value.Name, err)
+		}
+
+		for _, fileTag := range fileTags {
+			if _, err = store.AddFileTag(tx, fileTag.FileId, fileTag.TagId, survivor.Id); err != nil {
+				return fmt.Errorf("could not apply value '%v' to file #%v: %v", survivor.Name, fileTag.FileId, err)
+			}
+		}
+
+		if err = store.DeleteValue(tx, value.Id); err != nil {
+			return fmt.Errorf("could not delete value '%v': %v", value.Name, err)
+		}
+	}
+
+	for _, survivor := range sorted {
+		if survivors[normalizedNameKey(survivor.Name)] != survivor {
+			continue
+		}
+
+		normalizedName := entities.NormalizeName(survivor.Name)
+		if normalizedName == survivor.Name {
+			continue
+		}
+
+		log.Infof("renaming value '%v' to '%v'", survivor.Name, normalizedName)
+
+		if _, err := store.RenameValue(tx, survivor.Id, normalizedName); err != nil {
+			return fmt.Errorf("could not rename value '%v': %v", survivor.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// The name by which tags and values are identified once names are normalised.
+func normalizedNameKey(name string) string {
+	return strings.ToLower(entities.NormalizeName(name))
+}
+
+func tagsById(tags entities.Tags) entities.Tags {
+	sorted := make(entities.Tags, len(tags))
+	copy(sorted, tags)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Id < sorted[j].Id })
+
+	return sorted
+}
+
+func valuesById(values entities.Values) entities.Values {
+	sorted := make(entities.Values, len(values))
+	copy(sorted, values)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Id < sorted[j].Id })
+
+	return sorted
+}
@@ -0,0 +1,177 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/common/ontology"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage"
+	"io"
+	"os"
+)
+
+var OntologyCommand = Command{
+	Name:     "ontology",
+	Synopsis: "Imports tags and implications from an ontology",
+	Usages:   []string{"tmsu ontology [OPTION]... FILE..."},
+	Description: `Imports the concepts of an existing vocabulary, such as a thesaurus or classification scheme, from each FILE as tags, with each narrower concept implying its broader concept. For example a relation from 'dog' to 'beagle' results in the implication 'beagle -> dog'.
+
+The following formats are supported:
+
+  csv   rows of broader,narrower concept pairs (an optional header row
+        'broader,narrower' and lines starting with '#' are ignored)
+  skos  SKOS concepts in RDF/XML, named by their skos:prefLabel and related
+        by skos:broader and skos:narrower
+
+The format is determined from the file extension ('.csv', or '.rdf', '.xml' or '.skos' for SKOS) unless specified with --format, which is required when reading from standard input ('-').
+
+Concepts whose names are not valid tag names, and relations that would create an implication cycle, are skipped with a warning.`,
+	Examples: []string{"$ tmsu ontology animals.csv",
+		`$ tmsu ontology --format=skos thesaurus.owl
+$ tmsu imply
+beagle -> dog
+   dog -> animal`},
+	Options: Options{{"--format", "-f", "the format of the ontology: csv or skos", true, ""}},
+	Exec:    ontologyExec,
+}
+
+// unexported
+
+func ontologyExec(options Options, args []string, databasePath string) (error, warnings) {
+	if len(args) == 0 {
+		return fmt.Errorf("too few arguments"), nil
+	}
+
+	format := ""
+	if options.HasOption("--format") {
+		format = options.Get("--format").Argument
+	}
+
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	tx, err := store.Begin()
+	if err != nil {
+		return err, nil
+	}
+	defer tx.Commit()
+
+	warnings := make(warnings, 0, 10)
+	for _, path := range args {
+		relations, err := readOntology(path, format)
+		if err != nil {
+			return err, warnings
+		}
+
+		log.Debugf("%v: importing %v relations", path, len(relations))
+
+		if warnings, err = importRelations(store, tx, relations, warnings); err != nil {
+			return err, warnings
+		}
+	}
+
+	return nil, warnings
+}
+
+func readOntology(path, format string) (ontology.Relations, error) {
+	if format == "" {
+		if path == "-" {
+			return nil, fmt.Errorf("the format must be specified when reading from standard input")
+		}
+
+		var err error
+		if format, err = ontology.FormatFor(path); err != nil {
+			return nil, fmt.Errorf("%v: use --format to specify the format", err)
+		}
+	}
+
+	var reader io.Reader
+	if path == "-" {
+		reader = os.Stdin
+	} else {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("%v: could not open ontology: %v", path, err)
+		}
+		defer file.Close()
+
+		reader = file
+	}
+
+	relations, err := ontology.Read(reader, format)
+	if err != nil {
+		return nil, fmt.Errorf("%v: could not read ontology: %v", path, err)
+	}
+
+	return relations, nil
+}
+
+func importRelations(store *storage.Storage, tx *storage.Tx, relations ontology.Relations, warnings warnings) (warnings, error) {
+	tags := make(map[string]*entities.Tag)
+	tagFor := func(name string) (*entities.Tag, error) {
+		if tag, ok := tags[name]; ok {
+			return tag, nil
+		}
+
+		tag, err := store.TagByName(tx, name)
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve tag '%v': %v", name, err)
+		}
+		if tag == nil {
+			if err := entities.ValidateTagName(name); err != nil {
+				warnings = append(warnings, fmt.Sprintf("concept '%v': %v", name, err))
+			} else if tag, err = createTag(store, tx, name); err != nil {
+				return nil, err
+			}
+		}
+
+		tags[name] = tag
+		return tag, nil
+	}
+
+	for _, relation := range relations {
+		broaderTag, err := tagFor(relation.Broader)
+		if err != nil {
+			return warnings, err
+		}
+
+		narrowerTag, err := tagFor(relation.Narrower)
+		if err != nil {
+			return warnings, err
+		}
+
+		if broaderTag == nil || narrowerTag == nil {
+			continue
+		}
+		if broaderTag.Id == narrowerTag.Id {
+			warnings = append(warnings, fmt.Sprintf("concept '%v' cannot be narrower than itself", narrowerTag.Name))
+			continue
+		}
+
+		log.Debugf("adding tag implication of '%v' to '%v'", narrowerTag.Name, broaderTag.Name)
+
+		if err := store.AddImplication(tx, entities.TagIdValueIdPair{narrowerTag.Id, 0}, entities.TagIdValueIdPair{broaderTag.Id, 0}); err != nil {
+			warnings = append(warnings, fmt.Sprintf("cannot add implication of '%v' to '%v': %v", narrowerTag.Name, broaderTag.Name, err))
+		}
+	}
+
+	return warnings, nil
+}
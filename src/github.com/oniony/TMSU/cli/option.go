@@ -88,7 +88,7 @@ func (parser *OptionParser) Parse(args ...string) (command *Command, options Opt
 		arg := args[index]
 
 		switch {
-		case arg == "":
+		case arg == "" && (command == nil || !command.EmptyArguments):
 			err = fmt.Errorf("invalid empty argument")
 			return
 		case arg == "--" && parseOptions:
@@ -116,6 +116,9 @@ func (parser *OptionParser) Parse(args ...string) (command *Command, options Opt
 						option.Argument = args[index+1]
 						index++
 					}
+				} else if len(parts) == 2 {
+					// an optional argument, which must be attached
+					option.Argument = parts[1]
 				}
 
 				options = append(options, *option)
@@ -76,3 +76,40 @@ func TestInvalidGlobalOption(test *testing.T) {
 		test.Fatal("Invalid option not identified.")
 	}
 }
+
+func TestParseAttachedOptionalArgument(test *testing.T) {
+	parser := NewOptionParser(Options{Option{"--wait", "", "wait", false, ""}}, []*Command{{Name: "a"}})
+
+	_, options, arguments, err := parser.Parse("--wait=30", "a", "--wait", "b")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(options) != 2 {
+		test.Fatalf("Expected two options but were %v.", len(options))
+	}
+	if options[0].Argument != "30" {
+		test.Fatalf("Expected option argument of '30' but was '%v'.", options[0].Argument)
+	}
+	if options[1].Argument != "" {
+		test.Fatalf("Expected no option argument but was '%v'.", options[1].Argument)
+	}
+	if len(arguments) != 1 || arguments[0] != "b" {
+		test.Fatalf("Expected argument of 'b' but were %v.", arguments)
+	}
+}
+
+func TestParseEmptyArgument(test *testing.T) {
+	parser := NewOptionParser(Options{}, []*Command{{Name: "a"}, {Name: "b", EmptyArguments: true}})
+
+	if _, _, _, err := parser.Parse("a", ""); err == nil {
+		test.Fatal("Empty argument not identified.")
+	}
+
+	_, _, arguments, err := parser.Parse("b", "c", "")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(arguments) != 2 || arguments[1] != "" {
+		test.Fatalf("Expected arguments of 'c' and '' but were %v.", arguments)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/common/metadata"
+	"github.com/oniony/TMSU/entities"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// The output of a plugin: the fingerprint of the file and the tags, as
+// TAG[=VALUE] arguments, it suggests for it.
+type pluginResult struct {
+	Fingerprint string   `json:"fingerprint"`
+	Tags        []string `json:"tags"`
+}
+
+// unexported
+
+// the results of the plugins run, by path, so that fingerprinting and
+// automatic tagging run a file's plugin only once
+var pluginResults = make(map[string]*pluginResult)
+var pluginMutex sync.Mutex
+
+// Runs the first of the plugins matching the MIME type of the regular file at
+// the specified path, returning nil if none matches or the plugin fails.
+func runPlugins(plugins []entities.Plugin, path string) *pluginResult {
+	if len(plugins) == 0 {
+		return nil
+	}
+
+	pluginMutex.Lock()
+	result, ok := pluginResults[path]
+	pluginMutex.Unlock()
+	if ok {
+		return result
+	}
+
+	result = runPlugin(plugins, path)
+
+	pluginMutex.Lock()
+	pluginResults[path] = result
+	pluginMutex.Unlock()
+
+	return result
+}
+
+func runPlugin(plugins []entities.Plugin, path string) *pluginResult {
+	stat, err := os.Lstat(path)
+	if err != nil || !stat.Mode().IsRegular() {
+		return nil
+	}
+
+	mimeType, err := metadata.DetectMimeType(path)
+	if err != nil {
+		log.Debugf("%v: could not detect MIME type for plugins: %v", path, err)
+		return nil
+	}
+	mimeType = strings.ToLower(mimeType)
+
+	for _, plugin := range plugins {
+		if !plugin.Matches(mimeType) {
+			continue
+		}
+
+		log.Debugf("%v: running plugin '%v' for MIME type '%v'", path, plugin.Command, mimeType)
+
+		result, err := runPluginCommand(plugin.Command, path)
+		if err != nil {
+			log.Warnf("%v: plugin %v", path, err)
+			return nil
+		}
+
+		return result
+	}
+
+	return nil
+}
+
+func runPluginCommand(command, path string) (*pluginResult, error) {
+	var plugin *exec.Cmd
+	if runtime.GOOS == "windows" {
+		plugin = exec.Command("cmd", "/C", strings.Replace(command, "$1", path, -1))
+	} else {
+		plugin = exec.Command("/bin/sh", "-c", command, "sh", path)
+	}
+
+	var output bytes.Buffer
+	plugin.Stdout = &output
+	if err := plugin.Run(); err != nil {
+		return nil, fmt.Errorf("'%v' failed: %v", command, err)
+	}
+
+	var result pluginResult
+	if err := json.Unmarshal(output.Bytes(), &result); err != nil {
+		return nil, fmt.Errorf("'%v' produced invalid output: %v", command, err)
+	}
+
+	return &result, nil
+}
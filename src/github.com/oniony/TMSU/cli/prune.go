@@ -0,0 +1,187 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage"
+)
+
+var PruneCommand = Command{
+	Name:     "prune",
+	Synopsis: "Remove unused tags, values and files",
+	Usages:   []string{"tmsu prune [OPTION]..."},
+	Description: `Removes from the database the records that no longer serve any purpose, reporting each as it is removed:
+
+  * tags that are not applied to any file
+  * values that are not applied to any file
+  * files that have no tags
+
+Tags and values retained for forgotten files or for fingerprints are not considered unused.
+
+Tags and values that are referenced by an implication are kept unless --implications is specified, in which case they are removed together with their implications. --implications also removes any implications that refer to tags or values that no longer exist.
+
+Use --pretend to report what would be removed without changing the database.`,
+	Examples: []string{`$ tmsu prune
+tag 'draft': removed
+value 'wip': removed
+/home/bob/notes.txt: removed`,
+		`$ tmsu prune --implications --pretend
+tag 'draft': unused
+implication 'draft -> todo': unused`},
+	Options: Options{{"--implications", "-i", "also remove unused tags and values referenced by implications", false, ""},
+		{"--pretend", "-P", "do not make any changes", false, ""}},
+	Exec: pruneExec,
+}
+
+// unexported
+
+func pruneExec(options Options, args []string, databasePath string) (error, warnings) {
+	if len(args) > 0 {
+		return fmt.Errorf("too many arguments"), nil
+	}
+
+	implications := options.HasOption("--implications")
+	pretend := options.HasOption("--pretend")
+
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	tx, err := store.Begin()
+	if err != nil {
+		return err, nil
+	}
+
+	if err := prune(store, tx, implications, pretend); err != nil {
+		tx.Rollback()
+		return err, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err, nil
+	}
+
+	return nil, nil
+}
+
+func prune(store *storage.Storage, tx *storage.Tx, implications, pretend bool) error {
+	log.Debug("identifying unused tags")
+
+	tags, err := store.UnusedTags(tx, implications)
+	if err != nil {
+		return fmt.Errorf("could not retrieve unused tags: %v", err)
+	}
+
+	log.Debug("identifying unused values")
+
+	values, err := store.UnusedValues(tx, implications)
+	if err != nil {
+		return fmt.Errorf("could not retrieve unused values: %v", err)
+	}
+
+	if implications {
+		if err := pruneImplications(store, tx, tags, values, pretend); err != nil {
+			return err
+		}
+	}
+
+	for _, tag := range tags {
+		reportPruned(fmt.Sprintf("tag '%v'", tag.Name), "unused", pretend)
+
+		if !pretend {
+			if err := store.DeleteTag(tx, tag.Id); err != nil {
+				return fmt.Errorf("could not delete tag '%v': %v", tag.Name, err)
+			}
+		}
+	}
+
+	for _, value := range values {
+		reportPruned(fmt.Sprintf("value '%v'", value.Name), "unused", pretend)
+
+		if !pretend {
+			if err := store.DeleteValue(tx, value.Id); err != nil {
+				return fmt.Errorf("could not delete value '%v': %v", value.Name, err)
+			}
+		}
+	}
+
+	log.Debug("identifying untagged files")
+
+	files, err := store.UntaggedFiles(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve untagged files: %v", err)
+	}
+
+	for _, file := range files {
+		reportPruned(file.Path(), "untagged", pretend)
+	}
+
+	if !pretend && len(files) > 0 {
+		if err := deleteUntaggedFiles(store, tx, files); err != nil {
+			return fmt.Errorf("could not remove untagged files: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Reports the implications that refer to the unused tags or values, which are
+// removed along with them, and removes those referring to missing ones.
+func pruneImplications(store *storage.Storage, tx *storage.Tx, tags entities.Tags, values entities.Values, pretend bool) error {
+	log.Debug("identifying implications of unused tags and values")
+
+	implications, err := store.Implications(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve implications: %v", err)
+	}
+
+	for _, implication := range implications {
+		if tags.Contains(&implication.ImplyingTag) || tags.Contains(&implication.ImpliedTag) ||
+			values.Contains(&implication.ImplyingValue) || values.Contains(&implication.ImpliedValue) {
+			reportPruned(fmt.Sprintf("implication '%v -> %v'", implyingName(*implication), impliedName(*implication)), "unused", pretend)
+		}
+	}
+
+	orphans, err := store.ImplicationOrphanCounts(tx)
+	if err != nil {
+		return fmt.Errorf("could not check for orphaned implications: %v", err)
+	}
+
+	for _, orphan := range orphans {
+		reportPruned(fmt.Sprintf("%v %v", orphan.Count, orphan.Description), "orphaned", pretend)
+	}
+
+	if !pretend && len(orphans) > 0 {
+		if err := store.DeleteImplicationOrphans(tx); err != nil {
+			return fmt.Errorf("could not remove orphaned implications: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func reportPruned(item, reason string, pretend bool) {
+	if pretend {
+		fmt.Printf("%v: %v\n", item, reason)
+	} else {
+		fmt.Printf("%v: removed\n", item)
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	_path "github.com/oniony/TMSU/common/path"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+var RelateCommand = Command{
+	Name:     "relate",
+	Synopsis: "Relate files to one another",
+	Usages: []string{"tmsu relate [OPTION]... --as=RELATION FILE RELATED",
+		"tmsu relate [OPTION]... --delete --as=RELATION FILE RELATED",
+		"tmsu relate FILE..."},
+	Description: `Records that the file FILE has the RELATION to the file RELATED, for example that a JPEG is 'derived-from' a RAW image or that a track is 'part-of-set' with another. If no RELATION is specified then 'related' is assumed.
+
+With --copy-tags the tags explicitly applied to RELATED are first copied to FILE, as per the 'copy-tags' subcommand, so that, for example, a transcoded file is given the tags of its original. Otherwise both files must already be tagged. A file's relations are removed along with it when it is no longer tagged.
+
+With --delete the relation is removed instead.
+
+If only FILEs are specified then the relations from and to each are listed.
+
+The files related to a file, in either direction, can be queried using the 'related-to:PATH' term. See the 'files' subcommand.`,
+	Examples: []string{"$ tmsu relate --as=derived-from IMG_0001.jpg IMG_0001.cr2",
+		"$ tmsu relate --copy-tags --as=transcoded-from song.mp3 song.flac",
+		"$ tmsu relate IMG_0001.jpg\n./IMG_0001.jpg derived-from ./IMG_0001.cr2",
+		"$ tmsu files related-to:IMG_0001.cr2\n./IMG_0001.jpg",
+		"$ tmsu relate --delete --as=derived-from IMG_0001.jpg IMG_0001.cr2"},
+	Options: Options{{"--as", "-a", "the RELATION of FILE to RELATED (default: related)", true, ""},
+		{"--delete", "-d", "remove the relation", false, ""},
+		{"--copy-tags", "-c", "copy the tags of RELATED to FILE", false, ""}},
+	Exec: relateExec,
+}
+
+// unexported
+
+const defaultRelation = "related"
+
+func relateExec(options Options, args []string, databasePath string) (error, warnings) {
+	if len(args) < 1 {
+		return fmt.Errorf("too few arguments"), nil
+	}
+
+	relation := defaultRelation
+	if options.HasOption("--as") {
+		relation = options.Get("--as").Argument
+		if err := validateRelation(relation); err != nil {
+			return err, nil
+		}
+	}
+
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	tx, err := store.Begin()
+	if err != nil {
+		return err, nil
+	}
+	defer tx.Commit()
+
+	if !options.HasOption("--as") && !options.HasOption("--delete") && !options.HasOption("--copy-tags") {
+		return listRelations(store, tx, args)
+	}
+
+	if len(args) != 2 {
+		return fmt.Errorf("a single file and the file it is related to must be specified"), nil
+	}
+
+	if options.HasOption("--delete") {
+		return deleteRelation(store, tx, args[0], args[1], relation)
+	}
+
+	return addRelation(store, tx, args[0], args[1], relation, options.HasOption("--copy-tags"))
+}
+
+func validateRelation(relation string) error {
+	if relation == "" {
+		return fmt.Errorf("relation cannot be empty")
+	}
+	if strings.IndexFunc(relation, unicode.IsSpace) != -1 {
+		return fmt.Errorf("relation '%v' cannot contain whitespace", relation)
+	}
+
+	return nil
+}
+
+func addRelation(store *storage.Storage, tx *storage.Tx, path, relatedPath, relation string, copyTags bool) (error, warnings) {
+	warnings := make(warnings, 0, 10)
+
+	if copyTags {
+		absRelatedPath, err := filepath.Abs(relatedPath)
+		if err != nil {
+			return fmt.Errorf("%v: could not get absolute path: %v", relatedPath, err), warnings
+		}
+
+		err, tagWarnings := tagFrom(store, tx, absRelatedPath, []string{path}, true, false, false, false, symlinkFollow, false, 1, nil)
+		warnings = append(warnings, tagWarnings...)
+		if err != nil {
+			return err, warnings
+		}
+	}
+
+	file, err := relatedFile(store, tx, path)
+	if err != nil {
+		return err, warnings
+	}
+
+	related, err := relatedFile(store, tx, relatedPath)
+	if err != nil {
+		return err, warnings
+	}
+
+	if file.Id == related.Id {
+		return fmt.Errorf("%v: a file cannot be related to itself", path), warnings
+	}
+
+	if _, err := store.AddFileRelation(tx, file.Id, related.Id, relation); err != nil {
+		return fmt.Errorf("could not relate files: %v", err), warnings
+	}
+
+	return nil, warnings
+}
+
+func deleteRelation(store *storage.Storage, tx *storage.Tx, path, relatedPath, relation string) (error, warnings) {
+	file, err := relatedFile(store, tx, path)
+	if err != nil {
+		return err, nil
+	}
+
+	related, err := relatedFile(store, tx, relatedPath)
+	if err != nil {
+		return err, nil
+	}
+
+	if err := store.DeleteFileRelation(tx, file.Id, related.Id, relation); err != nil {
+		return fmt.Errorf("%v: no such relation '%v' to '%v'", path, relation, relatedPath), nil
+	}
+
+	return nil, nil
+}
+
+func listRelations(store *storage.Storage, tx *storage.Tx, paths []string) (error, warnings) {
+	warnings := make(warnings, 0, 10)
+
+	for _, path := range paths {
+		file, err := relatedFile(store, tx, path)
+		if err != nil {
+			warnings = append(warnings, err.Error())
+			continue
+		}
+
+		relations, err := store.FileRelationsByFileId(tx, file.Id)
+		if err != nil {
+			return fmt.Errorf("%v: could not retrieve relations: %v", path, err), warnings
+		}
+
+		for _, relation := range relations {
+			from, err := relationPath(store, tx, relation.FileId)
+			if err != nil {
+				return err, warnings
+			}
+
+			to, err := relationPath(store, tx, relation.RelatedFileId)
+			if err != nil {
+				return err, warnings
+			}
+
+			fmt.Printf("%v %v %v\n", escape(from, ' '), relation.Relation, escape(to, ' '))
+		}
+	}
+
+	return nil, warnings
+}
+
+// Retrieves the file at the path, which must be tagged to be related.
+func relatedFile(store *storage.Storage, tx *storage.Tx, path string) (*entities.File, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("%v: could not get absolute path: %v", path, err)
+	}
+
+	file, err := store.FileByPath(tx, absPath)
+	if err != nil {
+		return nil, fmt.Errorf("%v: could not retrieve file: %v", path, err)
+	}
+	if file == nil {
+		return nil, fmt.Errorf("%v: path is not tagged", path)
+	}
+
+	return file, nil
+}
+
+func relationPath(store *storage.Storage, tx *storage.Tx, fileId entities.FileId) (string, error) {
+	file, err := store.File(tx, fileId)
+	if err != nil {
+		return "", fmt.Errorf("could not retrieve file #%v: %v", fileId, err)
+	}
+	if file == nil {
+		return "", fmt.Errorf("file #%v does not exist", fileId)
+	}
+
+	return _path.Rel(file.Path()), nil
+}
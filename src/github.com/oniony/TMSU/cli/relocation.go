@@ -0,0 +1,141 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/oniony/TMSU/common/fingerprint"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage"
+	"io"
+	"os"
+	"strings"
+)
+
+// unexported
+
+// Decides, when a path being tagged has the content of a file in the database
+// that is missing, whether the path is the missing file moved, in which case
+// its entry is relocated, with its tags, rather than a duplicate added.
+type relocator struct {
+	automatic bool
+
+	// the terminal from which the user is asked, or nil if they cannot be
+	reader *bufio.Reader
+}
+
+// Relocates automatically or, if the user can be asked, upon confirmation.
+func newRelocator(automatic bool) *relocator {
+	relocations := relocator{automatic, nil}
+	if !automatic && stdinIsCharDevice() {
+		relocations.reader = bufio.NewReader(os.Stdin)
+	}
+
+	return &relocations
+}
+
+// Relocates the missing file with the fingerprint, if there is one, to the path
+// being tagged, returning the relocated file or nil if none was.
+func (relocations *relocator) relocate(store *storage.Storage, tx *storage.Tx, path, absPath string, fp fingerprint.Fingerprint, stat os.FileInfo) (*entities.File, error) {
+	if relocations == nil || fp == fingerprint.Empty || stat.IsDir() || stat.Size() == 0 {
+		return nil, nil
+	}
+
+	missing, err := missingFileByFingerprint(store, tx, fp, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+	if missing == nil {
+		return nil, nil
+	}
+
+	switch {
+	case relocations.automatic:
+	case relocations.reader != nil:
+		confirmed, err := confirmRelocation(relocations.reader, path, missing.Path())
+		if err != nil || !confirmed {
+			return nil, err
+		}
+	default:
+		log.Warnf("%v: has the content of missing file '%v': use --auto-relocate to relocate it", path, missing.Path())
+		return nil, nil
+	}
+
+	file, err := relocateFile(store, tx, missing, absPath, fp, stat)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Warnf("%v: relocated from '%v'", path, missing.Path())
+
+	return file, nil
+}
+
+// Retrieves a file in the database with the fingerprint and size that no longer
+// exists at its path.
+func missingFileByFingerprint(store *storage.Storage, tx *storage.Tx, fp fingerprint.Fingerprint, size int64) (*entities.File, error) {
+	files, err := store.FilesByFingerprint(tx, fp)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		if file.IsDir || file.Size != size {
+			continue
+		}
+
+		if _, err := os.Lstat(file.Path()); os.IsNotExist(err) {
+			return file, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Updates the database entry of a file that has moved to the path.
+func relocateFile(store *storage.Storage, tx *storage.Tx, dbFile *entities.File, path string, fp fingerprint.Fingerprint, stat os.FileInfo) (*entities.File, error) {
+	file, err := store.UpdateFile(tx, dbFile.Id, path, fp, stat.ModTime(), stat.Size(), stat.IsDir())
+	if err != nil {
+		return nil, fmt.Errorf("%v: could not update file in database: %v", dbFile.Path(), err)
+	}
+
+	return file, nil
+}
+
+// Asks whether the missing file has moved to the path.
+func confirmRelocation(reader *bufio.Reader, path, missingPath string) (bool, error) {
+	for {
+		fmt.Printf("%v: relocate missing file '%v' here? [y/N]: ", path, missingPath)
+
+		answer, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "", "n", "no":
+			return false, nil
+		case "y", "yes":
+			return true, nil
+		}
+
+		if err == io.EOF {
+			return false, nil
+		}
+	}
+}
@@ -18,21 +18,32 @@ package cli
 import (
 	"fmt"
 	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/entities"
 	"github.com/oniony/TMSU/storage"
+	"regexp"
+	"sort"
 )
 
 var RenameCommand = Command{
 	Name:     "rename",
 	Aliases:  []string{"mv"},
 	Synopsis: "Rename a tag or value",
-	Usages:   []string{"tmsu rename [OPTION]... OLD NEW"},
+	Usages: []string{"tmsu rename [OPTION]... OLD NEW",
+		"tmsu rename [OPTION]... --regex PATTERN REPLACEMENT"},
 	Description: `Renames a tag or value from OLD to NEW.
 
+With --regex every tag or value whose name matches the regular expression PATTERN is renamed, the new name being PATTERN's match replaced by REPLACEMENT, in which $1, $2 &c. refer to the captured groups. An empty REPLACEMENT removes the match. The mapping is printed before the tags or values are renamed.
+
 Attempting to rename a tag or value with a name that already exists will result in an error. To merge tags or values use the 'merge' subcommand instead.`,
 	Examples: []string{"$ tmsu rename montain mountain",
-		"$ tmsu rename --value MMXVII 2017"},
-	Options: Options{{"--value", "", "rename a value", false, ""}},
-	Exec:    renameExec,
+		"$ tmsu rename --value MMXVII 2017",
+		"$ tmsu rename --regex '^prj-(.*)$' 'project/$1'",
+		"$ tmsu rename --regex '^prj-' ''",
+		"$ tmsu --dry-run rename --regex '^prj-(.*)$' 'project/$1'"},
+	Options: Options{{"--value", "", "rename a value", false, ""},
+		{"--regex", "-r", "rename all tags or values matching a regular expression", false, ""}},
+	Exec:           renameExec,
+	EmptyArguments: true,
 }
 
 // unexported
@@ -46,8 +57,10 @@ func renameExec(options Options, args []string, databasePath string) (error, war
 		return fmt.Errorf("too many arguments"), nil
 	}
 
-	currentName := parseTagOrValueName(args[0])
-	newName := parseTagOrValueName(args[1])
+	// only the replacement of a regular expression may be empty
+	if args[0] == "" || (args[1] == "" && !options.HasOption("--regex")) {
+		return fmt.Errorf("invalid empty argument"), nil
+	}
 
 	store, err := openDatabase(databasePath)
 	if err != nil {
@@ -61,6 +74,22 @@ func renameExec(options Options, args []string, databasePath string) (error, war
 	}
 	defer tx.Commit()
 
+	if options.HasOption("--regex") {
+		expression, err := regexp.Compile(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid regular expression '%v': %v", args[0], err), nil
+		}
+
+		if options.HasOption("--value") {
+			return renameValuesMatching(store, tx, expression, args[1]), nil
+		}
+
+		return renameTagsMatching(store, tx, expression, args[1]), nil
+	}
+
+	currentName := parseTagOrValueName(args[0])
+	newName := parseTagOrValueName(args[1])
+
 	if options.HasOption("--value") {
 		return renameValue(store, tx, currentName, newName), nil
 	}
@@ -68,13 +97,141 @@ func renameExec(options Options, args []string, databasePath string) (error, war
 	return renameTag(store, tx, currentName, newName), nil
 }
 
+// A change of name resulting from a regular expression replacement.
+type renaming struct {
+	from string
+	to   string
+}
+
+// Determines the new names of those names matching the expression, ordered by
+// current name.
+func renamingsMatching(names []string, expression *regexp.Regexp, replacement string) []renaming {
+	renamings := make([]renaming, 0, 10)
+	for _, name := range names {
+		if !expression.MatchString(name) {
+			continue
+		}
+
+		newName := expression.ReplaceAllString(name, replacement)
+		if newName == name {
+			continue
+		}
+
+		renamings = append(renamings, renaming{name, newName})
+	}
+
+	sort.Slice(renamings, func(i, j int) bool { return renamings[i].from < renamings[j].from })
+
+	return renamings
+}
+
+func printRenamings(renamings []renaming) {
+	for _, renaming := range renamings {
+		fmt.Printf("%v -> %v\n", renaming.from, renaming.to)
+	}
+}
+
+// Checks that the renamings neither collide with each other nor with an
+// existing name, so that nothing is renamed unless everything can be.
+func validateRenamings(renamings []renaming, existingNames []string, validate func(string) error, kind string) error {
+	destinations := make(map[string]string, len(renamings))
+	for _, renaming := range renamings {
+		if err := validate(renaming.to); err != nil {
+			return fmt.Errorf("cannot rename %v '%v' to '%v': %v", kind, renaming.from, renaming.to, err)
+		}
+
+		if other, found := destinations[renaming.to]; found {
+			return fmt.Errorf("%vs '%v' and '%v' would both be renamed '%v': use 'merge' to combine them", kind, other, renaming.from, renaming.to)
+		}
+		destinations[renaming.to] = renaming.from
+
+		if containsString(existingNames, renaming.to) {
+			return fmt.Errorf("%v '%v' already exists", kind, renaming.to)
+		}
+	}
+
+	return nil
+}
+
+func renameTagsMatching(store *storage.Storage, tx *storage.Tx, expression *regexp.Regexp, replacement string) error {
+	tags, err := store.Tags(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tags: %v", err)
+	}
+
+	names := namesOfTags(tags)
+	renamings := renamingsMatching(names, expression, replacement)
+	if len(renamings) == 0 {
+		return fmt.Errorf("no tags match '%v'", expression)
+	}
+
+	if err := validateRenamings(renamings, names, entities.ValidateTagName, "tag"); err != nil {
+		return err
+	}
+
+	printRenamings(renamings)
+
+	for _, renaming := range renamings {
+		if err := renameTag(store, tx, renaming.from, renaming.to); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func renameValuesMatching(store *storage.Storage, tx *storage.Tx, expression *regexp.Regexp, replacement string) error {
+	values, err := store.Values(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve values: %v", err)
+	}
+
+	names := namesOfValues(values)
+	renamings := renamingsMatching(names, expression, replacement)
+	if len(renamings) == 0 {
+		return fmt.Errorf("no values match '%v'", expression)
+	}
+
+	if err := validateRenamings(renamings, names, entities.ValidateValueName, "value"); err != nil {
+		return err
+	}
+
+	printRenamings(renamings)
+
+	for _, renaming := range renamings {
+		if err := renameValue(store, tx, renaming.from, renaming.to); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func namesOfTags(tags entities.Tags) []string {
+	names := make([]string, len(tags))
+	for index, tag := range tags {
+		names[index] = tag.Name
+	}
+
+	return names
+}
+
+func namesOfValues(values entities.Values) []string {
+	names := make([]string, len(values))
+	for index, value := range values {
+		names[index] = value.Name
+	}
+
+	return names
+}
+
 func renameTag(store *storage.Storage, tx *storage.Tx, currentName, newName string) error {
 	sourceTag, err := store.TagByName(tx, currentName)
 	if err != nil {
 		return fmt.Errorf("could not retrieve tag '%v': %v", currentName, err)
 	}
 	if sourceTag == nil {
-		return fmt.Errorf("no such tag '%v'", currentName)
+		return NoSuchTagError{currentName, nil}
 	}
 
 	destTag, err := store.TagByName(tx, newName)
@@ -85,7 +242,7 @@ func renameTag(store *storage.Storage, tx *storage.Tx, currentName, newName stri
 		return fmt.Errorf("tag '%v' already exists", newName)
 	}
 
-	log.Infof(2, "renaming tag '%v' to '%v'.", currentName, newName)
+	log.Debugf("renaming tag '%v' to '%v'.", currentName, newName)
 
 	_, err = store.RenameTag(tx, sourceTag.Id, newName)
 	if err != nil {
@@ -101,7 +258,7 @@ func renameValue(store *storage.Storage, tx *storage.Tx, currentName, newName st
 		return fmt.Errorf("could not retrieve value '%v': %v", currentName, err)
 	}
 	if sourceValue == nil {
-		return fmt.Errorf("no such value '%v'", currentName)
+		return NoSuchValueError{currentName}
 	}
 
 	destValue, err := store.ValueByName(tx, newName)
@@ -112,7 +269,7 @@ func renameValue(store *storage.Storage, tx *storage.Tx, currentName, newName st
 		return fmt.Errorf("value '%v' already exists", newName)
 	}
 
-	log.Infof(2, "renaming value '%v' to '%v'.", currentName, newName)
+	log.Debugf("renaming value '%v' to '%v'.", currentName, newName)
 
 	_, err = store.RenameValue(tx, sourceValue.Id, newName)
 	if err != nil {
@@ -25,7 +25,9 @@ import (
 	"github.com/oniony/TMSU/storage"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 )
 
 var RepairCommand = Command{
@@ -38,21 +40,44 @@ var RepairCommand = Command{
 
 Modified files are identified by a change to the file's modification time or file size. These files are repaired by updating the details in the database.
 
-An attempt is made to find missing files under PATHs specified. If a file with the same fingerprint is found then the database is updated with the new file's details. If no PATHs are specified, or no match can be found, then the file is instead reported as missing.
+An attempt is made to find missing files under PATHs specified. If an untagged file with the same size and fingerprint is found then the database is updated with the new file's details. If no PATHs are specified then those of the 'searchPaths' setting are searched instead: a list of paths separated by the platform's path list separator (':' on Linux) where relative paths are relative to the database root. If there are no paths to search, or no match can be found, then the file is instead reported as missing. Files and directories matching the 'ignorePatterns' setting or a '.tmsuignore' file are not searched.
 
-Files that have been both moved and modified cannot be repaired and must be manually relocated.
+Files that have been both moved and modified cannot be repaired and must be manually relocated, unless they were moved within a git repository: with --git, missing files are first looked for at the paths to which git's rename detection, as used by 'git log --follow', shows them to have been moved. The renames must have been committed or staged (e.g. by 'git mv'). Directories are followed by way of the files within them.
 
-When run with the --manual option, any paths that begin with OLD are updated to begin with NEW. Any affected files' fingerprints are updated providing the file exists at the new location. No further repairs are attempted in this mode.`,
+With --forget, missing files are instead forgotten: they are removed from the database but their details and tags are retained for the number of days given by the 'forgottenRetention' setting (zero to retain them indefinitely). Should a forgotten file reappear at its old location, or an untagged file with the same size and fingerprint be found under the PATHs searched, it is restored along with its tags. Forgotten files that have expired are removed permanently. (See also the 'forget' and 'restore' subcommands.)
+
+With --incremental, the files in directories whose modification time is unchanged since the last repair are assumed to be unmodified and are not examined, so that a routine repair of a large tree that has seen few changes is quick. Adding, removing or renaming a file changes the modification time of its directory but modifying a file's content in place does not: such modifications are only found by a repair without --incremental, which should therefore still be run from time to time. Directories with missing files are always examined. The search for moved files is unaffected.
+
+The files on volumes that are not mounted are skipped rather than reported as missing. (See the 'volume' subcommand.)
+
+With --templates, the files in the database are also tagged according to the templates of the 'pathTemplates' setting, such that the tags derived from their paths are applied to files added before a template was configured or that have since moved. (See 'tmsu help config'.)
+
+Untagged files under the PATHs searched that have been tagged by fingerprint (see 'tag --fingerprint') are added to the database with those tags. As each untagged file must be fingerprinted, this can be slow for large search paths.
+
+When run with the --manual option, any paths that begin with OLD are updated to begin with NEW, within a single transaction. Any affected files' fingerprints are updated providing the file exists at the new location. No further repairs are attempted in this mode. When a whole volume is mounted elsewhere, --paths-only rewrites the paths without examining the files at all, so that this is quick even for many files and possible before the volume is mounted at its new location.`,
 	Examples: []string{"$ tmsu repair",
 		"$ tmsu repair /new/path  # look for missing files here",
+		"$ tmsu config searchPaths=/media/photos:/media/backup",
+		"$ tmsu repair  # look for missing files under the search paths",
 		"$ tmsu repair --path=/home/sally  # repair subset of database",
-		"$ tmsu repair --manual /home/bob /home/fred  # manually repair paths"},
+		"$ tmsu repair --git  # follow files renamed in git repositories",
+		"$ tmsu repair --incremental  # skip unchanged directories",
+		"$ tmsu repair --forget  # forget missing files until they are restored",
+		"$ tmsu repair --templates  # apply the tags derived from file paths",
+		"$ tmsu repair --manual /home/bob /home/fred  # manually repair paths",
+		"$ tmsu repair --manual --paths-only /media/old /media/new  # remap a volume"},
 	Options: Options{{"--path", "-p", "limit repair to files in database under path", true, ""},
 		{"--pretend", "-P", "do not make any changes", false, ""},
 		{"--remove", "-R", "remove missing files from the database", false, ""},
+		{"--forget", "-F", "forget missing files, retaining their tags until restored", false, ""},
+		{"--git", "-g", "follow files renamed in git repositories", false, ""},
+		{"--incremental", "-i", "skip directories unchanged since the last repair", false, ""},
 		{"--manual", "-m", "manually relocate files", false, ""},
+		{"--paths-only", "", "with --manual, rewrite the paths without examining the files", false, ""},
 		{"--unmodified", "-u", "recalculate fingerprints for unmodified files", false, ""},
-		{"--rationalize", "", "remove explicit taggings where an implicit tagging exists", false, ""}},
+		{"--rationalize", "", "remove explicit taggings where an implicit tagging exists", false, ""},
+		{"--templates", "-t", "apply the tags the 'pathTemplates' setting derives from file paths", false, ""},
+		{"--jobs", "-j", "fingerprint up to N files at once (default: the number of processors)", true, ""}},
 	Exec: repairExec,
 }
 
@@ -81,21 +106,49 @@ func repairExec(options Options, args []string, databasePath string) (error, war
 		fromPath := args[0]
 		toPath := args[1]
 
-		if err := manualRepair(store, tx, fromPath, toPath, pretend); err != nil {
+		if !pretend {
+			if err := fireHooks(store, tx, hookEvent{"repair", []string{fromPath, toPath}, nil, nil}); err != nil {
+				return err, nil
+			}
+		}
+
+		pathsOnly := options.HasOption("--paths-only")
+
+		if err := manualRepair(store, tx, fromPath, toPath, pathsOnly, pretend); err != nil {
 			return err, nil
 		}
 	} else {
 		searchPaths := args
 		removeMissing := options.HasOption("--remove")
+		forgetMissing := options.HasOption("--forget")
 		recalcUnmodified := options.HasOption("--unmodified")
 		rationalize := options.HasOption("--rationalize")
+		useGit := options.HasOption("--git")
+		incremental := options.HasOption("--incremental")
+		applyTemplates := options.HasOption("--templates")
+
+		jobs, err := parseJobs(options)
+		if err != nil {
+			return err, nil
+		}
 
 		limitPath := ""
 		if options.HasOption("--path") {
 			limitPath = options.Get("--path").Argument
 		}
 
-		if err := fullRepair(store, tx, searchPaths, limitPath, removeMissing, recalcUnmodified, rationalize, pretend); err != nil {
+		if !pretend {
+			paths := searchPaths
+			if limitPath != "" {
+				paths = []string{limitPath}
+			}
+
+			if err := fireHooks(store, tx, hookEvent{"repair", paths, nil, nil}); err != nil {
+				return err, nil
+			}
+		}
+
+		if err := fullRepair(store, tx, searchPaths, limitPath, removeMissing, forgetMissing, recalcUnmodified, rationalize, useGit, incremental, applyTemplates, pretend, jobs); err != nil {
 			return err, nil
 		}
 	}
@@ -103,7 +156,7 @@ func repairExec(options Options, args []string, databasePath string) (error, war
 	return nil, nil
 }
 
-func manualRepair(store *storage.Storage, tx *storage.Tx, fromPath, toPath string, pretend bool) error {
+func manualRepair(store *storage.Storage, tx *storage.Tx, fromPath, toPath string, pathsOnly, pretend bool) error {
 	absFromPath, err := filepath.Abs(fromPath)
 	if err != nil {
 		return fmt.Errorf("%v: could not determine absolute path", err)
@@ -114,79 +167,97 @@ func manualRepair(store *storage.Storage, tx *storage.Tx, fromPath, toPath strin
 		return fmt.Errorf("%v: could not determine absolute path", err)
 	}
 
-	log.Infof(2, "retrieving files under '%v' from the database", fromPath)
+	if pretend {
+		return reportManualRepair(store, tx, absFromPath, absToPath)
+	}
+
+	log.Debugf("relocating files under '%v' to '%v'", fromPath, toPath)
 
-	dbFile, err := store.FileByPath(tx, absFromPath)
+	files, err := store.RelocateFiles(tx, absFromPath, absToPath)
 	if err != nil {
-		return fmt.Errorf("%v: could not retrieve file: %v", fromPath, err)
+		return err
 	}
 
-	if dbFile != nil {
-		log.Infof(2, "%v: updating to %v", fromPath, toPath)
+	if pathsOnly {
+		return nil
+	}
 
-		if !pretend {
-			if err := manualRepairFile(store, tx, dbFile, absToPath); err != nil {
-				return err
-			}
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := manualRepairFile(store, tx, file, settings); err != nil {
+			return err
 		}
 	}
 
+	return nil
+}
+
+func reportManualRepair(store *storage.Storage, tx *storage.Tx, absFromPath, absToPath string) error {
+	log.Debugf("retrieving files under '%v' from the database", absFromPath)
+
 	dbFiles, err := store.FilesByDirectory(tx, absFromPath)
 	if err != nil {
 		return fmt.Errorf("could not retrieve files from storage: %v", err)
 	}
 
-	for _, dbFile = range dbFiles {
-		relFileFromPath := _path.Rel(dbFile.Path())
-		absFileToPath := strings.Replace(dbFile.Path(), absFromPath, absToPath, 1)
-		relFileToPath := _path.Rel(absFileToPath)
-
-		log.Infof(2, "%v: updating to %v", relFileFromPath, relFileToPath)
+	dbFile, err := store.FileByPath(tx, absFromPath)
+	if err != nil {
+		return fmt.Errorf("%v: could not retrieve file: %v", absFromPath, err)
+	}
+	if dbFile != nil {
+		dbFiles = append(entities.Files{dbFile}, dbFiles...)
+	}
 
-		if !pretend {
-			if err := manualRepairFile(store, tx, dbFile, absFileToPath); err != nil {
-				return err
-			}
+	for _, dbFile := range dbFiles {
+		relPath, err := filepath.Rel(absFromPath, dbFile.Path())
+		if err != nil {
+			return err
 		}
+
+		log.Debugf("%v: updating to %v", _path.Rel(dbFile.Path()), _path.Rel(filepath.Join(absToPath, relPath)))
 	}
 
 	return nil
 }
 
-func manualRepairFile(store *storage.Storage, tx *storage.Tx, file *entities.File, toPath string) error {
-	stat, err := os.Stat(toPath)
+// Updates the details of a relocated file, providing it exists at its new
+// location.
+func manualRepairFile(store *storage.Storage, tx *storage.Tx, file *entities.File, settings entities.Settings) error {
+	path := file.Path()
+
+	stat, err := os.Stat(path)
 	if err != nil {
 		switch {
 		case os.IsPermission(err):
-			return fmt.Errorf("%v: permission denied", toPath)
+			return fmt.Errorf("%v: permission denied", path)
 		case os.IsNotExist(err):
-			return fmt.Errorf("%v: file not found", toPath)
+			log.Debugf("%v: file not found: leaving details as they are", path)
+			return nil
 		default:
 			return err
 		}
-	} else {
-		settings, err := store.Settings(tx)
-		if err != nil {
-			return err
-		}
+	}
 
-		fingerprint, err := fingerprint.Create(toPath, settings.FileFingerprintAlgorithm(), settings.DirectoryFingerprintAlgorithm(), settings.SymlinkFingerprintAlgorithm())
-		if err != nil {
-			log.Warnf("%v: could not create fingerprint: %v", toPath, err)
-			fingerprint = file.Fingerprint
+	fingerprint, err := fingerprint.CreateContext(commandContext, path, settings.FileFingerprintAlgorithm(), settings.DirectoryFingerprintAlgorithm(), settings.SymlinkFingerprintAlgorithm())
+	if err != nil {
+		if commandContext.Err() != nil {
+			return err
 		}
 
-		modTime := stat.ModTime()
-		size := stat.Size()
-		isDir := stat.IsDir()
+		log.Warnf("%v: could not create fingerprint: %v", path, err)
+		fingerprint = file.Fingerprint
+	}
 
-		_, err = store.UpdateFile(tx, file.Id, toPath, fingerprint, modTime, size, isDir)
+	_, err = store.UpdateFile(tx, file.Id, path, fingerprint, stat.ModTime(), stat.Size(), stat.IsDir())
 
-		return err
-	}
+	return err
 }
 
-func fullRepair(store *storage.Storage, tx *storage.Tx, searchPaths []string, limitPath string, removeMissing, recalcUnmodified, rationalize, pretend bool) error {
+func fullRepair(store *storage.Storage, tx *storage.Tx, searchPaths []string, limitPath string, removeMissing, forgetMissing, recalcUnmodified, rationalize, useGit, incremental, applyTemplates, pretend bool, jobs uint) error {
 	absLimitPath := ""
 	if limitPath != "" {
 		var err error
@@ -201,7 +272,17 @@ func fullRepair(store *storage.Storage, tx *storage.Tx, searchPaths []string, li
 		return err
 	}
 
-	log.Infof(2, "retrieving files under '%v' from the database", absLimitPath)
+	if len(searchPaths) == 0 {
+		searchPaths = configuredSearchPaths(store, settings)
+	}
+
+	if !pretend {
+		if err := store.UpdateVolumePaths(tx); err != nil {
+			return err
+		}
+	}
+
+	log.Debugf("retrieving files under '%v' from the database", absLimitPath)
 
 	dbFiles, err := store.FilesByDirectory(tx, absLimitPath)
 	if err != nil {
@@ -217,28 +298,61 @@ func fullRepair(store *storage.Storage, tx *storage.Tx, searchPaths []string, li
 		dbFiles = append(dbFiles, dbFile)
 	}
 
-	log.Infof(2, "retrieved %v files from the database for path '%v'", len(dbFiles), absLimitPath)
+	log.Debugf("retrieved %v files from the database for path '%v'", len(dbFiles), absLimitPath)
+
+	dbFiles = skipUnmountedVolumes(store, dbFiles)
+
+	journal, err := loadScanJournal(store, tx, incremental, absLimitPath)
+	if err != nil {
+		return err
+	}
+
+	unmodfied, modified, missing := determineStatuses(dbFiles, journal)
 
-	unmodfied, modified, missing := determineStatuses(dbFiles)
+	fingerprints := newFingerprinter(settings, jobs)
 
 	if recalcUnmodified {
-		if err = repairUnmodified(store, tx, unmodfied, pretend, settings); err != nil {
+		if err = repairUnmodified(store, tx, unmodfied, pretend, fingerprints); err != nil {
 			return err
 		}
 	}
 
-	if err = repairModified(store, tx, modified, pretend, settings); err != nil {
+	if err = repairModified(store, tx, modified, pretend, fingerprints); err != nil {
 		return err
 	}
 
-	if err = repairMoved(store, tx, missing, searchPaths, pretend, settings); err != nil {
+	if useGit {
+		if missing, err = repairRenamed(store, tx, missing, pretend, fingerprints); err != nil {
+			return err
+		}
+	}
+
+	if err = repairMoved(store, tx, missing, searchPaths, pretend, settings, fingerprints); err != nil {
 		return err
 	}
 
-	if err = repairMissing(store, tx, missing, pretend, removeMissing); err != nil {
+	if err = repairForgotten(store, tx, absLimitPath, searchPaths, pretend, settings); err != nil {
 		return err
 	}
 
+	if err = repairFingerprintTags(store, tx, searchPaths, pretend, settings); err != nil {
+		return err
+	}
+
+	if err = repairMissing(store, tx, missing, pretend, removeMissing, forgetMissing); err != nil {
+		return err
+	}
+
+	if err = purgeForgottenFiles(store, tx, pretend, settings); err != nil {
+		return err
+	}
+
+	if applyTemplates {
+		if err = applyPathTemplates(store, tx, dbFiles, pretend, settings); err != nil {
+			return err
+		}
+	}
+
 	if err = deleteUntaggedFiles(store, tx, dbFiles); err != nil {
 		return err
 	}
@@ -249,11 +363,37 @@ func fullRepair(store *storage.Storage, tx *storage.Tx, searchPaths []string, li
 		}
 	}
 
+	if !pretend {
+		if err = journal.save(store, tx, incremental); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// Omits the files on volumes that are not mounted, which would otherwise be
+// reported missing.
+func skipUnmountedVolumes(store *storage.Storage, files entities.Files) entities.Files {
+	skipped := make(map[string]bool)
+
+	return files.Where(func(file *entities.File) bool {
+		volume := store.VolumeForPath(file.Path())
+		if volume == nil || store.VolumeMounted(volume) {
+			return true
+		}
+
+		if !skipped[volume.Name] {
+			log.Infof("volume '%v' is not mounted: skipping its files", volume.Name)
+			skipped[volume.Name] = true
+		}
+
+		return false
+	})
+}
+
 func deleteUntaggedFiles(store *storage.Storage, tx *storage.Tx, files entities.Files) error {
-	log.Infof(2, "purging untagged files")
+	log.Debugf("purging untagged files")
 
 	fileIds := make([]entities.FileId, len(files))
 	for index, file := range files {
@@ -263,8 +403,81 @@ func deleteUntaggedFiles(store *storage.Storage, tx *storage.Tx, files entities.
 	return store.DeleteUntaggedFiles(tx, fileIds)
 }
 
+// Applies the tags the path templates derive from the files' paths, which may
+// have been repaired, that the files do not already have.
+func applyPathTemplates(store *storage.Storage, tx *storage.Tx, files entities.Files, pretend bool, settings entities.Settings) error {
+	log.Debugf("applying path templates")
+
+	templates, err := settings.PathTemplates()
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		file, err := store.File(tx, file.Id)
+		if err != nil {
+			return fmt.Errorf("could not retrieve file: %v", err)
+		}
+		if file == nil {
+			// removed as missing
+			continue
+		}
+
+		tagArgs := make([]string, 0, 10)
+		for _, tagArg := range pathTemplateTagArgs(store, templates, file.Path()) {
+			applied, err := fileHasTagArg(store, tx, file, tagArg)
+			if err != nil {
+				return err
+			}
+			if !applied {
+				tagArgs = append(tagArgs, tagArg)
+			}
+		}
+		if len(tagArgs) == 0 {
+			continue
+		}
+
+		if !pretend {
+			pairs, warnings, err := parseTagValuePairs(store, tx, settings, tagArgs, nil)
+			if err != nil {
+				return fmt.Errorf("%v: %v", file.Path(), err)
+			}
+			for _, warning := range warnings {
+				log.Warn(warning)
+			}
+
+			for _, pair := range pairs {
+				if _, err := store.AddFileTag(tx, file.Id, pair.TagId, pair.ValueId); err != nil {
+					return fmt.Errorf("%v: could not apply tags: %v", file.Path(), err)
+				}
+			}
+		}
+
+		fmt.Printf("%v: tagged %v\n", file.Path(), strings.Join(tagArgs, " "))
+	}
+
+	return nil
+}
+
+// Whether the file already has the tag and value of the TAG=VALUE argument.
+func fileHasTagArg(store *storage.Storage, tx *storage.Tx, file *entities.File, tagArg string) (bool, error) {
+	tagName, valueName := parseTagEqValueName(tagArg)
+
+	tag, err := store.TagByName(tx, tagName)
+	if err != nil || tag == nil {
+		return false, err
+	}
+
+	value, err := store.ValueByName(tx, valueName)
+	if err != nil || value == nil {
+		return false, err
+	}
+
+	return store.FileTagExists(tx, file.Id, tag.Id, value.Id, false)
+}
+
 func rationalizeFileTags(store *storage.Storage, tx *storage.Tx, files entities.Files) error {
-	log.Infof(2, "rationalizing file tags")
+	log.Debugf("rationalizing file tags")
 
 	for _, file := range files {
 		fileTags, err := store.FileTagsByFileId(tx, file.Id, false)
@@ -274,7 +487,7 @@ func rationalizeFileTags(store *storage.Storage, tx *storage.Tx, files entities.
 
 		for _, fileTag := range fileTags {
 			if fileTag.Implicit && fileTag.Explicit {
-				log.Infof(2, "%v: removing explicit tagging %v as implicit tagging exists", file.Path(), fileTag.TagId)
+				log.Debugf("%v: removing explicit tagging %v as implicit tagging exists", file.Path(), fileTag.TagId)
 
 				if err := store.DeleteFileTag(tx, fileTag.FileId, fileTag.TagId, fileTag.ValueId); err != nil {
 					return fmt.Errorf("could not delete file tag for file %v, tag %v and value %v", fileTag.FileId, fileTag.TagId, fileTag.ValueId)
@@ -286,52 +499,75 @@ func rationalizeFileTags(store *storage.Storage, tx *storage.Tx, files entities.
 	return nil
 }
 
-func determineStatuses(dbFiles entities.Files) (unmodified, modified, missing entities.Files) {
-	log.Infof(2, "determining file statuses")
+// Determines which of the files are unmodified, modified or missing. The files
+// in directories the journal shows to be unchanged are taken to be unmodified
+// without being examined.
+func determineStatuses(dbFiles entities.Files, journal *scanJournal) (unmodified, modified, missing entities.Files) {
+	log.Debugf("determining file statuses")
 
 	unmodified = make(entities.Files, 0, 10)
 	modified = make(entities.Files, 0, 10)
 	missing = make(entities.Files, 0, 10)
 
 	for _, dbFile := range dbFiles {
-		stat, err := os.Stat(dbFile.Path())
+		directory := filepath.Dir(dbFile.Path())
+
+		// a directory's own modification time reflects changes to its contents
+		if !dbFile.IsDir && journal.unchanged(directory) {
+			log.Tracef("%v: directory unchanged", dbFile.Path())
+			unmodified = append(unmodified, dbFile)
+			continue
+		}
+
+		journal.examine(directory)
+
+		stat, err := os.Lstat(dbFile.Path())
 		if err != nil {
 			switch {
 			case os.IsPermission(err):
 				//TODO return as warning
 				log.Warnf("%v: permission denied", dbFile.Path())
+				journal.markIncomplete(directory)
 				continue
 			case os.IsNotExist(err):
 				//TODO return as warning
-				log.Infof(2, "%v: missing", dbFile.Path())
+				log.Debugf("%v: missing", dbFile.Path())
 				missing = append(missing, dbFile)
+				journal.markIncomplete(directory)
 				continue
 			}
 		}
 
 		if dbFile.ModTime.Equal(stat.ModTime().UTC()) && dbFile.Size == stat.Size() {
-			log.Infof(2, "%v: unmodified", dbFile.Path())
+			log.Debugf("%v: unmodified", dbFile.Path())
 			unmodified = append(unmodified, dbFile)
 		} else {
-			log.Infof(2, "%v: modified", dbFile.Path())
+			log.Debugf("%v: modified", dbFile.Path())
 			modified = append(modified, dbFile)
+			journal.markIncomplete(directory)
 		}
 	}
 
 	return
 }
 
-func repairUnmodified(store *storage.Storage, tx *storage.Tx, unmodified entities.Files, pretend bool, settings entities.Settings) error {
-	log.Infof(2, "recalculating fingerprints for unmodified files")
+func repairUnmodified(store *storage.Storage, tx *storage.Tx, unmodified entities.Files, pretend bool, fingerprints *fingerprinter) error {
+	log.Debugf("recalculating fingerprints for unmodified files")
+
+	for index, dbFile := range unmodified {
+		fingerprints.prepareFileBatch(unmodified, index)
 
-	for _, dbFile := range unmodified {
-		stat, err := os.Stat(dbFile.Path())
+		stat, err := os.Lstat(dbFile.Path())
 		if err != nil {
 			return err
 		}
 
-		fingerprint, err := fingerprint.Create(dbFile.Path(), settings.FileFingerprintAlgorithm(), settings.DirectoryFingerprintAlgorithm(), settings.SymlinkFingerprintAlgorithm())
+		fingerprint, err := fingerprints.create(dbFile.Path())
 		if err != nil {
+			if commandContext.Err() != nil {
+				return err
+			}
+
 			log.Warnf("%v: could not create fingerprint: %v", dbFile.Path(), err)
 			continue
 		}
@@ -349,17 +585,23 @@ func repairUnmodified(store *storage.Storage, tx *storage.Tx, unmodified entitie
 	return nil
 }
 
-func repairModified(store *storage.Storage, tx *storage.Tx, modified entities.Files, pretend bool, settings entities.Settings) error {
-	log.Infof(2, "repairing modified files")
+func repairModified(store *storage.Storage, tx *storage.Tx, modified entities.Files, pretend bool, fingerprints *fingerprinter) error {
+	log.Debugf("repairing modified files")
+
+	for index, dbFile := range modified {
+		fingerprints.prepareFileBatch(modified, index)
 
-	for _, dbFile := range modified {
-		stat, err := os.Stat(dbFile.Path())
+		stat, err := os.Lstat(dbFile.Path())
 		if err != nil {
 			return err
 		}
 
-		fingerprint, err := fingerprint.Create(dbFile.Path(), settings.FileFingerprintAlgorithm(), settings.DirectoryFingerprintAlgorithm(), settings.SymlinkFingerprintAlgorithm())
+		fingerprint, err := fingerprints.create(dbFile.Path())
 		if err != nil {
+			if commandContext.Err() != nil {
+				return err
+			}
+
 			log.Warnf("%v: could not create fingerprint: %v", dbFile.Path(), err)
 			continue
 		}
@@ -377,25 +619,32 @@ func repairModified(store *storage.Storage, tx *storage.Tx, modified entities.Fi
 	return nil
 }
 
-func repairMoved(store *storage.Storage, tx *storage.Tx, missing entities.Files, searchPaths []string, pretend bool, settings entities.Settings) error {
-	log.Infof(2, "repairing moved files")
+func repairMoved(store *storage.Storage, tx *storage.Tx, missing entities.Files, searchPaths []string, pretend bool, settings entities.Settings, fingerprints *fingerprinter) error {
+	log.Debugf("repairing moved files")
 
 	if len(missing) == 0 || len(searchPaths) == 0 {
 		// don't bother enumerating filesystem if nothing to do
 		return nil
 	}
 
-	pathsBySize, err := buildPathBySizeMap(searchPaths)
+	followSymlinks := configuredSymlinkPolicy(settings).follow()
+
+	pathsBySize, err := buildPathBySizeMap(store, tx, searchPaths, followSymlinks)
 	if err != nil {
 		return err
 	}
 
+	// a candidate of a common size may be compared against many missing files
+	fingerprintByPath := make(map[string]fingerprint.Fingerprint)
+
 	for index, dbFile := range missing {
-		log.Infof(2, "%v: searching for new location", dbFile.Path())
+		log.Debugf("%v: searching for new location", dbFile.Path())
 
 		pathsOfSize := pathsBySize[dbFile.Size]
-		log.Infof(2, "%v: file is of size %v, identified %v files of this size", dbFile.Path(), dbFile.Size, len(pathsOfSize))
+		log.Debugf("%v: file is of size %v, identified %v files of this size", dbFile.Path(), dbFile.Size, len(pathsOfSize))
 
+		candidatePaths := make([]string, 0, len(pathsOfSize))
+		unfingerprinted := make([]string, 0, len(pathsOfSize))
 		for _, candidatePath := range pathsOfSize {
 			candidateFile, err := store.FileByPath(tx, candidatePath)
 			if err != nil {
@@ -406,21 +655,34 @@ func repairMoved(store *storage.Storage, tx *storage.Tx, missing entities.Files,
 				continue
 			}
 
-			stat, err := os.Stat(candidatePath)
+			candidatePaths = append(candidatePaths, candidatePath)
+			if _, ok := fingerprintByPath[candidatePath]; !ok {
+				unfingerprinted = append(unfingerprinted, candidatePath)
+			}
+		}
+
+		fingerprints.prepare(unfingerprinted)
+
+		for _, candidatePath := range candidatePaths {
+			stat, err := statPath(candidatePath, followSymlinks)
 			if err != nil {
 				return fmt.Errorf("%v: could not stat file: %v", candidatePath, err)
 			}
 
-			fingerprint, err := fingerprint.Create(candidatePath, settings.FileFingerprintAlgorithm(), settings.DirectoryFingerprintAlgorithm(), settings.SymlinkFingerprintAlgorithm())
-			if err != nil {
-				return fmt.Errorf("%v: could not create fingerprint: %v", candidatePath, err)
+			candidateFingerprint, ok := fingerprintByPath[candidatePath]
+			if !ok {
+				candidateFingerprint, err = fingerprints.create(candidatePath)
+				if err != nil {
+					return fmt.Errorf("%v: could not create fingerprint: %v", candidatePath, err)
+				}
+
+				fingerprintByPath[candidatePath] = candidateFingerprint
 			}
 
-			if fingerprint == dbFile.Fingerprint {
+			if candidateFingerprint == dbFile.Fingerprint {
 				if !pretend {
-					_, err := store.UpdateFile(tx, dbFile.Id, candidatePath, dbFile.Fingerprint, stat.ModTime(), dbFile.Size, dbFile.IsDir)
-					if err != nil {
-						return fmt.Errorf("%v: could not update file in database: %v", dbFile.Path(), err)
+					if _, err := relocateFile(store, tx, dbFile, candidatePath, dbFile.Fingerprint, stat); err != nil {
+						return err
 					}
 				}
 
@@ -436,7 +698,98 @@ func repairMoved(store *storage.Storage, tx *storage.Tx, missing entities.Files,
 	return nil
 }
 
-func repairMissing(store *storage.Storage, tx *storage.Tx, missing entities.Files, pretend, force bool) error {
+// Looks for the missing files at the paths to which git shows them to have been
+// renamed, returning those that are still missing.
+func repairRenamed(store *storage.Storage, tx *storage.Tx, missing entities.Files, pretend bool, fingerprints *fingerprinter) (entities.Files, error) {
+	log.Debugf("repairing files renamed in git repositories")
+
+	repositories := newGitRepositories()
+	remaining := make(entities.Files, 0, len(missing))
+
+	for _, dbFile := range missing {
+		repository, err := repositories.forPath(dbFile.Path())
+		if err != nil {
+			return nil, err
+		}
+		if repository == nil {
+			remaining = append(remaining, dbFile)
+			continue
+		}
+
+		newPath, ok := repository.follow(dbFile.Path())
+		if !ok {
+			log.Debugf("%v: not renamed in git", dbFile.Path())
+			remaining = append(remaining, dbFile)
+			continue
+		}
+
+		stat, err := os.Lstat(newPath)
+		if err != nil {
+			log.Debugf("%v: renamed in git to %v, which no longer exists", dbFile.Path(), newPath)
+			remaining = append(remaining, dbFile)
+			continue
+		}
+
+		newFile, err := store.FileByPath(tx, newPath)
+		if err != nil {
+			return nil, err
+		}
+		if newFile != nil {
+			log.Warnf("%v: renamed in git to %v, which is already tagged", dbFile.Path(), newPath)
+			remaining = append(remaining, dbFile)
+			continue
+		}
+
+		// the file may have been changed along with its name
+		fingerprint, err := fingerprints.create(newPath)
+		if err != nil {
+			if commandContext.Err() != nil {
+				return nil, err
+			}
+
+			log.Warnf("%v: could not create fingerprint: %v", newPath, err)
+			fingerprint = dbFile.Fingerprint
+		}
+
+		if !pretend {
+			if _, err := relocateFile(store, tx, dbFile, newPath, fingerprint, stat); err != nil {
+				return nil, err
+			}
+		}
+
+		fmt.Printf("%v: updated path to %v\n", dbFile.Path(), newPath)
+	}
+
+	return remaining, nil
+}
+
+// The search paths from the settings that exist, resolved against the
+// database root.
+func configuredSearchPaths(store *storage.Storage, settings entities.Settings) []string {
+	searchPaths := make([]string, 0, 10)
+	for _, path := range settings.SearchPaths() {
+		if path == "" {
+			continue
+		}
+
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(store.RootPath, path)
+		}
+
+		if _, err := os.Stat(path); err != nil {
+			log.Warnf("%v: could not access search path", path)
+			continue
+		}
+
+		searchPaths = append(searchPaths, path)
+	}
+
+	log.Debugf("searching configured paths: %v", strings.Join(searchPaths, ", "))
+
+	return searchPaths
+}
+
+func repairMissing(store *storage.Storage, tx *storage.Tx, missing entities.Files, pretend, force, forget bool) error {
 	for _, dbFile := range missing {
 		if dbFile == nil {
 			continue
@@ -450,6 +803,14 @@ func repairMissing(store *storage.Storage, tx *storage.Tx, missing entities.File
 			}
 
 			fmt.Printf("%v: removed\n", dbFile.Path())
+		} else if forget {
+			if !pretend {
+				if _, err := store.ForgetFile(tx, dbFile.Id); err != nil {
+					return fmt.Errorf("%v: could not forget file: %v", dbFile.Path(), err)
+				}
+			}
+
+			fmt.Printf("%v: forgotten\n", dbFile.Path())
 		} else {
 			fmt.Printf("%v: missing\n", dbFile.Path())
 		}
@@ -458,29 +819,259 @@ func repairMissing(store *storage.Storage, tx *storage.Tx, missing entities.File
 	return nil
 }
 
-func buildPathBySizeMap(paths []string) (map[int64][]string, error) {
-	log.Infof(2, "building map of paths by size")
+// Restores forgotten files that have reappeared, either at their old location
+// or, as an untagged file of the same size and fingerprint, under the search
+// paths.
+func repairForgotten(store *storage.Storage, tx *storage.Tx, absLimitPath string, searchPaths []string, pretend bool, settings entities.Settings) error {
+	log.Debugf("restoring reappeared forgotten files")
+
+	forgottenFiles, err := store.ForgottenFiles(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve forgotten files: %v", err)
+	}
+
+	remaining := make(entities.ForgottenFiles, 0, len(forgottenFiles))
+	for _, forgottenFile := range forgottenFiles {
+		path := forgottenFile.Path()
+		if absLimitPath != "" && !_path.Equal(path, absLimitPath) && !_path.HasPrefix(path, absLimitPath+string(filepath.Separator)) {
+			continue
+		}
+
+		if _, err := os.Lstat(path); err != nil {
+			remaining = append(remaining, forgottenFile)
+			continue
+		}
+
+		restored, err := restoreReappeared(store, tx, forgottenFile, path, nil, pretend, settings)
+		if err != nil {
+			return err
+		}
+		if !restored {
+			remaining = append(remaining, forgottenFile)
+		}
+	}
+
+	if len(remaining) == 0 || len(searchPaths) == 0 {
+		return nil
+	}
+
+	followSymlinks := configuredSymlinkPolicy(settings).follow()
+
+	pathsBySize, err := buildPathBySizeMap(store, tx, searchPaths, followSymlinks)
+	if err != nil {
+		return err
+	}
+
+	fingerprintByPath := make(map[string]fingerprint.Fingerprint)
+	claimed := make(map[string]bool)
+
+	for _, forgottenFile := range remaining {
+		for _, candidatePath := range pathsBySize[forgottenFile.Size] {
+			if claimed[candidatePath] {
+				continue
+			}
+
+			candidateFile, err := store.FileByPath(tx, candidatePath)
+			if err != nil {
+				return err
+			}
+			if candidateFile != nil {
+				// file is already tagged
+				continue
+			}
+
+			restored, err := restoreReappeared(store, tx, forgottenFile, candidatePath, fingerprintByPath, pretend, settings)
+			if err != nil {
+				return err
+			}
+			if restored {
+				claimed[candidatePath] = true
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// Restores a forgotten file at the specified path, providing the file there has
+// the same fingerprint.
+func restoreReappeared(store *storage.Storage, tx *storage.Tx, forgottenFile *entities.ForgottenFile, path string, fingerprintByPath map[string]fingerprint.Fingerprint, pretend bool, settings entities.Settings) (bool, error) {
+	pathFingerprint, ok := fingerprintByPath[path]
+	if !ok {
+		var err error
+		pathFingerprint, err = fingerprint.CreateContext(commandContext, path, settings.FileFingerprintAlgorithm(), settings.DirectoryFingerprintAlgorithm(), settings.SymlinkFingerprintAlgorithm())
+		if err != nil {
+			return false, fmt.Errorf("%v: could not create fingerprint: %v", path, err)
+		}
+
+		if fingerprintByPath != nil {
+			fingerprintByPath[path] = pathFingerprint
+		}
+	}
+
+	if pathFingerprint != forgottenFile.Fingerprint {
+		log.Debugf("%v: fingerprint differs from forgotten file %v", path, forgottenFile.Path())
+		return false, nil
+	}
+
+	if !pretend {
+		if _, err := restoreFile(store, tx, forgottenFile, path, settings); err != nil {
+			return false, fmt.Errorf("%v: could not restore file: %v", path, err)
+		}
+	}
+
+	if path == forgottenFile.Path() {
+		fmt.Printf("%v: restored\n", path)
+	} else {
+		fmt.Printf("%v: restored to %v\n", forgottenFile.Path(), path)
+	}
+
+	return true, nil
+}
+
+// Adds the untagged files under the search paths that have been tagged by
+// fingerprint, applying those tags.
+func repairFingerprintTags(store *storage.Storage, tx *storage.Tx, searchPaths []string, pretend bool, settings entities.Settings) error {
+	log.Debugf("applying fingerprint taggings")
+
+	fingerprintTags, err := store.FingerprintTags(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve fingerprint taggings: %v", err)
+	}
+
+	if len(fingerprintTags) == 0 || len(searchPaths) == 0 {
+		return nil
+	}
+
+	algorithms := make([]string, 0, 1)
+	tagged := make(map[fingerprint.Fingerprint]bool, len(fingerprintTags))
+	for _, fingerprintTag := range fingerprintTags {
+		if !containsString(algorithms, fingerprintTag.Algorithm) {
+			algorithms = append(algorithms, fingerprintTag.Algorithm)
+		}
+
+		tagged[fingerprintTag.Fingerprint] = true
+	}
+
+	followSymlinks := configuredSymlinkPolicy(settings).follow()
+
+	pathsBySize, err := buildPathBySizeMap(store, tx, searchPaths, followSymlinks)
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(pathsBySize))
+	for _, pathsOfSize := range pathsBySize {
+		paths = append(paths, pathsOfSize...)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		file, err := store.FileByPath(tx, path)
+		if err != nil {
+			return err
+		}
+		if file != nil {
+			// file is already tagged
+			continue
+		}
+
+		for _, algorithm := range algorithms {
+			pathFingerprint, err := fingerprint.CreateContext(commandContext, path, algorithm, settings.DirectoryFingerprintAlgorithm(), settings.SymlinkFingerprintAlgorithm())
+			if err != nil {
+				return fmt.Errorf("%v: could not create fingerprint: %v", path, err)
+			}
+
+			if !tagged[pathFingerprint] {
+				continue
+			}
+
+			if !pretend {
+				if err := addFingerprintTaggedFile(store, tx, path, pathFingerprint, followSymlinks, settings); err != nil {
+					return err
+				}
+			}
+
+			fmt.Printf("%v: tagged by fingerprint %v:%v\n", path, algorithm, pathFingerprint)
+
+			delete(tagged, pathFingerprint)
+			break
+		}
+	}
+
+	return nil
+}
+
+func addFingerprintTaggedFile(store *storage.Storage, tx *storage.Tx, path string, taggedFingerprint fingerprint.Fingerprint, followSymlinks bool, settings entities.Settings) error {
+	stat, err := statPath(path, followSymlinks)
+	if err != nil {
+		return fmt.Errorf("%v: could not stat file: %v", path, err)
+	}
+
+	fingerprint, err := fingerprint.CreateContext(commandContext, path, settings.FileFingerprintAlgorithm(), settings.DirectoryFingerprintAlgorithm(), settings.SymlinkFingerprintAlgorithm())
+	if err != nil {
+		return fmt.Errorf("%v: could not create fingerprint: %v", path, err)
+	}
+
+	file, err := store.AddFile(tx, path, fingerprint, stat.ModTime(), stat.Size(), stat.IsDir())
+	if err != nil {
+		return fmt.Errorf("%v: could not add file to database: %v", path, err)
+	}
+
+	if _, err := store.AttachFingerprintTags(tx, taggedFingerprint, file.Id); err != nil {
+		return fmt.Errorf("%v: could not apply tags: %v", path, err)
+	}
+
+	return nil
+}
+
+// Permanently removes forgotten files older than the retention period.
+func purgeForgottenFiles(store *storage.Storage, tx *storage.Tx, pretend bool, settings entities.Settings) error {
+	days, err := settings.ForgottenRetention()
+	if err != nil {
+		return err
+	}
+	if days == 0 || pretend {
+		return nil
+	}
+
+	log.Debugf("purging files forgotten more than %v days ago", days)
+
+	count, err := store.PurgeForgottenFiles(tx, time.Now().AddDate(0, 0, -int(days)))
+	if err != nil {
+		return fmt.Errorf("could not purge forgotten files: %v", err)
+	}
+
+	log.Debugf("purged %v forgotten files", count)
+
+	return nil
+}
+
+func buildPathBySizeMap(store *storage.Storage, tx *storage.Tx, paths []string, followSymlinks bool) (map[int64][]string, error) {
+	log.Debugf("building map of paths by size")
 
 	pathsBySize := make(map[int64][]string, 10)
+	guard := make(directoryGuard)
 
 	for _, path := range paths {
-		if err := buildPathBySizeMapRecursive(path, pathsBySize); err != nil {
+		if err := buildPathBySizeMapRecursive(store, tx, path, followSymlinks, guard, pathsBySize); err != nil {
 			return nil, err
 		}
 	}
 
-	log.Infof(2, "path by size map has %v sizes", len(pathsBySize))
+	log.Debugf("path by size map has %v sizes", len(pathsBySize))
 
 	return pathsBySize, nil
 }
 
-func buildPathBySizeMapRecursive(path string, pathBySizeMap map[int64][]string) error {
+func buildPathBySizeMapRecursive(store *storage.Storage, tx *storage.Tx, path string, followSymlinks bool, guard directoryGuard, pathBySizeMap map[int64][]string) error {
 	absPath, err := filepath.Abs(path)
 	if err != nil {
 		return fmt.Errorf("%v: could not get absolute path", path)
 	}
 
-	stat, err := os.Stat(absPath)
+	stat, err := statPath(absPath, followSymlinks)
 	if err != nil {
 		switch {
 		case os.IsPermission(err):
@@ -491,7 +1082,12 @@ func buildPathBySizeMapRecursive(path string, pathBySizeMap map[int64][]string)
 	}
 
 	if stat.IsDir() {
-		log.Infof(3, "%v: examining directory contents", absPath)
+		if !guard.enter(absPath) {
+			log.Warnf("%v: skipping directory already searched (symbolic link loop?)", path)
+			return nil
+		}
+
+		log.Tracef("%v: examining directory contents", absPath)
 
 		dir, err := os.Open(absPath)
 		if err != nil {
@@ -506,12 +1102,22 @@ func buildPathBySizeMapRecursive(path string, pathBySizeMap map[int64][]string)
 
 		for _, name := range names {
 			childPath := filepath.Join(path, name)
-			if err := buildPathBySizeMapRecursive(childPath, pathBySizeMap); err != nil {
+
+			ignored, err := isIgnored(store, tx, filepath.Join(absPath, name))
+			if err != nil {
+				return err
+			}
+			if ignored {
+				log.Tracef("%v: skipping ignored file/directory", childPath)
+				continue
+			}
+
+			if err := buildPathBySizeMapRecursive(store, tx, childPath, followSymlinks, guard, pathBySizeMap); err != nil {
 				return err
 			}
 		}
 	} else {
-		log.Infof(3, "%v: file is of size %v", absPath, stat.Size())
+		log.Tracef("%v: file is of size %v", absPath, stat.Size())
 
 		filesOfSize, ok := pathBySizeMap[stat.Size()]
 		if ok {
@@ -523,3 +1129,12 @@ func buildPathBySizeMapRecursive(path string, pathBySizeMap map[int64][]string)
 
 	return nil
 }
+
+// Stats the path, or the symbolic link itself if links are not followed.
+func statPath(path string, followSymlinks bool) (os.FileInfo, error) {
+	if followSymlinks {
+		return os.Stat(path)
+	}
+
+	return os.Lstat(path)
+}
@@ -0,0 +1,201 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/fingerprint"
+	"github.com/oniony/TMSU/common/log"
+	_path "github.com/oniony/TMSU/common/path"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage"
+	"os"
+	"path/filepath"
+)
+
+var RestoreCommand = Command{
+	Name:     "restore",
+	Synopsis: "Restore forgotten files or the database from a backup",
+	Usages: []string{"tmsu restore [OPTION]... FILE...",
+		"tmsu restore --list",
+		"tmsu restore --backup BACKUP"},
+	Description: `Restores FILEs forgotten by the 'forget' subcommand, or by 'repair --forget', along with their tags. Each FILE must exist. Where a file has been forgotten more than once at the same path, the most recently forgotten is restored.
+
+With --list the forgotten files are listed, most recently forgotten first, along with the time they were forgotten.
+
+With --backup the database is instead restored from the BACKUP taken by the 'backup' subcommand. The backup is copied into the database, rather than replacing its file, so this is safe whilst the virtual filesystem is mounted, which then shows the restored tags. The database is first backed up to the default backup directory in case the restore is regretted.`,
+	Examples: []string{"$ tmsu restore mountain.jpg",
+		"$ tmsu restore --list\n2018-03-15 09:30  /home/bob/mountain.jpg",
+		"$ tmsu restore --backup .tmsu/backups/db-20181015-093000"},
+	Options: Options{{"--list", "-l", "list the forgotten files", false, ""},
+		{"--backup", "-b", "restore the database from a backup", false, ""}},
+	Exec: restoreExec,
+}
+
+// unexported
+
+const forgottenTimeFormat = "2006-01-02 15:04"
+
+func restoreExec(options Options, args []string, databasePath string) (error, warnings) {
+	if options.HasOption("--backup") {
+		if len(args) != 1 {
+			return fmt.Errorf("the backup to restore must be specified"), nil
+		}
+
+		return restoreBackup(args[0], databasePath), nil
+	}
+
+	list := options.HasOption("--list")
+	if !list && len(args) < 1 {
+		return fmt.Errorf("files to restore must be specified"), nil
+	}
+
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	tx, err := store.Begin()
+	if err != nil {
+		return err, nil
+	}
+	defer tx.Commit()
+
+	if list {
+		return listForgottenFiles(store, tx), nil
+	}
+
+	if err := fireHooks(store, tx, hookEvent{"tag", args, nil, nil}); err != nil {
+		return err, nil
+	}
+
+	return restorePaths(store, tx, args)
+}
+
+// Restores the database from the backup, having first backed up the database
+// as it is.
+func restoreBackup(path, databasePath string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("%v: could not read backup: %v", path, err)
+	}
+
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if err := store.CheckBackup(path); err != nil {
+		return err
+	}
+
+	if !dryRun {
+		dir := defaultBackupDir(databasePath)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("%v: could not create directory: %v", dir, err)
+		}
+
+		previousPath := newBackupPath(dir, databasePath)
+		if err := store.Backup(previousPath); err != nil {
+			return fmt.Errorf("could not back up the database before restoring: %v", err)
+		}
+
+		log.Infof("backed up database to '%v'", previousPath)
+	}
+
+	if err := store.Restore(path); err != nil {
+		return err
+	}
+
+	log.Infof("restored database from '%v'", path)
+
+	return nil
+}
+
+func listForgottenFiles(store *storage.Storage, tx *storage.Tx) error {
+	files, err := store.ForgottenFiles(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve forgotten files: %v", err)
+	}
+
+	for _, file := range files {
+		fmt.Printf("%v  %v\n", file.ForgottenAt.Local().Format(forgottenTimeFormat), _path.Rel(file.Path()))
+	}
+
+	return nil
+}
+
+func restorePaths(store *storage.Storage, tx *storage.Tx, paths []string) (error, warnings) {
+	warnings := make(warnings, 0, 10)
+
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve settings: %v", err), warnings
+	}
+
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("%v: could not get absolute path: %v", path, err), warnings
+		}
+
+		forgottenFiles, err := store.ForgottenFilesByPath(tx, absPath)
+		if err != nil {
+			return fmt.Errorf("%v: could not retrieve forgotten file: %v", path, err), warnings
+		}
+		if len(forgottenFiles) == 0 {
+			warnings = append(warnings, fmt.Sprintf("%v: file is not forgotten", path))
+			continue
+		}
+
+		if _, err := os.Stat(absPath); err != nil {
+			switch {
+			case os.IsNotExist(err):
+				warnings = append(warnings, fmt.Sprintf("%v: no such file", path))
+				continue
+			case os.IsPermission(err):
+				warnings = append(warnings, fmt.Sprintf("%v: permission denied", path))
+				continue
+			default:
+				return fmt.Errorf("%v: could not stat file: %v", path, err), warnings
+			}
+		}
+
+		if _, err := restoreFile(store, tx, forgottenFiles[0], absPath, settings); err != nil {
+			return fmt.Errorf("%v: could not restore file: %v", path, err), warnings
+		}
+	}
+
+	return nil, warnings
+}
+
+// Restores a forgotten file, and its tags, at the specified path.
+func restoreFile(store *storage.Storage, tx *storage.Tx, forgottenFile *entities.ForgottenFile, path string, settings entities.Settings) (*entities.File, error) {
+	log.Debugf("%v: restoring file", path)
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint, err := fingerprint.CreateContext(commandContext, path, settings.FileFingerprintAlgorithm(), settings.DirectoryFingerprintAlgorithm(), settings.SymlinkFingerprintAlgorithm())
+	if err != nil {
+		return nil, fmt.Errorf("could not create fingerprint: %v", err)
+	}
+
+	return store.RestoreFile(tx, forgottenFile, path, fingerprint, stat.ModTime(), stat.Size(), stat.IsDir())
+}
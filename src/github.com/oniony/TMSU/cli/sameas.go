@@ -0,0 +1,146 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/fingerprint"
+	"github.com/oniony/TMSU/common/log"
+	_path "github.com/oniony/TMSU/common/path"
+	"github.com/oniony/TMSU/storage"
+	"path/filepath"
+	"strings"
+)
+
+var SameAsCommand = Command{
+	Name:     "same-as",
+	Synopsis: "List tagged files with identical content",
+	Usages:   []string{"tmsu same-as [OPTION]... FILE"},
+	Description: `Lists the tagged files in the database whose content is identical to that of FILE, as identified by their fingerprints. FILE need not itself be in the database.
+
+With --tags each file is listed along with its tags.
+
+When the 'propagateDuplicateTags' setting is enabled the tags applied to a file are applied to these files too. (See the 'config' subcommand.)`,
+	Examples: []string{"$ tmsu same-as photo.jpg\nbackup/photo.jpg",
+		"$ tmsu same-as --tags photo.jpg\nbackup/photo.jpg: holiday landscape"},
+	Options: Options{{"--tags", "-t", "list the tags of each file", false, ""}},
+	Exec:    sameAsExec,
+}
+
+// unexported
+
+func sameAsExec(options Options, args []string, databasePath string) (error, warnings) {
+	if len(args) != 1 {
+		return fmt.Errorf("a single file must be specified"), nil
+	}
+
+	showTags := options.HasOption("--tags")
+
+	colour, err := useColour(options)
+	if err != nil {
+		return err, nil
+	}
+
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	tx, err := store.Begin()
+	if err != nil {
+		return err, nil
+	}
+	defer tx.Commit()
+
+	return listSameAs(store, tx, args[0], showTags, colour), nil
+}
+
+func listSameAs(store *storage.Storage, tx *storage.Tx, path string, showTags, colour bool) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("%v: could not get absolute path: %v", path, err)
+	}
+
+	fp, err := fingerprintOf(store, tx, absPath)
+	if err != nil {
+		return fmt.Errorf("%v: could not create fingerprint: %v", path, err)
+	}
+	if fp == fingerprint.Empty {
+		log.Debugf("%v: file has no fingerprint", path)
+		return nil
+	}
+
+	log.Debugf("%v: retrieving files with fingerprint '%v'", path, fp)
+
+	files, err := store.FilesByFingerprint(tx, fp)
+	if err != nil {
+		return fmt.Errorf("%v: could not retrieve files matching fingerprint '%v': %v", path, fp, err)
+	}
+
+	infos, err := tagInfosForColour(store, tx, colour)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.Path() == absPath {
+			continue
+		}
+
+		fileTags, err := store.FileTagsByFileId(tx, file.Id, false)
+		if err != nil {
+			return fmt.Errorf("%v: could not retrieve file-tags: %v", file.Path(), err)
+		}
+		if len(fileTags) == 0 {
+			continue
+		}
+
+		relPath := _path.Rel(file.Path())
+		if !showTags {
+			fmt.Println(relPath)
+			continue
+		}
+
+		tagNames, err := tagNamesForFileTags(store, tx, fileTags, colour, infos)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%v: %v\n", relPath, strings.Join(tagNames, " "))
+	}
+
+	return nil
+}
+
+// The fingerprint of the file: that recorded in the database if it is there,
+// otherwise calculated from its content.
+func fingerprintOf(store *storage.Storage, tx *storage.Tx, absPath string) (fingerprint.Fingerprint, error) {
+	file, err := store.FileByPath(tx, absPath)
+	if err != nil {
+		return fingerprint.Empty, err
+	}
+	if file != nil {
+		return file.Fingerprint, nil
+	}
+
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return fingerprint.Empty, err
+	}
+
+	return newFingerprinter(settings, 1).create(absPath)
+}
@@ -0,0 +1,136 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	_path "github.com/oniony/TMSU/common/path"
+	"github.com/oniony/TMSU/storage"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// unexported
+
+// The directories a repair examines, and their modification times, along with
+// those recorded by the previous repair. As adding, removing or renaming a
+// file changes its directory's modification time, the files of a directory
+// whose modification time is unchanged need not be examined again.
+type scanJournal struct {
+	previous   map[string]time.Time
+	modTimes   map[string]time.Time
+	examined   map[string]bool
+	incomplete map[string]bool
+	limitPath  string
+}
+
+// Loads the journal of the previous repair, which is used only when repairing
+// incrementally. Only the directories under the absolute limit path, if any,
+// are recorded by this repair.
+func loadScanJournal(store *storage.Storage, tx *storage.Tx, incremental bool, absLimitPath string) (*scanJournal, error) {
+	previous := make(map[string]time.Time)
+
+	if incremental {
+		var err error
+		previous, err = store.ScanJournal(tx)
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve scan journal: %v", err)
+		}
+	}
+
+	return &scanJournal{previous, make(map[string]time.Time), make(map[string]bool), make(map[string]bool), absLimitPath}, nil
+}
+
+// Whether the directory is unchanged since the previous repair examined it.
+func (journal *scanJournal) unchanged(directory string) bool {
+	previous, ok := journal.previous[directory]
+	if !ok {
+		return false
+	}
+
+	modTime, ok := journal.modTime(directory)
+	return ok && modTime.Equal(previous)
+}
+
+// Records that the files of the directory have been examined.
+func (journal *scanJournal) examine(directory string) {
+	if journal.limitPath != "" && !_path.Equal(directory, journal.limitPath) && !_path.HasPrefix(directory, journal.limitPath+string(filepath.Separator)) {
+		// not every file in the directory is being repaired
+		return
+	}
+
+	if _, ok := journal.modTime(directory); ok {
+		journal.examined[directory] = true
+	}
+}
+
+// Records that the directory has files that could not be repaired, so that it
+// is examined again by the next repair.
+func (journal *scanJournal) markIncomplete(directory string) {
+	journal.incomplete[directory] = true
+}
+
+// Records the directories examined. Unless the journal is incremental or
+// limited to a path, the directories examined replace those recorded
+// previously so that directories that no longer exist are dropped.
+func (journal *scanJournal) save(store *storage.Storage, tx *storage.Tx, incremental bool) error {
+	log.Debugf("updating scan journal")
+
+	if !incremental && journal.limitPath == "" {
+		if err := store.ClearScanJournal(tx); err != nil {
+			return fmt.Errorf("could not clear scan journal: %v", err)
+		}
+	}
+
+	for directory := range journal.incomplete {
+		if err := store.DeleteScanJournalEntry(tx, directory); err != nil {
+			return fmt.Errorf("%v: could not update scan journal: %v", directory, err)
+		}
+	}
+
+	for directory := range journal.examined {
+		if journal.incomplete[directory] {
+			continue
+		}
+
+		if err := store.UpdateScanJournal(tx, directory, journal.modTimes[directory]); err != nil {
+			return fmt.Errorf("%v: could not update scan journal: %v", directory, err)
+		}
+	}
+
+	return nil
+}
+
+// The modification time of the directory, as first seen by this repair so that
+// a change made whilst it is being examined is noticed next time.
+func (journal *scanJournal) modTime(directory string) (time.Time, bool) {
+	if modTime, ok := journal.modTimes[directory]; ok {
+		return modTime, !modTime.IsZero()
+	}
+
+	stat, err := os.Stat(directory)
+	if err != nil || !stat.IsDir() {
+		journal.modTimes[directory] = time.Time{}
+		return time.Time{}, false
+	}
+
+	modTime := stat.ModTime().UTC()
+	journal.modTimes[directory] = modTime
+
+	return modTime, true
+}
@@ -0,0 +1,88 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/web"
+)
+
+var ServeCommand = Command{
+	Name:     "serve",
+	Synopsis: "Serve a web interface to the database",
+	Usages: []string{"tmsu serve [OPTION]...",
+		"tmsu serve [OPTION]... --webdav=ADDRESS"},
+	Description: `Serves a web interface for browsing and tagging the files in the database, for those who would rather not use the command-line.
+
+The interface shows a cloud of the database's tags, a query box and the files matching the query along with their tags. Tagged files can be opened in the browser and, unless --read-only is specified, tags can be applied to and removed from the listed files.
+
+By default the interface is only available to the local machine: specify an --address such as ':8080' to serve it to the network. There is no authentication so anyone able to reach the address can view the tagged files.
+
+With --webdav the virtual filesystem is instead served over WebDAV at ADDRESS, for machines that cannot mount it, such as Windows clients and phones. It has the same tag and query directories as the virtual filesystem (see the 'mount' subcommand) but, as WebDAV has no symbolic links, the files are served with the content of the files they link to. Unless --read-only is specified, creating, renaming and removing tag directories and removing files from them changes the tagging as in the virtual filesystem.`,
+	Examples: []string{"$ tmsu serve",
+		"$ tmsu serve --address=:8080 --read-only",
+		"$ tmsu serve --webdav=:8080"},
+	Options: Options{Option{"--address", "-a", "the address to listen on (default localhost:8080)", true, ""},
+		Option{"--read-only", "-r", "do not allow tags to be edited", false, ""},
+		Option{"--webdav", "", "serve the virtual filesystem over WebDAV at ADDRESS", true, ""}},
+	Exec: serveExec,
+}
+
+// unexported
+
+func serveExec(options Options, args []string, databasePath string) (error, warnings) {
+	if len(args) > 0 {
+		return fmt.Errorf("too many arguments"), nil
+	}
+
+	address := "localhost:8080"
+	if options.HasOption("--address") {
+		address = options.Get("--address").Argument
+	}
+
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	readOnly := options.HasOption("--read-only") || store.ReadOnly()
+
+	if options.HasOption("--webdav") {
+		if options.HasOption("--address") {
+			return fmt.Errorf("--address cannot be used with --webdav"), nil
+		}
+
+		address := options.Get("--webdav").Argument
+
+		fmt.Printf("serving database '%v' over WebDAV at http://%v/\n", databasePath, address)
+
+		if err := serveWebdav(commandContext, store, address, readOnly); err != nil {
+			return fmt.Errorf("could not serve WebDAV: %v", err), nil
+		}
+
+		return nil, nil
+	}
+
+	fmt.Printf("serving database '%v' at http://%v/\n", databasePath, address)
+
+	server := web.NewServer(store, readOnly)
+	if err := server.ListenAndServe(commandContext, address); err != nil {
+		return fmt.Errorf("could not serve web interface: %v", err), nil
+	}
+
+	return nil, nil
+}
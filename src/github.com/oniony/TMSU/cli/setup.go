@@ -0,0 +1,401 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/oniony/TMSU/storage/database"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+var SetupCommand = Command{
+	Name:     "setup",
+	Synopsis: "Set up a new database interactively",
+	Usages:   []string{"tmsu setup [PATH]"},
+	Description: `Guides you through setting up TMSU, asking a series of questions with the default answer shown in brackets. Press enter to accept the default.
+
+Creates a database under PATH, or the working directory if none is specified, in the same way as 'init'. It then offers to:
+
+  * record the root directories of the files to be tagged, which 'repair'
+    searches for moved files
+  * choose the fingerprint algorithm used to identify files
+  * install the zsh completion
+  * create a systemd user unit that mounts the virtual filesystem at login
+  * tag the files under the root directories by their directory names, in the
+    same way as 'bootstrap'
+
+Running setup against an existing database revisits its settings.`,
+	Examples: []string{"$ tmsu setup",
+		"$ tmsu setup ~/photos"},
+	Options: Options{},
+	Exec:    setupExec,
+}
+
+// unexported
+
+type setup struct {
+	reader   *bufio.Reader
+	warnings warnings
+}
+
+func setupExec(options Options, args []string, _ string) (error, warnings) {
+	if len(args) > 1 {
+		return fmt.Errorf("too many arguments"), nil
+	}
+
+	path := ""
+	if len(args) == 1 {
+		path = args[0]
+	} else {
+		workingDirectory, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("could not identify working directory: %v", err), nil
+		}
+
+		path = workingDirectory
+	}
+
+	setup := setup{bufio.NewReader(os.Stdin), make(warnings, 0, 10)}
+
+	if err := setup.run(path); err != nil {
+		return err, setup.warnings
+	}
+
+	return nil, setup.warnings
+}
+
+func (setup *setup) run(path string) error {
+	answer, err := setup.ask("Directory to create the database in", path)
+	if err != nil {
+		return err
+	}
+
+	directory, err := filepath.Abs(answer)
+	if err != nil {
+		return fmt.Errorf("%v: could not get absolute path: %v", answer, err)
+	}
+
+	databasePath := filepath.Join(directory, ".tmsu", "db")
+	if _, err := os.Stat(databasePath); err == nil {
+		fmt.Printf("Using the existing database at '%v'.\n", databasePath)
+	} else {
+		if err := os.MkdirAll(directory, 0755); err != nil {
+			return fmt.Errorf("%v: could not create directory: %v", directory, err)
+		}
+
+		if err := initializeDatabase(directory, database.DefaultBackend); err != nil {
+			return fmt.Errorf("%v: could not initialize database: %v", directory, err)
+		}
+	}
+
+	roots, err := setup.configure(databasePath, directory)
+	if err != nil {
+		return err
+	}
+
+	if err := setup.installCompletion(); err != nil {
+		return err
+	}
+
+	if runtime.GOOS == "linux" {
+		if err := setup.createSystemdUnit(databasePath, directory); err != nil {
+			return err
+		}
+	}
+
+	if len(roots) > 0 {
+		bootstrap, err := setup.confirm("Tag the files under the root directories by their directory names now?", false)
+		if err != nil {
+			return err
+		}
+
+		if bootstrap {
+			err, warnings := bootstrapExec(Options{}, roots, databasePath)
+			setup.warnings = append(setup.warnings, warnings...)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	fmt.Printf("Setup is complete: the database is used whenever tmsu is run beneath '%v'.\n", directory)
+
+	return nil
+}
+
+// Updates the database settings, returning the root directories chosen.
+func (setup *setup) configure(databasePath, directory string) ([]string, error) {
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	tx, err := store.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve settings: %v", err)
+	}
+
+	defaultRoots := settings.Value("searchPaths")
+	if defaultRoots == "" {
+		defaultRoots = directory
+	}
+
+	answer, err := setup.ask("Root directories of the files to tag, separated by '"+string(os.PathListSeparator)+"'", defaultRoots)
+	if err != nil {
+		return nil, err
+	}
+
+	roots := make([]string, 0, 10)
+	for _, root := range filepath.SplitList(answer) {
+		if root == "" {
+			continue
+		}
+
+		absRoot, err := filepath.Abs(root)
+		if err != nil {
+			return nil, fmt.Errorf("%v: could not get absolute path: %v", root, err)
+		}
+
+		if stat, err := os.Stat(absRoot); err != nil || !stat.IsDir() {
+			setup.warnings = append(setup.warnings, fmt.Sprintf("%v: no such directory", root))
+			continue
+		}
+
+		roots = append(roots, absRoot)
+	}
+
+	if len(roots) > 0 {
+		if _, err := store.UpdateSetting(tx, "searchPaths", strings.Join(roots, string(os.PathListSeparator))); err != nil {
+			return nil, fmt.Errorf("could not update setting 'searchPaths': %v", err)
+		}
+	}
+
+	fmt.Printf("Fingerprint algorithms: %v\n", strings.Join(fileFingerprintAlgorithms, ", "))
+
+	for {
+		algorithm, err := setup.ask("Fingerprint algorithm for files", settings.FileFingerprintAlgorithm())
+		if err != nil {
+			return nil, err
+		}
+
+		if !containsString(fileFingerprintAlgorithms, algorithm) {
+			fmt.Printf("'%v' is not a fingerprint algorithm.\n", algorithm)
+			continue
+		}
+
+		if _, err := store.UpdateSetting(tx, "fileFingerprintAlgorithm", algorithm); err != nil {
+			return nil, fmt.Errorf("could not update setting 'fileFingerprintAlgorithm': %v", err)
+		}
+
+		break
+	}
+
+	return roots, nil
+}
+
+// Copies the zsh completion from the TMSU distribution to the user's
+// site-functions directory.
+func (setup *setup) installCompletion() error {
+	if !strings.HasSuffix(os.Getenv("SHELL"), "zsh") {
+		fmt.Println("Shell completion is only available for zsh.")
+		return nil
+	}
+
+	for _, dir := range []string{"/usr/share/zsh/site-functions", "/usr/local/share/zsh/site-functions"} {
+		if _, err := os.Stat(filepath.Join(dir, "_tmsu")); err == nil {
+			fmt.Printf("The zsh completion is already installed in '%v'.\n", dir)
+			return nil
+		}
+	}
+
+	sourcePath := findCompletion()
+	if sourcePath == "" {
+		fmt.Println("The zsh completion was not found: copy 'misc/zsh/_tmsu' from the TMSU distribution to a directory on your $fpath.")
+		return nil
+	}
+
+	install, err := setup.confirm("Install the zsh completion?", true)
+	if err != nil || !install {
+		return err
+	}
+
+	u, err := user.Current()
+	if err != nil {
+		return fmt.Errorf("could not identify current user: %v", err)
+	}
+
+	destDir := filepath.Join(u.HomeDir, ".local", "share", "zsh", "site-functions")
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return fmt.Errorf("%v: could not create directory: %v", destDir, err)
+	}
+
+	content, err := ioutil.ReadFile(sourcePath)
+	if err != nil {
+		return fmt.Errorf("%v: could not read completion: %v", sourcePath, err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(destDir, "_tmsu"), content, 0644); err != nil {
+		return fmt.Errorf("%v: could not install completion: %v", destDir, err)
+	}
+
+	fmt.Printf("Installed the zsh completion: add 'fpath=(%v $fpath)' to your ~/.zshrc before 'compinit'.\n", destDir)
+
+	return nil
+}
+
+// Writes a systemd user unit that mounts the virtual filesystem.
+func (setup *setup) createSystemdUnit(databasePath, directory string) error {
+	create, err := setup.confirm("Create a systemd user unit to mount the virtual filesystem at login?", false)
+	if err != nil || !create {
+		return err
+	}
+
+	answer, err := setup.ask("Mountpoint", filepath.Join(directory, "mnt"))
+	if err != nil {
+		return err
+	}
+
+	mountPath, err := filepath.Abs(answer)
+	if err != nil {
+		return fmt.Errorf("%v: could not get absolute path: %v", answer, err)
+	}
+
+	if err := os.MkdirAll(mountPath, 0755); err != nil {
+		return fmt.Errorf("%v: could not create mountpoint: %v", mountPath, err)
+	}
+
+	executablePath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("could not identify tmsu executable: %v", err)
+	}
+
+	u, err := user.Current()
+	if err != nil {
+		return fmt.Errorf("could not identify current user: %v", err)
+	}
+
+	unitDir := filepath.Join(u.HomeDir, ".config", "systemd", "user")
+	if err := os.MkdirAll(unitDir, 0755); err != nil {
+		return fmt.Errorf("%v: could not create directory: %v", unitDir, err)
+	}
+
+	unitName := "tmsu-" + systemdUnitName(filepath.Base(directory)) + ".service"
+	unit := fmt.Sprintf(`[Unit]
+Description=TMSU virtual filesystem for %v
+
+[Service]
+ExecStart="%v" "--database=%v" vfs "%v"
+ExecStop=fusermount -u "%v"
+
+[Install]
+WantedBy=default.target
+`, directory, executablePath, databasePath, mountPath, mountPath)
+
+	unitPath := filepath.Join(unitDir, unitName)
+	if err := ioutil.WriteFile(unitPath, []byte(unit), 0644); err != nil {
+		return fmt.Errorf("%v: could not write unit: %v", unitPath, err)
+	}
+
+	fmt.Printf("Created '%v': start it with 'systemctl --user enable --now %v'.\n", unitPath, unitName)
+
+	return nil
+}
+
+// Asks a question, returning the default answer if none is given.
+func (setup *setup) ask(question, defaultAnswer string) (string, error) {
+	fmt.Printf("%v [%v]: ", question, defaultAnswer)
+
+	answer, err := setup.reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	if err == io.EOF {
+		fmt.Println()
+	}
+
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return defaultAnswer, nil
+	}
+
+	return answer, nil
+}
+
+func (setup *setup) confirm(question string, defaultYes bool) (bool, error) {
+	defaultAnswer := "y/N"
+	if defaultYes {
+		defaultAnswer = "Y/n"
+	}
+
+	for {
+		answer, err := setup.ask(question, defaultAnswer)
+		if err != nil {
+			return false, err
+		}
+
+		switch strings.ToLower(answer) {
+		case "y/n":
+			return defaultYes, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+	}
+}
+
+// Locates the zsh completion within the distribution the executable belongs to.
+func findCompletion() string {
+	executablePath, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+
+	distributionPath := filepath.Dir(filepath.Dir(executablePath))
+	for _, path := range []string{filepath.Join(distributionPath, "misc", "zsh", "_tmsu"),
+		filepath.Join(distributionPath, "share", "zsh", "site-functions", "_tmsu")} {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return ""
+}
+
+func systemdUnitName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
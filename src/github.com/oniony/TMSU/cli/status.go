@@ -19,12 +19,16 @@ import (
 	"fmt"
 	"github.com/oniony/TMSU/common/log"
 	_path "github.com/oniony/TMSU/common/path"
+	"github.com/oniony/TMSU/common/terminal/ansi"
 	"github.com/oniony/TMSU/entities"
 	"github.com/oniony/TMSU/storage"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
 )
 
 //TODO should return warnings for permission errors
@@ -32,7 +36,8 @@ import (
 var StatusCommand = Command{
 	Name:     "status",
 	Synopsis: "List the file tagging status",
-	Usages:   []string{"tmsu status [PATH]..."},
+	Usages: []string{"tmsu status [OPTION]... [PATH]...",
+		"tmsu status --compare DB [PATH]..."},
 	Description: `Shows the status of PATHs.
 
 Where PATHs are not specified the status of the database is shown.
@@ -44,12 +49,32 @@ Where PATHs are not specified the status of the database is shown.
 
 Status codes of T, M and ! mean that the file has been tagged (and thus is in the TMSU database). Modified files are those with a different modification time or size to that in the database. Missing files are those in the database but that no longer exist in the file-system.
 
+Untagged files are listed as they are found. Files and directories matching the 'ignorePatterns' setting, such as '.git' and 'node_modules', or the patterns in a '.tmsuignore' file are not searched for untagged files: see the 'config' subcommand.
+
+Where the output is coloured (see the global --color option) the status codes are coloured: green for tagged, yellow for modified and red for missing files.
+
+With --verify-state a column is added showing the outcome of each tagged file's most recent verification by the 'verify' subcommand: 'ok', 'FAILED' where the content did not match the fingerprint, or 'unverified'.
+
+With the global --porcelain option the status is written in a stable form for scripts: one record per file of tab-separated fields, being the status code, the verification state if --verify-state is specified and the absolute path. Untagged files have the status code '?' rather than 'U', and the output is never coloured.
+
+With --compare the file-system is not examined: instead the taggings of the database are compared with those of the database DB, which is either the path of a database file or, for a database on another machine, HOST:PATH as for the 'sync' subcommand, so that the changes can be reviewed before the databases are synchronised. For each file with differing tags, the tags applied only in DB are listed after '-' and those applied only in this database after '+'. Files are matched by their path relative to the root of each database and only explicit taggings are compared. With --porcelain each differing tagging is written as a record of the '-' or '+', the absolute path, the tag name and the value name (empty if none), separated by tabs.
+
 Note: The 'repair' subcommand can be used to fix problems caused by files that have been modified or moved on disk.`,
 	Examples: []string{"$ tmsu status",
 		"$ tmsu status .",
-		"$ tmsu status --directory *"},
+		"$ tmsu status --directory *",
+		`$ tmsu status --verify-state photos
+T ok         photos/beach.jpg
+T FAILED     photos/mountain.jpg
+U -          photos/new.jpg`,
+		`$ tmsu --porcelain status photos`,
+		`$ tmsu status --compare laptop:photos/.tmsu/db
+- photos/beach.jpg: holiday
++ photos/beach.jpg: beach year=2017`},
 	Options: Options{Option{"--directory", "-d", "do not examine directory contents (non-recursive)", false, ""},
-		Option{"--no-dereference", "-P", "do not follow symbolic links", false, ""}},
+		Option{"--no-dereference", "-P", "do not follow symbolic links", false, ""},
+		Option{"--verify-state", "", "show the verification state of tagged files", false, ""},
+		Option{"--compare", "-c", "compare the taggings with those of another database", true, ""}},
 	Exec: statusExec,
 }
 
@@ -57,43 +82,45 @@ type Status byte
 
 const (
 	UNTAGGED Status = 'U'
+	UNKNOWN  Status = '?'
 	TAGGED   Status = 'T'
 	MODIFIED Status = 'M'
 	MISSING  Status = '!'
 )
 
 type StatusReport struct {
-	Rows []Row
+	Rows  []Row
+	paths map[string]bool
 }
 
 func (report *StatusReport) AddRow(row Row) {
 	report.Rows = append(report.Rows, row)
+	report.paths[row.Path] = true
 }
 
 func (report *StatusReport) ContainsRow(path string) bool {
-	for _, row := range report.Rows {
-		if row.Path == path {
-			return true
-		}
-	}
-
-	return false
+	return report.paths[path]
 }
 
 type Row struct {
 	Path   string
 	Status Status
+	FileId entities.FileId
 }
 
 func NewReport() *StatusReport {
-	return &StatusReport{make([]Row, 0, 10)}
+	return &StatusReport{make([]Row, 0, 10), make(map[string]bool)}
 }
 
 // unexported
 
 func statusExec(options Options, args []string, databasePath string) (error, warnings) {
 	dirOnly := options.HasOption("--directory")
-	followSymlinks := !options.HasOption("--no-dereference")
+	verifyState := options.HasOption("--verify-state")
+	colour, err := useColour(options)
+	if err != nil {
+		return err, nil
+	}
 
 	store, err := openDatabase(databasePath)
 	if err != nil {
@@ -101,75 +128,97 @@ func statusExec(options Options, args []string, databasePath string) (error, war
 	}
 	defer store.Close()
 
+	if options.HasOption("--compare") {
+		return compareDatabases(store, databasePath, options.Get("--compare").Argument, args, colour), nil
+	}
+
 	tx, err := store.Begin()
 	if err != nil {
 		return err, nil
 	}
 	defer tx.Commit()
 
-	var report *StatusReport
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve settings: %v", err), nil
+	}
 
-	if len(args) == 0 {
-		report, err = statusDatabase(store, tx, dirOnly, followSymlinks)
-		if err != nil {
-			return err, nil
-		}
-	} else {
-		report, err = statusPaths(store, tx, args, dirOnly, followSymlinks)
+	followSymlinks := symlinkPolicyFor(options, settings).follow()
+
+	var verifications entities.Verifications
+	if verifyState {
+		log.Debug("retrieving verification states")
+
+		verifications, err = store.Verifications(tx)
 		if err != nil {
-			return err, nil
+			return fmt.Errorf("could not retrieve verification states: %v", err), nil
 		}
 	}
 
-	printReport(report)
+	ignored := func(path string, isDir bool) (bool, error) {
+		return store.Ignored(tx, path, isDir)
+	}
+
+	scanner := newDirectoryScanner(ignored, followSymlinks, verifications, colour)
+
+	if len(args) == 0 {
+		err = statusDatabase(store, tx, scanner, dirOnly)
+	} else {
+		err = statusPaths(store, tx, scanner, args, dirOnly)
+	}
+	if err != nil {
+		return err, nil
+	}
 
 	return nil, nil
 }
 
-func statusDatabase(store *storage.Storage, tx *storage.Tx, dirOnly, followSymlinks bool) (*StatusReport, error) {
+// Reports the status of every file in the database followed by the untagged
+// files beneath them.
+func statusDatabase(store *storage.Storage, tx *storage.Tx, scanner *directoryScanner, dirOnly bool) error {
 	report := NewReport()
 
-	log.Info(2, "retrieving all files from database.")
+	log.Debug("retrieving all files from database.")
 
 	files, err := store.Files(tx, "name")
 	if err != nil {
-		return nil, fmt.Errorf("could not retrieve files: %v", err)
+		return fmt.Errorf("could not retrieve files: %v", err)
 	}
 
-	err = statusCheckFiles(files, report)
-	if err != nil {
-		return nil, err
+	if err := statusCheckFiles(files, report); err != nil {
+		return err
 	}
 
+	printReport(report, scanner.verifications, scanner.colour)
+
 	tree := _path.NewTree()
 	for _, file := range files {
 		tree.Add(file.Path(), file.IsDir)
 	}
 
-	topLevelPaths := tree.TopLevel().Paths()
-	if err != nil {
-		return nil, err
-	}
-
-	for _, path := range topLevelPaths {
-		if err = findNewFiles(path, report, dirOnly, followSymlinks); err != nil {
-			return nil, err
+	for _, path := range tree.TopLevel().Paths() {
+		if err := findNewFiles(path, report, scanner, dirOnly); err != nil {
+			return err
 		}
 	}
 
-	return report, nil
+	return nil
 }
 
-func statusPaths(store *storage.Storage, tx *storage.Tx, paths []string, dirOnly, followSymlinks bool) (*StatusReport, error) {
+// Reports the status of the files in the database at or beneath the paths
+// followed by the untagged files amongst them.
+func statusPaths(store *storage.Storage, tx *storage.Tx, scanner *directoryScanner, paths []string, dirOnly bool) error {
 	report := NewReport()
 
-	for _, path := range paths {
+	absPaths := make([]string, len(paths))
+	for index, path := range paths {
 		absPath, err := filepath.Abs(path)
 		if err != nil {
-			return nil, fmt.Errorf("%v: could not get absolute path: %v", path, err)
+			return fmt.Errorf("%v: could not get absolute path: %v", path, err)
 		}
+		absPaths[index] = absPath
 
-		log.Infof(2, "%v: resolving file", path)
+		log.Debugf("%v: resolving file", path)
 
 		resolvedPath := absPath
 
@@ -179,55 +228,81 @@ func statusPaths(store *storage.Storage, tx *storage.Tx, paths []string, dirOnly
 			case os.IsNotExist(err), os.IsPermission(err):
 				stat = emptyStat{}
 			default:
-				return nil, fmt.Errorf("%v: could not stat path: %v", path, err)
+				return fmt.Errorf("%v: could not stat path: %v", path, err)
 			}
 		} else {
 			resolvedPath, err = filepath.EvalSymlinks(absPath)
 			if err != nil {
-				return nil, fmt.Errorf("%v: could not dereference symbolic link: %v", path, err)
+				return fmt.Errorf("%v: could not dereference symbolic link: %v", path, err)
 			}
 		}
 
-		log.Infof(2, "%v: checking file in database", path)
+		log.Debugf("%v: checking file in database", path)
 
 		file, err := store.FileByPath(tx, resolvedPath)
 		if err != nil {
-			return nil, fmt.Errorf("%v: could not retrieve file: %v", path, err)
+			return fmt.Errorf("%v: could not retrieve file: %v", path, err)
 		}
 		if file != nil {
 			err = statusCheckFile(absPath, file, report)
 			if err != nil {
-				return nil, err
+				return err
 			}
 		}
 
-		if !dirOnly && (stat.Mode()&os.ModeSymlink == 0 || followSymlinks) {
-			log.Infof(2, "%v: retrieving files from database.", path)
+		if !dirOnly && (stat.Mode()&os.ModeSymlink == 0 || scanner.followSymlinks) {
+			log.Debugf("%v: retrieving files from database.", path)
 
 			files, err := store.FilesByDirectory(tx, resolvedPath)
 			if err != nil {
-				return nil, fmt.Errorf("%v: could not retrieve files for directory: %v", path, err)
+				return fmt.Errorf("%v: could not retrieve files for directory: %v", path, err)
 			}
 
 			err = statusCheckFiles(files, report)
 			if err != nil {
-				return nil, err
+				return err
 			}
 		}
+	}
 
-		err = findNewFiles(absPath, report, dirOnly, followSymlinks)
-		if err != nil {
-			return nil, err
+	printReport(report, scanner.verifications, scanner.colour)
+
+	for _, absPath := range absPaths {
+		if err := findNewFiles(absPath, report, scanner, dirOnly); err != nil {
+			return err
 		}
 	}
 
-	return report, nil
+	return nil
 }
 
+// Checks the status of the files against the file-system, examining several
+// files at once.
 func statusCheckFiles(files entities.Files, report *StatusReport) error {
-	for _, file := range files {
-		if err := statusCheckFile(file.Path(), file, report); err != nil {
-			return err
+	rows := make([]*Row, len(files))
+	errors := make([]error, len(files))
+
+	var wait sync.WaitGroup
+	semaphore := make(chan struct{}, statusWorkers)
+	for index, file := range files {
+		wait.Add(1)
+		semaphore <- struct{}{}
+
+		go func(index int, file *entities.File) {
+			defer wait.Done()
+			defer func() { <-semaphore }()
+
+			rows[index], errors[index] = fileStatus(file.Path(), file)
+		}(index, file)
+	}
+	wait.Wait()
+
+	for index := range files {
+		if errors[index] != nil {
+			return errors[index]
+		}
+		if rows[index] != nil {
+			report.AddRow(*rows[index])
 		}
 	}
 
@@ -235,50 +310,63 @@ func statusCheckFiles(files entities.Files, report *StatusReport) error {
 }
 
 func statusCheckFile(absPath string, file *entities.File, report *StatusReport) error {
-	log.Infof(2, "%v: checking file status.", absPath)
+	row, err := fileStatus(absPath, file)
+	if err != nil {
+		return err
+	}
+	if row != nil {
+		report.AddRow(*row)
+	}
 
-	stat, err := os.Stat(file.Path())
+	return nil
+}
+
+// Determines the status of a file in the database, or nil if it cannot be
+// determined.
+func fileStatus(absPath string, file *entities.File) (*Row, error) {
+	log.Debugf("%v: checking file status.", absPath)
+
+	// a symbolic link stored as a link is compared against the link itself
+	stat, err := os.Lstat(file.Path())
 	if err != nil {
 		switch {
 		case os.IsNotExist(err):
-			log.Infof(2, "%v: file is missing.", absPath)
+			log.Debugf("%v: file is missing.", absPath)
 
-			report.AddRow(Row{absPath, MISSING})
-			return nil
+			return &Row{absPath, MISSING, file.Id}, nil
 		case os.IsPermission(err):
 			log.Warnf("%v: permission denied.", absPath)
+
+			return nil, nil
 		case strings.Contains(err.Error(), "not a directory"): //TODO improve
-			report.AddRow(Row{file.Path(), MISSING})
-			return nil
+			return &Row{file.Path(), MISSING, file.Id}, nil
 		default:
-			return fmt.Errorf("%v: could not stat: %v", file.Path(), err)
+			return nil, fmt.Errorf("%v: could not stat: %v", file.Path(), err)
 		}
-	} else {
-		if stat.Size() != file.Size || !stat.ModTime().UTC().Equal(file.ModTime) {
-			log.Infof(2, "%v: file is modified.", absPath)
+	}
 
-			report.AddRow(Row{absPath, MODIFIED})
-		} else {
-			log.Infof(2, "%v: file is unchanged.", absPath)
+	if stat.Size() != file.Size || !stat.ModTime().UTC().Equal(file.ModTime) {
+		log.Debugf("%v: file is modified.", absPath)
 
-			report.AddRow(Row{absPath, TAGGED})
-		}
+		return &Row{absPath, MODIFIED, file.Id}, nil
 	}
 
-	return nil
+	log.Debugf("%v: file is unchanged.", absPath)
+
+	return &Row{absPath, TAGGED, file.Id}, nil
 }
 
-func findNewFiles(searchPath string, report *StatusReport, dirOnly, followSymlinks bool) error {
-	log.Infof(2, "%v: finding new files.", searchPath)
+// Reports the path, if it is not already in the report, and the files beneath
+// it as untagged as they are found.
+func findNewFiles(searchPath string, report *StatusReport, scanner *directoryScanner, dirOnly bool) error {
+	log.Debugf("%v: finding new files.", searchPath)
 
 	absPath, err := filepath.Abs(searchPath)
 	if err != nil {
 		return fmt.Errorf("%v: could not get absolute path: %v", searchPath, err)
 	}
 
-	if !report.ContainsRow(absPath) {
-		report.AddRow(Row{absPath, UNTAGGED})
-	}
+	scanner.reportUntagged(absPath, report)
 
 	stat, err := os.Stat(absPath)
 	if err != nil {
@@ -293,23 +381,103 @@ func findNewFiles(searchPath string, report *StatusReport, dirOnly, followSymlin
 		}
 	}
 
-	if !dirOnly && stat.IsDir() {
-		dir, err := os.Open(absPath)
-		if err != nil {
-			return fmt.Errorf("%v: could not open file: %v", searchPath, err)
+	if dirOnly || !stat.IsDir() {
+		return nil
+	}
+
+	if !scanner.visited.enter(absPath) {
+		return nil
+	}
+
+	return scanner.walk(absPath, scanner.list(absPath), report)
+}
+
+// the number of files or directories examined at once
+var statusWorkers = 4 * runtime.NumCPU()
+
+// Walks directory trees reporting untagged files. Directories are listed ahead
+// of the walk reaching them by a bounded number of goroutines, so that the
+// file-system is read concurrently whilst files are still reported in order.
+type directoryScanner struct {
+	ignored        func(path string, isDir bool) (bool, error)
+	followSymlinks bool
+	verifications  entities.Verifications
+	colour         bool
+	semaphore      chan struct{}
+	visited        directoryGuard
+}
+
+type directoryListing struct {
+	entries []os.DirEntry
+	err     error
+	done    chan struct{}
+}
+
+func newDirectoryScanner(ignored func(path string, isDir bool) (bool, error), followSymlinks bool, verifications entities.Verifications, colour bool) *directoryScanner {
+	return &directoryScanner{ignored, followSymlinks, verifications, colour, make(chan struct{}, statusWorkers), make(directoryGuard)}
+}
+
+// Starts listing the directory in the background.
+func (scanner *directoryScanner) list(dirPath string) *directoryListing {
+	listing := &directoryListing{done: make(chan struct{})}
+
+	go func() {
+		scanner.semaphore <- struct{}{}
+		defer func() { <-scanner.semaphore }()
+		defer close(listing.done)
+
+		listing.entries, listing.err = os.ReadDir(dirPath)
+	}()
+
+	return listing
+}
+
+func (scanner *directoryScanner) walk(dirPath string, listing *directoryListing, report *StatusReport) error {
+	<-listing.done
+
+	if listing.err != nil {
+		if os.IsPermission(listing.err) {
+			log.Warnf("%v: permission denied.", dirPath)
+			return nil
 		}
 
-		dirNames, err := dir.Readdirnames(0)
+		return fmt.Errorf("%v: could not read directory listing: %v", dirPath, listing.err)
+	}
+
+	// list the subdirectories whilst this directory's entries are reported
+	entries := make([]os.DirEntry, 0, len(listing.entries))
+	subdirectories := make(map[string]*directoryListing)
+	for _, entry := range listing.entries {
+		path := filepath.Join(dirPath, entry.Name())
+		isDir := scanner.isDir(path, entry)
+
+		ignored, err := scanner.ignored(path, isDir)
 		if err != nil {
-			return fmt.Errorf("%v: could not read directory listing: %v", searchPath, err)
+			return err
+		}
+		if ignored {
+			log.Debugf("%v: ignoring.", path)
+			continue
 		}
 
-		sort.Strings(dirNames)
+		if isDir && !scanner.visited.enter(path) {
+			log.Warnf("%v: skipping directory already visited (symbolic link loop?)", path)
+			isDir = false
+		}
 
-		for _, dirName := range dirNames {
-			dirPath := filepath.Join(absPath, dirName)
-			err = findNewFiles(dirPath, report, dirOnly, followSymlinks)
-			if err != nil {
+		entries = append(entries, entry)
+		if isDir {
+			subdirectories[entry.Name()] = scanner.list(path)
+		}
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dirPath, entry.Name())
+
+		scanner.reportUntagged(path, report)
+
+		if subdirectory, ok := subdirectories[entry.Name()]; ok {
+			if err := scanner.walk(path, subdirectory, report); err != nil {
 				return err
 			}
 		}
@@ -318,22 +486,308 @@ func findNewFiles(searchPath string, report *StatusReport, dirOnly, followSymlin
 	return nil
 }
 
-func printReport(report *StatusReport) {
-	printRows(report.Rows, TAGGED)
-	printRows(report.Rows, MODIFIED)
-	printRows(report.Rows, MISSING)
-	printRows(report.Rows, UNTAGGED)
+// Prints the path as untagged unless it has already been reported.
+func (scanner *directoryScanner) reportUntagged(path string, report *StatusReport) {
+	if report.ContainsRow(path) {
+		return
+	}
+
+	// untagged rows are printed as found rather than kept, so only the path
+	// is recorded in case a later search path overlaps
+	report.paths[path] = true
+	printRow(Row{path, UNTAGGED, 0}, scanner.verifications, scanner.colour)
+}
+
+func (scanner *directoryScanner) isDir(path string, entry os.DirEntry) bool {
+	if entry.Type()&os.ModeSymlink == 0 {
+		return entry.IsDir()
+	}
+	if !scanner.followSymlinks {
+		return false
+	}
+
+	stat, err := os.Stat(path)
+	return err == nil && stat.IsDir()
 }
 
-func printRows(rows []Row, status Status) {
+// Prints the report, with the verification state of each file if verifications is not nil.
+func printReport(report *StatusReport, verifications entities.Verifications, colour bool) {
+	printRows(report.Rows, TAGGED, verifications, colour)
+	printRows(report.Rows, MODIFIED, verifications, colour)
+	printRows(report.Rows, MISSING, verifications, colour)
+	printRows(report.Rows, UNTAGGED, verifications, colour)
+}
+
+func printRows(rows []Row, status Status, verifications entities.Verifications, colour bool) {
 	for _, row := range rows {
 		if row.Status == status {
-			printRow(row)
+			printRow(row, verifications, colour)
 		}
 	}
 }
 
-func printRow(row Row) {
+func printRow(row Row, verifications entities.Verifications, colour bool) {
+	if porcelain {
+		printPorcelainRow(row, verifications)
+		return
+	}
+
 	relPath := _path.Rel(row.Path)
-	fmt.Printf("%v %v\n", string(row.Status), relPath)
+
+	code := string(row.Status)
+	if colourCode, ok := statusColours[row.Status]; colour && ok {
+		code = colourCode + code + ansi.ResetCode
+	}
+
+	if verifications == nil {
+		fmt.Printf("%v %v\n", code, relPath)
+		return
+	}
+
+	state := "-"
+	if row.FileId != 0 {
+		state = verifications.State(row.FileId)
+	}
+
+	padding := strings.Repeat(" ", 10-len(state))
+	if colour && state == "FAILED" {
+		state = ansi.Red(state)
+	}
+
+	fmt.Printf("%v %v%v %v\n", code, state, padding, relPath)
+}
+
+var statusColours = map[Status]string{
+	TAGGED:   ansi.GreenCode,
+	MODIFIED: ansi.YellowCode,
+	MISSING:  ansi.RedCode,
+}
+
+// Prints the row as tab-separated fields with the absolute path, as for the
+// global --porcelain option.
+func printPorcelainRow(row Row, verifications entities.Verifications) {
+	code := row.Status
+	if code == UNTAGGED {
+		code = UNKNOWN
+	}
+
+	if verifications == nil {
+		fmt.Printf("%c\t%v\n", code, row.Path)
+		return
+	}
+
+	state := "-"
+	if row.FileId != 0 {
+		state = verifications.State(row.FileId)
+	}
+
+	fmt.Printf("%c\t%v\t%v\n", code, state, row.Path)
+}
+
+// Lists the differences between the explicit taggings of the files at or
+// beneath the paths, or of all files, in the database and the other database.
+func compareDatabases(store *storage.Storage, databasePath, otherPath string, paths []string, colour bool) error {
+	if _, _, viaSsh := parseSshRemote(otherPath); viaSsh {
+		tempDir, err := ioutil.TempDir("", "tmsu-compare-")
+		if err != nil {
+			return fmt.Errorf("could not create temporary directory: %v", err)
+		}
+		defer os.RemoveAll(tempDir)
+
+		otherPath, err = fetchSshRemote(otherPath, tempDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	if sameFile(databasePath, otherPath) {
+		return fmt.Errorf("cannot compare a database with itself")
+	}
+
+	otherStore, err := openDatabase(otherPath)
+	if err != nil {
+		return fmt.Errorf("could not open database to compare: %v", err)
+	}
+	defer otherStore.Close()
+
+	absPaths := make([]string, len(paths))
+	for index, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("%v: could not get absolute path: %v", path, err)
+		}
+		absPaths[index] = absPath
+	}
+
+	taggings, err := storedTaggings(store, absPaths)
+	if err != nil {
+		return err
+	}
+
+	otherTaggings, err := storedTaggings(otherStore, nil)
+	if err != nil {
+		return fmt.Errorf("could not retrieve taggings to compare: %v", err)
+	}
+
+	storedPaths := make([]string, 0, len(taggings)+len(otherTaggings))
+	for storedPath := range taggings {
+		storedPaths = append(storedPaths, storedPath)
+	}
+	for storedPath := range otherTaggings {
+		if _, ok := taggings[storedPath]; !ok && isWithinPaths(store.AbsStoredPath(storedPath), absPaths) {
+			storedPaths = append(storedPaths, storedPath)
+		}
+	}
+	sort.Strings(storedPaths)
+
+	for _, storedPath := range storedPaths {
+		path := store.AbsStoredPath(storedPath)
+
+		printTaggingDifferences(path, '-', otherTaggings[storedPath].without(taggings[storedPath]), colour)
+		printTaggingDifferences(path, '+', taggings[storedPath].without(otherTaggings[storedPath]), colour)
+	}
+
+	return nil
+}
+
+// A tag and value applied to a file.
+type tagging struct {
+	tagName   string
+	valueName string
+}
+
+type taggings []tagging
+
+// The taggings that are not amongst the others.
+func (taggings taggings) without(others taggings) taggings {
+	result := make([]tagging, 0, len(taggings))
+	for _, tagging := range taggings {
+		found := false
+		for _, other := range others {
+			if tagging == other {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			result = append(result, tagging)
+		}
+	}
+
+	return result
+}
+
+// Retrieves the explicit taggings of the files at or beneath the absolute
+// paths, or of all files, by their stored path.
+func storedTaggings(store *storage.Storage, absPaths []string) (map[string]taggings, error) {
+	tx, err := store.BeginRead()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Commit()
+
+	files, err := store.Files(tx, "none")
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve files: %v", err)
+	}
+
+	fileTags, err := store.FileTags(tx)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve file tags: %v", err)
+	}
+
+	tags, err := store.Tags(tx)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve tags: %v", err)
+	}
+
+	values, err := store.Values(tx)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve values: %v", err)
+	}
+
+	storedPathById := make(map[entities.FileId]string, len(files))
+	for _, file := range files {
+		if isWithinPaths(file.Path(), absPaths) {
+			storedPathById[file.Id] = store.StoredPath(file.Path())
+		}
+	}
+
+	tagNameById := make(map[entities.TagId]string, len(tags))
+	for _, tag := range tags {
+		tagNameById[tag.Id] = tag.Name
+	}
+
+	valueNameById := make(map[entities.ValueId]string, len(values))
+	for _, value := range values {
+		valueNameById[value.Id] = value.Name
+	}
+
+	taggingsByPath := make(map[string]taggings, len(storedPathById))
+	for _, fileTag := range fileTags {
+		storedPath, ok := storedPathById[fileTag.FileId]
+		if !ok {
+			continue
+		}
+
+		taggingsByPath[storedPath] = append(taggingsByPath[storedPath], tagging{tagNameById[fileTag.TagId], valueNameById[fileTag.ValueId]})
+	}
+
+	for _, taggings := range taggingsByPath {
+		sort.Slice(taggings, func(i, j int) bool {
+			if taggings[i].tagName != taggings[j].tagName {
+				return taggings[i].tagName < taggings[j].tagName
+			}
+
+			return taggings[i].valueName < taggings[j].valueName
+		})
+	}
+
+	return taggingsByPath, nil
+}
+
+// Whether the path is at or beneath one of the paths, or there are none.
+func isWithinPaths(path string, paths []string) bool {
+	if len(paths) == 0 {
+		return true
+	}
+
+	for _, parent := range paths {
+		if path == parent || strings.HasPrefix(path, parent+string(filepath.Separator)) || parent == string(filepath.Separator) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func printTaggingDifferences(path string, sign byte, taggings taggings, colour bool) {
+	if len(taggings) == 0 {
+		return
+	}
+
+	if porcelain {
+		for _, tagging := range taggings {
+			fmt.Printf("%c\t%v\t%v\t%v\n", sign, path, tagging.tagName, tagging.valueName)
+		}
+
+		return
+	}
+
+	names := make([]string, len(taggings))
+	for index, tagging := range taggings {
+		names[index] = formatTagValueName(tagging.tagName, tagging.valueName, "", false, false, true)
+	}
+
+	code := string(sign)
+	if colour {
+		if sign == '+' {
+			code = ansi.Green(code)
+		} else {
+			code = ansi.Red(code)
+		}
+	}
+
+	fmt.Printf("%v %v: %v\n", code, _path.Rel(path), strings.Join(names, " "))
 }
@@ -0,0 +1,78 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"github.com/oniony/TMSU/entities"
+	"path/filepath"
+)
+
+// unexported
+
+// How symbolic links are treated: whether the link's target is tagged, the
+// link itself or both.
+type symlinkPolicy string
+
+const (
+	symlinkFollow symlinkPolicy = "follow"
+	symlinkLink   symlinkPolicy = "link"
+	symlinkBoth   symlinkPolicy = "both"
+)
+
+// Determines the policy from the 'symlinkPolicy' setting, unless symbolic links
+// are not to be dereferenced at all.
+func symlinkPolicyFor(options Options, settings entities.Settings) symlinkPolicy {
+	if options.HasOption("--no-dereference") {
+		return symlinkLink
+	}
+
+	return configuredSymlinkPolicy(settings)
+}
+
+// Determines the policy from the 'symlinkPolicy' setting alone.
+func configuredSymlinkPolicy(settings entities.Settings) symlinkPolicy {
+	switch policy := symlinkPolicy(settings.SymlinkPolicy()); policy {
+	case symlinkLink, symlinkBoth:
+		return policy
+	default:
+		return symlinkFollow
+	}
+}
+
+// Whether symbolic links are dereferenced.
+func (policy symlinkPolicy) follow() bool {
+	return policy != symlinkLink
+}
+
+// The directories entered during a recursive operation, by their real path, so
+// that a symbolic link to a directory's ancestor does not recurse forever.
+type directoryGuard map[string]bool
+
+// Records that the directory is being entered, returning false if it has been
+// entered already.
+func (guard directoryGuard) enter(path string) bool {
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		realPath = path
+	}
+
+	if guard[realPath] {
+		return false
+	}
+
+	guard[realPath] = true
+	return true
+}
@@ -0,0 +1,432 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var SyncCommand = Command{
+	Name:     "sync",
+	Synopsis: "Synchronise tagging with another database",
+	Usages:   []string{"tmsu sync [OPTION]... REMOTE"},
+	Description: `Exchanges tagging changes with the database at REMOTE, which is either the path of a database file or, for a database on another machine, HOST:PATH. A database on another machine is copied with 'scp' for the duration of the synchronisation and then copied back.
+
+Each time a tag is applied to or removed from a file the change is recorded. A change recorded in only one database is copied to the other. Where the same file tag was added in one database but removed in the other the most recent change wins, unless --interactive is specified in which case you are asked which to keep.
+
+Files are matched by their path relative to the root of each database, being the directory containing the '.tmsu' directory. Tagging that predates the upgrade of a database to this version is treated as having been applied long ago, so the first synchronisation combines the tagging of both databases.`,
+	Examples: []string{"$ tmsu sync /mnt/nas/photos/.tmsu/db",
+		"$ tmsu sync nas:photos/.tmsu/db",
+		"$ tmsu sync --interactive nas:photos/.tmsu/db"},
+	Options: Options{Option{"--interactive", "-i", "ask which change to keep where the databases conflict", false, ""}},
+	Exec:    syncExec,
+}
+
+// unexported
+
+func syncExec(options Options, args []string, databasePath string) (error, warnings) {
+	if len(args) < 1 {
+		return fmt.Errorf("too few arguments"), nil
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("too many arguments"), nil
+	}
+
+	interactive := options.HasOption("--interactive")
+
+	remotePath := args[0]
+	host, hostPath, viaSsh := parseSshRemote(args[0])
+	if viaSsh {
+		tempDir, err := ioutil.TempDir("", "tmsu-sync-")
+		if err != nil {
+			return fmt.Errorf("could not create temporary directory: %v", err), nil
+		}
+		defer os.RemoveAll(tempDir)
+
+		remotePath, err = fetchSshRemote(args[0], tempDir)
+		if err != nil {
+			return err, nil
+		}
+	}
+
+	if sameFile(databasePath, remotePath) {
+		return fmt.Errorf("cannot synchronise a database with itself"), nil
+	}
+
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	remoteStore, err := openDatabase(remotePath)
+	if err != nil {
+		return fmt.Errorf("could not open remote database: %v", err), nil
+	}
+	defer remoteStore.Close()
+
+	tx, err := store.Begin()
+	if err != nil {
+		return err, nil
+	}
+
+	remoteTx, err := remoteStore.Begin()
+	if err != nil {
+		tx.Rollback()
+		return err, nil
+	}
+
+	syncer := synchroniser{store, tx, remoteStore, remoteTx, interactive, bufio.NewReader(os.Stdin)}
+	if err := syncer.synchronise(); err != nil {
+		remoteTx.Rollback()
+		tx.Rollback()
+		return err, nil
+	}
+
+	if err := remoteTx.Commit(); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("could not commit changes to remote database: %v", err), nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err, nil
+	}
+
+	if viaSsh && !dryRun {
+		log.Debugf("returning database to '%v'", args[0])
+
+		if err := secureCopy(remotePath, host+":"+hostPath); err != nil {
+			return fmt.Errorf("could not return database to '%v': %v", args[0], err), nil
+		}
+	}
+
+	return nil, nil
+}
+
+type synchroniser struct {
+	local       *storage.Storage
+	localTx     *storage.Tx
+	remote      *storage.Storage
+	remoteTx    *storage.Tx
+	interactive bool
+	reader      *bufio.Reader
+}
+
+func (syncer *synchroniser) synchronise() error {
+	localChanges, err := syncer.local.FileTagChanges(syncer.localTx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve local changes: %v", err)
+	}
+
+	remoteChanges, err := syncer.remote.FileTagChanges(syncer.remoteTx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve remote changes: %v", err)
+	}
+
+	localByKey := localChanges.ByKey()
+	remoteByKey := remoteChanges.ByKey()
+
+	keys := make([]entities.FileTagChangeKey, 0, len(localByKey)+len(remoteByKey))
+	for key := range localByKey {
+		keys = append(keys, key)
+	}
+	for key := range remoteByKey {
+		if _, ok := localByKey[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Sort(fileTagChangeKeys(keys))
+
+	for _, key := range keys {
+		localChange := localByKey[key]
+		remoteChange := remoteByKey[key]
+
+		switch {
+		case remoteChange == nil:
+			err = syncer.send(*localChange)
+		case localChange == nil:
+			err = syncer.receive(*remoteChange)
+		case localChange.Removed == remoteChange.Removed:
+			continue
+		default:
+			var keepRemote bool
+			keepRemote, err = syncer.resolveConflict(*localChange, *remoteChange)
+			if err != nil {
+				return err
+			}
+
+			if keepRemote {
+				err = syncer.receive(*remoteChange)
+			} else {
+				err = syncer.send(*localChange)
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (syncer *synchroniser) send(change entities.FileTagChange) error {
+	log.Debugf("%v: sending %v", change.Path(), describeFileTagChange(change))
+
+	return applyFileTagChange(change, syncer.local, syncer.localTx, syncer.remote, syncer.remoteTx)
+}
+
+func (syncer *synchroniser) receive(change entities.FileTagChange) error {
+	log.Debugf("%v: receiving %v", change.Path(), describeFileTagChange(change))
+
+	return applyFileTagChange(change, syncer.remote, syncer.remoteTx, syncer.local, syncer.localTx)
+}
+
+// Determines whether the remote change should be kept in preference to the
+// local one.
+func (syncer *synchroniser) resolveConflict(localChange, remoteChange entities.FileTagChange) (bool, error) {
+	if !syncer.interactive {
+		return remoteChange.ChangedAt.After(localChange.ChangedAt), nil
+	}
+
+	fmt.Printf("%v: %v locally at %v but %v remotely at %v\n",
+		localChange.Path(),
+		describeFileTagChange(localChange),
+		localChange.ChangedAt.Local().Format(time.RFC3339),
+		describeFileTagChange(remoteChange),
+		remoteChange.ChangedAt.Local().Format(time.RFC3339))
+
+	for {
+		fmt.Print("keep [l]ocal or [r]emote change? ")
+
+		answer, err := syncer.reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				return false, fmt.Errorf("conflict for '%v' was not resolved", localChange.Path())
+			}
+			return false, err
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "l", "local":
+			return false, nil
+		case "r", "remote":
+			return true, nil
+		}
+	}
+}
+
+// Applies a change from one database to the other, recording it with the same
+// time so that the databases thereafter agree.
+func applyFileTagChange(change entities.FileTagChange, fromStore *storage.Storage, fromTx *storage.Tx, toStore *storage.Storage, toTx *storage.Tx) error {
+	path := toStore.FileTagChangePath(change)
+
+	file, err := toStore.FileByPath(toTx, path)
+	if err != nil {
+		return fmt.Errorf("%v: could not retrieve file: %v", path, err)
+	}
+
+	tag, err := toStore.TagByName(toTx, change.Tag)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tag '%v': %v", change.Tag, err)
+	}
+
+	value, err := toStore.ValueByName(toTx, change.Value)
+	if err != nil {
+		return fmt.Errorf("could not retrieve value '%v': %v", change.Value, err)
+	}
+
+	if change.Removed {
+		if file != nil && tag != nil && value != nil {
+			if err := removeSyncedFileTag(toStore, toTx, file.Id, tag.Id, value.Id); err != nil {
+				return fmt.Errorf("%v: could not remove tag '%v': %v", path, change.Tag, err)
+			}
+		}
+	} else {
+		if file == nil {
+			file, err = addSyncedFile(fromStore, fromTx, toStore, toTx, change, path)
+			if err != nil {
+				return fmt.Errorf("%v: could not add file: %v", path, err)
+			}
+		}
+
+		if tag == nil {
+			log.Debugf("adding tag '%v'", change.Tag)
+
+			tag, err = toStore.AddTag(toTx, change.Tag)
+			if err != nil {
+				return fmt.Errorf("could not add tag '%v': %v", change.Tag, err)
+			}
+		}
+
+		if value == nil {
+			log.Debugf("adding value '%v'", change.Value)
+
+			value, err = toStore.AddValue(toTx, change.Value)
+			if err != nil {
+				return fmt.Errorf("could not add value '%v': %v", change.Value, err)
+			}
+		}
+
+		exists, err := toStore.FileTagExists(toTx, file.Id, tag.Id, value.Id, true)
+		if err != nil {
+			return fmt.Errorf("%v: could not determine whether tag '%v' is applied: %v", path, change.Tag, err)
+		}
+		if !exists {
+			if _, err := toStore.AddFileTag(toTx, file.Id, tag.Id, value.Id); err != nil {
+				return fmt.Errorf("%v: could not apply tag '%v': %v", path, change.Tag, err)
+			}
+		}
+	}
+
+	if err := toStore.UpdateFileTagChange(toTx, change); err != nil {
+		return fmt.Errorf("%v: could not record change: %v", path, err)
+	}
+
+	return nil
+}
+
+func removeSyncedFileTag(store *storage.Storage, tx *storage.Tx, fileId entities.FileId, tagId entities.TagId, valueId entities.ValueId) error {
+	exists, err := store.FileTagExists(tx, fileId, tagId, valueId, true)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+
+	if err := store.DeleteFileTag(tx, fileId, tagId, valueId); err != nil {
+		return err
+	}
+
+	return store.DeleteFileIfUntagged(tx, fileId)
+}
+
+// Adds a file that is to be tagged, copying its details from the database
+// the change was made in.
+func addSyncedFile(fromStore *storage.Storage, fromTx *storage.Tx, toStore *storage.Storage, toTx *storage.Tx, change entities.FileTagChange, path string) (*entities.File, error) {
+	source, err := fromStore.FileByPath(fromTx, fromStore.FileTagChangePath(change))
+	if err != nil {
+		return nil, err
+	}
+	if source == nil {
+		return toStore.AddFile(toTx, path, "", time.Time{}, 0, false)
+	}
+
+	return toStore.AddFile(toTx, path, source.Fingerprint, source.ModTime, source.Size, source.IsDir)
+}
+
+func describeFileTagChange(change entities.FileTagChange) string {
+	name := formatTagValueName(change.Tag, change.Value, "", false, false, true)
+
+	if change.Removed {
+		return "'" + name + "' removed"
+	}
+
+	return "'" + name + "' added"
+}
+
+// Splits a remote of the form HOST:PATH, as accepted by scp. A colon after a
+// slash is part of a path, as is the drive letter of a Windows path.
+func parseSshRemote(remote string) (string, string, bool) {
+	index := strings.Index(remote, ":")
+	if index < 1 || strings.ContainsAny(remote[:index], `/\`) {
+		return "", "", false
+	}
+	if index == 1 && filepath.VolumeName(remote) != "" {
+		return "", "", false
+	}
+
+	return remote[:index], remote[index+1:], true
+}
+
+// Copies the database at the HOST:PATH remote into the temporary directory,
+// returning the path of the copy.
+func fetchSshRemote(remote, tempDir string) (string, error) {
+	host, hostPath, _ := parseSshRemote(remote)
+
+	// placed within a '.tmsu' directory so that its root is a directory
+	path := filepath.Join(tempDir, ".tmsu", "db")
+	if err := os.Mkdir(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("could not create temporary directory: %v", err)
+	}
+
+	log.Debugf("fetching database from '%v'", remote)
+
+	if err := secureCopy(host+":"+hostPath, path); err != nil {
+		return "", fmt.Errorf("could not fetch database from '%v': %v", remote, err)
+	}
+
+	return path, nil
+}
+
+func secureCopy(source, dest string) error {
+	command := exec.Command("scp", "-q", source, dest)
+	command.Stderr = os.Stderr
+
+	return command.Run()
+}
+
+func sameFile(path, otherPath string) bool {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	otherStat, err := os.Stat(otherPath)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(stat, otherStat)
+}
+
+type fileTagChangeKeys []entities.FileTagChangeKey
+
+func (keys fileTagChangeKeys) Len() int {
+	return len(keys)
+}
+
+func (keys fileTagChangeKeys) Swap(i, j int) {
+	keys[i], keys[j] = keys[j], keys[i]
+}
+
+func (keys fileTagChangeKeys) Less(i, j int) bool {
+	a, b := keys[i], keys[j]
+
+	switch {
+	case a.Directory != b.Directory:
+		return a.Directory < b.Directory
+	case a.Name != b.Name:
+		return a.Name < b.Name
+	case a.Tag != b.Tag:
+		return a.Tag < b.Tag
+	default:
+		return a.Value < b.Value
+	}
+}
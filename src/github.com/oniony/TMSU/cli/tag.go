@@ -17,16 +17,21 @@ package cli
 
 import (
 	"bufio"
+	"encoding/hex"
 	"fmt"
 	"github.com/oniony/TMSU/common/fingerprint"
 	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/common/metadata"
 	"github.com/oniony/TMSU/common/text"
+	"github.com/oniony/TMSU/common/units"
 	"github.com/oniony/TMSU/entities"
-	"github.com/oniony/TMSU/query"
 	"github.com/oniony/TMSU/storage"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var TagCommand = Command{
@@ -37,33 +42,71 @@ var TagCommand = Command{
 		"tmsu tag [OPTION]... --from=SOURCE FILE...",
 		"tmsu tag [OPTION]... --where=QUERY TAG[=VALUE]...",
 		"tmsu tag [OPTION]... --create {TAG|=VALUE}...",
+		"tmsu tag [OPTION]... --fingerprint=FINGERPRINT TAG[=VALUE]...",
+		"tmsu tag [OPTION]... --inherit DIRECTORY TAG[=VALUE]...",
 		"tmsu tag [OPTION[... -"},
 	Description: `Tags the file FILE with the TAGs and VALUEs specified.
 
 Optionally tags applied to files may be attributed with a VALUE using the TAG=VALUE syntax.
 
-Tag and value names may consist of one or more letter, number, punctuation and symbol characters (from the corresponding Unicode categories). Tag names cannot contain the slash '/' or backslash '\' characters.
+Tag and value names may consist of one or more letter, number, punctuation, symbol and whitespace characters (from the corresponding Unicode categories). In the virtual filesystem the slash '/' and backslash '\' characters of a name are shown as the lookalike characters '∕' and '∖'.
+
+With --detect-mime each file is additionally tagged 'mime=TYPE' with the MIME type identified from its content, e.g. 'mime=image/jpeg'. Images, audio and video are also tagged with the coarse category 'image', 'audio' or 'video'.
+
+With --inherit the TAGs applied to a directory are inherited by its contents, both those present now and any added to it in future, whether or not they have themselves been tagged. Inherited tags are resolved whenever a query is run so, unlike --recursive which tags the current contents individually, untagging the directory removes them from its contents too. Queries (and the virtual filesystem) list the tagged files and directories beneath the directory, whilst the 'tags' subcommand shows the inherited tags of any file beneath it.
+
+When tagging recursively with --chunk-size the changes are committed after every N files, rather than all at once, so that tagging a great many files does not hold the database for its duration nor lose all of its work if interrupted. The directories finished are recorded so that running the same command again after an interruption resumes the tagging, skipping those directories that still have the tags applied. --restart discards this record and starts the tagging over.
+
+When tagging recursively, files and directories matching the 'ignorePatterns' setting or the patterns in a '.tmsuignore' file are skipped. See the 'config' subcommand for more information.
+
+Symbolic links are treated according to the 'symlinkPolicy' setting: by default the link's target is tagged ('follow'), but the link itself may be tagged instead ('link') or both the link and its target ('both'). The --no-dereference option always tags the link itself. A directory reached more than once through symbolic links is tagged only once when tagging recursively.
 
 Tags will not be applied if they are already implied by tag implications. This behaviour can be overridden with the --explicit option. See the 'imply' subcommand for more information.
 
+Files of the MIME types handled by the 'plugins' setting are given the tags suggested by the plugin and, for use in detecting duplicates, the fingerprint it calculates. See the 'config' subcommand for more information.
+
+A file not yet in the database that has the content, as identified by its fingerprint and size, of a file in the database that is missing is taken to be the missing file moved: if standard input is a terminal then you are asked whether to relocate the missing file's entry, with its tags, to the new path rather than adding the file anew. With --auto-relocate this is done without asking, otherwise the file is added with a warning. (See also the 'repair' subcommand.)
+
+When the 'propagateDuplicateTags' setting is enabled the TAGs are also applied to the files in the database with the same content, as identified by their fingerprints. See the 'same-as' subcommand for more information.
+
+With --fingerprint the TAGs are applied to the file with the FINGERPRINT specified, so that files can be tagged whilst offline, for example whilst on a detached drive. The FINGERPRINT may be prefixed with the algorithm that calculated it, e.g. 'SHA256:', otherwise that of the 'fileFingerprintAlgorithm' setting is assumed. Any file in the database with the fingerprint is tagged immediately, otherwise the tags are applied once such a file is tagged or is found by the 'repair' subcommand under the paths it searches. Files tagged by fingerprint are listed by 'files --offline'.
+
+With --until the TAGs are applied with an expiry: once the DATE, e.g. 2025-01-01 or 'in 3 days', has passed they are removed, or converted to another tag, by the 'expire' subcommand. Tagging a file again with --until changes the expiry of the tags. --until can be used only when tagging the FILEs specified or those matching --where, and not recursively.
+
+A TAG of the form @NAME applies all of the tags of the bundle NAME. See the 'bundle' subcommand for more information.
+
 If a single argument of - is passed, TMSU will read lines from standard input in the format 'FILE TAG[=VALUE]...'.
 
-Note: The equals '=' and whitespace characters must be escaped with a backslash '\' when used within a tag or value name. However, your shell may use the backslash for its own purposes: this can normally be avoided by enclosing the argument in single quotation marks or by escaping the backslash with an additional backslash '\\'.`,
+Note: The equals '=', whitespace, double quotation mark '"' and backslash '\' characters must be escaped with a backslash '\' when used within a tag or value name, or else that part of the name enclosed in double quotation marks, e.g. '"rock & roll"="live at leeds"', as in queries. The 'nameQuoting' setting determines which form is used when names are output. However, your shell may use the backslash for its own purposes: this can normally be avoided by enclosing the argument in single quotation marks or by escaping the backslash with an additional backslash '\\'.`,
 	Examples: []string{"$ tmsu tag mountain1.jpg photo landscape holiday good country=france",
 		"$ tmsu tag --from=mountain1.jpg mountain2.jpg",
 		`$ tmsu tag --tags="landscape" field1.jpg field2.jpg`,
 		"$ tmsu tag --create bad rubbish awful =2017",
 		`$ tmsu tag --where="bad and good" confused`,
-		"$ tmsu tag sheep.jpg '<tag>'"},
+		"$ tmsu tag sheep.jpg '<tag>'",
+		`$ tmsu tag --tags='"rock & roll" "a=b"=c' song.mp3`,
+		"$ tmsu tag --recursive --detect-mime ~/Music",
+		"$ tmsu tag --recursive --chunk-size=1000 ~/Archive archive",
+		"$ tmsu tag --inherit ~/Photos/2017 year=2017",
+		"$ tmsu tag --fingerprint=SHA256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08 archive",
+		"$ tmsu tag --until=2025-01-01 report.pdf review-by"},
 	Options: Options{{"--tags", "-t", "the set of tags to apply", true, ""},
 		{"--recursive", "-r", "recursively apply tags to directory contents", false, ""},
+		{"--inherit", "-I", "apply tags to directories so that their contents, present and future, inherit them", false, ""},
 		{"--include-hidden", "-H", "don't skip hidden files/directories when tagging recursively", false, ""},
 		{"--from", "-f", "copy tags from the SOURCE file", true, ""},
 		{"--where", "-w", "tags files matching QUERY", true, ""},
+		{"--fingerprint", "", "tags the file with FINGERPRINT, which may be offline", true, ""},
 		{"--create", "-c", "create tags or values without tagging any files", false, ""},
 		{"--explicit", "-e", "explicitly apply tags even if they are already implied", false, ""},
 		{"--force", "-F", "apply tags to non-existent or non-permissioned paths", false, ""},
-		{"--no-dereference", "-P", "do not follow symbolic links (tag the link itself)", false, ""}},
+		{"--no-dereference", "-P", "do not follow symbolic links (tag the link itself)", false, ""},
+		{"--detect-mime", "-M", "also tag files with their detected MIME type", false, ""},
+		{"--jobs", "-j", "fingerprint up to N files at once (default: the number of processors)", true, ""},
+		{"--until", "-u", "remove the tags once DATE has passed (see 'expire')", true, ""},
+		{"--chunk-size", "", "when tagging recursively, commit after every N files", true, ""},
+		{"--restart", "", "when tagging in chunks, start over rather than resume an interrupted run", false, ""},
+		{"--auto-relocate", "", "relocate missing files found to have moved rather than asking", false, ""}},
 	Exec: tagExec,
 }
 
@@ -74,7 +117,52 @@ func tagExec(options Options, args []string, databasePath string) (error, warnin
 	includeHidden := options.HasOption("--include-hidden")
 	explicit := options.HasOption("--explicit")
 	force := options.HasOption("--force")
-	followSymlinks := !options.HasOption("--no-dereference")
+	detectMime := options.HasOption("--detect-mime")
+	inherit := options.HasOption("--inherit")
+	autoRelocate := options.HasOption("--auto-relocate")
+
+	if inherit && recursive {
+		return fmt.Errorf("--inherit cannot be used with --recursive"), nil
+	}
+
+	jobs, err := parseJobs(options)
+	if err != nil {
+		return err, nil
+	}
+
+	var chunkSize uint
+	if options.HasOption("--chunk-size") {
+		if !recursive {
+			return fmt.Errorf("--chunk-size can be used only with --recursive"), nil
+		}
+
+		text := options.Get("--chunk-size").Argument
+		size, err := strconv.ParseUint(text, 10, 32)
+		if err != nil || size == 0 {
+			return fmt.Errorf("invalid chunk size '%v': expected a positive integer", text), nil
+		}
+
+		chunkSize = uint(size)
+	}
+
+	restart := options.HasOption("--restart")
+	if restart && chunkSize == 0 {
+		return fmt.Errorf("--restart can be used only with --chunk-size"), nil
+	}
+
+	var until time.Time
+	if options.HasOption("--until") {
+		for _, name := range []string{"--recursive", "--inherit", "--create", "--from", "--fingerprint"} {
+			if options.HasOption(name) {
+				return fmt.Errorf("--until cannot be used with %v", name), nil
+			}
+		}
+
+		until, err = units.ParseTime(options.Get("--until").Argument)
+		if err != nil {
+			return err, nil
+		}
+	}
 
 	store, err := openDatabase(databasePath)
 	if err != nil {
@@ -88,6 +176,14 @@ func tagExec(options Options, args []string, databasePath string) (error, warnin
 	}
 	defer tx.Commit()
 
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve settings: %v", err), nil
+	}
+
+	symlinks := symlinkPolicyFor(options, settings)
+	relocations := newRelocator(autoRelocate)
+
 	switch {
 	case options.HasOption("--create"):
 		if len(args) == 0 {
@@ -100,7 +196,7 @@ func tagExec(options Options, args []string, databasePath string) (error, warnin
 			return fmt.Errorf("too few arguments"), nil
 		}
 
-		tagArgs := text.Tokenize(options.Get("--tags").Argument)
+		tagArgs := text.TokenizeQuoted(options.Get("--tags").Argument)
 		if len(tagArgs) == 0 {
 			return fmt.Errorf("too few arguments"), nil
 		}
@@ -110,7 +206,20 @@ func tagExec(options Options, args []string, databasePath string) (error, warnin
 			return fmt.Errorf("too few arguments"), nil
 		}
 
-		return tagPaths(store, tx, tagArgs, paths, explicit, recursive, includeHidden, force, followSymlinks)
+		if err := fireHooks(store, tx, hookEvent{"tag", paths, tagArgs, nil}); err != nil {
+			return err, nil
+		}
+
+		if inherit {
+			return tagDirectoriesInherited(store, tx, tagArgs, paths, symlinks)
+		}
+
+		err, warnings := tagPaths(store, tx, tagArgs, paths, explicit, recursive, includeHidden, force, symlinks, detectMime, jobs, chunkSize, restart, relocations)
+		if err == nil && !until.IsZero() {
+			err = expireTagsOnPaths(store, tx, tagArgs, paths, symlinks, until)
+		}
+
+		return err, warnings
 	case options.HasOption("--from"):
 		if len(args) < 1 {
 			return fmt.Errorf("too few arguments"), nil
@@ -123,7 +232,11 @@ func tagExec(options Options, args []string, databasePath string) (error, warnin
 
 		paths := args
 
-		return tagFrom(store, tx, fromPath, paths, explicit, recursive, includeHidden, force, followSymlinks)
+		if err := fireHooks(store, tx, hookEvent{"tag", paths, nil, nil}); err != nil {
+			return err, nil
+		}
+
+		return tagFrom(store, tx, fromPath, paths, explicit, recursive, includeHidden, force, symlinks, detectMime, jobs, relocations)
 	case options.HasOption("--where"):
 		if len(args) < 1 {
 			return fmt.Errorf("too few arguments"), nil
@@ -132,9 +245,35 @@ func tagExec(options Options, args []string, databasePath string) (error, warnin
 		query := options.Get("--where").Argument
 		tagArgs := args
 
-		return tagWhere(store, tx, query, explicit, tagArgs)
+		if err := fireHooks(store, tx, hookEvent{"tag", nil, tagArgs, nil}); err != nil {
+			return err, nil
+		}
+
+		return tagWhere(store, tx, query, explicit, tagArgs, until)
+	case options.HasOption("--fingerprint"):
+		if len(args) < 1 {
+			return fmt.Errorf("too few arguments"), nil
+		}
+
+		tagArgs := args
+
+		if err := fireHooks(store, tx, hookEvent{"tag", nil, tagArgs, nil}); err != nil {
+			return err, nil
+		}
+
+		return tagFingerprint(store, tx, options.Get("--fingerprint").Argument, tagArgs)
 	case len(args) == 1 && args[0] == "-":
-		return readStandardInput(store, tx, recursive, includeHidden, explicit, force, followSymlinks)
+		if !until.IsZero() {
+			return fmt.Errorf("--until cannot be used when reading from standard input"), nil
+		}
+
+		if err := fireHooks(store, tx, hookEvent{"tag", nil, nil, nil}); err != nil {
+			return err, nil
+		}
+
+		// the paths are read from standard input so the user cannot be asked
+		// whether to relocate files
+		return readStandardInput(store, tx, recursive, includeHidden, explicit, force, symlinks, detectMime, jobs, &relocator{autoRelocate, nil})
 	default:
 		if len(args) < 2 {
 			return fmt.Errorf("too few arguments"), nil
@@ -143,7 +282,20 @@ func tagExec(options Options, args []string, databasePath string) (error, warnin
 		paths := args[0:1]
 		tagArgs := args[1:]
 
-		return tagPaths(store, tx, tagArgs, paths, explicit, recursive, includeHidden, force, followSymlinks)
+		if err := fireHooks(store, tx, hookEvent{"tag", paths, tagArgs, nil}); err != nil {
+			return err, nil
+		}
+
+		if inherit {
+			return tagDirectoriesInherited(store, tx, tagArgs, paths, symlinks)
+		}
+
+		err, warnings := tagPaths(store, tx, tagArgs, paths, explicit, recursive, includeHidden, force, symlinks, detectMime, jobs, chunkSize, restart, relocations)
+		if err == nil && !until.IsZero() {
+			err = expireTagsOnPaths(store, tx, tagArgs, paths, symlinks, until)
+		}
+
+		return err, warnings
 	}
 }
 
@@ -187,10 +339,10 @@ func createTagsValues(store *storage.Storage, tx *storage.Tx, tagArgs []string)
 	return nil, warnings
 }
 
-func tagPaths(store *storage.Storage, tx *storage.Tx, tagArgs, paths []string, explicit, recursive, includeHidden, force, followSymlinks bool) (error, warnings) {
+func tagPaths(store *storage.Storage, tx *storage.Tx, tagArgs, paths []string, explicit, recursive, includeHidden, force bool, symlinks symlinkPolicy, detectMime bool, jobs, chunkSize uint, restart bool, relocations *relocator) (error, warnings) {
 	warnings := make(warnings, 0, 10)
 
-	log.Infof(2, "loading settings")
+	log.Debugf("loading settings")
 
 	settings, err := store.Settings(tx)
 	if err != nil {
@@ -202,8 +354,24 @@ func tagPaths(store *storage.Storage, tx *storage.Tx, tagArgs, paths []string, e
 		return err, warnings
 	}
 
+	fingerprints := newFingerprinter(settings, jobs)
+	if err := prepareFingerprints(store, tx, fingerprints, paths); err != nil {
+		return err, warnings
+	}
+
+	var chunker *tagChunker
+	if chunkSize > 0 {
+		chunker, err = newTagChunker(store, tx, chunkSize, tagArgs, paths, explicit, restart)
+		if err != nil {
+			return err, warnings
+		}
+	}
+
+	tagged := true
 	for _, path := range paths {
-		if err := tagPath(store, tx, path, pairs, explicit, recursive, includeHidden, force, followSymlinks, settings.FileFingerprintAlgorithm(), settings.DirectoryFingerprintAlgorithm(), settings.SymlinkFingerprintAlgorithm(), settings.ReportDuplicates()); err != nil {
+		if err := tagPath(store, tx, path, pairs, explicit, recursive, includeHidden, force, symlinks, make(directoryGuard), chunker, detectMime, fingerprints, relocations, settings.ReportDuplicates(), settings.PropagateDuplicateTags()); err != nil {
+			tagged = false
+
 			switch {
 			case os.IsPermission(err):
 				warnings = append(warnings, fmt.Sprintf("%v: permission denied", path))
@@ -215,18 +383,95 @@ func tagPaths(store *storage.Storage, tx *storage.Tx, tagArgs, paths []string, e
 		}
 	}
 
+	// the journal is kept until every path is tagged so that, once the problem
+	// is resolved, the tagging can be resumed
+	if tagged {
+		if err := chunker.complete(); err != nil {
+			return err, warnings
+		}
+	}
+
 	return nil, warnings
 }
 
-func tagFrom(store *storage.Storage, tx *storage.Tx, fromPath string, paths []string, explicit, recursive, includeHidden, force, followSymlinks bool) (error, warnings) {
-	log.Infof(2, "loading settings")
+// Applies the tags to the directories and marks them as inherited by the
+// directories' contents.
+func tagDirectoriesInherited(store *storage.Storage, tx *storage.Tx, tagArgs, paths []string, symlinks symlinkPolicy) (error, warnings) {
+	warnings := make(warnings, 0, 10)
+
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return err, warnings
+	}
+
+	pairs, warnings, err := parseTagValuePairs(store, tx, settings, tagArgs, warnings)
+	if err != nil {
+		return err, warnings
+	}
+
+	fingerprints := newFingerprinter(settings, 1)
+
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("%v: could not get absolute path: %v", path, err), warnings
+		}
+
+		if symlinks.follow() {
+			absPath, err = filepath.EvalSymlinks(absPath)
+		}
+
+		var stat os.FileInfo
+		if err == nil {
+			stat, err = os.Stat(absPath)
+		}
+		if err != nil {
+			switch {
+			case os.IsPermission(err):
+				warnings = append(warnings, fmt.Sprintf("%v: permission denied", path))
+				continue
+			case os.IsNotExist(err):
+				warnings = append(warnings, fmt.Sprintf("%v: no such file", path))
+				continue
+			default:
+				return fmt.Errorf("%v: could not stat file: %v", path, err), warnings
+			}
+		}
+		if !stat.IsDir() {
+			return fmt.Errorf("%v: tags can only be inherited from a directory", path), warnings
+		}
+
+		// applied explicitly as the directory's file tags must exist to be inherited
+		if err := tagFile(store, tx, path, absPath, stat, pairs, true, false, false, fingerprints, nil, false, false); err != nil {
+			return err, warnings
+		}
+
+		file, err := store.FileByPath(tx, absPath)
+		if err != nil {
+			return fmt.Errorf("%v: could not retrieve file: %v", path, err), warnings
+		}
+
+		log.Debugf("%v: marking tags as inherited", path)
+
+		for _, pair := range pairs {
+			if err := store.SetFileTagInheritance(tx, file.Id, pair.TagId, pair.ValueId, true); err != nil {
+				return fmt.Errorf("%v: could not mark tags as inherited: %v", path, err), warnings
+			}
+		}
+	}
+
+	return nil, warnings
+}
+
+func tagFrom(store *storage.Storage, tx *storage.Tx, fromPath string, paths []string, explicit, recursive, includeHidden, force bool, symlinks symlinkPolicy, detectMime bool, jobs uint, relocations *relocator) (error, warnings) {
+	log.Debugf("loading settings")
 
 	settings, err := store.Settings(tx)
 	if err != nil {
 		return fmt.Errorf("could not retrieve settings: %v", err), nil
 	}
 
-	if followSymlinks {
+	if symlinks.follow() {
 		fromPath, err = filepath.EvalSymlinks(fromPath)
 		if err != nil {
 			return err, nil
@@ -253,8 +498,13 @@ func tagFrom(store *storage.Storage, tx *storage.Tx, fromPath string, paths []st
 
 	warnings := make(warnings, 0, 10)
 
+	fingerprints := newFingerprinter(settings, jobs)
+	if err := prepareFingerprints(store, tx, fingerprints, paths); err != nil {
+		return err, warnings
+	}
+
 	for _, path := range paths {
-		if err := tagPath(store, tx, path, pairs, explicit, recursive, includeHidden, force, followSymlinks, settings.FileFingerprintAlgorithm(), settings.DirectoryFingerprintAlgorithm(), settings.SymlinkFingerprintAlgorithm(), settings.ReportDuplicates()); err != nil {
+		if err := tagPath(store, tx, path, pairs, explicit, recursive, includeHidden, force, symlinks, make(directoryGuard), nil, detectMime, fingerprints, relocations, settings.ReportDuplicates(), settings.PropagateDuplicateTags()); err != nil {
 			switch {
 			case os.IsPermission(err):
 				warnings = append(warnings, fmt.Sprintf("%v: permission denied", path))
@@ -269,24 +519,24 @@ func tagFrom(store *storage.Storage, tx *storage.Tx, fromPath string, paths []st
 	return nil, warnings
 }
 
-func tagWhere(store *storage.Storage, tx *storage.Tx, queryText string, explicit bool, tagArgs []string) (error, warnings) {
+func tagWhere(store *storage.Storage, tx *storage.Tx, queryText string, explicit bool, tagArgs []string, until time.Time) (error, warnings) {
 	warnings := make(warnings, 0, 10)
 
-	log.Infof(2, "loading settings")
+	log.Debugf("loading settings")
 
 	settings, err := store.Settings(tx)
 	if err != nil {
 		return err, warnings
 	}
 
-	log.Info(2, "parsing query")
+	log.Debug("parsing query")
 
-	expression, err := query.Parse(queryText)
+	expression, err := parseQuery(store, tx, queryText)
 	if err != nil {
-		return fmt.Errorf("could not parse query: %v", err), warnings
+		return err, warnings
 	}
 
-	log.Info(2, "querying files")
+	log.Debug("querying files")
 
 	files, err := store.FilesForQuery(tx, expression, "", explicit, false, "none")
 	if err != nil {
@@ -298,7 +548,7 @@ func tagWhere(store *storage.Storage, tx *storage.Tx, queryText string, explicit
 		return err, warnings
 	}
 
-	log.Infof(2, "applying tags to %v files", len(files))
+	log.Debugf("applying tags to %v files", len(files))
 
 	for _, file := range files {
 		for _, pair := range pairs {
@@ -306,18 +556,168 @@ func tagWhere(store *storage.Storage, tx *storage.Tx, queryText string, explicit
 				return fmt.Errorf("could not apply tags: %v", err), warnings
 			}
 		}
+
+		if settings.PropagateDuplicateTags() {
+			if err := propagateTags(store, tx, file, pairs, explicit); err != nil {
+				return fmt.Errorf("%v: could not apply tags to duplicates: %v", file.Path(), err), warnings
+			}
+		}
+
+		if !until.IsZero() {
+			if err := expireTags(store, tx, file, pairs, until); err != nil {
+				return err, warnings
+			}
+		}
+	}
+
+	return nil, warnings
+}
+
+// Sets the expiry of the tags applied to the files at the paths.
+func expireTagsOnPaths(store *storage.Storage, tx *storage.Tx, tagArgs, paths []string, symlinks symlinkPolicy, until time.Time) error {
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return err
+	}
+
+	pairs, _, err := parseTagValuePairs(store, tx, settings, tagArgs, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("%v: could not get absolute path: %v", path, err)
+		}
+
+		if symlinks.follow() {
+			if resolvedPath, err := filepath.EvalSymlinks(absPath); err == nil {
+				absPath = resolvedPath
+			}
+		}
+
+		file, err := store.FileByPath(tx, absPath)
+		if err != nil {
+			return fmt.Errorf("%v: could not retrieve file: %v", path, err)
+		}
+		if file == nil {
+			continue
+		}
+
+		if err := expireTags(store, tx, file, pairs, until); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Sets the expiry of the tags explicitly applied to the file. Tags that are
+// only implied have no expiry.
+func expireTags(store *storage.Storage, tx *storage.Tx, file *entities.File, pairs entities.TagIdValueIdPairs, until time.Time) error {
+	for _, pair := range pairs {
+		set, err := store.SetFileTagExpiry(tx, file.Id, pair.TagId, pair.ValueId, until)
+		if err != nil {
+			return fmt.Errorf("%v: could not set expiry of tags: %v", file.Path(), err)
+		}
+		if !set {
+			log.Debugf("%v: tag #%v is not explicitly applied so cannot expire", file.Path(), pair.TagId)
+		}
+	}
+
+	return nil
+}
+
+func tagFingerprint(store *storage.Storage, tx *storage.Tx, fingerprintArg string, tagArgs []string) (error, warnings) {
+	warnings := make(warnings, 0, 10)
+
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return err, warnings
+	}
+
+	algorithm, fp, err := parseFingerprint(fingerprintArg, settings)
+	if err != nil {
+		return err, warnings
+	}
+
+	pairs, warnings, err := parseTagValuePairs(store, tx, settings, tagArgs, warnings)
+	if err != nil {
+		return err, warnings
+	}
+
+	files := entities.Files{}
+	if algorithm == settings.FileFingerprintAlgorithm() {
+		files, err = store.FilesByFingerprint(tx, fp)
+		if err != nil {
+			return fmt.Errorf("could not retrieve files with fingerprint '%v': %v", fp, err), warnings
+		}
+	}
+
+	if len(files) == 0 {
+		log.Debugf("%v: no such file in the database: recording tags against the fingerprint", fp)
+
+		for _, pair := range pairs {
+			if _, err := store.AddFingerprintTag(tx, algorithm, fp, pair.TagId, pair.ValueId); err != nil {
+				return fmt.Errorf("could not apply tags: %v", err), warnings
+			}
+		}
+
+		return nil, warnings
+	}
+
+	for _, file := range files {
+		log.Debugf("%v: applying tags.", file.Path())
+
+		for _, pair := range pairs {
+			if _, err = store.AddFileTag(tx, file.Id, pair.TagId, pair.ValueId); err != nil {
+				return fmt.Errorf("%v: could not apply tags: %v", file.Path(), err), warnings
+			}
+		}
 	}
 
 	return nil, warnings
 }
 
-func tagPath(store *storage.Storage, tx *storage.Tx, path string, pairs []entities.TagIdValueIdPair, explicit, recursive, includeHidden, force, followSymlinks bool, fileFingerprintAlg, dirFingerprintAlg, symlinkFingerprintAlg string, reportDuplicates bool) error {
+// Parses a fingerprint of the form [ALGORITHM:]HEX, returning the algorithm,
+// which is that of the settings if unspecified, and the fingerprint.
+func parseFingerprint(text string, settings entities.Settings) (string, fingerprint.Fingerprint, error) {
+	algorithm := settings.FileFingerprintAlgorithm()
+
+	index := strings.LastIndex(text, ":")
+	if index != -1 {
+		algorithm = text[:index]
+		text = text[index+1:]
+
+		if !containsString(fileFingerprintAlgorithms, algorithm) || algorithm == "none" {
+			return "", fingerprint.Empty, fmt.Errorf("unsupported fingerprint algorithm '%v'", algorithm)
+		}
+	}
+
+	if _, err := hex.DecodeString(text); err != nil || text == "" {
+		return "", fingerprint.Empty, fmt.Errorf("invalid fingerprint '%v': expected hexadecimal digits", text)
+	}
+
+	return algorithm, fingerprint.Fingerprint(strings.ToLower(text)), nil
+}
+
+func tagPath(store *storage.Storage, tx *storage.Tx, path string, pairs []entities.TagIdValueIdPair, explicit, recursive, includeHidden, force bool, symlinks symlinkPolicy, guard directoryGuard, chunker *tagChunker, detectMime bool, fingerprints *fingerprinter, relocations *relocator, reportDuplicates, propagateDuplicates bool) error {
 	absPath, err := filepath.Abs(path)
 	if err != nil {
 		return fmt.Errorf("%v: could not get absolute path: %v", path, err)
 	}
 
-	log.Infof(2, "%v: resolving path", path)
+	skip, err := chunker.skip(absPath, pairs)
+	if err != nil {
+		return err
+	}
+	if skip {
+		log.Debugf("%v: skipping directory already tagged", path)
+		return nil
+	}
+
+	log.Debugf("%v: resolving path", path)
 
 	stat, err := os.Lstat(absPath)
 	if err != nil {
@@ -333,7 +733,14 @@ func tagPath(store *storage.Storage, tx *storage.Tx, path string, pairs []entiti
 			return err
 		}
 	}
-	if followSymlinks {
+	if symlinks == symlinkBoth && stat.Mode()&os.ModeSymlink != 0 {
+		log.Debugf("%v: tagging symbolic link", path)
+
+		if err := tagFile(store, tx, path, absPath, stat, pairs, explicit, force, detectMime, fingerprints, relocations, reportDuplicates, propagateDuplicates); err != nil {
+			return err
+		}
+	}
+	if symlinks.follow() {
 		absPath, err = filepath.EvalSymlinks(absPath)
 		if err != nil {
 			// can't honour 'force' as we don't know the target path
@@ -346,60 +753,156 @@ func tagPath(store *storage.Storage, tx *storage.Tx, path string, pairs []entiti
 		}
 	}
 
-	log.Infof(2, "%v: checking if file exists in database", path)
+	if err := tagFile(store, tx, path, absPath, stat, pairs, explicit, force, detectMime, fingerprints, relocations, reportDuplicates, propagateDuplicates); err != nil {
+		return err
+	}
+
+	if err := chunker.tagged(); err != nil {
+		return err
+	}
+
+	if recursive && stat.IsDir() {
+		if !guard.enter(absPath) {
+			log.Warnf("%v: skipping directory already tagged (symbolic link loop?)", path)
+			return nil
+		}
+
+		if err = tagRecursively(store, tx, absPath, pairs, explicit, includeHidden, force, symlinks, guard, chunker, detectMime, fingerprints, relocations, reportDuplicates, propagateDuplicates); err != nil {
+			return err
+		}
+
+		if err := chunker.finish(absPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Applies the tags to the file at the resolved path, adding it to the database
+// if necessary.
+func tagFile(store *storage.Storage, tx *storage.Tx, path, absPath string, stat os.FileInfo, pairs []entities.TagIdValueIdPair, explicit, force, detectMime bool, fingerprints *fingerprinter, relocations *relocator, reportDuplicates, propagateDuplicates bool) error {
+	log.Debugf("%v: checking if file exists in database", path)
 
 	file, err := store.FileByPath(tx, absPath)
 	if err != nil {
 		return fmt.Errorf("%v: could not retrieve file: %v", path, err)
 	}
 	if file == nil {
-		log.Infof(2, "%v: creating fingerprint", path)
+		log.Debugf("%v: creating fingerprint", path)
 
-		fp, err := fingerprint.Create(absPath, fileFingerprintAlg, dirFingerprintAlg, symlinkFingerprintAlg)
+		fp, err := fingerprints.create(absPath)
 		if err != nil {
 			if !force || !(os.IsNotExist(err) || os.IsPermission(err)) {
 				return fmt.Errorf("%v: could not create fingerprint: %v", path, err)
 			}
 		}
 
-		if fp != fingerprint.Empty && reportDuplicates {
-			log.Infof(2, "%v: checking for duplicates", path)
+		// a missing file with the same content may have been moved here
+		file, err = relocations.relocate(store, tx, path, absPath, fp, stat)
+		if err != nil {
+			return fmt.Errorf("%v: could not relocate file: %v", path, err)
+		}
+		if file == nil {
+			if fp != fingerprint.Empty && reportDuplicates {
+				log.Debugf("%v: checking for duplicates", path)
 
-			count, err := store.FileCountByFingerprint(tx, fp)
-			if err != nil {
-				return fmt.Errorf("%v: could not identify duplicates: %v", path, err)
+				count, err := store.FileCountByFingerprint(tx, fp)
+				if err != nil {
+					return fmt.Errorf("%v: could not identify duplicates: %v", path, err)
+				}
+				if count != 0 {
+					log.Warnf("'%v' is a duplicate", path)
+				}
 			}
-			if count != 0 {
-				log.Warnf("'%v' is a duplicate", path)
+
+			log.Debugf("%v: adding file", path)
+
+			file, err = store.AddFile(tx, absPath, fp, stat.ModTime(), int64(stat.Size()), stat.IsDir())
+			if err != nil {
+				return fmt.Errorf("%v: could not add file to database: %v", path, err)
 			}
 		}
+	}
 
-		log.Infof(2, "%v: adding file", path)
-
-		file, err = store.AddFile(tx, absPath, fp, stat.ModTime(), int64(stat.Size()), stat.IsDir())
+	filePairs := pairs
+	if detectMime && !stat.IsDir() {
+		mimePairs, err := mimeTagValuePairs(store, tx, absPath)
 		if err != nil {
-			return fmt.Errorf("%v: could not add file to database: %v", path, err)
+			return fmt.Errorf("%v: could not detect MIME type: %v", path, err)
 		}
+
+		filePairs = append(append(make([]entities.TagIdValueIdPair, 0, len(pairs)+len(mimePairs)), pairs...), mimePairs...)
+	}
+
+	// the automatic tags derive from the path rather than the content so are
+	// not shared with duplicates
+	contentPairs := filePairs
+
+	autoPairs, err := autoTagValuePairs(store, tx, absPath)
+	if err != nil {
+		return fmt.Errorf("%v: could not apply automatic tags: %v", path, err)
+	}
+	if len(autoPairs) > 0 {
+		filePairs = append(append(make([]entities.TagIdValueIdPair, 0, len(filePairs)+len(autoPairs)), filePairs...), autoPairs...)
 	}
 
 	if !explicit {
-		pairs, err = removeAlreadyAppliedTagValuePairs(store, tx, pairs, file)
+		filePairs, err = removeAlreadyAppliedTagValuePairs(store, tx, filePairs, file)
 		if err != nil {
 			return fmt.Errorf("%v: could not remove applied tags: %v", path, err)
 		}
 	}
 
-	log.Infof(2, "%v: applying tags.", path)
+	log.Debugf("%v: applying tags.", path)
 
-	for _, pair := range pairs {
+	for _, pair := range filePairs {
 		if _, err = store.AddFileTag(tx, file.Id, pair.TagId, pair.ValueId); err != nil {
 			return fmt.Errorf("%v: could not apply tags: %v", path, err)
 		}
 	}
 
-	if recursive && stat.IsDir() {
-		if err = tagRecursively(store, tx, absPath, pairs, explicit, includeHidden, force, followSymlinks, fileFingerprintAlg, dirFingerprintAlg, symlinkFingerprintAlg, reportDuplicates); err != nil {
-			return err
+	if propagateDuplicates {
+		if err := propagateTags(store, tx, file, contentPairs, explicit); err != nil {
+			return fmt.Errorf("%v: could not apply tags to duplicates: %v", path, err)
+		}
+	}
+
+	return nil
+}
+
+// Applies the tags to the other files in the database with the same content as
+// the file, as identified by their fingerprint. Directories and empty files are
+// not considered to share content.
+func propagateTags(store *storage.Storage, tx *storage.Tx, file *entities.File, pairs []entities.TagIdValueIdPair, explicit bool) error {
+	if file.IsDir || file.Size == 0 || file.Fingerprint == fingerprint.Empty || len(pairs) == 0 {
+		return nil
+	}
+
+	duplicates, err := store.FilesByFingerprint(tx, file.Fingerprint)
+	if err != nil {
+		return err
+	}
+
+	for _, duplicate := range duplicates {
+		if duplicate.Id == file.Id || duplicate.IsDir {
+			continue
+		}
+
+		duplicatePairs := pairs
+		if !explicit {
+			duplicatePairs, err = removeAlreadyAppliedTagValuePairs(store, tx, pairs, duplicate)
+			if err != nil {
+				return err
+			}
+		}
+
+		log.Debugf("%v: applying tags of duplicate '%v'", duplicate.Path(), file.Path())
+
+		for _, pair := range duplicatePairs {
+			if _, err := store.AddFileTag(tx, duplicate.Id, pair.TagId, pair.ValueId); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -407,7 +910,12 @@ func tagPath(store *storage.Storage, tx *storage.Tx, path string, pairs []entiti
 }
 
 func parseTagValuePairs(store *storage.Storage, tx *storage.Tx, settings entities.Settings, tagArgs []string, warnings warnings) (entities.TagIdValueIdPairs, warnings, error) {
-	log.Info(2, "parsing tag/value pairs")
+	log.Debug("parsing tag/value pairs")
+
+	tagArgs, err := expandBundles(store, tx, tagArgs)
+	if err != nil {
+		return nil, warnings, err
+	}
 
 	pairs := make(entities.TagIdValueIdPairs, 0, len(tagArgs))
 
@@ -419,17 +927,30 @@ func parseTagValuePairs(store *storage.Storage, tx *storage.Tx, settings entitie
 			return nil, warnings, err
 		}
 		if tag == nil {
+			if !settings.PermitsNewTag(tagName) {
+				return nil, warnings, closedVocabularyError(store, tx, tagName)
+			}
+
 			if settings.AutoCreateTags() {
 				tag, err = createTag(store, tx, tagName)
 				if err != nil {
 					return nil, warnings, err
 				}
 			} else {
-				warnings = append(warnings, fmt.Sprintf("no such tag '%v'", tagName))
+				warnings = append(warnings, noSuchTagWarning(noSuchTagError(store, tx, tagName)))
 				continue
 			}
 		}
 
+		valueName, warnings, err = canonicalValueName(store, tx, settings, tag.Id, tagArg, valueName, warnings)
+		if err != nil {
+			return nil, warnings, fmt.Errorf("cannot apply %v", err)
+		}
+
+		if err := store.ValidateTagValue(tx, tag.Id, valueName); err != nil {
+			return nil, warnings, fmt.Errorf("cannot apply '%v': %v", tagArg, err)
+		}
+
 		value, err := store.ValueByName(tx, valueName)
 		if err != nil {
 			return nil, warnings, err
@@ -441,7 +962,7 @@ func parseTagValuePairs(store *storage.Storage, tx *storage.Tx, settings entitie
 					return nil, warnings, err
 				}
 			} else {
-				warnings = append(warnings, fmt.Sprintf("no such value '%v'", valueName))
+				warnings = append(warnings, noSuchTagWarning(NoSuchValueError{valueName}))
 				continue
 			}
 		}
@@ -452,7 +973,117 @@ func parseTagValuePairs(store *storage.Storage, tx *storage.Tx, settings entitie
 	return pairs, warnings, nil
 }
 
-func readStandardInput(store *storage.Storage, tx *storage.Tx, recursive, includeHidden, explicit, force, followSymlinks bool) (error, warnings) {
+func mimeTagValuePairs(store *storage.Storage, tx *storage.Tx, path string) (entities.TagIdValueIdPairs, error) {
+	log.Debugf("%v: detecting MIME type", path)
+
+	mimeType, err := metadata.DetectMimeType(path)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	tagArgs := []string{"mime=" + escape(mimeType, '\\', '=')}
+	category := metadata.MediaCategory(mimeType)
+	if category != "" {
+		tagArgs = append(tagArgs, category)
+	}
+
+	pairs, warnings, err := parseTagValuePairs(store, tx, settings, tagArgs, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range warnings {
+		log.Warn(warning)
+	}
+
+	return pairs, nil
+}
+
+// The tags applied by the automatic tagging rules and path templates matching
+// the path.
+func autoTagValuePairs(store *storage.Storage, tx *storage.Tx, path string) (entities.TagIdValueIdPairs, error) {
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	autoTags, err := settings.AutoTags()
+	if err != nil {
+		return nil, err
+	}
+
+	tagArgs := make([]string, 0, len(autoTags))
+	for _, autoTag := range autoTags {
+		if autoTag.Matches(path) {
+			log.Debugf("%v: matches automatic tagging rule '%v'", path, autoTag.Pattern)
+
+			tagArgs = append(tagArgs, autoTag.TagArg)
+		}
+	}
+
+	templates, err := settings.PathTemplates()
+	if err != nil {
+		return nil, err
+	}
+
+	tagArgs = append(tagArgs, pathTemplateTagArgs(store, templates, path)...)
+
+	plugins, err := settings.Plugins()
+	if err != nil {
+		return nil, err
+	}
+
+	if result := runPlugins(plugins, path); result != nil && len(result.Tags) > 0 {
+		log.Debugf("%v: plugin suggests tags %v", path, strings.Join(result.Tags, " "))
+
+		tagArgs = append(tagArgs, result.Tags...)
+	}
+
+	if len(tagArgs) == 0 {
+		return nil, nil
+	}
+
+	pairs, warnings, err := parseTagValuePairs(store, tx, settings, tagArgs, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range warnings {
+		log.Warn(warning)
+	}
+
+	return pairs, nil
+}
+
+// The TAG=VALUE arguments derived from the path by the templates matching it.
+// Path components that are not valid value names are skipped with a warning.
+func pathTemplateTagArgs(store *storage.Storage, templates []entities.PathTemplate, path string) []string {
+	tagArgs := make([]string, 0, 10)
+	for _, template := range templates {
+		pathTags, matched := template.Match(path, store.RootPath)
+		if !matched {
+			continue
+		}
+
+		log.Debugf("%v: matches path template '%v'", path, template.Text)
+
+		for _, pathTag := range pathTags {
+			if err := entities.ValidateValueName(pathTag.ValueName); err != nil {
+				log.Warnf("%v: cannot derive tag '%v' from path: %v", path, pathTag.TagName, err)
+				continue
+			}
+
+			tagArgs = append(tagArgs, escape(pathTag.TagName, '\\', '=', '"')+"="+escape(pathTag.ValueName, '\\', '=', '"'))
+		}
+	}
+
+	return tagArgs
+}
+
+func readStandardInput(store *storage.Storage, tx *storage.Tx, recursive, includeHidden, explicit, force bool, symlinks symlinkPolicy, detectMime bool, jobs uint, relocations *relocator) (error, warnings) {
 	reader := bufio.NewReader(os.Stdin)
 
 	warnings := make(warnings, 0, 10)
@@ -477,7 +1108,7 @@ func readStandardInput(store *storage.Storage, tx *storage.Tx, recursive, includ
 		path := words[0]
 		tagArgs := words[1:]
 
-		err, commandWarnings := tagPaths(store, tx, tagArgs, []string{path}, explicit, recursive, includeHidden, force, followSymlinks)
+		err, commandWarnings := tagPaths(store, tx, tagArgs, []string{path}, explicit, recursive, includeHidden, force, symlinks, detectMime, jobs, 0, false, relocations)
 		if err != nil {
 			warnings = append(warnings, err.Error())
 		}
@@ -489,7 +1120,7 @@ func readStandardInput(store *storage.Storage, tx *storage.Tx, recursive, includ
 	return nil, warnings
 }
 
-func tagRecursively(store *storage.Storage, tx *storage.Tx, path string, pairs []entities.TagIdValueIdPair, explicit, includeHidden, force, followSymlinks bool, fileFingerprintAlg, dirFingerprintAlg, symlinkFingerprintAlg string, reportDuplicates bool) error {
+func tagRecursively(store *storage.Storage, tx *storage.Tx, path string, pairs []entities.TagIdValueIdPair, explicit, includeHidden, force bool, symlinks symlinkPolicy, guard directoryGuard, chunker *tagChunker, detectMime bool, fingerprints *fingerprinter, relocations *relocator, reportDuplicates, propagateDuplicates bool) error {
 	osFile, err := os.Open(path)
 	if err != nil {
 		return fmt.Errorf("%v: could not open path: %v", path, err)
@@ -501,37 +1132,92 @@ func tagRecursively(store *storage.Storage, tx *storage.Tx, path string, pairs [
 		return fmt.Errorf("%v: could not retrieve directory contents: %v", path, err)
 	}
 
+	childPaths := make([]string, 0, len(childNames))
 	for _, childName := range childNames {
 		childPath := filepath.Join(path, childName)
 		if childName[0] == '.' && !includeHidden {
-			log.Infof(2, "%v: skipping hidden file/directory", childPath)
+			log.Debugf("%v: skipping hidden file/directory", childPath)
 			continue
 		}
 
-		if err = tagPath(store, tx, childPath, pairs, explicit, true, includeHidden, force, followSymlinks, fileFingerprintAlg, dirFingerprintAlg, symlinkFingerprintAlg, reportDuplicates); err != nil {
+		ignored, err := isIgnored(store, tx, childPath)
+		if err != nil {
 			return err
 		}
+		if ignored {
+			log.Debugf("%v: skipping ignored file/directory", childPath)
+			continue
+		}
+
+		childPaths = append(childPaths, childPath)
+	}
+
+	if err := prepareFingerprints(store, tx, fingerprints, childPaths); err != nil {
+		return err
 	}
 
+	for _, childPath := range childPaths {
+		if err = tagPath(store, tx, childPath, pairs, explicit, true, includeHidden, force, symlinks, guard, chunker, detectMime, fingerprints, relocations, reportDuplicates, propagateDuplicates); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Fingerprints those of the files at the specified paths that will need to be
+// added to the database. Only regular files are prepared: symbolic links and
+// directories are fingerprinted as they are tagged.
+func prepareFingerprints(store *storage.Storage, tx *storage.Tx, fingerprints *fingerprinter, paths []string) error {
+	if fingerprints.jobs < 2 || len(paths) < 2 {
+		return nil
+	}
+
+	absPaths := make([]string, 0, len(paths))
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("%v: could not get absolute path: %v", path, err)
+		}
+
+		stat, err := os.Lstat(absPath)
+		if err != nil || !stat.Mode().IsRegular() {
+			// reported when the path is tagged
+			continue
+		}
+
+		file, err := store.FileByPath(tx, absPath)
+		if err != nil {
+			return fmt.Errorf("%v: could not retrieve file: %v", path, err)
+		}
+		if file != nil {
+			continue
+		}
+
+		absPaths = append(absPaths, absPath)
+	}
+
+	fingerprints.prepare(absPaths)
+
 	return nil
 }
 
 func removeAlreadyAppliedTagValuePairs(store *storage.Storage, tx *storage.Tx, pairs []entities.TagIdValueIdPair, file *entities.File) ([]entities.TagIdValueIdPair, error) {
-	log.Infof(2, "%v: determining existing file-tags", file.Path())
+	log.Debugf("%v: determining existing file-tags", file.Path())
 
 	existingFileTags, err := store.FileTagsByFileId(tx, file.Id, false)
 	if err != nil {
 		return nil, fmt.Errorf("%v: could not determine file's tags: %v", file.Path(), err)
 	}
 
-	log.Infof(2, "%v: determining implied tags", file.Path())
+	log.Debugf("%v: determining implied tags", file.Path())
 
 	newImplications, err := store.ImplicationsFor(tx, pairs...)
 	if err != nil {
 		return nil, fmt.Errorf("%v: could not determine implied tags: %v", file.Path(), err)
 	}
 
-	log.Infof(2, "%v: revising set of tags to apply", file.Path())
+	log.Debugf("%v: revising set of tags to apply", file.Path())
 
 	revisedPairs := make([]entities.TagIdValueIdPair, 0, len(pairs))
 	for _, pair := range pairs {
@@ -0,0 +1,210 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage"
+	"strings"
+)
+
+var TagDefCommand = Command{
+	Name:     "tag-def",
+	Synopsis: "Defines the type of a tag's values",
+	Usages: []string{"tmsu tag-def [TAG]...",
+		"tmsu tag-def TAG TYPE [VALUE]...",
+		"tmsu tag-def --delete TAG..."},
+	Description: `Defines the type of the values that may be applied with TAG.
+
+TYPE is one of:
+
+  text     any value (the default for tags without a definition)
+  integer  whole numbers, e.g. 42 or -7
+  date     YYYY, YYYY-MM, YYYY-MM-DD or YYYY-MM-DDTHH:MM:SS
+  enum     one of the VALUEs listed, which are ordered as specified
+
+Values applied with the 'tag' and 'imply' subcommands are validated against the tag's type. A tag cannot be given a type that its existing values do not conform to.
+
+The type determines how values are compared in queries and the order in which they are listed: integers are compared numerically, dates chronologically and enumeration values by their position in the definition.
+
+When run without a TYPE lists the definitions of the specified TAGs or, without arguments, of every defined tag.`,
+	Examples: []string{"$ tmsu tag-def year integer",
+		"$ tmsu tag-def taken date",
+		"$ tmsu tag-def rating enum poor fair good excellent",
+		`$ tmsu tag-def
+rating: enum (poor, fair, good, excellent)
+taken: date
+year: integer`,
+		`$ tmsu files "rating >= good"`,
+		"$ tmsu tag-def --delete year"},
+	Options: Options{Option{"--delete", "-d", "removes the definition, allowing any value", false, ""}},
+	Exec:    tagDefExec,
+}
+
+// unexported
+
+func tagDefExec(options Options, args []string, databasePath string) (error, warnings) {
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	tx, err := store.Begin()
+	if err != nil {
+		return err, nil
+	}
+	defer tx.Commit()
+
+	if options.HasOption("--delete") {
+		if len(args) == 0 {
+			return fmt.Errorf("too few arguments"), nil
+		}
+
+		return deleteTagDefinitions(store, tx, args)
+	}
+
+	switch len(args) {
+	case 0:
+		return listAllTagDefinitions(store, tx), nil
+	case 1:
+		return listTagDefinitions(store, tx, args)
+	default:
+		if err := entities.ValidateTagType(args[1]); err != nil {
+			// not a type, so treat as a list of tags
+			return listTagDefinitions(store, tx, args)
+		}
+
+		return defineTag(store, tx, args[0], args[1], args[2:])
+	}
+}
+
+func listAllTagDefinitions(store *storage.Storage, tx *storage.Tx) error {
+	log.Debug("retrieving tag definitions")
+
+	definitions, err := store.TagDefinitions(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tag definitions: %v", err)
+	}
+
+	tags, err := store.Tags(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tags: %v", err)
+	}
+
+	for _, tag := range tags {
+		definition := definitions.ForTag(tag.Id)
+		if definition == nil {
+			continue
+		}
+
+		printTagDefinition(tag.Name, definition)
+	}
+
+	return nil
+}
+
+func listTagDefinitions(store *storage.Storage, tx *storage.Tx, tagArgs []string) (error, warnings) {
+	warnings := make(warnings, 0, 10)
+
+	for _, tagArg := range tagArgs {
+		tagName := parseTagOrValueName(tagArg)
+
+		tag, err := store.TagByName(tx, tagName)
+		if err != nil {
+			return fmt.Errorf("could not retrieve tag '%v': %v", tagName, err), warnings
+		}
+		if tag == nil {
+			warnings = append(warnings, noSuchTagWarning(NoSuchTagError{tagName, nil}))
+			continue
+		}
+
+		definition, err := store.TagDefinition(tx, tag.Id)
+		if err != nil {
+			return fmt.Errorf("could not retrieve definition for tag '%v': %v", tagName, err), warnings
+		}
+		if definition == nil {
+			definition = &entities.TagDefinition{tag.Id, entities.TextType, nil}
+		}
+
+		printTagDefinition(tag.Name, definition)
+	}
+
+	return nil, warnings
+}
+
+func defineTag(store *storage.Storage, tx *storage.Tx, tagArg, tagType string, valueArgs []string) (error, warnings) {
+	tagName := parseTagOrValueName(tagArg)
+
+	tag, err := store.TagByName(tx, tagName)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tag '%v': %v", tagName, err), nil
+	}
+	if tag == nil {
+		tag, err = createTag(store, tx, tagName)
+		if err != nil {
+			return fmt.Errorf("could not create tag '%v': %v", tagName, err), nil
+		}
+	}
+
+	valueNames := make([]string, len(valueArgs))
+	for index, valueArg := range valueArgs {
+		valueNames[index] = parseTagOrValueName(valueArg)
+	}
+
+	log.Debugf("defining tag '%v' as %v", tagName, tagType)
+
+	if _, err := store.DefineTag(tx, tag.Id, tagType, valueNames); err != nil {
+		return fmt.Errorf("could not define tag '%v': %v", tagName, err), nil
+	}
+
+	return nil, nil
+}
+
+func deleteTagDefinitions(store *storage.Storage, tx *storage.Tx, tagArgs []string) (error, warnings) {
+	warnings := make(warnings, 0, 10)
+
+	for _, tagArg := range tagArgs {
+		tagName := parseTagOrValueName(tagArg)
+
+		tag, err := store.TagByName(tx, tagName)
+		if err != nil {
+			return fmt.Errorf("could not retrieve tag '%v': %v", tagName, err), warnings
+		}
+		if tag == nil {
+			warnings = append(warnings, noSuchTagWarning(NoSuchTagError{tagName, nil}))
+			continue
+		}
+
+		if err := store.UndefineTag(tx, tag.Id); err != nil {
+			return fmt.Errorf("could not delete definition for tag '%v': %v", tagName, err), warnings
+		}
+	}
+
+	return nil, warnings
+}
+
+func printTagDefinition(tagName string, definition *entities.TagDefinition) {
+	tagName = escape(tagName, ':')
+
+	if definition.Type == entities.EnumType {
+		fmt.Printf("%v: %v (%v)\n", tagName, definition.Type, strings.Join(definition.Values, ", "))
+	} else {
+		fmt.Printf("%v: %v\n", tagName, definition.Type)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage"
+	"strings"
+	"time"
+)
+
+var TagInfoCommand = Command{
+	Name:     "tag-info",
+	Synopsis: "Describes tags",
+	Usages: []string{"tmsu tag-info [TAG]...",
+		"tmsu tag-info set TAG [--description=TEXT] [--colour=COLOUR]"},
+	Description: `Shows or sets the descriptive details of tags, so that those sharing a tagging vocabulary can see what each tag is for.
+
+Each tag may be given a description and a colour, and the time the tag was created is recorded. Tags created before this was recorded show no creation time.
+
+When run without 'set' shows the details of the specified TAGs or, without arguments, of every tag having a description or colour.
+
+COLOUR is one of: ` + strings.Join(entities.TagColours, ", ") + `. An empty TEXT or COLOUR clears the description or colour.
+
+The details are also listed by 'tags --long'.`,
+	Examples: []string{`$ tmsu tag-info set photo --description="Photographs taken by the team" --colour=blue`,
+		`$ tmsu tag-info photo
+photo: Photographs taken by the team
+  colour: blue
+  created: 2018-03-15 09:30`,
+		"$ tmsu tag-info set photo --colour="},
+	Options: Options{Option{"--description", "-d", "set the tag's description", true, ""},
+		Option{"--colour", "-c", "set the tag's colour", true, ""}},
+	Exec: tagInfoExec,
+}
+
+// unexported
+
+func tagInfoExec(options Options, args []string, databasePath string) (error, warnings) {
+	setting := len(args) > 0 && args[0] == "set"
+	hasDetails := options.HasOption("--description") || options.HasOption("--colour")
+	switch {
+	case setting && !hasDetails:
+		return fmt.Errorf("--description or --colour must be specified"), nil
+	case !setting && hasDetails:
+		return fmt.Errorf("--description and --colour can only be used with 'set'"), nil
+	}
+
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	tx, err := store.Begin()
+	if err != nil {
+		return err, nil
+	}
+	defer tx.Commit()
+
+	if setting {
+		if len(args) != 2 {
+			return fmt.Errorf("a single tag must be specified"), nil
+		}
+
+		return setTagInfo(store, tx, args[1], options)
+	}
+
+	if len(args) == 0 {
+		return listAllTagInfos(store, tx), nil
+	}
+
+	return listTagInfos(store, tx, args)
+}
+
+func listAllTagInfos(store *storage.Storage, tx *storage.Tx) error {
+	log.Debug("retrieving tag details")
+
+	infos, err := store.TagInfos(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tag details: %v", err)
+	}
+
+	tags, err := store.Tags(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tags: %v", err)
+	}
+
+	for _, tag := range tags {
+		info := infos.ForTag(tag.Id)
+		if info == nil || (info.Description == "" && info.Colour == "") {
+			continue
+		}
+
+		printTagInfo(tag.Name, info)
+	}
+
+	return nil
+}
+
+func listTagInfos(store *storage.Storage, tx *storage.Tx, tagArgs []string) (error, warnings) {
+	warnings := make(warnings, 0, 10)
+
+	for _, tagArg := range tagArgs {
+		tagName := parseTagOrValueName(tagArg)
+
+		tag, err := store.TagByName(tx, tagName)
+		if err != nil {
+			return fmt.Errorf("could not retrieve tag '%v': %v", tagName, err), warnings
+		}
+		if tag == nil {
+			warnings = append(warnings, noSuchTagWarning(NoSuchTagError{tagName, nil}))
+			continue
+		}
+
+		info, err := store.TagInfo(tx, tag.Id)
+		if err != nil {
+			return fmt.Errorf("could not retrieve details of tag '%v': %v", tagName, err), warnings
+		}
+		if info == nil {
+			info = &entities.TagInfo{TagId: tag.Id}
+		}
+
+		printTagInfo(tag.Name, info)
+	}
+
+	return nil, warnings
+}
+
+func setTagInfo(store *storage.Storage, tx *storage.Tx, tagArg string, options Options) (error, warnings) {
+	tagName := parseTagOrValueName(tagArg)
+
+	tag, err := store.TagByName(tx, tagName)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tag '%v': %v", tagName, err), nil
+	}
+	if tag == nil {
+		return NoSuchTagError{tagName, nil}, nil
+	}
+
+	info, err := store.TagInfo(tx, tag.Id)
+	if err != nil {
+		return fmt.Errorf("could not retrieve details of tag '%v': %v", tagName, err), nil
+	}
+	if info == nil {
+		info = &entities.TagInfo{TagId: tag.Id}
+	}
+
+	description := info.Description
+	if options.HasOption("--description") {
+		description = options.Get("--description").Argument
+	}
+
+	colour := info.Colour
+	if options.HasOption("--colour") {
+		colour = options.Get("--colour").Argument
+	}
+
+	log.Debugf("updating details of tag '%v'", tagName)
+
+	if err := store.UpdateTagInfo(tx, tag.Id, description, colour); err != nil {
+		return fmt.Errorf("could not update details of tag '%v': %v", tagName, err), nil
+	}
+
+	return nil, nil
+}
+
+func printTagInfo(tagName string, info *entities.TagInfo) {
+	tagName = escape(tagName, ':')
+
+	if info.Description == "" {
+		fmt.Println(tagName)
+	} else {
+		fmt.Printf("%v: %v\n", tagName, info.Description)
+	}
+
+	if info.Colour != "" {
+		fmt.Printf("  colour: %v\n", info.Colour)
+	}
+	if !info.Created.IsZero() {
+		fmt.Printf("  created: %v\n", formatTagCreated(info.Created))
+	}
+}
+
+func formatTagCreated(created time.Time) string {
+	return created.Local().Format("2006-01-02 15:04")
+}
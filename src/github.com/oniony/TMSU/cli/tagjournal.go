@@ -0,0 +1,157 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage"
+	"path/filepath"
+	"strings"
+)
+
+// unexported
+
+// Commits a recursive tagging in chunks of files, journalling the directories
+// it finishes so that, should it be interrupted, running the same tagging
+// again resumes where it left off rather than starting over.
+type tagChunker struct {
+	store     *storage.Storage
+	tx        *storage.Tx
+	size      uint
+	count     uint
+	operation string
+	finished  map[string]bool
+}
+
+// Loads the journal of an earlier, interrupted run of the tagging of the paths
+// with the tags, or discards it should the tagging be restarted. The tagging is
+// identified by its tags and absolute paths.
+func newTagChunker(store *storage.Storage, tx *storage.Tx, size uint, tagArgs, paths []string, explicit, restart bool) (*tagChunker, error) {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%v\x00%v\x00", strings.Join(tagArgs, "\x00"), explicit)
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("%v: could not get absolute path: %v", path, err)
+		}
+
+		fmt.Fprintf(hash, "%v\x00", absPath)
+	}
+	operation := hex.EncodeToString(hash.Sum(nil))
+
+	if restart {
+		if err := store.ClearTagJournal(tx, operation); err != nil {
+			return nil, fmt.Errorf("could not clear tag journal: %v", err)
+		}
+	}
+
+	directories, err := store.TagJournal(tx, operation)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve tag journal: %v", err)
+	}
+
+	finished := make(map[string]bool, len(directories))
+	for _, directory := range directories {
+		finished[directory] = true
+	}
+
+	if len(finished) > 0 {
+		log.Infof("resuming interrupted tagging: skipping %v finished directories", len(finished))
+	}
+
+	return &tagChunker{store, tx, size, 0, operation, finished}, nil
+}
+
+// Whether an earlier run finished tagging the directory and its contents. As
+// the journal is keyed only by the tagging, the tags may since have been
+// removed, deleted or renamed, so the directory must still have them applied.
+func (chunker *tagChunker) skip(absPath string, pairs []entities.TagIdValueIdPair) (bool, error) {
+	if chunker == nil || !chunker.finished[absPath] {
+		return false, nil
+	}
+
+	file, err := chunker.store.FileByPath(chunker.tx, absPath)
+	if err != nil {
+		return false, fmt.Errorf("%v: could not retrieve file: %v", absPath, err)
+	}
+	if file == nil {
+		return false, nil
+	}
+
+	for _, pair := range pairs {
+		exists, err := chunker.store.FileTagExists(chunker.tx, file.Id, pair.TagId, pair.ValueId, false)
+		if err != nil {
+			return false, fmt.Errorf("%v: could not check tags: %v", absPath, err)
+		}
+		if !exists {
+			log.Debugf("%v: no longer tagged: resuming its tagging", absPath)
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// Records that a file has been tagged, committing the chunk once it is full.
+func (chunker *tagChunker) tagged() error {
+	if chunker == nil {
+		return nil
+	}
+
+	chunker.count++
+	if chunker.count < chunker.size {
+		return nil
+	}
+
+	log.Debugf("committing chunk of %v files", chunker.count)
+
+	chunker.count = 0
+	if err := chunker.tx.Checkpoint(); err != nil {
+		return fmt.Errorf("could not commit chunk: %v", err)
+	}
+
+	return nil
+}
+
+// Records that the directory and its contents have been tagged.
+func (chunker *tagChunker) finish(directory string) error {
+	if chunker == nil {
+		return nil
+	}
+
+	if err := chunker.store.AddTagJournalEntry(chunker.tx, chunker.operation, directory); err != nil {
+		return fmt.Errorf("%v: could not update tag journal: %v", directory, err)
+	}
+
+	return nil
+}
+
+// Forgets the journal once the tagging has completed.
+func (chunker *tagChunker) complete() error {
+	if chunker == nil {
+		return nil
+	}
+
+	if err := chunker.store.ClearTagJournal(chunker.tx, chunker.operation); err != nil {
+		return fmt.Errorf("could not clear tag journal: %v", err)
+	}
+
+	return nil
+}
@@ -16,8 +16,10 @@
 package cli
 
 import (
+	"bufio"
 	"fmt"
 	"github.com/oniony/TMSU/common/log"
+	_path "github.com/oniony/TMSU/common/path"
 	"github.com/oniony/TMSU/common/terminal"
 	"github.com/oniony/TMSU/common/terminal/ansi"
 	"github.com/oniony/TMSU/entities"
@@ -36,21 +38,42 @@ var TagsCommand = Command{
 When color is turned on, tags are shown in the following colors:
 
   Normal  An explicitly applied (regular) tag
-  'Cyan'    Tag implied by other tags
+  'Cyan'    Tag implied by other tags or inherited from a directory
   'Yellow'  Tag is both explicitly applied and implied by other tags
 
-See the 'imply' subcommand for more information on implied tags.`,
+See the 'imply' subcommand for more information on implied tags and the 'tag' subcommand's --inherit option for inherited tags. A file need not have been tagged to inherit tags.
+
+With --long every tag is listed along with the time it was created, its colour and its description: see the 'tag-info' subcommand.
+
+When listing all of the tags, --count shows the number of files to which each tag is explicitly applied and --min-count omits those tags applied to fewer than N files. The tags are listed in the order specified by --sort:
+
+  name    by tag name (the default)
+  count   by the number of files tagged, most used first
+  recent  by when the tag was last applied, most recent first
+
+With --fuzzy the tags whose names resemble PATTERN are listed, closest first: those PATTERN is a prefix of and then those a few edits away, ignoring case. This is useful for finding a tag whose exact name has been forgotten.
+
+With --untagged the files and directories under PATH that have no tags are listed instead, as per the 'untagged' subcommand.`,
 	Examples: []string{"$ tmsu tags\nmp3  music  opera",
 		"$ tmsu tags tralala.mp3\nmp3  music  opera",
 		"$ tmsu tags tralala.mp3 boom.mp3\n./tralala.mp3: mp3 music opera\n./boom.mp3: mp3 music drum-n-bass",
 		"$ tmsu tags --count tralala.mp3",
-		"$ tmsu tags --value 2009 red"},
-	Options: Options{{"--count", "-c", "lists the number of tags rather than their names", false, ""},
+		"$ tmsu tags --count --sort=count --min-count=2\nmusic  12\nmp3     9\nopera   2",
+		"$ tmsu tags --untagged=/home/bob/music",
+		"$ tmsu tags --long\nmp3    2018-03-15 09:30        MPEG audio files\nmusic  2018-03-15 09:30  blue\nopera  2018-03-16 18:02",
+		"$ tmsu tags --value 2009 red",
+		"$ tmsu tags --fuzzy holdiay\nholiday\nholidays"},
+	Options: Options{{"--count", "-c", "lists the number of tags rather than their names (or, for all tags, the number of files per tag)", false, ""},
 		{"", "-1", "list one tag per line", false, ""},
 		{"--explicit", "-e", "do not show implied tags", false, ""},
+		{"--long", "-l", "list every tag with its creation time, colour and description", false, ""},
 		{"--name", "-n", "when to print the file/value name: auto, always, never", true, ""},
 		{"--no-dereference", "-P", "do not follow symlinks (show tags for symlink itself)", false, ""},
-		{"--value", "-u", "show tags which utilise values", false, ""}},
+		{"--value", "-u", "show tags which utilise values", false, ""},
+		{"--sort", "-s", "sort all tags: name, count, recent", true, ""},
+		{"--min-count", "", "list only tags applied to at least N files", true, ""},
+		{"--untagged", "", "list the files under PATH that have no tags", true, ""},
+		{"--fuzzy", "-z", "list the tags with names similar to PATTERN", true, ""}},
 	Exec: tagsExec,
 }
 
@@ -60,7 +83,6 @@ func tagsExec(options Options, args []string, databasePath string) (error, warni
 	showCount := options.HasOption("--count")
 	onePerLine := options.HasOption("-1")
 	explicitOnly := options.HasOption("--explicit")
-	followSymlinks := !options.HasOption("--no-dereference")
 	colour, err := useColour(options)
 	if err != nil {
 		return err, nil
@@ -71,6 +93,34 @@ func tagsExec(options Options, args []string, databasePath string) (error, warni
 		printName = options.Get("--name").Argument
 	}
 
+	sort := "name"
+	if options.HasOption("--sort") {
+		sort = options.Get("--sort").Argument
+
+		switch sort {
+		case "name", "count", "recent":
+			// valid
+		default:
+			return fmt.Errorf("invalid sort type '%v': must be one of name, count or recent", sort), nil
+		}
+	}
+
+	var minCount uint
+	if options.HasOption("--min-count") {
+		argument := options.Get("--min-count").Argument
+
+		count, err := strconv.ParseUint(argument, 10, 0)
+		if err != nil {
+			return fmt.Errorf("invalid minimum count '%v': must be a non-negative integer", argument), nil
+		}
+
+		minCount = uint(count)
+	}
+
+	if (options.HasOption("--sort") || options.HasOption("--min-count")) && len(args) > 0 {
+		return fmt.Errorf("--sort and --min-count cannot be used with files"), nil
+	}
+
 	store, err := openDatabase(databasePath)
 	if err != nil {
 		return err, nil
@@ -83,142 +133,352 @@ func tagsExec(options Options, args []string, databasePath string) (error, warni
 	}
 	defer tx.Commit()
 
+	if options.HasOption("--untagged") {
+		paths := append([]string{options.Get("--untagged").Argument}, args...)
+		return listUntaggedForTags(store, tx, options, paths, showCount), nil
+	}
+
+	if options.HasOption("--fuzzy") {
+		if len(args) > 0 {
+			return fmt.Errorf("--fuzzy cannot be used with files"), nil
+		}
+
+		return listFuzzyTags(store, tx, options.Get("--fuzzy").Argument, showCount), nil
+	}
+
 	if options.HasOption("--value") {
 		return listTagsForValues(store, tx, args, showCount, onePerLine, colour, printName)
 	}
 
+	if options.HasOption("--long") {
+		if len(args) > 0 {
+			return fmt.Errorf("--long cannot be used with files"), nil
+		}
+
+		return listAllTagsLong(store, tx, sort, minCount, colour), nil
+	}
+
 	if len(args) == 0 {
-		return listAllTags(store, tx, showCount, onePerLine), nil
+		return listAllTags(store, tx, showCount, onePerLine, colour, sort, minCount), nil
 	}
 
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve settings: %v", err), nil
+	}
+
+	followSymlinks := symlinkPolicyFor(options, settings).follow()
+
 	return listTagsForPaths(store, tx, args, showCount, onePerLine, explicitOnly, colour, followSymlinks, printName)
 }
 
-func listAllTags(store *storage.Storage, tx *storage.Tx, showCount, onePerLine bool) error {
-	log.Info(2, "retrieving all tags.")
+func listAllTags(store *storage.Storage, tx *storage.Tx, showCount, onePerLine, colour bool, sort string, minCount uint) error {
+	log.Debug("retrieving all tags.")
+
+	tagCounts, err := store.TagFileCounts(tx, sort, minCount)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tags: %v", err)
+	}
+
+	infos, err := tagInfosForColour(store, tx, colour)
+	if err != nil {
+		return err
+	}
+
+	tagNames := make([]string, len(tagCounts))
+	for index, tagCount := range tagCounts {
+		tagNames[index] = colourTagName(formatName(tagCount.Name), tagCount.Id, infos)
+	}
+
+	switch {
+	case showCount:
+		table := terminal.NewTable(false, true)
+		for index, tagCount := range tagCounts {
+			table.AddRow(tagNames[index], strconv.FormatUint(uint64(tagCount.FileCount), 10))
+		}
+		table.Print()
+	case onePerLine:
+		for _, tagName := range tagNames {
+			fmt.Println(tagName)
+		}
+	default:
+		terminal.PrintColumns(tagNames)
+	}
+
+	return nil
+}
+
+func listFuzzyTags(store *storage.Storage, tx *storage.Tx, pattern string, showCount bool) error {
+	log.Debugf("retrieving tags similar to '%v'.", pattern)
+
+	matches, err := store.FuzzyTagNames(tx, pattern)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tags: %v", err)
+	}
+
+	if showCount {
+		fmt.Println(len(matches))
+		return nil
+	}
+
+	for _, match := range matches {
+		fmt.Println(formatName(match.Name))
+	}
+
+	return nil
+}
+
+func listUntaggedForTags(store *storage.Storage, tx *storage.Tx, options Options, paths []string, showCount bool) error {
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve settings: %v", err)
+	}
+
+	followSymlinks := symlinkPolicyFor(options, settings).follow()
 
 	if showCount {
-		count, err := store.TagCount(tx)
+		count, err := findUntaggedCount(store, tx, paths, true, followSymlinks)
 		if err != nil {
-			return fmt.Errorf("could not retrieve tag count: %v", err)
+			return err
 		}
 
 		fmt.Println(count)
-	} else {
-		tags, err := store.Tags(tx)
-		if err != nil {
-			return fmt.Errorf("could not retrieve tags: %v", err)
+		return nil
+	}
+
+	return findUntagged(store, tx, paths, true, followSymlinks)
+}
+
+func listAllTagsLong(store *storage.Storage, tx *storage.Tx, sort string, minCount uint, colour bool) error {
+	log.Debug("retrieving all tags with their details.")
+
+	tags, err := store.TagFileCounts(tx, sort, minCount)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tags: %v", err)
+	}
+
+	infos, err := store.TagInfos(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tag details: %v", err)
+	}
+
+	table := terminal.NewTable()
+	for _, tag := range tags {
+		info := infos.ForTag(tag.Id)
+		if info == nil {
+			info = &entities.TagInfo{TagId: tag.Id}
 		}
 
-		if onePerLine {
-			for _, tag := range tags {
-				fmt.Println(escape(tag.Name, '=', ' '))
-			}
-		} else {
-			tagNames := make([]string, len(tags))
-			for index, tag := range tags {
-				tagNames[index] = escape(tag.Name, '=', ' ')
-			}
+		tagName := formatName(tag.Name)
+		if colour {
+			tagName = colourTagName(tagName, tag.Id, infos)
+		}
 
-			terminal.PrintColumns(tagNames)
+		created := ""
+		if !info.Created.IsZero() {
+			created = formatTagCreated(info.Created)
 		}
+
+		table.AddRow(tagName, fmt.Sprintf("%-16v", created), info.Colour, info.Description)
 	}
+	table.Print()
 
 	return nil
 }
 
+// The number of paths whose tags are looked up together.
+const tagsBatchSize = 1000
+
 func listTagsForPaths(store *storage.Storage, tx *storage.Tx, paths []string, showCount, onePerLine, explicitOnly, colour, followSymlinks bool, printPathWhen string) (error, warnings) {
 	warnings := make(warnings, 0, 10)
 
 	printPath := printPathWhen != "never" && (printPathWhen == "always" || len(paths) > 1 || !stdoutIsCharDevice())
 
-	for index, path := range paths {
-		absPath, err := filepath.Abs(path)
+	infos, err := tagInfosForColour(store, tx, colour)
+	if err != nil {
+		return err, warnings
+	}
+
+	namer := newTagNamer(store, tx, colour, infos)
+	inheritedTagNames := make(map[string][]string)
+
+	output := bufio.NewWriter(os.Stdout)
+	defer output.Flush()
+
+	for start := 0; start < len(paths); start += tagsBatchSize {
+		end := start + tagsBatchSize
+		if end > len(paths) {
+			end = len(paths)
+		}
+
+		absPaths, batchWarnings, err := resolveTagsPaths(paths[start:end], followSymlinks)
+		warnings = append(warnings, batchWarnings...)
 		if err != nil {
 			return err, warnings
 		}
 
-		log.Infof(2, "%v: resolving path", absPath)
+		log.Debugf("retrieving tags for %v paths", len(absPaths))
 
-		if followSymlinks {
-			absPath, err = filepath.EvalSymlinks(absPath)
-			if err != nil {
-				switch {
-				case os.IsNotExist(err), os.IsPermission(err):
-					// ignore
-				default:
-					warnings = append(warnings, err.Error())
-					continue
-				}
-			}
+		files, err := store.FilesByPaths(tx, nonEmpty(absPaths))
+		if err != nil {
+			return fmt.Errorf("could not retrieve files: %v", err), warnings
 		}
 
-		log.Infof(2, "%v: retrieving tags", absPath)
+		filesByPath := make(map[string]*entities.File, len(files))
+		for _, file := range files {
+			filesByPath[_path.Key(file.Path())] = file
+		}
 
-		file, err := store.FileByPath(tx, absPath)
+		fileTagsByFileId, err := store.FileTagsByFileIds(tx, files, explicitOnly)
 		if err != nil {
-			warnings = append(warnings, err.Error())
-			continue
+			return fmt.Errorf("could not retrieve file-tags: %v", err), warnings
 		}
 
-		var tagNames []string
-		if file != nil {
-			tagNames, err = tagNamesForFile(store, tx, file.Id, explicitOnly, colour)
-			if err != nil {
-				return err, warnings
-			}
-		} else {
-			_, err := os.Stat(absPath)
-			if err != nil {
-				switch {
-				case os.IsPermission(err):
-					warnings = append(warnings, fmt.Sprintf("%v: permission denied", absPath))
-					continue
-				case os.IsNotExist(err):
-					warnings = append(warnings, fmt.Sprintf("%v: no such file", absPath))
-					continue
-				default:
-					return fmt.Errorf("%v: could not stat file: %v", absPath, err), warnings
-				}
-			}
+		batchFileTags := make(entities.FileTags, 0, len(files)*5)
+		for _, fileTags := range fileTagsByFileId {
+			batchFileTags = append(batchFileTags, fileTags...)
 		}
 
-		escapedPath := escape(path, '\\', ':')
-		switch {
-		case showCount:
-			if printPath {
-				fmt.Print(escapedPath + ": ")
-			}
+		if err := namer.load(batchFileTags); err != nil {
+			return err, warnings
+		}
 
-			fmt.Println(strconv.Itoa(len(tagNames)))
-		case onePerLine:
-			if index > 0 {
-				fmt.Println()
+		for offset, absPath := range absPaths {
+			if absPath == "" {
+				continue
 			}
 
-			if printPath {
-				fmt.Println(escapedPath + ":")
-			}
+			var tagNames []string
+			if file := filesByPath[_path.Key(absPath)]; file != nil {
+				tagNames, err = namer.names(fileTagsByFileId[file.Id])
+				if err != nil {
+					return err, warnings
+				}
+			} else {
+				_, err := os.Stat(absPath)
+				if err != nil {
+					switch {
+					case os.IsPermission(err):
+						warnings = append(warnings, fmt.Sprintf("%v: permission denied", absPath))
+						continue
+					case os.IsNotExist(err):
+						warnings = append(warnings, fmt.Sprintf("%v: no such file", absPath))
+						continue
+					default:
+						return fmt.Errorf("%v: could not stat file: %v", absPath, err), warnings
+					}
+				}
 
-			for _, tagName := range tagNames {
-				fmt.Println(tagName)
+				if !explicitOnly {
+					directory := filepath.Dir(absPath)
+
+					var ok bool
+					if tagNames, ok = inheritedTagNames[directory]; !ok {
+						fileTags, err := store.InheritedFileTags(tx, absPath)
+						if err != nil {
+							return fmt.Errorf("%v: could not retrieve inherited tags: %v", absPath, err), warnings
+						}
+
+						tagNames, err = namer.names(fileTags)
+						if err != nil {
+							return err, warnings
+						}
+
+						inheritedTagNames[directory] = tagNames
+					}
+				}
 			}
-		default:
-			if printPath {
-				fmt.Print(escapedPath + ":")
+
+			index := start + offset
+			escapedPath := escape(paths[index], '\\', ':')
+			switch {
+			case showCount:
+				if printPath {
+					fmt.Fprint(output, escapedPath+": ")
+				}
+
+				fmt.Fprintln(output, strconv.Itoa(len(tagNames)))
+			case onePerLine:
+				if index > 0 {
+					fmt.Fprintln(output)
+				}
+
+				if printPath {
+					fmt.Fprintln(output, escapedPath+":")
+				}
 
 				for _, tagName := range tagNames {
-					fmt.Print(" " + tagName)
+					fmt.Fprintln(output, tagName)
 				}
+			default:
+				if printPath {
+					fmt.Fprint(output, escapedPath+":")
+
+					for _, tagName := range tagNames {
+						fmt.Fprint(output, " "+tagName)
+					}
+
+					fmt.Fprintln(output)
+				} else {
+					output.Flush()
+					terminal.PrintColumns(tagNames)
+				}
+			}
+		}
 
-				fmt.Println()
+		// stream each batch's output rather than holding it until the end
+		if err := output.Flush(); err != nil {
+			return err, warnings
+		}
+	}
+
+	return nil, warnings
+}
+
+// Resolves the absolute paths of the files whose tags are to be listed. The
+// path of a file that cannot be resolved is left empty and a warning given.
+func resolveTagsPaths(paths []string, followSymlinks bool) ([]string, warnings, error) {
+	warnings := make(warnings, 0, 10)
+	absPaths := make([]string, len(paths))
+
+	for index, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, warnings, err
+		}
+
+		log.Debugf("%v: resolving path", absPath)
+
+		if followSymlinks {
+			resolvedPath, err := filepath.EvalSymlinks(absPath)
+			if err != nil {
+				switch {
+				case os.IsNotExist(err), os.IsPermission(err):
+					// ignore
+				default:
+					warnings = append(warnings, err.Error())
+					continue
+				}
 			} else {
-				terminal.PrintColumns(tagNames)
+				absPath = resolvedPath
 			}
 		}
+
+		absPaths[index] = absPath
+	}
+
+	return absPaths, warnings, nil
+}
+
+func nonEmpty(texts []string) []string {
+	nonEmpty := make([]string, 0, len(texts))
+	for _, text := range texts {
+		if text != "" {
+			nonEmpty = append(nonEmpty, text)
+		}
 	}
 
-	return nil, warnings
+	return nonEmpty
 }
 
 func listTagsForValues(store *storage.Storage, tx *storage.Tx, valueNames []string, showCount, onePerLine, colour bool, printTagWhen string) (error, warnings) {
@@ -227,18 +487,18 @@ func listTagsForValues(store *storage.Storage, tx *storage.Tx, valueNames []stri
 	printTag := printTagWhen != "never" && (printTagWhen == "always" || len(valueNames) > 1 || !stdoutIsCharDevice())
 
 	for index, valueName := range valueNames {
-		log.Infof(2, "%v: looking up value", valueName)
+		log.Debugf("%v: looking up value", valueName)
 
 		value, err := store.ValueByName(tx, valueName)
 		if err != nil {
 			return err, warnings
 		}
 		if value == nil {
-			warnings = append(warnings, fmt.Sprintf("no such value '%v'", valueName))
+			warnings = append(warnings, noSuchTagWarning(NoSuchValueError{valueName}))
 			continue
 		}
 
-		log.Infof(2, "%v: retrieving tags", valueName)
+		log.Debugf("%v: retrieving tags", valueName)
 
 		var tagNames []string
 		if value != nil {
@@ -288,39 +548,60 @@ func listTagsForValues(store *storage.Storage, tx *storage.Tx, valueNames []stri
 	return nil, warnings
 }
 
-func tagNamesForFile(store *storage.Storage, tx *storage.Tx, fileId entities.FileId, explicitOnly, colour bool) ([]string, error) {
+func tagNamesForFile(store *storage.Storage, tx *storage.Tx, fileId entities.FileId, explicitOnly, colour bool, infos entities.TagInfos) ([]string, error) {
 	fileTags, err := store.FileTagsByFileId(tx, fileId, explicitOnly)
 	if err != nil {
 		return nil, fmt.Errorf("could not retrieve file-tags for file '%v': %v", fileId, err)
 	}
 
+	return tagNamesForFileTags(store, tx, fileTags, colour, infos)
+}
+
+// Formats the tags of a file, coloured where colour is set to show whether they
+// are implied and otherwise with the colours in infos.
+func tagNamesForFileTags(store *storage.Storage, tx *storage.Tx, fileTags entities.FileTags, colour bool, infos entities.TagInfos) ([]string, error) {
+	return newTagNamer(store, tx, colour, infos).names(fileTags)
+}
+
+// Formats the tags of many files, looking up each tag and value once.
+type tagNamer struct {
+	store  *storage.Storage
+	tx     *storage.Tx
+	colour bool
+	infos  entities.TagInfos
+	tags   map[entities.TagId]*entities.Tag
+	values map[entities.ValueId]*entities.Value
+}
+
+func newTagNamer(store *storage.Storage, tx *storage.Tx, colour bool, infos entities.TagInfos) *tagNamer {
+	return &tagNamer{store, tx, colour, infos, make(map[entities.TagId]*entities.Tag), make(map[entities.ValueId]*entities.Value)}
+}
+
+// The formatted names of the file tags.
+func (namer *tagNamer) names(fileTags entities.FileTags) ([]string, error) {
+	if err := namer.load(fileTags); err != nil {
+		return nil, err
+	}
+
 	taggings := make([]string, len(fileTags))
 
 	for index, fileTag := range fileTags {
-		tag, err := store.Tag(tx, fileTag.TagId)
-		if err != nil {
-			return nil, fmt.Errorf("could not lookup tag: %v", err)
-		}
+		tag := namer.tags[fileTag.TagId]
 		if tag == nil {
 			return nil, fmt.Errorf("tag '%v' does not exist", fileTag.TagId)
 		}
 
-		var tagging string
-		if fileTag.ValueId == 0 {
-			tagging = formatTagValueName(tag.Name, "", colour, fileTag.Implicit, fileTag.Explicit)
-		} else {
-			value, err := store.Value(tx, fileTag.ValueId)
-			if err != nil {
-				return nil, fmt.Errorf("could not lookup value: %v", err)
-			}
+		valueName := ""
+		if fileTag.ValueId != 0 {
+			value := namer.values[fileTag.ValueId]
 			if value == nil {
 				return nil, fmt.Errorf("value '%v' does not exist", fileTag.ValueId)
 			}
 
-			tagging = formatTagValueName(tag.Name, value.Name, colour, fileTag.Implicit, fileTag.Explicit)
+			valueName = value.Name
 		}
 
-		taggings[index] = tagging
+		taggings[index] = formatTagValueName(tag.Name, valueName, tagColour(tag.Id, namer.infos), namer.colour, fileTag.Implicit, fileTag.Explicit)
 	}
 
 	ansi.Sort(taggings)
@@ -328,6 +609,57 @@ func tagNamesForFile(store *storage.Storage, tx *storage.Tx, fileId entities.Fil
 	return taggings, nil
 }
 
+// Looks up the tags and values of the file tags not already looked up.
+func (namer *tagNamer) load(fileTags entities.FileTags) error {
+	tagIds := make(entities.TagIds, 0, 10)
+	valueIds := make(entities.ValueIds, 0, 10)
+
+	for _, fileTag := range fileTags {
+		if _, ok := namer.tags[fileTag.TagId]; !ok {
+			namer.tags[fileTag.TagId] = nil
+			tagIds = append(tagIds, fileTag.TagId)
+		}
+		if _, ok := namer.values[fileTag.ValueId]; !ok && fileTag.ValueId != 0 {
+			namer.values[fileTag.ValueId] = nil
+			valueIds = append(valueIds, fileTag.ValueId)
+		}
+	}
+
+	for start := 0; start < len(tagIds); start += tagsBatchSize {
+		end := start + tagsBatchSize
+		if end > len(tagIds) {
+			end = len(tagIds)
+		}
+
+		tags, err := namer.store.TagsByIds(namer.tx, tagIds[start:end])
+		if err != nil {
+			return fmt.Errorf("could not lookup tags: %v", err)
+		}
+
+		for _, tag := range tags {
+			namer.tags[tag.Id] = tag
+		}
+	}
+
+	for start := 0; start < len(valueIds); start += tagsBatchSize {
+		end := start + tagsBatchSize
+		if end > len(valueIds) {
+			end = len(valueIds)
+		}
+
+		values, err := namer.store.ValuesByIds(namer.tx, valueIds[start:end])
+		if err != nil {
+			return fmt.Errorf("could not lookup values: %v", err)
+		}
+
+		for _, value := range values {
+			namer.values[value.Id] = value
+		}
+	}
+
+	return nil
+}
+
 func tagNamesForValue(store *storage.Storage, tx *storage.Tx, valueId entities.ValueId) ([]string, error) {
 	fileTags, err := store.FileTagsByValueId(tx, valueId)
 	if err != nil {
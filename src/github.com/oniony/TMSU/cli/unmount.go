@@ -51,21 +51,21 @@ func unmountExec(options Options, args []string, databasePath string) (error, wa
 }
 
 func unmount(path string) error {
-	log.Info(2, "searching path for fusermount.")
+	log.Debug("searching path for fusermount.")
 
 	fusermountPath, err := exec.LookPath("fusermount")
 	if err != nil {
 		return fmt.Errorf("could not find 'fusermount': ensure fuse is installed: %v", err)
 	}
 
-	log.Infof(2, "running: %v -u %v.", fusermountPath, path)
+	log.Debugf("running: %v -u %v.", fusermountPath, path)
 
 	process, err := os.StartProcess(fusermountPath, []string{fusermountPath, "-u", path}, &os.ProcAttr{})
 	if err != nil {
 		return fmt.Errorf("could not start 'fusermount': %v", err)
 	}
 
-	log.Info(2, "waiting for process to exit.")
+	log.Debug("waiting for process to exit.")
 
 	processState, err := process.Wait()
 	if err != nil {
@@ -79,7 +79,7 @@ func unmount(path string) error {
 }
 
 func unmountAll() error {
-	log.Info(2, "retrieving mount table.")
+	log.Debug("retrieving mount table.")
 
 	mt, err := vfs.GetMountTable()
 	if err != nil {
@@ -87,14 +87,22 @@ func unmountAll() error {
 	}
 
 	if len(mt) == 0 {
-		log.Info(2, "mount table is empty.")
+		log.Debug("mount table is empty.")
 	}
 
+	unmounted := make(map[string]bool, len(mt))
 	for _, mount := range mt {
+		// several databases may be mounted together at the same path
+		if unmounted[mount.MountPath] {
+			continue
+		}
+
 		err = unmount(mount.MountPath)
 		if err != nil {
 			return err
 		}
+
+		unmounted[mount.MountPath] = true
 	}
 
 	return nil
@@ -30,15 +30,25 @@ var UntagCommand = Command{
 	Synopsis: "Remove tags from files",
 	Usages: []string{"tmsu untag [OPTION]... FILE TAG[=VALUE]...",
 		"tmsu untag [OPTION]... --all FILE...",
-		`tmsu untag [OPTION]... --tags="TAG[=VALUE]..." FILE...`},
-	Description: "Disassociates FILE with the TAGs specified.",
+		`tmsu untag [OPTION]... --tags="TAG[=VALUE]..." FILE...`,
+		"tmsu untag [OPTION]... --fingerprint=FINGERPRINT TAG[=VALUE]..."},
+	Description: `Disassociates FILE with the TAGs specified.
+
+With --recursive the tags are also removed from everything beneath a directory, whether or not the directory itself is tagged.
+
+Files are removed from the database when their last tag is removed. The --prune option additionally removes any of the files that were already without tags, for example those left behind by an interrupted tagging operation.
+
+With --fingerprint the TAGs are removed from those applied to FINGERPRINT by 'tag --fingerprint' that are yet to be applied to a file.`,
 	Examples: []string{"$ tmsu untag mountain.jpg hill county=germany",
 		"$ tmsu untag --all mountain-copy.jpg",
+		"$ tmsu untag --recursive --prune photos/ holiday",
 		`$ tmsu untag --tags="river underwater year=2017" forest.jpg desert.jpg`},
 	Options: Options{{"--all", "-a", "strip each file of all tags", false, ""},
 		{"--tags", "-t", "the set of tags to remove", true, ""},
 		{"--recursive", "-r", "recursively remove tags from directory contents", false, ""},
-		{"--no-dereference", "-P", "do not follow symbolic links (untag the link itself)", false, ""}},
+		{"--prune", "", "remove files left without tags from the database", false, ""},
+		{"--no-dereference", "-P", "do not follow symbolic links (untag the link itself)", false, ""},
+		{"--fingerprint", "", "remove tags applied to FINGERPRINT", true, ""}},
 	Exec: untagExec,
 }
 
@@ -50,7 +60,7 @@ func untagExec(options Options, args []string, databasePath string) (error, warn
 	}
 
 	recursive := options.HasOption("--recursive")
-	followSymlinks := !options.HasOption("--no-dereference")
+	prune := options.HasOption("--prune")
 
 	store, err := openDatabase(databasePath)
 	if err != nil {
@@ -64,16 +74,35 @@ func untagExec(options Options, args []string, databasePath string) (error, warn
 	}
 	defer tx.Commit()
 
-	if options.HasOption("--all") {
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve settings: %v", err), nil
+	}
+
+	symlinks := symlinkPolicyFor(options, settings)
+
+	if options.HasOption("--fingerprint") {
+		tagArgs := args
+
+		if err := fireHooks(store, tx, hookEvent{"untag", nil, tagArgs, nil}); err != nil {
+			return err, nil
+		}
+
+		return untagFingerprint(store, tx, settings, options.Get("--fingerprint").Argument, tagArgs)
+	} else if options.HasOption("--all") {
 		if len(args) < 1 {
 			return fmt.Errorf("files to untag must be specified"), nil
 		}
 
 		paths := args
 
-		return untagPathsAll(store, tx, paths, recursive, followSymlinks)
+		if err := fireHooks(store, tx, hookEvent{"untag", paths, nil, nil}); err != nil {
+			return err, nil
+		}
+
+		return untagPathsAll(store, tx, paths, recursive, prune, symlinks)
 	} else if options.HasOption("--tags") {
-		tagArgs := text.Tokenize(options.Get("--tags").Argument)
+		tagArgs := text.TokenizeQuoted(options.Get("--tags").Argument)
 		if len(tagArgs) == 0 {
 			return fmt.Errorf("set of tags to apply must be specified"), nil
 		}
@@ -83,7 +112,11 @@ func untagExec(options Options, args []string, databasePath string) (error, warn
 			return fmt.Errorf("at least one file to untag must be specified"), nil
 		}
 
-		return untagPaths(store, tx, paths, tagArgs, recursive, followSymlinks)
+		if err := fireHooks(store, tx, hookEvent{"untag", paths, tagArgs, nil}); err != nil {
+			return err, nil
+		}
+
+		return untagPaths(store, tx, paths, tagArgs, recursive, prune, symlinks)
 	} else {
 		if len(args) < 2 {
 			return fmt.Errorf("tags to remove and files to untag must be specified"), nil
@@ -92,108 +125,82 @@ func untagExec(options Options, args []string, databasePath string) (error, warn
 		paths := args[0:1]
 		tagArgs := args[1:]
 
-		return untagPaths(store, tx, paths, tagArgs, recursive, followSymlinks)
-	}
-}
-
-func untagPathsAll(store *storage.Storage, tx *storage.Tx, paths []string, recursive, followSymlinks bool) (error, warnings) {
-	warnings := make(warnings, 0, 10)
-
-	for _, path := range paths {
-		absPath, err := filepath.Abs(path)
-		if err != nil {
-			return fmt.Errorf("%v: could not get absolute path: %v", path, err), warnings
+		if err := fireHooks(store, tx, hookEvent{"untag", paths, tagArgs, nil}); err != nil {
+			return err, nil
 		}
 
-		log.Infof(2, "%v: resolving path", path)
-
-		if followSymlinks {
-			absPath, err = filepath.EvalSymlinks(absPath)
-			if err != nil {
-				switch {
-				case os.IsNotExist(err), os.IsPermission(err):
-					// ignore
-				default:
-					return err, nil
-				}
-			}
-		}
+		return untagPaths(store, tx, paths, tagArgs, recursive, prune, symlinks)
+	}
+}
 
-		file, err := store.FileByPath(tx, absPath)
-		if err != nil {
-			return fmt.Errorf("%v: could not retrieve file: %v", path, err), warnings
-		}
-		if file == nil {
-			warnings = append(warnings, fmt.Sprintf("%v: file is not tagged.", path))
-			continue
-		}
+func untagPathsAll(store *storage.Storage, tx *storage.Tx, paths []string, recursive, prune bool, symlinks symlinkPolicy) (error, warnings) {
+	files, err, warnings := filesToUntag(store, tx, paths, recursive, symlinks)
+	if err != nil {
+		return err, warnings
+	}
 
-		log.Infof(2, "%v: removing all tags.", path)
+	for _, file := range files {
+		log.Debugf("%v: removing all tags.", file.Path())
 
 		if err := store.DeleteFileTagsByFileId(tx, file.Id); err != nil {
-			return fmt.Errorf("%v: could not remove file's tags: %v", path, err), warnings
+			return fmt.Errorf("%v: could not remove file's tags: %v", file.Path(), err), warnings
 		}
+	}
 
-		if recursive {
-			childFiles, err := store.FilesByDirectory(tx, file.Path())
-			if err != nil {
-				return fmt.Errorf("%v: could not retrieve files for directory: %v", path, err), warnings
-			}
-
-			for _, childFile := range childFiles {
-				if err := store.DeleteFileTagsByFileId(tx, childFile.Id); err != nil {
-					return fmt.Errorf("%v: could not remove file's tags: %v", childFile.Path(), err), warnings
-				}
-			}
+	if prune {
+		if err := pruneFiles(store, tx, files); err != nil {
+			return err, warnings
 		}
 	}
 
 	return nil, warnings
 }
 
-func untagPaths(store *storage.Storage, tx *storage.Tx, paths, tagArgs []string, recursive, followSymlinks bool) (error, warnings) {
+func untagFingerprint(store *storage.Storage, tx *storage.Tx, settings entities.Settings, fingerprintArg string, tagArgs []string) (error, warnings) {
 	warnings := make(warnings, 0, 10)
 
-	files := make(entities.Files, 0, len(paths))
-	for _, path := range paths {
-		absPath, err := filepath.Abs(path)
-		if err != nil {
-			return fmt.Errorf("%v: could not get absolute path: %v", path, err), warnings
-		}
+	_, fp, err := parseFingerprint(fingerprintArg, settings)
+	if err != nil {
+		return err, warnings
+	}
 
-		log.Infof(2, "%v: resolving path", path)
+	for _, tagArg := range tagArgs {
+		tagName, valueName := parseTagEqValueName(tagArg)
 
-		if followSymlinks {
-			absPath, err = filepath.EvalSymlinks(absPath)
-			if err != nil {
-				switch {
-				case os.IsNotExist(err), os.IsPermission(err):
-					// ignore
-				default:
-					return err, nil
-				}
-			}
+		tag, err := store.TagByName(tx, tagName)
+		if err != nil {
+			return fmt.Errorf("could not retrieve tag '%v': %v", tagName, err), warnings
+		}
+		if tag == nil {
+			warnings = append(warnings, noSuchTagWarning(noSuchTagError(store, tx, tagName)))
+			continue
 		}
 
-		file, err := store.FileByPath(tx, absPath)
+		value, err := store.ValueByName(tx, valueName)
 		if err != nil {
-			return fmt.Errorf("%v: could not retrieve file: %v", path, err), warnings
+			return fmt.Errorf("could not retrieve value '%v': %v", valueName, err), warnings
 		}
-		if file == nil {
-			warnings = append(warnings, fmt.Sprintf("%v: file is not tagged", path))
+		if value == nil {
+			warnings = append(warnings, noSuchTagWarning(NoSuchValueError{valueName}))
 			continue
 		}
 
-		files = append(files, file)
+		deleted, err := store.DeleteFingerprintTag(tx, fp, tag.Id, value.Id)
+		if err != nil {
+			return fmt.Errorf("%v: could not remove tag '%v', value '%v': %v", fp, tag.Name, value.Name, err), warnings
+		}
+		if !deleted {
+			warnings = append(warnings, fmt.Sprintf("%v: fingerprint is not tagged '%v'.", fp, tagArg))
+		}
+	}
 
-		if recursive {
-			childFiles, err := store.FilesByDirectory(tx, file.Path())
-			if err != nil {
-				return fmt.Errorf("%v: could not retrieve files for directory: %v", file.Path(), err), warnings
-			}
+	return nil, warnings
+}
 
-			files = append(files, childFiles...)
-		}
+func untagPaths(store *storage.Storage, tx *storage.Tx, paths, tagArgs []string, recursive, prune bool, symlinks symlinkPolicy) (error, warnings) {
+	files, err, warnings := filesToUntag(store, tx, paths, recursive, symlinks)
+	if err != nil {
+		return err, warnings
 	}
 
 	for _, tagArg := range tagArgs {
@@ -204,7 +211,7 @@ func untagPaths(store *storage.Storage, tx *storage.Tx, paths, tagArgs []string,
 			return fmt.Errorf("could not retrieve tag '%v': %v", tagName, err), warnings
 		}
 		if tag == nil {
-			warnings = append(warnings, fmt.Sprintf("no such tag '%v'", tagName))
+			warnings = append(warnings, noSuchTagWarning(noSuchTagError(store, tx, tagName)))
 			continue
 		}
 
@@ -213,7 +220,7 @@ func untagPaths(store *storage.Storage, tx *storage.Tx, paths, tagArgs []string,
 			return fmt.Errorf("could not retrieve value '%v': %v", valueName, err), warnings
 		}
 		if value == nil {
-			warnings = append(warnings, fmt.Sprintf("no such value '%v'", valueName))
+			warnings = append(warnings, noSuchTagWarning(NoSuchValueError{valueName}))
 			continue
 		}
 
@@ -246,5 +253,90 @@ func untagPaths(store *storage.Storage, tx *storage.Tx, paths, tagArgs []string,
 		}
 	}
 
+	if prune {
+		if err := pruneFiles(store, tx, files); err != nil {
+			return err, warnings
+		}
+	}
+
 	return nil, warnings
 }
+
+// Resolves the paths to the files from which tags are to be removed, which
+// includes the contents of directories if recursive. Under the 'both' policy a
+// symbolic link and its target are both untagged.
+func filesToUntag(store *storage.Storage, tx *storage.Tx, paths []string, recursive bool, symlinks symlinkPolicy) (entities.Files, error, warnings) {
+	warnings := make(warnings, 0, 10)
+
+	files := make(entities.Files, 0, len(paths))
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("%v: could not get absolute path: %v", path, err), warnings
+		}
+
+		log.Debugf("%v: resolving path", path)
+
+		absPaths := []string{absPath}
+		if symlinks.follow() {
+			resolvedPath, err := filepath.EvalSymlinks(absPath)
+			if err != nil {
+				switch {
+				case os.IsNotExist(err), os.IsPermission(err):
+					// ignore
+				default:
+					return nil, err, warnings
+				}
+			} else if symlinks == symlinkBoth && resolvedPath != absPath {
+				absPaths = append(absPaths, resolvedPath)
+			} else {
+				absPaths[0] = resolvedPath
+			}
+		}
+
+		found := false
+		for _, absPath := range absPaths {
+			file, err := store.FileByPath(tx, absPath)
+			if err != nil {
+				return nil, fmt.Errorf("%v: could not retrieve file: %v", path, err), warnings
+			}
+			if file != nil {
+				files = append(files, file)
+				found = true
+			}
+
+			if recursive {
+				// the directory itself need not be tagged for its contents to be
+				childFiles, err := store.FilesByDirectory(tx, absPath)
+				if err != nil {
+					return nil, fmt.Errorf("%v: could not retrieve files for directory: %v", path, err), warnings
+				}
+
+				files = append(files, childFiles...)
+				found = found || len(childFiles) > 0
+			}
+		}
+
+		if !found {
+			warnings = append(warnings, fmt.Sprintf("%v: file is not tagged", path))
+		}
+	}
+
+	return files, nil, warnings
+}
+
+// Removes those files that are left without tags from the database.
+func pruneFiles(store *storage.Storage, tx *storage.Tx, files entities.Files) error {
+	fileIds := make(entities.FileIds, len(files))
+	for index, file := range files {
+		fileIds[index] = file.Id
+	}
+
+	log.Debugf("pruning untagged files")
+
+	if err := store.DeleteUntaggedFiles(tx, fileIds); err != nil {
+		return fmt.Errorf("could not remove untagged files: %v", err)
+	}
+
+	return nil
+}
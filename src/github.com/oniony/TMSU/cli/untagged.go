@@ -28,12 +28,16 @@ var UntaggedCommand = Command{
 	Name:     "untagged",
 	Synopsis: "List untagged files",
 	Usages:   []string{"tmsu untagged [OPTION]... [PATH]..."},
-	Description: `Identify untagged files in the filesystem.  
+	Description: `Identify untagged files in the filesystem: those that are not in the database and those that are but have no tags applied.
 
-Where PATHs are not specified, untagged items under the current working directory are shown.`,
+Where PATHs are not specified, untagged items under the current working directory are shown. Directory contents are examined recursively unless --directory is specified.
+
+The database is consulted once per directory, rather than once per file, so that large directory trees can be examined quickly.`,
 	Examples: []string{"$ tmsu untagged",
-		"$ tmsu untagged /home/fred/drawings"},
+		"$ tmsu untagged /home/fred/drawings",
+		"$ tmsu untagged --count ~/Music"},
 	Options: Options{Option{"--directory", "-d", "do not examine directory contents (non-recursive)", false, ""},
+		Option{"--recursive", "-r", "examine directory contents (the default)", false, ""},
 		Option{"--count", "-c", "list the number of files rather than their names", false, ""},
 		Option{"--no-dereference", "-P", "do not dereference symbolic links", false, ""}},
 	Exec: untaggedExec,
@@ -42,9 +46,12 @@ Where PATHs are not specified, untagged items under the current working director
 // unexported
 
 func untaggedExec(options Options, args []string, databasePath string) (error, warnings) {
+	if options.HasOption("--directory") && options.HasOption("--recursive") {
+		return fmt.Errorf("--directory cannot be used with --recursive"), nil
+	}
+
 	recursive := !options.HasOption("--directory")
 	count := options.HasOption("--count")
-	followSymlinks := !options.HasOption("--no-dereference")
 
 	paths := args
 	if len(paths) == 0 {
@@ -67,6 +74,13 @@ func untaggedExec(options Options, args []string, databasePath string) (error, w
 	}
 	defer tx.Commit()
 
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve settings: %v", err), nil
+	}
+
+	followSymlinks := symlinkPolicyFor(options, settings).follow()
+
 	if count {
 		count, err := findUntaggedCount(store, tx, paths, recursive, followSymlinks)
 		if err != nil {
@@ -89,7 +103,7 @@ func findUntagged(store *storage.Storage, tx *storage.Tx, paths []string, recurs
 		fmt.Println(relPath)
 	}
 
-	return findUntaggedFunc(store, tx, paths, recursive, followSymlinks, action)
+	return findUntaggedFunc(store, tx, paths, recursive, followSymlinks, make(directoryGuard), action)
 }
 
 func findUntaggedCount(store *storage.Storage, tx *storage.Tx, paths []string, recursive, followSymlinks bool) (uint, error) {
@@ -99,12 +113,16 @@ func findUntaggedCount(store *storage.Storage, tx *storage.Tx, paths []string, r
 		count++
 	}
 
-	err := findUntaggedFunc(store, tx, paths, recursive, followSymlinks, action)
+	err := findUntaggedFunc(store, tx, paths, recursive, followSymlinks, make(directoryGuard), action)
 
 	return count, err
 }
 
-func findUntaggedFunc(store *storage.Storage, tx *storage.Tx, paths []string, recursive, followSymlinks bool, action func(absPath string)) error {
+func findUntaggedFunc(store *storage.Storage, tx *storage.Tx, paths []string, recursive, followSymlinks bool, guard directoryGuard, action func(absPath string)) error {
+	// the tagged file names of each directory, which for a directory's
+	// entries need be retrieved only once
+	taggedNames := make(map[string]map[string]bool)
+
 	for _, path := range paths {
 		absPath, err := filepath.Abs(path)
 		if err != nil {
@@ -112,7 +130,7 @@ func findUntaggedFunc(store *storage.Storage, tx *storage.Tx, paths []string, re
 		}
 
 		if followSymlinks {
-			log.Infof(2, "%v: resolving path", path)
+			log.Debugf("%v: resolving path", path)
 
 			absPath, err = filepath.EvalSymlinks(absPath)
 			if err != nil {
@@ -120,12 +138,17 @@ func findUntaggedFunc(store *storage.Storage, tx *storage.Tx, paths []string, re
 			}
 		}
 
-		//TODO PERF no need to retrieve file: we merely need to know it exists
-		file, err := store.FileByPath(tx, absPath)
-		if err != nil {
-			return fmt.Errorf("%v: could not retrieve file: %v", path, err)
+		directory := filepath.Dir(absPath)
+		names, ok := taggedNames[directory]
+		if !ok {
+			names, err = store.TaggedFileNamesByDirectory(tx, directory)
+			if err != nil {
+				return fmt.Errorf("%v: could not retrieve tagged files: %v", directory, err)
+			}
+
+			taggedNames[directory] = names
 		}
-		if file == nil {
+		if !names[filepath.Base(absPath)] {
 			action(absPath)
 		}
 
@@ -134,8 +157,14 @@ func findUntaggedFunc(store *storage.Storage, tx *storage.Tx, paths []string, re
 			if err != nil {
 				return err
 			}
+			if len(entries) > 0 && !guard.enter(path) {
+				log.Warnf("%v: skipping directory already visited (symbolic link loop?)", path)
+				continue
+			}
 
-			findUntaggedFunc(store, tx, entries, true, followSymlinks, action)
+			if err := findUntaggedFunc(store, tx, entries, true, followSymlinks, guard, action); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -19,19 +19,31 @@ import (
 	"fmt"
 	"github.com/oniony/TMSU/common/log"
 	"github.com/oniony/TMSU/common/terminal"
+	"github.com/oniony/TMSU/entities"
 	"github.com/oniony/TMSU/storage"
+	"sort"
 	"strings"
 )
 
 var ValuesCommand = Command{
-	Name:        "values",
-	Synopsis:    "List values",
-	Usages:      []string{"tmsu values [OPTION]... [TAG]..."},
-	Description: "Lists the values for TAGs. If no TAG is specified then all tags are listed.",
+	Name:     "values",
+	Synopsis: "List values",
+	Usages:   []string{"tmsu values [OPTION]... [TAG]...", "tmsu values [OPTION]... --used-by=QUERY [TAG]...", "tmsu values [OPTION]... --suggest=PREFIX [TAG]"},
+	Description: `Lists the values for TAGs. If no TAG is specified then all tags are listed.
+
+With --used-by only those values applied, explicitly or by implication, to the files matching QUERY are listed. See the 'files' subcommand for the query syntax.
+
+With --suggest the values beginning with PREFIX are listed one per line, e.g. for shell completion. Where TAG is specified these are the values applied with TAG together with those permitted by its enumeration type (see the 'tag-def' subcommand) or vocabulary (see the 'vocabulary' subcommand), listed in the order of the tag's type. The case of the PREFIX is ignored if the 'ignoreCase' setting is enabled.
+
+To reject values outside of a fixed set, such as typing mistakes, define the tag as an enumeration with the 'tag-def' subcommand.`,
 	Examples: []string{"$ tmsu values year\n2000\n2001\n2017",
 		"$ tmsu values\n2000\n2001\n2017\ncheese\nopera",
-		"$ tmsu values --count year\n3"},
+		"$ tmsu values --count year\n3",
+		"$ tmsu values --used-by='film and year>2000' actor\njones  smith",
+		"$ tmsu values --suggest=20 year\n2000\n2001\n2017"},
 	Options: Options{{"--count", "-c", "lists the number of values rather than their names", false, ""},
+		{"--used-by", "-u", "list only the values occurring on files matching the query", true, ""},
+		{"--suggest", "-s", "list only the values beginning with the prefix", true, ""},
 		{"", "-1", "list one value per line", false, ""}},
 	Exec: valuesExec,
 }
@@ -54,6 +66,16 @@ func valuesExec(options Options, args []string, databasePath string) (error, war
 	}
 	defer tx.Commit()
 
+	if options.Get("--suggest") != nil {
+		prefix := options.Get("--suggest").Argument
+		return listValueSuggestions(store, tx, prefix, args, showCount)
+	}
+
+	if options.HasOption("--used-by") {
+		queryText := options.Get("--used-by").Argument
+		return listValuesUsedBy(store, tx, queryText, args, showCount, onePerLine)
+	}
+
 	if len(args) == 0 {
 		return listAllValues(store, tx, showCount, onePerLine), nil
 	}
@@ -62,7 +84,7 @@ func valuesExec(options Options, args []string, databasePath string) (error, war
 }
 
 func listAllValues(store *storage.Storage, tx *storage.Tx, showCount, onePerLine bool) error {
-	log.Info(2, "retrieving all values.")
+	log.Debug("retrieving all values.")
 
 	if showCount {
 		count, err := store.ValueCount(tx)
@@ -79,12 +101,12 @@ func listAllValues(store *storage.Storage, tx *storage.Tx, showCount, onePerLine
 
 		if onePerLine {
 			for _, value := range values {
-				fmt.Println(escape(value.Name))
+				fmt.Println(formatName(value.Name))
 			}
 		} else {
 			valueNames := make([]string, len(values))
 			for index, value := range values {
-				valueNames[index] = escape(value.Name)
+				valueNames[index] = formatName(value.Name)
 			}
 
 			terminal.PrintColumns(valueNames)
@@ -119,7 +141,7 @@ func listValuesForTag(store *storage.Storage, tx *storage.Tx, tagName string, sh
 		return fmt.Errorf("no such tag, '%v'", tagName)
 	}
 
-	log.Infof(2, "retrieving values for tag '%v'.", tagName)
+	log.Debugf("retrieving values for tag '%v'.", tagName)
 
 	values, err := store.ValuesByTag(tx, tag.Id)
 	if err != nil {
@@ -131,12 +153,12 @@ func listValuesForTag(store *storage.Storage, tx *storage.Tx, tagName string, sh
 	} else {
 		if onePerLine {
 			for _, value := range values {
-				fmt.Println(escape(value.Name, '=', ' '))
+				fmt.Println(formatName(value.Name))
 			}
 		} else {
 			valueNames := make([]string, len(values))
 			for index, value := range values {
-				valueNames[index] = escape(value.Name, '=', ' ')
+				valueNames[index] = formatName(value.Name)
 			}
 
 			terminal.PrintColumns(valueNames)
@@ -159,7 +181,7 @@ func listValuesForTags(store *storage.Storage, tx *storage.Tx, tagNames []string
 			continue
 		}
 
-		log.Infof(2, "retrieving values for tag '%v'.", tagName)
+		log.Debugf("retrieving values for tag '%v'.", tagName)
 
 		values, err := store.ValuesByTag(tx, tag.Id)
 		if err != nil {
@@ -172,13 +194,13 @@ func listValuesForTags(store *storage.Storage, tx *storage.Tx, tagNames []string
 			if onePerLine {
 				fmt.Println(tagName)
 				for _, value := range values {
-					fmt.Println(escape(value.Name, '=', ' '))
+					fmt.Println(formatName(value.Name))
 				}
 				fmt.Println()
 			} else {
 				valueNames := make([]string, len(values))
 				for index, value := range values {
-					valueNames[index] = escape(value.Name, '=', ' ')
+					valueNames[index] = formatName(value.Name)
 				}
 
 				fmt.Printf("%v: %v\n", tagName, strings.Join(valueNames, " "))
@@ -188,3 +210,135 @@ func listValuesForTags(store *storage.Storage, tx *storage.Tx, tagNames []string
 
 	return nil, warnings
 }
+
+func listValueSuggestions(store *storage.Storage, tx *storage.Tx, prefix string, args []string, showCount bool) (error, warnings) {
+	var tagId entities.TagId
+	switch len(args) {
+	case 0:
+	case 1:
+		tagName := parseTagOrValueName(args[0])
+
+		tag, err := store.TagByName(tx, tagName)
+		if err != nil {
+			return fmt.Errorf("could not retrieve tag '%v': %v", tagName, err), nil
+		}
+		if tag == nil {
+			return fmt.Errorf("no such tag, '%v'", tagName), nil
+		}
+
+		tagId = tag.Id
+	default:
+		return fmt.Errorf("only one tag may be specified with --suggest"), nil
+	}
+
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve settings: %v", err), nil
+	}
+
+	log.Debugf("retrieving values beginning '%v'.", prefix)
+
+	valueNames, err := store.ValueNamesByPrefix(tx, tagId, prefix, settings.IgnoreCase())
+	if err != nil {
+		return fmt.Errorf("could not retrieve values: %v", err), nil
+	}
+
+	if showCount {
+		fmt.Println(len(valueNames))
+		return nil, nil
+	}
+
+	for _, valueName := range valueNames {
+		fmt.Println(formatName(valueName))
+	}
+
+	return nil, nil
+}
+
+func listValuesUsedBy(store *storage.Storage, tx *storage.Tx, queryText string, args []string, showCount, onePerLine bool) (error, warnings) {
+	warnings := make(warnings, 0, 10)
+
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve settings: %v", err), nil
+	}
+
+	expression, err := parseQuery(store, tx, queryText)
+	if err != nil {
+		return err, nil
+	}
+
+	expression, ignoreCase, err := store.NormalizeQuery(tx, expression, settings.IgnoreCase())
+	if err != nil {
+		return fmt.Errorf("could not normalize query: %v", err), nil
+	}
+
+	var tagIds map[entities.TagId]bool
+	if len(args) > 0 {
+		tagIds = make(map[entities.TagId]bool, len(args))
+
+		for _, arg := range args {
+			tagName := parseTagOrValueName(arg)
+
+			tag, err := store.TagByName(tx, tagName)
+			if err != nil {
+				return fmt.Errorf("could not retrieve tag '%v': %v", tagName, err), warnings
+			}
+			if tag == nil {
+				warnings = append(warnings, fmt.Sprintf("no such tag, '%v'.", tagName))
+				continue
+			}
+
+			tagIds[tag.Id] = true
+		}
+	}
+
+	log.Debug("querying database")
+
+	files, err := store.FilesForQuery(tx, expression, "", false, ignoreCase, "none")
+	if err != nil {
+		return fmt.Errorf("could not query files: %v", err), warnings
+	}
+
+	valueIds := make(entities.ValueIds, 0, 10)
+	for _, file := range files {
+		fileTags, err := store.FileTagsByFileId(tx, file.Id, false)
+		if err != nil {
+			return fmt.Errorf("%v: could not retrieve file-tags: %v", file.Path(), err), warnings
+		}
+
+		for _, fileTag := range fileTags {
+			if fileTag.ValueId == 0 || (tagIds != nil && !tagIds[fileTag.TagId]) {
+				continue
+			}
+
+			valueIds = append(valueIds, fileTag.ValueId)
+		}
+	}
+
+	values, err := store.ValuesByIds(tx, valueIds.Uniq())
+	if err != nil {
+		return fmt.Errorf("could not retrieve values: %v", err), warnings
+	}
+
+	if showCount {
+		fmt.Println(len(values))
+		return nil, warnings
+	}
+
+	valueNames := make([]string, len(values))
+	for index, value := range values {
+		valueNames[index] = formatName(value.Name)
+	}
+	sort.Strings(valueNames)
+
+	if onePerLine {
+		for _, valueName := range valueNames {
+			fmt.Println(valueName)
+		}
+	} else {
+		terminal.PrintColumns(valueNames)
+	}
+
+	return nil, warnings
+}
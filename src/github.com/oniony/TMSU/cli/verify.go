@@ -0,0 +1,210 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/fingerprint"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var VerifyCommand = Command{
+	Name:     "verify",
+	Synopsis: "Check file contents against their fingerprints",
+	Usages: []string{"tmsu verify [OPTION]... [PATH]...",
+		"tmsu verify [OPTION]... --where=QUERY"},
+	Description: `Recalculates the fingerprints of the tagged files under each PATH, or of those matching QUERY, and reports any whose content no longer matches the fingerprint recorded in the database. If neither is specified then every tagged file is verified.
+
+A file whose modification time and size are unchanged but whose content differs is reported as 'corrupt', which suggests bit rot or silent corruption: restore it from a backup. One that has been changed since it was fingerprinted is reported as 'modified'. Files that are missing are reported as such and directories, and files without a fingerprint, are skipped.
+
+The outcome of each verification is recorded and shown by 'status --verify-state' and 'files --failing-verification'.
+
+With --repair-fingerprint the new content of the files that fail is accepted instead: their fingerprints are updated to match.`,
+	Examples: []string{`$ tmsu verify ~/Photos
+/home/bob/Photos/mountain.jpg: corrupt
+tmsu: 1 file(s) failed verification`,
+		`$ tmsu verify --where="important and not archived"`,
+		"$ tmsu verify --repair-fingerprint ~/Documents/notes.txt"},
+	Options: Options{{"--where", "-w", "verify the files matching QUERY", true, ""},
+		{"--repair-fingerprint", "-r", "accept the content of files that fail by updating their fingerprints", false, ""},
+		{"--jobs", "-j", "fingerprint up to N files at once (default: the number of processors)", true, ""}},
+	Exec: verifyExec,
+}
+
+// unexported
+
+func verifyExec(options Options, args []string, databasePath string) (error, warnings) {
+	repairFingerprint := options.HasOption("--repair-fingerprint")
+
+	jobs, err := parseJobs(options)
+	if err != nil {
+		return err, nil
+	}
+
+	if options.HasOption("--where") && len(args) > 0 {
+		return fmt.Errorf("--where cannot be used with paths"), nil
+	}
+
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	tx, err := store.Begin()
+	if err != nil {
+		return err, nil
+	}
+	defer tx.Commit()
+
+	settings, err := store.Settings(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve settings: %v", err), nil
+	}
+
+	var files entities.Files
+	switch {
+	case options.HasOption("--where"):
+		files, err = filesForVerifyQuery(store, tx, options.Get("--where").Argument)
+	case len(args) > 0:
+		files, err = filesForVerifyPaths(store, tx, args)
+	default:
+		files, err = store.Files(tx, "name")
+	}
+	if err != nil {
+		return err, nil
+	}
+
+	return verifyFiles(store, tx, files, repairFingerprint, newFingerprinter(settings, jobs))
+}
+
+func filesForVerifyQuery(store *storage.Storage, tx *storage.Tx, queryText string) (entities.Files, error) {
+	expression, err := parseQuery(store, tx, queryText)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.FilesForQuery(tx, expression, "", false, false, "name")
+}
+
+func filesForVerifyPaths(store *storage.Storage, tx *storage.Tx, paths []string) (entities.Files, error) {
+	files := make(entities.Files, 0, 10)
+
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("%v: could not get absolute path: %v", path, err)
+		}
+
+		file, err := store.FileByPath(tx, absPath)
+		if err != nil {
+			return nil, fmt.Errorf("%v: could not retrieve file: %v", path, err)
+		}
+		if file != nil {
+			files = append(files, file)
+		}
+
+		dirFiles, err := store.FilesByDirectory(tx, absPath)
+		if err != nil {
+			return nil, fmt.Errorf("%v: could not retrieve files: %v", path, err)
+		}
+
+		files = append(files, dirFiles...)
+	}
+
+	return files, nil
+}
+
+func verifyFiles(store *storage.Storage, tx *storage.Tx, files entities.Files, repairFingerprint bool, fingerprints *fingerprinter) (error, warnings) {
+	warnings := make(warnings, 0, 10)
+
+	verifiable := make(entities.Files, 0, len(files))
+	for _, file := range files {
+		if file.IsDir || file.Fingerprint == fingerprint.Empty {
+			log.Debugf("%v: skipping as it has no content fingerprint", file.Path())
+			continue
+		}
+
+		verifiable = append(verifiable, file)
+	}
+
+	failed := 0
+	for index, file := range verifiable {
+		fingerprints.prepareFileBatch(verifiable, index)
+
+		stat, err := os.Lstat(file.Path())
+		if err != nil {
+			switch {
+			case os.IsNotExist(err):
+				fmt.Printf("%v: missing\n", file.Path())
+				failed++
+			case os.IsPermission(err):
+				warnings = append(warnings, fmt.Sprintf("%v: permission denied", file.Path()))
+			default:
+				return fmt.Errorf("%v: could not stat file: %v", file.Path(), err), warnings
+			}
+
+			continue
+		}
+
+		log.Debugf("%v: verifying", file.Path())
+
+		fp, err := fingerprints.create(file.Path())
+		if err != nil {
+			if commandContext.Err() != nil {
+				return err, warnings
+			}
+
+			warnings = append(warnings, fmt.Sprintf("%v: could not create fingerprint: %v", file.Path(), err))
+			continue
+		}
+
+		passed := fp == file.Fingerprint
+		if !passed {
+			if file.ModTime.Equal(stat.ModTime().UTC()) && file.Size == stat.Size() {
+				fmt.Printf("%v: corrupt\n", file.Path())
+			} else {
+				fmt.Printf("%v: modified\n", file.Path())
+			}
+
+			if repairFingerprint {
+				if _, err := store.UpdateFile(tx, file.Id, file.Path(), fp, stat.ModTime(), stat.Size(), stat.IsDir()); err != nil {
+					return fmt.Errorf("%v: could not update file in database: %v", file.Path(), err), warnings
+				}
+
+				fmt.Printf("%v: updated fingerprint\n", file.Path())
+				passed = true
+			} else {
+				failed++
+			}
+		}
+
+		if err := store.UpdateVerification(tx, file.Id, time.Now(), passed); err != nil {
+			return fmt.Errorf("%v: could not record verification: %v", file.Path(), err), warnings
+		}
+	}
+
+	if failed > 0 {
+		warnings = append(warnings, fmt.Sprintf("%v file(s) failed verification", failed))
+	}
+
+	return nil, warnings
+}
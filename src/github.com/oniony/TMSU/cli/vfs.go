@@ -18,9 +18,16 @@
 package cli
 
 import (
+	"context"
 	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/storage"
 	"github.com/oniony/TMSU/vfs"
+	"os/user"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var VfsCommand = Command{
@@ -43,25 +50,157 @@ func vfsExec(options Options, args []string, databasePath string) (error, warnin
 	}
 
 	mountOptions := []string{}
+	excludedPaths := []string{}
+	workers := uint(4)
+	timeout := 30 * time.Second
+	cache := true
+	writeBack := vfs.WriteBack{Untag: true, DeleteTags: true}
+	owner := vfs.CurrentOwner()
 	if options.HasOption("--options") {
-		mountOptions = strings.Split(options.Get("--options").Argument, ",")
+		for _, mountOption := range strings.Split(options.Get("--options").Argument, ",") {
+			switch {
+			case mountOption == "":
+				// ignore
+			case strings.HasPrefix(mountOption, "exclude="):
+				excludedPath, err := filepath.Abs(mountOption[len("exclude="):])
+				if err != nil {
+					return fmt.Errorf("could not get absolute path for excluded path: %v", err), nil
+				}
+
+				excludedPaths = append(excludedPaths, excludedPath)
+			case strings.HasPrefix(mountOption, "workers="):
+				value, err := strconv.ParseUint(mountOption[len("workers="):], 10, 32)
+				if err != nil || value == 0 {
+					return fmt.Errorf("invalid workers option '%v': expected a positive integer", mountOption), nil
+				}
+
+				workers = uint(value)
+			case strings.HasPrefix(mountOption, "timeout="):
+				value, err := strconv.ParseUint(mountOption[len("timeout="):], 10, 32)
+				if err != nil {
+					return fmt.Errorf("invalid timeout option '%v': expected a number of seconds", mountOption), nil
+				}
+
+				timeout = time.Duration(value) * time.Second
+			case strings.HasPrefix(mountOption, "uid="):
+				uid, err := parseMountOwner(mountOption[len("uid="):], lookupUserId)
+				if err != nil {
+					return fmt.Errorf("invalid uid option '%v': %v", mountOption, err), nil
+				}
+
+				owner.Uid = uid
+			case strings.HasPrefix(mountOption, "gid="):
+				gid, err := parseMountOwner(mountOption[len("gid="):], lookupGroupId)
+				if err != nil {
+					return fmt.Errorf("invalid gid option '%v': %v", mountOption, err), nil
+				}
+
+				owner.Gid = gid
+			case mountOption == "ro":
+				// the databases are opened read-only so that changes fail
+				// with EROFS, whilst the option is also passed to FUSE
+				readOnly = true
+				mountOptions = append(mountOptions, mountOption)
+			case mountOption == "nocache":
+				cache = false
+			case mountOption == "tagcopies":
+				writeBack.Tag = true
+			case mountOption == "nountag":
+				writeBack.Untag = false
+			case mountOption == "nodeletetags":
+				writeBack.DeleteTags = false
+			default:
+				mountOptions = append(mountOptions, mountOption)
+			}
+		}
 	}
 
 	mountPath := args[0]
 
-	store, err := openDatabase(databasePath)
-	if err != nil {
-		return err, nil
+	databasePaths := databaseOptionPaths(options)
+	if len(databasePaths) < 2 {
+		databasePaths = []string{databasePath}
+	}
+
+	stores := make([]*storage.Storage, len(databasePaths))
+	for index, databasePath := range databasePaths {
+		store, err := openDatabase(databasePath)
+		if err != nil {
+			return err, nil
+		}
+		defer store.Close()
+
+		// requests in progress when interrupted are completed whilst unmounting
+		store.SetContext(context.Background())
+
+		if len(excludedPaths) > 0 {
+			log.Debugf("excluding paths: %v", strings.Join(excludedPaths, ", "))
+			store.ExcludePaths(excludedPaths...)
+		}
+
+		stores[index] = store
+	}
+
+	var fileSystem interface {
+		Serve(ctx context.Context)
+		Unmount()
 	}
-	defer store.Close()
 
-	vfs, err := vfs.MountVfs(store, mountPath, mountOptions)
+	var err error
+	if len(stores) == 1 {
+		fileSystem, err = vfs.MountVfs(stores[0], mountPath, mountOptions, workers, timeout, cache, writeBack, owner)
+	} else {
+		fileSystem, err = vfs.MountUnionVfs(stores, mountPath, mountOptions, workers, timeout, cache, writeBack, owner)
+	}
 	if err != nil {
 		return fmt.Errorf("could not mount virtual filesystem at '%v': %v", mountPath, err), nil
 	}
-	defer vfs.Unmount()
+	defer fileSystem.Unmount()
 
-	vfs.Serve()
+	fileSystem.Serve(commandContext)
 
 	return nil, nil
 }
+
+// Parses the user or group of the 'uid' or 'gid' mount option, which may be
+// given by number or by name.
+func parseMountOwner(text string, lookup func(string) (string, error)) (uint32, error) {
+	if text == "" {
+		return 0, fmt.Errorf("expected a number or name")
+	}
+
+	id, err := strconv.ParseUint(text, 10, 32)
+	if err == nil {
+		return uint32(id), nil
+	}
+
+	idText, err := lookup(text)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err = strconv.ParseUint(idText, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("'%v' does not have a numeric identifier", text)
+	}
+
+	return uint32(id), nil
+}
+
+func lookupUserId(name string) (string, error) {
+	account, err := user.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+
+	return account.Uid, nil
+}
+
+func lookupGroupId(name string) (string, error) {
+	group, err := user.LookupGroup(name)
+	if err != nil {
+		return "", err
+	}
+
+	return group.Gid, nil
+}
@@ -0,0 +1,277 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage"
+	"io"
+	"os"
+	"strings"
+)
+
+var VocabularyCommand = Command{
+	Name:     "vocabulary",
+	Aliases:  []string{"vocab"},
+	Synopsis: "Manages controlled vocabularies of tag values",
+	Usages: []string{"tmsu vocabulary [OPTION]... [TAG]...",
+		"tmsu vocabulary --load=FILE TAG",
+		"tmsu vocabulary --delete TAG..."},
+	Description: `Manages the controlled vocabulary of values for TAG, e.g. the set of country names for a 'country' tag.
+
+When a value is applied with the 'tag' or 'imply' subcommands it is canonicalised against the tag's vocabulary: a synonym is replaced by its canonical value and letter case is corrected, so 'country=uk' is applied as 'country=United Kingdom'. Values that are not in the vocabulary produce a warning, along with suggestions of similar terms, unless the 'strictVocabularies' setting is enabled in which case they are rejected.
+
+The --load option replaces the vocabulary of TAG with that read from FILE ('-' for standard input). Each line of the file holds a canonical value optionally followed by its synonyms, all separated by tabs. Blank lines and lines starting with '#' are ignored.
+
+When run without arguments lists each tag that has a vocabulary along with the number of canonical values. Otherwise lists the canonical values of each TAG.`,
+	Examples: []string{"$ tmsu vocabulary --load=countries.tsv country",
+		"$ tmsu vocabulary\ncountry: 249 values",
+		"$ tmsu vocabulary --synonyms country\n...\nUnited Kingdom (Great Britain, UK)\n...",
+		"$ tmsu tag holiday.jpg country=germny\ntmsu: 'country=germny': 'germny' is not in the vocabulary: did you mean 'Germany'?",
+		"$ tmsu config strictVocabularies=yes",
+		"$ tmsu vocabulary --delete country"},
+	Options: Options{Option{"--load", "-l", "replaces the vocabulary with that read from FILE", true, ""},
+		Option{"--synonyms", "-s", "list the synonyms of each value", false, ""},
+		Option{"--delete", "-d", "deletes the vocabulary", false, ""}},
+	Exec: vocabularyExec,
+}
+
+// unexported
+
+func vocabularyExec(options Options, args []string, databasePath string) (error, warnings) {
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	tx, err := store.Begin()
+	if err != nil {
+		return err, nil
+	}
+	defer tx.Commit()
+
+	switch {
+	case options.HasOption("--delete"):
+		if len(args) == 0 {
+			return fmt.Errorf("too few arguments"), nil
+		}
+
+		return deleteVocabularies(store, tx, args)
+	case options.HasOption("--load"):
+		if len(args) != 1 {
+			return fmt.Errorf("a single tag must be specified"), nil
+		}
+
+		return loadVocabulary(store, tx, args[0], options.Get("--load").Argument), nil
+	case len(args) == 0:
+		return listAllVocabularies(store, tx), nil
+	default:
+		return listVocabularies(store, tx, args, options.HasOption("--synonyms"))
+	}
+}
+
+func listAllVocabularies(store *storage.Storage, tx *storage.Tx) error {
+	log.Debug("retrieving vocabularies")
+
+	vocabularies, err := store.Vocabularies(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve vocabularies: %v", err)
+	}
+
+	tags, err := store.Tags(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tags: %v", err)
+	}
+
+	for _, tag := range tags {
+		vocabulary := vocabularies.ForTag(tag.Id)
+		if vocabulary == nil {
+			continue
+		}
+
+		fmt.Printf("%v: %v values\n", escape(tag.Name, ':'), len(vocabulary.CanonicalNames()))
+	}
+
+	return nil
+}
+
+func listVocabularies(store *storage.Storage, tx *storage.Tx, tagArgs []string, showSynonyms bool) (error, warnings) {
+	warnings := make(warnings, 0, 10)
+
+	for index, tagArg := range tagArgs {
+		tagName := parseTagOrValueName(tagArg)
+
+		tag, err := store.TagByName(tx, tagName)
+		if err != nil {
+			return fmt.Errorf("could not retrieve tag '%v': %v", tagName, err), warnings
+		}
+		if tag == nil {
+			warnings = append(warnings, noSuchTagWarning(NoSuchTagError{tagName, nil}))
+			continue
+		}
+
+		vocabulary, err := store.Vocabulary(tx, tag.Id)
+		if err != nil {
+			return fmt.Errorf("could not retrieve vocabulary for tag '%v': %v", tagName, err), warnings
+		}
+		if vocabulary == nil {
+			warnings = append(warnings, fmt.Sprintf("tag '%v' has no vocabulary", tagName))
+			continue
+		}
+
+		indent := ""
+		if len(tagArgs) > 1 {
+			if index > 0 {
+				fmt.Println()
+			}
+
+			fmt.Printf("%v:\n", escape(tagName, ':'))
+			indent = "  "
+		}
+
+		for _, canonical := range vocabulary.CanonicalNames() {
+			synonyms := vocabulary.Synonyms(canonical)
+			if showSynonyms && len(synonyms) > 0 {
+				fmt.Printf("%v%v (%v)\n", indent, canonical, strings.Join(synonyms, ", "))
+			} else {
+				fmt.Printf("%v%v\n", indent, canonical)
+			}
+		}
+	}
+
+	return nil, warnings
+}
+
+func loadVocabulary(store *storage.Storage, tx *storage.Tx, tagArg, path string) error {
+	tagName := parseTagOrValueName(tagArg)
+
+	var reader io.Reader
+	if path == "-" {
+		reader = os.Stdin
+	} else {
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("could not open vocabulary: %v", err)
+		}
+		defer file.Close()
+
+		reader = file
+	}
+
+	terms, err := readVocabularyTerms(reader)
+	if err != nil {
+		return fmt.Errorf("could not read vocabulary '%v': %v", path, err)
+	}
+
+	tag, err := store.TagByName(tx, tagName)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tag '%v': %v", tagName, err)
+	}
+	if tag == nil {
+		tag, err = createTag(store, tx, tagName)
+		if err != nil {
+			return fmt.Errorf("could not create tag '%v': %v", tagName, err)
+		}
+	}
+
+	log.Debugf("loading %v vocabulary terms for tag '%v'", len(terms), tagName)
+
+	if _, err := store.UpdateVocabulary(tx, tag.Id, terms); err != nil {
+		return fmt.Errorf("could not update vocabulary for tag '%v': %v", tagName, err)
+	}
+
+	return nil
+}
+
+func deleteVocabularies(store *storage.Storage, tx *storage.Tx, tagArgs []string) (error, warnings) {
+	warnings := make(warnings, 0, 10)
+
+	for _, tagArg := range tagArgs {
+		tagName := parseTagOrValueName(tagArg)
+
+		tag, err := store.TagByName(tx, tagName)
+		if err != nil {
+			return fmt.Errorf("could not retrieve tag '%v': %v", tagName, err), warnings
+		}
+		if tag == nil {
+			warnings = append(warnings, noSuchTagWarning(NoSuchTagError{tagName, nil}))
+			continue
+		}
+
+		if err := store.DeleteVocabulary(tx, tag.Id); err != nil {
+			return fmt.Errorf("could not delete vocabulary for tag '%v': %v", tagName, err), warnings
+		}
+	}
+
+	return nil, warnings
+}
+
+func readVocabularyTerms(reader io.Reader) ([]entities.VocabularyTerm, error) {
+	terms := make([]entities.VocabularyTerm, 0, 100)
+
+	scanner := bufio.NewScanner(reader)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		names := strings.Split(line, "\t")
+		canonical := strings.TrimSpace(names[0])
+		if canonical == "" {
+			return nil, fmt.Errorf("line %v: missing canonical value", lineNumber)
+		}
+
+		terms = append(terms, entities.VocabularyTerm{canonical, canonical})
+
+		for _, name := range names[1:] {
+			name = strings.TrimSpace(name)
+			if name != "" {
+				terms = append(terms, entities.VocabularyTerm{name, canonical})
+			}
+		}
+	}
+
+	return terms, scanner.Err()
+}
+
+// Canonicalises the value name against the tag's vocabulary, reporting values
+// that are not in the vocabulary as warnings or, if the vocabulary is strict, as
+// an error.
+func canonicalValueName(store *storage.Storage, tx *storage.Tx, settings entities.Settings, tagId entities.TagId, tagArg, valueName string, warnings warnings) (string, warnings, error) {
+	canonical, err := store.CanonicalValueName(tx, tagId, valueName)
+	if err != nil {
+		if _, ok := err.(storage.UnknownTermError); !ok {
+			return "", warnings, fmt.Errorf("'%v': could not canonicalise value: %v", tagArg, err)
+		}
+
+		if settings.StrictVocabularies() {
+			return "", warnings, fmt.Errorf("'%v': %v", tagArg, err)
+		}
+
+		return canonical, append(warnings, fmt.Sprintf("'%v': %v", tagArg, err)), nil
+	}
+
+	if canonical != valueName {
+		log.Debugf("'%v': canonicalised value '%v' to '%v'", tagArg, valueName, canonical)
+	}
+
+	return canonical, warnings, nil
+}
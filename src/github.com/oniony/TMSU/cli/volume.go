@@ -0,0 +1,180 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cli
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/filesystem"
+	"github.com/oniony/TMSU/common/log"
+	_path "github.com/oniony/TMSU/common/path"
+	"github.com/oniony/TMSU/storage"
+	"path/filepath"
+	"strings"
+)
+
+var VolumeCommand = Command{
+	Name:     "volume",
+	Synopsis: "Manage volumes of removable media",
+	Usages: []string{"tmsu volume",
+		"tmsu volume [OPTION]... NAME PATH",
+		"tmsu volume --delete NAME..."},
+	Description: `Adds a volume NAME for the file-system mounted at PATH, such as a removable drive. The paths of the files on a volume are stored relative to its root so that they remain valid wherever the volume is mounted. Files already in the database beneath PATH are moved onto the volume.
+
+A volume is identified by the UUID of its file-system, which is determined automatically where possible or may be specified with --uuid. A volume without a UUID is assumed to be mounted at the path at which it was added.
+
+The 'repair' subcommand skips the files on volumes that are not mounted rather than reporting them as missing and records where each volume is now mounted. The 'files' subcommand shows the volume each file is on with --volume.
+
+When run without arguments lists the volumes along with where each is mounted.
+
+With --delete the volume is removed: the paths of its files are then stored as absolute paths at its current or last mount path.`,
+	Examples: []string{"$ tmsu volume photos /media/bob/PHOTOS",
+		"$ tmsu volume --uuid=2f9a3c1e-8b57-4c1f-9f0e-3c7d2a1b0e44 archive /mnt/archive",
+		`$ tmsu volume
+archive: /mnt/archive (not mounted)
+photos: /media/bob/PHOTOS`,
+		"$ tmsu volume --delete archive"},
+	Options: Options{{"--uuid", "-u", "the UUID of the volume's file-system", true, ""},
+		{"--delete", "-d", "removes the volume", false, ""}},
+	Exec: volumeExec,
+}
+
+// unexported
+
+func volumeExec(options Options, args []string, databasePath string) (error, warnings) {
+	store, err := openDatabase(databasePath)
+	if err != nil {
+		return err, nil
+	}
+	defer store.Close()
+
+	tx, err := store.Begin()
+	if err != nil {
+		return err, nil
+	}
+	defer tx.Commit()
+
+	if options.HasOption("--delete") {
+		if len(args) == 0 {
+			return fmt.Errorf("too few arguments"), nil
+		}
+
+		return deleteVolumes(store, tx, args)
+	}
+
+	switch len(args) {
+	case 0:
+		return listVolumes(store, tx), nil
+	case 2:
+		uuid := ""
+		if options.HasOption("--uuid") {
+			uuid = options.Get("--uuid").Argument
+		}
+
+		return addVolume(store, tx, args[0], args[1], uuid), nil
+	default:
+		return fmt.Errorf("a volume name and path must be specified"), nil
+	}
+}
+
+func listVolumes(store *storage.Storage, tx *storage.Tx) error {
+	log.Debug("retrieving volumes")
+
+	if err := store.UpdateVolumePaths(tx); err != nil {
+		return err
+	}
+
+	volumes, err := store.Volumes(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve volumes: %v", err)
+	}
+
+	for _, volume := range volumes {
+		if store.VolumeMounted(volume) {
+			fmt.Printf("%v: %v\n", volume.Name, store.VolumePath(volume))
+		} else {
+			fmt.Printf("%v: %v (not mounted)\n", volume.Name, volume.Path)
+		}
+	}
+
+	return nil
+}
+
+func addVolume(store *storage.Storage, tx *storage.Tx, name, path, uuid string) error {
+	if name == "" || strings.ContainsAny(name, ": \t\n") {
+		return fmt.Errorf("invalid volume name '%v'", name)
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("%v: could not get absolute path: %v", path, err)
+	}
+
+	volumes, err := store.Volumes(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve volumes: %v", err)
+	}
+	if volumes.ByName(name) != nil {
+		return fmt.Errorf("volume '%v' already exists", name)
+	}
+
+	if uuid == "" {
+		mounts, err := filesystem.ListMounts()
+		if err != nil {
+			return fmt.Errorf("could not list mounted file-systems: %v", err)
+		}
+
+		if mount := mounts.Containing(absPath); mount != nil && _path.Equal(mount.Path, absPath) {
+			uuid = mount.Uuid
+		}
+
+		if uuid == "" {
+			log.Infof("%v: could not determine file-system UUID", path)
+		}
+	}
+
+	log.Debugf("adding volume '%v' at '%v'", name, absPath)
+
+	if _, err := store.AddVolume(tx, name, uuid, absPath); err != nil {
+		return fmt.Errorf("could not add volume '%v': %v", name, err)
+	}
+
+	return nil
+}
+
+func deleteVolumes(store *storage.Storage, tx *storage.Tx, names []string) (error, warnings) {
+	warnings := make(warnings, 0, 10)
+
+	volumes, err := store.Volumes(tx)
+	if err != nil {
+		return fmt.Errorf("could not retrieve volumes: %v", err), warnings
+	}
+
+	for _, name := range names {
+		volume := volumes.ByName(name)
+		if volume == nil {
+			warnings = append(warnings, fmt.Sprintf("no such volume '%v'", name))
+			continue
+		}
+
+		log.Debugf("deleting volume '%v'", name)
+
+		if err := store.DeleteVolume(tx, volume); err != nil {
+			return fmt.Errorf("could not delete volume '%v': %v", name, err), warnings
+		}
+	}
+
+	return nil, warnings
+}
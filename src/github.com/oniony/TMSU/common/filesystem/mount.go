@@ -0,0 +1,70 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package filesystem
+
+import (
+	_path "github.com/oniony/TMSU/common/path"
+	"path/filepath"
+)
+
+// A mounted file-system.
+type Mount struct {
+	Device string
+	Path   string
+	Uuid   string
+}
+
+type Mounts []Mount
+
+// The mount containing the specified absolute path, i.e. that with the longest
+// mount path that is an ancestor of the path, or nil if there is none.
+func (mounts Mounts) Containing(path string) *Mount {
+	var containing *Mount
+
+	for index, mount := range mounts {
+		if !_path.Equal(path, mount.Path) && !_path.HasPrefix(path, withSeparator(mount.Path)) {
+			continue
+		}
+
+		if containing == nil || len(mount.Path) > len(containing.Path) {
+			containing = &mounts[index]
+		}
+	}
+
+	return containing
+}
+
+// The mount of the file-system with the specified UUID, or nil if it is not
+// mounted.
+func (mounts Mounts) ByUuid(uuid string) *Mount {
+	for index, mount := range mounts {
+		if uuid != "" && mount.Uuid == uuid {
+			return &mounts[index]
+		}
+	}
+
+	return nil
+}
+
+// unexported
+
+func withSeparator(path string) string {
+	if len(path) > 0 && path[len(path)-1] == filepath.Separator {
+		return path
+	}
+
+	return path + string(filepath.Separator)
+}
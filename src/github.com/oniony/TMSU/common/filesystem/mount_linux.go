@@ -0,0 +1,91 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package filesystem
+
+import (
+	"bufio"
+	_path "github.com/oniony/TMSU/common/path"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const mountsPath = "/proc/self/mounts"
+const uuidsPath = "/dev/disk/by-uuid"
+
+// Lists the mounted file-systems along with, where it can be determined, the
+// UUID of each.
+func ListMounts() (Mounts, error) {
+	uuids := deviceUuids()
+
+	file, err := os.Open(mountsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	mounts := make(Mounts, 0, 10)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		device := _path.UnescapeOctal(fields[0])
+		if resolved, err := filepath.EvalSymlinks(device); err == nil {
+			device = resolved
+		}
+
+		mounts = append(mounts, Mount{device, _path.UnescapeOctal(fields[1]), uuids[device]})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return mounts, nil
+}
+
+// unexported
+
+// Maps the block devices to the UUIDs of the file-systems upon them.
+func deviceUuids() map[string]string {
+	uuids := make(map[string]string)
+
+	dir, err := os.Open(uuidsPath)
+	if err != nil {
+		return uuids
+	}
+	defer dir.Close()
+
+	names, err := dir.Readdirnames(0)
+	if err != nil {
+		return uuids
+	}
+
+	for _, name := range names {
+		device, err := filepath.EvalSymlinks(filepath.Join(uuidsPath, name))
+		if err != nil {
+			continue
+		}
+
+		uuids[device] = name
+	}
+
+	return uuids
+}
@@ -0,0 +1,59 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package filesystem
+
+import (
+	"testing"
+)
+
+func TestMountsContaining(test *testing.T) {
+	mounts := Mounts{Mount{"/dev/sda1", "/", ""},
+		Mount{"/dev/sdb1", "/media/bob/PHOTOS", "2f9a-3c1e"},
+		Mount{"/dev/sdc1", "/media/bob/PHOTOS2", ""}}
+
+	paths := map[string]string{
+		"/":                             "/",
+		"/home/bob":                     "/",
+		"/media/bob/PHOTOS":             "/media/bob/PHOTOS",
+		"/media/bob/PHOTOS/2017/a.jpg":  "/media/bob/PHOTOS",
+		"/media/bob/PHOTOS2/2017/a.jpg": "/media/bob/PHOTOS2",
+		"/media/bob/PHOTOSX":            "/"}
+
+	for path, expected := range paths {
+		mount := mounts.Containing(path)
+
+		if mount == nil || mount.Path != expected {
+			test.Fatalf("Expected '%v' to be on mount '%v' but was %v", path, expected, mount)
+		}
+	}
+}
+
+func TestMountsByUuid(test *testing.T) {
+	mounts := Mounts{Mount{"/dev/sda1", "/", ""},
+		Mount{"/dev/sdb1", "/media/bob/PHOTOS", "2f9a-3c1e"}}
+
+	if mount := mounts.ByUuid("2f9a-3c1e"); mount == nil || mount.Path != "/media/bob/PHOTOS" {
+		test.Fatalf("Expected mount of UUID '2f9a-3c1e' to be '/media/bob/PHOTOS' but was %v", mount)
+	}
+
+	if mount := mounts.ByUuid(""); mount != nil {
+		test.Fatalf("Expected no mount for the empty UUID but was %v", mount)
+	}
+
+	if mount := mounts.ByUuid("0000-0000"); mount != nil {
+		test.Fatalf("Expected no mount for UUID '0000-0000' but was %v", mount)
+	}
+}
@@ -16,6 +16,7 @@
 package fingerprint
 
 import (
+	"context"
 	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
@@ -34,6 +35,16 @@ const sparseFingerprintThreshold = 5 * 1024 * 1024
 const sparseFingerprintSize = 512 * 1024
 
 func Create(path, fileAlgorithm, directoryAlgorithm, symlinkAlgorithm string) (Fingerprint, error) {
+	return CreateContext(context.Background(), path, fileAlgorithm, directoryAlgorithm, symlinkAlgorithm)
+}
+
+// Creates a fingerprint, abandoning the reading of the file with the context's
+// error should the context be cancelled.
+func CreateContext(ctx context.Context, path, fileAlgorithm, directoryAlgorithm, symlinkAlgorithm string) (Fingerprint, error) {
+	if err := ctx.Err(); err != nil {
+		return Empty, err
+	}
+
 	stat, err := os.Lstat(path)
 	if err != nil {
 		return Empty, err
@@ -54,7 +65,7 @@ func Create(path, fileAlgorithm, directoryAlgorithm, symlinkAlgorithm string) (F
 	case stat.Mode().IsDir():
 		return createDirectoryFingerprint(path, directoryAlgorithm)
 	case stat.Mode().IsRegular():
-		return createFileFingerprint(path, fileAlgorithm, stat)
+		return createFileFingerprint(ctx, path, fileAlgorithm, stat)
 	default:
 		return Empty, fmt.Errorf("unsupported file mode '%v'", stat.Mode())
 	}
@@ -62,34 +73,34 @@ func Create(path, fileAlgorithm, directoryAlgorithm, symlinkAlgorithm string) (F
 
 // unexported
 
-func createFileFingerprint(path, algorithm string, stat os.FileInfo) (Fingerprint, error) {
+func createFileFingerprint(ctx context.Context, path, algorithm string, stat os.FileInfo) (Fingerprint, error) {
 	switch algorithm {
 	case "dynamic:SHA256", "":
-		return dynamicFingerprint(path, sha256.New(), stat.Size())
+		return dynamicFingerprint(ctx, path, sha256.New(), stat.Size())
 	case "dynamic:SHA1":
-		return dynamicFingerprint(path, sha1.New(), stat.Size())
+		return dynamicFingerprint(ctx, path, sha1.New(), stat.Size())
 	case "dynamic:MD5":
-		return dynamicFingerprint(path, md5.New(), stat.Size())
+		return dynamicFingerprint(ctx, path, md5.New(), stat.Size())
 	case "dynamic:BLAKE2b":
 		hash, err := blake2b.New256(nil)
 		if err != nil {
 			// Should never happen actually.
 			return "", err
 		}
-		return dynamicFingerprint(path, hash, stat.Size())
+		return dynamicFingerprint(ctx, path, hash, stat.Size())
 	case "SHA256":
-		return regularFingerprint(path, sha256.New())
+		return regularFingerprint(ctx, path, sha256.New())
 	case "SHA1":
-		return regularFingerprint(path, sha1.New())
+		return regularFingerprint(ctx, path, sha1.New())
 	case "MD5":
-		return regularFingerprint(path, md5.New())
+		return regularFingerprint(ctx, path, md5.New())
 	case "BLAKE2b":
 		hash, err := blake2b.New256(nil)
 		if err != nil {
 			// Should never happen actually.
 			return "", err
 		}
-		return regularFingerprint(path, hash)
+		return regularFingerprint(ctx, path, hash)
 	case "none":
 		return Empty, nil
 	default:
@@ -123,16 +134,16 @@ func createSymlinkFingerprint(path, algorithm string) (Fingerprint, error) {
 	}
 }
 
-func regularFingerprint(path string, h hash.Hash) (Fingerprint, error) {
-	return calculateRegularFingerprint(path, h)
+func regularFingerprint(ctx context.Context, path string, h hash.Hash) (Fingerprint, error) {
+	return calculateRegularFingerprint(ctx, path, h)
 }
 
-func dynamicFingerprint(path string, h hash.Hash, fileSize int64) (Fingerprint, error) {
+func dynamicFingerprint(ctx context.Context, path string, h hash.Hash, fileSize int64) (Fingerprint, error) {
 	if fileSize > sparseFingerprintThreshold {
 		return calculateSparseFingerprint(path, fileSize, h)
 	}
 
-	return calculateRegularFingerprint(path, h)
+	return calculateRegularFingerprint(ctx, path, h)
 }
 
 // Uses the symbolic target's filename as the fingerprint
@@ -247,7 +258,7 @@ func calculateSparseFingerprint(path string, fileSize int64, h hash.Hash) (Finge
 	return Fingerprint(fingerprint), nil
 }
 
-func calculateRegularFingerprint(path string, h hash.Hash) (Fingerprint, error) {
+func calculateRegularFingerprint(ctx context.Context, path string, h hash.Hash) (Fingerprint, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return Empty, err
@@ -255,8 +266,16 @@ func calculateRegularFingerprint(path string, h hash.Hash) (Fingerprint, error)
 	defer file.Close()
 
 	buffer := make([]byte, 1024)
-	for count := 0; err == nil; count, err = file.Read(buffer) {
+	for count, read := 0, 0; err == nil; count, err = file.Read(buffer) {
 		h.Write(buffer[:count])
+
+		// large files take a while so check periodically for cancellation
+		if read += count; read >= sparseFingerprintSize {
+			if err := ctx.Err(); err != nil {
+				return Empty, err
+			}
+			read = 0
+		}
 	}
 
 	sum := h.Sum(make([]byte, 0, 64))
@@ -16,6 +16,7 @@
 package fingerprint
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"testing"
@@ -71,6 +72,24 @@ func TestNoneGeneration(test *testing.T) {
 	testCreateForLargeFile(test, "none", "")
 }
 
+func TestCreateWithCancelledContext(test *testing.T) {
+	tempFilePath := filepath.Join(os.TempDir(), "tmsu-fingerprint")
+	file, err := os.Create(tempFilePath)
+	if err != nil {
+		test.Fatal(err.Error())
+	}
+	defer os.Remove(tempFilePath)
+	file.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = CreateContext(ctx, tempFilePath, "SHA256", "none", "none")
+	if err != context.Canceled {
+		test.Fatalf("Expected '%v' but was '%v'", context.Canceled, err)
+	}
+}
+
 // unexported
 
 func testCreateForSmallFile(test *testing.T, algorithm string, expectedFingerprint Fingerprint) {
@@ -0,0 +1,124 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fingerprint
+
+import (
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math/bits"
+	"os"
+)
+
+// A difference hash (dHash) of an image: visually similar images have hashes
+// that differ in only a few bits.
+type PerceptualHash uint64
+
+var ErrUnsupportedImage = errors.New("not a supported image")
+
+// Creates a perceptual hash of the image at the specified path.
+//
+// The image is reduced to a 9x8 grid of average luminances and each bit of the
+// hash records whether a cell is brighter than its right-hand neighbour, which
+// makes the hash insensitive to scaling, re-encoding and small colour changes.
+func CreatePerceptualHash(path string) (PerceptualHash, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		if err == image.ErrFormat {
+			return 0, ErrUnsupportedImage
+		}
+
+		return 0, err
+	}
+
+	return createDifferenceHash(img), nil
+}
+
+// The number of bits that differ between the two hashes.
+func (hash PerceptualHash) Distance(other PerceptualHash) uint {
+	return uint(bits.OnesCount64(uint64(hash ^ other)))
+}
+
+// unexported
+
+const hashWidth = 8
+const hashHeight = 8
+
+func createDifferenceHash(img image.Image) PerceptualHash {
+	grid := reduce(img, hashWidth+1, hashHeight)
+
+	var hash PerceptualHash
+	for y := 0; y < hashHeight; y++ {
+		for x := 0; x < hashWidth; x++ {
+			hash <<= 1
+			if grid[y][x] > grid[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+// Reduces the image to a grid of the average luminance of each cell.
+func reduce(img image.Image, width, height int) [][]float64 {
+	bounds := img.Bounds()
+	grid := make([][]float64, height)
+
+	for row := 0; row < height; row++ {
+		grid[row] = make([]float64, width)
+
+		y0, y1 := span(bounds.Min.Y, bounds.Dy(), row, height)
+		for column := 0; column < width; column++ {
+			x0, x1 := span(bounds.Min.X, bounds.Dx(), column, width)
+
+			var total float64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					total += luminance(img, x, y)
+				}
+			}
+
+			grid[row][column] = total / float64((x1-x0)*(y1-y0))
+		}
+	}
+
+	return grid
+}
+
+// The pixel range covered by the cell at the index, at least one pixel wide.
+func span(min, length, index, count int) (int, int) {
+	start := min + index*length/count
+	end := min + (index+1)*length/count
+	if end <= start {
+		end = start + 1
+	}
+
+	return start, end
+}
+
+func luminance(img image.Image, x, y int) float64 {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+}
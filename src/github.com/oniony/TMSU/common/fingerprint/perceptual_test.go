@@ -0,0 +1,155 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package fingerprint
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPerceptualHashOfResizedImage(test *testing.T) {
+	// set-up
+
+	dir := createTemporaryDirectory(test)
+	defer os.RemoveAll(dir)
+
+	original := writeImage(test, dir, "original.png", gradient(320, 240))
+	resized := writeImage(test, dir, "resized.jpg", gradient(64, 48))
+	inverted := writeImage(test, dir, "inverted.png", invert(gradient(320, 240)))
+
+	// test
+
+	originalHash := createPerceptualHash(test, original)
+	resizedHash := createPerceptualHash(test, resized)
+	invertedHash := createPerceptualHash(test, inverted)
+
+	// validate
+
+	if distance := originalHash.Distance(resizedHash); distance > 4 {
+		test.Fatalf("expected resized image to be similar but distance was %v", distance)
+	}
+	if distance := originalHash.Distance(invertedHash); distance < 32 {
+		test.Fatalf("expected inverted image to be dissimilar but distance was %v", distance)
+	}
+}
+
+func TestPerceptualHashOfNonImage(test *testing.T) {
+	// set-up
+
+	dir := createTemporaryDirectory(test)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "text")
+	if err := ioutil.WriteFile(path, []byte("not an image"), 0600); err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	_, err := CreatePerceptualHash(path)
+
+	// validate
+
+	if err != ErrUnsupportedImage {
+		test.Fatalf("expected unsupported image error but was %v", err)
+	}
+}
+
+func TestPerceptualHashDistance(test *testing.T) {
+	if distance := PerceptualHash(0xF0).Distance(PerceptualHash(0x0F)); distance != 8 {
+		test.Fatalf("expected distance of 8 but was %v", distance)
+	}
+	if distance := PerceptualHash(0xFF).Distance(PerceptualHash(0xFF)); distance != 0 {
+		test.Fatalf("expected distance of 0 but was %v", distance)
+	}
+}
+
+// unexported
+
+func createTemporaryDirectory(test *testing.T) string {
+	dir, err := ioutil.TempDir("", "tmsu-perceptual")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	return dir
+}
+
+func createPerceptualHash(test *testing.T, path string) PerceptualHash {
+	hash, err := CreatePerceptualHash(path)
+	if err != nil {
+		test.Fatalf("%v: %v", path, err)
+	}
+
+	return hash
+}
+
+func gradient(width, height int) image.Image {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			// alternate the direction of the gradient in each band of rows
+			level := x * 255 / width
+			if (y*4/height)%2 == 1 {
+				level = 255 - level
+			}
+
+			img.SetGray(x, y, color.Gray{uint8(level)})
+		}
+	}
+
+	return img
+}
+
+func invert(img image.Image) image.Image {
+	bounds := img.Bounds()
+	inverted := image.NewGray(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			inverted.SetGray(x, y, color.Gray{255 - color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y})
+		}
+	}
+
+	return inverted
+}
+
+func writeImage(test *testing.T, dir, name string, img image.Image) string {
+	path := filepath.Join(dir, name)
+
+	file, err := os.Create(path)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer file.Close()
+
+	switch filepath.Ext(name) {
+	case ".jpg":
+		err = jpeg.Encode(file, img, nil)
+	default:
+		err = png.Encode(file, img)
+	}
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	return path
+}
@@ -0,0 +1,120 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package i18n
+
+var german = map[string]string{
+	// help
+	"Global options:": "Globale Optionen:",
+	"Examples:":       "Beispiele:",
+	"Aliases:":        "Aliase:",
+	"Options:":        "Optionen:",
+	"Specify subcommand name for detailed help on a particular subcommand, e.g. tmsu help files": "Für eine ausführliche Hilfe zu einem Unterbefehl dessen Namen angeben, z. B. tmsu help files",
+	"No such command '%v'.": "Unbekannter Befehl '%v'.",
+
+	// global options
+	"show verbose messages":                                     "ausführliche Meldungen anzeigen",
+	"show help and exit":                                        "Hilfe anzeigen und beenden",
+	"show version information and exit":                         "Versionsinformationen anzeigen und beenden",
+	"use the specified database":                                "die angegebene Datenbank verwenden",
+	"colorize the output (auto/always/never)":                   "die Ausgabe einfärben (auto/always/never)",
+	"report the changes that would be made without making them": "die Änderungen melden, ohne sie vorzunehmen",
+	"open the database read-only, rejecting any changes":        "die Datenbank schreibgeschützt öffnen und alle Änderungen ablehnen",
+	"wait for another process's lock on the database to be released (--wait=SECONDS to give up after a time)": "auf die Freigabe der Datenbanksperre eines anderen Prozesses warten (--wait=SEKUNDEN, um nach einer Zeit aufzugeben)",
+	"attribute changes to the specified user":                                "Änderungen dem angegebenen Benutzer zuschreiben",
+	"report only errors, not warnings or information":                        "nur Fehler melden, keine Warnungen oder Informationen",
+	"report errors and warnings as tab-separated severity, kind and message": "Fehler und Warnungen als tabulatorgetrennten Schweregrad, Art und Meldung ausgeben",
+
+	// subcommand synopses
+	"Runs several subcommands in a single transaction":             "Führt mehrere Unterbefehle in einer Transaktion aus",
+	"Back up the database":                                         "Die Datenbank sichern",
+	"Tag files from their directory structure":                     "Dateien anhand ihrer Verzeichnisstruktur markieren",
+	"Browse tags and files interactively":                          "Tags und Dateien interaktiv durchsuchen",
+	"Views or amends database settings":                            "Zeigt oder ändert Datenbankeinstellungen",
+	"Create a copy of a tag":                                       "Eine Kopie eines Tags erstellen",
+	"Copy tags from one file to others":                            "Tags von einer Datei auf andere kopieren",
+	"Delete one or more tags":                                      "Einen oder mehrere Tags löschen",
+	"Tag files or show their tags in a desktop dialog":             "Dateien in einem Desktop-Dialog markieren oder ihre Tags anzeigen",
+	"Identify duplicate files":                                     "Doppelte Dateien finden",
+	"Stream tagging changes":                                       "Änderungen an Markierungen fortlaufend ausgeben",
+	"Tag files from their embedded metadata":                       "Dateien anhand ihrer eingebetteten Metadaten markieren",
+	"List files with particular tags":                              "Dateien mit bestimmten Tags auflisten",
+	"Remove missing files from the database, retaining their tags": "Fehlende Dateien aus der Datenbank entfernen und ihre Tags aufbewahren",
+	"Check the database for inconsistencies":                       "Die Datenbank auf Unstimmigkeiten prüfen",
+	"List subcommands or show help for a particular subcommand":    "Unterbefehle auflisten oder Hilfe zu einem Unterbefehl anzeigen",
+	"Show the history of tagging changes":                          "Den Verlauf der Änderungen an Markierungen anzeigen",
+	"Creates a tag implication":                                    "Erstellt eine Tag-Implikation",
+	"Import taggings from other tools":                             "Markierungen aus anderen Programmen importieren",
+	"Index the contents of files":                                  "Den Inhalt von Dateien indizieren",
+	"Add TMSU actions to a file manager":                           "TMSU-Aktionen zu einem Dateimanager hinzufügen",
+	"Show database or file information":                            "Datenbank- oder Dateiinformationen anzeigen",
+	"Initializes a new database":                                   "Initialisiert eine neue Datenbank",
+	"Build a directory tree of symbolic links to tagged files":     "Einen Verzeichnisbaum symbolischer Verknüpfungen auf markierte Dateien erstellen",
+	"List the saved queries a file matches":                        "Die gespeicherten Abfragen auflisten, auf die eine Datei zutrifft",
+	"Merge tags":                                                   "Tags zusammenführen",
+	"Mount the virtual filesystem":                                 "Das virtuelle Dateisystem einhängen",
+	"Normalize tag and value names":                                "Tag- und Wertnamen normalisieren",
+	"Imports tags and implications from an ontology":               "Importiert Tags und Implikationen aus einer Ontologie",
+	"Remove unused tags, values and files":                         "Ungenutzte Tags, Werte und Dateien entfernen",
+	"Relate files to one another":                                  "Dateien miteinander in Beziehung setzen",
+	"Rename a tag or value":                                        "Einen Tag oder Wert umbenennen",
+	"Repair the database":                                          "Die Datenbank reparieren",
+	"Restore forgotten files or the database from a backup":        "Vergessene Dateien oder die Datenbank aus einer Sicherung wiederherstellen",
+	"Serve a web interface to the database":                        "Eine Weboberfläche für die Datenbank bereitstellen",
+	"Set up a new database interactively":                          "Eine neue Datenbank interaktiv einrichten",
+	"List the file tagging status":                                 "Den Markierungsstatus von Dateien auflisten",
+	"Synchronise tagging with another database":                    "Markierungen mit einer anderen Datenbank abgleichen",
+	"Apply tags to files":                                          "Tags auf Dateien anwenden",
+	"Defines the type of a tag's values":                           "Legt den Typ der Werte eines Tags fest",
+	"Describes tags":                                               "Beschreibt Tags",
+	"List tags":                                                    "Tags auflisten",
+	"Unmount the virtual filesystem":                               "Das virtuelle Dateisystem aushängen",
+	"Remove tags from files":                                       "Tags von Dateien entfernen",
+	"List untagged files":                                          "Nicht markierte Dateien auflisten",
+	"List values":                                                  "Werte auflisten",
+	"Check file contents against their fingerprints":               "Dateiinhalte anhand ihrer Fingerabdrücke prüfen",
+	"Display the version":                                          "Die Version anzeigen",
+	"Hosts the virtual filesystem":                                 "Stellt das virtuelle Dateisystem bereit",
+	"Manages controlled vocabularies of tag values":                "Verwaltet kontrollierte Vokabulare von Tag-Werten",
+	"Manage volumes of removable media":                            "Datenträger von Wechselmedien verwalten",
+
+	// errors
+	"no such tag '%v'":     "Tag '%v' existiert nicht",
+	"no such value '%v'":   "Wert '%v' existiert nicht",
+	": did you mean '%v'?": ": war '%v' gemeint?",
+	"interrupted: changes in progress were rolled back": "unterbrochen: laufende Änderungen wurden zurückgenommen",
+	"could not find database: %v":                       "Datenbank nicht gefunden: %v",
+	"no database found: use 'tmsu init' to create one":  "keine Datenbank gefunden: mit 'tmsu init' eine erstellen",
+	"could not parse query: %v":                         "Abfrage konnte nicht gelesen werden: %v",
+
+	// info
+	"Database":           "Datenbank",
+	"Backend":            "Backend",
+	"Root path":          "Wurzelpfad",
+	"Size":               "Größe",
+	"Tags":               "Tags",
+	"Values":             "Werte",
+	"Files":              "Dateien",
+	"Taggings":           "Markierungen",
+	"Mean tags per file": "Tags je Datei im Mittel",
+	"Mean files per tag": "Dateien je Tag im Mittel",
+	"Taggings by %v":     "Markierungen von %v",
+	"(unknown)":          "(unbekannt)",
+	"Tag":                "Tag",
+	"Minimum":            "Minimum",
+	"Maximum":            "Maximum",
+	"Mean":               "Mittelwert",
+	"Date":               "Datum",
+}
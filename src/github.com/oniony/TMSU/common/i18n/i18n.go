@@ -0,0 +1,100 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package i18n translates the messages TMSU shows to the user. Messages are
+// identified by their English text, which is shown should there be no
+// translation, so that a catalogue need only translate some of them.
+package i18n
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// The language messages are translated to, e.g. 'de', or empty for English.
+var Language = languageFromEnvironment()
+
+// Translates the message to the selected language.
+func T(message string) string {
+	if translation, ok := catalogues[Language][message]; ok {
+		return translation
+	}
+
+	return message
+}
+
+// Translates the format then formats the values with it.
+func Tf(format string, values ...interface{}) string {
+	return fmt.Sprintf(T(format), values...)
+}
+
+// Selects the language for a POSIX locale name such as 'de_AT.UTF-8'. The
+// language is used if there is a catalogue for the locale's language and
+// territory, e.g. 'de_AT', or else for the language alone. An unknown locale
+// selects English.
+func Select(locale string) {
+	Language = languageFor(locale)
+}
+
+// The languages there are catalogues for.
+func Languages() []string {
+	languages := make([]string, 0, len(catalogues))
+	for language := range catalogues {
+		languages = append(languages, language)
+	}
+
+	return languages
+}
+
+// unexported
+
+// message catalogues by language, each mapping the English text of a message
+// to its translation
+var catalogues = map[string]map[string]string{
+	"de": german,
+}
+
+// the locale is determined by the first of these to be set, as per gettext
+var localeVariables = []string{"LC_ALL", "LC_MESSAGES", "LANG"}
+
+func languageFromEnvironment() string {
+	for _, name := range localeVariables {
+		if locale := os.Getenv(name); locale != "" {
+			return languageFor(locale)
+		}
+	}
+
+	return ""
+}
+
+func languageFor(locale string) string {
+	// strip the codeset and modifier, e.g. 'de_AT.UTF-8@euro'
+	if index := strings.IndexAny(locale, ".@"); index != -1 {
+		locale = locale[:index]
+	}
+
+	if _, ok := catalogues[locale]; ok {
+		return locale
+	}
+
+	if index := strings.Index(locale, "_"); index != -1 {
+		if _, ok := catalogues[locale[:index]]; ok {
+			return locale[:index]
+		}
+	}
+
+	return ""
+}
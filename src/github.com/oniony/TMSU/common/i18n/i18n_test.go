@@ -0,0 +1,62 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package i18n
+
+import (
+	"testing"
+)
+
+func TestLanguageFor(test *testing.T) {
+	cases := []struct {
+		locale, language string
+	}{
+		{"", ""},
+		{"C", ""},
+		{"POSIX", ""},
+		{"en_GB.UTF-8", ""},
+		{"fr_FR", ""},
+		{"de", "de"},
+		{"de_DE", "de"},
+		{"de_AT.UTF-8", "de"},
+		{"de_DE@euro", "de"},
+	}
+
+	for _, c := range cases {
+		if language := languageFor(c.locale); language != c.language {
+			test.Fatalf("language for '%v': expected '%v' but was '%v'", c.locale, c.language, language)
+		}
+	}
+}
+
+func TestTranslate(test *testing.T) {
+	defer Select("")
+
+	Select("de_DE.UTF-8")
+	if text := T("Examples:"); text != "Beispiele:" {
+		test.Fatalf("expected 'Beispiele:' but was '%v'", text)
+	}
+	if text := Tf("no such tag '%v'", "foo"); text != "Tag 'foo' existiert nicht" {
+		test.Fatalf("expected translated error but was '%v'", text)
+	}
+	if text := T("untranslated"); text != "untranslated" {
+		test.Fatalf("expected untranslated message to be unchanged but was '%v'", text)
+	}
+
+	Select("fr_FR")
+	if text := T("Examples:"); text != "Examples:" {
+		test.Fatalf("expected English but was '%v'", text)
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package ignore matches paths against gitignore-style patterns.
+package ignore
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// The name of the file listing the patterns of paths to ignore within a
+// directory and its descendants.
+const Filename = ".tmsuignore"
+
+// Matches paths against the patterns configured for a tree and those listed in
+// the ignore files within it.
+//
+// As with gitignore, a pattern containing a slash other than at its end is
+// matched against the path relative to the directory the pattern applies to,
+// otherwise against the file name at any depth. A trailing slash matches only
+// directories, '**' matches any number of directories and a leading '!'
+// re-includes a path excluded by an earlier pattern.
+type Matcher struct {
+	root     string
+	patterns []pattern
+	mutex    sync.Mutex
+	files    map[string][]pattern
+}
+
+// Creates a matcher for the tree at root from the specified patterns, which
+// apply in addition to those in the tree's ignore files.
+func NewMatcher(root string, patterns []string) (*Matcher, error) {
+	parsed, err := parsePatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Matcher{filepath.Clean(root), parsed, sync.Mutex{}, make(map[string][]pattern)}, nil
+}
+
+// Validates the patterns.
+func ValidatePatterns(patterns []string) error {
+	_, err := parsePatterns(patterns)
+	return err
+}
+
+// Determines whether the path, which must be absolute, is ignored.
+//
+// Only the path itself is considered: it is the caller's responsibility to
+// skip the contents of ignored directories.
+func (matcher *Matcher) Ignored(absPath string, isDir bool) bool {
+	absPath = filepath.Clean(absPath)
+
+	relPath, err := filepath.Rel(matcher.root, absPath)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		// outside of the tree, so only the configured patterns apply
+		return match(matcher.patterns, strings.TrimPrefix(filepath.ToSlash(absPath), "/"), isDir, false)
+	}
+	if relPath == "." {
+		return false
+	}
+
+	ignored := match(matcher.patterns, filepath.ToSlash(relPath), isDir, false)
+
+	// ignore files nearer the path take precedence
+	dir := matcher.root
+	dirs := []string{dir}
+	if parent := filepath.Dir(relPath); parent != "." {
+		for _, name := range strings.Split(parent, string(filepath.Separator)) {
+			dir = filepath.Join(dir, name)
+			dirs = append(dirs, dir)
+		}
+	}
+
+	for _, dir := range dirs {
+		relToDir, _ := filepath.Rel(dir, absPath)
+		ignored = match(matcher.filePatterns(dir), filepath.ToSlash(relToDir), isDir, ignored)
+	}
+
+	return ignored
+}
+
+// unexported
+
+type pattern struct {
+	segments []string
+	negated  bool
+	dirOnly  bool
+	anchored bool
+}
+
+func parsePatterns(texts []string) ([]pattern, error) {
+	patterns := make([]pattern, 0, len(texts))
+	for _, text := range texts {
+		pattern, ok, err := parsePattern(text)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	return patterns, nil
+}
+
+func parsePattern(text string) (pattern, bool, error) {
+	text = strings.TrimRight(text, " \t\r")
+	if text == "" || text[0] == '#' {
+		return pattern{}, false, nil
+	}
+
+	var result pattern
+
+	switch {
+	case text[0] == '!':
+		result.negated = true
+		text = text[1:]
+	case strings.HasPrefix(text, `\!`), strings.HasPrefix(text, `\#`):
+		text = text[1:]
+	}
+
+	if strings.HasSuffix(text, "/") {
+		result.dirOnly = true
+		text = strings.TrimRight(text, "/")
+	}
+
+	if text == "" {
+		return pattern{}, false, fmt.Errorf("invalid pattern: no name")
+	}
+
+	result.anchored = strings.Contains(text, "/")
+	text = strings.TrimPrefix(text, "/")
+
+	result.segments = strings.Split(text, "/")
+	for _, segment := range result.segments {
+		if _, err := path.Match(segment, ""); err != nil {
+			return pattern{}, false, fmt.Errorf("invalid pattern '%v': %v", text, err)
+		}
+	}
+
+	return result, true, nil
+}
+
+// Applies the patterns in turn to the slash-separated relative path, the last
+// to match determining whether it is ignored.
+func match(patterns []pattern, relPath string, isDir, ignored bool) bool {
+	for _, pattern := range patterns {
+		if pattern.matches(relPath, isDir) {
+			ignored = !pattern.negated
+		}
+	}
+
+	return ignored
+}
+
+func (pattern pattern) matches(relPath string, isDir bool) bool {
+	if pattern.dirOnly && !isDir {
+		return false
+	}
+
+	if !pattern.anchored {
+		matched, _ := path.Match(pattern.segments[0], path.Base(relPath))
+		return matched
+	}
+
+	return matchSegments(pattern.segments, strings.Split(relPath, "/"))
+}
+
+func matchSegments(patternSegments, pathSegments []string) bool {
+	for len(patternSegments) > 0 {
+		if patternSegments[0] == "**" {
+			for skip := 0; skip <= len(pathSegments); skip++ {
+				if matchSegments(patternSegments[1:], pathSegments[skip:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(pathSegments) == 0 {
+			return false
+		}
+
+		if matched, _ := path.Match(patternSegments[0], pathSegments[0]); !matched {
+			return false
+		}
+
+		patternSegments = patternSegments[1:]
+		pathSegments = pathSegments[1:]
+	}
+
+	return len(pathSegments) == 0
+}
+
+// The patterns in the ignore file in the directory, which are read once.
+func (matcher *Matcher) filePatterns(dir string) []pattern {
+	matcher.mutex.Lock()
+	defer matcher.mutex.Unlock()
+
+	patterns, ok := matcher.files[dir]
+	if !ok {
+		patterns = readPatterns(filepath.Join(dir, Filename))
+		matcher.files[dir] = patterns
+	}
+
+	return patterns
+}
+
+func readPatterns(filePath string) []pattern {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	patterns := make([]pattern, 0, 10)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// an invalid pattern in a file is skipped, as with gitignore
+		if pattern, ok, err := parsePattern(scanner.Text()); err == nil && ok {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	return patterns
+}
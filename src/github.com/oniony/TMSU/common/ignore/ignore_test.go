@@ -0,0 +1,123 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ignore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIgnoredByConfiguredPatterns(test *testing.T) {
+	// set-up
+
+	matcher, err := NewMatcher("/some/root", []string{"node_modules", "*.o", "/build", "doc/**/*.html", "logs/", "!keep.o"})
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	ignored := map[string]bool{
+		"/some/root/node_modules":            true,
+		"/some/root/src/node_modules":        true,
+		"/some/root/src/main.o":              true,
+		"/some/root/src/keep.o":              false,
+		"/some/root/build":                   true,
+		"/some/root/src/build":               false,
+		"/some/root/doc/index.html":          true,
+		"/some/root/doc/api/types/list.html": true,
+		"/some/root/src/doc/index.html":      false,
+		"/some/root/src/main.c":              false,
+		"/other/node_modules":                true,
+		"/other/build":                       false,
+	}
+
+	// validate
+
+	for path, expected := range ignored {
+		if actual := matcher.Ignored(path, false); actual != expected {
+			test.Fatalf("Expected '%v' ignored to be %v but was %v", path, expected, actual)
+		}
+	}
+
+	if matcher.Ignored("/some/root/logs", false) {
+		test.Fatalf("Expected a file named 'logs' not to be ignored")
+	}
+	if !matcher.Ignored("/some/root/logs", true) {
+		test.Fatalf("Expected a directory named 'logs' to be ignored")
+	}
+}
+
+func TestIgnoredByIgnoreFiles(test *testing.T) {
+	// set-up
+
+	root, err := ioutil.TempDir("", "tmsu-ignore-")
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	if err := os.MkdirAll(filepath.Join(root, "src", "vendor"), 0755); err != nil {
+		test.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, Filename), []byte("# build output\n*.tmp\ncache/\n"), 0644); err != nil {
+		test.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "src", Filename), []byte("/vendor\n!important.tmp\n"), 0644); err != nil {
+		test.Fatal(err)
+	}
+
+	matcher, err := NewMatcher(root, nil)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	ignored := map[string]bool{
+		"a.tmp":                 true,
+		"src/b.tmp":             true,
+		"src/important.tmp":     false,
+		"important.tmp":         true,
+		"src/vendor":            true,
+		"vendor":                false,
+		"src/main.c":            false,
+		"src/cache":             true,
+		"src/vendor/lib/a.c":    false,
+		"src/subdir/vendor":     false,
+		"src/subdir/cache":      true,
+		"src/subdir/readme.txt": false,
+	}
+
+	// validate
+
+	for path, expected := range ignored {
+		isDir := filepath.Base(path) == "cache" || filepath.Base(path) == "vendor"
+		if actual := matcher.Ignored(filepath.Join(root, path), isDir); actual != expected {
+			test.Fatalf("Expected '%v' ignored to be %v but was %v", path, expected, actual)
+		}
+	}
+}
+
+func TestInvalidPatterns(test *testing.T) {
+	for _, pattern := range []string{"[", "/", "!"} {
+		if err := ValidatePatterns([]string{pattern}); err == nil {
+			test.Fatalf("Expected '%v' to be rejected", pattern)
+		}
+	}
+}
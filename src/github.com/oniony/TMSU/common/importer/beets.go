@@ -0,0 +1,60 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package importer
+
+import (
+	"database/sql"
+	"strconv"
+)
+
+// unexported
+
+// the fields of a beets item that are imported
+var beetsFields = []string{"album", "artist", "genre", "title", "year"}
+
+// Reads the fields of the items in a beets library as tags named by the
+// fields, e.g. 'artist'.
+func readBeets(db *sql.DB) (*Library, error) {
+	rows, err := db.Query(`SELECT path, album, artist, genre, title, year FROM items ORDER BY path`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	library := &Library{}
+	for rows.Next() {
+		var path []byte
+		var album, artist, genre, title sql.NullString
+		var year sql.NullInt64
+		if err := rows.Scan(&path, &album, &artist, &genre, &title, &year); err != nil {
+			return nil, err
+		}
+
+		tagging := Tagging{Path: string(path)}
+		for index, field := range []sql.NullString{album, artist, genre, title} {
+			if field.String != "" {
+				tagging.Tags = append(tagging.Tags, Tag{beetsFields[index], field.String})
+			}
+		}
+		if year.Int64 > 0 {
+			tagging.Tags = append(tagging.Tags, Tag{"year", strconv.FormatInt(year.Int64, 10)})
+		}
+
+		library.Taggings = append(library.Taggings, tagging)
+	}
+
+	return library, rows.Err()
+}
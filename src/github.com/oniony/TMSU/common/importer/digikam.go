@@ -0,0 +1,169 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package importer
+
+import (
+	"database/sql"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// unexported
+
+// the root of the tags digiKam uses for its own purposes
+const digikamInternalTags = "_Digikam_Internal_Tags_"
+
+type digikamTag struct {
+	parentId int64
+	name     string
+}
+
+// Reads the tags and ratings of the images in a digiKam database. Images are
+// held in albums, which are directories beneath the album roots. Tags are
+// nested within their parent tag.
+func readDigikam(db *sql.DB) (*Library, error) {
+	tags, err := readDigikamTags(db)
+	if err != nil {
+		return nil, err
+	}
+
+	library := &Library{}
+	ids := make([]int64, 0, len(tags))
+	for id := range tags {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	names := make(map[int64]string) // the tag names, with the hierarchy added
+	for _, id := range ids {
+		if path := digikamTagPath(tags, id); path != nil {
+			names[id] = library.addHierarchy(path)
+		}
+	}
+
+	rows, err := db.Query(`
+SELECT i.id, r.identifier, r.specificPath, a.relativePath, i.name, coalesce(ii.rating, -1)
+FROM Images i
+INNER JOIN Albums a ON a.id = i.album
+INNER JOIN AlbumRoots r ON r.id = a.albumRoot
+LEFT OUTER JOIN ImageInformation ii ON ii.imageid = i.id
+WHERE i.status = 1
+ORDER BY r.id, a.relativePath, i.name`)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := make(map[int64]int) // image to tagging
+	for rows.Next() {
+		var id int64
+		var identifier, specificPath, relativePath, name string
+		var rating int
+		if err := rows.Scan(&id, &identifier, &specificPath, &relativePath, &name, &rating); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		tagging := Tagging{Path: filepath.Join(digikamRootPath(identifier, specificPath), relativePath, name)}
+		if rating > 0 {
+			tagging.Tags = append(tagging.Tags, Tag{"rating", strconv.Itoa(rating)})
+		}
+
+		indexes[id] = len(library.Taggings)
+		library.Taggings = append(library.Taggings, tagging)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(`SELECT imageid, tagid FROM ImageTags ORDER BY imageid, tagid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var imageId, tagId int64
+		if err := rows.Scan(&imageId, &tagId); err != nil {
+			return nil, err
+		}
+
+		index, ok := indexes[imageId]
+		name, tagged := names[tagId]
+		if ok && tagged {
+			library.Taggings[index].Tags = append(library.Taggings[index].Tags, Tag{name, ""})
+		}
+	}
+
+	return library, rows.Err()
+}
+
+func readDigikamTags(db *sql.DB) (map[int64]digikamTag, error) {
+	rows, err := db.Query(`SELECT id, pid, name FROM Tags`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make(map[int64]digikamTag)
+	for rows.Next() {
+		var id int64
+		var tag digikamTag
+		if err := rows.Scan(&id, &tag.parentId, &tag.name); err != nil {
+			return nil, err
+		}
+
+		tags[id] = tag
+	}
+
+	return tags, rows.Err()
+}
+
+// The names of the tag and its ancestors, outermost first, or nil for
+// digiKam's internal tags.
+func digikamTagPath(tags map[int64]digikamTag, id int64) []string {
+	var path []string
+	for id != 0 && len(path) <= len(tags) {
+		tag, ok := tags[id]
+		if !ok {
+			break
+		}
+		if tag.name == digikamInternalTags {
+			return nil
+		}
+
+		path = append([]string{tag.name}, path...)
+		id = tag.parentId
+	}
+
+	return path
+}
+
+// The path of an album root. digiKam identifies the volume of the root, by
+// path or by file-system UUID, with the root's path relative to the volume.
+func digikamRootPath(identifier, specificPath string) string {
+	if index := strings.Index(identifier, "?"); index != -1 {
+		if query, err := url.ParseQuery(identifier[index+1:]); err == nil && query.Get("path") != "" {
+			return query.Get("path")
+		}
+	}
+
+	return specificPath
+}
@@ -0,0 +1,115 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package importer reads the taggings made by other tagging tools, from their
+// sidecar files or databases, so that they can be applied in TMSU.
+package importer
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/oniony/TMSU/common/ontology"
+	"strings"
+)
+
+// The supported tools.
+const (
+	TagSpaces = "tagspaces"
+	Shotwell  = "shotwell"
+	Digikam   = "digikam"
+	Beets     = "beets"
+)
+
+var Tools = []string{TagSpaces, Shotwell, Digikam, Beets}
+
+// A tag, with an optional value, as applied by another tool. The tags read
+// from beets are its fields, e.g. 'artist'.
+type Tag struct {
+	Name  string
+	Value string
+}
+
+// The tags applied to a file by another tool.
+type Tagging struct {
+	Path string
+	Tags []Tag
+}
+
+// The taggings read from a tool along with the hierarchy of its tags, where
+// the tool arranges tags within other tags.
+type Library struct {
+	Taggings  []Tagging
+	Hierarchy ontology.Relations
+}
+
+// Opens a tool's Sqlite database for reading.
+type Opener func(path string) (*sql.DB, error)
+
+// Reads the taggings of the tool from path: the directory tree to search for
+// TagSpaces sidecar files or the database of the other tools.
+func Read(tool, path string, open Opener) (*Library, error) {
+	switch tool {
+	case TagSpaces:
+		return readTagSpaces(path)
+	case Shotwell, Digikam, Beets:
+		db, err := open(path)
+		if err != nil {
+			return nil, fmt.Errorf("%v: could not open %v database: %v", path, tool, err)
+		}
+		defer db.Close()
+
+		var library *Library
+		switch tool {
+		case Shotwell:
+			library, err = readShotwell(db)
+		case Digikam:
+			library, err = readDigikam(db)
+		case Beets:
+			library, err = readBeets(db)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v: could not read %v database: %v", path, tool, err)
+		}
+
+		return library, nil
+	}
+
+	return nil, fmt.Errorf("unsupported tool '%v': expected one of %v", tool, strings.Join(Tools, ", "))
+}
+
+// unexported
+
+// Adds the relations between each tag of a path, e.g. 'animal/dog/beagle', to
+// the hierarchy, returning the last.
+func (library *Library) addHierarchy(names []string) string {
+	for index := 1; index < len(names); index++ {
+		relation := ontology.Relation{names[index-1], names[index]}
+		if !containsRelation(library.Hierarchy, relation) {
+			library.Hierarchy = append(library.Hierarchy, relation)
+		}
+	}
+
+	return names[len(names)-1]
+}
+
+func containsRelation(relations ontology.Relations, relation ontology.Relation) bool {
+	for _, existing := range relations {
+		if existing == relation {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,144 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package importer
+
+import (
+	"github.com/oniony/TMSU/common/ontology"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTagSpaces(test *testing.T) {
+	// set-up
+
+	root := test.TempDir()
+	writeFile(test, filepath.Join(root, "beach[holiday 2018].jpg"), "")
+	writeFile(test, filepath.Join(root, "report.pdf"), "")
+	writeFile(test, filepath.Join(root, "untagged.txt"), "")
+	writeFile(test, filepath.Join(root, ".ts", "report.pdf.json"), `{"appName":"TagSpaces","tags":[{"title":"work","type":"sidecar"},{"title":" ","type":"sidecar"}]}`)
+	writeFile(test, filepath.Join(root, "photos", ".ts", "tsm.json"), `{"tags":[{"title":"pictures"}]}`)
+
+	// test
+
+	library, err := Read(TagSpaces, root, nil)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	expected := []Tagging{{filepath.Join(root, "beach[holiday 2018].jpg"), []Tag{{"holiday", ""}, {"2018", ""}}},
+		{filepath.Join(root, "photos"), []Tag{{"pictures", ""}}},
+		{filepath.Join(root, "report.pdf"), []Tag{{"work", ""}}}}
+	if !reflect.DeepEqual(library.Taggings, expected) {
+		test.Fatalf("expected taggings %v but were %v", expected, library.Taggings)
+	}
+}
+
+func TestTagSpacesFileNameTags(test *testing.T) {
+	cases := []struct {
+		name string
+		tags []string
+	}{
+		{"beach.jpg", nil},
+		{"beach[holiday].jpg", []string{"holiday"}},
+		{"beach[holiday  2018]", []string{"holiday", "2018"}},
+		{"[draft] notes.txt", nil},
+	}
+
+	for _, c := range cases {
+		if tags := tagSpacesFileNameTags(c.name); !reflect.DeepEqual(tags, c.tags) {
+			test.Fatalf("tags of '%v': expected %v but were %v", c.name, c.tags, tags)
+		}
+	}
+}
+
+func TestAddHierarchy(test *testing.T) {
+	// set-up
+
+	library := &Library{}
+
+	// test
+
+	first := library.addHierarchy([]string{"animal", "dog", "beagle"})
+	second := library.addHierarchy([]string{"animal", "dog"})
+
+	// validate
+
+	if first != "beagle" || second != "dog" {
+		test.Fatalf("expected 'beagle' and 'dog' but were '%v' and '%v'", first, second)
+	}
+
+	expected := ontology.Relations{{"animal", "dog"}, {"dog", "beagle"}}
+	if !reflect.DeepEqual(library.Hierarchy, expected) {
+		test.Fatalf("expected hierarchy %v but was %v", expected, library.Hierarchy)
+	}
+}
+
+func TestDigikamTagPath(test *testing.T) {
+	// set-up
+
+	tags := map[int64]digikamTag{1: {0, "animal"}, 2: {1, "dog"}, 3: {0, digikamInternalTags}, 4: {3, "Pick Label"}}
+
+	// test & validate
+
+	if path := digikamTagPath(tags, 2); !reflect.DeepEqual(path, []string{"animal", "dog"}) {
+		test.Fatalf("expected path of 'dog' to be [animal dog] but was %v", path)
+	}
+	if path := digikamTagPath(tags, 4); path != nil {
+		test.Fatalf("expected internal tag to have no path but was %v", path)
+	}
+}
+
+func TestDigikamRootPath(test *testing.T) {
+	cases := []struct {
+		identifier, specificPath, path string
+	}{
+		{"volumeid:?path=%2Fhome%2Fbob%2FPictures", "/", "/home/bob/Pictures"},
+		{"volumeid:?uuid=0123-4567", "/home/bob/Pictures", "/home/bob/Pictures"},
+	}
+
+	for _, c := range cases {
+		if path := digikamRootPath(c.identifier, c.specificPath); path != c.path {
+			test.Fatalf("root path of '%v': expected '%v' but was '%v'", c.identifier, c.path, path)
+		}
+	}
+}
+
+func TestUnsupportedTool(test *testing.T) {
+	// test
+
+	_, err := Read("picasa", "", nil)
+
+	// validate
+
+	if err == nil {
+		test.Fatal("expected error for unsupported tool")
+	}
+}
+
+// unexported
+
+func writeFile(test *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		test.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		test.Fatal(err)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package importer
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// unexported
+
+// Reads the tags and ratings of the photos and videos in a Shotwell database.
+// Shotwell lists the media of each tag as 'thumbXXXXXXXXXXXXXXXX' for photos
+// and 'video-XXXXXXXXXXXXXXXX' for videos, with a hexadecimal identifier, and
+// names nested tags by their path, e.g. '/animal/dog'.
+func readShotwell(db *sql.DB) (*Library, error) {
+	library := &Library{}
+	indexes := make(map[string]int) // media identifier to tagging
+
+	media := []struct{ table, prefix string }{{"PhotoTable", "thumb"}, {"VideoTable", "video-"}}
+	for _, medium := range media {
+		rows, err := db.Query(`SELECT id, filename, rating FROM ` + medium.table + ` ORDER BY filename`)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var id int64
+			var rating int
+			tagging := Tagging{}
+			if err := rows.Scan(&id, &tagging.Path, &rating); err != nil {
+				rows.Close()
+				return nil, err
+			}
+
+			if rating > 0 {
+				tagging.Tags = append(tagging.Tags, Tag{"rating", strconv.Itoa(rating)})
+			}
+
+			indexes[fmt.Sprintf("%v%016x", medium.prefix, id)] = len(library.Taggings)
+			library.Taggings = append(library.Taggings, tagging)
+		}
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := db.Query(`SELECT name, photo_id_list FROM TagTable ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var mediaList sql.NullString
+		if err := rows.Scan(&name, &mediaList); err != nil {
+			return nil, err
+		}
+
+		if strings.HasPrefix(name, "/") {
+			name = library.addHierarchy(strings.Split(strings.Trim(name, "/"), "/"))
+		}
+
+		for _, mediumId := range strings.Split(mediaList.String, ",") {
+			if index, ok := indexes[strings.TrimSpace(mediumId)]; ok {
+				library.Taggings[index].Tags = append(library.Taggings[index].Tags, Tag{name, ""})
+			}
+		}
+	}
+
+	return library, rows.Err()
+}
@@ -0,0 +1,130 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package importer
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// unexported
+
+// the directory, alongside the files, in which TagSpaces keeps its sidecars
+const tagSpacesDir = ".ts"
+
+// the sidecar holding the tags of the directory itself
+const tagSpacesDirSidecar = "tsm.json"
+
+type tagSpacesSidecar struct {
+	Tags []struct {
+		Title string `json:"title"`
+	} `json:"tags"`
+}
+
+// Reads the tags TagSpaces keeps in sidecar files, '.ts/FILE.json', and in
+// file names, e.g. 'beach[holiday 2018].jpg'.
+func readTagSpaces(root string) (*Library, error) {
+	library := &Library{}
+
+	err := filepath.Walk(root, func(path string, stat os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if path != root && strings.HasPrefix(stat.Name(), ".") {
+			if stat.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		var sidecarPath string
+		if stat.IsDir() {
+			sidecarPath = filepath.Join(path, tagSpacesDir, tagSpacesDirSidecar)
+		} else {
+			sidecarPath = filepath.Join(filepath.Dir(path), tagSpacesDir, stat.Name()+".json")
+		}
+
+		names, err := readTagSpacesSidecar(sidecarPath)
+		if err != nil {
+			return err
+		}
+
+		if !stat.IsDir() {
+			names = append(names, tagSpacesFileNameTags(stat.Name())...)
+		}
+
+		if len(names) == 0 {
+			return nil
+		}
+
+		tagging := Tagging{Path: path}
+		for _, name := range names {
+			tagging.Tags = append(tagging.Tags, Tag{name, ""})
+		}
+		library.Taggings = append(library.Taggings, tagging)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return library, nil
+}
+
+func readTagSpacesSidecar(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var sidecar tagSpacesSidecar
+	if err := json.Unmarshal(content, &sidecar); err != nil {
+		return nil, &os.PathError{"parse", path, err}
+	}
+
+	names := make([]string, 0, len(sidecar.Tags))
+	for _, tag := range sidecar.Tags {
+		if title := strings.TrimSpace(tag.Title); title != "" {
+			names = append(names, title)
+		}
+	}
+
+	return names, nil
+}
+
+// The tags within square brackets ahead of the extension of a TagSpaces file
+// name, separated by spaces.
+func tagSpacesFileNameTags(name string) []string {
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	if !strings.HasSuffix(name, "]") {
+		return nil
+	}
+
+	start := strings.LastIndex(name, "[")
+	if start == -1 {
+		return nil
+	}
+
+	return strings.Fields(name[start+1 : len(name)-1])
+}
@@ -16,64 +16,245 @@
 package log
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"strings"
+	"sync"
 	"time"
 )
 
-var Verbosity uint = 1
+// The severity of a message: messages are written if at least as severe as the
+// configured level.
+type Level uint
+
+const (
+	ErrorLevel Level = iota
+	WarnLevel
+	InfoLevel
+	DebugLevel
+	TraceLevel
+)
+
+var levelNames = []string{"error", "warn", "info", "debug", "trace"}
+
+func (level Level) String() string {
+	if int(level) < len(levelNames) {
+		return levelNames[level]
+	}
+
+	return fmt.Sprintf("level%v", uint(level))
+}
+
+// Parses the name of a level.
+func ParseLevel(name string) (Level, error) {
+	for index, levelName := range levelNames {
+		if name == levelName {
+			return Level(index), nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid log level '%v': expected one of %v", name, strings.Join(levelNames, ", "))
+}
+
+const (
+	// messages prefixed with 'tmsu: ', as for a terminal
+	TextFormat = "text"
+
+	// a JSON object per message, for log aggregation
+	JsonFormat = "json"
+)
+
+var Formats = []string{TextFormat, JsonFormat}
+
+// Sets the least severe level of message written.
+func SetLevel(newLevel Level) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	level = newLevel
+}
+
+// Whether messages of the level are written.
+func Enabled(messageLevel Level) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return messageLevel <= level
+}
+
+// Sets the format in which messages are written.
+func SetFormat(name string) error {
+	for _, validFormat := range Formats {
+		if name == validFormat {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			format = name
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid log format '%v': expected one of %v", name, strings.Join(Formats, ", "))
+}
+
+// Sets where messages are written. By default, and if nil, informational
+// messages are written to standard output and the rest to standard error.
+func SetOutput(writer io.Writer) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	output = writer
+}
+
+// Adds a field written with every message in the JSON format, e.g. to identify
+// the process to a log aggregator.
+func AddField(name string, value interface{}) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	fields = append(fields, field{name, value})
+}
 
 func Fatal(values ...interface{}) {
-	log(os.Stderr, values...)
+	write(ErrorLevel, sprintln(values...))
 	os.Exit(1)
 }
 
 func Fatalf(format string, values ...interface{}) {
-	logf(os.Stderr, format, values...)
+	write(ErrorLevel, fmt.Sprintf(format, values...))
 	os.Exit(1)
 }
 
+// Reports an error, which unlike a warning is shown even at the error level.
+func Error(values ...interface{}) {
+	log(ErrorLevel, values...)
+}
+
 func Warn(values ...interface{}) {
-	log(os.Stderr, values...)
+	log(WarnLevel, values...)
 }
 
 func Warnf(format string, values ...interface{}) {
-	logf(os.Stderr, format, values...)
+	logf(WarnLevel, format, values...)
+}
+
+// Reports progress that the user is shown by default.
+func Info(values ...interface{}) {
+	log(InfoLevel, values...)
+}
+
+func Infof(format string, values ...interface{}) {
+	logf(InfoLevel, format, values...)
+}
+
+// Reports the detail of an operation, shown with --verbose.
+func Debug(values ...interface{}) {
+	log(DebugLevel, values...)
+}
+
+func Debugf(format string, values ...interface{}) {
+	logf(DebugLevel, format, values...)
+}
+
+// Reports the finest detail, such as the SQL executed.
+func Trace(values ...interface{}) {
+	log(TraceLevel, values...)
+}
+
+func Tracef(format string, values ...interface{}) {
+	logf(TraceLevel, format, values...)
+}
+
+// unexported
+
+type field struct {
+	name  string
+	value interface{}
 }
 
-func Info(verbosity uint, values ...interface{}) {
-	if verbosity > Verbosity {
+var mutex sync.Mutex
+var level = InfoLevel
+var format = TextFormat
+var output io.Writer
+var fields []field
+
+func log(messageLevel Level, values ...interface{}) {
+	if !Enabled(messageLevel) {
 		return
 	}
 
-	log(os.Stdout, values...)
+	write(messageLevel, sprintln(values...))
 }
 
-func Infof(verbosity uint, format string, values ...interface{}) {
-	if verbosity > Verbosity {
+func logf(messageLevel Level, format string, values ...interface{}) {
+	if !Enabled(messageLevel) {
 		return
 	}
 
-	logf(os.Stdout, format, values...)
+	write(messageLevel, fmt.Sprintf(format, values...))
 }
 
-// unexported
+// Formats the values as fmt.Println would, without the newline.
+func sprintln(values ...interface{}) string {
+	message := fmt.Sprintln(values...)
+	return message[:len(message)-1]
+}
+
+func write(messageLevel Level, message string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	dest := output
+	if dest == nil {
+		if messageLevel == InfoLevel || messageLevel > WarnLevel {
+			dest = os.Stdout
+		} else {
+			dest = os.Stderr
+		}
+	}
+
+	now := time.Now()
+
+	var buffer bytes.Buffer
+	switch format {
+	case JsonFormat:
+		buffer.WriteString(`{"time":`)
+		writeJson(&buffer, now.Format(time.RFC3339Nano))
+		buffer.WriteString(`,"level":`)
+		writeJson(&buffer, messageLevel.String())
+		buffer.WriteString(`,"message":`)
+		writeJson(&buffer, message)
+
+		for _, field := range fields {
+			buffer.WriteString(",")
+			writeJson(&buffer, field.name)
+			buffer.WriteString(":")
+			writeJson(&buffer, field.value)
+		}
+
+		buffer.WriteString("}\n")
+	default:
+		// a log file is read after the fact so is always timestamped
+		if level >= DebugLevel || output != nil {
+			fmt.Fprintf(&buffer, "%v %v: ", now.Format(time.RFC3339Nano), messageLevel)
+		}
 
-func log(dest io.Writer, values ...interface{}) {
-	if Verbosity > 1 {
-		fmt.Fprintf(dest, "%v: ", time.Now())
+		fmt.Fprintf(&buffer, "tmsu: %v\n", message)
 	}
 
-	fmt.Fprintf(dest, "tmsu: ")
-	fmt.Fprintln(dest, values...)
+	// written whole so that the messages of concurrent processes sharing a log
+	// file are not interleaved
+	dest.Write(buffer.Bytes())
 }
 
-func logf(dest io.Writer, format string, values ...interface{}) {
-	if Verbosity > 1 {
-		fmt.Printf("%v: ", time.Now())
+func writeJson(buffer *bytes.Buffer, value interface{}) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprint(value))
 	}
 
-	format = "tmsu: " + format + "\n"
-	fmt.Fprintf(dest, format, values...)
+	buffer.Write(encoded)
 }
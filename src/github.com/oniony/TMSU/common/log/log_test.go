@@ -0,0 +1,82 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(test *testing.T) {
+	level, err := ParseLevel("debug")
+	if err != nil || level != DebugLevel {
+		test.Fatalf("expected debug level but got %v: %v", level, err)
+	}
+
+	if _, err := ParseLevel("loud"); err == nil {
+		test.Fatal("expected an error for an invalid level")
+	}
+}
+
+func TestLevelFiltersMessages(test *testing.T) {
+	var buffer bytes.Buffer
+	configure(test, &buffer, WarnLevel, TextFormat)
+
+	Info("not shown")
+	Warnf("shown %v", 1)
+	Error("also", "shown")
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], " warn: tmsu: shown 1") || !strings.HasSuffix(lines[1], " error: tmsu: also shown") {
+		test.Fatalf("unexpected output: %q", buffer.String())
+	}
+}
+
+func TestJsonFormat(test *testing.T) {
+	var buffer bytes.Buffer
+	configure(test, &buffer, DebugLevel, JsonFormat)
+	AddField("command", "mount")
+
+	Debugf("mounting '%v'", "mp")
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buffer.Bytes(), &record); err != nil {
+		test.Fatalf("could not parse output %q: %v", buffer.String(), err)
+	}
+
+	if record["level"] != "debug" || record["message"] != "mounting 'mp'" || record["command"] != "mount" || record["time"] == nil {
+		test.Fatalf("unexpected record: %v", record)
+	}
+}
+
+// unexported
+
+func configure(test *testing.T, buffer *bytes.Buffer, newLevel Level, newFormat string) {
+	SetLevel(newLevel)
+	SetOutput(buffer)
+	if err := SetFormat(newFormat); err != nil {
+		test.Fatal(err)
+	}
+
+	test.Cleanup(func() {
+		SetLevel(InfoLevel)
+		SetOutput(nil)
+		SetFormat(TextFormat)
+		fields = nil
+	})
+}
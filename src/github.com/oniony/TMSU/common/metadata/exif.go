@@ -0,0 +1,186 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+const (
+	exifMakeTag             = 0x010F
+	exifModelTag            = 0x0110
+	exifDateTimeTag         = 0x0132
+	exifArtistTag           = 0x013B
+	exifIfdPointerTag       = 0x8769
+	exifDateTimeOriginalTag = 0x9003
+)
+
+const exifAsciiType = 2
+const exifDateFormat = "2006:01:02 15:04:05"
+const maxTiffSize = 64 * 1024 * 1024
+
+// unexported
+
+func extractJpeg(reader io.Reader) (Fields, error) {
+	buffered := bufio.NewReader(reader)
+
+	soi := make([]byte, 2)
+	if _, err := io.ReadFull(buffered, soi); err != nil {
+		return nil, err
+	}
+
+	for {
+		marker := make([]byte, 4)
+		if _, err := io.ReadFull(buffered, marker); err != nil {
+			// no EXIF segment
+			return Fields{}, nil
+		}
+		if marker[0] != 0xFF {
+			return nil, fmt.Errorf("corrupt JPEG segment marker")
+		}
+
+		// start of scan: image data follows
+		if marker[1] == 0xDA {
+			return Fields{}, nil
+		}
+
+		length := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if length < 0 {
+			return nil, fmt.Errorf("corrupt JPEG segment length")
+		}
+
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(buffered, segment); err != nil {
+			return nil, err
+		}
+
+		if marker[1] == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return parseTiff(segment[6:])
+		}
+	}
+}
+
+func extractTiff(reader io.Reader) (Fields, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(reader, maxTiffSize))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseTiff(data)
+}
+
+func parseTiff(data []byte) (Fields, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("truncated TIFF header")
+	}
+
+	var order binary.ByteOrder
+	switch string(data[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid TIFF byte order")
+	}
+
+	entries := make(map[uint16]string)
+
+	ifdOffset := order.Uint32(data[4:8])
+	exifOffset, err := readIfd(data, order, ifdOffset, entries)
+	if err != nil {
+		return nil, err
+	}
+	if exifOffset != 0 {
+		if _, err := readIfd(data, order, exifOffset, entries); err != nil {
+			return nil, err
+		}
+	}
+
+	fields := make(Fields)
+
+	manufacturer := strings.TrimSpace(entries[exifMakeTag])
+	model := strings.TrimSpace(entries[exifModelTag])
+	camera := make([]string, 0, 2)
+	if manufacturer != "" && !strings.HasPrefix(model, manufacturer) {
+		camera = append(camera, manufacturer)
+	}
+	if model != "" {
+		camera = append(camera, model)
+	}
+	fields.set(Camera, strings.Join(camera, " "))
+
+	fields.set(Artist, entries[exifArtistTag])
+
+	for _, tag := range []uint16{exifDateTimeOriginalTag, exifDateTimeTag} {
+		taken, err := time.Parse(exifDateFormat, entries[tag])
+		if err == nil {
+			fields.set(Date, taken.Format("2006-01-02"))
+			fields.set(Year, taken.Format("2006"))
+		}
+	}
+
+	return fields, nil
+}
+
+// reads the ASCII entries of an image file directory, returning the offset of the EXIF sub-directory, if any
+func readIfd(data []byte, order binary.ByteOrder, offset uint32, entries map[uint16]string) (uint32, error) {
+	if int(offset)+2 > len(data) {
+		return 0, fmt.Errorf("truncated TIFF directory")
+	}
+
+	count := int(order.Uint16(data[offset:]))
+	var exifOffset uint32
+
+	for index := 0; index < count; index++ {
+		start := int(offset) + 2 + index*12
+		if start+12 > len(data) {
+			return 0, fmt.Errorf("truncated TIFF directory entry")
+		}
+		entry := data[start : start+12]
+
+		tag := order.Uint16(entry[0:2])
+		entryType := order.Uint16(entry[2:4])
+		length := order.Uint32(entry[4:8])
+
+		switch {
+		case tag == exifIfdPointerTag:
+			exifOffset = order.Uint32(entry[8:12])
+		case entryType == exifAsciiType:
+			var value []byte
+			if length <= 4 {
+				value = entry[8 : 8+length]
+			} else {
+				valueOffset := order.Uint32(entry[8:12])
+				if uint64(valueOffset)+uint64(length) > uint64(len(data)) {
+					continue
+				}
+				value = data[valueOffset : valueOffset+length]
+			}
+
+			entries[tag] = string(bytes.TrimRight(value, "\x00"))
+		}
+	}
+
+	return exifOffset, nil
+}
@@ -0,0 +1,186 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf16"
+)
+
+var id3FrameFields = map[string]string{
+	"TPE1": Artist, "TP1": Artist,
+	"TALB": Album, "TAL": Album,
+	"TIT2": Title, "TT2": Title,
+	"TYER": Year, "TYE": Year,
+	"TDRC": Year,
+	"TCON": Genre, "TCO": Genre,
+}
+
+// unexported
+
+func extractId3(file *os.File) (Fields, error) {
+	fields := make(Fields)
+
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(file, header); err == nil && string(header[0:3]) == "ID3" {
+		size := syncsafe(header[6:10])
+		tag := make([]byte, size)
+		if _, err := io.ReadFull(file, tag); err != nil {
+			return nil, err
+		}
+
+		parseId3v2(header[3], header[5], tag, fields)
+	}
+
+	// fall back to an ID3v1 tag at the end of the file for anything missing
+	if _, err := file.Seek(-128, io.SeekEnd); err == nil {
+		trailer := make([]byte, 128)
+		if _, err := io.ReadFull(file, trailer); err == nil && string(trailer[0:3]) == "TAG" {
+			fields.set(Title, latin1(trailer[3:33]))
+			fields.set(Artist, latin1(trailer[33:63]))
+			fields.set(Album, latin1(trailer[63:93]))
+			fields.set(Year, latin1(trailer[93:97]))
+		}
+	}
+
+	return fields, nil
+}
+
+func parseId3v2(version, flags byte, tag []byte, fields Fields) {
+	idLength, headerLength := 4, 10
+	if version == 2 {
+		idLength, headerLength = 3, 6
+	}
+
+	offset := 0
+	if flags&0x40 != 0 && version > 2 && len(tag) >= 4 {
+		// skip the extended header
+		if version == 4 {
+			offset = int(syncsafe(tag[0:4]))
+		} else {
+			offset = int(binary.BigEndian.Uint32(tag[0:4])) + 4
+		}
+	}
+
+	for offset+headerLength <= len(tag) {
+		id := string(tag[offset : offset+idLength])
+		if id[0] == 0 {
+			// padding
+			break
+		}
+
+		var size int
+		switch version {
+		case 2:
+			size = int(tag[offset+3])<<16 | int(tag[offset+4])<<8 | int(tag[offset+5])
+		case 4:
+			size = int(syncsafe(tag[offset+4 : offset+8]))
+		default:
+			size = int(binary.BigEndian.Uint32(tag[offset+4 : offset+8]))
+		}
+
+		start := offset + headerLength
+		end := start + size
+		if size <= 0 || end > len(tag) {
+			break
+		}
+
+		if field, ok := id3FrameFields[id]; ok {
+			value := decodeId3Text(tag[start:end])
+
+			switch field {
+			case Year:
+				if len(value) > 4 {
+					value = value[0:4]
+				}
+			case Genre:
+				value = strings.TrimLeft(value[strings.LastIndex(value, ")")+1:], " ")
+			}
+
+			fields.set(field, value)
+		}
+
+		offset = end
+	}
+}
+
+func decodeId3Text(frame []byte) string {
+	if len(frame) == 0 {
+		return ""
+	}
+
+	text := frame[1:]
+
+	switch frame[0] {
+	case 1, 2:
+		return utf16String(text, frame[0] == 2)
+	case 3:
+		return string(bytes.TrimRight(text, "\x00"))
+	default:
+		return latin1(text)
+	}
+}
+
+func utf16String(data []byte, bigEndian bool) string {
+	if len(data) >= 2 {
+		switch {
+		case data[0] == 0xFE && data[1] == 0xFF:
+			bigEndian = true
+			data = data[2:]
+		case data[0] == 0xFF && data[1] == 0xFE:
+			bigEndian = false
+			data = data[2:]
+		}
+	}
+
+	units := make([]uint16, 0, len(data)/2)
+	for index := 0; index+1 < len(data); index += 2 {
+		var unit uint16
+		if bigEndian {
+			unit = binary.BigEndian.Uint16(data[index:])
+		} else {
+			unit = binary.LittleEndian.Uint16(data[index:])
+		}
+		if unit == 0 {
+			break
+		}
+
+		units = append(units, unit)
+	}
+
+	return string(utf16.Decode(units))
+}
+
+func latin1(data []byte) string {
+	runes := make([]rune, 0, len(data))
+	for _, b := range data {
+		if b == 0 {
+			break
+		}
+
+		runes = append(runes, rune(b))
+	}
+
+	return string(runes)
+}
+
+func syncsafe(data []byte) uint32 {
+	return uint32(data[0]&0x7F)<<21 | uint32(data[1]&0x7F)<<14 | uint32(data[2]&0x7F)<<7 | uint32(data[3]&0x7F)
+}
@@ -0,0 +1,87 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package metadata
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// The metadata fields that can be extracted.
+const (
+	Album  = "album"
+	Artist = "artist"
+	Author = "author"
+	Camera = "camera"
+	Date   = "date"
+	Genre  = "genre"
+	Title  = "title"
+	Year   = "year"
+)
+
+// Metadata field values by field name.
+type Fields map[string]string
+
+// Extracts metadata from the file at the specified path.
+//
+// EXIF is read from JPEG and TIFF images, ID3 tags from audio files and the
+// document information dictionary from PDFs. Files of other types yield no
+// fields.
+func Extract(path string) (Fields, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header := make([]byte, 8)
+	count, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, fmt.Errorf("%v: could not read file: %v", path, err)
+	}
+	header = header[:count]
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8}):
+		return extractJpeg(file)
+	case bytes.HasPrefix(header, []byte("II*\x00")), bytes.HasPrefix(header, []byte("MM\x00*")):
+		return extractTiff(file)
+	case bytes.HasPrefix(header, []byte("%PDF")):
+		return extractPdf(file)
+	default:
+		return extractId3(file)
+	}
+}
+
+// unexported
+
+func (fields Fields) set(name, value string) {
+	value = strings.TrimSpace(strings.Trim(value, "\x00"))
+	if value == "" {
+		return
+	}
+
+	if _, exists := fields[name]; !exists {
+		fields[name] = value
+	}
+}
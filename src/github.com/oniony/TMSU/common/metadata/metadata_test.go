@@ -0,0 +1,186 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestId3v2Extraction(test *testing.T) {
+	// set-up
+
+	frames := new(bytes.Buffer)
+	writeId3Frame(frames, "TPE1", "\x03Nina Simone")
+	writeId3Frame(frames, "TALB", "\x00Pastel Blues")
+	writeId3Frame(frames, "TYER", "\x001965")
+
+	data := append([]byte{'I', 'D', '3', 3, 0, 0}, syncsafeBytes(frames.Len())...)
+	data = append(data, frames.Bytes()...)
+
+	// test
+
+	fields := extractFromBytes(test, "tmsu-metadata.mp3", data)
+
+	// validate
+
+	expectField(test, fields, Artist, "Nina Simone")
+	expectField(test, fields, Album, "Pastel Blues")
+	expectField(test, fields, Year, "1965")
+}
+
+func TestExifExtraction(test *testing.T) {
+	// set-up
+
+	text := []byte("Canon\x00Canon EOS 5D\x002009:06:21 14:02:11\x00")
+	stringsOffset := uint32(8 + 2 + 3*12 + 4)
+
+	tiff := new(bytes.Buffer)
+	tiff.WriteString("II*\x00")
+	binary.Write(tiff, binary.LittleEndian, uint32(8))
+	binary.Write(tiff, binary.LittleEndian, uint16(3))
+	writeIfdEntry(tiff, exifMakeTag, 6, stringsOffset)
+	writeIfdEntry(tiff, exifModelTag, 13, stringsOffset+6)
+	writeIfdEntry(tiff, exifDateTimeTag, 20, stringsOffset+19)
+	binary.Write(tiff, binary.LittleEndian, uint32(0))
+	tiff.Write(text)
+
+	app1 := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	data := []byte{0xFF, 0xD8, 0xFF, 0xE1}
+	data = append(data, byte((len(app1)+2)>>8), byte(len(app1)+2))
+	data = append(data, app1...)
+	data = append(data, 0xFF, 0xD9)
+
+	// test
+
+	fields := extractFromBytes(test, "tmsu-metadata.jpg", data)
+
+	// validate
+
+	expectField(test, fields, Camera, "Canon EOS 5D")
+	expectField(test, fields, Year, "2009")
+	expectField(test, fields, Date, "2009-06-21")
+}
+
+func TestExifExtractionWithoutModel(test *testing.T) {
+	// set-up
+
+	text := []byte("NIKON CORPORATION \x00")
+	stringsOffset := uint32(8 + 2 + 1*12 + 4)
+
+	tiff := new(bytes.Buffer)
+	tiff.WriteString("II*\x00")
+	binary.Write(tiff, binary.LittleEndian, uint32(8))
+	binary.Write(tiff, binary.LittleEndian, uint16(1))
+	writeIfdEntry(tiff, exifMakeTag, 19, stringsOffset)
+	binary.Write(tiff, binary.LittleEndian, uint32(0))
+	tiff.Write(text)
+
+	// test
+
+	fields := extractFromBytes(test, "tmsu-metadata.tif", tiff.Bytes())
+
+	// validate
+
+	expectField(test, fields, Camera, "NIKON CORPORATION")
+}
+
+func TestPdfExtraction(test *testing.T) {
+	// set-up
+
+	data := []byte("%PDF-1.4\n1 0 obj\n<< /Title (On Computable Numbers) /Author (Alan Turing) /CreationDate (D:19361112000000) >>\nendobj\n%%EOF\n")
+
+	// test
+
+	fields := extractFromBytes(test, "tmsu-metadata.pdf", data)
+
+	// validate
+
+	expectField(test, fields, Author, "Alan Turing")
+	expectField(test, fields, Title, "On Computable Numbers")
+	expectField(test, fields, Year, "1936")
+}
+
+func TestMimeTypeDetection(test *testing.T) {
+	expectMimeType(test, "tmsu-mime.dat", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"), "image/png", "image")
+	expectMimeType(test, "tmsu-mime.dat", []byte("fLaC\x00\x00\x00\x22"), "audio/flac", "audio")
+	expectMimeType(test, "tmsu-mime.txt", []byte("hello, world\n"), "text/plain", "")
+}
+
+// unexported
+
+func expectMimeType(test *testing.T, name string, data []byte, expectedMimeType, expectedCategory string) {
+	path := filepath.Join(os.TempDir(), name)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		test.Fatal(err.Error())
+	}
+	defer os.Remove(path)
+
+	mimeType, err := DetectMimeType(path)
+	if err != nil {
+		test.Fatal(err.Error())
+	}
+
+	if mimeType != expectedMimeType {
+		test.Fatalf("MIME type incorrect: expected '%v' but was '%v'", expectedMimeType, mimeType)
+	}
+	if category := MediaCategory(mimeType); category != expectedCategory {
+		test.Fatalf("Category incorrect: expected '%v' but was '%v'", expectedCategory, category)
+	}
+}
+
+func extractFromBytes(test *testing.T, name string, data []byte) Fields {
+	path := filepath.Join(os.TempDir(), name)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		test.Fatal(err.Error())
+	}
+	defer os.Remove(path)
+
+	fields, err := Extract(path)
+	if err != nil {
+		test.Fatal(err.Error())
+	}
+
+	return fields
+}
+
+func expectField(test *testing.T, fields Fields, name, expected string) {
+	if fields[name] != expected {
+		test.Fatalf("Field '%v' incorrect: expected '%v' but was '%v'", name, expected, fields[name])
+	}
+}
+
+func writeId3Frame(buffer *bytes.Buffer, id, content string) {
+	buffer.WriteString(id)
+	binary.Write(buffer, binary.BigEndian, uint32(len(content)))
+	buffer.Write([]byte{0, 0})
+	buffer.WriteString(content)
+}
+
+func writeIfdEntry(buffer *bytes.Buffer, tag uint16, length, offset uint32) {
+	binary.Write(buffer, binary.LittleEndian, tag)
+	binary.Write(buffer, binary.LittleEndian, uint16(exifAsciiType))
+	binary.Write(buffer, binary.LittleEndian, length)
+	binary.Write(buffer, binary.LittleEndian, offset)
+}
+
+func syncsafeBytes(size int) []byte {
+	return []byte{byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F), byte(size >> 7 & 0x7F), byte(size & 0x7F)}
+}
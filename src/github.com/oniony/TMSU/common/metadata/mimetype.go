@@ -0,0 +1,95 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package metadata
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const sniffLength = 512
+const unknownMimeType = "application/octet-stream"
+
+// Detects the MIME type of the file at the specified path from its content.
+//
+// The file extension is consulted only where the content is not recognised.
+func DetectMimeType(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	header := make([]byte, sniffLength)
+	count, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	header = header[:count]
+
+	mimeType := sniffMimeType(header)
+	if mimeType == unknownMimeType {
+		if byExtension := mime.TypeByExtension(filepath.Ext(path)); byExtension != "" {
+			mimeType = byExtension
+		}
+	}
+
+	if index := strings.Index(mimeType, ";"); index != -1 {
+		mimeType = strings.TrimSpace(mimeType[:index])
+	}
+
+	return mimeType, nil
+}
+
+// The coarse category of a MIME type, e.g. 'image', or an empty string for types without one.
+func MediaCategory(mimeType string) string {
+	category := strings.SplitN(mimeType, "/", 2)[0]
+
+	switch category {
+	case "audio", "image", "video":
+		return category
+	case "application":
+		if mimeType == "application/ogg" {
+			return "audio"
+		}
+	}
+
+	return ""
+}
+
+// unexported
+
+func sniffMimeType(header []byte) string {
+	// formats not recognised by the standard library
+	switch {
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		return "audio/flac"
+	case bytes.HasPrefix(header, []byte("II*\x00")), bytes.HasPrefix(header, []byte("MM\x00*")):
+		return "image/tiff"
+	case len(header) >= 12 && string(header[4:8]) == "ftyp" && string(header[8:11]) == "M4A":
+		return "audio/mp4"
+	case len(header) >= 2 && header[0] == 0xFF && header[1]&0xE0 == 0xE0 && header[1]&0x06 != 0:
+		// MPEG audio frame sync without an ID3 header
+		return "audio/mpeg"
+	}
+
+	return http.DetectContentType(header)
+}
@@ -0,0 +1,149 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package metadata
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"os"
+	"regexp"
+)
+
+// the document information dictionary is normally near the start or the end of the file
+const pdfScanSize = 256 * 1024
+
+var pdfAuthorPattern = regexp.MustCompile(`/Author\s*(\((?:\\.|[^\\)])*\)|<[0-9A-Fa-f\s]*>)`)
+var pdfTitlePattern = regexp.MustCompile(`/Title\s*(\((?:\\.|[^\\)])*\)|<[0-9A-Fa-f\s]*>)`)
+var pdfCreationDatePattern = regexp.MustCompile(`/CreationDate\s*\(D:(\d{4})`)
+
+// unexported
+
+func extractPdf(file *os.File) (Fields, error) {
+	data, err := readHeadAndTail(file, pdfScanSize)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(Fields)
+
+	if match := pdfAuthorPattern.FindSubmatch(data); match != nil {
+		fields.set(Author, decodePdfString(match[1]))
+	}
+	if match := pdfTitlePattern.FindSubmatch(data); match != nil {
+		fields.set(Title, decodePdfString(match[1]))
+	}
+	if match := pdfCreationDatePattern.FindSubmatch(data); match != nil {
+		fields.set(Year, string(match[1]))
+	}
+
+	return fields, nil
+}
+
+func readHeadAndTail(file *os.File, size int64) ([]byte, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if stat.Size() <= 2*size {
+		buffer := make([]byte, stat.Size())
+		_, err := io.ReadFull(file, buffer)
+		return buffer, err
+	}
+
+	buffer := make([]byte, 2*size)
+	if _, err := io.ReadFull(file, buffer[:size]); err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(-size, io.SeekEnd); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(file, buffer[size:]); err != nil {
+		return nil, err
+	}
+
+	return buffer, nil
+}
+
+func decodePdfString(token []byte) string {
+	var data []byte
+
+	if token[0] == '<' {
+		digits := bytes.Map(func(r rune) rune {
+			if r == '<' || r == '>' || r == ' ' || r == '\n' || r == '\r' || r == '\t' {
+				return -1
+			}
+			return r
+		}, token)
+		if len(digits)%2 == 1 {
+			digits = append(digits, '0')
+		}
+
+		decoded, err := hex.DecodeString(string(digits))
+		if err != nil {
+			return ""
+		}
+		data = decoded
+	} else {
+		data = unescapePdfLiteral(token[1 : len(token)-1])
+	}
+
+	if bytes.HasPrefix(data, []byte{0xFE, 0xFF}) {
+		return utf16String(data, true)
+	}
+
+	return latin1(data)
+}
+
+func unescapePdfLiteral(literal []byte) []byte {
+	result := make([]byte, 0, len(literal))
+
+	for index := 0; index < len(literal); index++ {
+		b := literal[index]
+		if b != '\\' || index+1 == len(literal) {
+			result = append(result, b)
+			continue
+		}
+
+		index++
+		switch literal[index] {
+		case 'n':
+			result = append(result, '\n')
+		case 'r':
+			result = append(result, '\r')
+		case 't':
+			result = append(result, '\t')
+		case 'b':
+			result = append(result, '\b')
+		case 'f':
+			result = append(result, '\f')
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			value := 0
+			digits := 0
+			for ; digits < 3 && index < len(literal) && literal[index] >= '0' && literal[index] <= '7'; digits++ {
+				value = value*8 + int(literal[index]-'0')
+				index++
+			}
+			index--
+			result = append(result, byte(value))
+		default:
+			result = append(result, literal[index])
+		}
+	}
+
+	return result
+}
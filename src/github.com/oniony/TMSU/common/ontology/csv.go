@@ -0,0 +1,60 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ontology
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// unexported
+
+// Reads relations from CSV of broader,narrower pairs. A header row naming the
+// columns 'broader' and 'narrower' is skipped.
+func readCsv(reader io.Reader) (Relations, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.Comment = '#'
+	csvReader.FieldsPerRecord = 2
+	csvReader.TrimLeadingSpace = true
+
+	relations := make(Relations, 0, 100)
+	for row := 1; ; row++ {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		broader := strings.TrimSpace(record[0])
+		narrower := strings.TrimSpace(record[1])
+
+		if row == 1 && strings.EqualFold(broader, "broader") && strings.EqualFold(narrower, "narrower") {
+			continue
+		}
+
+		if broader == "" || narrower == "" {
+			return nil, fmt.Errorf("row %v: missing concept", row)
+		}
+
+		relations = append(relations, Relation{broader, narrower})
+	}
+
+	return relations, nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ontology
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// The supported ontology formats.
+const (
+	CsvFormat  = "csv"
+	SkosFormat = "skos"
+)
+
+// A hierarchical relation between two concepts, e.g. 'dog' is broader than 'beagle'.
+type Relation struct {
+	Broader  string
+	Narrower string
+}
+
+type Relations []Relation
+
+// Reads the hierarchical relations from an ontology in the specified format.
+func Read(reader io.Reader, format string) (Relations, error) {
+	switch format {
+	case CsvFormat:
+		return readCsv(reader)
+	case SkosFormat:
+		return readSkos(reader)
+	}
+
+	return nil, fmt.Errorf("unsupported ontology format '%v': expected '%v' or '%v'", format, CsvFormat, SkosFormat)
+}
+
+// Identifies the ontology format from the file extension.
+func FormatFor(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return CsvFormat, nil
+	case ".rdf", ".xml", ".skos":
+		return SkosFormat, nil
+	}
+
+	return "", fmt.Errorf("%v: cannot determine ontology format from file extension", path)
+}
@@ -0,0 +1,122 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ontology
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCsvRelations(test *testing.T) {
+	// set-up
+
+	text := `broader,narrower
+# mammals
+animal,dog
+dog, beagle
+`
+
+	// test
+
+	relations, err := Read(strings.NewReader(text), CsvFormat)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	expectRelations(test, relations, Relations{{"animal", "dog"}, {"dog", "beagle"}})
+}
+
+func TestCsvMissingConcept(test *testing.T) {
+	// test
+
+	_, err := Read(strings.NewReader("animal,\n"), CsvFormat)
+
+	// validate
+
+	if err == nil {
+		test.Fatal("expected error for missing concept")
+	}
+}
+
+func TestSkosRelations(test *testing.T) {
+	// set-up
+
+	text := `<?xml version="1.0"?>
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+         xmlns:skos="http://www.w3.org/2004/02/skos/core#">
+  <skos:Concept rdf:about="http://example.com/animal">
+    <skos:prefLabel xml:lang="fr">animal</skos:prefLabel>
+    <skos:prefLabel xml:lang="en">creature</skos:prefLabel>
+    <skos:narrower rdf:resource="http://example.com/dog"/>
+  </skos:Concept>
+  <skos:Concept rdf:about="http://example.com/dog">
+    <skos:prefLabel>dog</skos:prefLabel>
+    <skos:broader rdf:resource="http://example.com/animal"/>
+  </skos:Concept>
+  <rdf:Description rdf:about="http://example.com/beagle">
+    <skos:prefLabel>beagle</skos:prefLabel>
+    <skos:broader rdf:resource="http://example.com/dog"/>
+    <skos:broader rdf:resource="http://elsewhere.com/hound"/>
+  </rdf:Description>
+</rdf:RDF>`
+
+	// test
+
+	relations, err := Read(strings.NewReader(text), SkosFormat)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	expectRelations(test, relations, Relations{{"creature", "dog"}, {"dog", "beagle"}})
+}
+
+func TestFormatFor(test *testing.T) {
+	// test
+
+	csvFormat, csvErr := FormatFor("terms.CSV")
+	skosFormat, skosErr := FormatFor("thesaurus.rdf")
+	_, unknownErr := FormatFor("terms.txt")
+
+	// validate
+
+	if csvErr != nil || csvFormat != CsvFormat {
+		test.Fatalf("expected '%v' but got '%v' (%v)", CsvFormat, csvFormat, csvErr)
+	}
+	if skosErr != nil || skosFormat != SkosFormat {
+		test.Fatalf("expected '%v' but got '%v' (%v)", SkosFormat, skosFormat, skosErr)
+	}
+	if unknownErr == nil {
+		test.Fatal("expected error for unknown extension")
+	}
+}
+
+// unexported
+
+func expectRelations(test *testing.T, actual, expected Relations) {
+	if len(actual) != len(expected) {
+		test.Fatalf("expected %v relations but got %v: %v", len(expected), len(actual), actual)
+	}
+
+	for index := range expected {
+		if actual[index] != expected[index] {
+			test.Fatalf("expected relation %v to be %v but was %v", index, expected[index], actual[index])
+		}
+	}
+}
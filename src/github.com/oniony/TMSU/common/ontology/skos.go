@@ -0,0 +1,182 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ontology
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// unexported
+
+const (
+	rdfNamespace  = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	skosNamespace = "http://www.w3.org/2004/02/skos/core#"
+	xmlNamespace  = "http://www.w3.org/XML/1998/namespace"
+)
+
+type skosConcept struct {
+	label     string
+	labelLang string
+	broader   []string
+	narrower  []string
+}
+
+// Reads relations from SKOS concepts in RDF/XML, taking skos:broader and
+// skos:narrower references between concepts and naming each concept by its
+// skos:prefLabel, preferring an English label where there are several.
+func readSkos(reader io.Reader) (Relations, error) {
+	decoder := xml.NewDecoder(reader)
+
+	concepts := make(map[string]*skosConcept)
+	order := make([]string, 0, 100)
+
+	var current *skosConcept
+	var inLabel bool
+	var labelLang string
+	var label strings.Builder
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			switch {
+			case isConceptElement(element):
+				about := attribute(element, rdfNamespace, "about")
+				if about == "" {
+					return nil, fmt.Errorf("concept without rdf:about")
+				}
+
+				concept, ok := concepts[about]
+				if !ok {
+					concept = &skosConcept{}
+					concepts[about] = concept
+					order = append(order, about)
+				}
+
+				current = concept
+			case current == nil:
+			case element.Name.Space == skosNamespace && element.Name.Local == "prefLabel":
+				inLabel = true
+				labelLang = attribute(element, xmlNamespace, "lang")
+				label.Reset()
+			case element.Name.Space == skosNamespace && element.Name.Local == "broader":
+				current.broader = append(current.broader, attribute(element, rdfNamespace, "resource"))
+			case element.Name.Space == skosNamespace && element.Name.Local == "narrower":
+				current.narrower = append(current.narrower, attribute(element, rdfNamespace, "resource"))
+			}
+		case xml.CharData:
+			if inLabel {
+				label.Write(element)
+			}
+		case xml.EndElement:
+			switch {
+			case inLabel:
+				inLabel = false
+
+				text := strings.TrimSpace(label.String())
+				if text != "" && (current.label == "" || isPreferredLang(labelLang, current.labelLang)) {
+					current.label = text
+					current.labelLang = labelLang
+				}
+			case isConceptElement(xml.StartElement{Name: element.Name}):
+				current = nil
+			}
+		}
+	}
+
+	relations := make(Relations, 0, len(order))
+	seen := make(map[Relation]bool)
+	addRelation := func(broaderAbout, narrowerAbout string) error {
+		broader, narrower := concepts[broaderAbout], concepts[narrowerAbout]
+		if broader == nil || narrower == nil {
+			// reference to a concept outside of this scheme
+			return nil
+		}
+		if broader.label == "" {
+			return fmt.Errorf("concept '%v' has no prefLabel", broaderAbout)
+		}
+		if narrower.label == "" {
+			return fmt.Errorf("concept '%v' has no prefLabel", narrowerAbout)
+		}
+
+		relation := Relation{broader.label, narrower.label}
+		if !seen[relation] {
+			seen[relation] = true
+			relations = append(relations, relation)
+		}
+
+		return nil
+	}
+
+	for _, about := range order {
+		concept := concepts[about]
+
+		for _, broaderAbout := range concept.broader {
+			if err := addRelation(broaderAbout, about); err != nil {
+				return nil, err
+			}
+		}
+		for _, narrowerAbout := range concept.narrower {
+			if err := addRelation(about, narrowerAbout); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return relations, nil
+}
+
+func isConceptElement(element xml.StartElement) bool {
+	return (element.Name.Space == skosNamespace && element.Name.Local == "Concept") ||
+		(element.Name.Space == rdfNamespace && element.Name.Local == "Description")
+}
+
+func attribute(element xml.StartElement, space, local string) string {
+	for _, attr := range element.Attr {
+		if attr.Name.Space == space && attr.Name.Local == local {
+			return attr.Value
+		}
+	}
+
+	return ""
+}
+
+// Determines whether a label in language lang is preferable to one in
+// currentLang: English first, then labels without a language.
+func isPreferredLang(lang, currentLang string) bool {
+	rank := func(lang string) int {
+		switch {
+		case lang == "en" || strings.HasPrefix(lang, "en-"):
+			return 0
+		case lang == "":
+			return 1
+		}
+
+		return 2
+	}
+
+	return rank(lang) < rank(currentLang)
+}
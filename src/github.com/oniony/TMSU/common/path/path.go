@@ -29,6 +29,25 @@ type FileSystemFile struct {
 	IsDir bool
 }
 
+// Describes how paths are written in command output: relative to the working
+// directory unless Absolute is set or RelativeTo names another directory.
+type Format struct {
+	Absolute   bool
+	RelativeTo string
+}
+
+// Formats the specified absolute path.
+func (format Format) Path(path string) string {
+	switch {
+	case format.Absolute:
+		return path
+	case format.RelativeTo != "":
+		return RelTo(path, format.RelativeTo)
+	default:
+		return Rel(path)
+	}
+}
+
 func IsRoot(path string) bool {
 	return filepath.Dir(path) == path
 }
@@ -55,19 +74,19 @@ func RelTo(path, to string) string {
 		panic("could not get absolute path")
 	}
 
-	if path == to {
+	if Equal(path, to) {
 		return "."
 	}
 
 	prefix := trailingSeparator(to)
-	if strings.HasPrefix(path, prefix) {
+	if HasPrefix(path, prefix) {
 		// can't use filepath.Join as it strips the leading './'
 		return "." + string(filepath.Separator) + path[len(prefix):]
 	}
 
 	to = filepath.Dir(to)
 	prefix = trailingSeparator(to)
-	if strings.HasPrefix(path, prefix) {
+	if HasPrefix(path, prefix) {
 		// can't use filepath.Join as it strips the leading './'
 		return ".." + string(filepath.Separator) + path[len(prefix):]
 	}
@@ -75,6 +94,32 @@ func RelTo(path, to string) string {
 	return path
 }
 
+// Whether the paths are the same, disregarding case on platforms whose
+// file-systems do.
+func Equal(path1, path2 string) bool {
+	if caseInsensitive {
+		return strings.EqualFold(path1, path2)
+	}
+
+	return path1 == path2
+}
+
+// A key under which to look the path up in a map, which is the same for paths
+// that are Equal.
+func Key(path string) string {
+	if caseInsensitive {
+		return strings.ToLower(path)
+	}
+
+	return path
+}
+
+// Whether the path begins with the prefix, disregarding case on platforms
+// whose file-systems do.
+func HasPrefix(path, prefix string) bool {
+	return len(path) >= len(prefix) && Equal(path[:len(prefix)], prefix)
+}
+
 func UnescapeOctal(path string) string {
 	decodeChar := func(match string) string {
 		code, err := strconv.ParseUint(match[1:], 8, 0)
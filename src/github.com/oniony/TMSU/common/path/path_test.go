@@ -43,3 +43,47 @@ func TestRelTo(test *testing.T) {
 		}
 	}
 }
+
+func TestFormatPath(test *testing.T) {
+	formats := map[Format]string{
+		Format{true, ""}:         "/some/path",
+		Format{true, "/other"}:   "/some/path",
+		Format{false, "/some"}:   "./path",
+		Format{false, "/other/"}: "../some/path"}
+
+	for format, expected := range formats {
+		actual := format.Path("/some/path")
+
+		if actual != expected {
+			test.Fatalf("Expected '/some/path' formatted with %v to be '%v' but was '%v'", format, expected, actual)
+		}
+	}
+}
+
+func TestHasPrefix(test *testing.T) {
+	prefixes := map[string]bool{
+		"":            true,
+		"/some/":      true,
+		"/some/path":  true,
+		"/SOME/":      caseInsensitive,
+		"/other/":     false,
+		"/some/path/": false}
+
+	for prefix, expected := range prefixes {
+		actual := HasPrefix("/some/path", prefix)
+
+		if actual != expected {
+			test.Fatalf("Expected '/some/path' having prefix '%v' to be %v but was %v", prefix, expected, actual)
+		}
+	}
+}
+
+func TestKey(test *testing.T) {
+	if Key("/some/path") != Key("/some/path") {
+		test.Fatal("Expected the same path to have the same key")
+	}
+
+	if (Key("/some/path") == Key("/SOME/Path")) != caseInsensitive {
+		test.Fatalf("Expected paths differing by case to have the same key only where case is disregarded")
+	}
+}
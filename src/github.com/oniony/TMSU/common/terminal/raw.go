@@ -0,0 +1,54 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// +build !windows
+
+package terminal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Puts the terminal on standard input into raw mode, such that keystrokes are
+// read immediately and not echoed, returning a function that restores the
+// previous mode.
+func MakeRaw() (func() error, error) {
+	state, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("could not read terminal mode: %v", err)
+	}
+
+	if _, err := stty("raw", "-echo"); err != nil {
+		return nil, fmt.Errorf("could not set terminal mode: %v", err)
+	}
+
+	return func() error {
+		_, err := stty(strings.TrimSpace(state))
+		return err
+	}, nil
+}
+
+// unexported
+
+func stty(args ...string) (string, error) {
+	command := exec.Command("stty", args...)
+	command.Stdin = os.Stdin
+
+	output, err := command.Output()
+	return string(output), err
+}
@@ -0,0 +1,91 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package terminal
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/terminal/ansi"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// A table of text whose columns are aligned when it is printed. Cells may
+// contain ANSI formatting, which does not count towards their width.
+type Table struct {
+	Separator    string // printed between columns
+	rightAligned []bool
+	rows         [][]string
+}
+
+// Creates a table whose columns are separated by two spaces. A column is
+// aligned to the right where the corresponding flag is set, e.g. for numbers.
+func NewTable(rightAligned ...bool) *Table {
+	return &Table{"  ", rightAligned, nil}
+}
+
+func (table *Table) AddRow(cells ...string) {
+	table.rows = append(table.rows, cells)
+}
+
+func (table *Table) Print() {
+	table.Fprint(os.Stdout)
+}
+
+// Writes the table, padding each cell but the last of each row to the width
+// of its column.
+func (table *Table) Fprint(writer io.Writer) {
+	widths := make([]int, 0, 10)
+	for _, row := range table.rows {
+		for index, cell := range row {
+			if index == len(widths) {
+				widths = append(widths, 0)
+			}
+
+			if width := cellWidth(cell); width > widths[index] {
+				widths[index] = width
+			}
+		}
+	}
+
+	for _, row := range table.rows {
+		line := ""
+		for index, cell := range row {
+			if index > 0 {
+				line += table.Separator
+			}
+
+			padding := strings.Repeat(" ", widths[index]-cellWidth(cell))
+			switch {
+			case index < len(table.rightAligned) && table.rightAligned[index]:
+				line += padding + cell
+			case index < len(row)-1:
+				line += cell + padding
+			default:
+				line += cell
+			}
+		}
+
+		fmt.Fprintln(writer, strings.TrimRight(line, " "))
+	}
+}
+
+// unexported
+
+func cellWidth(cell string) int {
+	return utf8.RuneCountInString(ansi.Strip(cell))
+}
@@ -0,0 +1,66 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package terminal
+
+import (
+	"bytes"
+	"github.com/oniony/TMSU/common/terminal/ansi"
+	"testing"
+)
+
+func TestTableAlignsColumns(test *testing.T) {
+	// set-up
+
+	table := NewTable(false, true)
+	table.AddRow("mp3", "12", "MPEG audio")
+	table.AddRow(ansi.Blue("music"), "3", "")
+	table.AddRow("über", "100")
+
+	// test
+
+	var buffer bytes.Buffer
+	table.Fprint(&buffer)
+
+	// validate
+
+	expected := "mp3     12  MPEG audio\n" +
+		ansi.Blue("music") + "    3\n" +
+		"über   100\n"
+	if buffer.String() != expected {
+		test.Fatalf("expected %q but was %q", expected, buffer.String())
+	}
+}
+
+func TestTableSeparator(test *testing.T) {
+	// set-up
+
+	table := NewTable(true)
+	table.Separator = " -> "
+	table.AddRow("a", "b")
+	table.AddRow("abc", "d")
+
+	// test
+
+	var buffer bytes.Buffer
+	table.Fprint(&buffer)
+
+	// validate
+
+	expected := "  a -> b\nabc -> d\n"
+	if buffer.String() != expected {
+		test.Fatalf("expected %q but was %q", expected, buffer.String())
+	}
+}
@@ -35,6 +35,14 @@ func Width() int {
 	return int(s.cols)
 }
 
+func Height() int {
+	var s winsize
+
+	_, _, _ = syscall.Syscall(syscall.SYS_IOCTL, os.Stdout.Fd(), uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&s)))
+
+	return int(s.rows)
+}
+
 type winsize struct {
 	rows     uint16
 	cols     uint16
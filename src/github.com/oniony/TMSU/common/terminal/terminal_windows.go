@@ -47,6 +47,21 @@ func Width() int {
 	return cols
 }
 
+func Height() int {
+	outHandle, err := syscall.GetStdHandle(syscall.STD_OUTPUT_HANDLE)
+	if err != nil {
+		return 0
+	}
+
+	info := &consoleScreenBufferInfo{}
+	success, _, _ := syscall.Syscall(getConsoleScreenBufferInfo.Addr(), 2, uintptr(outHandle), uintptr(unsafe.Pointer(info)), 0)
+	if int(success) == 0 {
+		return 0
+	}
+
+	return int(info.window.bottom-info.window.top) + 1
+}
+
 type (
 	short int16
 	word  uint16
@@ -0,0 +1,41 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package text
+
+import (
+	"testing"
+)
+
+func TestDistance(test *testing.T) {
+	cases := []struct {
+		a, b     string
+		distance int
+	}{
+		{"", "", 0},
+		{"", "abc", 3},
+		{"abc", "", 3},
+		{"germany", "germany", 0},
+		{"germny", "germany", 1},
+		{"kitten", "sitting", 3},
+		{"über", "uber", 1},
+	}
+
+	for _, c := range cases {
+		if distance := Distance(c.a, c.b); distance != c.distance {
+			test.Fatalf("distance between '%v' and '%v': expected %v but was %v", c.a, c.b, c.distance, distance)
+		}
+	}
+}
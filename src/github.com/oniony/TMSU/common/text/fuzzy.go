@@ -0,0 +1,88 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package text
+
+import (
+	"sort"
+	"strings"
+)
+
+// A candidate name found to be similar to another.
+type Match struct {
+	Name string
+
+	// zero if the other name is a prefix of this one, otherwise the number of
+	// edits between the two
+	Distance int
+}
+
+// Finds the candidates similar to name, ignoring case: those that name is a
+// prefix of and those no more than maxDistance edits away. The matches are
+// ordered closest first then by name.
+func FuzzyMatch(name string, candidates []string, maxDistance int) []Match {
+	lowerName := strings.ToLower(name)
+
+	matches := make([]Match, 0, 10)
+	for _, candidate := range candidates {
+		lowerCandidate := strings.ToLower(candidate)
+
+		var distance int
+		if !strings.HasPrefix(lowerCandidate, lowerName) {
+			distance = Distance(lowerName, lowerCandidate)
+			if distance > maxDistance {
+				continue
+			}
+		}
+
+		matches = append(matches, Match{candidate, distance})
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+
+		return matches[i].Name < matches[j].Name
+	})
+
+	return matches
+}
+
+// The greatest number of edits at which a name is considered a misspelling of
+// another: longer names are permitted more mistakes.
+func MisspellingDistance(name string) int {
+	return len([]rune(name))/3 + 1
+}
+
+// Suggests up to count of the candidates that a misspelt name may have been
+// intended to be.
+func Suggest(name string, candidates []string, count int) []string {
+	matches := FuzzyMatch(name, candidates, MisspellingDistance(name))
+
+	suggestions := make([]string, 0, count)
+	for _, match := range matches {
+		if len(suggestions) == count {
+			break
+		}
+		if match.Name == name {
+			continue
+		}
+
+		suggestions = append(suggestions, match.Name)
+	}
+
+	return suggestions
+}
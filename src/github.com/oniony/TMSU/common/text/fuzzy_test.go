@@ -0,0 +1,47 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package text
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFuzzyMatch(test *testing.T) {
+	// test
+
+	matches := FuzzyMatch("Hol", []string{"music", "holiday", "hal", "holidays", "photo"}, 1)
+
+	// validate
+
+	expected := []Match{{"holiday", 0}, {"holidays", 0}, {"hal", 1}}
+	if !reflect.DeepEqual(matches, expected) {
+		test.Fatalf("Expected %v but were %v", expected, matches)
+	}
+}
+
+func TestSuggest(test *testing.T) {
+	// test
+
+	suggestions := Suggest("holdiay", []string{"holiday", "holdiay", "music", "holidays", "hold"}, 2)
+
+	// validate
+
+	expected := []string{"holiday", "hold"}
+	if !reflect.DeepEqual(suggestions, expected) {
+		test.Fatalf("Expected %v but were %v", expected, suggestions)
+	}
+}
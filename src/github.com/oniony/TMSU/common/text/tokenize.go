@@ -74,3 +74,65 @@ func Tokenize(text string) []string {
 
 	return tokens
 }
+
+// Splits the text at whitespace, as Tokenize does, but leaves backslash escapes
+// and double-quoted sections in each token for the caller to interpret, so
+// that, for example, a quoted '=' can be told apart from a separator. A
+// single-quoted section is rewritten as the equivalent double-quoted section.
+func TokenizeQuoted(text string) []string {
+	tokens := make([]string, 0, 10)
+	token := make([]rune, 0, 100)
+	var quote rune
+	escape := false
+
+	for _, char := range text {
+		switch {
+		case escape:
+			if quote == '\'' && char != '\'' {
+				// the backslash is retained within the double quotes
+				token = append(token, '\\')
+			}
+			token = append(token, char)
+			escape = false
+		case char == '\\':
+			if quote != '\'' {
+				token = append(token, char)
+			}
+			escape = true
+		case quote == '\'':
+			switch char {
+			case '\'':
+				token = append(token, '"')
+				quote = 0
+			case '"':
+				token = append(token, '\\', char)
+			default:
+				token = append(token, char)
+			}
+		case quote == '"':
+			if char == '"' {
+				quote = 0
+			}
+			token = append(token, char)
+		case char == '"':
+			quote = char
+			token = append(token, char)
+		case char == '\'':
+			quote = char
+			token = append(token, '"')
+		case char == ' ', char == '\t':
+			if len(token) > 0 {
+				tokens = append(tokens, string(token))
+				token = make([]rune, 0, 100)
+			}
+		default:
+			token = append(token, char)
+		}
+	}
+
+	if len(token) > 0 {
+		tokens = append(tokens, string(token))
+	}
+
+	return tokens
+}
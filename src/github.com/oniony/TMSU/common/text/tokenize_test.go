@@ -82,3 +82,19 @@ func TestComplex(test *testing.T) {
 		test.Fatalf("tokenization failed: %v", words)
 	}
 }
+
+func TestQuotedRetainsQuotesAndEscapes(test *testing.T) {
+	words := TokenizeQuoted(`one "two three"=four five\ six`)
+
+	if len(words) != 3 || words[0] != "one" || words[1] != `"two three"=four` || words[2] != `five\ six` {
+		test.Fatalf("tokenization failed: %v", words)
+	}
+}
+
+func TestQuotedRewritesSingleQuotes(test *testing.T) {
+	words := TokenizeQuoted(`'one "two"'=three 'four\'s'`)
+
+	if len(words) != 2 || words[0] != `"one \"two\""=three` || words[1] != `"four's"` {
+		test.Fatalf("tokenization failed: %v", words)
+	}
+}
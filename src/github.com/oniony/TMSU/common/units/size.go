@@ -0,0 +1,78 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package units parses the human-friendly sizes and times accepted by queries
+// and command options, such as '1.5G' or '2 weeks ago'.
+package units
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Parses a file size: a number of bytes, optionally followed by a K, M, G or T
+// unit denoting a binary multiple of bytes, e.g. '10M', '1.5 GB' or '2GiB'.
+func ParseSize(text string) (int64, error) {
+	trimmed := strings.TrimSpace(text)
+
+	if strings.Contains(trimmed, ",") {
+		return 0, fmt.Errorf("ambiguous size '%v': use '.' for the decimal point and no thousands separators", text)
+	}
+
+	end := strings.IndexFunc(trimmed, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if end == -1 {
+		end = len(trimmed)
+	}
+	number, unit := trimmed[:end], strings.ToUpper(strings.TrimSpace(trimmed[end:]))
+
+	if number == "" {
+		return 0, fmt.Errorf("invalid size '%v': expected a number of bytes with an optional K, M, G or T unit", text)
+	}
+
+	multiplier, ok := sizeUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size '%v': unknown unit '%v': expected B, K, M, G or T", text, trimmed[end:])
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size '%v': expected a number of bytes with an optional K, M, G or T unit", text)
+	}
+
+	if multiplier == 1 && value != math.Trunc(value) {
+		return 0, fmt.Errorf("invalid size '%v': not a whole number of bytes", text)
+	}
+
+	// fractions of a multiple are rounded down to the byte
+	size := math.Floor(value * float64(multiplier))
+	if size >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size '%v': too large", text)
+	}
+
+	return int64(size), nil
+}
+
+// unexported
+
+var sizeUnits = map[string]int64{
+	"":  1,
+	"B": 1,
+	"K": 1 << 10, "KB": 1 << 10, "KIB": 1 << 10,
+	"M": 1 << 20, "MB": 1 << 20, "MIB": 1 << 20,
+	"G": 1 << 30, "GB": 1 << 30, "GIB": 1 << 30,
+	"T": 1 << 40, "TB": 1 << 40, "TIB": 1 << 40,
+}
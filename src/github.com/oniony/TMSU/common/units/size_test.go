@@ -0,0 +1,66 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package units
+
+import (
+	"testing"
+)
+
+func TestParseSize(test *testing.T) {
+	// set-up
+
+	expected := map[string]int64{
+		"512":    512,
+		"512B":   512,
+		"10K":    10240,
+		"10 kB":  10240,
+		"1.5M":   1572864,
+		"1.5G":   1610612736,
+		"2GB":    2147483648,
+		"2 GiB":  2147483648,
+		"1t":     1099511627776,
+		" 0.1K ": 102,
+	}
+
+	for text, expectedSize := range expected {
+		// test
+
+		size, err := ParseSize(text)
+
+		// validate
+
+		if err != nil {
+			test.Fatal(err)
+		}
+		if size != expectedSize {
+			test.Fatalf("Expected size of '%v' to be %v but was %v", text, expectedSize, size)
+		}
+	}
+}
+
+func TestParseInvalidSize(test *testing.T) {
+	for _, text := range []string{"", "big", "G", "10X", "10 MBs", "1.5", "-1K", "1,5G", "1,000", "99999999T"} {
+		// test
+
+		_, err := ParseSize(text)
+
+		// validate
+
+		if err == nil {
+			test.Fatalf("Expected error for invalid size '%v'", text)
+		}
+	}
+}
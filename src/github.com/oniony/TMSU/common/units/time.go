@@ -0,0 +1,173 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package units
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parses a date, with optional time, in the local time zone or in RFC 3339
+// format, e.g. '2023-01-01' or '2023-01-01T09:30', or a time relative to now,
+// e.g. 'now-7d', '2 weeks ago', 'in 3 days' or 'yesterday'.
+func ParseTime(text string) (time.Time, error) {
+	return parseTimeAt(text, time.Now())
+}
+
+// unexported
+
+var timeLayouts = []string{"2006-01-02", "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02T15:04:05", "2006-01-02 15:04:05"}
+
+// A unit of relative time: a fixed duration or, as their lengths vary, a
+// number of calendar months.
+type timeUnit struct {
+	duration time.Duration
+	months   int
+}
+
+var timeUnits = map[string]timeUnit{
+	"s": {time.Second, 0}, "sec": {time.Second, 0}, "secs": {time.Second, 0}, "second": {time.Second, 0}, "seconds": {time.Second, 0},
+	"min": {time.Minute, 0}, "mins": {time.Minute, 0}, "minute": {time.Minute, 0}, "minutes": {time.Minute, 0},
+	"h": {time.Hour, 0}, "hr": {time.Hour, 0}, "hrs": {time.Hour, 0}, "hour": {time.Hour, 0}, "hours": {time.Hour, 0},
+	"d": {24 * time.Hour, 0}, "day": {24 * time.Hour, 0}, "days": {24 * time.Hour, 0},
+	"w": {7 * 24 * time.Hour, 0}, "wk": {7 * 24 * time.Hour, 0}, "wks": {7 * 24 * time.Hour, 0}, "week": {7 * 24 * time.Hour, 0}, "weeks": {7 * 24 * time.Hour, 0},
+	"mon": {0, 1}, "month": {0, 1}, "months": {0, 1},
+	"y": {0, 12}, "yr": {0, 12}, "yrs": {0, 12}, "year": {0, 12}, "years": {0, 12},
+}
+
+func parseTimeAt(text string, now time.Time) (time.Time, error) {
+	trimmed := strings.TrimSpace(text)
+
+	for _, layout := range timeLayouts {
+		if parsed, err := time.ParseInLocation(layout, trimmed, time.Local); err == nil {
+			return parsed, nil
+		}
+	}
+	if parsed, err := time.Parse(time.RFC3339, trimmed); err == nil {
+		return parsed, nil
+	}
+
+	words := strings.Fields(strings.ToLower(trimmed))
+	if len(words) == 0 {
+		return time.Time{}, invalidTime(text)
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch {
+	case len(words) == 1 && words[0] == "today":
+		return today, nil
+	case len(words) == 1 && words[0] == "yesterday":
+		return today.AddDate(0, 0, -1), nil
+	case len(words) == 1 && words[0] == "tomorrow":
+		return today.AddDate(0, 0, 1), nil
+	case strings.HasPrefix(words[0], "now"):
+		return parseNowOffset(text, strings.TrimPrefix(strings.Join(words, ""), "now"), now)
+	case words[len(words)-1] == "ago":
+		return parseOffset(text, words[:len(words)-1], -1, now)
+	case words[0] == "in":
+		return parseOffset(text, words[1:], 1, now)
+	case strings.Count(trimmed, "/") == 2:
+		return time.Time{}, fmt.Errorf("ambiguous date '%v': use YYYY-MM-DD", text)
+	}
+
+	return time.Time{}, invalidTime(text)
+}
+
+// Parses the offset following 'now', e.g. '-7d' or '+2weeks', in which, as
+// they are conventionally written so, a bare 'm' denotes minutes.
+func parseNowOffset(text, offset string, now time.Time) (time.Time, error) {
+	if offset == "" {
+		return now, nil
+	}
+
+	sign := 1
+	switch offset[0] {
+	case '-':
+		sign = -1
+	case '+':
+	default:
+		return time.Time{}, invalidTime(text)
+	}
+
+	count, unitName := splitQuantity(offset[1:])
+	if unitName == "m" {
+		unitName = "min"
+	}
+
+	return applyOffset(text, count, unitName, sign, now)
+}
+
+// Parses an offset written in words, e.g. '2 weeks', '2weeks' or 'a week'.
+func parseOffset(text string, words []string, sign int, now time.Time) (time.Time, error) {
+	var count, unitName string
+
+	switch len(words) {
+	case 1:
+		count, unitName = splitQuantity(words[0])
+	case 2:
+		count, unitName = words[0], words[1]
+	default:
+		return time.Time{}, invalidTime(text)
+	}
+
+	if count == "a" || count == "an" {
+		count = "1"
+	}
+	if unitName == "m" {
+		return time.Time{}, fmt.Errorf("ambiguous time '%v': use 'min' for minutes or 'mon' for months", text)
+	}
+
+	return applyOffset(text, count, unitName, sign, now)
+}
+
+// Splits a quantity such as '7d' into its number and unit.
+func splitQuantity(text string) (string, string) {
+	end := strings.IndexFunc(text, func(r rune) bool { return r < '0' || r > '9' })
+	if end == -1 {
+		return text, ""
+	}
+
+	return text[:end], text[end:]
+}
+
+func applyOffset(text, count, unitName string, sign int, now time.Time) (time.Time, error) {
+	number, err := strconv.ParseUint(count, 10, 32)
+	if err != nil {
+		return time.Time{}, invalidTime(text)
+	}
+
+	unit, ok := timeUnits[unitName]
+	if !ok {
+		if unitName == "" {
+			return time.Time{}, fmt.Errorf("invalid time '%v': missing unit: expected seconds, minutes, hours, days, weeks, months or years", text)
+		}
+		return time.Time{}, fmt.Errorf("invalid time '%v': unknown unit '%v': expected seconds, minutes, hours, days, weeks, months or years", text, unitName)
+	}
+
+	n := sign * int(number)
+	if unit.months != 0 {
+		return now.AddDate(0, n*unit.months, 0), nil
+	}
+
+	return now.Add(time.Duration(n) * unit.duration), nil
+}
+
+func invalidTime(text string) error {
+	return fmt.Errorf("invalid time '%v': expected YYYY-MM-DD[THH:MM[:SS]], now[(-|+)N UNIT], N UNIT ago, in N UNIT, today, yesterday or tomorrow", text)
+}
@@ -0,0 +1,90 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package units
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(test *testing.T) {
+	// test
+
+	parsed, err := ParseTime("2023-01-02T09:30")
+
+	// validate
+
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	expected := time.Date(2023, 1, 2, 9, 30, 0, 0, time.Local)
+	if !parsed.Equal(expected) {
+		test.Fatalf("Expected %v but was %v", expected, parsed)
+	}
+}
+
+func TestParseRelativeTime(test *testing.T) {
+	// set-up
+
+	now := time.Date(2023, 3, 15, 15, 45, 10, 0, time.Local)
+
+	expected := map[string]time.Time{
+		"now":           now,
+		"now-7d":        now.AddDate(0, 0, -7),
+		"now+90m":       now.Add(90 * time.Minute),
+		"now - 2 weeks": now.AddDate(0, 0, -14),
+		"now-1y":        time.Date(2022, 3, 15, 15, 45, 10, 0, time.Local),
+		"2 weeks ago":   now.AddDate(0, 0, -14),
+		"2weeks ago":    now.AddDate(0, 0, -14),
+		"an hour ago":   now.Add(-time.Hour),
+		"30 mins ago":   now.Add(-30 * time.Minute),
+		"1 month ago":   time.Date(2023, 2, 15, 15, 45, 10, 0, time.Local),
+		"In 3 Days":     now.AddDate(0, 0, 3),
+		"today":         time.Date(2023, 3, 15, 0, 0, 0, 0, time.Local),
+		"yesterday":     time.Date(2023, 3, 14, 0, 0, 0, 0, time.Local),
+		"tomorrow":      time.Date(2023, 3, 16, 0, 0, 0, 0, time.Local),
+	}
+
+	for text, expectedTime := range expected {
+		// test
+
+		parsed, err := parseTimeAt(text, now)
+
+		// validate
+
+		if err != nil {
+			test.Fatal(err)
+		}
+		if !parsed.Equal(expectedTime) {
+			test.Fatalf("Expected '%v' to be %v but was %v", text, expectedTime, parsed)
+		}
+	}
+}
+
+func TestParseInvalidTime(test *testing.T) {
+	for _, text := range []string{"", "someday", "now-7", "now-d", "now7d", "now-7x", "2 m ago", "weeks ago", "in 2", "01/02/2023", "2023-13-01"} {
+		// test
+
+		_, err := ParseTime(text)
+
+		// validate
+
+		if err == nil {
+			test.Fatalf("Expected error for invalid time '%v'", text)
+		}
+	}
+}
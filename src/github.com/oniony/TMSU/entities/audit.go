@@ -0,0 +1,58 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package entities
+
+import (
+	"path/filepath"
+	"time"
+)
+
+// The actions recorded in the audit trail.
+const (
+	AuditTag     = "tag"
+	AuditUntag   = "untag"
+	AuditImply   = "imply"
+	AuditUnimply = "unimply"
+)
+
+// A change recorded in the audit trail: the tagging or untagging of a file, in
+// which case Directory and Name identify the file, or the addition or removal
+// of a tag implication, in which case ImpliedTag and ImpliedValue name the
+// implied tag.
+type AuditEntry struct {
+	Id           uint
+	ChangedAt    time.Time
+	User         string
+	Command      string
+	Action       string
+	Directory    string
+	Name         string
+	Tag          string
+	Value        string
+	ImpliedTag   string
+	ImpliedValue string
+}
+
+// The path of the file, which is relative to the database root unless absolute.
+func (entry AuditEntry) Path() string {
+	if entry.Name == "" {
+		return ""
+	}
+
+	return filepath.Join(entry.Directory, entry.Name)
+}
+
+type AuditEntries []*AuditEntry
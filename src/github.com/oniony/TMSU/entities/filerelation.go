@@ -0,0 +1,26 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package entities
+
+// A named relation from one file to another, e.g. a JPEG 'derived-from' the
+// RAW image it was developed from.
+type FileRelation struct {
+	FileId        FileId
+	RelatedFileId FileId
+	Relation      string
+}
+
+type FileRelations []*FileRelation
@@ -96,3 +96,9 @@ func (fileTags FileTags) ValueIds() ValueIds {
 
 	return valueIds.Uniq()
 }
+
+// The number of file tags applied by an author.
+type AuthorFileTagCount struct {
+	Author       string
+	FileTagCount uint
+}
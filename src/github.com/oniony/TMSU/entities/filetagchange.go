@@ -0,0 +1,61 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package entities
+
+import (
+	"path/filepath"
+	"time"
+)
+
+// The most recent addition or removal of a file tag, identified by name so
+// that it can be matched between databases.
+type FileTagChange struct {
+	Directory string
+	Name      string
+	Tag       string
+	Value     string
+	Removed   bool
+	ChangedAt time.Time
+}
+
+// Identifies the file tag that was changed.
+func (change FileTagChange) Key() FileTagChangeKey {
+	return FileTagChangeKey{change.Directory, change.Name, change.Tag, change.Value}
+}
+
+// The path of the file, which is relative to the database root unless absolute.
+func (change FileTagChange) Path() string {
+	return filepath.Join(change.Directory, change.Name)
+}
+
+type FileTagChangeKey struct {
+	Directory string
+	Name      string
+	Tag       string
+	Value     string
+}
+
+type FileTagChanges []*FileTagChange
+
+// Indexes the changes by the file tag changed.
+func (changes FileTagChanges) ByKey() map[FileTagChangeKey]*FileTagChange {
+	changeByKey := make(map[FileTagChangeKey]*FileTagChange, len(changes))
+	for _, change := range changes {
+		changeByKey[change.Key()] = change
+	}
+
+	return changeByKey
+}
@@ -0,0 +1,49 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package entities
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFileTagChangesByKey(test *testing.T) {
+	// set-up
+
+	now := time.Now()
+	added := &FileTagChange{"photos", "a.jpg", "year", "2017", false, now}
+	removed := &FileTagChange{"photos", "a.jpg", "year", "", true, now}
+	changes := FileTagChanges{added, removed}
+
+	// test
+
+	changeByKey := changes.ByKey()
+
+	// validate
+
+	if len(changeByKey) != 2 {
+		test.Fatalf("Expected 2 changes but were %v", len(changeByKey))
+	}
+	if changeByKey[FileTagChangeKey{"photos", "a.jpg", "year", "2017"}] != added {
+		test.Fatalf("Addition was not indexed by its key")
+	}
+	if changeByKey[removed.Key()] != removed {
+		test.Fatalf("Removal was not indexed by its key")
+	}
+	if path := added.Path(); path != "photos/a.jpg" {
+		test.Fatalf("Expected path 'photos/a.jpg' but was '%v'", path)
+	}
+}
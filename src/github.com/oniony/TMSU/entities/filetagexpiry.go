@@ -0,0 +1,48 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package entities
+
+import (
+	"time"
+)
+
+// The time at which a file tag is due to be removed.
+type FileTagExpiry struct {
+	FileId    FileId
+	TagId     TagId
+	ValueId   ValueId
+	ExpiresAt time.Time
+}
+
+// Determines whether the file tag has expired by the time specified.
+func (expiry FileTagExpiry) ExpiredAt(at time.Time) bool {
+	return !expiry.ExpiresAt.After(at)
+}
+
+type FileTagExpiries []*FileTagExpiry
+
+// Retrieves the expiries that have expired by the time specified.
+func (expiries FileTagExpiries) ExpiredAt(at time.Time) FileTagExpiries {
+	expired := make(FileTagExpiries, 0, len(expiries))
+
+	for _, expiry := range expiries {
+		if expiry.ExpiredAt(at) {
+			expired = append(expired, expiry)
+		}
+	}
+
+	return expired
+}
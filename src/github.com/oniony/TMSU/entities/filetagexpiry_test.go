@@ -0,0 +1,43 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package entities
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFileTagExpiriesExpiredAt(test *testing.T) {
+	// set-up
+
+	now := time.Now()
+	expiries := FileTagExpiries{&FileTagExpiry{1, 1, 0, now.Add(-time.Hour)},
+		&FileTagExpiry{2, 1, 0, now},
+		&FileTagExpiry{3, 1, 0, now.Add(time.Hour)}}
+
+	// test
+
+	expired := expiries.ExpiredAt(now)
+
+	// validate
+
+	if len(expired) != 2 {
+		test.Fatalf("Expected 2 expired file tags but was %v", len(expired))
+	}
+	if expired[0].FileId != 1 || expired[1].FileId != 2 {
+		test.Fatalf("Expected files #1 and #2 to have expired but was #%v and #%v", expired[0].FileId, expired[1].FileId)
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package entities
+
+import (
+	"github.com/oniony/TMSU/common/fingerprint"
+	"path/filepath"
+	"time"
+)
+
+type ForgottenFileId uint
+
+// A file removed from the database whose details and tags are retained, so
+// that they can be restored should the file reappear.
+type ForgottenFile struct {
+	Id          ForgottenFileId
+	Directory   string
+	Name        string
+	Fingerprint fingerprint.Fingerprint
+	ModTime     time.Time
+	Size        int64
+	IsDir       bool
+	ForgottenAt time.Time
+}
+
+func (file ForgottenFile) Path() string {
+	return filepath.Join(file.Directory, file.Name)
+}
+
+type ForgottenFiles []*ForgottenFile
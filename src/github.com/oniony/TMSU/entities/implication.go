@@ -15,11 +15,16 @@
 
 package entities
 
+// An implication of one tag (and value) by another.
+//
+// If ImplyingPattern is set the implication applies to any value of the
+// implying tag that matches the glob pattern rather than to ImplyingValue.
 type Implication struct {
-	ImplyingTag   Tag
-	ImplyingValue Value
-	ImpliedTag    Tag
-	ImpliedValue  Value
+	ImplyingTag     Tag
+	ImplyingValue   Value
+	ImplyingPattern string
+	ImpliedTag      Tag
+	ImpliedValue    Value
 }
 
 func (implication Implication) ImplyingTagValuePair() TagIdValueIdPair {
@@ -35,6 +40,7 @@ type Implications []*Implication
 func (implications Implications) Contains(implication Implication) bool {
 	for _, i := range implications {
 		if i.ImplyingTag.Id == implication.ImplyingTag.Id && i.ImplyingValue.Id == implication.ImplyingValue.Id &&
+			i.ImplyingPattern == implication.ImplyingPattern &&
 			i.ImpliedTag.Id == implication.ImpliedTag.Id && i.ImpliedValue.Id == implication.ImpliedValue.Id {
 			return true
 		}
@@ -15,8 +15,54 @@
 
 package entities
 
+import (
+	"time"
+)
+
 type Query struct {
 	Text string
 }
 
 type Queries []*Query
+
+// How a query was run: the SQL it was translated to, the plan by which SQLite
+// executed it and how long it took to retrieve the matching files.
+type QueryExplanation struct {
+	Sql       string
+	Params    []interface{}
+	Plan      QueryPlanSteps
+	FileCount uint
+	Duration  time.Duration
+}
+
+// A step of a query plan, nested within the step identified by Parent.
+type QueryPlanStep struct {
+	Id     int
+	Parent int
+	Detail string
+}
+
+type QueryPlanSteps []QueryPlanStep
+
+// The depth to which the step is nested, the top-level steps having a depth of
+// zero.
+func (steps QueryPlanSteps) Depth(step QueryPlanStep) int {
+	depth := 0
+
+	for parent := step.Parent; parent != 0; depth++ {
+		found := false
+		for _, candidate := range steps {
+			if candidate.Id == parent {
+				parent = candidate.Parent
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			break
+		}
+	}
+
+	return depth
+}
@@ -0,0 +1,35 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package entities
+
+import (
+	"testing"
+)
+
+func TestQueryPlanStepDepth(test *testing.T) {
+	// set-up
+
+	steps := QueryPlanSteps{{2, 0, "COMPOUND QUERY"}, {3, 2, "LEFT-MOST SUBQUERY"}, {5, 3, "SCAN file_tag"}, {9, 0, "USE TEMP B-TREE FOR ORDER BY"}}
+
+	// test & validate
+
+	expected := []int{0, 1, 2, 0}
+	for index, step := range steps {
+		if depth := steps.Depth(step); depth != expected[index] {
+			test.Fatalf("Expected depth of '%v' to be %v but was %v", step.Detail, expected[index], depth)
+		}
+	}
+}
@@ -15,6 +15,17 @@
 
 package entities
 
+import (
+	"bytes"
+	"fmt"
+	"github.com/oniony/TMSU/query"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
 type Setting struct {
 	Name  string
 	Value string
@@ -42,6 +53,72 @@ func (settings Settings) AutoCreateValues() bool {
 	return settings.BoolValue("autoCreateValues")
 }
 
+// Whether tags that do not exist are refused, rather than created, when first
+// applied, unless their names match one of NewTagPatterns.
+func (settings Settings) ClosedVocabulary() bool {
+	return settings.BoolValue("closedVocabulary")
+}
+
+// The glob patterns of the names of tags that may be created when first
+// applied even though the vocabulary is closed.
+func (settings Settings) NewTagPatterns() []string {
+	value := settings.Value("newTagPatterns")
+	if value == "" {
+		return nil
+	}
+
+	return strings.Split(value, ",")
+}
+
+// Whether a tag that does not exist may be created by applying it.
+func (settings Settings) PermitsNewTag(tagName string) bool {
+	if !settings.ClosedVocabulary() {
+		return true
+	}
+
+	for _, pattern := range settings.NewTagPatterns() {
+		if matched, _ := filepath.Match(pattern, tagName); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The rules for tagging files automatically by path, in the form
+// PATTERN:TAG[=VALUE] separated by commas.
+func (settings Settings) AutoTags() ([]AutoTag, error) {
+	return ParseAutoTags(settings.Value("autoTags"))
+}
+
+// The templates deriving tags from the components of files' paths, separated
+// by commas.
+func (settings Settings) PathTemplates() ([]PathTemplate, error) {
+	return ParsePathTemplates(settings.Value("pathTemplates"))
+}
+
+// The external commands that extract the text content of files for indexing,
+// by file name extension, in the form EXTENSION:COMMAND separated by commas.
+func (settings Settings) ContentExtractors() ([]ContentExtractor, error) {
+	return ParseContentExtractors(settings.Value("contentExtractors"))
+}
+
+// The order files are listed in when no sort is specified.
+func (settings Settings) DefaultSort() string {
+	return settings.Value("defaultSort")
+}
+
+// The number of files a tag may be applied to before 'delete' asks for
+// confirmation, or zero to always ask.
+func (settings Settings) DeleteThreshold() (uint, error) {
+	count, err := strconv.ParseUint(settings.Value("deleteThreshold"), 10, 0)
+	if err != nil {
+		return 0, fmt.Errorf("invalid delete threshold '%v': expected a number of files", settings.Value("deleteThreshold"))
+	}
+
+	return uint(count), nil
+}
+
 func (settings Settings) FileFingerprintAlgorithm() string {
 	return settings.Value("fileFingerprintAlgorithm")
 }
@@ -54,10 +131,114 @@ func (settings Settings) SymlinkFingerprintAlgorithm() string {
 	return settings.Value("symlinkFingerprintAlgorithm")
 }
 
+// The number of days for which forgotten files are retained, or zero to
+// retain them indefinitely.
+func (settings Settings) ForgottenRetention() (uint, error) {
+	days, err := strconv.ParseUint(settings.Value("forgottenRetention"), 10, 0)
+	if err != nil {
+		return 0, fmt.Errorf("invalid forgotten file retention '%v': expected a number of days", settings.Value("forgottenRetention"))
+	}
+
+	return uint(days), nil
+}
+
+// How symbolic links are tagged and scanned: 'follow' to use the link's
+// target, 'link' to store the link itself or 'both'.
+func (settings Settings) SymlinkPolicy() string {
+	return settings.Value("symlinkPolicy")
+}
+
+// The commands run when tagging changes, in the form EVENT:COMMAND separated by
+// commas.
+func (settings Settings) Hooks() ([]Hook, error) {
+	return ParseHooks(settings.Value("hooks"))
+}
+
+// Whether tag and value names in queries are matched without regard to case.
+func (settings Settings) IgnoreCase() bool {
+	return settings.BoolValue("ignoreCase")
+}
+
+// The glob patterns of the names of files and directories that are skipped
+// when searching the file-system for untagged files.
+func (settings Settings) IgnorePatterns() []string {
+	value := settings.Value("ignorePatterns")
+	if value == "" {
+		return nil
+	}
+
+	return strings.Split(value, ",")
+}
+
+// The Sqlite journal mode: 'wal', so that queries can proceed whilst another
+// process is making changes, or 'delete' for file-systems where the
+// write-ahead log is unsafe, such as network shares.
+func (settings Settings) JournalMode() string {
+	return settings.Value("journalMode")
+}
+
+// Whether the tags implied by the tags applied to files are stored as implied
+// file tags, for the benefit of programs that read the database directly, in
+// addition to being determined at time of query.
+func (settings Settings) MaterializeImplications() bool {
+	return settings.BoolValue("materializeImplications")
+}
+
+func (settings Settings) MetadataMapping() string {
+	return settings.Value("metadataMapping")
+}
+
+// How tag and value names containing spaces, equals signs, quotes or
+// backslashes are output: 'backslash' to escape each such character or
+// 'quotes' to enclose the name in double quotes.
+func (settings Settings) NameQuoting() string {
+	return settings.Value("nameQuoting")
+}
+
+// Whether tag and value names are stored in Unicode normalisation form C and
+// matched without regard to case.
+func (settings Settings) NormalizeNames() bool {
+	return settings.BoolValue("normalizeNames")
+}
+
+// The external commands that fingerprint and suggest tags for files of
+// particular MIME types, in the form MIME-PATTERN:COMMAND separated by commas.
+func (settings Settings) Plugins() ([]Plugin, error) {
+	return ParsePlugins(settings.Value("plugins"))
+}
+
+// Whether the tags applied to a file are also applied to the files in the
+// database having the same fingerprint.
+func (settings Settings) PropagateDuplicateTags() bool {
+	return settings.BoolValue("propagateDuplicateTags")
+}
+
+// The macros queries may call, in the form NAME(PARAM,...) = BODY separated by
+// semicolons.
+func (settings Settings) QueryMacros() ([]query.Macro, error) {
+	return query.ParseMacros(settings.Value("queryMacros"))
+}
+
 func (settings Settings) ReportDuplicates() bool {
 	return settings.BoolValue("reportDuplicates")
 }
 
+// The paths searched for moved files when repairing, separated by the
+// platform's path list separator.
+func (settings Settings) SearchPaths() []string {
+	return filepath.SplitList(settings.Value("searchPaths"))
+}
+
+func (settings Settings) StrictVocabularies() bool {
+	return settings.BoolValue("strictVocabularies")
+}
+
+// The rules restricting who may apply and remove tags, in the form
+// PATTERN:PRINCIPAL... separated by commas.
+func (settings Settings) TagPermissions() ([]TagPermission, error) {
+	return ParseTagPermissions(settings.Value("tagPermissions"))
+}
+
 func (settings Settings) ContainsName(name string) bool {
 	for _, setting := range settings {
 		if setting.Name == name {
@@ -94,3 +275,384 @@ func (settings Settings) BoolValue(name string) bool {
 
 	return false
 }
+
+// A rule applying a tag to the files whose path matches a pattern. A pattern
+// containing a path separator is matched against the absolute path, otherwise
+// against the file name.
+type AutoTag struct {
+	Pattern string
+	TagArg  string
+}
+
+func (autoTag AutoTag) Matches(path string) bool {
+	subject := path
+	if !strings.ContainsRune(autoTag.Pattern, filepath.Separator) {
+		subject = filepath.Base(path)
+	}
+
+	matched, _ := filepath.Match(autoTag.Pattern, subject)
+	return matched
+}
+
+func ParseAutoTags(text string) ([]AutoTag, error) {
+	autoTags := make([]AutoTag, 0, 10)
+
+	for _, rule := range strings.Split(text, ",") {
+		if rule == "" {
+			continue
+		}
+
+		index := strings.LastIndex(rule, ":")
+		if index < 1 || index == len(rule)-1 {
+			return nil, fmt.Errorf("invalid automatic tagging rule '%v': expected PATTERN:TAG[=VALUE]", rule)
+		}
+
+		pattern := rule[:index]
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern '%v': %v", pattern, err)
+		}
+
+		autoTags = append(autoTags, AutoTag{pattern, rule[index+1:]})
+	}
+
+	return autoTags, nil
+}
+
+// A template of the paths of files from whose components tags are derived,
+// e.g. '/music/{artist}/{album}/...'. A component containing {TAG} tags the
+// file with the TAG=VALUE pair whose value is the corresponding text of the
+// path. Elsewhere '*' and '?' match as in a glob pattern and a final '...'
+// matches the remainder of the path. A relative template is relative to the
+// database root.
+type PathTemplate struct {
+	Text       string
+	components []*regexp.Regexp
+	tagNames   [][]string
+	open       bool
+}
+
+// A tag and value derived from a path by a template.
+type PathTag struct {
+	TagName   string
+	ValueName string
+}
+
+// Matches the template against the absolute path, returning the tags derived
+// from its components. Relative templates are matched against the path
+// relative to the root path.
+func (template PathTemplate) Match(path, rootPath string) ([]PathTag, bool) {
+	if !filepath.IsAbs(filepath.FromSlash(template.Text)) {
+		relPath, err := filepath.Rel(rootPath, path)
+		if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+			return nil, false
+		}
+
+		path = relPath
+	}
+
+	names := splitPath(path)
+	if len(names) < len(template.components) || (len(names) > len(template.components) && !template.open) || (template.open && len(names) == len(template.components)) {
+		return nil, false
+	}
+
+	pathTags := make([]PathTag, 0, len(template.components))
+	for index, component := range template.components {
+		submatches := component.FindStringSubmatch(names[index])
+		if submatches == nil {
+			return nil, false
+		}
+
+		for tagIndex, tagName := range template.tagNames[index] {
+			pathTags = append(pathTags, PathTag{tagName, submatches[tagIndex+1]})
+		}
+	}
+
+	return pathTags, true
+}
+
+func ParsePathTemplates(text string) ([]PathTemplate, error) {
+	templates := make([]PathTemplate, 0, 10)
+
+	for _, templateText := range strings.Split(text, ",") {
+		if templateText == "" {
+			continue
+		}
+
+		template, err := ParsePathTemplate(templateText)
+		if err != nil {
+			return nil, err
+		}
+
+		templates = append(templates, template)
+	}
+
+	return templates, nil
+}
+
+func ParsePathTemplate(text string) (PathTemplate, error) {
+	template := PathTemplate{Text: text}
+
+	names := splitPath(filepath.FromSlash(text))
+	if len(names) > 0 && names[len(names)-1] == "..." {
+		template.open = true
+		names = names[:len(names)-1]
+	}
+	if len(names) == 0 {
+		return PathTemplate{}, fmt.Errorf("invalid path template '%v': no components", text)
+	}
+
+	tagCount := 0
+	for _, name := range names {
+		component, tagNames, err := parseTemplateComponent(name)
+		if err != nil {
+			return PathTemplate{}, fmt.Errorf("invalid path template '%v': %v", text, err)
+		}
+
+		template.components = append(template.components, component)
+		template.tagNames = append(template.tagNames, tagNames)
+		tagCount += len(tagNames)
+	}
+
+	if tagCount == 0 {
+		return PathTemplate{}, fmt.Errorf("invalid path template '%v': no {TAG} components", text)
+	}
+
+	return template, nil
+}
+
+// A command that writes the text content of the file at path $1 to standard
+// output.
+type ContentExtractor struct {
+	Extension string
+	Command   string
+}
+
+func ParseContentExtractors(text string) ([]ContentExtractor, error) {
+	extractors := make([]ContentExtractor, 0, 10)
+
+	for _, rule := range strings.Split(text, ",") {
+		if rule == "" {
+			continue
+		}
+
+		index := strings.Index(rule, ":")
+		if index < 1 || index == len(rule)-1 {
+			return nil, fmt.Errorf("invalid content extractor '%v': expected EXTENSION:COMMAND", rule)
+		}
+
+		extractors = append(extractors, ContentExtractor{strings.ToLower(rule[:index]), rule[index+1:]})
+	}
+
+	return extractors, nil
+}
+
+// A command, run with the path of a file whose MIME type matches the glob
+// pattern as $1, that writes a JSON object to standard output giving the
+// file's 'fingerprint' and the 'tags' it suggests for it, either of which may
+// be omitted.
+type Plugin struct {
+	MimePattern string
+	Command     string
+}
+
+func (plugin Plugin) Matches(mimeType string) bool {
+	matched, _ := path.Match(plugin.MimePattern, mimeType)
+	return matched
+}
+
+func ParsePlugins(text string) ([]Plugin, error) {
+	plugins := make([]Plugin, 0, 10)
+
+	for _, rule := range strings.Split(text, ",") {
+		if rule == "" {
+			continue
+		}
+
+		index := strings.Index(rule, ":")
+		if index < 1 || index == len(rule)-1 {
+			return nil, fmt.Errorf("invalid plugin '%v': expected MIME-PATTERN:COMMAND", rule)
+		}
+
+		pattern := strings.ToLower(rule[:index])
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid plugin '%v': invalid MIME type pattern '%v'", rule, pattern)
+		}
+
+		plugins = append(plugins, Plugin{pattern, rule[index+1:]})
+	}
+
+	return plugins, nil
+}
+
+// A rule permitting only the listed users and the members of the listed
+// groups to apply or remove the tags whose names match a glob pattern, e.g.
+// 'archived/*' for the tags in the 'archived' namespace.
+type TagPermission struct {
+	Pattern string
+	Users   []string
+	Groups  []string
+}
+
+func (permission TagPermission) Matches(tagName string) bool {
+	matched, _ := path.Match(permission.Pattern, tagName)
+	return matched
+}
+
+// Whether the user, a member of the groups, is permitted by the rule.
+func (permission TagPermission) Permits(userName string, groupNames []string) bool {
+	for _, permitted := range permission.Users {
+		if permitted == userName {
+			return true
+		}
+	}
+
+	for _, permitted := range permission.Groups {
+		for _, groupName := range groupNames {
+			if permitted == groupName {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Parses the rules, each a pattern followed by a colon and the permitted user
+// names and group names, the latter prefixed with '@', separated by spaces.
+func ParseTagPermissions(text string) ([]TagPermission, error) {
+	permissions := make([]TagPermission, 0, 10)
+
+	for _, rule := range strings.Split(text, ",") {
+		if rule == "" {
+			continue
+		}
+
+		index := strings.LastIndex(rule, ":")
+		if index < 1 || strings.TrimSpace(rule[index+1:]) == "" {
+			return nil, fmt.Errorf("invalid tag permission '%v': expected PATTERN:USER|@GROUP...", rule)
+		}
+
+		pattern := rule[:index]
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern '%v': %v", pattern, err)
+		}
+
+		permission := TagPermission{Pattern: pattern}
+		for _, principal := range strings.Fields(rule[index+1:]) {
+			if strings.HasPrefix(principal, "@") {
+				permission.Groups = append(permission.Groups, principal[1:])
+			} else {
+				permission.Users = append(permission.Users, principal)
+			}
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	return permissions, nil
+}
+
+// The events for which hooks are run: before and after the tagging changes.
+var HookEvents = []string{"pre-tag", "post-tag", "pre-untag", "post-untag", "pre-repair", "post-repair", "pre-delete", "post-delete"}
+
+// A command run when an event occurs.
+type Hook struct {
+	Event   string
+	Command string
+}
+
+func ParseHooks(text string) ([]Hook, error) {
+	hooks := make([]Hook, 0, 10)
+
+	for _, rule := range strings.Split(text, ",") {
+		if rule == "" {
+			continue
+		}
+
+		index := strings.Index(rule, ":")
+		if index < 1 || index == len(rule)-1 {
+			return nil, fmt.Errorf("invalid hook '%v': expected EVENT:COMMAND", rule)
+		}
+
+		event := rule[:index]
+		if !isHookEvent(event) {
+			return nil, fmt.Errorf("invalid hook event '%v': expected one of %v", event, strings.Join(HookEvents, ", "))
+		}
+
+		hooks = append(hooks, Hook{event, rule[index+1:]})
+	}
+
+	return hooks, nil
+}
+
+// unexported
+
+func isHookEvent(event string) bool {
+	for _, hookEvent := range HookEvents {
+		if hookEvent == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The non-empty components of a path.
+func splitPath(path string) []string {
+	names := make([]string, 0, 10)
+	for _, name := range strings.Split(path, string(filepath.Separator)) {
+		if name != "" && name != "." {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// Converts a component of a path template to a regular expression capturing
+// the text of each {TAG}.
+func parseTemplateComponent(name string) (*regexp.Regexp, []string, error) {
+	if name == "..." {
+		return nil, nil, fmt.Errorf("'...' must be the last component")
+	}
+
+	var pattern bytes.Buffer
+	pattern.WriteString("^")
+
+	tagNames := make([]string, 0, 1)
+	for index := 0; index < len(name); index++ {
+		switch name[index] {
+		case '{':
+			end := strings.IndexByte(name[index:], '}')
+			if end == -1 {
+				return nil, nil, fmt.Errorf("'{' is not closed")
+			}
+
+			tagName := name[index+1 : index+end]
+			if err := ValidateTagName(tagName); err != nil {
+				return nil, nil, err
+			}
+
+			tagNames = append(tagNames, tagName)
+			pattern.WriteString("(.+?)")
+			index += end
+		case '}':
+			return nil, nil, fmt.Errorf("'}' is not opened")
+		case '*':
+			pattern.WriteString(".*")
+		case '?':
+			pattern.WriteString(".")
+		default:
+			pattern.WriteString(regexp.QuoteMeta(name[index : index+1]))
+		}
+	}
+
+	pattern.WriteString("$")
+
+	component, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return component, tagNames, nil
+}
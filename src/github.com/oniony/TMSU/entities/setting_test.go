@@ -0,0 +1,224 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package entities
+
+import (
+	"testing"
+)
+
+func TestParseAutoTags(test *testing.T) {
+	// test
+
+	autoTags, err := ParseAutoTags("*.jpg:photo,/media/music/*:year=2017")
+
+	// validate
+
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(autoTags) != 2 {
+		test.Fatalf("Expected 2 rules but were %v", len(autoTags))
+	}
+	if autoTags[0].Pattern != "*.jpg" || autoTags[0].TagArg != "photo" {
+		test.Fatalf("Unexpected first rule %v", autoTags[0])
+	}
+	if autoTags[1].Pattern != "/media/music/*" || autoTags[1].TagArg != "year=2017" {
+		test.Fatalf("Unexpected second rule %v", autoTags[1])
+	}
+	if !autoTags[0].Matches("/home/bob/holiday.jpg") {
+		test.Fatalf("Rule should match on file name")
+	}
+	if autoTags[1].Matches("/home/bob/song.mp3") || !autoTags[1].Matches("/media/music/song.mp3") {
+		test.Fatalf("Rule should match on absolute path")
+	}
+}
+
+func TestParseInvalidAutoTags(test *testing.T) {
+	for _, text := range []string{"photo", "*.jpg:", "[:photo"} {
+		if _, err := ParseAutoTags(text); err == nil {
+			test.Fatalf("Expected '%v' to be rejected", text)
+		}
+	}
+}
+
+func TestParsePathTemplates(test *testing.T) {
+	// test
+
+	templates, err := ParsePathTemplates("/media/music/{artist}/{year} - {album}/...,photos/{event}/*.jpg")
+
+	// validate
+
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(templates) != 2 {
+		test.Fatalf("Expected 2 templates but were %v", len(templates))
+	}
+
+	pathTags, matched := templates[0].Match("/media/music/Nina Simone/1965 - Pastel Blues/Sinnerman.mp3", "/")
+	if !matched {
+		test.Fatalf("Template should match path")
+	}
+	expected := []PathTag{{"artist", "Nina Simone"}, {"year", "1965"}, {"album", "Pastel Blues"}}
+	if len(pathTags) != len(expected) {
+		test.Fatalf("Expected tags %v but were %v", expected, pathTags)
+	}
+	for index := range expected {
+		if pathTags[index] != expected[index] {
+			test.Fatalf("Expected tags %v but were %v", expected, pathTags)
+		}
+	}
+
+	if _, matched := templates[0].Match("/media/music/Nina Simone/Pastel Blues/Sinnerman.mp3", "/"); matched {
+		test.Fatalf("Template should not match component without literal text")
+	}
+	if _, matched := templates[0].Match("/media/music/Nina Simone/1965 - Pastel Blues", "/"); matched {
+		test.Fatalf("Template should not match the directory itself")
+	}
+
+	if pathTags, matched := templates[1].Match("/home/bob/photos/wedding/1.jpg", "/home/bob"); !matched || len(pathTags) != 1 || pathTags[0] != (PathTag{"event", "wedding"}) {
+		test.Fatalf("Relative template should match path under root: %v", pathTags)
+	}
+	if _, matched := templates[1].Match("/home/bob/photos/wedding/party/1.jpg", "/home/bob"); matched {
+		test.Fatalf("Template should not match deeper path")
+	}
+	if _, matched := templates[1].Match("/home/sally/photos/wedding/1.jpg", "/home/bob"); matched {
+		test.Fatalf("Relative template should not match path outside root")
+	}
+}
+
+func TestParseInvalidPathTemplates(test *testing.T) {
+	for _, text := range []string{"/media/music/...", "/media/{artist/...", "/media/artist}/...", "/{artist}/.../{album}", "/{}/..."} {
+		if _, err := ParsePathTemplates(text); err == nil {
+			test.Fatalf("Expected '%v' to be rejected", text)
+		}
+	}
+}
+
+func TestParseTagPermissions(test *testing.T) {
+	// test
+
+	permissions, err := ParseTagPermissions("archived/*:@librarians alice,secret:bob")
+
+	// validate
+
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(permissions) != 2 {
+		test.Fatalf("Expected 2 rules but were %v", len(permissions))
+	}
+	if !permissions[0].Matches("archived/2018") || permissions[0].Matches("archived") || permissions[0].Matches("draft") {
+		test.Fatalf("Rule should match only the tags in the namespace")
+	}
+	if !permissions[0].Permits("alice", nil) || !permissions[0].Permits("carol", []string{"staff", "librarians"}) {
+		test.Fatalf("Rule should permit the listed user and group")
+	}
+	if permissions[0].Permits("bob", []string{"staff"}) {
+		test.Fatalf("Rule should not permit an unlisted user")
+	}
+	if !permissions[1].Matches("secret") || !permissions[1].Permits("bob", nil) {
+		test.Fatalf("Unexpected second rule %v", permissions[1])
+	}
+}
+
+func TestParseInvalidTagPermissions(test *testing.T) {
+	for _, text := range []string{"archived/*", "archived/*:", ":alice", "[:alice"} {
+		if _, err := ParseTagPermissions(text); err == nil {
+			test.Fatalf("Expected '%v' to be rejected", text)
+		}
+	}
+}
+
+func TestParseHooks(test *testing.T) {
+	// test
+
+	hooks, err := ParseHooks("post-tag:updatedb --tags,pre-delete:echo deleting")
+
+	// validate
+
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(hooks) != 2 {
+		test.Fatalf("Expected 2 hooks but were %v", len(hooks))
+	}
+	if hooks[0].Event != "post-tag" || hooks[0].Command != "updatedb --tags" {
+		test.Fatalf("Unexpected first hook %v", hooks[0])
+	}
+	if hooks[1].Event != "pre-delete" || hooks[1].Command != "echo deleting" {
+		test.Fatalf("Unexpected second hook %v", hooks[1])
+	}
+}
+
+func TestParseInvalidHooks(test *testing.T) {
+	for _, text := range []string{"echo", "post-tag:", "post-tidy:echo"} {
+		if _, err := ParseHooks(text); err == nil {
+			test.Fatalf("Expected '%v' to be rejected", text)
+		}
+	}
+}
+
+func TestParsePlugins(test *testing.T) {
+	// test
+
+	plugins, err := ParsePlugins("image/x-canon-*:cr2info \"$1\",Application/X-Foo:foo --json $1")
+
+	// validate
+
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(plugins) != 2 {
+		test.Fatalf("Expected 2 plugins but were %v", len(plugins))
+	}
+	if plugins[0].MimePattern != "image/x-canon-*" || plugins[0].Command != `cr2info "$1"` {
+		test.Fatalf("Unexpected first plugin %v", plugins[0])
+	}
+	if !plugins[0].Matches("image/x-canon-cr2") || plugins[0].Matches("image/jpeg") {
+		test.Fatalf("First plugin matched unexpectedly")
+	}
+	if plugins[1].MimePattern != "application/x-foo" || plugins[1].Command != "foo --json $1" {
+		test.Fatalf("Unexpected second plugin %v", plugins[1])
+	}
+}
+
+func TestParseInvalidPlugins(test *testing.T) {
+	for _, text := range []string{"cr2info", "image/*:", ":cr2info", "image/[:cr2info"} {
+		if _, err := ParsePlugins(text); err == nil {
+			test.Fatalf("Expected '%v' to be rejected", text)
+		}
+	}
+}
+
+func TestPermitsNewTag(test *testing.T) {
+	// set-up
+
+	open := Settings{&Setting{"closedVocabulary", "no"}, &Setting{"newTagPatterns", ""}}
+	closed := Settings{&Setting{"closedVocabulary", "yes"}, &Setting{"newTagPatterns", "year-*,draft"}}
+
+	// validate
+
+	if !open.PermitsNewTag("holdiay") {
+		test.Fatalf("Open vocabulary should permit any new tag")
+	}
+	if closed.PermitsNewTag("holdiay") {
+		test.Fatalf("Closed vocabulary should not permit an unmatched tag")
+	}
+	if !closed.PermitsNewTag("year-2017") || !closed.PermitsNewTag("draft") {
+		test.Fatalf("Closed vocabulary should permit tags matching the patterns")
+	}
+}
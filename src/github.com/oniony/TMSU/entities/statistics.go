@@ -0,0 +1,31 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package entities
+
+import (
+	"time"
+)
+
+// The number of tags, values, files and file tags in the database on a day.
+type StatisticsSnapshot struct {
+	TakenOn      time.Time
+	TagCount     uint
+	ValueCount   uint
+	FileCount    uint
+	FileTagCount uint
+}
+
+type StatisticsSnapshots []*StatisticsSnapshot
@@ -17,6 +17,7 @@ package entities
 
 import (
 	"fmt"
+	"golang.org/x/text/unicode/norm"
 	"sort"
 	"strings"
 	"unicode"
@@ -76,6 +77,15 @@ func (tags Tags) Less(i, j int) bool {
 	return tags[i].Name < tags[j].Name
 }
 
+func (tags Tags) Names() []string {
+	names := make([]string, len(tags))
+	for index, tag := range tags {
+		names[index] = tag.Name
+	}
+
+	return names
+}
+
 func (tags Tags) Contains(searchTag *Tag) bool {
 	for _, tag := range tags {
 		if tag.Id == searchTag.Id {
@@ -121,6 +131,12 @@ type TagFileCount struct {
 	FileCount uint
 }
 
+// Converts a tag or value name to Unicode normalisation form C so that names
+// differing only in how their accented characters are composed are identical.
+func NormalizeName(name string) string {
+	return norm.NFC.String(name)
+}
+
 func ValidateTagName(tagName string) error {
 	switch tagName {
 	case "":
@@ -34,3 +34,19 @@ func TestUniqueTagIds(test *testing.T) {
 		test.Fatalf("Unexpected unique set: %v", uniq)
 	}
 }
+
+func TestNormalizeNameComposesCharacters(test *testing.T) {
+	// set-up
+
+	decomposed := "cafe\u0301"
+
+	// test
+
+	normalized := NormalizeName(decomposed)
+
+	// validate
+
+	if normalized != "caf\u00e9" {
+		test.Fatalf("Unexpected normalised name: %+q", normalized)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package entities
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TextType    = "text"
+	IntegerType = "integer"
+	DateType    = "date"
+	EnumType    = "enum"
+)
+
+var TagTypes = []string{TextType, IntegerType, DateType, EnumType}
+
+type TagDefinition struct {
+	TagId  TagId
+	Type   string
+	Values []string
+}
+
+type TagDefinitions []*TagDefinition
+
+func (definitions TagDefinitions) ForTag(tagId TagId) *TagDefinition {
+	for _, definition := range definitions {
+		if definition.TagId == tagId {
+			return definition
+		}
+	}
+
+	return nil
+}
+
+// Validates that the value name is permitted by the tag's type.
+func (definition TagDefinition) ValidateValue(valueName string) error {
+	if valueName == "" {
+		return nil
+	}
+
+	switch definition.Type {
+	case IntegerType:
+		if _, err := strconv.ParseInt(valueName, 10, 64); err != nil {
+			return fmt.Errorf("value '%v' is not an integer", valueName)
+		}
+	case DateType:
+		if _, err := parseDate(valueName); err != nil {
+			return fmt.Errorf("value '%v' is not a date: expected YYYY, YYYY-MM, YYYY-MM-DD or YYYY-MM-DDTHH:MM:SS", valueName)
+		}
+	case EnumType:
+		if definition.ordinal(valueName) == -1 {
+			return fmt.Errorf("value '%v' is not one of: %v", valueName, strings.Join(definition.Values, ", "))
+		}
+	}
+
+	return nil
+}
+
+// Sorts the values according to the tag's type.
+func (definition TagDefinition) SortValues(values Values) {
+	sort.SliceStable(values, func(i, j int) bool {
+		return definition.Less(values[i].Name, values[j].Name)
+	})
+}
+
+// Determines whether value name a sorts before value name b for the tag's type.
+func (definition TagDefinition) Less(a, b string) bool {
+	switch definition.Type {
+	case IntegerType:
+		ai, aErr := strconv.ParseInt(a, 10, 64)
+		bi, bErr := strconv.ParseInt(b, 10, 64)
+		if aErr == nil && bErr == nil {
+			return ai < bi
+		}
+	case DateType:
+		at, aErr := parseDate(a)
+		bt, bErr := parseDate(b)
+		if aErr == nil && bErr == nil {
+			return at.Before(bt)
+		}
+	case EnumType:
+		ao := definition.ordinal(a)
+		bo := definition.ordinal(b)
+		if ao != -1 && bo != -1 {
+			return ao < bo
+		}
+	}
+
+	return a < b
+}
+
+func ValidateTagType(tagType string) error {
+	for _, validType := range TagTypes {
+		if tagType == validType {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid tag type '%v': expected one of: %v", tagType, strings.Join(TagTypes, ", "))
+}
+
+// unexported
+
+var dateFormats = []string{"2006", "2006-01", "2006-01-02", "2006-01-02T15:04:05"}
+
+func parseDate(text string) (time.Time, error) {
+	var err error
+	for _, format := range dateFormats {
+		var date time.Time
+		date, err = time.Parse(format, text)
+		if err == nil {
+			return date, nil
+		}
+	}
+
+	return time.Time{}, err
+}
+
+func (definition TagDefinition) ordinal(valueName string) int {
+	for index, name := range definition.Values {
+		if name == valueName {
+			return index
+		}
+	}
+
+	return -1
+}
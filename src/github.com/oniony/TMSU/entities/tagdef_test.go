@@ -0,0 +1,92 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package entities
+
+import (
+	"testing"
+)
+
+func TestIntegerTagDefinitionValidatesValues(test *testing.T) {
+	// set-up
+
+	definition := TagDefinition{1, IntegerType, nil}
+
+	// test & validate
+
+	if err := definition.ValidateValue("42"); err != nil {
+		test.Fatalf("Unexpected error: %v", err)
+	}
+	if err := definition.ValidateValue("-7"); err != nil {
+		test.Fatalf("Unexpected error: %v", err)
+	}
+	if err := definition.ValidateValue("forty-two"); err == nil {
+		test.Fatalf("Expected error for non-integer value")
+	}
+}
+
+func TestDateTagDefinitionValidatesValues(test *testing.T) {
+	// set-up
+
+	definition := TagDefinition{1, DateType, nil}
+
+	// test & validate
+
+	for _, valueName := range []string{"2017", "2017-03", "2017-03-14", "2017-03-14T09:26:53"} {
+		if err := definition.ValidateValue(valueName); err != nil {
+			test.Fatalf("Unexpected error for '%v': %v", valueName, err)
+		}
+	}
+	if err := definition.ValidateValue("14/03/2017"); err == nil {
+		test.Fatalf("Expected error for invalid date")
+	}
+}
+
+func TestEnumTagDefinitionSortsByDeclarationOrder(test *testing.T) {
+	// set-up
+
+	definition := TagDefinition{1, EnumType, []string{"low", "medium", "high"}}
+	values := Values{&Value{1, "high"}, &Value{2, "low"}, &Value{3, "medium"}}
+
+	// test
+
+	definition.SortValues(values)
+
+	// validate
+
+	if err := definition.ValidateValue("extreme"); err == nil {
+		test.Fatalf("Expected error for value outside enumeration")
+	}
+	if values[0].Name != "low" || values[1].Name != "medium" || values[2].Name != "high" {
+		test.Fatalf("Unexpected sort order: %v, %v, %v", values[0].Name, values[1].Name, values[2].Name)
+	}
+}
+
+func TestIntegerTagDefinitionSortsNumerically(test *testing.T) {
+	// set-up
+
+	definition := TagDefinition{1, IntegerType, nil}
+	values := Values{&Value{1, "10"}, &Value{2, "9"}, &Value{3, "100"}}
+
+	// test
+
+	definition.SortValues(values)
+
+	// validate
+
+	if values[0].Name != "9" || values[1].Name != "10" || values[2].Name != "100" {
+		test.Fatalf("Unexpected sort order: %v, %v, %v", values[0].Name, values[1].Name, values[2].Name)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package entities
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The colours a tag may be given.
+var TagColours = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+// Descriptive details of a tag, for those sharing a tagging vocabulary.
+type TagInfo struct {
+	TagId       TagId
+	Description string
+	Colour      string
+	Created     time.Time // zero if the tag predates its creation being recorded
+}
+
+type TagInfos []*TagInfo
+
+func (infos TagInfos) ForTag(tagId TagId) *TagInfo {
+	for _, info := range infos {
+		if info.TagId == tagId {
+			return info
+		}
+	}
+
+	return nil
+}
+
+// Validates a tag colour: one of the TagColours or empty for none.
+func ValidateTagColour(colour string) error {
+	if colour == "" {
+		return nil
+	}
+
+	for _, validColour := range TagColours {
+		if colour == validColour {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid colour '%v': expected one of: %v", colour, strings.Join(TagColours, ", "))
+}
@@ -13,6 +13,8 @@
 // You should have received a copy of the GNU General Public License
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
+// Package entities defines the types held in a TMSU database: files, tags,
+// values, the taggings between them and the database settings.
 package entities
 
 import (
@@ -64,6 +64,33 @@ type Value struct {
 
 type Values []*Value
 
+// The number of files tagged with a particular value of a tag.
+type ValueFileCount struct {
+	Id        ValueId
+	Name      string
+	FileCount uint
+}
+
+// The spread of the values applied with a tag.
+type ValueStatistics struct {
+	// the number of files tagged with each value, ordered by value
+	Counts []ValueFileCount
+
+	// the mean of the values, if they are all numbers
+	Mean    float64
+	HasMean bool
+}
+
+// The number of files tagged with a value of the tag.
+func (statistics ValueStatistics) FileCount() uint {
+	var total uint
+	for _, count := range statistics.Counts {
+		total += count.FileCount
+	}
+
+	return total
+}
+
 func (values Values) Len() int {
 	return len(values)
 }
@@ -0,0 +1,48 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package entities
+
+import (
+	"time"
+)
+
+// The outcome of the most recent check of a file's content against its fingerprint.
+type Verification struct {
+	FileId     FileId
+	VerifiedAt time.Time
+	Passed     bool
+}
+
+type Verifications map[FileId]*Verification
+
+// Describes the verification state of the file: 'ok', 'FAILED' or 'unverified'.
+func (verifications Verifications) State(fileId FileId) string {
+	verification, ok := verifications[fileId]
+	switch {
+	case !ok:
+		return "unverified"
+	case verification.Passed:
+		return "ok"
+	default:
+		return "FAILED"
+	}
+}
+
+// Determines whether the file failed its most recent verification.
+func (verifications Verifications) Failed(fileId FileId) bool {
+	verification, ok := verifications[fileId]
+	return ok && !verification.Passed
+}
@@ -0,0 +1,43 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package entities
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerificationState(test *testing.T) {
+	// set-up
+
+	now := time.Now()
+	verifications := Verifications{1: &Verification{1, now, true}, 2: &Verification{2, now, false}}
+
+	// test & validate
+
+	if state := verifications.State(1); state != "ok" {
+		test.Fatalf("Expected 'ok' but was '%v'", state)
+	}
+	if state := verifications.State(2); state != "FAILED" {
+		test.Fatalf("Expected 'FAILED' but was '%v'", state)
+	}
+	if state := verifications.State(3); state != "unverified" {
+		test.Fatalf("Expected 'unverified' but was '%v'", state)
+	}
+	if verifications.Failed(1) || !verifications.Failed(2) || verifications.Failed(3) {
+		test.Fatalf("Only file #2 should have failed verification")
+	}
+}
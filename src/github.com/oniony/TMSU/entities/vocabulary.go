@@ -0,0 +1,149 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package entities
+
+import (
+	"github.com/oniony/TMSU/common/text"
+	"sort"
+	"strings"
+)
+
+// A term of a controlled vocabulary: value Name is canonicalised to Canonical.
+type VocabularyTerm struct {
+	Name      string
+	Canonical string
+}
+
+// The controlled vocabulary of values for a tag.
+type Vocabulary struct {
+	TagId TagId
+	Terms []VocabularyTerm
+}
+
+type Vocabularies []*Vocabulary
+
+func (vocabularies Vocabularies) ForTag(tagId TagId) *Vocabulary {
+	for _, vocabulary := range vocabularies {
+		if vocabulary.TagId == tagId {
+			return vocabulary
+		}
+	}
+
+	return nil
+}
+
+// Retrieves the canonical form of the value name. Terms are matched exactly
+// where possible and otherwise ignoring case.
+func (vocabulary Vocabulary) Canonicalise(valueName string) (string, bool) {
+	if valueName == "" {
+		return "", true
+	}
+
+	for _, term := range vocabulary.Terms {
+		if term.Name == valueName {
+			return term.Canonical, true
+		}
+	}
+
+	for _, term := range vocabulary.Terms {
+		if strings.EqualFold(term.Name, valueName) {
+			return term.Canonical, true
+		}
+	}
+
+	return valueName, false
+}
+
+// The distinct canonical value names, sorted.
+func (vocabulary Vocabulary) CanonicalNames() []string {
+	names := make([]string, 0, len(vocabulary.Terms))
+	seen := make(map[string]bool, len(vocabulary.Terms))
+
+	for _, term := range vocabulary.Terms {
+		if !seen[term.Canonical] {
+			seen[term.Canonical] = true
+			names = append(names, term.Canonical)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// The synonyms of the canonical value name, sorted.
+func (vocabulary Vocabulary) Synonyms(canonical string) []string {
+	synonyms := make([]string, 0, 5)
+
+	for _, term := range vocabulary.Terms {
+		if term.Canonical == canonical && term.Name != canonical {
+			synonyms = append(synonyms, term.Name)
+		}
+	}
+
+	sort.Strings(synonyms)
+
+	return synonyms
+}
+
+// Suggests up to count canonical value names for a value name that is not in
+// the vocabulary: terms the name is a prefix of, then those that are a small
+// number of edits away.
+func (vocabulary Vocabulary) Suggest(valueName string, count int) []string {
+	type suggestion struct {
+		canonical string
+		distance  int
+	}
+
+	termNames := make([]string, len(vocabulary.Terms))
+	canonicalByName := make(map[string]string, len(vocabulary.Terms))
+	for index, term := range vocabulary.Terms {
+		termNames[index] = term.Name
+		canonicalByName[term.Name] = term.Canonical
+	}
+
+	bestByCanonical := make(map[string]int)
+	for _, match := range text.FuzzyMatch(valueName, termNames, text.MisspellingDistance(valueName)) {
+		canonical := canonicalByName[match.Name]
+		if best, ok := bestByCanonical[canonical]; !ok || match.Distance < best {
+			bestByCanonical[canonical] = match.Distance
+		}
+	}
+
+	suggestions := make([]suggestion, 0, len(bestByCanonical))
+	for canonical, distance := range bestByCanonical {
+		suggestions = append(suggestions, suggestion{canonical, distance})
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].distance != suggestions[j].distance {
+			return suggestions[i].distance < suggestions[j].distance
+		}
+
+		return suggestions[i].canonical < suggestions[j].canonical
+	})
+
+	if len(suggestions) > count {
+		suggestions = suggestions[:count]
+	}
+
+	names := make([]string, len(suggestions))
+	for index, suggestion := range suggestions {
+		names[index] = suggestion.canonical
+	}
+
+	return names
+}
@@ -0,0 +1,99 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package entities
+
+import (
+	"testing"
+)
+
+func TestVocabularyCanonicalisesSynonyms(test *testing.T) {
+	// set-up
+
+	vocabulary := countries()
+
+	// test & validate
+
+	for valueName, expected := range map[string]string{
+		"Germany":        "Germany",
+		"germany":        "Germany",
+		"UK":             "United Kingdom",
+		"great britain":  "United Kingdom",
+		"United Kingdom": "United Kingdom",
+		"":               ""} {
+		canonical, ok := vocabulary.Canonicalise(valueName)
+		if !ok {
+			test.Fatalf("Expected '%v' to be in vocabulary", valueName)
+		}
+		if canonical != expected {
+			test.Fatalf("Expected '%v' to be canonicalised to '%v' but was '%v'", valueName, expected, canonical)
+		}
+	}
+
+	if _, ok := vocabulary.Canonicalise("Atlantis"); ok {
+		test.Fatalf("Expected 'Atlantis' not to be in vocabulary")
+	}
+}
+
+func TestVocabularyCanonicalNames(test *testing.T) {
+	// set-up
+
+	vocabulary := countries()
+
+	// test
+
+	names := vocabulary.CanonicalNames()
+
+	// validate
+
+	if len(names) != 3 || names[0] != "France" || names[1] != "Germany" || names[2] != "United Kingdom" {
+		test.Fatalf("Unexpected canonical names: %v", names)
+	}
+}
+
+func TestVocabularySuggestsSimilarTerms(test *testing.T) {
+	// set-up
+
+	vocabulary := countries()
+
+	// test
+
+	misspelt := vocabulary.Suggest("Germny", 3)
+	prefix := vocabulary.Suggest("uni", 3)
+	unrelated := vocabulary.Suggest("Atlantis", 3)
+
+	// validate
+
+	if len(misspelt) != 1 || misspelt[0] != "Germany" {
+		test.Fatalf("Unexpected suggestions for misspelling: %v", misspelt)
+	}
+	if len(prefix) != 1 || prefix[0] != "United Kingdom" {
+		test.Fatalf("Unexpected suggestions for prefix: %v", prefix)
+	}
+	if len(unrelated) != 0 {
+		test.Fatalf("Unexpected suggestions for unrelated value: %v", unrelated)
+	}
+}
+
+// unexported
+
+func countries() Vocabulary {
+	return Vocabulary{1, []VocabularyTerm{
+		{"France", "France"},
+		{"Germany", "Germany"},
+		{"United Kingdom", "United Kingdom"},
+		{"UK", "United Kingdom"},
+		{"Great Britain", "United Kingdom"}}}
+}
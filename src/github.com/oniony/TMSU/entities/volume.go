@@ -0,0 +1,53 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package entities
+
+type VolumeId uint
+
+// A separately mounted file-system, such as a removable drive, the paths of
+// whose files are stored relative to its root so that they remain valid
+// wherever it is mounted.
+//
+// The volume is identified by the UUID of its file-system, where known, and
+// Path is where it was last mounted.
+type Volume struct {
+	Id   VolumeId
+	Name string
+	Uuid string
+	Path string
+}
+
+type Volumes []*Volume
+
+func (volumes Volumes) ByName(name string) *Volume {
+	for _, volume := range volumes {
+		if volume.Name == name {
+			return volume
+		}
+	}
+
+	return nil
+}
+
+func (volumes Volumes) ById(id VolumeId) *Volume {
+	for _, volume := range volumes {
+		if volume.Id == id {
+			return volume
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package query
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/units"
+)
+
+// The built-in pseudo-tags which, when compared, refer to an attribute of the
+// file rather than to a tag.
+var FileAttributes = []string{"ext", "mtime", "name", "size"}
+
+// Whether the name is that of a built-in file attribute.
+func IsFileAttribute(name string) bool {
+	for _, attribute := range FileAttributes {
+		if attribute == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// unexported
+
+func validateFileAttributeComparison(attribute, operator, value string) error {
+	switch attribute {
+	case "size":
+		if operator == "~" {
+			return fmt.Errorf("'size' cannot be matched against a pattern")
+		}
+
+		_, err := units.ParseSize(value)
+		return err
+	case "mtime":
+		if operator == "~" {
+			return fmt.Errorf("'mtime' cannot be matched against a pattern")
+		}
+
+		_, err := units.ParseTime(value)
+		return err
+	case "ext":
+		switch operator {
+		case "=", "==", "!=", "~":
+			return nil
+		default:
+			return fmt.Errorf("'ext' can only be compared with '=', '!=' or '~'")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package query
+
+import (
+	"testing"
+)
+
+func TestFileAttributeComparisonParsing(test *testing.T) {
+	// set-up
+
+	scanner := NewScanner("size > 10M and ext = pdf")
+	parser := NewParser(scanner)
+
+	// test
+
+	expression, err := parser.Parse()
+
+	// validate
+
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	tagNames, err := TagNames(expression)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(tagNames) != 0 {
+		test.Fatalf("Expected no tag names but got %v", tagNames)
+	}
+}
+
+func TestInvalidFileAttributeComparisonParsing(test *testing.T) {
+	// set-up
+
+	scanner := NewScanner("mtime > someday")
+	parser := NewParser(scanner)
+
+	// test
+
+	_, err := parser.Parse()
+
+	// validate
+
+	if err == nil {
+		test.Fatal("Expected error for invalid time")
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package query
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// A named query fragment, e.g. 'recent(n) = mtime > now-${n}d', that a query
+// calls as 'recent(7)'. The parser expands a call by substituting the
+// arguments for the parameters referenced as '$n' or '${n}' in the body.
+type Macro struct {
+	Name   string
+	Params []string
+	Body   string
+}
+
+// Parses macro definitions, of the form NAME(PARAM,...) = BODY, separated by
+// semicolons.
+func ParseMacros(text string) ([]Macro, error) {
+	macros := make([]Macro, 0, 10)
+	names := make(map[string]bool)
+
+	for _, definition := range strings.Split(text, ";") {
+		if strings.TrimSpace(definition) == "" {
+			continue
+		}
+
+		macro, err := parseMacro(definition)
+		if err != nil {
+			return nil, err
+		}
+
+		if names[macro.Name] {
+			return nil, fmt.Errorf("macro '%v' is defined more than once", macro.Name)
+		}
+		names[macro.Name] = true
+
+		macros = append(macros, macro)
+	}
+
+	return macros, nil
+}
+
+// The macro's body with the arguments substituted for its parameters.
+func (macro Macro) Expand(args []string) (string, error) {
+	if len(args) != len(macro.Params) {
+		return "", fmt.Errorf("macro '%v' takes %v argument(s) but was given %v", macro.Name, len(macro.Params), len(args))
+	}
+
+	values := make(map[string]string, len(args))
+	for index, param := range macro.Params {
+		values[param] = escapeArgument(args[index])
+	}
+
+	body := []rune(macro.Body)
+	expanded := ""
+
+	for index := 0; index < len(body); index++ {
+		if body[index] != '$' {
+			expanded += string(body[index])
+			continue
+		}
+
+		name, length := referencedParam(body[index+1:])
+		value, ok := values[name]
+		if !ok {
+			expanded += string(body[index])
+			continue
+		}
+
+		expanded += value
+		index += length
+	}
+
+	return expanded, nil
+}
+
+// unexported
+
+// The depth to which macros may call one another, beyond which a macro is
+// assumed to be calling itself.
+const maxMacroDepth = 16
+
+func parseMacro(definition string) (Macro, error) {
+	openIndex := strings.Index(definition, "(")
+	closeIndex := strings.Index(definition, ")")
+	if openIndex < 0 || closeIndex < openIndex {
+		return Macro{}, fmt.Errorf("invalid macro '%v': expected NAME(PARAM,...) = BODY", strings.TrimSpace(definition))
+	}
+
+	name := strings.TrimSpace(definition[:openIndex])
+	if !isMacroIdentifier(strings.Replace(name, "-", "_", -1)) {
+		return Macro{}, fmt.Errorf("invalid macro name '%v'", name)
+	}
+
+	params := make([]string, 0, 5)
+	for _, param := range strings.Split(definition[openIndex+1:closeIndex], ",") {
+		param = strings.TrimSpace(param)
+		if param == "" {
+			continue
+		}
+		if !isMacroIdentifier(param) {
+			return Macro{}, fmt.Errorf("invalid parameter '%v' for macro '%v'", param, name)
+		}
+
+		params = append(params, param)
+	}
+
+	body := strings.TrimSpace(definition[closeIndex+1:])
+	if !strings.HasPrefix(body, "=") {
+		return Macro{}, fmt.Errorf("invalid macro '%v': expected '=' after the parameters", name)
+	}
+
+	body = strings.TrimSpace(body[1:])
+	if body == "" {
+		return Macro{}, fmt.Errorf("invalid macro '%v': no body specified", name)
+	}
+
+	return Macro{name, params, body}, nil
+}
+
+// The name of the parameter referenced at the start of the text, following a
+// '$', and the number of runes the reference occupies.
+func referencedParam(text []rune) (string, int) {
+	if len(text) > 0 && text[0] == '{' {
+		for index, r := range text {
+			if r == '}' {
+				return string(text[1:index]), index + 1
+			}
+		}
+
+		return "", 0
+	}
+
+	length := 0
+	for length < len(text) && isMacroIdentifierRune(text[length]) {
+		length++
+	}
+
+	return string(text[:length]), length
+}
+
+// Escapes the characters that would otherwise end an argument's symbol, so
+// that an argument is substituted as a single symbol.
+func escapeArgument(arg string) string {
+	escaped := ""
+
+	for _, r := range arg {
+		switch {
+		case unicode.IsSpace(r), strings.ContainsRune(`()=!<>~"\`, r):
+			escaped += `\` + string(r)
+		default:
+			escaped += string(r)
+		}
+	}
+
+	return escaped
+}
+
+func isMacroIdentifier(text string) bool {
+	if text == "" {
+		return false
+	}
+
+	for _, r := range text {
+		if !isMacroIdentifierRune(r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isMacroIdentifierRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,139 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package query
+
+import (
+	"testing"
+)
+
+func TestParseMacros(test *testing.T) {
+	// test
+
+	macros, err := ParseMacros("recent(n) = mtime > now-${n}d; lossless() = flac or wav")
+
+	// validate
+
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(macros) != 2 {
+		test.Fatalf("Expected 2 macros but got %v", len(macros))
+	}
+	if macros[0].Name != "recent" || len(macros[0].Params) != 1 || macros[0].Params[0] != "n" || macros[0].Body != "mtime > now-${n}d" {
+		test.Fatalf("Unexpected macro %v", macros[0])
+	}
+	if macros[1].Name != "lossless" || len(macros[1].Params) != 0 || macros[1].Body != "flac or wav" {
+		test.Fatalf("Unexpected macro %v", macros[1])
+	}
+}
+
+func TestParseInvalidMacros(test *testing.T) {
+	for _, text := range []string{"recent = mtime > now", "recent(n) mtime", "recent(n) =", "re cent() = a", "f(a b) = a", "f() = a; f() = b"} {
+		if _, err := ParseMacros(text); err == nil {
+			test.Fatalf("Expected error for macros '%v'", text)
+		}
+	}
+}
+
+func TestMacroExpansion(test *testing.T) {
+	// set-up
+
+	macro := Macro{"between", []string{"tag", "low", "high"}, "$tag >= ${low} and $tag <= $high and $other"}
+
+	// test
+
+	expanded, err := macro.Expand([]string{"year", "1990", "1999"})
+
+	// validate
+
+	if err != nil {
+		test.Fatal(err)
+	}
+	if expanded != "year >= 1990 and year <= 1999 and $other" {
+		test.Fatalf("Unexpected expansion '%v'", expanded)
+	}
+}
+
+func TestMacroCallParsing(test *testing.T) {
+	// set-up
+
+	macros, err := ParseMacros("lossless() = flac or wav; between(tag, low, high) = $tag >= $low and $tag <= $high")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	expression, err := ParseWithMacros("music lossless() not between(year, 1990,1999)", macros)
+
+	// validate
+
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	and := validateAnd(expression)
+	left := validateAnd(and.LeftOperand)
+	validateTag(left.LeftOperand, "music", test)
+	or := validateOr(left.RightOperand)
+	validateTag(or.LeftOperand, "flac", test)
+	validateTag(or.RightOperand, "wav", test)
+
+	between := validateAnd(validateNot(and.RightOperand).Operand)
+	low := validateComparison(between.LeftOperand, ">=", test)
+	validateTag(low.Tag, "year", test)
+	validateValue(low.Value, "1990", test)
+	high := validateComparison(between.RightOperand, "<=", test)
+	validateValue(high.Value, "1999", test)
+}
+
+func TestMacroNameWithoutCallParsing(test *testing.T) {
+	// set-up
+
+	macros, err := ParseMacros("lossless() = flac or wav")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// test
+
+	expression, err := ParseWithMacros("lossless", macros)
+
+	// validate
+
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	validateTag(expression, "lossless", test)
+}
+
+func TestInvalidMacroCallParsing(test *testing.T) {
+	// set-up
+
+	macros, err := ParseMacros("recent(n) = mtime > now-${n}d; loop() = a or loop()")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// test & validate
+
+	for _, text := range []string{"recent()", "recent(1, 2)", "recent(7", "recent(x)", "loop()"} {
+		if _, err := ParseWithMacros(text, macros); err == nil {
+			test.Fatalf("Expected error for query '%v'", text)
+		}
+	}
+}
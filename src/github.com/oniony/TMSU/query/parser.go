@@ -17,14 +17,36 @@ package query
 
 import (
 	"fmt"
+	"strings"
 )
 
+// the prefix of a term matching the indexed content of files
+const contentPrefix = "content:"
+
+// the prefix of a term matching the files related to a file
+const relatedPrefix = "related-to:"
+
+// The value name that, when compared for equality, matches any value.
+const AnyValue = "*"
+
 type Parser struct {
 	scanner *Scanner
+	macros  map[string]Macro
+	depth   int
 }
 
 func NewParser(scanner *Scanner) Parser {
-	return Parser{scanner}
+	return Parser{scanner, nil, 0}
+}
+
+// Creates a parser that expands calls to the macros.
+func NewMacroParser(scanner *Scanner, macros []Macro) Parser {
+	macrosByName := make(map[string]Macro, len(macros))
+	for _, macro := range macros {
+		macrosByName[macro.Name] = macro
+	}
+
+	return Parser{scanner, macrosByName, 0}
 }
 
 func (parser Parser) Parse() (Expression, error) {
@@ -53,10 +75,31 @@ type ComparisonExpression struct {
 	Value    ValueExpression
 }
 
+// Whether the comparison is against any value of the tag, i.e. 'TAG=*', rather
+// than a particular value.
+func (expression ComparisonExpression) AnyValue() bool {
+	switch expression.Operator {
+	case "=", "==", "!=":
+		return expression.Value.Name == AnyValue && !IsFileAttribute(expression.Tag.Name)
+	default:
+		return false
+	}
+}
+
 type NotExpression struct {
 	Operand Expression
 }
 
+// Matches files whose indexed content contains the text.
+type ContentExpression struct {
+	Text string
+}
+
+// Matches files related, in either direction, to the file at the path.
+type RelatedExpression struct {
+	Path string
+}
+
 type TagExpression struct {
 	Name string
 }
@@ -214,12 +257,50 @@ func (parser Parser) comparison() (Expression, error) {
 		return nil, err
 	}
 
+	if macro, ok := parser.macros[tag.Name]; ok {
+		token, err := parser.scanner.LookAhead()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := token.(OpenParenToken); ok {
+			return parser.call(macro)
+		}
+	}
+
+	if strings.HasPrefix(tag.Name, contentPrefix) {
+		text := strings.TrimPrefix(tag.Name, contentPrefix)
+		if text == "" {
+			return nil, fmt.Errorf("no text specified for '%v'", contentPrefix)
+		}
+
+		return ContentExpression{text}, nil
+	}
+
+	if strings.HasPrefix(tag.Name, relatedPrefix) {
+		path := strings.TrimPrefix(tag.Name, relatedPrefix)
+		if path == "" {
+			return nil, fmt.Errorf("no path specified for '%v'", relatedPrefix)
+		}
+
+		return RelatedExpression{path}, nil
+	}
+
 	token, err := parser.scanner.LookAhead()
 	if err != nil {
 		return nil, err
 	}
 
 	switch typedToken := token.(type) {
+	case WithoutValueOperatorToken:
+		parser.scanner.Next()
+
+		if IsFileAttribute(tag.Name) {
+			return nil, fmt.Errorf("'without value' cannot be applied to '%v'", tag.Name)
+		}
+
+		// tagged, but not with any value
+		return AndExpression{tag, NotExpression{ComparisonExpression{tag, "=", ValueExpression{AnyValue}}}}, nil
 	case ComparisonOperatorToken:
 		parser.scanner.Next()
 
@@ -228,6 +309,12 @@ func (parser Parser) comparison() (Expression, error) {
 			return nil, err
 		}
 
+		if IsFileAttribute(tag.Name) {
+			if err := validateFileAttributeComparison(tag.Name, typedToken.operator, value.Name); err != nil {
+				return nil, err
+			}
+		}
+
 		return ComparisonExpression{tag, typedToken.operator, value}, nil
 	}
 
@@ -261,3 +348,48 @@ func (parser Parser) value() (ValueExpression, error) {
 		return ValueExpression{}, fmt.Errorf("unexpected token: %v", Type(token))
 	}
 }
+
+// Expands a call to the macro, the arguments of which are symbols separated by
+// commas or whitespace.
+func (parser Parser) call(macro Macro) (Expression, error) {
+	parser.scanner.Next() // '('
+
+	args := make([]string, 0, len(macro.Params))
+	for closed := false; !closed; {
+		token, err := parser.scanner.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch typedToken := token.(type) {
+		case SymbolToken:
+			for _, arg := range strings.Split(typedToken.name, ",") {
+				if arg != "" {
+					args = append(args, arg)
+				}
+			}
+		case CloseParenToken:
+			closed = true
+		case EndToken:
+			return nil, fmt.Errorf("unterminated call to macro '%v'", macro.Name)
+		default:
+			return nil, fmt.Errorf("unexpected token in call to macro '%v': %v", macro.Name, Type(token))
+		}
+	}
+
+	if parser.depth >= maxMacroDepth {
+		return nil, fmt.Errorf("macro '%v' is nested too deeply: does it call itself?", macro.Name)
+	}
+
+	body, err := macro.Expand(args)
+	if err != nil {
+		return nil, err
+	}
+
+	expression, err := Parser{NewScanner(body), parser.macros, parser.depth + 1}.Parse()
+	if err != nil {
+		return nil, fmt.Errorf("in macro '%v': %v", macro.Name, err)
+	}
+
+	return expression, nil
+}
@@ -50,6 +50,88 @@ func TestTagEqualValueParsing(test *testing.T) {
 	validateValue(comparison.Value, "2000", test)
 }
 
+func TestTagEqualAnyValueParsing(test *testing.T) {
+	scanner := NewScanner("actor=* and not year=2000")
+	parser := NewParser(scanner)
+
+	expression, err := parser.Parse()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	dump(expression)
+
+	and := validateAnd(expression)
+	comparison := validateComparison(and.LeftOperand, "=", test)
+	validateTag(comparison.Tag, "actor", test)
+	validateValue(comparison.Value, "*", test)
+
+	if !comparison.AnyValue() {
+		test.Fatal("Expected comparison against any value.")
+	}
+
+	valueNames, err := ExactValueNames(expression)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(valueNames) != 1 || valueNames[0] != "2000" {
+		test.Fatalf("Expected value names [2000] but were %v.", valueNames)
+	}
+}
+
+func TestTagWithoutValueParsing(test *testing.T) {
+	scanner := NewScanner("month without value and not year")
+	parser := NewParser(scanner)
+
+	expression, err := parser.Parse()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	dump(expression)
+
+	and := validateAnd(expression)
+	withoutValue := validateAnd(and.LeftOperand)
+	validateTag(withoutValue.LeftOperand, "month", test)
+	comparison := validateComparison(validateNot(withoutValue.RightOperand).Operand, "=", test)
+	validateTag(comparison.Tag, "month", test)
+
+	if !comparison.AnyValue() {
+		test.Fatal("Expected comparison against any value.")
+	}
+
+	validateTag(validateNot(and.RightOperand).Operand, "year", test)
+}
+
+func TestWithoutTagParsing(test *testing.T) {
+	scanner := NewScanner(`month without or without "value"`)
+	parser := NewParser(scanner)
+
+	expression, err := parser.Parse()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	dump(expression)
+
+	or := validateOr(expression)
+	and := validateAnd(or.LeftOperand)
+	validateTag(and.LeftOperand, "month", test)
+	validateTag(and.RightOperand, "without", test)
+	and = validateAnd(or.RightOperand)
+	validateTag(and.LeftOperand, "without", test)
+	validateTag(and.RightOperand, "value", test)
+}
+
+func TestFileAttributeWithoutValueParsing(test *testing.T) {
+	scanner := NewScanner("size without value")
+	parser := NewParser(scanner)
+
+	if _, err := parser.Parse(); err == nil {
+		test.Fatal("Expected error for file attribute without value")
+	}
+}
+
 func TestTagGreaterThanValueParsing(test *testing.T) {
 	scanner := NewScanner("year>2000")
 	parser := NewParser(scanner)
@@ -377,6 +459,113 @@ func TestAndParenOrParsing(test *testing.T) {
 	validateTag(or.RightOperand, "sweetcorn", test)
 }
 
+func TestTagMatchesQuotedPatternParsing(test *testing.T) {
+	scanner := NewScanner(`genre ~ "^(rock|jazz) \\d+$"`)
+	parser := NewParser(scanner)
+
+	expression, err := parser.Parse()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	dump(expression)
+
+	comparison := validateComparison(expression, "~", test)
+	validateTag(comparison.Tag, "genre", test)
+	validateValue(comparison.Value, `^(rock|jazz) \d+$`, test)
+}
+
+func TestFileNameMatchesPatternParsing(test *testing.T) {
+	scanner := NewScanner(`music and name~*.flac`)
+	parser := NewParser(scanner)
+
+	expression, err := parser.Parse()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	dump(expression)
+
+	and := validateAnd(expression)
+	validateTag(and.LeftOperand, "music", test)
+	comparison := validateComparison(and.RightOperand, "~", test)
+	validateTag(comparison.Tag, "name", test)
+	validateValue(comparison.Value, "*.flac", test)
+
+	tagNames, err := TagNames(expression)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if len(tagNames) != 1 || tagNames[0] != "music" {
+		test.Fatalf("Unexpected tag names: %v", tagNames)
+	}
+}
+
+func TestContentParsing(test *testing.T) {
+	scanner := NewScanner(`invoice and content:"acme corp"`)
+	parser := NewParser(scanner)
+
+	expression, err := parser.Parse()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	dump(expression)
+
+	and := validateAnd(expression)
+	validateTag(and.LeftOperand, "invoice", test)
+
+	content, ok := and.RightOperand.(ContentExpression)
+	if !ok {
+		test.Fatalf("Expected content expression but was %T", and.RightOperand)
+	}
+	if content.Text != "acme corp" {
+		test.Fatalf("Expected content text 'acme corp' but was '%v'", content.Text)
+	}
+	if !HasContent(expression) {
+		test.Fatal("Expected expression to match on content")
+	}
+}
+
+func TestRelatedParsing(test *testing.T) {
+	scanner := NewScanner(`not related-to:raw/img_0001.cr2`)
+	parser := NewParser(scanner)
+
+	expression, err := parser.Parse()
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	dump(expression)
+
+	not, ok := expression.(NotExpression)
+	if !ok {
+		test.Fatalf("Expected not expression but was %T", expression)
+	}
+
+	related, ok := not.Operand.(RelatedExpression)
+	if !ok {
+		test.Fatalf("Expected related expression but was %T", not.Operand)
+	}
+	if related.Path != "raw/img_0001.cr2" {
+		test.Fatalf("Expected path 'raw/img_0001.cr2' but was '%v'", related.Path)
+	}
+
+	mapped := MapPaths(expression, func(path string) string { return "/photos/" + path })
+	if mapped.(NotExpression).Operand.(RelatedExpression).Path != "/photos/raw/img_0001.cr2" {
+		test.Fatalf("Unexpected mapped expression: %v", mapped)
+	}
+}
+
+func TestUnterminatedQuoteParsing(test *testing.T) {
+	scanner := NewScanner(`genre ~ "rock`)
+	parser := NewParser(scanner)
+
+	if _, err := parser.Parse(); err == nil {
+		test.Fatal("Expected error for unterminated quoted string")
+	}
+}
+
 // unexported
 
 func validateNot(expression Expression) NotExpression {
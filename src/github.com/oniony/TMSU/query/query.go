@@ -13,6 +13,8 @@
 // You should have received a copy of the GNU General Public License
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
+// Package query parses TMSU queries, such as 'music and not (rock or year <
+// 2000)', into expressions that the storage package can evaluate.
 package query
 
 import (
@@ -26,6 +28,14 @@ func Parse(query string) (Expression, error) {
 	return parser.Parse()
 }
 
+// Parses the query, expanding calls to the macros.
+func ParseWithMacros(query string, macros []Macro) (Expression, error) {
+	scanner := NewScanner(query)
+	parser := NewMacroParser(scanner, macros)
+
+	return parser.Parse()
+}
+
 // Creates an 'and' expression for all the tag names specified
 func HasAll(tagNames []string) Expression {
 	if len(tagNames) == 0 {
@@ -54,13 +64,96 @@ func ExactValueNames(expression Expression) ([]string, error) {
 	return exactValueNames(expression, names)
 }
 
+// Whether the expression matches on the indexed content of files
+func HasContent(expression Expression) bool {
+	switch exp := expression.(type) {
+	case ContentExpression:
+		return true
+	case NotExpression:
+		return HasContent(exp.Operand)
+	case AndExpression:
+		return HasContent(exp.LeftOperand) || HasContent(exp.RightOperand)
+	case OrExpression:
+		return HasContent(exp.LeftOperand) || HasContent(exp.RightOperand)
+	default:
+		return false
+	}
+}
+
+// Rewrites an expression, transforming every tag and value name with the
+// specified function
+func MapNames(expression Expression, mapping func(string) string) (Expression, error) {
+	switch exp := expression.(type) {
+	case EmptyExpression, ContentExpression, RelatedExpression:
+		return exp, nil
+	case TagExpression:
+		return TagExpression{mapping(exp.Name)}, nil
+	case NotExpression:
+		operand, err := MapNames(exp.Operand, mapping)
+		if err != nil {
+			return nil, err
+		}
+
+		return NotExpression{operand}, nil
+	case AndExpression:
+		left, err := MapNames(exp.LeftOperand, mapping)
+		if err != nil {
+			return nil, err
+		}
+
+		right, err := MapNames(exp.RightOperand, mapping)
+		if err != nil {
+			return nil, err
+		}
+
+		return AndExpression{left, right}, nil
+	case OrExpression:
+		left, err := MapNames(exp.LeftOperand, mapping)
+		if err != nil {
+			return nil, err
+		}
+
+		right, err := MapNames(exp.RightOperand, mapping)
+		if err != nil {
+			return nil, err
+		}
+
+		return OrExpression{left, right}, nil
+	case ComparisonExpression:
+		if IsFileAttribute(exp.Tag.Name) {
+			return exp, nil
+		}
+
+		return ComparisonExpression{TagExpression{mapping(exp.Tag.Name)}, exp.Operator, ValueExpression{mapping(exp.Value.Name)}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported token type '%t'", exp)
+	}
+}
+
+// Rewrites an expression, transforming the path of every file relation term
+// with the specified function
+func MapPaths(expression Expression, mapping func(string) string) Expression {
+	switch exp := expression.(type) {
+	case RelatedExpression:
+		return RelatedExpression{mapping(exp.Path)}
+	case NotExpression:
+		return NotExpression{MapPaths(exp.Operand, mapping)}
+	case AndExpression:
+		return AndExpression{MapPaths(exp.LeftOperand, mapping), MapPaths(exp.RightOperand, mapping)}
+	case OrExpression:
+		return OrExpression{MapPaths(exp.LeftOperand, mapping), MapPaths(exp.RightOperand, mapping)}
+	default:
+		return exp
+	}
+}
+
 // unexported
 
 func tagNames(expression Expression, names []string) ([]string, error) {
 	var err error
 
 	switch exp := expression.(type) {
-	case EmptyExpression:
+	case EmptyExpression, ContentExpression, RelatedExpression:
 		// nowt
 	case TagExpression:
 		names = append(names, exp.Name)
@@ -90,7 +183,9 @@ func tagNames(expression Expression, names []string) ([]string, error) {
 			return nil, err
 		}
 	case ComparisonExpression:
-		names = append(names, exp.Tag.Name)
+		if !IsFileAttribute(exp.Tag.Name) {
+			names = append(names, exp.Tag.Name)
+		}
 	default:
 		return nil, fmt.Errorf("unsupported token type '%t'", exp)
 	}
@@ -102,7 +197,7 @@ func exactValueNames(expression Expression, names []string) ([]string, error) {
 	var err error
 
 	switch exp := expression.(type) {
-	case EmptyExpression:
+	case EmptyExpression, ContentExpression, RelatedExpression:
 		// nowt
 	case TagExpression:
 		// nowt
@@ -132,10 +227,18 @@ func exactValueNames(expression Expression, names []string) ([]string, error) {
 			return nil, err
 		}
 	case ComparisonExpression:
+		if IsFileAttribute(exp.Tag.Name) {
+			break
+		}
+
+		if exp.AnyValue() {
+			break
+		}
+
 		switch exp.Operator {
 		case "=", "==", "!=":
 			names = append(names, exp.Value.Name)
-		case "<", ">", "<=", ">=":
+		case "<", ">", "<=", ">=", "~":
 			// do nowt
 		default:
 			return nil, fmt.Errorf("unsupported operator '%v'", exp.Operator)
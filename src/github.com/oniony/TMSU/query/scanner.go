@@ -43,6 +43,8 @@ func Type(token Token) string {
 		return "'or'"
 	case ComparisonOperatorToken:
 		return typedToken.operator
+	case WithoutValueOperatorToken:
+		return "'without value'"
 	case EndToken:
 		return "EOF"
 	case nil:
@@ -78,6 +80,10 @@ type ComparisonOperatorToken struct {
 	operator string
 }
 
+// The postfix operator 'without value', which follows a tag name.
+type WithoutValueOperatorToken struct {
+}
+
 type Scanner struct {
 	stream    *strings.Reader
 	lookAhead Token
@@ -136,6 +142,10 @@ func (scanner *Scanner) readToken() (Token, error) {
 		return CloseParenToken{}, nil
 	case r == rune('!'), r == rune('='), r == rune('<'), r == rune('>'):
 		return scanner.readComparisonOperatorToken(r)
+	case r == rune('~'):
+		return ComparisonOperatorToken{"~"}, nil
+	case r == rune('"'):
+		return scanner.readQuotedToken()
 	case unicode.IsOneOf(symbolChars, r), r == rune('\\'):
 		scanner.stream.UnreadRune()
 		return scanner.readTextToken()
@@ -169,11 +179,89 @@ func (scanner *Scanner) readTextToken() (Token, error) {
 		return ComparisonOperatorToken{"<="}, nil
 	case "ge", "GE":
 		return ComparisonOperatorToken{">="}, nil
+	case "without", "WITHOUT":
+		// only an operator when followed by 'value', so that 'without' remains
+		// a valid tag name
+		if scanner.skipWord("value", "VALUE") {
+			return WithoutValueOperatorToken{}, nil
+		}
+	}
+
+	return SymbolToken{text}, nil
+}
+
+// Reads a double-quoted string, within which the operators and whitespace have
+// no special meaning, as a symbol.
+func (scanner *Scanner) readQuotedToken() (Token, error) {
+	text, err := scanner.readQuoted()
+	if err != nil {
+		return nil, err
 	}
 
 	return SymbolToken{text}, nil
 }
 
+// Reads the remainder of a double-quoted section. Only quotes and backslashes
+// are escaped so that regular expressions can be written naturally.
+func (scanner *Scanner) readQuoted() (string, error) {
+	text := ""
+	escaped := false
+
+	for {
+		r, _, err := scanner.stream.ReadRune()
+		if err == io.EOF {
+			return "", fmt.Errorf("unterminated quoted string")
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch {
+		case escaped:
+			if r != rune('"') && r != rune('\\') {
+				text += "\\"
+			}
+			text += string(r)
+			escaped = false
+		case r == rune('\\'):
+			escaped = true
+		case r == rune('"'):
+			return text, nil
+		default:
+			text += string(r)
+		}
+	}
+}
+
+// Skips the whitespace and word that follow if the word is one of those
+// specified, otherwise leaves the stream where it was.
+func (scanner *Scanner) skipWord(words ...string) bool {
+	offset, err := scanner.stream.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false
+	}
+
+	r, _, err := scanner.stream.ReadRune()
+	for err == nil && unicode.IsSpace(r) {
+		r, _, err = scanner.stream.ReadRune()
+	}
+	if err == nil && r != rune('"') {
+		scanner.stream.UnreadRune()
+
+		text, err := scanner.readString()
+		if err == nil {
+			for _, word := range words {
+				if text == word {
+					return true
+				}
+			}
+		}
+	}
+
+	scanner.stream.Seek(offset, io.SeekStart)
+	return false
+}
+
 func (scanner *Scanner) readComparisonOperatorToken(r rune) (Token, error) {
 	switch r {
 	case rune('='), rune('!'), rune('<'), rune('>'):
@@ -220,9 +308,16 @@ func (scanner *Scanner) readString() (string, error) {
 		}
 
 		switch {
-		case unicode.IsSpace(r), r == rune(')'), r == rune('('), r == rune('='), r == rune('!'), r == rune('<'), r == rune('>'):
+		case unicode.IsSpace(r), r == rune(')'), r == rune('('), r == rune('='), r == rune('!'), r == rune('<'), r == rune('>'), r == rune('~'):
 			scanner.stream.UnreadRune()
 			return text, nil
+		case r == rune('"'):
+			quoted, err := scanner.readQuoted()
+			if err != nil {
+				return "", err
+			}
+
+			text += quoted
 		case unicode.IsOneOf(symbolChars, r):
 			text += string(r)
 		default:
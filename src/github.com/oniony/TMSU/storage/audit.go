@@ -0,0 +1,43 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage/database"
+)
+
+// Retrieves the audit trail, oldest first.
+func (store *Storage) AuditEntries(tx *Tx) (entities.AuditEntries, error) {
+	return database.AuditEntries(tx.tx)
+}
+
+// Retrieves the audit trail of the file with the specified path, oldest first.
+func (store *Storage) AuditEntriesByPath(tx *Tx, path string) (entities.AuditEntries, error) {
+	return database.AuditEntriesByPath(tx.tx, store.relPath(path))
+}
+
+// Retrieves the audit trail of the tag with the specified name, including the
+// implications that imply it, oldest first.
+func (store *Storage) AuditEntriesByTag(tx *Tx, tagName string) (entities.AuditEntries, error) {
+	return database.AuditEntriesByTag(tx.tx, tagName)
+}
+
+// The absolute path of the file an audit entry applies to, or the empty string
+// if it does not apply to a file.
+func (store *Storage) AuditEntryPath(entry entities.AuditEntry) string {
+	return store.absStoredPath(entry.Path())
+}
@@ -0,0 +1,36 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage/database"
+)
+
+// Retrieves the bundles, ordered by name.
+func (store *Storage) Bundles(tx *Tx) (entities.Bundles, error) {
+	return database.Bundles(tx.tx)
+}
+
+// Adds a bundle of the tags, in TAG[=VALUE] form.
+func (store *Storage) AddBundle(tx *Tx, name string, tagArgs []string) error {
+	return database.InsertBundle(tx.tx, name, tagArgs)
+}
+
+// Removes a bundle.
+func (store *Storage) DeleteBundle(tx *Tx, name string) error {
+	return database.DeleteBundle(tx.tx, name)
+}
@@ -0,0 +1,56 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/query"
+	"github.com/oniony/TMSU/storage/database"
+)
+
+// Whether the full-text index of file contents has been created.
+func (storage *Storage) ContentIndexExists(tx *Tx) (bool, error) {
+	return database.ContentIndexExists(tx.tx)
+}
+
+// Creates the full-text index of file contents if it does not already exist.
+func (storage *Storage) CreateContentIndex(tx *Tx) error {
+	return database.CreateContentIndex(tx.tx)
+}
+
+// Records the text content of a file in the full-text index.
+func (storage *Storage) UpdateFileContent(tx *Tx, fileId entities.FileId, content string) error {
+	return database.UpdateFileContent(tx.tx, fileId, content)
+}
+
+// unexported
+
+func (storage *Storage) checkContentIndexed(tx *Tx, expression query.Expression) error {
+	if !query.HasContent(expression) {
+		return nil
+	}
+
+	exists, err := storage.ContentIndexExists(tx)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("file contents have not been indexed: use the 'index' subcommand")
+	}
+
+	return nil
+}
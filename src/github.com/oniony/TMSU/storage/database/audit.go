@@ -0,0 +1,219 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"database/sql"
+	"github.com/oniony/TMSU/entities"
+	_path "path"
+)
+
+// Retrieves the audit trail, oldest first.
+func AuditEntries(tx *Tx) (entities.AuditEntries, error) {
+	sql := `
+SELECT id, changed_at, user, command, action, directory, name, tag, value, implied_tag, implied_value
+FROM audit
+ORDER BY id`
+
+	rows, err := tx.Query(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readAuditEntries(rows, make(entities.AuditEntries, 0, 10))
+}
+
+// Retrieves the audit trail of the file with the specified path, oldest first.
+func AuditEntriesByPath(tx *Tx, path string) (entities.AuditEntries, error) {
+	sql := `
+SELECT id, changed_at, user, command, action, directory, name, tag, value, implied_tag, implied_value
+FROM audit
+WHERE directory = ? COLLATE ` + pathCollation + ` AND name = ? COLLATE ` + pathCollation + `
+ORDER BY id`
+
+	rows, err := tx.Query(sql, _path.Dir(path), _path.Base(path))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readAuditEntries(rows, make(entities.AuditEntries, 0, 10))
+}
+
+// Retrieves the audit trail of the tag with the specified name, including the
+// implications that imply it, oldest first.
+func AuditEntriesByTag(tx *Tx, tagName string) (entities.AuditEntries, error) {
+	sql := `
+SELECT id, changed_at, user, command, action, directory, name, tag, value, implied_tag, implied_value
+FROM audit
+WHERE tag = ? OR implied_tag = ?
+ORDER BY id`
+
+	rows, err := tx.Query(sql, tagName, tagName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readAuditEntries(rows, make(entities.AuditEntries, 0, 10))
+}
+
+// unexported
+
+// The audit table records every change to the tagging of files and to the
+// tag implications. It is maintained by triggers, as is the file tag change
+// table, but the user and command responsible are filled in by the
+// transaction when it commits as the triggers cannot know them: changes made
+// other than by TMSU are left unattributed.
+func createAuditTable(tx *sql.Tx) error {
+	sql := `
+CREATE TABLE IF NOT EXISTS audit (
+    id INTEGER PRIMARY KEY,
+    changed_at DATETIME NOT NULL,
+    user TEXT NOT NULL DEFAULT '',
+    command TEXT NOT NULL DEFAULT '',
+    action TEXT NOT NULL,
+    directory TEXT NOT NULL DEFAULT '',
+    name TEXT NOT NULL DEFAULT '',
+    tag TEXT NOT NULL,
+    value TEXT NOT NULL DEFAULT '',
+    implied_tag TEXT NOT NULL DEFAULT '',
+    implied_value TEXT NOT NULL DEFAULT ''
+)`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	sql = `
+CREATE INDEX IF NOT EXISTS idx_audit_file
+ON audit(directory, name)`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	sql = `
+CREATE INDEX IF NOT EXISTS idx_audit_tag
+ON audit(tag)`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	sql = `
+CREATE TRIGGER IF NOT EXISTS audit_file_tag_added AFTER INSERT ON file_tag
+WHEN NOT NEW.implied
+BEGIN
+    INSERT INTO audit (changed_at, action, directory, name, tag, value)
+    SELECT strftime('%Y-%m-%d %H:%M:%f', 'now'), 'tag', f.directory, f.name, t.name, coalesce(v.name, '')
+    FROM file f, tag t
+    LEFT OUTER JOIN value v ON v.id = NEW.value_id
+    WHERE f.id = NEW.file_id AND t.id = NEW.tag_id;
+END`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	sql = `
+CREATE TRIGGER IF NOT EXISTS audit_file_tag_removed AFTER DELETE ON file_tag
+WHEN NOT OLD.implied
+BEGIN
+    INSERT INTO audit (changed_at, action, directory, name, tag, value)
+    SELECT strftime('%Y-%m-%d %H:%M:%f', 'now'), 'untag', f.directory, f.name, t.name, coalesce(v.name, '')
+    FROM file f, tag t
+    LEFT OUTER JOIN value v ON v.id = OLD.value_id
+    WHERE f.id = OLD.file_id AND t.id = OLD.tag_id;
+END`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	sql = `
+CREATE TRIGGER IF NOT EXISTS audit_implication_added AFTER INSERT ON implication
+BEGIN
+    INSERT INTO audit (changed_at, action, tag, value, implied_tag, implied_value)
+    SELECT strftime('%Y-%m-%d %H:%M:%f', 'now'), 'imply', t.name, coalesce(v.name, ''), it.name, coalesce(iv.name, '')
+    FROM tag t, tag it
+    LEFT OUTER JOIN value v ON v.id = NEW.value_id
+    LEFT OUTER JOIN value iv ON iv.id = NEW.implied_value_id
+    WHERE t.id = NEW.tag_id AND it.id = NEW.implied_tag_id;
+END`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	sql = `
+CREATE TRIGGER IF NOT EXISTS audit_implication_removed AFTER DELETE ON implication
+BEGIN
+    INSERT INTO audit (changed_at, action, tag, value, implied_tag, implied_value)
+    SELECT strftime('%Y-%m-%d %H:%M:%f', 'now'), 'unimply', t.name, coalesce(v.name, ''), it.name, coalesce(iv.name, '')
+    FROM tag t, tag it
+    LEFT OUTER JOIN value v ON v.id = OLD.value_id
+    LEFT OUTER JOIN value iv ON iv.id = OLD.implied_value_id
+    WHERE t.id = OLD.tag_id AND it.id = OLD.implied_tag_id;
+END`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// The identifier of the most recent audit entry, after which those made by the
+// transaction will follow.
+func lastAuditId(tx *sql.Tx) (int64, error) {
+	var id int64
+	if err := tx.QueryRow("SELECT coalesce(max(id), 0) FROM audit").Scan(&id); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// Attributes the audit entries made after the specified entry to the user and
+// command.
+func attributeAuditEntries(tx *sql.Tx, afterId int64, user, command string) error {
+	sql := `
+UPDATE audit
+SET user = ?, command = ?
+WHERE id > ?`
+
+	_, err := tx.Exec(sql, user, command, afterId)
+	return err
+}
+
+func readAuditEntries(rows *sql.Rows, entries entities.AuditEntries) (entities.AuditEntries, error) {
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var entry entities.AuditEntry
+		if err := rows.Scan(&entry.Id, &entry.ChangedAt, &entry.User, &entry.Command, &entry.Action, &entry.Directory, &entry.Name, &entry.Tag, &entry.Value, &entry.ImpliedTag, &entry.ImpliedValue); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"database/sql"
+	"io"
+	"os"
+	"sort"
+)
+
+// A Backend is an engine in which the database is held. The storage layer is
+// written in Sqlite's dialect of SQL so each backend supplies a database/sql
+// driver that accepts it, along with TMSU's REGEXP function.
+type Backend interface {
+	// The name by which the backend is selected, e.g. 'sqlite'.
+	Name() string
+
+	// Opens a pool of connections to the specified data source, which is a
+	// 'file:' URI with backend-specific query parameters.
+	Open(dataSourceName string) (*sql.DB, error)
+
+	// Identifies whether a database file, given its leading bytes, was
+	// created by this backend.
+	Recognises(header []byte) bool
+}
+
+// A Backend whose connections cannot report the changes made through one
+// another, as Sqlite's data version does, so which identifies them itself.
+type VersionedBackend interface {
+	Backend
+
+	// Retrieves a number that changes whenever the database at the specified
+	// path is changed.
+	DataVersion(path string) (int64, error)
+}
+
+// The backend used for new databases when none is specified.
+const DefaultBackend = "sqlite"
+
+// Makes a backend available for new and existing databases. Registering a
+// second backend with the same name replaces the first.
+func RegisterBackend(backend Backend) {
+	backends[backend.Name()] = backend
+}
+
+// Retrieves the names of the registered backends.
+func Backends() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Identifies the backend that created the database at the specified path.
+func BackendAt(path string) (string, error) {
+	backend, err := recogniseBackend(path)
+	if err != nil {
+		return "", err
+	}
+
+	return backend.Name(), nil
+}
+
+// unexported
+
+var backends = make(map[string]Backend)
+
+// the number of leading bytes of a database file used to recognise its backend
+const backendHeaderSize = 100
+
+func lookupBackend(name string) (Backend, error) {
+	if name == "" {
+		name = DefaultBackend
+	}
+
+	backend, found := backends[name]
+	if !found {
+		return nil, UnknownBackendError{name, Backends()}
+	}
+
+	return backend, nil
+}
+
+func recogniseBackend(path string) (Backend, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, DatabaseAccessError{path, err}
+	}
+	defer file.Close()
+
+	header := make([]byte, backendHeaderSize)
+	count, err := io.ReadFull(file, header)
+	switch err {
+	case nil, io.ErrUnexpectedEOF:
+	case io.EOF:
+		// an empty file becomes a database of the default backend when opened
+		return lookupBackend(DefaultBackend)
+	default:
+		return nil, DatabaseAccessError{path, err}
+	}
+	header = header[:count]
+
+	for _, name := range Backends() {
+		if backends[name].Recognises(header) {
+			return backends[name], nil
+		}
+	}
+
+	return nil, UnrecognisedBackendError{path}
+}
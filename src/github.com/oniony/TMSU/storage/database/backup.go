@@ -0,0 +1,192 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"os"
+	"time"
+)
+
+// A Backend whose databases can be copied whilst they are in use, as by
+// Sqlite's online backup API.
+type BackupBackend interface {
+	Backend
+
+	// Copies the database open on the source connection over the one open on
+	// the destination connection, consistently even should another process
+	// modify the source meanwhile.
+	Copy(dest, source *sql.Conn) error
+}
+
+// Copies the database to a new file at destPath whilst it remains in use.
+func (database *Database) Backup(destPath string) error {
+	backend, ok := database.backend.(BackupBackend)
+	if !ok {
+		return fmt.Errorf("the %v backend does not support backups", database.backend.Name())
+	}
+
+	if _, err := os.Stat(destPath); err == nil {
+		return fmt.Errorf("'%v' already exists", destPath)
+	}
+
+	// the backup is written beside the destination and renamed once
+	// complete, so that an interrupted backup does not leave a partial file
+	partialPath := destPath + ".partial"
+	os.Remove(partialPath)
+
+	log.Debugf("backing up database at '%v' to '%v'", database.path, destPath)
+
+	destDb, err := open(backend, partialPath)
+	if err != nil {
+		return err
+	}
+
+	err = copyDatabase(backend, destDb, database.readDb, database.lockWait)
+	destDb.Close()
+	if err != nil {
+		os.Remove(partialPath)
+		return fmt.Errorf("could not back up database: %v", err)
+	}
+
+	if err := os.Rename(partialPath, destPath); err != nil {
+		os.Remove(partialPath)
+		return fmt.Errorf("could not back up database: %v", err)
+	}
+
+	return nil
+}
+
+// Replaces the content of the database with that of the backup at
+// sourcePath. Rather than the database file being replaced, the backup is
+// copied into it so that other processes using the database, such as the
+// virtual filesystem, see the restored database rather than the old file.
+func (database *Database) Restore(sourcePath string) error {
+	if database.readOnly {
+		return fmt.Errorf("database at '%v' is read-only: cannot be restored", database.path)
+	}
+
+	backend, ok := database.backend.(BackupBackend)
+	if !ok {
+		return fmt.Errorf("the %v backend does not support backups", database.backend.Name())
+	}
+
+	if err := database.CheckBackup(sourcePath); err != nil {
+		return err
+	}
+
+	sourceDb, err := open(backend, sourcePath, "mode=ro")
+	if err != nil {
+		return err
+	}
+	defer sourceDb.Close()
+
+	if database.dryRun {
+		return nil
+	}
+
+	lockFile, err := acquireWriteLock(context.Background(), database.path, database.command, database.lockWait)
+	if err != nil {
+		return err
+	}
+	if lockFile != nil {
+		defer releaseWriteLock(lockFile)
+	}
+
+	log.Debugf("restoring database at '%v' from '%v'", database.path, sourcePath)
+
+	if err := copyDatabase(backend, database.db, sourceDb, database.lockWait); err != nil {
+		if isLocked(err) {
+			return lockedError(database.path)
+		}
+		return fmt.Errorf("could not restore database: %v", err)
+	}
+
+	// a backup taken by an earlier version is brought up to date now, rather
+	// than when the database is next opened, for the processes using it
+	tx, err := database.db.Begin()
+	if err != nil {
+		return DatabaseTransactionError{database.path, err}
+	}
+	if err := upgrade(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Checks that the backup at the path is a TMSU database that this version can
+// restore over the database.
+func (database *Database) CheckBackup(path string) error {
+	backend, err := recogniseBackend(path)
+	if err != nil {
+		return err
+	}
+	if backend.Name() != database.backend.Name() {
+		return fmt.Errorf("%v: a %v database cannot be restored over a %v database", path, backend.Name(), database.backend.Name())
+	}
+
+	db, err := open(backend, path, "mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return DatabaseTransactionError{path, err}
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT 1 FROM tag LIMIT 1")
+	if err != nil {
+		return fmt.Errorf("%v: not a TMSU database", path)
+	}
+	rows.Close()
+
+	version := currentSchemaVersion(tx)
+	if version.GreaterThan(latestSchemaVersion) {
+		return SchemaTooNewError{version.String(), latestSchemaVersion.String()}
+	}
+
+	return nil
+}
+
+// unexported
+
+func copyDatabase(backend BackupBackend, destDb, sourceDb *sql.DB, lockWait time.Duration) error {
+	ctx := context.Background()
+
+	destConn, err := destDb.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+
+	sourceConn, err := sourceDb.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer sourceConn.Close()
+
+	return retryWhileLocked(ctx, lockWait, func() error {
+		return backend.Copy(destConn, sourceConn)
+	})
+}
@@ -0,0 +1,92 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"github.com/oniony/TMSU/entities"
+)
+
+// Retrieves the bundles, ordered by name.
+func Bundles(tx *Tx) (entities.Bundles, error) {
+	sql := `
+SELECT name, tag
+FROM bundle
+ORDER BY name, position`
+
+	rows, err := tx.Query(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bundles := make(entities.Bundles, 0, 10)
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var name, tagArg string
+		if err := rows.Scan(&name, &tagArg); err != nil {
+			return nil, err
+		}
+
+		if len(bundles) == 0 || bundles[len(bundles)-1].Name != name {
+			bundles = append(bundles, &entities.Bundle{name, make([]string, 0, 5)})
+		}
+
+		bundle := bundles[len(bundles)-1]
+		bundle.TagArgs = append(bundle.TagArgs, tagArg)
+	}
+
+	return bundles, nil
+}
+
+// Adds a bundle of the tags, in TAG[=VALUE] form.
+func InsertBundle(tx *Tx, name string, tagArgs []string) error {
+	sql := `
+INSERT INTO bundle (name, position, tag)
+VALUES (?, ?, ?)`
+
+	for position, tagArg := range tagArgs {
+		if _, err := tx.Exec(sql, name, position, tagArg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Removes a bundle.
+func DeleteBundle(tx *Tx, name string) error {
+	sql := `
+DELETE FROM bundle
+WHERE name = ?`
+
+	result, err := tx.Exec(sql, name)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return NoSuchBundleError{name}
+	}
+
+	return nil
+}
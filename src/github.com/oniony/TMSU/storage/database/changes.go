@@ -0,0 +1,229 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"database/sql"
+	"github.com/oniony/TMSU/common/log"
+	_path "path"
+	"sort"
+	"strings"
+)
+
+// The number of rows changed within a transaction, by table.
+type changes map[string]*rowChanges
+
+type rowChanges struct {
+	added   int64
+	updated int64
+	removed int64
+}
+
+// Records the rows affected by the data modification statement.
+func (changes changes) record(statement string, rowsAffected int64) {
+	verb, table := parseStatement(statement)
+	if table == "" {
+		return
+	}
+
+	tableChanges, ok := changes[table]
+	if !ok {
+		tableChanges = &rowChanges{}
+		changes[table] = tableChanges
+	}
+
+	switch verb {
+	case "INSERT", "REPLACE":
+		tableChanges.added += rowsAffected
+	case "UPDATE":
+		tableChanges.updated += rowsAffected
+	case "DELETE":
+		tableChanges.removed += rowsAffected
+	}
+}
+
+// Reports the changes that a dry run would have made: the files affected
+// followed by the number of rows changed in each table.
+func (changes changes) report(paths []string) {
+	tables := make([]string, 0, len(changes))
+	for table, tableChanges := range changes {
+		if tableChanges.added+tableChanges.updated+tableChanges.removed > 0 {
+			tables = append(tables, table)
+		}
+	}
+
+	if len(tables) == 0 {
+		log.Warn("dry run: no changes would be made")
+		return
+	}
+
+	sort.Strings(tables)
+
+	if len(paths) > 0 {
+		log.Warn("dry run: the following files would be affected")
+		for _, path := range paths {
+			log.Warnf("  %v", path)
+		}
+	}
+
+	log.Warn("dry run: the following changes would be made")
+	for _, table := range tables {
+		tableChanges := changes[table]
+		log.Warnf("  %v: %v added, %v updated, %v removed", table, tableChanges.added, tableChanges.updated, tableChanges.removed)
+	}
+}
+
+// unexported
+
+// the temporary table and triggers noting the files a dry run affects, which
+// are created within, so rolled back with, the dry run's transaction
+var changedFileStatements = []string{`
+CREATE TEMP TABLE dry_run_file (
+    directory TEXT NOT NULL,
+    name TEXT NOT NULL,
+    PRIMARY KEY (directory, name)
+)`, `
+CREATE TEMP TRIGGER dry_run_file_added AFTER INSERT ON main.file
+BEGIN
+    INSERT OR IGNORE INTO dry_run_file VALUES (NEW.directory, NEW.name);
+END`, `
+CREATE TEMP TRIGGER dry_run_file_updated AFTER UPDATE ON main.file
+BEGIN
+    INSERT OR IGNORE INTO dry_run_file VALUES (OLD.directory, OLD.name);
+    INSERT OR IGNORE INTO dry_run_file VALUES (NEW.directory, NEW.name);
+END`, `
+CREATE TEMP TRIGGER dry_run_file_removed AFTER DELETE ON main.file
+BEGIN
+    INSERT OR IGNORE INTO dry_run_file VALUES (OLD.directory, OLD.name);
+END`, `
+CREATE TEMP TRIGGER dry_run_file_tag_added AFTER INSERT ON main.file_tag
+BEGIN
+    INSERT OR IGNORE INTO dry_run_file
+    SELECT directory, name FROM main.file WHERE id = NEW.file_id;
+END`, `
+CREATE TEMP TRIGGER dry_run_file_tag_updated AFTER UPDATE ON main.file_tag
+BEGIN
+    INSERT OR IGNORE INTO dry_run_file
+    SELECT directory, name FROM main.file WHERE id IN (OLD.file_id, NEW.file_id);
+END`, `
+CREATE TEMP TRIGGER dry_run_file_tag_removed AFTER DELETE ON main.file_tag
+BEGIN
+    INSERT OR IGNORE INTO dry_run_file
+    SELECT directory, name FROM main.file WHERE id = OLD.file_id;
+END`, `
+CREATE TEMP TRIGGER dry_run_tag_renamed AFTER UPDATE OF name ON main.tag
+WHEN OLD.name != NEW.name
+BEGIN
+    INSERT OR IGNORE INTO dry_run_file
+    SELECT f.directory, f.name
+    FROM main.file_tag ft
+    INNER JOIN main.file f ON f.id = ft.file_id
+    WHERE ft.tag_id = NEW.id;
+END`, `
+CREATE TEMP TRIGGER dry_run_value_renamed AFTER UPDATE OF name ON main.value
+WHEN OLD.name != NEW.name
+BEGIN
+    INSERT OR IGNORE INTO dry_run_file
+    SELECT f.directory, f.name
+    FROM main.file_tag ft
+    INNER JOIN main.file f ON f.id = ft.file_id
+    WHERE ft.value_id = NEW.id;
+END`}
+
+// Notes the files that the statements subsequently run within the transaction
+// add, remove, move or change the tags of.
+func recordChangedFiles(tx *sql.Tx) error {
+	for _, statement := range changedFileStatements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Retrieves the stored paths of the files noted by recordChangedFiles.
+func changedFiles(tx *sql.Tx) ([]string, error) {
+	rows, err := tx.Query(`
+SELECT directory, name
+FROM temp.dry_run_file
+ORDER BY directory, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	paths := make([]string, 0, 10)
+	for rows.Next() {
+		var directory, name string
+		if err := rows.Scan(&directory, &name); err != nil {
+			return nil, err
+		}
+
+		paths = append(paths, _path.Join(directory, name))
+	}
+
+	return paths, rows.Err()
+}
+
+// Identifies the verb and table of a data modification statement, e.g.
+// 'INSERT' and 'file_tag' for 'INSERT OR IGNORE INTO file_tag ...'.
+func parseStatement(statement string) (string, string) {
+	words := strings.Fields(statement)
+	if len(words) == 0 {
+		return "", ""
+	}
+
+	verb := strings.ToUpper(words[0])
+
+	var keyword string
+	switch verb {
+	case "INSERT", "REPLACE":
+		keyword = "INTO"
+	case "DELETE":
+		keyword = "FROM"
+	case "UPDATE":
+		// skip any conflict clause, e.g. 'UPDATE OR IGNORE table'
+		for index := 1; index < len(words); index++ {
+			switch strings.ToUpper(words[index]) {
+			case "OR", "ROLLBACK", "ABORT", "REPLACE", "FAIL", "IGNORE":
+				continue
+			}
+
+			return verb, tableName(words[index])
+		}
+
+		return "", ""
+	default:
+		return "", ""
+	}
+
+	for index := 1; index < len(words)-1; index++ {
+		if strings.ToUpper(words[index]) == keyword {
+			return verb, tableName(words[index+1])
+		}
+	}
+
+	return "", ""
+}
+
+func tableName(word string) string {
+	if index := strings.IndexAny(word, "("); index != -1 {
+		word = word[:index]
+	}
+
+	return strings.Trim(word, "\"`[]")
+}
@@ -0,0 +1,87 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"github.com/oniony/TMSU/entities"
+	"strings"
+)
+
+// Whether the full-text index of file contents has been created.
+func ContentIndexExists(tx *Tx) (bool, error) {
+	sql := `
+SELECT count(1)
+FROM sqlite_master
+WHERE type = 'table' AND name = 'file_content'`
+
+	rows, err := tx.Query(sql)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	count, err := readCount(rows)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// Creates the full-text index of file contents, which requires Sqlite3 to
+// have been built with the FTS5 extension.
+func CreateContentIndex(tx *Tx) error {
+	sql := `
+CREATE VIRTUAL TABLE IF NOT EXISTS file_content USING fts5 (
+    content
+)`
+
+	if _, err := tx.Exec(sql); err != nil {
+		if strings.Contains(err.Error(), "no such module") {
+			return ContentIndexUnavailableError{}
+		}
+
+		return err
+	}
+
+	sql = `
+CREATE TRIGGER IF NOT EXISTS file_content_deleted
+AFTER DELETE ON file
+BEGIN
+    DELETE FROM file_content WHERE rowid = OLD.id;
+END`
+
+	_, err := tx.Exec(sql)
+	return err
+}
+
+// Records the text content of a file, replacing any previously indexed.
+func UpdateFileContent(tx *Tx, fileId entities.FileId, content string) error {
+	sql := `
+DELETE FROM file_content
+WHERE rowid = ?`
+
+	if _, err := tx.Exec(sql, int(fileId)); err != nil {
+		return err
+	}
+
+	sql = `
+INSERT INTO file_content (rowid, content)
+VALUES (?, ?)`
+
+	_, err := tx.Exec(sql, int(fileId), content)
+	return err
+}
@@ -16,21 +16,49 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
-	_ "github.com/mattn/go-sqlite3" // initialised Sqlite3
+	"fmt"
 	"github.com/oniony/TMSU/common/log"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 type Database struct {
-	db *sql.DB
+	db       *sql.DB
+	readDb   *sql.DB
+	path     string
+	backend  Backend
+	readOnly bool
+	dryRun   bool
+	command  string
+	user     string
+	lockWait time.Duration
+
+	// gives the path by which a dry run reports a file it affects
+	dryRunPath func(string) string
+
+	// a connection reserved for reading the data version: see DataVersion
+	versionConn  *sql.Conn
+	versionMutex sync.Mutex
 }
 
-func CreateAt(path string) error {
-	log.Infof(2, "creating database at '%v'.", path)
+// Creates a new database at the specified path using the named backend, or
+// the default backend if the name is empty.
+func CreateAt(path, backendName string) error {
+	backend, err := lookupBackend(backendName)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("creating %v database at '%v'.", backend.Name(), path)
 
-	db, err := sql.Open("sqlite3", path)
+	db, err := backend.Open(path)
 	if err != nil {
 		return DatabaseAccessError{path, err}
 	}
@@ -42,6 +70,7 @@ func CreateAt(path string) error {
 	}
 
 	if err := upgrade(tx); err != nil {
+		tx.Rollback()
 		return err
 	}
 
@@ -52,8 +81,12 @@ func CreateAt(path string) error {
 	return nil
 }
 
-func OpenAt(path string) (*Database, error) {
-	log.Infof(2, "opening database at '%v'.", path)
+// Opens the database at the specified path. Statements blocked by another
+// process's lock are retried for up to lockWait before failing. If readOnly is
+// set, or the database file cannot be written, the database is opened
+// read-only and any attempt to modify it fails.
+func OpenAt(path string, lockWait time.Duration, readOnly bool) (*Database, error) {
+	log.Debugf("opening database at '%v'.", path)
 
 	_, err := os.Stat(path)
 	if err != nil {
@@ -65,72 +98,603 @@ func OpenAt(path string) (*Database, error) {
 		}
 	}
 
-	db, err := sql.Open("sqlite3", path)
+	if !readOnly && isReadOnly(path) {
+		log.Debugf("database at '%v' is read-only: opening in read-only mode", path)
+
+		readOnly = true
+	}
+
+	backend, err := recogniseBackend(path)
 	if err != nil {
-		return nil, DatabaseAccessError{path, err}
+		return nil, err
 	}
 
-	tx, err := db.Begin()
+	log.Debugf("database at '%v' uses the %v backend", path, backend.Name())
+
+	parameters := make([]string, 0, 2)
+	if readOnly {
+		parameters = append(parameters, "mode=ro")
+	}
+	if lockWait > 0 {
+		log.Debugf("waiting up to %v for database locks", lockWait)
+
+		parameters = append(parameters, engine.busyTimeoutParameter(lockWait))
+	}
+
+	readDb, err := open(backend, path, parameters...)
 	if err != nil {
+		return nil, err
+	}
+
+	// read transactions have their own pool of connections so that, with the
+	// write-ahead log, they proceed whilst another process is writing
+	db := readDb
+	if !readOnly {
+		// take the write lock when the transaction begins, where a busy lock
+		// is waited upon, rather than upon the first write, where Sqlite
+		// fails immediately to avoid deadlocking with the other writer
+		db, err = open(backend, path, append(parameters, "_txlock=immediate")...)
+		if err != nil {
+			readDb.Close()
+			return nil, err
+		}
+	}
+
+	var tx *sql.Tx
+	err = retryWhileLocked(context.Background(), lockWait, func() (err error) {
+		tx, err = db.Begin()
+		return err
+	})
+	if err != nil {
+		if isLocked(err) {
+			return nil, lockedError(path)
+		}
 		return nil, DatabaseTransactionError{path, err}
 	}
 
-	if err := upgrade(tx); err != nil {
+	if readOnly {
+		// a read-only database cannot be upgraded so must already be current
+		version := currentSchemaVersion(tx)
+		if version.GreaterThan(latestSchemaVersion) {
+			tx.Rollback()
+			return nil, SchemaTooNewError{version.String(), latestSchemaVersion.String()}
+		}
+		if version != latestSchemaVersion {
+			tx.Rollback()
+			return nil, DatabaseReadOnlyError{path, "schema version " + version.String() + " must be upgraded"}
+		}
+	} else if err := upgrade(tx); err != nil {
+		tx.Rollback()
+		if isLocked(err) {
+			return nil, lockedError(path)
+		}
 		return nil, err
 	}
 
 	if err := tx.Commit(); err != nil {
+		if isLocked(err) {
+			return nil, lockedError(path)
+		}
 		return nil, DatabaseTransactionError{path, err}
 	}
 
-	return &Database{db}, nil
+	return &Database{db, readDb, path, backend, readOnly, false, "", "", lockWait, nil, nil, sync.Mutex{}}, nil
 }
 
 func (database *Database) Close() error {
+	if database.versionConn != nil {
+		database.versionConn.Close()
+	}
+
+	if database.readDb != database.db {
+		database.readDb.Close()
+	}
+
 	return database.db.Close()
 }
 
-func (database *Database) Begin() (*Tx, error) {
-	tx, err := database.db.Begin()
+// The name of the backend in which the database is held.
+func (database *Database) Backend() string {
+	return database.backend.Name()
+}
+
+// Whether the database was opened read-only, because the database file or the
+// medium it resides upon cannot be written.
+func (database *Database) ReadOnly() bool {
+	return database.readOnly
+}
+
+// Enables dry-run mode: the changes made by subsequent transactions are
+// reported upon commit but rolled back. The files affected are reported by the
+// paths absPath gives for their stored paths.
+func (database *Database) SetDryRun(dryRun bool, absPath func(string) string) {
+	database.dryRun = dryRun
+	database.dryRunPath = absPath
+}
+
+// Sets the command line recorded as the holder of the database lock whilst
+// this process's transactions are writing to it, and to which the changes
+// they make are attributed in the audit trail.
+func (database *Database) SetCommand(command string) {
+	database.command = command
+}
+
+// Sets the user to whom changes are attributed in the audit trail.
+func (database *Database) SetUser(user string) {
+	database.user = user
+}
+
+// Retrieves a number that changes whenever a transaction that modified the
+// database is committed, whether by this or another process, allowing the
+// results of earlier queries to be cached until the database is next changed.
+//
+// Sqlite only reports the changes made through other connections so the
+// number is read from a connection that is never used to make changes.
+func (database *Database) DataVersion() (int64, error) {
+	if backend, ok := database.backend.(VersionedBackend); ok {
+		return backend.DataVersion(database.path)
+	}
+
+	database.versionMutex.Lock()
+	defer database.versionMutex.Unlock()
+
+	if database.versionConn == nil {
+		conn, err := database.readDb.Conn(context.Background())
+		if err != nil {
+			return 0, err
+		}
+
+		database.versionConn = conn
+	}
+
+	var version int64
+	if err := database.versionConn.QueryRowContext(context.Background(), "PRAGMA data_version").Scan(&version); err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// Sets the database's journal mode, e.g. 'wal' or 'delete'. The mode is
+// recorded in the database file so applies to every process using it.
+func (database *Database) SetJournalMode(mode string) error {
+	if database.readOnly {
+		return nil
+	}
+
+	ctx := context.Background()
+
+	conn, err := database.db.Conn(ctx)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	defer conn.Close()
+
+	var current string
+	if err := conn.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&current); err != nil {
+		return err
+	}
+	if strings.EqualFold(current, mode) {
+		return nil
+	}
+	if current == "memory" {
+		// the database is held in memory, as by the json backend, so has no
+		// journal to switch
+		return nil
+	}
+
+	log.Debugf("switching journal mode from '%v' to '%v'", current, mode)
+
+	// the write-ahead log can only be left by the sole connection to the
+	// database, so this process's other connections are closed first
+	database.closeIdleConnections()
+	defer database.db.SetMaxIdleConns(maxIdleConnections)
+	defer database.readDb.SetMaxIdleConns(maxIdleConnections)
+
+	// another process using the database is not waited upon, as the mode can
+	// only be switched once it is no longer in use
+	var busyTimeout int
+	if err := conn.QueryRowContext(ctx, "PRAGMA busy_timeout").Scan(&busyTimeout); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, "PRAGMA busy_timeout = 0"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "PRAGMA busy_timeout = "+strconv.Itoa(busyTimeout))
+
+	// the journal mode cannot be changed within a transaction
+	var result string
+	err = conn.QueryRowContext(ctx, "PRAGMA journal_mode="+mode).Scan(&result)
+	if err != nil && !isLocked(err) {
+		return err
+	}
+	if err != nil || !strings.EqualFold(result, mode) {
+		return fmt.Errorf("could not switch journal mode to '%v': database is in use", mode)
+	}
+
+	return nil
+}
+
+// Rebuilds the database file, reclaiming unused space, and refreshes the
+// statistics used to plan queries.
+func (database *Database) Compact() error {
+	if database.readOnly {
+		return fmt.Errorf("database at '%v' is read-only: cannot be compacted", database.path)
+	}
+	if database.dryRun {
+		return nil
+	}
+
+	lockFile, err := acquireWriteLock(context.Background(), database.path, database.command, database.lockWait)
+	if err != nil {
+		return err
 	}
+	if lockFile != nil {
+		defer releaseWriteLock(lockFile)
+	}
+
+	// VACUUM cannot be run within a transaction
+	for _, statement := range []string{"VACUUM", "ANALYZE"} {
+		log.Debugf("running %v", statement)
+
+		err := retryWhileLocked(context.Background(), database.lockWait, func() error {
+			_, err := database.db.Exec(statement)
+			return err
+		})
+		if err != nil {
+			if isLocked(err) {
+				return lockedError(database.path)
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Begins a transaction that may modify the database.
+func (database *Database) Begin() (*Tx, error) {
+	return database.BeginContext(context.Background())
+}
+
+// Begins a transaction that may modify the database. Should the context be
+// cancelled before the transaction ends then its statements fail and it is
+// rolled back.
+func (database *Database) BeginContext(ctx context.Context) (*Tx, error) {
+	return database.begin(ctx, database.db)
+}
 
-	return &Tx{tx}, nil
+// Begins a transaction that only queries the database, which does not take the
+// write lock so does not wait upon, nor hold up, other processes' changes.
+func (database *Database) BeginRead() (*Tx, error) {
+	return database.BeginReadContext(context.Background())
+}
+
+// Begins a transaction that only queries the database, bound to the context.
+func (database *Database) BeginReadContext(ctx context.Context) (*Tx, error) {
+	return database.begin(ctx, database.readDb)
 }
 
 type Tx struct {
-	tx *sql.Tx
+	tx        *sql.Tx
+	ctx       context.Context
+	database  *Database
+	changes   changes
+	lockFile  *os.File
+	written   bool
+	auditFrom int64
+
+	// the statements prepared by ExecPrepared and QueryPrepared, which are
+	// closed with the transaction
+	statements map[string]*sql.Stmt
+}
+
+// The context the transaction is bound to.
+func (tx *Tx) Context() context.Context {
+	return tx.ctx
 }
 
 func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
-	log.Info(3, query)
-	log.Infof(3, "params: %v", args)
+	return tx.exec(query, args, func() (sql.Result, error) {
+		return tx.tx.ExecContext(tx.ctx, query, args...)
+	})
+}
+
+// Executes a statement that is run repeatedly, such as for each file being
+// tagged. The statement is prepared upon first use and reused for the rest of
+// the transaction so the query text must not vary between calls.
+func (tx *Tx) ExecPrepared(query string, args ...interface{}) (sql.Result, error) {
+	return tx.exec(query, args, func() (sql.Result, error) {
+		statement, err := tx.prepare(query)
+		if err != nil {
+			return nil, err
+		}
 
-	return tx.tx.Exec(query, args...)
+		return statement.ExecContext(tx.ctx, args...)
+	})
 }
 
 func (tx *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
-	log.Info(3, query)
-	log.Infof(3, "params: %v", args)
+	log.Trace(query)
+	log.Tracef("params: %v", args)
+
+	rows, err := tx.tx.QueryContext(tx.ctx, query, args...)
+	if err != nil && isLocked(err) {
+		return nil, lockedError(tx.database.path)
+	}
+
+	return rows, err
+}
+
+// Runs a query that is run repeatedly, preparing it upon first use as per
+// ExecPrepared.
+func (tx *Tx) QueryPrepared(query string, args ...interface{}) (*sql.Rows, error) {
+	log.Trace(query)
+	log.Tracef("params: %v", args)
+
+	statement, err := tx.prepare(query)
+	if err != nil {
+		if isLocked(err) {
+			return nil, lockedError(tx.database.path)
+		}
+		return nil, err
+	}
+
+	rows, err := statement.QueryContext(tx.ctx, args...)
+	if err != nil && isLocked(err) {
+		return nil, lockedError(tx.database.path)
+	}
 
-	return tx.tx.Query(query, args...)
+	return rows, err
 }
 
 func (tx *Tx) Commit() error {
-	log.Info(2, "committing transaction")
+	defer tx.releaseLock()
+
+	if tx.database.dryRun {
+		log.Debug("dry run: rolling back transaction")
 
-	return tx.tx.Commit()
+		paths, err := changedFiles(tx.tx)
+		if err != nil {
+			log.Debugf("could not identify the files affected: %v", err)
+		}
+		for index, path := range paths {
+			paths[index] = tx.database.dryRunPath(path)
+		}
+
+		if err := tx.tx.Rollback(); err != nil {
+			return err
+		}
+
+		tx.changes.report(paths)
+
+		return nil
+	}
+
+	if tx.written && tx.auditFrom >= 0 {
+		if err := attributeAuditEntries(tx.tx, tx.auditFrom, tx.database.user, tx.database.command); err != nil {
+			tx.tx.Rollback()
+			return fmt.Errorf("could not attribute changes in the audit trail: %v", err)
+		}
+	}
+
+	if tx.written {
+		// the statistics are a convenience so failing to record them should
+		// not prevent the changes being committed
+		if err := recordStatistics(tx.tx); err != nil {
+			log.Debugf("could not record database statistics: %v", err)
+		}
+	}
+
+	log.Debug("committing transaction")
+
+	if err := tx.tx.Commit(); err != nil {
+		if isLocked(err) {
+			return lockedError(tx.database.path)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Commits the changes made so far and continues in a new transaction, so that
+// a long-running change neither holds a single transaction open nor loses all
+// of its work should it be interrupted. The write lock is released in between,
+// letting other processes make their changes. In a dry run nothing is
+// committed so the changes are kept in the one transaction.
+func (tx *Tx) Checkpoint() error {
+	if tx.database.dryRun {
+		return nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	next, err := tx.database.begin(tx.ctx, tx.database.db)
+	if err != nil {
+		return err
+	}
+
+	*tx = *next
+
+	return nil
 }
 
 func (tx *Tx) Rollback() error {
-	log.Info(2, "rolling back transaction")
+	defer tx.releaseLock()
+
+	log.Debug("rolling back transaction")
 
 	return tx.tx.Rollback()
 }
 
 // unexported
 
+// Closes the connections of both pools that are not in use, and the one
+// reserved for reading the data version, until the pools' limits are restored.
+func (database *Database) closeIdleConnections() {
+	database.versionMutex.Lock()
+	if database.versionConn != nil {
+		database.versionConn.Close()
+		database.versionConn = nil
+	}
+	database.versionMutex.Unlock()
+
+	database.readDb.SetMaxIdleConns(0)
+	database.db.SetMaxIdleConns(0)
+}
+
+func open(backend Backend, path string, parameters ...string) (*sql.DB, error) {
+	dataSourceName := "file:" + escapeUriPath(path) + "?" + strings.Join(parameters, "&")
+
+	db, err := backend.Open(dataSourceName)
+	if err != nil {
+		return nil, DatabaseAccessError{path, err}
+	}
+
+	// keep connections open between transactions rather than reopening the
+	// database file each time
+	db.SetMaxIdleConns(maxIdleConnections)
+	db.SetConnMaxLifetime(0)
+
+	return db, nil
+}
+
+// the number of idle connections kept in each pool
+const maxIdleConnections = 4
+
+func (database *Database) begin(ctx context.Context, db *sql.DB) (*Tx, error) {
+	var lockFile *os.File
+	if db == database.db && !database.readOnly {
+		var err error
+		lockFile, err = acquireWriteLock(ctx, database.path, database.command, database.lockWait)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var tx *sql.Tx
+	err := retryWhileLocked(ctx, database.lockWait, func() (err error) {
+		tx, err = db.BeginTx(ctx, nil)
+		return err
+	})
+	if err != nil {
+		if lockFile != nil {
+			releaseWriteLock(lockFile)
+		}
+
+		if isLocked(err) {
+			return nil, lockedError(database.path)
+		}
+		return nil, err
+	}
+
+	if database.dryRun && db == database.db && !database.readOnly {
+		if err := recordChangedFiles(tx); err != nil {
+			tx.Rollback()
+			if lockFile != nil {
+				releaseWriteLock(lockFile)
+			}
+
+			return nil, err
+		}
+	}
+
+	return &Tx{tx, ctx, database, make(changes), lockFile, false, -1, nil}, nil
+}
+
+// applies the statement run by execute, which must not be attempted upon a
+// read-only database, noting the changes it makes
+func (tx *Tx) exec(query string, args []interface{}, execute func() (sql.Result, error)) (sql.Result, error) {
+	if tx.database.readOnly {
+		return nil, DatabaseReadOnlyError{tx.database.path, "cannot be modified"}
+	}
+
+	log.Trace(query)
+	log.Tracef("params: %v", args)
+
+	if !tx.written {
+		// the audit entries this transaction makes will follow the last
+		if auditFrom, err := lastAuditId(tx.tx); err == nil {
+			tx.auditFrom = auditFrom
+		} else {
+			log.Debugf("could not identify last audit entry: %v", err)
+		}
+	}
+
+	result, err := execute()
+	if err != nil {
+		if isLocked(err) {
+			return nil, lockedError(tx.database.path)
+		}
+		return nil, err
+	}
+
+	tx.written = true
+
+	if tx.database.dryRun {
+		if rowsAffected, err := result.RowsAffected(); err == nil {
+			tx.changes.record(query, rowsAffected)
+		}
+	}
+
+	return result, nil
+}
+
+// prepares the statement within the transaction or reuses that prepared already
+func (tx *Tx) prepare(query string) (*sql.Stmt, error) {
+	if statement, ok := tx.statements[query]; ok {
+		return statement, nil
+	}
+
+	statement, err := tx.tx.PrepareContext(tx.ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	if tx.statements == nil {
+		tx.statements = make(map[string]*sql.Stmt)
+	}
+	tx.statements[query] = statement
+
+	return statement, nil
+}
+
+func (tx *Tx) releaseLock() {
+	if tx.lockFile == nil {
+		return
+	}
+
+	releaseWriteLock(tx.lockFile)
+	tx.lockFile = nil
+}
+
+// Determines whether the database file cannot be opened for writing, whether
+// due to its permissions or to residing on a read-only file-system.
+func isReadOnly(path string) bool {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		if os.IsPermission(err) {
+			return true
+		}
+		if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.EROFS {
+			return true
+		}
+
+		return false
+	}
+	file.Close()
+
+	return false
+}
+
+// Escapes the characters of a path that are significant within an SQLite URI filename.
+func escapeUriPath(path string) string {
+	return strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23").Replace(path)
+}
+
 func readCount(rows *sql.Rows) (uint, error) {
 	if !rows.Next() {
 		return 0, errors.New("could not get count")
@@ -148,6 +712,10 @@ func readCount(rows *sql.Rows) (uint, error) {
 	return count, nil
 }
 
+// The number of parameters bound to a single statement by the lookups that
+// take many keys, which keeps each within Sqlite's limit on bound parameters.
+const parameterBatchSize = 500
+
 func collationFor(ignoreCase bool) string {
 	if ignoreCase {
 		return " COLLATE NOCASE"
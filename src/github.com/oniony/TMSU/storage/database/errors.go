@@ -18,6 +18,7 @@ package database
 import (
 	"fmt"
 	"github.com/oniony/TMSU/entities"
+	"strings"
 )
 
 type DatabaseNotFoundError struct {
@@ -46,6 +47,37 @@ func (err DatabaseTransactionError) Error() string {
 	return fmt.Sprintf("database transaction error: %v", err.Reason)
 }
 
+type DatabaseReadOnlyError struct {
+	DatabasePath string
+	Reason       string
+}
+
+func (err DatabaseReadOnlyError) Error() string {
+	return fmt.Sprintf("database at '%v' is read-only: %v", err.DatabasePath, err.Reason)
+}
+
+type SchemaTooNewError struct {
+	Version       string
+	LatestVersion string
+}
+
+func (err SchemaTooNewError) Error() string {
+	return fmt.Sprintf("database schema version %v is newer than the latest version supported, %v: a newer version of TMSU is required", err.Version, err.LatestVersion)
+}
+
+type DatabaseLockedError struct {
+	DatabasePath string
+	Holder       *LockHolder
+}
+
+func (err DatabaseLockedError) Error() string {
+	if err.Holder == nil {
+		return fmt.Sprintf("database at '%v' is locked by another process", err.DatabasePath)
+	}
+
+	return fmt.Sprintf("database at '%v' is locked by %v", err.DatabasePath, err.Holder)
+}
+
 type DatabaseQueryError struct {
 	DatabasePath string
 	Query        string
@@ -72,6 +104,14 @@ func (err NoSuchValueError) Error() string {
 	return fmt.Sprintf("no such value #%v", err.ValueId)
 }
 
+type NoSuchVolumeError struct {
+	VolumeId entities.VolumeId
+}
+
+func (err NoSuchVolumeError) Error() string {
+	return fmt.Sprintf("no such volume #%v", err.VolumeId)
+}
+
 type NoSuchQueryError struct {
 	Query string
 }
@@ -80,6 +120,14 @@ func (err NoSuchQueryError) Error() string {
 	return fmt.Sprintf("no such query '%v'", err.Query)
 }
 
+type NoSuchBundleError struct {
+	Name string
+}
+
+func (err NoSuchBundleError) Error() string {
+	return fmt.Sprintf("no such bundle '%v'", err.Name)
+}
+
 type NoSuchFileTagError struct {
 	FileId  entities.FileId
 	TagId   entities.TagId
@@ -90,6 +138,16 @@ func (err NoSuchFileTagError) Error() string {
 	return fmt.Sprintf("no such file-tag for file #%v, tag #%v and value #%v.", err.FileId, err.TagId, err.ValueId)
 }
 
+type NoSuchFileRelationError struct {
+	FileId        entities.FileId
+	RelatedFileId entities.FileId
+	Relation      string
+}
+
+func (err NoSuchFileRelationError) Error() string {
+	return fmt.Sprintf("no such relation '%v' from file #%v to file #%v.", err.Relation, err.FileId, err.RelatedFileId)
+}
+
 type NoSuchImplicationError struct {
 	TagValuePair        entities.TagIdValueIdPair
 	ImpliedTagValuePair entities.TagIdValueIdPair
@@ -106,3 +164,27 @@ type NoSuchSettingError struct {
 func (err NoSuchSettingError) Error() string {
 	return fmt.Sprintf("no such setting '%v'", err.Name)
 }
+
+type ContentIndexUnavailableError struct {
+}
+
+func (err ContentIndexUnavailableError) Error() string {
+	return "full-text indexing is unavailable: TMSU was built without Sqlite3's FTS5 extension (build with '-tags sqlite_fts5')"
+}
+
+type UnknownBackendError struct {
+	Name      string
+	Available []string
+}
+
+func (err UnknownBackendError) Error() string {
+	return fmt.Sprintf("unknown storage backend '%v': available backends are %v", err.Name, strings.Join(err.Available, ", "))
+}
+
+type UnrecognisedBackendError struct {
+	DatabasePath string
+}
+
+func (err UnrecognisedBackendError) Error() string {
+	return fmt.Sprintf("database at '%v' was not created by any available storage backend", err.DatabasePath)
+}
@@ -18,10 +18,12 @@ package database
 import (
 	"database/sql"
 	"github.com/oniony/TMSU/common/fingerprint"
+	"github.com/oniony/TMSU/common/units"
 	"github.com/oniony/TMSU/entities"
 	"github.com/oniony/TMSU/query"
-	"path/filepath"
+	_path "path"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -48,7 +50,7 @@ FROM file `)
 
 	buildSort(sort, builder)
 
-	rows, err := tx.Query(builder.Sql())
+	rows, err := tx.Query(builder.Sql(), builder.Params()...)
 	if err != nil {
 		return nil, err
 	}
@@ -64,7 +66,7 @@ SELECT id, directory, name, fingerprint, mod_time, size, is_dir
 FROM file
 WHERE id = ?`
 
-	rows, err := tx.Query(sql, id)
+	rows, err := tx.QueryPrepared(sql, id)
 	if err != nil {
 		return nil, err
 	}
@@ -75,15 +77,15 @@ WHERE id = ?`
 
 // Retrieves the file with the specified path.
 func FileByPath(tx *Tx, path string) (*entities.File, error) {
-	directory := filepath.Dir(path)
-	name := filepath.Base(path)
+	directory := _path.Dir(path)
+	name := _path.Base(path)
 
 	sql := `
 SELECT id, directory, name, fingerprint, mod_time, size, is_dir
 FROM file
-WHERE directory = ? AND name = ?`
+WHERE directory = ? COLLATE ` + pathCollation + ` AND name = ? COLLATE ` + pathCollation
 
-	rows, err := tx.Query(sql, directory, name)
+	rows, err := tx.QueryPrepared(sql, directory, name)
 	if err != nil {
 		return nil, err
 	}
@@ -92,23 +94,70 @@ WHERE directory = ? AND name = ?`
 	return readFile(rows)
 }
 
-// Retrieves all files that are under the specified directory.
-func FilesByDirectory(tx *Tx, path string, pathContainsRoot bool) (entities.Files, error) {
+// Retrieves the files with the specified paths, in no particular order. Paths
+// that are not in the database are ignored.
+func FilesByPaths(tx *Tx, paths []string) (entities.Files, error) {
+	files := make(entities.Files, 0, len(paths))
+
+	batchSize := parameterBatchSize / 2
+	for start := 0; start < len(paths); start += batchSize {
+		end := start + batchSize
+		if end > len(paths) {
+			end = len(paths)
+		}
+
+		batch := paths[start:end]
+
+		sql := `
+SELECT id, directory, name, fingerprint, mod_time, size, is_dir
+FROM file
+WHERE (directory = ? COLLATE ` + pathCollation + ` AND name = ? COLLATE ` + pathCollation + `)`
+		sql += strings.Repeat(` OR (directory = ? COLLATE `+pathCollation+` AND name = ? COLLATE `+pathCollation+`)`, len(batch)-1)
+
+		params := make([]interface{}, 0, len(batch)*2)
+		for _, path := range batch {
+			params = append(params, _path.Dir(path), _path.Base(path))
+		}
+
+		rows, err := tx.Query(sql, params...)
+		if err != nil {
+			return nil, err
+		}
+
+		files, err = readFiles(rows, files)
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// Retrieves all files that are under the specified directory, including those
+// on the volumes, specified by their stored paths, mounted beneath it.
+func FilesByDirectory(tx *Tx, path string, pathContainsRoot bool, volumePaths []string) (entities.Files, error) {
 	sql := `
 SELECT id, directory, name, fingerprint, mod_time, size, is_dir
 FROM file
-WHERE directory = ? OR directory LIKE ?`
+WHERE directory = ? COLLATE ` + pathCollation + ` OR directory LIKE ?`
 
 	if pathContainsRoot {
-		sql += `OR directory = '.' OR directory LIKE './%`
+		sql += ` OR ` + relativeDirectoryCondition
+	}
+
+	path = _path.Clean(path)
+	params := []interface{}{path, _path.Join(path, "%")}
+
+	for _, volumePath := range volumePaths {
+		sql += ` OR directory = ? OR directory LIKE ?`
+		params = append(params, volumePath, volumePath+"/%")
 	}
 
 	sql += `
 ORDER BY directory || '/' || name`
 
-	path = filepath.Clean(path)
-
-	rows, err := tx.Query(sql, path, filepath.Join(path, "%"))
+	rows, err := tx.Query(sql, params...)
 	if err != nil {
 		return nil, err
 	}
@@ -117,6 +166,39 @@ ORDER BY directory || '/' || name`
 	return readFiles(rows, make(entities.Files, 0, 10))
 }
 
+// Retrieves the names of the files directly within the specified directory
+// that have at least one tag applied.
+func TaggedFileNamesByDirectory(tx *Tx, path string) (map[string]bool, error) {
+	sql := `
+SELECT name
+FROM file f
+WHERE directory = ? COLLATE ` + pathCollation + ` AND EXISTS (SELECT 1
+                                                        FROM file_tag ft
+                                                        WHERE ft.file_id = f.id)`
+
+	rows, err := tx.Query(sql, _path.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make(map[string]bool)
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+
+		names[name] = true
+	}
+
+	return names, nil
+}
+
 // Retrieves the number of files with the specified fingerprint.
 func FileCountByFingerprint(tx *Tx, fingerprint fingerprint.Fingerprint) (uint, error) {
 	sql := `
@@ -168,8 +250,11 @@ WHERE id NOT IN (SELECT distinct(file_id)
 }
 
 // Retrieves the count of files matching the specified query and matching the specified path.
-func FileCountForQuery(tx *Tx, expression query.Expression, path string, pathContainsRoot, explicitOnly, ignoreCase bool) (uint, error) {
-	builder := buildCountQuery(expression, path, pathContainsRoot, explicitOnly, ignoreCase)
+//
+// Files under any of the excluded paths are not counted, nor are files that are
+// not directories if dirOnly is set or directories if fileOnly is set.
+func FileCountForQuery(tx *Tx, expression query.Expression, path string, pathContainsRoot bool, volumePaths []string, excludedPaths []string, excludedPathsContainRoot, dirOnly, fileOnly, explicitOnly, ignoreCase bool) (uint, error) {
+	builder := buildCountQuery(expression, path, pathContainsRoot, volumePaths, excludedPaths, excludedPathsContainRoot, dirOnly, fileOnly, explicitOnly, ignoreCase)
 
 	rows, err := tx.Query(builder.Sql(), builder.Params()...)
 	if err != nil {
@@ -181,81 +266,177 @@ func FileCountForQuery(tx *Tx, expression query.Expression, path string, pathCon
 }
 
 // Retrieves the set of files matching the specified query and matching the specified path.
-func FilesForQuery(tx *Tx, expression query.Expression, path string, pathContainsRoot, explicitOnly, ignoreCase bool, sort string) (entities.Files, error) {
-	builder := buildQuery(expression, path, pathContainsRoot, explicitOnly, ignoreCase, sort)
+//
+// Files under any of the excluded paths are omitted.
+func FilesForQuery(tx *Tx, expression query.Expression, path string, pathContainsRoot bool, volumePaths []string, excludedPaths []string, excludedPathsContainRoot, explicitOnly, ignoreCase bool, sort string) (entities.Files, error) {
+	files := make(entities.Files, 0, 10)
+
+	err := EachFileForQuery(tx, expression, path, pathContainsRoot, volumePaths, excludedPaths, excludedPathsContainRoot, explicitOnly, ignoreCase, sort, func(file *entities.File) error {
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// Passes each of the files matching the specified query and path to the
+// specified function as it is read, so that however many files match only one
+// is held in memory. Iteration stops at the first error the function returns.
+//
+// Files under any of the excluded paths are omitted.
+func EachFileForQuery(tx *Tx, expression query.Expression, path string, pathContainsRoot bool, volumePaths []string, excludedPaths []string, excludedPathsContainRoot, explicitOnly, ignoreCase bool, sort string, fileFunc func(*entities.File) error) error {
+	builder := buildQuery(expression, path, pathContainsRoot, volumePaths, excludedPaths, excludedPathsContainRoot, explicitOnly, ignoreCase, sort)
 
 	rows, err := tx.Query(builder.Sql(), builder.Params()...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for {
+		file, err := readFile(rows)
+		if err != nil {
+			return err
+		}
+		if file == nil {
+			return nil
+		}
+
+		if err := fileFunc(file); err != nil {
+			return err
+		}
+	}
+}
+
+// Explains how the query for the files matching the specified query and path is
+// run: the SQL and the SQLite query plan. The query is then run, discarding the
+// files, to time it.
+func ExplainQuery(tx *Tx, expression query.Expression, path string, pathContainsRoot bool, volumePaths []string, excludedPaths []string, excludedPathsContainRoot, explicitOnly, ignoreCase bool, sort string) (*entities.QueryExplanation, error) {
+	builder := buildQuery(expression, path, pathContainsRoot, volumePaths, excludedPaths, excludedPathsContainRoot, explicitOnly, ignoreCase, sort)
+
+	explanation := entities.QueryExplanation{Sql: builder.Sql(), Params: builder.Params()}
+
+	rows, err := tx.Query("EXPLAIN QUERY PLAN "+builder.Sql(), builder.Params()...)
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var step entities.QueryPlanStep
+		var unused int
+		if err := rows.Scan(&step.Id, &step.Parent, &unused, &step.Detail); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		explanation.Plan = append(explanation.Plan, step)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+
+	rows, err = tx.Query(builder.Sql(), builder.Params()...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	return readFiles(rows, make(entities.Files, 0, 10))
+	for rows.Next() {
+		explanation.FileCount++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	explanation.Duration = time.Since(start)
+
+	return &explanation, nil
 }
 
-// Retrieves the sets of duplicate files within the database.
-func DuplicateFiles(tx *Tx) ([]entities.Files, error) {
-	sql := `
+// Retrieves the sets of duplicate files within the database, passing each set
+// to the specified function in turn so that only one set is held in memory.
+//
+// Only files under the specified path (if any) of at least the specified size
+// are considered.
+func DuplicateFiles(tx *Tx, path string, pathContainsRoot bool, volumePaths []string, minSize int64, fileSetFunc func(entities.Files) error) error {
+	builder := NewBuilder()
+
+	builder.AppendSql(`
 SELECT id, directory, name, fingerprint, mod_time, size, is_dir
 FROM file
 WHERE fingerprint IN (SELECT fingerprint
                       FROM file
-                      WHERE fingerprint != ''
+                      WHERE fingerprint != '' AND size >= `)
+	builder.AppendParam(minSize)
+	buildPathClause(path, pathContainsRoot, volumePaths, builder)
+	builder.AppendSql(`
                       GROUP BY fingerprint
-                      HAVING count(1) > 1
-)
-ORDER BY fingerprint, directory || '/' || name`
+                      HAVING count(1) > 1)
+      AND size >= `)
+	builder.AppendParam(minSize)
+	buildPathClause(path, pathContainsRoot, volumePaths, builder)
+	builder.AppendSql(`
+ORDER BY fingerprint, directory || '/' || name`)
 
-	rows, err := tx.Query(sql)
+	rows, err := tx.Query(builder.Sql(), builder.Params()...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
-	fileSets := make([]entities.Files, 0, 10)
 	var fileSet entities.Files
-	var previousFingerprint fingerprint.Fingerprint
-
-	for rows.Next() {
-		if rows.Err() != nil {
-			return nil, err
-		}
-
-		var fileId entities.FileId
-		var directory, name, fp string
-		var modTime time.Time
-		var size int64
-		var isDir bool
-		err = rows.Scan(&fileId, &directory, &name, &fp, &modTime, &size, &isDir)
+	for {
+		file, err := readFile(rows)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		fingerprint := fingerprint.Fingerprint(fp)
+		if file == nil || (len(fileSet) > 0 && file.Fingerprint != fileSet[0].Fingerprint) {
+			if len(fileSet) > 0 {
+				if err := fileSetFunc(fileSet); err != nil {
+					return err
+				}
+			}
 
-		if fingerprint != previousFingerprint {
-			if fileSet != nil {
-				fileSets = append(fileSets, fileSet)
+			if file == nil {
+				break
 			}
+
 			fileSet = make(entities.Files, 0, 10)
-			previousFingerprint = fingerprint
 		}
 
-		fileSet = append(fileSet, &entities.File{fileId, directory, name, fingerprint, modTime, size, isDir})
+		fileSet = append(fileSet, file)
 	}
 
-	// ensure last file set is added
-	if len(fileSet) > 0 {
-		fileSets = append(fileSets, fileSet)
+	return nil
+}
+
+// Retrieves the number of fingerprinted files of the specified size.
+func FileCountBySize(tx *Tx, size int64) (uint, error) {
+	sql := `
+SELECT count(1)
+FROM file
+WHERE size = ? AND fingerprint != ''`
+
+	rows, err := tx.Query(sql, size)
+	if err != nil {
+		return 0, err
 	}
+	defer rows.Close()
 
-	return fileSets, nil
+	return readCount(rows)
 }
 
 // Adds a file to the database.
 func InsertFile(tx *Tx, path string, fingerprint fingerprint.Fingerprint, modTime time.Time, size int64, isDir bool) (*entities.File, error) {
-	directory := filepath.Dir(path)
-	name := filepath.Base(path)
+	directory := _path.Dir(path)
+	name := _path.Base(path)
 
 	sql := `
 INSERT INTO file (directory, name, fingerprint, mod_time, size, is_dir)
@@ -284,8 +465,8 @@ VALUES (?, ?, ?, ?, ?, ?)`
 
 // Updates a file in the database.
 func UpdateFile(tx *Tx, fileId entities.FileId, path string, fingerprint fingerprint.Fingerprint, modTime time.Time, size int64, isDir bool) (*entities.File, error) {
-	directory := filepath.Dir(path)
-	name := filepath.Base(path)
+	directory := _path.Dir(path)
+	name := _path.Base(path)
 
 	sql := `
 UPDATE file
@@ -308,8 +489,44 @@ WHERE id = ?`
 	return &entities.File{entities.FileId(fileId), directory, name, fingerprint, modTime, size, isDir}, nil
 }
 
+// Updates the path of a file in the database, leaving its other details as
+// they are.
+func UpdateFilePath(tx *Tx, fileId entities.FileId, path string) error {
+	sql := `
+UPDATE file
+SET directory = ?, name = ?
+WHERE id = ?`
+
+	result, err := tx.Exec(sql, _path.Dir(path), _path.Base(path), int(fileId))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected != 1 {
+		panic("expected exactly one row to be affected.")
+	}
+
+	return nil
+}
+
 // Removes a file from the database.
 func DeleteFile(tx *Tx, fileId entities.FileId) error {
+	if err := DeletePerceptualHash(tx, fileId); err != nil {
+		return err
+	}
+
+	if err := DeleteVerification(tx, fileId); err != nil {
+		return err
+	}
+
+	if err := DeleteFileRelationsByFileId(tx, fileId); err != nil {
+		return err
+	}
+
 	sql := `
 DELETE FROM file
 WHERE id = ?`
@@ -337,6 +554,39 @@ WHERE id = ?`
 func DeleteUntaggedFiles(tx *Tx, fileIds entities.FileIds) error {
 	for _, fileId := range fileIds {
 		sql := `
+DELETE FROM file_perceptual_hash
+WHERE file_id = ?1
+AND (SELECT count(1)
+     FROM file_tag
+     WHERE file_id = ?1) == 0`
+
+		if _, err := tx.Exec(sql, fileId); err != nil {
+			return err
+		}
+
+		sql = `
+DELETE FROM file_verification
+WHERE file_id = ?1
+AND (SELECT count(1)
+     FROM file_tag
+     WHERE file_id = ?1) == 0`
+
+		if _, err := tx.Exec(sql, fileId); err != nil {
+			return err
+		}
+
+		sql = `
+DELETE FROM file_relation
+WHERE (file_id = ?1 OR related_file_id = ?1)
+AND (SELECT count(1)
+     FROM file_tag
+     WHERE file_id = ?1) == 0`
+
+		if _, err := tx.Exec(sql, fileId); err != nil {
+			return err
+		}
+
+		sql = `
 DELETE FROM file
 WHERE id = ?1
 AND (SELECT count(1)
@@ -391,39 +641,66 @@ func readFiles(rows *sql.Rows, files entities.Files) (entities.Files, error) {
 	return files, nil
 }
 
-func buildCountQuery(expression query.Expression, path string, pathContainsRoot, explicitOnly, ignoreCase bool) *SqlBuilder {
+func buildCountQuery(expression query.Expression, path string, pathContainsRoot bool, volumePaths []string, excludedPaths []string, excludedPathsContainRoot, dirOnly, fileOnly, explicitOnly, ignoreCase bool) *SqlBuilder {
 	builder := NewBuilder()
 
 	builder.AppendSql(`
 SELECT count(id)
 FROM file
 WHERE`)
-	buildQueryBranch(expression, builder, explicitOnly, ignoreCase)
-	buildPathClause(path, pathContainsRoot, builder)
+	buildQueryCondition(expression, builder, explicitOnly, ignoreCase)
+	buildPathClause(path, pathContainsRoot, volumePaths, builder)
+	buildExcludedPathsClause(excludedPaths, excludedPathsContainRoot, builder)
+	buildFileTypeClause(dirOnly, fileOnly, builder)
 
 	return builder
 }
 
-func buildQuery(expression query.Expression, path string, pathContainsRoot, explicitOnly, ignoreCase bool, sort string) *SqlBuilder {
+func buildQuery(expression query.Expression, path string, pathContainsRoot bool, volumePaths []string, excludedPaths []string, excludedPathsContainRoot, explicitOnly, ignoreCase bool, sort string) *SqlBuilder {
 	builder := NewBuilder()
 
 	builder.AppendSql(`
 SELECT id, directory, name, fingerprint, mod_time, size, is_dir
 FROM file
 WHERE`)
-	buildQueryBranch(expression, builder, explicitOnly, ignoreCase)
-	buildPathClause(path, pathContainsRoot, builder)
+	buildQueryCondition(expression, builder, explicitOnly, ignoreCase)
+	buildPathClause(path, pathContainsRoot, volumePaths, builder)
+	buildExcludedPathsClause(excludedPaths, excludedPathsContainRoot, builder)
 	buildSort(sort, builder)
 
 	return builder
 }
 
+// restricts the files to those in the set of file IDs matching the expression
+func buildQueryCondition(expression query.Expression, builder *SqlBuilder, explicitOnly, ignoreCase bool) {
+	if _, isEmpty := expression.(query.EmptyExpression); isEmpty {
+		builder.AppendSql("1 == 1")
+		return
+	}
+
+	builder.AppendSql("id IN (")
+	buildQueryBranch(expression, builder, explicitOnly, ignoreCase)
+	builder.AppendSql(")")
+}
+
+// builds a compound select yielding the IDs of the files matching the
+// expression. Rather than nesting a subquery per operator, which SQLite
+// evaluates file by file, the operands are combined with the set operations
+// INTERSECT, EXCEPT and UNION over indexed file_tag lookups.
 func buildQueryBranch(expression query.Expression, builder *SqlBuilder, explicitOnly, ignoreCase bool) {
 	switch exp := expression.(type) {
 	case query.TagExpression:
 		buildTagQueryBranch(exp, builder, explicitOnly, ignoreCase)
 	case query.ComparisonExpression:
-		buildComparisonQueryBranch(exp, builder, explicitOnly, ignoreCase)
+		if query.IsFileAttribute(exp.Tag.Name) {
+			buildFileAttributeQueryBranch(exp, builder, ignoreCase)
+		} else {
+			buildComparisonQueryBranch(exp, builder, explicitOnly, ignoreCase)
+		}
+	case query.ContentExpression:
+		buildContentQueryBranch(exp, builder)
+	case query.RelatedExpression:
+		buildRelatedQueryBranch(exp, builder)
 	case query.NotExpression:
 		buildNotQueryBranch(exp, builder, explicitOnly, ignoreCase)
 	case query.AndExpression:
@@ -431,49 +708,80 @@ func buildQueryBranch(expression query.Expression, builder *SqlBuilder, explicit
 	case query.OrExpression:
 		buildOrQueryBranch(exp, builder, explicitOnly, ignoreCase)
 	case query.EmptyExpression:
-		builder.AppendSql("1 == 1")
+		builder.AppendSql("SELECT id FROM file")
 	default:
 		panic("Unsupported expression type.")
 	}
 }
 
+// the implications with any pattern implications expanded to the values that match the pattern
+const expandedImplications = `SELECT tag_id, value_id, implied_tag_id, implied_value_id
+                              FROM implication
+                              WHERE pattern = ''
+                              UNION ALL
+                              SELECT i.tag_id, v.id, i.implied_tag_id, i.implied_value_id
+                              FROM implication i
+                              INNER JOIN value v ON v.name GLOB i.pattern
+                              WHERE i.pattern != ''`
+
+// the file tags each file inherits from the directories above it that were
+// tagged for inheritance
+const inheritedFileTags = `SELECT f.id AS file_id, ft.tag_id, ft.value_id
+                           FROM file_tag ft
+                           INNER JOIN file d ON d.id = ft.file_id
+                           INNER JOIN file f ON f.directory = ` + directoryPath + ` OR
+                                                substr(f.directory, 1, length(` + directoryPath + `) + 1) = ` + directoryPath + ` || '/'
+                           WHERE ft.inherit`
+
+// the IDs of the files having, directly or by inheritance, the tags and values
+// of the working table. The working table is forced to be the outer loop of
+// the join so that the file tags are searched by tag rather than each file tag
+// being scanned for a match.
+func effectiveFileTagsJoin(working, condition string) string {
+	return `SELECT file_tag.file_id
+      FROM ` + working + ` imps
+      CROSS JOIN file_tag ON file_tag.tag_id = imps.tag_id AND ` + condition + `
+      UNION ALL
+      SELECT file_tag.file_id
+      FROM (` + inheritedFileTags + `) file_tag
+      INNER JOIN ` + working + ` imps ON file_tag.tag_id = imps.tag_id AND ` + condition
+}
+
+// the path of directory d, which for a directory at the root is just its name
+const directoryPath = `CASE d.directory WHEN '/' THEN '/' || d.name WHEN '.' THEN d.name ELSE d.directory || '/' || d.name END`
+
 func buildTagQueryBranch(expression query.TagExpression, builder *SqlBuilder, explicitOnly, ignoreCase bool) {
 	collation := collationFor(ignoreCase)
 
 	if explicitOnly {
 		builder.AppendSql(`
-id IN (SELECT file_id
-       FROM file_tag
-       WHERE tag_id = (SELECT id
-                       FROM tag
-                       WHERE name` + collation + ` = `)
+SELECT file_id
+FROM file_tag
+WHERE NOT implied AND
+      tag_id = (SELECT id
+                FROM tag
+                WHERE name` + collation + ` = `)
 		builder.AppendParam(expression.Name)
 		builder.AppendSql(`
-                       )
-      )`)
+               )`)
 	} else {
 		builder.AppendSql(`
-id IN (SELECT file_id
-       FROM file_tag
-       INNER JOIN (WITH RECURSIVE working (tag_id, value_id) AS
-                   (
-                       SELECT id, 0
-                       FROM tag
-                       WHERE name` + collation + ` = `)
+SELECT file_id
+FROM (WITH RECURSIVE working (tag_id, value_id) AS
+      (
+          SELECT id, 0
+          FROM tag
+          WHERE name` + collation + ` = `)
 		builder.AppendParam(expression.Name)
 		builder.AppendSql(`
-                       UNION ALL
-                       SELECT b.tag_id, b.value_id
-                       FROM implication b, working
-                       WHERE b.implied_tag_id = working.tag_id AND
-                             (b.implied_value_id = working.value_id OR working.value_id = 0)
-                   )
-                   SELECT tag_id, value_id
-                   FROM working
-                  ) imps
-       ON file_tag.tag_id = imps.tag_id
-       AND (file_tag.value_id = imps.value_id OR imps.value_id = 0)
-      )`)
+          UNION ALL
+          SELECT b.tag_id, b.value_id
+          FROM (` + expandedImplications + `) b, working
+          WHERE b.implied_tag_id = working.tag_id AND
+                (b.implied_value_id = working.value_id OR working.value_id = 0)
+      )
+      ` + effectiveFileTagsJoin("working", "(file_tag.value_id = imps.value_id OR imps.value_id = 0)") + `
+     )`)
 	}
 }
 
@@ -489,117 +797,453 @@ func buildComparisonQueryBranch(expression query.ComparisonExpression, builder *
 	}
 
 	if expression.Operator == "!=" {
-		// reinterprent as otherwise it won't work for multiple values of same tag
+		// reinterpret as otherwise it won't work for multiple values of same tag
 		expression.Operator = "=="
-		builder.AppendSql(" not ")
+		buildNotQueryBranch(query.NotExpression{expression}, builder, explicitOnly, ignoreCase)
+		return
 	}
 
+	anyValue := expression.AnyValue()
+
 	if explicitOnly {
 		builder.AppendSql(`
-id IN (SELECT file_id
-       FROM file_tag
-       WHERE tag_id = (SELECT id
-                       FROM tag
-                       WHERE name` + collation + ` = `)
+SELECT file_id
+FROM file_tag
+WHERE NOT implied AND
+      tag_id = (SELECT id
+                FROM tag
+                WHERE name` + collation + ` = `)
 		builder.AppendParam(expression.Tag.Name)
-		builder.AppendSql(`) AND
-             value_id = (SELECT id
-                         FROM value
-                         WHERE name` + collation + ` = `)
-		builder.AppendParam(expression.Value.Name)
-		builder.AppendSql(`)
-     )`)
+		if anyValue {
+			builder.AppendSql(`) AND
+      value_id IN (SELECT id
+                   FROM value`)
+		} else if expression.Operator == "~" {
+			builder.AppendSql(`) AND
+      value_id IN (SELECT id
+                   FROM value
+                   WHERE name REGEXP `)
+			builder.AppendParam(regexpFor(expression.Value.Name, ignoreCase))
+		} else {
+			builder.AppendSql(`) AND
+      value_id = (SELECT id
+                  FROM value
+                  WHERE name` + collation + ` = `)
+			builder.AppendParam(expression.Value.Name)
+		}
+		builder.AppendSql(`)`)
 	} else {
 		builder.AppendSql(`
-id IN (WITH RECURSIVE impft (tag_id, value_id) AS
-       (
-           SELECT t.id, v.id
-           FROM tag t, value v
-           WHERE t.name` + collation + ` = `)
+SELECT file_id
+FROM (WITH RECURSIVE impft (tag_id, value_id) AS
+      (
+          SELECT t.id, v.id
+          FROM tag t, value v
+          WHERE t.name` + collation + ` = `)
 		builder.AppendParam(expression.Tag.Name)
-		builder.AppendSql("AND " + valueTerm + collation + " " + expression.Operator + " ")
-		builder.AppendParam(expression.Value.Name)
+		switch {
+		case anyValue:
+			// every value of the tag
+		case expression.Operator == "~":
+			builder.AppendSql(` AND v.name REGEXP `)
+			builder.AppendParam(regexpFor(expression.Value.Name, ignoreCase))
+		default:
+			buildTypedComparison(expression, valueTerm, collation, builder)
+		}
 		builder.AppendSql(`
-           UNION ALL
-           SELECT b.tag_id, b.value_id
-           FROM implication b, impft
-           WHERE b.implied_tag_id = impft.tag_id AND
-                 (b.implied_value_id = impft.value_id OR impft.value_id = 0)
-       )
+          UNION ALL
+          SELECT b.tag_id, b.value_id
+          FROM (` + expandedImplications + `) b, impft
+          WHERE b.implied_tag_id = impft.tag_id AND
+                (b.implied_value_id = impft.value_id OR impft.value_id = 0)
+      )
+      ` + effectiveFileTagsJoin("impft", "file_tag.value_id = imps.value_id") + `
+     )`)
+	}
+}
+
+// compares according to the tag's declared type, if any, otherwise numerically if the value is a number
+func buildTypedComparison(expression query.ComparisonExpression, untypedValueTerm, collation string, builder *SqlBuilder) {
+	operator := " " + expression.Operator + " "
+
+	builder.AppendSql(`AND CASE (SELECT type FROM tag_definition WHERE tag_id = t.id)
+               WHEN 'integer' THEN CAST(v.name AS integer)` + operator + `CAST(`)
+	builder.AppendParam(expression.Value.Name)
+	builder.AppendSql(` AS integer)
+               WHEN 'enum' THEN (SELECT ordinal FROM tag_enum_value WHERE tag_id = t.id AND name = v.name)` + operator + `(SELECT ordinal FROM tag_enum_value WHERE tag_id = t.id AND name` + collation + ` = `)
+	builder.AppendParam(expression.Value.Name)
+	builder.AppendSql(`)
+               WHEN 'date' THEN v.name` + operator)
+	builder.AppendParam(expression.Value.Name)
+	builder.AppendSql(`
+               WHEN 'text' THEN v.name` + collation + operator)
+	builder.AppendParam(expression.Value.Name)
+	builder.AppendSql(`
+               ELSE ` + untypedValueTerm + collation + operator)
+	builder.AppendParam(expression.Value.Name)
+	builder.AppendSql(`
+           END`)
+}
+
+// compares an attribute of the file itself: its name, extension, size or modification time
+func buildFileAttributeQueryBranch(expression query.ComparisonExpression, builder *SqlBuilder, ignoreCase bool) {
+	builder.AppendSql(`
+SELECT id
+FROM file
+WHERE`)
 
-       SELECT file_id
-       FROM file_tag
-       INNER JOIN impft
-       ON file_tag.tag_id = impft.tag_id AND
-          file_tag.value_id = impft.value_id
-      )`)
+	switch expression.Tag.Name {
+	case "name":
+		if expression.Operator == "~" {
+			buildGlobClause("", expression.Value.Name, ignoreCase, builder)
+		} else {
+			builder.AppendSql(`
+name` + collationFor(ignoreCase) + ` ` + expression.Operator + ` `)
+			builder.AppendParam(expression.Value.Name)
+		}
+	case "ext":
+		extension := strings.TrimPrefix(expression.Value.Name, ".")
+		if expression.Operator == "~" {
+			buildGlobClause("*.", extension, ignoreCase, builder)
+		} else {
+			if expression.Operator == "!=" {
+				builder.AppendSql(" NOT ")
+			}
+			buildGlobClause("*.", escapeGlob(extension), ignoreCase, builder)
+		}
+	case "size":
+		size, _ := units.ParseSize(expression.Value.Name) // validated by parser
+		builder.AppendSql(`
+size ` + expression.Operator + ` `)
+		builder.AppendParam(size)
+	case "mtime":
+		modTime, _ := units.ParseTime(expression.Value.Name) // validated by parser
+		builder.AppendSql(`
+julianday(mod_time) ` + expression.Operator + ` julianday(`)
+		builder.AppendParam(modTime.UTC().Format("2006-01-02 15:04:05"))
+		builder.AppendSql(`)`)
+	default:
+		panic("unsupported file attribute: " + expression.Tag.Name)
 	}
 }
 
+// matches the file name against a glob pattern, which is appended to the prefix
+func buildGlobClause(prefix, pattern string, ignoreCase bool, builder *SqlBuilder) {
+	if ignoreCase {
+		builder.AppendSql(`
+lower(name) GLOB '` + prefix + `' || lower(`)
+		builder.AppendParam(pattern)
+		builder.AppendSql(`)`)
+	} else {
+		builder.AppendSql(`
+name GLOB '` + prefix + `' || `)
+		builder.AppendParam(pattern)
+	}
+}
+
+// escapes the glob metacharacters so that the text is matched literally
+func escapeGlob(text string) string {
+	var escaped strings.Builder
+	for _, r := range text {
+		switch r {
+		case '*', '?', '[':
+			escaped.WriteRune('[')
+			escaped.WriteRune(r)
+			escaped.WriteRune(']')
+		default:
+			escaped.WriteRune(r)
+		}
+	}
+
+	return escaped.String()
+}
+
+// matches the full-text index of file contents, quoting the text so that it is
+// matched as a phrase rather than interpreted as an FTS5 query
+func buildContentQueryBranch(expression query.ContentExpression, builder *SqlBuilder) {
+	builder.AppendSql(`
+SELECT rowid
+FROM file_content
+WHERE file_content MATCH `)
+	builder.AppendParam(`"` + strings.Replace(expression.Text, `"`, `""`, -1) + `"`)
+}
+
+// matches the files related, in either direction, to the file at the stored
+// path
+func buildRelatedQueryBranch(expression query.RelatedExpression, builder *SqlBuilder) {
+	builder.AppendSql(`
+SELECT CASE r.file_id WHEN f.id THEN r.related_file_id ELSE r.file_id END
+FROM file f
+INNER JOIN file_relation r ON r.file_id = f.id OR r.related_file_id = f.id
+WHERE f.directory = `)
+	builder.AppendParam(_path.Dir(expression.Path))
+	builder.AppendSql(` COLLATE ` + pathCollation + ` AND f.name = `)
+	builder.AppendParam(_path.Base(expression.Path))
+	builder.AppendSql(` COLLATE ` + pathCollation)
+}
+
+// the regular expression to match values against, made case-insensitive if necessary
+func regexpFor(pattern string, ignoreCase bool) string {
+	if ignoreCase {
+		return "(?i)" + pattern
+	}
+
+	return pattern
+}
+
 func buildNotQueryBranch(expression query.NotExpression, builder *SqlBuilder, explicitOnly, ignoreCase bool) {
-	builder.AppendSql("NOT")
-	buildQueryBranch(expression.Operand, builder, explicitOnly, ignoreCase)
+	builder.AppendSql(`
+SELECT id
+FROM file
+EXCEPT`)
+	buildSetOperand(expression.Operand, builder, explicitOnly, ignoreCase)
 }
 
+// intersects the operands of a chain of 'and' operations, subtracting the
+// negated operands from the result rather than each from the set of all files
 func buildAndQueryBranch(expression query.AndExpression, builder *SqlBuilder, explicitOnly, ignoreCase bool) {
-	buildQueryBranch(expression.LeftOperand, builder, explicitOnly, ignoreCase)
-	builder.AppendSql("AND")
-	buildQueryBranch(expression.RightOperand, builder, explicitOnly, ignoreCase)
+	included, excluded := andOperands(expression, nil, nil)
+
+	if len(included) == 0 {
+		builder.AppendSql(`
+SELECT id
+FROM file`)
+	}
+
+	for index, operand := range included {
+		if index > 0 {
+			builder.AppendSql("INTERSECT")
+		}
+
+		buildSetOperand(operand, builder, explicitOnly, ignoreCase)
+	}
+
+	for _, operand := range excluded {
+		builder.AppendSql("EXCEPT")
+		buildSetOperand(operand, builder, explicitOnly, ignoreCase)
+	}
 }
 
 func buildOrQueryBranch(expression query.OrExpression, builder *SqlBuilder, explicitOnly, ignoreCase bool) {
-	builder.AppendSql("(")
-	buildQueryBranch(expression.LeftOperand, builder, explicitOnly, ignoreCase)
-	builder.AppendSql("OR")
-	buildQueryBranch(expression.RightOperand, builder, explicitOnly, ignoreCase)
+	for index, operand := range orOperands(expression, nil) {
+		if index > 0 {
+			builder.AppendSql("UNION")
+		}
+
+		buildSetOperand(operand, builder, explicitOnly, ignoreCase)
+	}
+}
+
+// builds an operand of a set operation, wrapping those that are themselves
+// set operations in a subquery as SQLite gives every set operator the same
+// precedence
+func buildSetOperand(expression query.Expression, builder *SqlBuilder, explicitOnly, ignoreCase bool) {
+	if !isSetOperation(expression) {
+		buildQueryBranch(expression, builder, explicitOnly, ignoreCase)
+		return
+	}
+
+	builder.AppendSql("SELECT * FROM (")
+	buildQueryBranch(expression, builder, explicitOnly, ignoreCase)
 	builder.AppendSql(")")
 }
 
-func buildPathClause(path string, pathContainsRoot bool, builder *SqlBuilder) {
+func isSetOperation(expression query.Expression) bool {
+	switch exp := expression.(type) {
+	case query.AndExpression, query.OrExpression, query.NotExpression:
+		return true
+	case query.ComparisonExpression:
+		return exp.Operator == "!=" && !query.IsFileAttribute(exp.Tag.Name)
+	}
+
+	return false
+}
+
+// splits a chain of 'and' operations into the operands to intersect and the
+// negated operands to subtract
+func andOperands(expression query.Expression, included, excluded []query.Expression) ([]query.Expression, []query.Expression) {
+	switch exp := expression.(type) {
+	case query.AndExpression:
+		included, excluded = andOperands(exp.LeftOperand, included, excluded)
+		return andOperands(exp.RightOperand, included, excluded)
+	case query.NotExpression:
+		return included, append(excluded, exp.Operand)
+	case query.ComparisonExpression:
+		if exp.Operator == "!=" && !query.IsFileAttribute(exp.Tag.Name) {
+			exp.Operator = "=="
+			return included, append(excluded, exp)
+		}
+	}
+
+	return append(included, expression), excluded
+}
+
+// flattens a chain of 'or' operations into its operands
+func orOperands(expression query.Expression, operands []query.Expression) []query.Expression {
+	if exp, isOr := expression.(query.OrExpression); isOr {
+		operands = orOperands(exp.LeftOperand, operands)
+		return orOperands(exp.RightOperand, operands)
+	}
+
+	return append(operands, expression)
+}
+
+// restricts the files to directories or to files that are not directories
+func buildFileTypeClause(dirOnly, fileOnly bool, builder *SqlBuilder) {
+	if dirOnly {
+		builder.AppendSql(" AND is_dir")
+	}
+
+	if fileOnly {
+		builder.AppendSql(" AND NOT is_dir")
+	}
+}
+
+func buildPathClause(path string, pathContainsRoot bool, volumePaths []string, builder *SqlBuilder) {
 	if path == "" {
 		return
 	}
 
-	path = filepath.Clean(path)
+	path = _path.Clean(path)
 
 	builder.AppendSql("AND (")
 
 	if path == "." {
-		builder.AppendSql("directory NOT LIKE '/%'")
+		builder.AppendSql(relativeDirectoryCondition)
 	} else {
 		builder.AppendSql("directory = ")
 		builder.AppendParam(path)
-		builder.AppendSql(" OR directory LIKE ")
-		builder.AppendParam(filepath.Join(path, "%"))
+		builder.AppendSql(" COLLATE " + pathCollation + " OR directory LIKE ")
+		builder.AppendParam(_path.Join(path, "%"))
 
 		if pathContainsRoot {
-			builder.AppendSql(" OR directory NOT LIKE '/%'")
+			builder.AppendSql(" OR " + relativeDirectoryCondition)
 		}
 	}
 
-	dir, name := filepath.Split(path)
+	for _, volumePath := range volumePaths {
+		builder.AppendSql(" OR directory = ")
+		builder.AppendParam(volumePath)
+		builder.AppendSql(" OR directory LIKE ")
+		builder.AppendParam(volumePath + "/%")
+	}
+
+	dir, name := _path.Split(path)
 	if dir != "" {
 		builder.AppendSql(" OR (directory = ")
-		builder.AppendParam(filepath.Clean(dir))
-		builder.AppendSql(" AND name = ")
+		builder.AppendParam(_path.Clean(dir))
+		builder.AppendSql(" COLLATE " + pathCollation + " AND name = ")
 		builder.AppendParam(name)
-		builder.AppendSql(")")
+		builder.AppendSql(" COLLATE " + pathCollation + ")")
 	}
 
 	builder.AppendSql(")")
 }
 
+func buildExcludedPathsClause(paths []string, pathsContainRoot bool, builder *SqlBuilder) {
+	if len(paths) == 0 {
+		return
+	}
+
+	builder.AppendSql("AND NOT (")
+
+	for index, path := range paths {
+		path = _path.Clean(path)
+
+		if index > 0 {
+			builder.AppendSql(" OR ")
+		}
+
+		if path == "." {
+			builder.AppendSql(relativeDirectoryCondition)
+			continue
+		}
+
+		// a prefix comparison rather than LIKE, which would treat '%' and '_'
+		// in the path as wildcards and ignore the path collation's case
+		prefix := strings.TrimSuffix(path, "/") + "/"
+
+		builder.AppendSql("directory = ")
+		builder.AppendParam(path)
+		builder.AppendSql(" COLLATE " + pathCollation + " OR substr(directory, 1, length(")
+		builder.AppendParam(prefix)
+		builder.AppendSql(")) = ")
+		builder.AppendParam(prefix)
+		builder.AppendSql(" COLLATE " + pathCollation)
+
+		dir, name := _path.Split(path)
+		if dir != "" {
+			builder.AppendSql(" OR (directory = ")
+			builder.AppendParam(_path.Clean(dir))
+			builder.AppendSql(" COLLATE " + pathCollation + " AND name = ")
+			builder.AppendParam(name)
+			builder.AppendSql(" COLLATE " + pathCollation + ")")
+		}
+	}
+
+	if pathsContainRoot {
+		builder.AppendSql(" OR " + relativeDirectoryCondition)
+	}
+
+	builder.AppendSql(")")
+}
+
+// orders by the sort, which may be prefixed with '-' to reverse the order
 func buildSort(sort string, builder *SqlBuilder) {
-	switch sort {
-	case "none":
+	direction := ""
+	if strings.HasPrefix(sort, "-") {
+		sort = sort[1:]
+		direction = " DESC"
+	}
+
+	switch {
+	case sort == "none":
 		// do nowt
-	case "id":
-		builder.AppendSql("ORDER BY id")
-	case "name":
-		builder.AppendSql("ORDER BY directory || '/' || name")
-	case "time":
-		builder.AppendSql("ORDER BY mod_time, directory || '/' || name")
-	case "size":
-		builder.AppendSql("ORDER BY size, directory || '/' || name")
+	case sort == "id":
+		builder.AppendSql("ORDER BY id" + direction)
+	case sort == "name":
+		builder.AppendSql("ORDER BY directory || '/' || name" + direction)
+	case sort == "time":
+		builder.AppendSql("ORDER BY mod_time" + direction + ", directory || '/' || name")
+	case sort == "size":
+		builder.AppendSql("ORDER BY size" + direction + ", directory || '/' || name")
+	case strings.HasPrefix(sort, "value:"):
+		buildValueSort(strings.TrimPrefix(sort, "value:"), direction, builder)
 	}
 }
+
+// orders by the value of a tag, according to the tag's declared type, with the
+// files that do not have the tag last
+func buildValueSort(tagName, direction string, builder *SqlBuilder) {
+	// a file with several values of the tag is ordered by the first of them
+	aggregate := "min"
+	if direction != "" {
+		aggregate = "max"
+	}
+
+	valueKey := `(SELECT ` + aggregate + `(` + typedValueTerm + `)
+ FROM file_tag ft
+ INNER JOIN tag t ON t.id = ft.tag_id
+ INNER JOIN value v ON v.id = ft.value_id
+ WHERE ft.file_id = file.id AND t.name = `
+
+	builder.AppendSql(`
+ORDER BY ` + valueKey)
+	builder.AppendParam(tagName)
+	builder.AppendSql(`) IS NULL, ` + valueKey)
+	builder.AppendParam(tagName)
+	builder.AppendSql(`)` + direction + `, directory || '/' || name`)
+}
+
+// the value v of tag t as the tag's declared type, if any, otherwise as a
+// number if it looks like one
+const typedValueTerm = `CASE (SELECT type FROM tag_definition WHERE tag_id = t.id)
+     WHEN 'integer' THEN CAST(v.name AS integer)
+     WHEN 'enum' THEN (SELECT ordinal FROM tag_enum_value WHERE tag_id = t.id AND name = v.name)
+     WHEN 'date' THEN v.name
+     WHEN 'text' THEN v.name
+     ELSE CASE WHEN v.name GLOB '[0-9]*' OR v.name GLOB '-[0-9]*' OR v.name GLOB '.[0-9]*'
+               THEN CAST(v.name AS float)
+               ELSE v.name
+          END
+ END`
@@ -0,0 +1,83 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"github.com/oniony/TMSU/query"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestFilesForQueryOmitsExcludedPaths(test *testing.T) {
+	// set-up
+
+	dir, err := ioutil.TempDir("", "tmsu-test")
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "db")
+	if err := CreateAt(path, ""); err != nil {
+		test.Fatal(err)
+	}
+
+	database, err := OpenAt(path, 0, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer database.Close()
+
+	tx, err := database.Begin()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	for _, path := range []string{"/data/a_b/sub/1", "/data/axb/sub/2", "/data/a%b/sub/3", "/data/abcb/sub/4", "/data/photos/sub/5", "/data/Photos/sub/6"} {
+		if _, err := InsertFile(tx, path, "", time.Now(), 0, false); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	// test
+
+	files, err := FilesForQuery(tx, query.EmptyExpression{}, "", false, nil, []string{"/data/a_b", "/data/a%b", "/data/photos"}, false, false, false, "")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// validate
+
+	paths := make([]string, len(files))
+	for index, file := range files {
+		paths[index] = file.Path()
+	}
+	sort.Strings(paths)
+
+	expected := []string{"/data/abcb/sub/4", "/data/axb/sub/2"}
+	if pathCollation == "BINARY" {
+		expected = append([]string{"/data/Photos/sub/6"}, expected...)
+	}
+	if !reflect.DeepEqual(paths, expected) {
+		test.Fatalf("expected files %v but got %v", expected, paths)
+	}
+}
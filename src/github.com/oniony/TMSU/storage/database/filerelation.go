@@ -0,0 +1,102 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"database/sql"
+	"github.com/oniony/TMSU/entities"
+)
+
+// Retrieves the relations from and to the specified file.
+func FileRelationsByFileId(tx *Tx, fileId entities.FileId) (entities.FileRelations, error) {
+	sql := `
+SELECT file_id, related_file_id, relation
+FROM file_relation
+WHERE file_id = ?1 OR related_file_id = ?1
+ORDER BY relation, file_id, related_file_id`
+
+	rows, err := tx.Query(sql, fileId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readFileRelations(rows, make(entities.FileRelations, 0, 10))
+}
+
+// Relates one file to another.
+func AddFileRelation(tx *Tx, fileId, relatedFileId entities.FileId, relation string) (*entities.FileRelation, error) {
+	sql := `
+INSERT OR IGNORE INTO file_relation (file_id, related_file_id, relation)
+VALUES (?1, ?2, ?3)`
+
+	if _, err := tx.Exec(sql, fileId, relatedFileId, relation); err != nil {
+		return nil, err
+	}
+
+	return &entities.FileRelation{fileId, relatedFileId, relation}, nil
+}
+
+// Removes a relation between files.
+func DeleteFileRelation(tx *Tx, fileId, relatedFileId entities.FileId, relation string) error {
+	sql := `
+DELETE FROM file_relation
+WHERE file_id = ?1 AND related_file_id = ?2 AND relation = ?3`
+
+	result, err := tx.Exec(sql, fileId, relatedFileId, relation)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return NoSuchFileRelationError{fileId, relatedFileId, relation}
+	}
+
+	return nil
+}
+
+// Removes the relations from and to the specified file.
+func DeleteFileRelationsByFileId(tx *Tx, fileId entities.FileId) error {
+	sql := `
+DELETE FROM file_relation
+WHERE file_id = ?1 OR related_file_id = ?1`
+
+	_, err := tx.Exec(sql, fileId)
+	return err
+}
+
+// unexported
+
+func readFileRelations(rows *sql.Rows, relations entities.FileRelations) (entities.FileRelations, error) {
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var relation entities.FileRelation
+		if err := rows.Scan(&relation.FileId, &relation.RelatedFileId, &relation.Relation); err != nil {
+			return nil, err
+		}
+
+		relations = append(relations, &relation)
+	}
+
+	return relations, nil
+}
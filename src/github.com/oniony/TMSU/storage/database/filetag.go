@@ -18,6 +18,7 @@ package database
 import (
 	"database/sql"
 	"github.com/oniony/TMSU/entities"
+	"strings"
 )
 
 // Determines whether the specified file has the specified tag applied.
@@ -25,9 +26,9 @@ func FileTagExists(tx *Tx, fileId entities.FileId, tagId entities.TagId, valueId
 	sql := `
 SELECT count(1)
 FROM file_tag
-WHERE file_id = ?1 AND tag_id = ?2 AND value_id = ?3`
+WHERE file_id = ?1 AND tag_id = ?2 AND value_id = ?3 AND NOT implied`
 
-	rows, err := tx.Query(sql, fileId, tagId, valueId)
+	rows, err := tx.QueryPrepared(sql, fileId, tagId, valueId)
 	if err != nil {
 		return false, err
 	}
@@ -43,7 +44,8 @@ func FileTagCount(tx *Tx) (uint, error) {
 
 	sql = `
 SELECT count(1)
-FROM file_tag`
+FROM file_tag
+WHERE NOT implied`
 
 	rows, err := tx.Query(sql)
 	if err != nil {
@@ -58,7 +60,8 @@ FROM file_tag`
 func FileTags(tx *Tx) (entities.FileTags, error) {
 	sql := `
 SELECT file_id, tag_id, value_id
-FROM file_tag`
+FROM file_tag
+WHERE NOT implied`
 
 	rows, err := tx.Query(sql)
 	if err != nil {
@@ -76,9 +79,9 @@ func FileTagCountByFileId(tx *Tx, fileId entities.FileId) (uint, error) {
 	sql = `
 SELECT count(1)
 FROM file_tag
-WHERE file_id = ?1`
+WHERE file_id = ?1 AND NOT implied`
 
-	rows, err := tx.Query(sql, fileId)
+	rows, err := tx.QueryPrepared(sql, fileId)
 	if err != nil {
 		return 0, err
 	}
@@ -94,7 +97,7 @@ func FileTagCountByTagId(tx *Tx, tagId entities.TagId) (uint, error) {
 	sql = `
 SELECT count(1)
 FROM file_tag
-WHERE tag_id = ?1`
+WHERE tag_id = ?1 AND NOT implied`
 
 	rows, err := tx.Query(sql, tagId)
 	if err != nil {
@@ -110,7 +113,7 @@ func FileTagsByTagId(tx *Tx, tagId entities.TagId) (entities.FileTags, error) {
 	sql := `
 SELECT file_id, tag_id, value_id
 FROM file_tag
-WHERE tag_id = ?1`
+WHERE tag_id = ?1 AND NOT implied`
 
 	rows, err := tx.Query(sql, tagId)
 	if err != nil {
@@ -128,7 +131,7 @@ func FileTagCountByValueId(tx *Tx, valueId entities.ValueId) (uint, error) {
 	sql = `
 SELECT count(1)
 FROM file_tag
-WHERE value_id = ?1`
+WHERE value_id = ?1 AND NOT implied`
 
 	rows, err := tx.Query(sql, valueId)
 	if err != nil {
@@ -144,7 +147,7 @@ func FileTagsByValueId(tx *Tx, valueId entities.ValueId) (entities.FileTags, err
 	sql := `
 SELECT file_id, tag_id, value_id
 FROM file_tag
-WHERE value_id = ?1`
+WHERE value_id = ?1 AND NOT implied`
 
 	rows, err := tx.Query(sql, valueId)
 	if err != nil {
@@ -160,9 +163,9 @@ func FileTagsByFileId(tx *Tx, fileId entities.FileId) (entities.FileTags, error)
 	sql := `
 SELECT file_id, tag_id, value_id
 FROM file_tag
-WHERE file_id = ?1`
+WHERE file_id = ?1 AND NOT implied`
 
-	rows, err := tx.Query(sql, fileId)
+	rows, err := tx.QueryPrepared(sql, fileId)
 	if err != nil {
 		return nil, err
 	}
@@ -171,13 +174,126 @@ WHERE file_id = ?1`
 	return readFileTags(rows, make(entities.FileTags, 0, 10))
 }
 
-// Adds a file tag.
+// Retrieves the set of file tags for the specified files.
+func FileTagsByFileIds(tx *Tx, fileIds entities.FileIds) (entities.FileTags, error) {
+	fileTags := make(entities.FileTags, 0, len(fileIds)*5)
+
+	for start := 0; start < len(fileIds); start += parameterBatchSize {
+		end := start + parameterBatchSize
+		if end > len(fileIds) {
+			end = len(fileIds)
+		}
+
+		batch := fileIds[start:end]
+
+		sql := `
+SELECT file_id, tag_id, value_id
+FROM file_tag
+WHERE file_id IN (?`
+		sql += strings.Repeat(",?", len(batch)-1)
+		sql += `) AND NOT implied
+ORDER BY file_id`
+
+		params := make([]interface{}, len(batch))
+		for index, fileId := range batch {
+			params[index] = fileId
+		}
+
+		rows, err := tx.Query(sql, params...)
+		if err != nil {
+			return nil, err
+		}
+
+		fileTags, err = readFileTags(rows, fileTags)
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return fileTags, nil
+}
+
+// Retrieves the IDs of the files with file tags applied by the specified
+// author.
+func FileIdsTaggedBy(tx *Tx, author string) (entities.FileIds, error) {
+	sql := `
+SELECT DISTINCT file_id
+FROM file_tag
+WHERE author = ? AND NOT implied
+ORDER BY file_id`
+
+	rows, err := tx.Query(sql, author)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fileIds := make(entities.FileIds, 0, 10)
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var fileId entities.FileId
+		if err := rows.Scan(&fileId); err != nil {
+			return nil, err
+		}
+
+		fileIds = append(fileIds, fileId)
+	}
+
+	return fileIds, nil
+}
+
+// Retrieves the number of file tags applied by each author.
+func FileTagCountsByAuthor(tx *Tx) ([]entities.AuthorFileTagCount, error) {
+	sql := `
+SELECT author, count(1)
+FROM file_tag
+WHERE NOT implied
+GROUP BY author
+ORDER BY author`
+
+	rows, err := tx.Query(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]entities.AuthorFileTagCount, 0, 10)
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var count entities.AuthorFileTagCount
+		if err := rows.Scan(&count.Author, &count.FileTagCount); err != nil {
+			return nil, err
+		}
+
+		counts = append(counts, count)
+	}
+
+	return counts, nil
+}
+
+// Adds a file tag, attributed to the database's user. A file tag already
+// applied by implication becomes explicit.
 func AddFileTag(tx *Tx, fileId entities.FileId, tagId entities.TagId, valueId entities.ValueId) (*entities.FileTag, error) {
 	sql := `
-INSERT OR IGNORE INTO file_tag (file_id, tag_id, value_id)
-VALUES (?1, ?2, ?3)`
+DELETE FROM file_tag
+WHERE file_id = ?1 AND tag_id = ?2 AND value_id = ?3 AND implied`
+
+	if _, err := tx.ExecPrepared(sql, fileId, tagId, valueId); err != nil {
+		return nil, err
+	}
+
+	sql = `
+INSERT OR IGNORE INTO file_tag (file_id, tag_id, value_id, author)
+VALUES (?1, ?2, ?3, ?4)`
 
-	_, err := tx.Exec(sql, fileId, tagId, valueId)
+	_, err := tx.ExecPrepared(sql, fileId, tagId, valueId, tx.database.user)
 	if err != nil {
 		return nil, err
 	}
@@ -185,13 +301,71 @@ VALUES (?1, ?2, ?3)`
 	return &entities.FileTag{fileId, tagId, valueId, true, false}, nil
 }
 
+// Sets whether a directory's file tag is inherited by the directory's contents.
+func SetFileTagInheritance(tx *Tx, fileId entities.FileId, tagId entities.TagId, valueId entities.ValueId, inherit bool) error {
+	sql := `
+UPDATE file_tag
+SET inherit = ?4
+WHERE file_id = ?1 AND tag_id = ?2 AND value_id = ?3`
+
+	result, err := tx.ExecPrepared(sql, fileId, tagId, valueId, inherit)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return NoSuchFileTagError{fileId, tagId, valueId}
+	}
+
+	return nil
+}
+
+// Retrieves the tags, and values, inherited by the contents of the specified
+// directory from it and the directories above it.
+func InheritedTagValuePairs(tx *Tx, directory string) (entities.TagIdValueIdPairs, error) {
+	sql := `
+SELECT DISTINCT ft.tag_id, ft.value_id
+FROM file_tag ft
+INNER JOIN file d ON d.id = ft.file_id
+WHERE ft.inherit AND
+      (?1 = ` + directoryPath + ` OR
+       substr(?1, 1, length(` + directoryPath + `) + 1) = ` + directoryPath + ` || '/')
+ORDER BY ft.tag_id, ft.value_id`
+
+	rows, err := tx.Query(sql, directory)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pairs := make(entities.TagIdValueIdPairs, 0, 10)
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var pair entities.TagIdValueIdPair
+		if err := rows.Scan(&pair.TagId, &pair.ValueId); err != nil {
+			return nil, err
+		}
+
+		pairs = append(pairs, pair)
+	}
+
+	return pairs, nil
+}
+
 // Removes a file tag.
 func DeleteFileTag(tx *Tx, fileId entities.FileId, tagId entities.TagId, valueId entities.ValueId) error {
 	sql := `
 DELETE FROM file_tag
-WHERE file_id = ?1 AND tag_id = ?2 AND value_id = ?3`
+WHERE file_id = ?1 AND tag_id = ?2 AND value_id = ?3 AND NOT implied`
 
-	result, err := tx.Exec(sql, fileId, tagId, valueId)
+	result, err := tx.ExecPrepared(sql, fileId, tagId, valueId)
 	if err != nil {
 		return err
 	}
@@ -255,10 +429,10 @@ WHERE value_id = ?`
 // Copies file tags from one tag to another.
 func CopyFileTags(tx *Tx, sourceTagId entities.TagId, destTagId entities.TagId) error {
 	sql := `
-INSERT INTO file_tag (file_id, tag_id, value_id)
-SELECT file_id, ?2, value_id
+INSERT INTO file_tag (file_id, tag_id, value_id, author)
+SELECT file_id, ?2, value_id, author
 FROM file_tag
-WHERE tag_id = ?1`
+WHERE tag_id = ?1 AND NOT implied`
 
 	_, err := tx.Exec(sql, sourceTagId, destTagId)
 	if err != nil {
@@ -268,6 +442,48 @@ WHERE tag_id = ?1`
 	return nil
 }
 
+// Removes the implied file tags materialised for the specified file, or for
+// every file should the file ID be zero.
+func DeleteImpliedFileTags(tx *Tx, fileId entities.FileId) error {
+	sql := `
+DELETE FROM file_tag
+WHERE implied AND ?1 IN (0, file_id)`
+
+	_, err := tx.ExecPrepared(sql, fileId)
+	return err
+}
+
+// Materialises, as implied file tags, the tags implied by the file tags of the
+// specified file, or of every file should the file ID be zero, that are not
+// already applied. As implied tags may themselves imply further tags this is
+// repeated until no more are added, the number added being returned.
+func AddImpliedFileTags(tx *Tx, fileId entities.FileId) (uint, error) {
+	sql := `
+INSERT OR IGNORE INTO file_tag (file_id, tag_id, value_id, implied)
+SELECT DISTINCT ft.file_id, i.implied_tag_id, i.implied_value_id, 1
+FROM file_tag ft
+INNER JOIN (` + expandedImplications + `) i ON i.tag_id = ft.tag_id AND i.value_id IN (0, ft.value_id)
+WHERE ?1 IN (0, ft.file_id)`
+
+	var total uint
+	for {
+		result, err := tx.ExecPrepared(sql, fileId)
+		if err != nil {
+			return 0, err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		if rowsAffected == 0 {
+			return total, nil
+		}
+
+		total += uint(rowsAffected)
+	}
+}
+
 // helpers
 
 func readFileTags(rows *sql.Rows, fileTags entities.FileTags) (entities.FileTags, error) {
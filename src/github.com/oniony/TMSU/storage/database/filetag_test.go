@@ -0,0 +1,315 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"database/sql"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/query"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// The benchmarks share a database of a million file tags: each of the files
+// has four of the hundred tags, so each tag is applied to ten thousand files
+// on average.
+const (
+	benchmarkFileCount   = 250000
+	benchmarkTagCount    = 100
+	benchmarkTagsPerFile = 4
+)
+
+var (
+	benchmarkDatabase     *Database
+	benchmarkDatabaseDir  string
+	benchmarkDatabaseErr  error
+	benchmarkDatabaseOnce sync.Once
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+
+	if benchmarkDatabase != nil {
+		benchmarkDatabase.Close()
+	}
+	if benchmarkDatabaseDir != "" {
+		os.RemoveAll(benchmarkDatabaseDir)
+	}
+
+	os.Exit(code)
+}
+
+func TestUpgradeReplacesFileTagFileIndex(test *testing.T) {
+	dir, err := ioutil.TempDir("", "tmsu-test")
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "db")
+	if err := CreateAt(path, ""); err != nil {
+		test.Fatal(err)
+	}
+
+	// restore the file tag indexes as they were prior to the upgrade
+	db, err := sql.Open(driverName, path)
+	if err != nil {
+		test.Fatal(err)
+	}
+	for _, statement := range []string{
+		`DROP INDEX idx_file_tag_tag_file`,
+		`CREATE INDEX idx_file_tag_file_id ON file_tag(file_id)`,
+		`UPDATE version SET major = 0, minor = 8, patch = 0, revision = 21`,
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			db.Close()
+			test.Fatal(err)
+		}
+	}
+	db.Close()
+
+	database, err := OpenAt(path, 0, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer database.Close()
+
+	tx, err := database.BeginRead()
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+SELECT name
+FROM sqlite_master
+WHERE type = 'index' AND tbl_name = 'file_tag' AND name NOT LIKE 'sqlite_%'
+ORDER BY name`)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer rows.Close()
+
+	names := make([]string, 0, 10)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			test.Fatal(err)
+		}
+		names = append(names, name)
+	}
+
+	expected := []string{"idx_file_tag_implied", "idx_file_tag_inherit", "idx_file_tag_tag_file", "idx_file_tag_tag_value_file", "idx_file_tag_value_id"}
+	if !reflect.DeepEqual(names, expected) {
+		test.Fatalf("expected indexes %v but got %v", expected, names)
+	}
+}
+
+func BenchmarkFileCountForTag(benchmark *testing.B) {
+	benchmarkQuery(benchmark, "tag7", false)
+}
+
+func BenchmarkFileCountForConjunction(benchmark *testing.B) {
+	benchmarkQuery(benchmark, "tag7 and tag40", false)
+}
+
+func BenchmarkFileCountForNegation(benchmark *testing.B) {
+	benchmarkQuery(benchmark, "tag7 and not tag40", false)
+}
+
+func BenchmarkFileCountForExplicitTag(benchmark *testing.B) {
+	benchmarkQuery(benchmark, "tag7", true)
+}
+
+func BenchmarkFileCountForExplicitConjunction(benchmark *testing.B) {
+	benchmarkQuery(benchmark, "tag7 and tag40", true)
+}
+
+func BenchmarkFileTagsByFileId(benchmark *testing.B) {
+	database := openBenchmarkDatabase(benchmark)
+
+	tx, err := database.BeginRead()
+	if err != nil {
+		benchmark.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	benchmark.ResetTimer()
+
+	for index := 0; index < benchmark.N; index++ {
+		fileId := entities.FileId(index*7919%benchmarkFileCount + 1)
+
+		fileTags, err := FileTagsByFileId(tx, fileId)
+		if err != nil {
+			benchmark.Fatal(err)
+		}
+		if len(fileTags) != benchmarkTagsPerFile {
+			benchmark.Fatalf("expected %v file tags for file #%v but got %v", benchmarkTagsPerFile, fileId, len(fileTags))
+		}
+	}
+}
+
+func BenchmarkFileTagExists(benchmark *testing.B) {
+	database := openBenchmarkDatabase(benchmark)
+
+	tx, err := database.BeginRead()
+	if err != nil {
+		benchmark.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	benchmark.ResetTimer()
+
+	for index := 0; index < benchmark.N; index++ {
+		fileId := entities.FileId(index*7919%benchmarkFileCount + 1)
+
+		if _, err := FileTagExists(tx, fileId, 1, 0); err != nil {
+			benchmark.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAddFileTag(benchmark *testing.B) {
+	database := openBenchmarkDatabase(benchmark)
+
+	tx, err := database.Begin()
+	if err != nil {
+		benchmark.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	// a tag that no file yet has, so that each iteration adds a file tag
+	if _, err := tx.Exec(`INSERT INTO tag (id, name) VALUES (?1, 'new')`, benchmarkTagCount+1); err != nil {
+		benchmark.Fatal(err)
+	}
+
+	benchmark.ResetTimer()
+
+	for index := 0; index < benchmark.N; index++ {
+		fileId := entities.FileId(index%benchmarkFileCount + 1)
+		valueId := entities.ValueId(index / benchmarkFileCount)
+
+		if _, err := AddFileTag(tx, fileId, benchmarkTagCount+1, valueId); err != nil {
+			benchmark.Fatal(err)
+		}
+	}
+}
+
+// unexported
+
+func benchmarkQuery(benchmark *testing.B, text string, explicitOnly bool) {
+	database := openBenchmarkDatabase(benchmark)
+
+	expression, err := query.Parse(text)
+	if err != nil {
+		benchmark.Fatal(err)
+	}
+
+	tx, err := database.BeginRead()
+	if err != nil {
+		benchmark.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	benchmark.ResetTimer()
+
+	for index := 0; index < benchmark.N; index++ {
+		if _, err := FileCountForQuery(tx, expression, "", false, nil, nil, false, false, false, explicitOnly, false); err != nil {
+			benchmark.Fatal(err)
+		}
+	}
+}
+
+func openBenchmarkDatabase(benchmark *testing.B) *Database {
+	benchmark.StopTimer()
+	defer benchmark.StartTimer()
+
+	benchmarkDatabaseOnce.Do(func() {
+		benchmarkDatabase, benchmarkDatabaseErr = createBenchmarkDatabase()
+	})
+	if benchmarkDatabaseErr != nil {
+		benchmark.Fatal(benchmarkDatabaseErr)
+	}
+
+	return benchmarkDatabase
+}
+
+func createBenchmarkDatabase() (*Database, error) {
+	dir, err := ioutil.TempDir("", "tmsu-benchmark")
+	if err != nil {
+		return nil, err
+	}
+	benchmarkDatabaseDir = dir
+
+	path := filepath.Join(dir, "db")
+	if err := CreateAt(path, ""); err != nil {
+		return nil, err
+	}
+
+	database, err := OpenAt(path, 0, false)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := database.Begin()
+	if err != nil {
+		database.Close()
+		return nil, err
+	}
+
+	// the file tags are added as implied, and then made explicit, so that the
+	// triggers recording each change do not dominate populating the database.
+	// The step between a file's tags is odd so that its four tags are distinct.
+	for _, statement := range []string{
+		`
+WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < ?1)
+INSERT INTO tag (id, name)
+SELECT i, 'tag' || i FROM n`,
+		`
+WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < ?2)
+INSERT INTO file (id, directory, name, fingerprint, mod_time, size, is_dir)
+SELECT i, '/benchmark/' || (i / 1000), 'file' || i, printf('%064x', i), '2018-01-01 00:00:00', i, 0 FROM n`,
+		`
+WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < ?2),
+               k(j) AS (SELECT 0 UNION ALL SELECT j + 1 FROM k WHERE j < ?3 - 1)
+INSERT INTO file_tag (file_id, tag_id, value_id, implied)
+SELECT i, (i + j * (2 * (i % 50) + 1)) % ?1 + 1, 0, 1 FROM n, k`,
+		`
+UPDATE file_tag SET implied = 0`,
+	} {
+		if _, err := tx.Exec(statement, benchmarkTagCount, benchmarkFileCount, benchmarkTagsPerFile); err != nil {
+			tx.Rollback()
+			database.Close()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		database.Close()
+		return nil, err
+	}
+
+	if err := database.Compact(); err != nil {
+		database.Close()
+		return nil, err
+	}
+
+	return database, nil
+}
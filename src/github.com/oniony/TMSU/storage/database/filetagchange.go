@@ -0,0 +1,101 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"database/sql"
+	"github.com/oniony/TMSU/entities"
+	"time"
+)
+
+// Retrieves the most recent change to each file tag.
+func FileTagChanges(tx *Tx) (entities.FileTagChanges, error) {
+	sql := `
+SELECT directory, name, tag, value, removed, changed_at
+FROM file_tag_change
+ORDER BY directory, name, tag, value`
+
+	rows, err := tx.Query(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readFileTagChanges(rows, make(entities.FileTagChanges, 0, 10))
+}
+
+// Retrieves the changes made at or after the specified time, oldest first.
+func FileTagChangesSince(tx *Tx, since time.Time) (entities.FileTagChanges, error) {
+	sql := `
+SELECT directory, name, tag, value, removed, changed_at
+FROM file_tag_change
+WHERE changed_at >= ?
+ORDER BY changed_at, directory, name, tag, value`
+
+	// formatted as by the triggers so the text comparison is chronological
+	rows, err := tx.Query(sql, since.UTC().Format(changeTimeFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readFileTagChanges(rows, make(entities.FileTagChanges, 0, 10))
+}
+
+// Records a change to a file tag, replacing that previously recorded.
+func UpdateFileTagChange(tx *Tx, change entities.FileTagChange) error {
+	sql := `
+INSERT OR REPLACE INTO file_tag_change (directory, name, tag, value, removed, changed_at)
+VALUES (?, ?, ?, ?, ?, ?)`
+
+	result, err := tx.Exec(sql, change.Directory, change.Name, change.Tag, change.Value, change.Removed, change.ChangedAt.UTC())
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected != 1 {
+		panic("expected exactly one row to be affected.")
+	}
+
+	return nil
+}
+
+// unexported
+
+const changeTimeFormat = "2006-01-02 15:04:05.000"
+
+func readFileTagChanges(rows *sql.Rows, changes entities.FileTagChanges) (entities.FileTagChanges, error) {
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var directory, name, tag, value string
+		var removed bool
+		var changedAt time.Time
+		if err := rows.Scan(&directory, &name, &tag, &value, &removed, &changedAt); err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, &entities.FileTagChange{directory, name, tag, value, removed, changedAt})
+	}
+
+	return changes, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"github.com/oniony/TMSU/entities"
+	"time"
+)
+
+// Retrieves the expiries of the file tags, soonest first. Expiries left behind
+// by file tags that have since been moved to another tag or value are ignored.
+func FileTagExpiries(tx *Tx) (entities.FileTagExpiries, error) {
+	sql := `
+SELECT e.file_id, e.tag_id, e.value_id, e.expires_at
+FROM file_tag_expiry e
+INNER JOIN file_tag ft ON ft.file_id = e.file_id AND ft.tag_id = e.tag_id AND ft.value_id = e.value_id AND NOT ft.implied
+ORDER BY e.expires_at, e.file_id, e.tag_id, e.value_id`
+
+	rows, err := tx.Query(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	expiries := make(entities.FileTagExpiries, 0, 10)
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var fileId entities.FileId
+		var tagId entities.TagId
+		var valueId entities.ValueId
+		var expiresAt time.Time
+		if err := rows.Scan(&fileId, &tagId, &valueId, &expiresAt); err != nil {
+			return nil, err
+		}
+
+		expiries = append(expiries, &entities.FileTagExpiry{fileId, tagId, valueId, expiresAt})
+	}
+
+	return expiries, nil
+}
+
+// Sets when an explicitly applied file tag expires, replacing any previous
+// expiry. Returns false if the file tag is not explicitly applied.
+func SetFileTagExpiry(tx *Tx, fileId entities.FileId, tagId entities.TagId, valueId entities.ValueId, expiresAt time.Time) (bool, error) {
+	sql := `
+INSERT OR REPLACE INTO file_tag_expiry (file_id, tag_id, value_id, expires_at)
+SELECT file_id, tag_id, value_id, ?4
+FROM file_tag
+WHERE file_id = ?1 AND tag_id = ?2 AND value_id = ?3 AND NOT implied`
+
+	result, err := tx.Exec(sql, fileId, tagId, valueId, expiresAt)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
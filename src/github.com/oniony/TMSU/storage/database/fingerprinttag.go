@@ -0,0 +1,142 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"database/sql"
+	"github.com/oniony/TMSU/common/fingerprint"
+	"github.com/oniony/TMSU/entities"
+)
+
+// Retrieves the fingerprint taggings, ordered by fingerprint.
+func FingerprintTags(tx *Tx) (entities.FingerprintTags, error) {
+	sql := `
+SELECT algorithm, fingerprint, tag_id, value_id
+FROM fingerprint_tag
+ORDER BY fingerprint, tag_id, value_id`
+
+	rows, err := tx.Query(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readFingerprintTags(rows, make(entities.FingerprintTags, 0, 10))
+}
+
+// Adds a tagging for the file with the specified fingerprint.
+func AddFingerprintTag(tx *Tx, algorithm string, fingerprint fingerprint.Fingerprint, tagId entities.TagId, valueId entities.ValueId) (*entities.FingerprintTag, error) {
+	sql := `
+INSERT OR IGNORE INTO fingerprint_tag (algorithm, fingerprint, tag_id, value_id)
+VALUES (?1, ?2, ?3, ?4)`
+
+	if _, err := tx.Exec(sql, algorithm, string(fingerprint), tagId, valueId); err != nil {
+		return nil, err
+	}
+
+	return &entities.FingerprintTag{algorithm, fingerprint, tagId, valueId}, nil
+}
+
+// Applies the taggings for the specified fingerprint to a file, removing them
+// as fingerprint taggings.
+func AttachFingerprintTags(tx *Tx, fingerprint fingerprint.Fingerprint, fileId entities.FileId) (uint, error) {
+	sql := `
+INSERT OR IGNORE INTO file_tag (file_id, tag_id, value_id, author)
+SELECT ?1, tag_id, value_id, ?2
+FROM fingerprint_tag
+WHERE fingerprint = ?3`
+
+	if _, err := tx.Exec(sql, fileId, tx.database.user, string(fingerprint)); err != nil {
+		return 0, err
+	}
+
+	sql = `
+DELETE FROM fingerprint_tag
+WHERE fingerprint = ?`
+
+	result, err := tx.Exec(sql, string(fingerprint))
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return uint(rowsAffected), nil
+}
+
+// Removes a fingerprint tagging.
+func DeleteFingerprintTag(tx *Tx, fingerprint fingerprint.Fingerprint, tagId entities.TagId, valueId entities.ValueId) (bool, error) {
+	sql := `
+DELETE FROM fingerprint_tag
+WHERE fingerprint = ?1 AND tag_id = ?2 AND value_id = ?3`
+
+	result, err := tx.Exec(sql, string(fingerprint), tagId, valueId)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// Removes the fingerprint taggings for the specified tag.
+func DeleteFingerprintTagsByTagId(tx *Tx, tagId entities.TagId) error {
+	sql := `
+DELETE FROM fingerprint_tag
+WHERE tag_id = ?`
+
+	_, err := tx.Exec(sql, tagId)
+	return err
+}
+
+// Removes the fingerprint taggings for the specified value.
+func DeleteFingerprintTagsByValueId(tx *Tx, valueId entities.ValueId) error {
+	sql := `
+DELETE FROM fingerprint_tag
+WHERE value_id = ?`
+
+	_, err := tx.Exec(sql, valueId)
+	return err
+}
+
+// unexported
+
+func readFingerprintTags(rows *sql.Rows, fingerprintTags entities.FingerprintTags) (entities.FingerprintTags, error) {
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var algorithm, fingerprintText string
+		var tagId entities.TagId
+		var valueId entities.ValueId
+		err := rows.Scan(&algorithm, &fingerprintText, &tagId, &valueId)
+		if err != nil {
+			return nil, err
+		}
+
+		fingerprintTags = append(fingerprintTags, &entities.FingerprintTag{algorithm, fingerprint.Fingerprint(fingerprintText), tagId, valueId})
+	}
+
+	return fingerprintTags, nil
+}
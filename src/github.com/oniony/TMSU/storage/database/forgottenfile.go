@@ -0,0 +1,233 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"database/sql"
+	"github.com/oniony/TMSU/common/fingerprint"
+	"github.com/oniony/TMSU/entities"
+	_path "path"
+	"time"
+)
+
+// Retrieves the forgotten files, most recently forgotten first.
+func ForgottenFiles(tx *Tx) (entities.ForgottenFiles, error) {
+	sql := `
+SELECT id, directory, name, fingerprint, mod_time, size, is_dir, forgotten_at
+FROM forgotten_file
+ORDER BY forgotten_at DESC, id DESC`
+
+	rows, err := tx.Query(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readForgottenFiles(rows, make(entities.ForgottenFiles, 0, 10))
+}
+
+// Retrieves the files forgotten at the specified path, most recently forgotten
+// first.
+func ForgottenFilesByPath(tx *Tx, path string) (entities.ForgottenFiles, error) {
+	sql := `
+SELECT id, directory, name, fingerprint, mod_time, size, is_dir, forgotten_at
+FROM forgotten_file
+WHERE directory = ? COLLATE ` + pathCollation + ` AND name = ? COLLATE ` + pathCollation + `
+ORDER BY forgotten_at DESC, id DESC`
+
+	rows, err := tx.Query(sql, _path.Dir(path), _path.Base(path))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readForgottenFiles(rows, make(entities.ForgottenFiles, 0, 1))
+}
+
+// Moves a file and its tags to the forgotten file tables, returning the
+// forgotten file. The file itself is left for the caller to remove.
+func ForgetFile(tx *Tx, fileId entities.FileId, forgottenAt time.Time) (*entities.ForgottenFile, error) {
+	sql := `
+INSERT INTO forgotten_file (directory, name, fingerprint, mod_time, size, is_dir, forgotten_at)
+SELECT directory, name, fingerprint, mod_time, size, is_dir, ?
+FROM file
+WHERE id = ?`
+
+	result, err := tx.Exec(sql, forgottenAt, fileId)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, NoSuchFileError{fileId}
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	sql = `
+INSERT INTO forgotten_file_tag (forgotten_file_id, tag_id, value_id)
+SELECT ?, tag_id, value_id
+FROM file_tag
+WHERE file_id = ? AND NOT implied`
+
+	if _, err := tx.Exec(sql, id, fileId); err != nil {
+		return nil, err
+	}
+
+	return ForgottenFile(tx, entities.ForgottenFileId(id))
+}
+
+// Retrieves the specified forgotten file.
+func ForgottenFile(tx *Tx, id entities.ForgottenFileId) (*entities.ForgottenFile, error) {
+	sql := `
+SELECT id, directory, name, fingerprint, mod_time, size, is_dir, forgotten_at
+FROM forgotten_file
+WHERE id = ?`
+
+	rows, err := tx.Query(sql, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readForgottenFile(rows)
+}
+
+// Applies the tags of the forgotten file to the specified file.
+func RestoreForgottenFileTags(tx *Tx, id entities.ForgottenFileId, fileId entities.FileId) error {
+	sql := `
+INSERT OR IGNORE INTO file_tag (file_id, tag_id, value_id, author)
+SELECT ?, tag_id, value_id, ?
+FROM forgotten_file_tag
+WHERE forgotten_file_id = ?`
+
+	_, err := tx.Exec(sql, fileId, tx.database.user, id)
+	return err
+}
+
+// Removes a forgotten file and its tags.
+func DeleteForgottenFile(tx *Tx, id entities.ForgottenFileId) error {
+	sql := `
+DELETE FROM forgotten_file_tag
+WHERE forgotten_file_id = ?`
+
+	if _, err := tx.Exec(sql, id); err != nil {
+		return err
+	}
+
+	sql = `
+DELETE FROM forgotten_file
+WHERE id = ?`
+
+	_, err := tx.Exec(sql, id)
+	return err
+}
+
+// Removes the files forgotten before the specified time, returning the number
+// removed.
+func DeleteForgottenFilesBefore(tx *Tx, before time.Time) (uint, error) {
+	sql := `
+DELETE FROM forgotten_file_tag
+WHERE forgotten_file_id IN (SELECT id
+                            FROM forgotten_file
+                            WHERE forgotten_at < ?)`
+
+	if _, err := tx.Exec(sql, before); err != nil {
+		return 0, err
+	}
+
+	sql = `
+DELETE FROM forgotten_file
+WHERE forgotten_at < ?`
+
+	result, err := tx.Exec(sql, before)
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return uint(rowsAffected), nil
+}
+
+// Removes the forgotten file tags for the specified tag.
+func DeleteForgottenFileTagsByTagId(tx *Tx, tagId entities.TagId) error {
+	sql := `
+DELETE FROM forgotten_file_tag
+WHERE tag_id = ?`
+
+	_, err := tx.Exec(sql, tagId)
+	return err
+}
+
+// Removes the forgotten file tags for the specified value.
+func DeleteForgottenFileTagsByValueId(tx *Tx, valueId entities.ValueId) error {
+	sql := `
+DELETE FROM forgotten_file_tag
+WHERE value_id = ?`
+
+	_, err := tx.Exec(sql, valueId)
+	return err
+}
+
+// unexported
+
+func readForgottenFile(rows *sql.Rows) (*entities.ForgottenFile, error) {
+	if !rows.Next() {
+		return nil, nil
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	var id entities.ForgottenFileId
+	var directory, name, fp string
+	var modTime, forgottenAt time.Time
+	var size int64
+	var isDir bool
+	err := rows.Scan(&id, &directory, &name, &fp, &modTime, &size, &isDir, &forgottenAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entities.ForgottenFile{id, directory, name, fingerprint.Fingerprint(fp), modTime, size, isDir, forgottenAt}, nil
+}
+
+func readForgottenFiles(rows *sql.Rows, files entities.ForgottenFiles) (entities.ForgottenFiles, error) {
+	for {
+		file, err := readForgottenFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		if file == nil {
+			break
+		}
+
+		files = append(files, file)
+	}
+
+	return files, nil
+}
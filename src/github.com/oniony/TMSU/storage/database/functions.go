@@ -0,0 +1,47 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"regexp"
+	"sync"
+)
+
+// unexported
+
+var regexpCache = struct {
+	sync.Mutex
+	patterns map[string]*regexp.Regexp
+}{patterns: make(map[string]*regexp.Regexp)}
+
+// Implements the REGEXP operator, which Sqlite3 reserves but does not define.
+func matchRegexp(pattern, text string) (bool, error) {
+	regexpCache.Lock()
+	defer regexpCache.Unlock()
+
+	compiled, found := regexpCache.patterns[pattern]
+	if !found {
+		var err error
+		compiled, err = regexp.Compile(pattern)
+		if err != nil {
+			return false, err
+		}
+
+		regexpCache.patterns[pattern] = compiled
+	}
+
+	return compiled.MatchString(text), nil
+}
@@ -25,6 +25,7 @@ func Implications(tx *Tx) (entities.Implications, error) {
 	sql := `
 SELECT tag.id, tag.name,
        value.id, value.name,
+       implication.pattern,
 	   implied_tag.id, implied_tag.name,
 	   implied_value.id, implied_value.name
 FROM implication
@@ -32,7 +33,7 @@ INNER JOIN tag tag ON implication.tag_id = tag.id
 LEFT OUTER JOIN value value ON implication.value_id = value.id
 INNER JOIN tag implied_tag ON implication.implied_tag_id = implied_tag.id
 LEFT OUTER JOIN value implied_value ON implication.implied_value_id = implied_value.id
-ORDER BY tag.name, value.name, implied_tag.name, implied_value.name`
+ORDER BY tag.name, value.name, implication.pattern, implied_tag.name, implied_value.name`
 
 	rows, err := tx.Query(sql)
 	if err != nil {
@@ -55,6 +56,7 @@ func ImplicationsFor(tx *Tx, pairs entities.TagIdValueIdPairs) (entities.Implica
 	builder.AppendSql(`
 SELECT tag.id, tag.name,
        value.id, value.name,
+       implication.pattern,
        implied_tag.id, implied_tag.name,
        implied_value.id, implied_value.name
 FROM implication
@@ -71,12 +73,14 @@ WHERE `)
 
 		builder.AppendSql("(implication.tag_id = ")
 		builder.AppendParam(pair.TagId)
-		builder.AppendSql(" AND implication.value_id IN (0, ")
+		builder.AppendSql(" AND ((implication.pattern = '' AND implication.value_id IN (0, ")
 		builder.AppendParam(pair.ValueId)
-		builder.AppendSql("))")
+		builder.AppendSql(")) OR (implication.pattern != '' AND (SELECT name FROM value WHERE id = ")
+		builder.AppendParam(pair.ValueId)
+		builder.AppendSql(") GLOB implication.pattern)))")
 	}
 
-	builder.AppendSql(`ORDER BY tag.name, value.name, implied_tag.name, implied_value.name`)
+	builder.AppendSql(`ORDER BY tag.name, value.name, implication.pattern, implied_tag.name, implied_value.name`)
 
 	rows, err := tx.Query(builder.Sql(), builder.Params()...)
 	if err != nil {
@@ -96,6 +100,7 @@ func ImplyingImplications(tx *Tx, pairs entities.TagIdValueIdPairs) (entities.Im
 	sql := `
 SELECT tag.id, tag.name,
        value.id, value.name,
+       implication.pattern,
        implying_tag.id, implying_tag.name,
        implying_value.id, implying_value.name
 FROM implication
@@ -148,12 +153,27 @@ VALUES (?1, ?2, ?3, ?4)`
 	return nil
 }
 
+// Adds an implication that applies to values of the tag matching the glob pattern
+func AddPatternImplication(tx *Tx, tagId entities.TagId, pattern string, impliedPair entities.TagIdValueIdPair) error {
+	sql := `
+INSERT OR IGNORE INTO implication (tag_id, value_id, pattern, implied_tag_id, implied_value_id)
+VALUES (?1, 0, ?2, ?3, ?4)`
+
+	_, err := tx.Exec(sql, tagId, pattern, impliedPair.TagId, impliedPair.ValueId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // Deletes the specified implication
 func DeleteImplication(tx *Tx, pair, impliedPair entities.TagIdValueIdPair) error {
 	sql := `
 DELETE FROM implication
 WHERE tag_id = ?1 AND
       value_id = ?2 AND
+      pattern = '' AND
       implied_tag_id = ?3 AND
       implied_value_id = ?4`
 
@@ -176,6 +196,34 @@ WHERE tag_id = ?1 AND
 	return nil
 }
 
+// Deletes the specified pattern implication
+func DeletePatternImplication(tx *Tx, tagId entities.TagId, pattern string, impliedPair entities.TagIdValueIdPair) error {
+	sql := `
+DELETE FROM implication
+WHERE tag_id = ?1 AND
+      pattern = ?2 AND
+      implied_tag_id = ?3 AND
+      implied_value_id = ?4`
+
+	result, err := tx.Exec(sql, tagId, pattern, impliedPair.TagId, impliedPair.ValueId)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return NoSuchImplicationError{entities.TagIdValueIdPair{tagId, 0}, impliedPair}
+	}
+	if rowsAffected > 1 {
+		panic("expected exactly one row to be affected")
+	}
+
+	return nil
+}
+
 // Deletes implications for the specified tag id
 func DeleteImplicationsByTagId(tx *Tx, tagId entities.TagId) error {
 	sql := `
@@ -218,6 +266,7 @@ func readImplication(rows *sql.Rows) (*entities.Implication, error) {
 	var implyingTagName string
 	var implyingValueId *entities.ValueId
 	var implyingValueName *string
+	var implyingPattern string
 	var impliedTagId entities.TagId
 	var impliedTagName string
 	var impliedValueId *entities.ValueId
@@ -226,6 +275,7 @@ func readImplication(rows *sql.Rows) (*entities.Implication, error) {
 		&implyingTagName,
 		&implyingValueId,
 		&implyingValueName,
+		&implyingPattern,
 		&impliedTagId,
 		&impliedTagName,
 		&impliedValueId,
@@ -246,6 +296,7 @@ func readImplication(rows *sql.Rows) (*entities.Implication, error) {
 
 	return &entities.Implication{entities.Tag{implyingTagId, implyingTagName},
 		implyingValue,
+		implyingPattern,
 		entities.Tag{impliedTagId, impliedTagName},
 		impliedValue}, nil
 }
@@ -0,0 +1,168 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/entities"
+)
+
+// Rows that refer to rows that do not exist.
+type Orphans struct {
+	Description string
+	Count       uint
+}
+
+// Runs SQLite's own check of the database file, returning the problems found.
+func IntegrityCheck(tx *Tx) ([]string, error) {
+	rows, err := tx.Query("PRAGMA integrity_check")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	problems := make([]string, 0, 10)
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var message string
+		if err := rows.Scan(&message); err != nil {
+			return nil, err
+		}
+
+		if message != "ok" {
+			problems = append(problems, message)
+		}
+	}
+
+	return problems, nil
+}
+
+// Counts the rows that refer to missing files, tags or values.
+func OrphanCounts(tx *Tx) ([]Orphans, error) {
+	return orphanCounts(tx, orphanChecks)
+}
+
+// Deletes the rows that refer to missing files, tags or values.
+func DeleteOrphans(tx *Tx) error {
+	return deleteOrphans(tx, orphanChecks)
+}
+
+// Counts the implications that refer to missing tags or values.
+func ImplicationOrphanCounts(tx *Tx) ([]Orphans, error) {
+	return orphanCounts(tx, orphanChecksFor("implication"))
+}
+
+// Deletes the implications that refer to missing tags or values.
+func DeleteImplicationOrphans(tx *Tx) error {
+	return deleteOrphans(tx, orphanChecksFor("implication"))
+}
+
+// Retrieves the tags that have the same name as a tag created before them.
+func DuplicateTags(tx *Tx) (entities.Tags, error) {
+	sql := `
+SELECT id, name
+FROM tag
+WHERE id != (SELECT min(id)
+             FROM tag t
+             WHERE t.name = tag.name)
+ORDER BY name, id`
+
+	rows, err := tx.Query(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readTags(rows, make(entities.Tags, 0, 10))
+}
+
+// unexported
+
+type orphanCheck struct {
+	table       string
+	description string
+	condition   string
+}
+
+var orphanChecks = []orphanCheck{
+	{"file_tag", "file-tags of missing files", "file_id NOT IN (SELECT id FROM file)"},
+	{"file_tag", "file-tags of missing tags", "tag_id NOT IN (SELECT id FROM tag)"},
+	{"file_tag", "file-tags with missing values", "value_id != 0 AND value_id NOT IN (SELECT id FROM value)"},
+	{"implication", "implications of missing tags", "tag_id NOT IN (SELECT id FROM tag) OR implied_tag_id NOT IN (SELECT id FROM tag)"},
+	{"implication", "implications with missing values", "(value_id != 0 AND value_id NOT IN (SELECT id FROM value)) OR (implied_value_id != 0 AND implied_value_id NOT IN (SELECT id FROM value))"},
+	{"tag_definition", "definitions of missing tags", "tag_id NOT IN (SELECT id FROM tag)"},
+	{"tag_enum_value", "enumeration values of missing tags", "tag_id NOT IN (SELECT id FROM tag)"},
+	{"vocabulary_term", "vocabulary terms of missing tags", "tag_id NOT IN (SELECT id FROM tag)"},
+	{"tag_info", "descriptions of missing tags", "tag_id NOT IN (SELECT id FROM tag)"},
+	{"file_perceptual_hash", "perceptual hashes of missing files", "file_id NOT IN (SELECT id FROM file)"},
+	{"file_verification", "verifications of missing files", "file_id NOT IN (SELECT id FROM file)"},
+}
+
+func orphanChecksFor(table string) []orphanCheck {
+	checks := make([]orphanCheck, 0, 2)
+	for _, check := range orphanChecks {
+		if check.table == table {
+			checks = append(checks, check)
+		}
+	}
+
+	return checks
+}
+
+func orphanCounts(tx *Tx, checks []orphanCheck) ([]Orphans, error) {
+	orphans := make([]Orphans, 0, len(checks))
+
+	for _, check := range checks {
+		sql := fmt.Sprintf(`
+SELECT count(1)
+FROM %v
+WHERE %v`, check.table, check.condition)
+
+		rows, err := tx.Query(sql)
+		if err != nil {
+			return nil, err
+		}
+
+		count, err := readCount(rows)
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if count > 0 {
+			orphans = append(orphans, Orphans{check.description, count})
+		}
+	}
+
+	return orphans, nil
+}
+
+func deleteOrphans(tx *Tx, checks []orphanCheck) error {
+	for _, check := range checks {
+		sql := fmt.Sprintf(`
+DELETE FROM %v
+WHERE %v`, check.table, check.condition)
+
+		if _, err := tx.Exec(sql); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,815 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// the name under which the driver for JSON databases is registered
+const jsonDriverName = "tmsu_json"
+
+func init() {
+	sql.Register(jsonDriverName, jsonDriver{})
+	RegisterBackend(jsonBackend{})
+}
+
+// unexported
+
+// jsonBackend keeps a Sqlite database in a plain JSON file, which can be read,
+// compared and merged with everyday tools. It is Sqlite nonetheless, so needs
+// the Sqlite driver: each connection works upon an in-memory Sqlite copy of
+// the whole database, which is reloaded when a transaction begins if the file
+// has changed and written back in full when a transaction that changed it is
+// committed, so it suits only small databases.
+type jsonBackend struct{}
+
+func (jsonBackend) Name() string {
+	return "json"
+}
+
+func (jsonBackend) Open(dataSourceName string) (*sql.DB, error) {
+	return sql.Open(jsonDriverName, dataSourceName)
+}
+
+// the start of every JSON database, once whitespace is removed
+var jsonHeader = []byte(`{"format":"` + jsonFormat + `"`)
+
+const jsonFormat = "tmsu-json"
+
+func (jsonBackend) Recognises(header []byte) bool {
+	compact := bytes.Join(bytes.Fields(header), nil)
+	return bytes.HasPrefix(compact, jsonHeader)
+}
+
+// The connections' copies of the database cannot report another's changes so
+// the database is taken to have changed whenever the file has been replaced.
+func (jsonBackend) DataVersion(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return info.ModTime().UnixNano(), nil
+}
+
+// jsonDocument is the content of a JSON database: the statements that create
+// its schema and the rows of each of its tables.
+type jsonDocument struct {
+	Format string            `json:"format"`
+	Schema []jsonSchemaEntry `json:"schema"`
+	Tables []jsonTable       `json:"tables"`
+}
+
+type jsonSchemaEntry struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	Sql  string `json:"sql"`
+}
+
+type jsonTable struct {
+	Name    string        `json:"name"`
+	Columns []string      `json:"columns"`
+	Rows    [][]jsonValue `json:"rows"`
+}
+
+// jsonValue is a Sqlite value: null, an integer, a real, which is always
+// written with a decimal point or exponent, text or a blob, which is written
+// as an object holding its bytes in base 64.
+type jsonValue struct {
+	value driver.Value
+}
+
+func (value jsonValue) MarshalJSON() ([]byte, error) {
+	switch v := value.value.(type) {
+	case nil:
+		return []byte("null"), nil
+	case int64:
+		return []byte(strconv.FormatInt(v, 10)), nil
+	case float64:
+		text := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(text, ".e") {
+			text += ".0"
+		}
+		return []byte(text), nil
+	case string:
+		return json.Marshal(v)
+	case []byte:
+		return json.Marshal(map[string]string{"blob": base64.StdEncoding.EncodeToString(v)})
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", v)
+	}
+}
+
+func (value *jsonValue) UnmarshalJSON(data []byte) error {
+	text := string(bytes.TrimSpace(data))
+
+	switch {
+	case text == "null":
+		value.value = nil
+	case strings.HasPrefix(text, `"`):
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		value.value = s
+	case strings.HasPrefix(text, "{"):
+		var blob struct {
+			Blob string `json:"blob"`
+		}
+		if err := json.Unmarshal(data, &blob); err != nil {
+			return err
+		}
+		decoded, err := base64.StdEncoding.DecodeString(blob.Blob)
+		if err != nil {
+			return err
+		}
+		value.value = decoded
+	case strings.ContainsAny(text, ".eE"):
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return err
+		}
+		value.value = f
+	default:
+		i, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return err
+		}
+		value.value = i
+	}
+
+	return nil
+}
+
+// jsonDriver opens connections to JSON databases. The data source name is the
+// path to the file or, as for Sqlite, a 'file:' URI whose 'mode=ro' parameter
+// opens the database read-only.
+type jsonDriver struct{}
+
+func (jsonDriver) Open(dataSourceName string) (driver.Conn, error) {
+	path, readOnly := dataSourceName, false
+
+	if strings.HasPrefix(dataSourceName, "file:") {
+		uri := strings.TrimPrefix(dataSourceName, "file:")
+		query := ""
+		if index := strings.IndexByte(uri, '?'); index != -1 {
+			uri, query = uri[:index], uri[index+1:]
+		}
+
+		var err error
+		path, err = url.PathUnescape(uri)
+		if err != nil {
+			return nil, err
+		}
+
+		parameters, err := url.ParseQuery(query)
+		if err != nil {
+			return nil, err
+		}
+		readOnly = parameters.Get("mode") == "ro"
+	}
+
+	conn := &jsonConn{path: path, readOnly: readOnly}
+	if err := conn.refresh(); err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+var memoryDriver driver.Driver
+var memoryDriverErr error
+var memoryDriverOnce sync.Once
+
+// Retrieves the Sqlite driver, with TMSU's SQL functions, that holds the
+// connections' in-memory copies of the database.
+func sqliteMemoryDriver() (driver.Driver, error) {
+	memoryDriverOnce.Do(func() {
+		db, err := sql.Open(driverName, ":memory:")
+		if err != nil {
+			memoryDriverErr = fmt.Errorf("could not load Sqlite driver: %v", err)
+			return
+		}
+
+		memoryDriver = db.Driver()
+		db.Close()
+	})
+
+	return memoryDriver, memoryDriverErr
+}
+
+// jsonConn is a connection to a JSON database, which works upon an in-memory
+// copy of it.
+type jsonConn struct {
+	path     string
+	readOnly bool
+
+	// the in-memory copy of the database and the file it was loaded from
+	conn   driver.Conn
+	loaded os.FileInfo
+
+	// whether a transaction is under way, during which the copy is neither
+	// reloaded nor saved
+	inTx bool
+}
+
+func (conn *jsonConn) Prepare(query string) (driver.Stmt, error) {
+	return conn.conn.Prepare(query)
+}
+
+func (conn *jsonConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := conn.conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+
+	return conn.conn.Prepare(query)
+}
+
+func (conn *jsonConn) Close() error {
+	return conn.conn.Close()
+}
+
+func (conn *jsonConn) Begin() (driver.Tx, error) {
+	return conn.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (conn *jsonConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := conn.refresh(); err != nil {
+		return nil, err
+	}
+
+	from, err := conn.changeCount()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := beginMemoryTx(ctx, conn.conn, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.inTx = true
+
+	return &jsonTx{conn, tx, from}, nil
+}
+
+func (conn *jsonConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := conn.conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	if conn.inTx {
+		return execer.ExecContext(ctx, query, args)
+	}
+
+	// a statement outside a transaction commits itself
+	if err := conn.refresh(); err != nil {
+		return nil, err
+	}
+
+	from, err := conn.changeCount()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := execer.ExecContext(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.saveChanges(from); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (conn *jsonConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := conn.conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	if !conn.inTx {
+		if err := conn.refresh(); err != nil {
+			return nil, err
+		}
+	}
+
+	return queryer.QueryContext(ctx, query, args)
+}
+
+func (conn *jsonConn) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := conn.conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+
+	return driver.ErrSkip
+}
+
+func (conn *jsonConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := conn.conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+
+	return nil
+}
+
+// jsonTx is a transaction upon the in-memory copy of a JSON database, which
+// is saved upon commit if the transaction changed it.
+type jsonTx struct {
+	conn *jsonConn
+	tx   driver.Tx
+	from [2]int64
+}
+
+func (tx *jsonTx) Commit() error {
+	tx.conn.inTx = false
+
+	if err := tx.tx.Commit(); err != nil {
+		return err
+	}
+
+	return tx.conn.saveChanges(tx.from)
+}
+
+func (tx *jsonTx) Rollback() error {
+	tx.conn.inTx = false
+
+	return tx.tx.Rollback()
+}
+
+// Reloads the in-memory copy of the database if the file has been replaced
+// since it was loaded.
+func (conn *jsonConn) refresh() error {
+	info, err := os.Stat(conn.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if conn.conn != nil && sameJsonFile(conn.loaded, info) {
+		return nil
+	}
+
+	var document jsonDocument
+	if info != nil && info.Size() > 0 {
+		data, err := os.ReadFile(conn.path)
+		if err != nil {
+			return err
+		}
+
+		if err := json.Unmarshal(data, &document); err != nil {
+			return fmt.Errorf("could not parse '%v': %v", conn.path, err)
+		}
+		if document.Format != jsonFormat {
+			return fmt.Errorf("'%v' is not a TMSU JSON database", conn.path)
+		}
+	}
+
+	memory, err := sqliteMemoryDriver()
+	if err != nil {
+		return err
+	}
+
+	memoryCopy, err := memory.Open(":memory:")
+	if err != nil {
+		return err
+	}
+
+	if err := loadJsonDocument(memoryCopy, document); err != nil {
+		memoryCopy.Close()
+		return fmt.Errorf("could not load '%v': %v", conn.path, err)
+	}
+
+	if conn.conn != nil {
+		conn.conn.Close()
+	}
+	conn.conn = memoryCopy
+	conn.loaded = info
+
+	return nil
+}
+
+// Writes the in-memory copy of the database back to the file if it has changed
+// since the change count was taken.
+func (conn *jsonConn) saveChanges(from [2]int64) error {
+	if conn.readOnly {
+		return nil
+	}
+
+	to, err := conn.changeCount()
+	if err != nil {
+		return err
+	}
+	if to == from {
+		return nil
+	}
+
+	document, err := exportJsonDocument(conn.conn)
+	if err != nil {
+		return err
+	}
+
+	if err := writeJsonFile(conn.path, document); err != nil {
+		// the copy now differs from the file so is reloaded before next use
+		conn.loaded = nil
+		return err
+	}
+
+	info, err := os.Stat(conn.path)
+	if err != nil {
+		conn.loaded = nil
+		return err
+	}
+	conn.loaded = info
+
+	return nil
+}
+
+// Retrieves the number of rows changed through the connection along with the
+// schema version, which together identify whether the database has changed.
+func (conn *jsonConn) changeCount() ([2]int64, error) {
+	rows, err := queryMemory(conn.conn, "SELECT total_changes(), schema_version FROM pragma_schema_version")
+	if err != nil {
+		return [2]int64{}, err
+	}
+	if len(rows) != 1 {
+		return [2]int64{}, fmt.Errorf("could not get change count")
+	}
+
+	changes, _ := rows[0][0].(int64)
+	schemaVersion, _ := rows[0][1].(int64)
+
+	return [2]int64{changes, schemaVersion}, nil
+}
+
+// Whether the file has not been replaced since it was loaded.
+func sameJsonFile(loaded, current os.FileInfo) bool {
+	if loaded == nil || current == nil {
+		return loaded == nil && current == nil
+	}
+
+	return os.SameFile(loaded, current) && loaded.ModTime().Equal(current.ModTime()) && loaded.Size() == current.Size()
+}
+
+// Creates the schema and rows of the document in an empty Sqlite database. The
+// tables are filled before the indexes and triggers are created so that the
+// triggers do not fire for the rows being loaded.
+func loadJsonDocument(conn driver.Conn, document jsonDocument) error {
+	tx, err := beginMemoryTx(context.Background(), conn, driver.TxOptions{})
+	if err != nil {
+		return err
+	}
+
+	if err := loadJsonSchema(conn, document, true); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, table := range document.Tables {
+		if err := loadJsonTable(conn, table); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := loadJsonSchema(conn, document, false); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func loadJsonSchema(conn driver.Conn, document jsonDocument, tables bool) error {
+	for _, entry := range document.Schema {
+		if (entry.Type == "table") != tables {
+			continue
+		}
+
+		if err := execMemory(conn, entry.Sql); err != nil {
+			return fmt.Errorf("could not create %v '%v': %v", entry.Type, entry.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func loadJsonTable(conn driver.Conn, table jsonTable) error {
+	names := make([]string, len(table.Columns))
+	placeholders := make([]string, len(table.Columns))
+	for index, column := range table.Columns {
+		names[index] = quoteIdentifier(column)
+		placeholders[index] = "?"
+	}
+
+	sql := "INSERT INTO " + quoteIdentifier(table.Name) + " (" + strings.Join(names, ", ") + ") VALUES (" + strings.Join(placeholders, ", ") + ")"
+
+	for _, row := range table.Rows {
+		if len(row) != len(table.Columns) {
+			return fmt.Errorf("table '%v' has a row of %v values for %v columns", table.Name, len(row), len(table.Columns))
+		}
+
+		args := make([]driver.Value, len(row))
+		for index, value := range row {
+			args[index] = value.value
+		}
+
+		if err := execMemory(conn, sql, args...); err != nil {
+			return fmt.Errorf("could not load table '%v': %v", table.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Reads the schema and rows of a Sqlite database. Sqlite's own tables, other
+// than that of the autoincrement sequences, and the tables that hold the
+// content of virtual tables are left out as Sqlite recreates them.
+func exportJsonDocument(conn driver.Conn) (jsonDocument, error) {
+	document := jsonDocument{Format: jsonFormat, Schema: []jsonSchemaEntry{}, Tables: []jsonTable{}}
+
+	tableTypes, err := queryMemory(conn, "SELECT name, type, wr FROM pragma_table_list WHERE schema = 'main'")
+	if err != nil {
+		return document, err
+	}
+
+	types := make(map[string]string, len(tableTypes))
+	withoutRowid := make(map[string]bool, len(tableTypes))
+	for _, row := range tableTypes {
+		name, _ := row[0].(string)
+		types[name], _ = row[1].(string)
+		withoutRowid[name] = row[2] == int64(1)
+	}
+
+	entries, err := queryMemory(conn, "SELECT type, name, sql FROM sqlite_master WHERE sql IS NOT NULL ORDER BY rowid")
+	if err != nil {
+		return document, err
+	}
+
+	tableNames := make([]string, 0, len(entries))
+	for _, row := range entries {
+		entryType, _ := row[0].(string)
+		name, _ := row[1].(string)
+		sql, _ := row[2].(string)
+
+		if strings.HasPrefix(name, "sqlite_") || types[name] == "shadow" {
+			continue
+		}
+
+		document.Schema = append(document.Schema, jsonSchemaEntry{entryType, name, sql})
+
+		if entryType == "table" {
+			tableNames = append(tableNames, name)
+		}
+	}
+	if _, found := types["sqlite_sequence"]; found {
+		tableNames = append(tableNames, "sqlite_sequence")
+	}
+
+	for _, name := range tableNames {
+		table, err := exportJsonTable(conn, name, !withoutRowid[name])
+		if err != nil {
+			return document, err
+		}
+
+		document.Tables = append(document.Tables, table)
+	}
+
+	return document, nil
+}
+
+func exportJsonTable(conn driver.Conn, name string, hasRowid bool) (jsonTable, error) {
+	table := jsonTable{Name: name, Columns: []string{}, Rows: [][]jsonValue{}}
+
+	columns, err := queryMemory(conn, "SELECT name FROM pragma_table_info(?) ORDER BY cid", name)
+	if err != nil {
+		return table, err
+	}
+
+	// the values are read through expressions rather than the columns
+	// themselves so that the driver does not convert them by declared type,
+	// e.g. into times
+	expressions := make([]string, 0, len(columns)+1)
+	if hasRowid && name != "sqlite_sequence" {
+		table.Columns = append(table.Columns, "rowid")
+		expressions = append(expressions, "rowid")
+	}
+	for _, row := range columns {
+		column, _ := row[0].(string)
+		table.Columns = append(table.Columns, column)
+		expressions = append(expressions, "+"+quoteIdentifier(column))
+	}
+
+	sql := "SELECT " + strings.Join(expressions, ", ") + " FROM " + quoteIdentifier(name)
+	if table.Columns[0] == "rowid" {
+		sql += " ORDER BY rowid"
+	}
+
+	rows, err := queryMemory(conn, sql)
+	if err != nil {
+		return table, err
+	}
+
+	for _, row := range rows {
+		values := make([]jsonValue, len(row))
+		for index, value := range row {
+			values[index] = jsonValue{value}
+		}
+
+		table.Rows = append(table.Rows, values)
+	}
+
+	return table, nil
+}
+
+// Writes the document beside the file and then renames it over the file, so
+// that other connections never read a partially written database. Each schema
+// entry and row is written on a line of its own so that the changes to the
+// file can be compared line by line.
+func writeJsonFile(path string, document jsonDocument) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	if err := writeJsonDocument(file, document); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	if err := file.Chmod(mode); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	if err := os.Rename(file.Name(), path); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	return nil
+}
+
+func writeJsonDocument(writer io.Writer, document jsonDocument) error {
+	buffer := bufio.NewWriter(writer)
+
+	format, err := json.Marshal(document.Format)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(buffer, "{\n  \"format\": %s,\n  \"schema\": [", format)
+
+	for index, entry := range document.Schema {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		buffer.WriteString(jsonSeparator(index) + "\n    ")
+		buffer.Write(data)
+	}
+
+	buffer.WriteString("\n  ],\n  \"tables\": [")
+
+	for index, table := range document.Tables {
+		name, err := json.Marshal(table.Name)
+		if err != nil {
+			return err
+		}
+		columns, err := json.Marshal(table.Columns)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(buffer, "%v\n    {\"name\": %s, \"columns\": %s, \"rows\": [", jsonSeparator(index), name, columns)
+
+		for rowIndex, row := range table.Rows {
+			data, err := json.Marshal(row)
+			if err != nil {
+				return err
+			}
+
+			buffer.WriteString(jsonSeparator(rowIndex) + "\n      ")
+			buffer.Write(data)
+		}
+
+		buffer.WriteString("\n    ]}")
+	}
+
+	buffer.WriteString("\n  ]\n}\n")
+
+	return buffer.Flush()
+}
+
+func jsonSeparator(index int) string {
+	if index == 0 {
+		return ""
+	}
+
+	return ","
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func beginMemoryTx(ctx context.Context, conn driver.Conn, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+
+	return conn.Begin()
+}
+
+func execMemory(conn driver.Conn, query string, args ...driver.Value) error {
+	statement, err := conn.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	_, err = statement.Exec(args)
+	return err
+}
+
+func queryMemory(conn driver.Conn, query string, args ...driver.Value) ([][]driver.Value, error) {
+	statement, err := conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	rows, err := statement.Query(args)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([][]driver.Value, 0, 10)
+	for {
+		row := make([]driver.Value, len(rows.Columns()))
+		if err := rows.Next(row); err != nil {
+			if err == io.EOF {
+				return results, nil
+			}
+			return nil, err
+		}
+
+		// the driver may reuse its buffers for the next row
+		for index, value := range row {
+			if blob, ok := value.([]byte); ok {
+				row[index] = append([]byte(nil), blob...)
+			}
+		}
+
+		results = append(results, row)
+	}
+}
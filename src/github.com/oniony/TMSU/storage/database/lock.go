@@ -0,0 +1,199 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Describes the process holding the database's write lock, as recorded in the
+// lock sidecar file alongside the database. The sidecar file is also locked by
+// the holder so that processes wanting to write take turns.
+type LockHolder struct {
+	Pid     int
+	Command string
+	Since   time.Time
+}
+
+func (holder LockHolder) String() string {
+	held := time.Since(holder.Since) / time.Second * time.Second
+
+	return fmt.Sprintf("'%v' (pid %v) for %v", holder.Command, holder.Pid, held)
+}
+
+// Reads the details of the process recorded as holding the lock on the
+// database at the specified path, or nil if there is no such live process.
+func ReadLockHolder(dbPath string) (*LockHolder, error) {
+	file, err := os.Open(lockHolderPath(dbPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var holder LockHolder
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ": ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		switch parts[0] {
+		case "pid":
+			holder.Pid, _ = strconv.Atoi(parts[1])
+		case "command":
+			holder.Command = parts[1]
+		case "since":
+			holder.Since, _ = time.Parse(time.RFC3339, parts[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if holder.Pid == 0 || !processExists(holder.Pid) {
+		// left behind by a process that did not exit cleanly
+		return nil, nil
+	}
+
+	return &holder, nil
+}
+
+// unexported
+
+// the longest pause between attempts to take a lock held by another process
+const maxLockRetryInterval = 200 * time.Millisecond
+
+func lockHolderPath(dbPath string) string {
+	return dbPath + ".lock"
+}
+
+// Takes the advisory lock that serialises the processes writing to the
+// database, waiting up to the specified time for another process to release
+// it, and records this process as its holder. Returns nil, rather than an
+// error, if the lock file cannot be opened, e.g. as the database's directory is
+// not writable, as Sqlite's own locking still applies.
+func acquireWriteLock(ctx context.Context, dbPath, command string, wait time.Duration) (*os.File, error) {
+	file, err := os.OpenFile(lockHolderPath(dbPath), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		log.Debugf("could not open lock file: %v", err)
+		return nil, nil
+	}
+
+	err = retryWhileLocked(ctx, wait, func() error {
+		locked, err := tryLockFile(file)
+		switch {
+		case err != nil:
+			return err
+		case !locked:
+			return errWriteLockHeld
+		default:
+			return nil
+		}
+	})
+	if err != nil {
+		file.Close()
+
+		if err == errWriteLockHeld {
+			return nil, lockedError(dbPath)
+		}
+		return nil, err
+	}
+
+	if err := writeLockHolder(file, command); err != nil {
+		log.Debugf("could not record lock holder: %v", err)
+	}
+
+	return file, nil
+}
+
+// Clears the record of this process as the lock holder and releases the lock.
+func releaseWriteLock(file *os.File) {
+	if err := file.Truncate(0); err != nil {
+		log.Debugf("could not remove lock holder record: %v", err)
+	}
+
+	if err := unlockFile(file); err != nil {
+		log.Debugf("could not release lock: %v", err)
+	}
+
+	file.Close()
+}
+
+func writeLockHolder(file *os.File, command string) error {
+	if command == "" {
+		command = "tmsu"
+	}
+
+	content := fmt.Sprintf("pid: %v\ncommand: %v\nsince: %v\n", os.Getpid(), command, time.Now().Format(time.RFC3339))
+
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+
+	_, err := file.WriteAt([]byte(content), 0)
+	return err
+}
+
+// Calls the function until it succeeds or fails for a reason other than the
+// database being locked, pausing for progressively longer between attempts.
+// The locked error is returned once the wait has elapsed or the context is
+// cancelled.
+func retryWhileLocked(ctx context.Context, wait time.Duration, function func() error) error {
+	deadline := time.Now().Add(wait)
+	interval := 10 * time.Millisecond
+
+	for {
+		err := function()
+		if !isLocked(err) || time.Now().Add(interval).After(deadline) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(interval):
+		}
+
+		if interval *= 2; interval > maxLockRetryInterval {
+			interval = maxLockRetryInterval
+		}
+	}
+}
+
+var errWriteLockHeld = errors.New("database is locked")
+
+func isLocked(err error) bool {
+	return err != nil && (err == errWriteLockHeld || engine.isBusy(err))
+}
+
+func lockedError(dbPath string) error {
+	holder, _ := ReadLockHolder(dbPath)
+
+	return DatabaseLockedError{dbPath, holder}
+}
@@ -0,0 +1,27 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// +build windows
+
+package database
+
+// unexported
+
+// Absolute paths are stored with forward slashes and either a drive letter,
+// e.g. 'C:/Users', or a leading double slash for a UNC path.
+const relativeDirectoryCondition = "(directory NOT LIKE '/%' AND directory NOT LIKE '_:/%' AND directory NOT LIKE '" + VolumePathPrefix + "%')"
+
+// NTFS is case-insensitive, so stored paths are compared regardless of case.
+const pathCollation = "NOCASE"
@@ -0,0 +1,109 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"database/sql"
+	"github.com/oniony/TMSU/common/fingerprint"
+	"github.com/oniony/TMSU/entities"
+)
+
+// Retrieves the perceptual hashes of the files that are images.
+func PerceptualHashes(tx *Tx) (map[entities.FileId]fingerprint.PerceptualHash, error) {
+	sql := `
+SELECT file_id, hash
+FROM file_perceptual_hash
+WHERE hash IS NOT NULL`
+
+	rows, err := tx.Query(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hashes := make(map[entities.FileId]fingerprint.PerceptualHash)
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var fileId entities.FileId
+		var hash int64
+		if err := rows.Scan(&fileId, &hash); err != nil {
+			return nil, err
+		}
+
+		hashes[fileId] = fingerprint.PerceptualHash(uint64(hash))
+	}
+
+	return hashes, nil
+}
+
+// Retrieves the files, other than directories, that have not yet been perceptually hashed.
+func FilesWithoutPerceptualHash(tx *Tx) (entities.Files, error) {
+	sql := `
+SELECT id, directory, name, fingerprint, mod_time, size, is_dir
+FROM file
+WHERE is_dir = 0
+AND id NOT IN (SELECT file_id
+               FROM file_perceptual_hash)
+ORDER BY directory || '/' || name`
+
+	rows, err := tx.Query(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readFiles(rows, make(entities.Files, 0, 10))
+}
+
+// Records the perceptual hash of a file. A nil hash records that the file is not an image.
+func UpdatePerceptualHash(tx *Tx, fileId entities.FileId, hash *fingerprint.PerceptualHash) error {
+	var value sql.NullInt64
+	if hash != nil {
+		value = sql.NullInt64{int64(uint64(*hash)), true}
+	}
+
+	sql := `
+INSERT OR REPLACE INTO file_perceptual_hash (file_id, hash)
+VALUES (?, ?)`
+
+	result, err := tx.Exec(sql, fileId, value)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected != 1 {
+		panic("expected exactly one row to be affected.")
+	}
+
+	return nil
+}
+
+// Removes the perceptual hash of a file so that it is recalculated.
+func DeletePerceptualHash(tx *Tx, fileId entities.FileId) error {
+	sql := `
+DELETE FROM file_perceptual_hash
+WHERE file_id = ?`
+
+	_, err := tx.Exec(sql, fileId)
+	return err
+}
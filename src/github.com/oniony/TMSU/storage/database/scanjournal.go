@@ -0,0 +1,81 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"time"
+)
+
+// Retrieves the modification time of each directory recorded in the scan
+// journal, by directory.
+func ScanJournal(tx *Tx) (map[string]time.Time, error) {
+	sql := `
+SELECT directory, mod_time
+FROM scan_journal`
+
+	rows, err := tx.Query(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	journal := make(map[string]time.Time)
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var directory string
+		var modTime time.Time
+		if err := rows.Scan(&directory, &modTime); err != nil {
+			return nil, err
+		}
+
+		journal[directory] = modTime
+	}
+
+	return journal, nil
+}
+
+// Records the modification time of a directory in the scan journal, replacing
+// any previous.
+func UpdateScanJournal(tx *Tx, directory string, modTime time.Time) error {
+	sql := `
+INSERT OR REPLACE INTO scan_journal (directory, mod_time)
+VALUES (?, ?)`
+
+	_, err := tx.Exec(sql, directory, modTime)
+	return err
+}
+
+// Removes a directory from the scan journal.
+func DeleteScanJournalEntry(tx *Tx, directory string) error {
+	sql := `
+DELETE FROM scan_journal
+WHERE directory = ?`
+
+	_, err := tx.Exec(sql, directory)
+	return err
+}
+
+// Empties the scan journal.
+func ClearScanJournal(tx *Tx) error {
+	sql := `
+DELETE FROM scan_journal`
+
+	_, err := tx.Exec(sql)
+	return err
+}
@@ -23,7 +23,8 @@ import (
 
 // unexported
 
-var latestSchemaVersion = schemaVersion{common.Version{0, 7, 0}, 1}
+// the version brought about by the last migration
+var latestSchemaVersion = migrations[len(migrations)-1].version
 
 func currentSchemaVersion(tx *sql.Tx) schemaVersion {
 	sql := `
@@ -69,6 +70,24 @@ VALUES (?, ?, ?, ?)`
 	return nil
 }
 
+// Whether the table has the column, as migrations adding a column are also run
+// against databases created with it.
+func columnExists(tx *sql.Tx, table, column string) (bool, error) {
+	sql := `
+SELECT count(1)
+FROM pragma_table_info(?)
+WHERE name = ?`
+
+	rows, err := tx.Query(sql, table, column)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	count, err := readCount(rows)
+	return count > 0, err
+}
+
 func updateSchemaVersion(tx *sql.Tx, version schemaVersion) error {
 	sql := `
 UPDATE version SET major = ?, minor = ?, patch = ?, revision = ?`
@@ -113,6 +132,70 @@ func createSchema(tx *sql.Tx) error {
 		return err
 	}
 
+	if err := createTagDefinitionTables(tx); err != nil {
+		return err
+	}
+
+	if err := createPerceptualHashTable(tx); err != nil {
+		return err
+	}
+
+	if err := createVocabularyTable(tx); err != nil {
+		return err
+	}
+
+	if err := createVerificationTable(tx); err != nil {
+		return err
+	}
+
+	if err := createFileTagChangeTable(tx); err != nil {
+		return err
+	}
+
+	if err := createTagInfoTable(tx); err != nil {
+		return err
+	}
+
+	if err := createAuditTable(tx); err != nil {
+		return err
+	}
+
+	if err := createForgottenFileTables(tx); err != nil {
+		return err
+	}
+
+	if err := createFingerprintTagTable(tx); err != nil {
+		return err
+	}
+
+	if err := createVolumeTable(tx); err != nil {
+		return err
+	}
+
+	if err := createFileRelationTable(tx); err != nil {
+		return err
+	}
+
+	if err := createScanJournalTable(tx); err != nil {
+		return err
+	}
+
+	if err := createFileTagExpiryTable(tx); err != nil {
+		return err
+	}
+
+	if err := createStatisticsTable(tx); err != nil {
+		return err
+	}
+
+	if err := createTagJournalTable(tx); err != nil {
+		return err
+	}
+
+	if err := createBundleTable(tx); err != nil {
+		return err
+	}
+
 	if err := createSettingTable(tx); err != nil {
 		return err
 	}
@@ -121,6 +204,10 @@ func createSchema(tx *sql.Tx) error {
 		return err
 	}
 
+	if err := createSchemaVersionTable(tx); err != nil {
+		return err
+	}
+
 	if err := insertSchemaVersion(tx, latestSchemaVersion); err != nil {
 		return err
 	}
@@ -190,6 +277,20 @@ CREATE TABLE IF NOT EXISTS value (
 		return err
 	}
 
+	return createValueNameIndex(tx)
+}
+
+// serves the look up of values by prefix ignoring case: the index of the
+// unique constraint serves that respecting case
+func createValueNameIndex(tx *sql.Tx) error {
+	sql := `
+CREATE INDEX IF NOT EXISTS idx_value_name_nocase
+ON value(name COLLATE NOCASE)`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -199,6 +300,9 @@ CREATE TABLE IF NOT EXISTS file_tag (
     file_id INTEGER NOT NULL,
     tag_id INTEGER NOT NULL,
     value_id INTEGER NOT NULL,
+    author TEXT NOT NULL DEFAULT '',
+    inherit BOOLEAN NOT NULL DEFAULT 0,
+    implied BOOLEAN NOT NULL DEFAULT 0,
     PRIMARY KEY (file_id, tag_id, value_id),
     FOREIGN KEY (file_id) REFERENCES file(id),
     FOREIGN KEY (tag_id) REFERENCES tag(id)
@@ -210,24 +314,77 @@ CREATE TABLE IF NOT EXISTS file_tag (
 	}
 
 	sql = `
-CREATE INDEX IF NOT EXISTS idx_file_tag_file_id
-ON file_tag(file_id)`
+CREATE INDEX IF NOT EXISTS idx_file_tag_value_id
+ON file_tag(value_id)`
 
 	if _, err := tx.Exec(sql); err != nil {
 		return err
 	}
 
-	sql = `
-CREATE INDEX IF NOT EXISTS idx_file_tag_tag_id
-ON file_tag(tag_id)`
+	if err := createFileTagInheritIndex(tx); err != nil {
+		return err
+	}
+
+	if err := createFileTagImpliedIndex(tx); err != nil {
+		return err
+	}
+
+	if err := createFileTagCoveringIndex(tx); err != nil {
+		return err
+	}
+
+	return createFileTagExplicitIndex(tx)
+}
+
+// the few directory tags inherited by directory contents are found without
+// scanning every file tag
+func createFileTagInheritIndex(tx *sql.Tx) error {
+	sql := `
+CREATE INDEX IF NOT EXISTS idx_file_tag_inherit
+ON file_tag(file_id) WHERE inherit`
 
 	if _, err := tx.Exec(sql); err != nil {
 		return err
 	}
 
-	sql = `
-CREATE INDEX IF NOT EXISTS idx_file_tag_value_id
-ON file_tag(value_id)`
+	return nil
+}
+
+// the materialised implied file tags are replaced without scanning the
+// explicit ones
+func createFileTagImpliedIndex(tx *sql.Tx) error {
+	sql := `
+CREATE INDEX IF NOT EXISTS idx_file_tag_implied
+ON file_tag(file_id) WHERE implied`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// covers the look up of the files having a tag (and value) so that queries
+// need not visit the file_tag table itself
+func createFileTagCoveringIndex(tx *sql.Tx) error {
+	sql := `
+CREATE INDEX IF NOT EXISTS idx_file_tag_tag_value_file
+ON file_tag(tag_id, value_id, file_id)`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// the files explicitly tagged with a tag are found from the index alone, without
+// reading each file tag to exclude the implied ones. The primary key serves the
+// look up of a file's tags.
+func createFileTagExplicitIndex(tx *sql.Tx) error {
+	sql := `
+CREATE INDEX IF NOT EXISTS idx_file_tag_tag_file
+ON file_tag(tag_id, file_id) WHERE NOT implied`
 
 	if _, err := tx.Exec(sql); err != nil {
 		return err
@@ -243,7 +400,8 @@ CREATE TABLE IF NOT EXISTS implication (
     value_id INTEGER NOT NULL,
     implied_tag_id INTEGER NOT NULL,
     implied_value_id INTEGER NOT NULL,
-    PRIMARY KEY (tag_id, value_id, implied_tag_id, implied_value_id)
+    pattern TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (tag_id, value_id, pattern, implied_tag_id, implied_value_id)
 )`
 
 	if _, err := tx.Exec(sql); err != nil {
@@ -266,6 +424,254 @@ CREATE TABLE IF NOT EXISTS query (
 	return nil
 }
 
+func createTagDefinitionTables(tx *sql.Tx) error {
+	sql := `
+CREATE TABLE IF NOT EXISTS tag_definition (
+    tag_id INTEGER PRIMARY KEY,
+    type TEXT NOT NULL,
+    FOREIGN KEY (tag_id) REFERENCES tag(id)
+)`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	sql = `
+CREATE TABLE IF NOT EXISTS tag_enum_value (
+    tag_id INTEGER NOT NULL,
+    ordinal INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    PRIMARY KEY (tag_id, name),
+    FOREIGN KEY (tag_id) REFERENCES tag(id)
+)`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func createPerceptualHashTable(tx *sql.Tx) error {
+	sql := `
+CREATE TABLE IF NOT EXISTS file_perceptual_hash (
+    file_id INTEGER PRIMARY KEY,
+    hash INTEGER,
+    FOREIGN KEY (file_id) REFERENCES file(id)
+)`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func createVocabularyTable(tx *sql.Tx) error {
+	sql := `
+CREATE TABLE IF NOT EXISTS vocabulary_term (
+    tag_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    canonical TEXT NOT NULL,
+    PRIMARY KEY (tag_id, name),
+    FOREIGN KEY (tag_id) REFERENCES tag(id)
+)`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func createVerificationTable(tx *sql.Tx) error {
+	sql := `
+CREATE TABLE IF NOT EXISTS file_verification (
+    file_id INTEGER PRIMARY KEY,
+    verified_at DATETIME NOT NULL,
+    passed BOOLEAN NOT NULL,
+    FOREIGN KEY (file_id) REFERENCES file(id)
+)`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// The tag information table holds the descriptive details of tags. A trigger
+// records when each tag is created, so tags that predate the table have no
+// creation time.
+func createTagInfoTable(tx *sql.Tx) error {
+	sql := `
+CREATE TABLE IF NOT EXISTS tag_info (
+    tag_id INTEGER PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    colour TEXT NOT NULL DEFAULT '',
+    created_at DATETIME,
+    FOREIGN KEY (tag_id) REFERENCES tag(id)
+)`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	sql = `
+CREATE TRIGGER IF NOT EXISTS tag_created AFTER INSERT ON tag
+BEGIN
+    INSERT OR REPLACE INTO tag_info (tag_id, created_at)
+    VALUES (NEW.id, strftime('%Y-%m-%d %H:%M:%f', 'now'));
+END`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// The file tag change table records, by name rather than identifier, when each
+// file tag was last added or removed so that databases can be synchronised.
+// It is maintained by triggers so that every route by which file tags change
+// is captured: renaming a file, tag or value is recorded as the removal of the
+// file tags under the old name and their addition under the new.
+func createFileTagChangeTable(tx *sql.Tx) error {
+	sql := `
+CREATE TABLE IF NOT EXISTS file_tag_change (
+    directory TEXT NOT NULL,
+    name TEXT NOT NULL,
+    tag TEXT NOT NULL,
+    value TEXT NOT NULL,
+    removed BOOLEAN NOT NULL,
+    changed_at DATETIME NOT NULL,
+    PRIMARY KEY (directory, name, tag, value)
+)`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	sql = `
+CREATE TRIGGER IF NOT EXISTS file_tag_added AFTER INSERT ON file_tag
+WHEN NOT NEW.implied
+BEGIN
+    INSERT OR REPLACE INTO file_tag_change (directory, name, tag, value, removed, changed_at)
+    SELECT f.directory, f.name, t.name, coalesce(v.name, ''), 0, strftime('%Y-%m-%d %H:%M:%f', 'now')
+    FROM file f, tag t
+    LEFT OUTER JOIN value v ON v.id = NEW.value_id
+    WHERE f.id = NEW.file_id AND t.id = NEW.tag_id;
+END`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	sql = `
+CREATE TRIGGER IF NOT EXISTS file_tag_removed AFTER DELETE ON file_tag
+WHEN NOT OLD.implied
+BEGIN
+    INSERT OR REPLACE INTO file_tag_change (directory, name, tag, value, removed, changed_at)
+    SELECT f.directory, f.name, t.name, coalesce(v.name, ''), 1, strftime('%Y-%m-%d %H:%M:%f', 'now')
+    FROM file f, tag t
+    LEFT OUTER JOIN value v ON v.id = OLD.value_id
+    WHERE f.id = OLD.file_id AND t.id = OLD.tag_id;
+END`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	sql = `
+CREATE TRIGGER IF NOT EXISTS file_moved AFTER UPDATE OF directory, name ON file
+WHEN OLD.directory != NEW.directory OR OLD.name != NEW.name
+BEGIN
+    INSERT OR REPLACE INTO file_tag_change (directory, name, tag, value, removed, changed_at)
+    SELECT OLD.directory, OLD.name, t.name, coalesce(v.name, ''), 1, strftime('%Y-%m-%d %H:%M:%f', 'now')
+    FROM file_tag ft
+    INNER JOIN tag t ON t.id = ft.tag_id
+    LEFT OUTER JOIN value v ON v.id = ft.value_id
+    WHERE ft.file_id = NEW.id AND NOT ft.implied;
+
+    INSERT OR REPLACE INTO file_tag_change (directory, name, tag, value, removed, changed_at)
+    SELECT NEW.directory, NEW.name, t.name, coalesce(v.name, ''), 0, strftime('%Y-%m-%d %H:%M:%f', 'now')
+    FROM file_tag ft
+    INNER JOIN tag t ON t.id = ft.tag_id
+    LEFT OUTER JOIN value v ON v.id = ft.value_id
+    WHERE ft.file_id = NEW.id AND NOT ft.implied;
+END`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	sql = `
+CREATE TRIGGER IF NOT EXISTS tag_renamed AFTER UPDATE OF name ON tag
+WHEN OLD.name != NEW.name
+BEGIN
+    INSERT OR REPLACE INTO file_tag_change (directory, name, tag, value, removed, changed_at)
+    SELECT f.directory, f.name, OLD.name, coalesce(v.name, ''), 1, strftime('%Y-%m-%d %H:%M:%f', 'now')
+    FROM file_tag ft
+    INNER JOIN file f ON f.id = ft.file_id
+    LEFT OUTER JOIN value v ON v.id = ft.value_id
+    WHERE ft.tag_id = NEW.id AND NOT ft.implied;
+
+    INSERT OR REPLACE INTO file_tag_change (directory, name, tag, value, removed, changed_at)
+    SELECT f.directory, f.name, NEW.name, coalesce(v.name, ''), 0, strftime('%Y-%m-%d %H:%M:%f', 'now')
+    FROM file_tag ft
+    INNER JOIN file f ON f.id = ft.file_id
+    LEFT OUTER JOIN value v ON v.id = ft.value_id
+    WHERE ft.tag_id = NEW.id AND NOT ft.implied;
+END`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	sql = `
+CREATE TRIGGER IF NOT EXISTS value_renamed AFTER UPDATE OF name ON value
+WHEN OLD.name != NEW.name
+BEGIN
+    INSERT OR REPLACE INTO file_tag_change (directory, name, tag, value, removed, changed_at)
+    SELECT f.directory, f.name, t.name, OLD.name, 1, strftime('%Y-%m-%d %H:%M:%f', 'now')
+    FROM file_tag ft
+    INNER JOIN file f ON f.id = ft.file_id
+    INNER JOIN tag t ON t.id = ft.tag_id
+    WHERE ft.value_id = NEW.id AND NOT ft.implied;
+
+    INSERT OR REPLACE INTO file_tag_change (directory, name, tag, value, removed, changed_at)
+    SELECT f.directory, f.name, t.name, NEW.name, 0, strftime('%Y-%m-%d %H:%M:%f', 'now')
+    FROM file_tag ft
+    INNER JOIN file f ON f.id = ft.file_id
+    INNER JOIN tag t ON t.id = ft.tag_id
+    WHERE ft.value_id = NEW.id AND NOT ft.implied;
+END`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Records the file tags that predate change tracking as having been added at
+// the epoch, so that any subsequent change to them takes precedence.
+func seedFileTagChanges(tx *sql.Tx) error {
+	sql := `
+INSERT OR IGNORE INTO file_tag_change (directory, name, tag, value, removed, changed_at)
+SELECT f.directory, f.name, t.name, coalesce(v.name, ''), 0, '1970-01-01 00:00:00.000'
+FROM file_tag ft
+INNER JOIN file f ON f.id = ft.file_id
+INNER JOIN tag t ON t.id = ft.tag_id
+LEFT OUTER JOIN value v ON v.id = ft.value_id`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func createSettingTable(tx *sql.Tx) error {
 	sql := `
 CREATE TABLE IF NOT EXISTS setting (
@@ -296,3 +702,240 @@ CREATE TABLE IF NOT EXISTS version (
 
 	return nil
 }
+
+// The schema version table records each migration applied to the database and
+// when, whereas the version table holds just the current version.
+func createSchemaVersionTable(tx *sql.Tx) error {
+	sql := `
+CREATE TABLE IF NOT EXISTS schema_version (
+    major NUMBER NOT NULL,
+    minor NUMBER NOT NULL,
+    patch NUMBER NOT NULL,
+    revision NUMBER NOT NULL,
+    description TEXT NOT NULL,
+    applied_at DATETIME NOT NULL,
+    PRIMARY KEY (major, minor, patch, revision)
+)`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func recordMigration(tx *sql.Tx, migration migration) error {
+	sql := `
+INSERT OR REPLACE INTO schema_version (major, minor, patch, revision, description, applied_at)
+VALUES (?, ?, ?, ?, ?, strftime('%Y-%m-%d %H:%M:%f', 'now'))`
+
+	version := migration.version
+	if _, err := tx.Exec(sql, version.Major, version.Minor, version.Patch, version.Revision, migration.description); err != nil {
+		return fmt.Errorf("could not record schema migration: %v", err)
+	}
+
+	return nil
+}
+
+// records the files removed from the database along with their tags, so that
+// the tags can be restored should the files reappear
+func createForgottenFileTables(tx *sql.Tx) error {
+	sql := `
+CREATE TABLE IF NOT EXISTS forgotten_file (
+    id INTEGER PRIMARY KEY,
+    directory TEXT NOT NULL,
+    name TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    mod_time DATETIME NOT NULL,
+    size INTEGER NOT NULL,
+    is_dir BOOLEAN NOT NULL,
+    forgotten_at DATETIME NOT NULL
+)`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	sql = `
+CREATE INDEX IF NOT EXISTS idx_forgotten_file_path
+ON forgotten_file(directory, name)`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	sql = `
+CREATE TABLE IF NOT EXISTS forgotten_file_tag (
+    forgotten_file_id INTEGER NOT NULL,
+    tag_id INTEGER NOT NULL,
+    value_id INTEGER NOT NULL,
+    PRIMARY KEY (forgotten_file_id, tag_id, value_id),
+    FOREIGN KEY (forgotten_file_id) REFERENCES forgotten_file(id),
+    FOREIGN KEY (tag_id) REFERENCES tag(id),
+    FOREIGN KEY (value_id) REFERENCES value(id)
+)`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	sql = `
+CREATE INDEX IF NOT EXISTS idx_forgotten_file_tag_tag_id
+ON forgotten_file_tag(tag_id)`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// records taggings of files not yet in the database by their fingerprint
+func createFingerprintTagTable(tx *sql.Tx) error {
+	sql := `
+CREATE TABLE IF NOT EXISTS fingerprint_tag (
+    algorithm TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    tag_id INTEGER NOT NULL,
+    value_id INTEGER NOT NULL,
+    PRIMARY KEY (fingerprint, tag_id, value_id),
+    FOREIGN KEY (tag_id) REFERENCES tag(id),
+    FOREIGN KEY (value_id) REFERENCES value(id)
+)`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// records the separately mounted file-systems whose files' paths are stored
+// relative to their roots
+func createVolumeTable(tx *sql.Tx) error {
+	sql := `
+CREATE TABLE IF NOT EXISTS volume (
+    id INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    uuid TEXT NOT NULL,
+    path TEXT NOT NULL,
+    CONSTRAINT con_volume_name UNIQUE (name)
+)`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// records the relations between files, such as an image derived from another
+func createFileRelationTable(tx *sql.Tx) error {
+	sql := `
+CREATE TABLE IF NOT EXISTS file_relation (
+    file_id INTEGER NOT NULL,
+    related_file_id INTEGER NOT NULL,
+    relation TEXT NOT NULL,
+    PRIMARY KEY (file_id, related_file_id, relation),
+    FOREIGN KEY (file_id) REFERENCES file(id),
+    FOREIGN KEY (related_file_id) REFERENCES file(id)
+)`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	sql = `
+CREATE INDEX IF NOT EXISTS idx_file_relation_related_file_id
+ON file_relation(related_file_id)`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// records the modification time of each directory when 'repair' last examined
+// it, so that unchanged directories can be skipped
+func createScanJournalTable(tx *sql.Tx) error {
+	sql := `
+CREATE TABLE IF NOT EXISTS scan_journal (
+    directory TEXT PRIMARY KEY,
+    mod_time DATETIME NOT NULL
+)`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// records when file tags applied with an expiry are due to expire. A trigger
+// forgets the expiry once the file tag is removed, so that the tag is not
+// expired should it be reapplied without one.
+func createFileTagExpiryTable(tx *sql.Tx) error {
+	sql := `
+CREATE TABLE IF NOT EXISTS file_tag_expiry (
+    file_id INTEGER NOT NULL,
+    tag_id INTEGER NOT NULL,
+    value_id INTEGER NOT NULL,
+    expires_at DATETIME NOT NULL,
+    PRIMARY KEY (file_id, tag_id, value_id),
+    FOREIGN KEY (file_id, tag_id, value_id) REFERENCES file_tag(file_id, tag_id, value_id)
+)`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	sql = `
+CREATE TRIGGER IF NOT EXISTS file_tag_expiry_removed AFTER DELETE ON file_tag
+WHEN NOT OLD.implied
+BEGIN
+    DELETE FROM file_tag_expiry
+    WHERE file_id = OLD.file_id AND tag_id = OLD.tag_id AND value_id = OLD.value_id;
+END`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// records the directories a chunked recursive tagging has finished, so that
+// the tagging can resume where it left off should it be interrupted
+func createTagJournalTable(tx *sql.Tx) error {
+	sql := `
+CREATE TABLE IF NOT EXISTS tag_journal (
+    operation TEXT NOT NULL,
+    directory TEXT NOT NULL,
+    PRIMARY KEY (operation, directory)
+)`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// records the named sets of tags that can be applied together, each tag along
+// with its position in the set
+func createBundleTable(tx *sql.Tx) error {
+	sql := `
+CREATE TABLE IF NOT EXISTS bundle (
+    name TEXT NOT NULL,
+    position INTEGER NOT NULL,
+    tag TEXT NOT NULL,
+    PRIMARY KEY (name, position)
+)`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
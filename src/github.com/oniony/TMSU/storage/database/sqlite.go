@@ -0,0 +1,85 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"time"
+)
+
+// the name under which the Sqlite driver, extended with TMSU's SQL functions,
+// is registered
+const driverName = "sqlite3_tmsu"
+
+func init() {
+	engine.register()
+	RegisterBackend(sqliteBackend{})
+}
+
+// Opens another application's Sqlite database, such as that of a photo manager,
+// for reading.
+func OpenSqliteReadOnly(path string) (*sql.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	return sql.Open(driverName, "file:"+path+"?mode=ro")
+}
+
+// unexported
+
+// sqliteDriver is a database/sql driver for Sqlite. Which one is decided at
+// build time: the cgo binding to the Sqlite library by default or, with the
+// 'purego' build tag, a translation of Sqlite to Go, which allows TMSU to be
+// built and cross-compiled without a C toolchain.
+type sqliteDriver interface {
+	// Registers the driver as driverName with matchRegexp as its REGEXP.
+	register()
+
+	// The connection parameter that waits up to timeout for a lock held by
+	// another connection before failing.
+	busyTimeoutParameter(timeout time.Duration) string
+
+	// Whether the error reports a lock held by another connection.
+	isBusy(err error) bool
+
+	// Copies the main database of the source connection over that of the
+	// destination connection using Sqlite's online backup API.
+	backup(dest, source *sql.Conn) error
+}
+
+// sqliteBackend holds the database in a Sqlite 3 file.
+type sqliteBackend struct{}
+
+func (sqliteBackend) Name() string {
+	return "sqlite"
+}
+
+func (sqliteBackend) Open(dataSourceName string) (*sql.DB, error) {
+	return sql.Open(driverName, dataSourceName)
+}
+
+func (sqliteBackend) Copy(dest, source *sql.Conn) error {
+	return engine.backup(dest, source)
+}
+
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+func (sqliteBackend) Recognises(header []byte) bool {
+	return bytes.HasPrefix(header, sqliteHeader)
+}
@@ -0,0 +1,67 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// +build !purego
+
+package database
+
+import (
+	"database/sql"
+	"github.com/mattn/go-sqlite3"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unexported
+
+var engine sqliteDriver = cgoSqlite{}
+
+// cgoSqlite is the go-sqlite3 binding to the Sqlite C library.
+type cgoSqlite struct{}
+
+func (cgoSqlite) register() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+		return conn.RegisterFunc("regexp", matchRegexp, true)
+	}})
+}
+
+func (cgoSqlite) busyTimeoutParameter(timeout time.Duration) string {
+	return "_busy_timeout=" + strconv.FormatInt(int64(timeout/time.Millisecond), 10)
+}
+
+func (cgoSqlite) isBusy(err error) bool {
+	return strings.Contains(err.Error(), "database is locked") || strings.Contains(err.Error(), "database table is locked")
+}
+
+func (cgoSqlite) backup(dest, source *sql.Conn) error {
+	return dest.Raw(func(destConn interface{}) error {
+		return source.Raw(func(sourceConn interface{}) error {
+			backup, err := destConn.(*sqlite3.SQLiteConn).Backup("main", sourceConn.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+
+			// copying every page in one step holds the source's read lock
+			// throughout, so the copy is consistent
+			if _, err := backup.Step(-1); err != nil {
+				backup.Finish()
+				return err
+			}
+
+			return backup.Finish()
+		})
+	})
+}
@@ -0,0 +1,114 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// +build purego
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+	"strconv"
+	"time"
+)
+
+// unexported
+
+var engine sqliteDriver = pureGoSqlite{}
+
+// pureGoSqlite is the modernc.org translation of Sqlite to Go.
+type pureGoSqlite struct{}
+
+func (pureGoSqlite) register() {
+	// the function is registered for every connection made by the driver
+	sqlite.MustRegisterDeterministicScalarFunction("regexp", 2, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		pattern, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("regexp: pattern is not text")
+		}
+
+		var text string
+		switch value := args[1].(type) {
+		case string:
+			text = value
+		case []byte:
+			text = string(value)
+		case nil:
+			return false, nil
+		default:
+			text = fmt.Sprint(value)
+		}
+
+		return matchRegexp(pattern, text)
+	})
+
+	sql.Register(driverName, &sqlite.Driver{})
+}
+
+func (pureGoSqlite) busyTimeoutParameter(timeout time.Duration) string {
+	return "_pragma=busy_timeout(" + strconv.FormatInt(int64(timeout/time.Millisecond), 10) + ")"
+}
+
+func (pureGoSqlite) isBusy(err error) bool {
+	sqliteErr, ok := err.(*sqlite.Error)
+	if !ok {
+		return false
+	}
+
+	switch sqliteErr.Code() & 0xff {
+	case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+		return true
+	}
+
+	return false
+}
+
+// the connections of the driver, which can start a backup to a database URI
+type backupConn interface {
+	NewBackup(destUri string) (*sqlite.Backup, error)
+}
+
+func (pureGoSqlite) backup(dest, source *sql.Conn) error {
+	var destUri string
+	err := dest.QueryRowContext(context.Background(), "SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&destUri)
+	if err != nil {
+		return err
+	}
+
+	return source.Raw(func(sourceConn interface{}) error {
+		conn, ok := sourceConn.(backupConn)
+		if !ok {
+			return fmt.Errorf("the Sqlite driver does not support backups")
+		}
+
+		backup, err := conn.NewBackup(destUri)
+		if err != nil {
+			return err
+		}
+
+		// copying every page in one step holds the source's read lock
+		// throughout, so the copy is consistent
+		if _, err := backup.Step(-1); err != nil {
+			backup.Finish()
+			return err
+		}
+
+		return backup.Finish()
+	})
+}
@@ -0,0 +1,95 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"database/sql"
+	"github.com/oniony/TMSU/entities"
+	"time"
+)
+
+// Retrieves the recorded statistics of the database, oldest first.
+func StatisticsHistory(tx *Tx) (entities.StatisticsSnapshots, error) {
+	sql := `
+SELECT taken_on, tag_count, value_count, file_count, file_tag_count
+FROM statistics
+ORDER BY taken_on`
+
+	rows, err := tx.Query(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snapshots := make(entities.StatisticsSnapshots, 0, 10)
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var takenOn string
+		var snapshot entities.StatisticsSnapshot
+		if err := rows.Scan(&takenOn, &snapshot.TagCount, &snapshot.ValueCount, &snapshot.FileCount, &snapshot.FileTagCount); err != nil {
+			return nil, err
+		}
+
+		snapshot.TakenOn, err = time.ParseInLocation("2006-01-02", takenOn, time.Local)
+		if err != nil {
+			return nil, err
+		}
+
+		snapshots = append(snapshots, &snapshot)
+	}
+
+	return snapshots, nil
+}
+
+// unexported
+
+// The statistics table records the number of tags, values, files and file tags
+// in the database as of the last change made each day, so that its growth can
+// be followed.
+func createStatisticsTable(tx *sql.Tx) error {
+	sql := `
+CREATE TABLE IF NOT EXISTS statistics (
+    taken_on TEXT PRIMARY KEY,
+    tag_count INTEGER NOT NULL,
+    value_count INTEGER NOT NULL,
+    file_count INTEGER NOT NULL,
+    file_tag_count INTEGER NOT NULL
+)`
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Records the current statistics, replacing those recorded earlier the same
+// day.
+func recordStatistics(tx *sql.Tx) error {
+	sql := `
+INSERT OR REPLACE INTO statistics (taken_on, tag_count, value_count, file_count, file_tag_count)
+SELECT date('now', 'localtime'),
+       (SELECT count(1) FROM tag),
+       (SELECT count(1) FROM value),
+       (SELECT count(1) FROM file),
+       (SELECT count(1) FROM file_tag WHERE NOT implied)`
+
+	_, err := tx.Exec(sql)
+	return err
+}
@@ -52,6 +52,35 @@ ORDER BY name`
 	return readTags(rows, make(entities.Tags, 0, 10))
 }
 
+// Retrieves the set of tags that are not applied to any file, nor retained
+// for forgotten files or fingerprints. Tags referenced by implications are
+// considered used unless ignoreImplications is specified.
+func UnusedTags(tx *Tx, ignoreImplications bool) (entities.Tags, error) {
+	sql := `
+SELECT id, name
+FROM tag
+WHERE id NOT IN (SELECT tag_id FROM file_tag)
+  AND id NOT IN (SELECT tag_id FROM forgotten_file_tag)
+  AND id NOT IN (SELECT tag_id FROM fingerprint_tag)`
+
+	if !ignoreImplications {
+		sql += `
+  AND id NOT IN (SELECT tag_id FROM implication)
+  AND id NOT IN (SELECT implied_tag_id FROM implication)`
+	}
+
+	sql += `
+ORDER BY name`
+
+	rows, err := tx.Query(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readTags(rows, make(entities.Tags, 0, 10))
+}
+
 // Retrieves a specific tag.
 func Tag(tx *Tx, id entities.TagId) (*entities.Tag, error) {
 	sql := `
@@ -105,7 +134,7 @@ SELECT id, name
 FROM tag
 WHERE name ` + collation + ` = ?`
 
-	rows, err := tx.Query(sql, name)
+	rows, err := tx.QueryPrepared(sql, name)
 	if err != nil {
 		return nil, err
 	}
@@ -225,7 +254,7 @@ func TagUsage(tx *Tx) ([]entities.TagFileCount, error) {
 	sql := `
 SELECT t.id, t.name, count(file_id)
 FROM file_tag ft, tag t
-WHERE ft.tag_id = t.id
+WHERE ft.tag_id = t.id AND NOT ft.implied
 GROUP BY t.id
 ORDER BY t.name`
 
@@ -258,6 +287,54 @@ ORDER BY t.name`
 	return tags, nil
 }
 
+// Retrieves every tag with the number of files to which it is explicitly
+// applied, omitting those applied to fewer than minCount files. The tags are
+// ordered by sort: 'name', 'count' (most used first) or 'recent' (most
+// recently applied first).
+func TagFileCounts(tx *Tx, sort string, minCount uint) ([]entities.TagFileCount, error) {
+	var order string
+	switch sort {
+	case "count":
+		order = "count(DISTINCT ft.file_id) DESC, t.name"
+	case "recent":
+		order = "coalesce((SELECT max(a.id) FROM audit a WHERE a.action = 'tag' AND a.tag = t.name), 0) DESC, t.name"
+	default:
+		order = "t.name"
+	}
+
+	sql := `
+SELECT t.id, t.name, count(DISTINCT ft.file_id)
+FROM tag t
+LEFT OUTER JOIN file_tag ft ON ft.tag_id = t.id AND NOT ft.implied
+GROUP BY t.id
+HAVING count(DISTINCT ft.file_id) >= ?
+ORDER BY ` + order
+
+	rows, err := tx.Query(sql, minCount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make([]entities.TagFileCount, 0, 10)
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var tagId entities.TagId
+		var name string
+		var count uint
+		if err := rows.Scan(&tagId, &name, &count); err != nil {
+			return nil, err
+		}
+
+		tags = append(tags, entities.TagFileCount{tagId, name, count})
+	}
+
+	return tags, nil
+}
+
 // unexported
 
 func readTag(rows *sql.Rows) (*entities.Tag, error) {
@@ -0,0 +1,209 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"database/sql"
+	"github.com/oniony/TMSU/entities"
+)
+
+// The complete set of tag definitions.
+func TagDefinitions(tx *Tx) (entities.TagDefinitions, error) {
+	sql := `
+SELECT tag_id, type
+FROM tag_definition
+ORDER BY tag_id`
+
+	rows, err := tx.Query(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	definitions, err := readTagDefinitions(rows, make(entities.TagDefinitions, 0, 10))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, definition := range definitions {
+		if definition.Values, err = enumValues(tx, definition.TagId); err != nil {
+			return nil, err
+		}
+	}
+
+	return definitions, nil
+}
+
+// Retrieves the definition of the specified tag.
+func TagDefinition(tx *Tx, tagId entities.TagId) (*entities.TagDefinition, error) {
+	sql := `
+SELECT tag_id, type
+FROM tag_definition
+WHERE tag_id = ?`
+
+	rows, err := tx.Query(sql, tagId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	definition, err := readTagDefinition(rows)
+	if err != nil || definition == nil {
+		return nil, err
+	}
+
+	if definition.Values, err = enumValues(tx, tagId); err != nil {
+		return nil, err
+	}
+
+	return definition, nil
+}
+
+// Adds or replaces the definition of a tag.
+func UpdateTagDefinition(tx *Tx, tagId entities.TagId, tagType string, values []string) (*entities.TagDefinition, error) {
+	sql := `
+INSERT OR REPLACE INTO tag_definition (tag_id, type)
+VALUES (?, ?)`
+
+	result, err := tx.Exec(sql, tagId, tagType)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected != 1 {
+		panic("expected exactly one row to be affected.")
+	}
+
+	sql = `
+DELETE FROM tag_enum_value
+WHERE tag_id = ?`
+
+	if _, err := tx.Exec(sql, tagId); err != nil {
+		return nil, err
+	}
+
+	sql = `
+INSERT INTO tag_enum_value (tag_id, ordinal, name)
+VALUES (?, ?, ?)`
+
+	for ordinal, value := range values {
+		if _, err := tx.Exec(sql, tagId, ordinal, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return &entities.TagDefinition{tagId, tagType, values}, nil
+}
+
+// Deletes the definition of a tag.
+func DeleteTagDefinition(tx *Tx, tagId entities.TagId) error {
+	sql := `
+DELETE FROM tag_enum_value
+WHERE tag_id = ?`
+
+	if _, err := tx.Exec(sql, tagId); err != nil {
+		return err
+	}
+
+	sql = `
+DELETE FROM tag_definition
+WHERE tag_id = ?`
+
+	result, err := tx.Exec(sql, tagId)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected > 1 {
+		panic("expected only one row to be affected.")
+	}
+
+	return nil
+}
+
+// unexported
+
+func enumValues(tx *Tx, tagId entities.TagId) ([]string, error) {
+	sql := `
+SELECT name
+FROM tag_enum_value
+WHERE tag_id = ?
+ORDER BY ordinal`
+
+	rows, err := tx.Query(sql, tagId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make([]string, 0, 10)
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+func readTagDefinition(rows *sql.Rows) (*entities.TagDefinition, error) {
+	if !rows.Next() {
+		return nil, nil
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	var tagId entities.TagId
+	var tagType string
+	err := rows.Scan(&tagId, &tagType)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entities.TagDefinition{tagId, tagType, nil}, nil
+}
+
+func readTagDefinitions(rows *sql.Rows, definitions entities.TagDefinitions) (entities.TagDefinitions, error) {
+	for {
+		definition, err := readTagDefinition(rows)
+		if err != nil {
+			return nil, err
+		}
+		if definition == nil {
+			break
+		}
+
+		definitions = append(definitions, definition)
+	}
+
+	return definitions, nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"database/sql"
+	"github.com/oniony/TMSU/entities"
+	"time"
+)
+
+// The details of every tag that has them.
+func TagInfos(tx *Tx) (entities.TagInfos, error) {
+	sql := `
+SELECT tag_id, description, colour, created_at
+FROM tag_info
+ORDER BY tag_id`
+
+	rows, err := tx.Query(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readTagInfos(rows, make(entities.TagInfos, 0, 10))
+}
+
+// Retrieves the details of the specified tag, or nil if there are none.
+func TagInfo(tx *Tx, tagId entities.TagId) (*entities.TagInfo, error) {
+	sql := `
+SELECT tag_id, description, colour, created_at
+FROM tag_info
+WHERE tag_id = ?`
+
+	rows, err := tx.Query(sql, tagId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readTagInfo(rows)
+}
+
+// Updates the description and colour of a tag, retaining its creation time.
+func UpdateTagInfo(tx *Tx, tagId entities.TagId, description, colour string) error {
+	sql := `
+INSERT OR IGNORE INTO tag_info (tag_id)
+VALUES (?)`
+
+	if _, err := tx.Exec(sql, tagId); err != nil {
+		return err
+	}
+
+	sql = `
+UPDATE tag_info
+SET description = ?, colour = ?
+WHERE tag_id = ?`
+
+	result, err := tx.Exec(sql, description, colour, tagId)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected != 1 {
+		panic("expected exactly one row to be affected.")
+	}
+
+	return nil
+}
+
+// Deletes the details of a tag.
+func DeleteTagInfo(tx *Tx, tagId entities.TagId) error {
+	sql := `
+DELETE FROM tag_info
+WHERE tag_id = ?`
+
+	_, err := tx.Exec(sql, tagId)
+	return err
+}
+
+// unexported
+
+func readTagInfo(rows *sql.Rows) (*entities.TagInfo, error) {
+	if !rows.Next() {
+		return nil, nil
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	var tagId entities.TagId
+	var description, colour string
+	var created *time.Time
+	if err := rows.Scan(&tagId, &description, &colour, &created); err != nil {
+		return nil, err
+	}
+
+	info := entities.TagInfo{tagId, description, colour, time.Time{}}
+	if created != nil {
+		info.Created = *created
+	}
+
+	return &info, nil
+}
+
+func readTagInfos(rows *sql.Rows, infos entities.TagInfos) (entities.TagInfos, error) {
+	for {
+		info, err := readTagInfo(rows)
+		if err != nil {
+			return nil, err
+		}
+		if info == nil {
+			break
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
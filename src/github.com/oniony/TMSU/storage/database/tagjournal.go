@@ -0,0 +1,66 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+// Retrieves the directories recorded as finished by the tagging operation.
+func TagJournal(tx *Tx, operation string) ([]string, error) {
+	sql := `
+SELECT directory
+FROM tag_journal
+WHERE operation = ?`
+
+	rows, err := tx.Query(sql, operation)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	directories := make([]string, 0, 10)
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var directory string
+		if err := rows.Scan(&directory); err != nil {
+			return nil, err
+		}
+
+		directories = append(directories, directory)
+	}
+
+	return directories, nil
+}
+
+// Records that the tagging operation has finished the directory.
+func AddTagJournalEntry(tx *Tx, operation, directory string) error {
+	sql := `
+INSERT OR IGNORE INTO tag_journal (operation, directory)
+VALUES (?, ?)`
+
+	_, err := tx.Exec(sql, operation, directory)
+	return err
+}
+
+// Forgets the directories finished by the tagging operation.
+func ClearTagJournal(tx *Tx, operation string) error {
+	sql := `
+DELETE FROM tag_journal
+WHERE operation = ?`
+
+	_, err := tx.Exec(sql, operation)
+	return err
+}
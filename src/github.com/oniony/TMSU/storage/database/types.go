@@ -32,9 +32,11 @@ func (version schemaVersion) String() string {
 }
 
 func (this schemaVersion) LessThan(that schemaVersion) bool {
-	return this.Major < that.Major || this.Minor < that.Minor || this.Patch < that.Patch || this.Revision < that.Revision
+	return this.Version.LessThan(that.Version) ||
+		(this.Version == that.Version && this.Revision < that.Revision)
 }
 
 func (this schemaVersion) GreaterThan(that schemaVersion) bool {
-	return this.Major > that.Major || this.Minor > that.Minor || this.Patch > that.Patch || this.Revision > that.Revision
+	return this.Version.GreaterThan(that.Version) ||
+		(this.Version == that.Version && this.Revision > that.Revision)
 }
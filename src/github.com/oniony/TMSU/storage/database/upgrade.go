@@ -17,25 +17,75 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
 	"github.com/oniony/TMSU/common"
 	"github.com/oniony/TMSU/common/log"
 )
 
 // unexported
 
+// A change to the database schema, identified by the schema version it brings
+// the database to.
+type migration struct {
+	version     schemaVersion
+	description string
+	apply       func(tx *sql.Tx) error
+}
+
+// The migrations, in the order in which they must be applied. A change to the
+// schema is made by appending a migration with the next revision (or, for a
+// new release, version) and updating createSchema to match: existing
+// migrations must never be altered as databases may already have them applied.
+var migrations = []migration{
+	{schemaVersion{common.Version{0, 5, 0}, 0}, "renaming fingerprint algorithm setting", renameFingerprintAlgorithmSetting},
+	{schemaVersion{common.Version{0, 6, 0}, 0}, "recreating implication table", recreateImplicationTable},
+	{schemaVersion{common.Version{0, 7, 0}, 0}, "updating fingerprint algorithms", updateFingerprintAlgorithms},
+	{schemaVersion{common.Version{0, 7, 0}, 1}, "recreating version table", recreateVersionTable},
+	{schemaVersion{common.Version{0, 8, 0}, 0}, "creating tag definition tables", createTagDefinitionTables},
+	{schemaVersion{common.Version{0, 8, 0}, 1}, "creating perceptual hash table", createPerceptualHashTable},
+	{schemaVersion{common.Version{0, 8, 0}, 2}, "adding pattern to implication table", addImplicationPattern},
+	{schemaVersion{common.Version{0, 8, 0}, 3}, "creating vocabulary table", createVocabularyTable},
+	{schemaVersion{common.Version{0, 8, 0}, 4}, "creating verification table", createVerificationTable},
+	{schemaVersion{common.Version{0, 8, 0}, 5}, "creating file tag change table", createAndSeedFileTagChangeTable},
+	{schemaVersion{common.Version{0, 8, 0}, 6}, "creating tag information table", createTagInfoTable},
+	{schemaVersion{common.Version{0, 8, 0}, 7}, "creating audit table", createAuditTable},
+	{schemaVersion{common.Version{0, 8, 0}, 8}, "adding author to file tag table", addFileTagAuthor},
+	{schemaVersion{common.Version{0, 8, 0}, 9}, "adding covering index to file tag table", replaceFileTagTagIndex},
+	{schemaVersion{common.Version{0, 8, 0}, 10}, "creating forgotten file tables", createForgottenFileTables},
+	{schemaVersion{common.Version{0, 8, 0}, 11}, "creating fingerprint tag table", createFingerprintTagTable},
+	{schemaVersion{common.Version{0, 8, 0}, 12}, "creating volume table", createVolumeTable},
+	{schemaVersion{common.Version{0, 8, 0}, 13}, "adding inheritance to file tag table", addFileTagInherit},
+	{schemaVersion{common.Version{0, 8, 0}, 14}, "creating file relation table", createFileRelationTable},
+	{schemaVersion{common.Version{0, 8, 0}, 15}, "adding implied flag to file tag table", addFileTagImplied},
+	{schemaVersion{common.Version{0, 8, 0}, 16}, "creating scan journal table", createScanJournalTable},
+	{schemaVersion{common.Version{0, 8, 0}, 17}, "adding case-insensitive index to value table", createValueNameIndex},
+	{schemaVersion{common.Version{0, 8, 0}, 18}, "creating file tag expiry table", createFileTagExpiryTable},
+	{schemaVersion{common.Version{0, 8, 0}, 19}, "creating statistics table", createStatisticsTable},
+	{schemaVersion{common.Version{0, 8, 0}, 20}, "creating tag journal table", createTagJournalTable},
+	{schemaVersion{common.Version{0, 8, 0}, 21}, "creating bundle table", createBundleTable},
+	{schemaVersion{common.Version{0, 8, 0}, 22}, "adding explicit tag index to file tag table", replaceFileTagFileIndex},
+}
+
+// Brings the database schema up to date by applying, in order, the migrations
+// it lacks. The caller's transaction is rolled back should any fail, so a
+// database is never left partly upgraded.
 func upgrade(tx *sql.Tx) error {
 	version := currentSchemaVersion(tx)
 
-	log.Infof(2, "database schema has version %v, latest schema version is %v", version, latestSchemaVersion)
+	log.Debugf("database schema has version %v, latest schema version is %v", version, latestSchemaVersion)
 
 	if version == latestSchemaVersion {
-		log.Infof(2, "schema is up to date")
+		log.Debugf("schema is up to date")
 		return nil
 	}
 
+	if version.GreaterThan(latestSchemaVersion) {
+		return SchemaTooNewError{version.String(), latestSchemaVersion.String()}
+	}
+
 	noVersion := schemaVersion{}
 	if version == noVersion {
-		log.Infof(2, "creating schema")
+		log.Debugf("creating schema")
 
 		if err := createSchema(tx); err != nil {
 			return err
@@ -44,38 +94,29 @@ func upgrade(tx *sql.Tx) error {
 		// still need to run upgrade as per 0.5.0 database did not store a version
 	}
 
-	log.Infof(2, "upgrading database")
+	log.Debugf("upgrading database")
 
-	if version.LessThan(schemaVersion{common.Version{0, 5, 0}, 0}) {
-		log.Infof(2, "renaming fingerprint algorithm setting")
-
-		if err := renameFingerprintAlgorithmSetting(tx); err != nil {
-			return err
-		}
+	if err := createSchemaVersionTable(tx); err != nil {
+		return err
 	}
-	if version.LessThan(schemaVersion{common.Version{0, 6, 0}, 0}) {
-		log.Infof(2, "recreating implication table")
 
-		if err := recreateImplicationTable(tx); err != nil {
-			return err
+	for _, migration := range migrations {
+		if !version.LessThan(migration.version) {
+			continue
 		}
-	}
-	if version.LessThan(schemaVersion{common.Version{0, 7, 0}, 0}) {
-		log.Infof(2, "updating fingerprint algorithms")
 
-		if err := updateFingerprintAlgorithms(tx); err != nil {
-			return err
+		log.Debugf("migrating schema to version %v: %v", migration.version, migration.description)
+
+		if err := migration.apply(tx); err != nil {
+			return fmt.Errorf("could not migrate database schema to version %v (%v): %v", migration.version, migration.description, err)
 		}
-	}
-	if version.LessThan(schemaVersion{common.Version{0, 7, 0}, 1}) {
-		log.Infof(2, "recreating version table")
 
-		if err := recreateVersionTable(tx); err != nil {
+		if err := recordMigration(tx, migration); err != nil {
 			return err
 		}
 	}
 
-	log.Infof(2, "updating schema version")
+	log.Debugf("updating schema version")
 	if err := updateSchemaVersion(tx, latestSchemaVersion); err != nil {
 		return err
 	}
@@ -106,7 +147,7 @@ RENAME TO implication_old`); err != nil {
 	}
 
 	if _, err := tx.Exec(`
-INSERT INTO implication
+INSERT INTO implication (tag_id, value_id, implied_tag_id, implied_value_id)
 SELECT tag_id, 0, implied_tag_id, 0
 FROM implication_old`); err != nil {
 		return err
@@ -170,9 +211,130 @@ func recreateVersionTable(tx *sql.Tx) error {
 		return err
 	}
 
-	if err := insertSchemaVersion(tx, latestSchemaVersion); err != nil {
+	// updated to the latest version once the remaining migrations are applied
+	if err := insertSchemaVersion(tx, schemaVersion{common.Version{0, 7, 0}, 1}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func addImplicationPattern(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+ALTER TABLE implication
+RENAME TO implication_old`); err != nil {
+		return err
+	}
+
+	if err := createImplicationTable(tx); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`
+INSERT INTO implication (tag_id, value_id, implied_tag_id, implied_value_id)
+SELECT tag_id, value_id, implied_tag_id, implied_value_id
+FROM implication_old`); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`
+DROP TABLE implication_old`); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+func createAndSeedFileTagChangeTable(tx *sql.Tx) error {
+	if err := createFileTagChangeTable(tx); err != nil {
+		return err
+	}
+
+	return seedFileTagChanges(tx)
+}
+
+// Existing file tags are left without an author as who applied them is not
+// known.
+func addFileTagAuthor(tx *sql.Tx) error {
+	if exists, err := columnExists(tx, "file_tag", "author"); err != nil || exists {
+		return err
+	}
+
+	if _, err := tx.Exec(`
+ALTER TABLE file_tag
+ADD COLUMN author TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Existing file tags apply only to the file tagged.
+func addFileTagInherit(tx *sql.Tx) error {
+	if exists, err := columnExists(tx, "file_tag", "inherit"); err != nil || exists {
+		return err
+	}
+
+	if _, err := tx.Exec(`
+ALTER TABLE file_tag
+ADD COLUMN inherit BOOLEAN NOT NULL DEFAULT 0`); err != nil {
+		return err
+	}
+
+	return createFileTagInheritIndex(tx)
+}
+
+// Existing file tags are all explicit. The triggers recording changes to the
+// file tags are recreated so as to skip those implied.
+func addFileTagImplied(tx *sql.Tx) error {
+	exists, err := columnExists(tx, "file_tag", "implied")
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		if _, err := tx.Exec(`
+ALTER TABLE file_tag
+ADD COLUMN implied BOOLEAN NOT NULL DEFAULT 0`); err != nil {
+			return err
+		}
+	}
+
+	for _, trigger := range []string{"file_tag_added", "file_tag_removed", "file_moved", "tag_renamed", "value_renamed", "audit_file_tag_added", "audit_file_tag_removed"} {
+		if _, err := tx.Exec("DROP TRIGGER IF EXISTS " + trigger); err != nil {
+			return err
+		}
+	}
+
+	if err := createFileTagChangeTable(tx); err != nil {
+		return err
+	}
+
+	if err := createAuditTable(tx); err != nil {
+		return err
+	}
+
+	return createFileTagImpliedIndex(tx)
+}
+
+// The index on tag_id is superseded by the covering index, of which it is a
+// prefix.
+func replaceFileTagTagIndex(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+DROP INDEX IF EXISTS idx_file_tag_tag_id`); err != nil {
+		return err
+	}
+
+	return createFileTagCoveringIndex(tx)
+}
+
+// the file ID index duplicates the leading column of the primary key so only
+// slows the changing of file tags
+func replaceFileTagFileIndex(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+DROP INDEX IF EXISTS idx_file_tag_file_id`); err != nil {
+		return err
+	}
+
+	return createFileTagExplicitIndex(tx)
+}
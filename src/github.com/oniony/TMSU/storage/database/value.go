@@ -17,8 +17,10 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"github.com/oniony/TMSU/entities"
 	"strings"
+	"unicode/utf8"
 )
 
 // Retrieves the count of values.
@@ -100,13 +102,25 @@ WHERE id IN (?`
 	return tags, nil
 }
 
-// Retrieves the set of unused values.
-func UnusedValues(tx *Tx) (entities.Values, error) {
+// Retrieves the set of values that are not applied to any file, nor retained
+// for forgotten files or fingerprints. Values referenced by implications are
+// considered used unless ignoreImplications is specified.
+func UnusedValues(tx *Tx, ignoreImplications bool) (entities.Values, error) {
 	sql := `
 SELECT id, name
 FROM value
-WHERE id NOT IN (SELECT distinct(value_id)
-                 FROM file_tag)`
+WHERE id NOT IN (SELECT value_id FROM file_tag)
+  AND id NOT IN (SELECT value_id FROM forgotten_file_tag)
+  AND id NOT IN (SELECT value_id FROM fingerprint_tag)`
+
+	if !ignoreImplications {
+		sql += `
+  AND id NOT IN (SELECT value_id FROM implication)
+  AND id NOT IN (SELECT implied_value_id FROM implication)`
+	}
+
+	sql += `
+ORDER BY name`
 
 	rows, err := tx.Query(sql)
 	if err != nil {
@@ -126,7 +140,7 @@ SELECT id, name
 FROM value
 WHERE name ` + collation + ` = ?`
 
-	rows, err := tx.Query(sql, name)
+	rows, err := tx.QueryPrepared(sql, name)
 	if err != nil {
 		return nil, err
 	}
@@ -181,7 +195,7 @@ SELECT id, name
 FROM value
 WHERE id IN (SELECT value_id
              FROM file_tag
-             WHERE tag_id = ?1)
+             WHERE tag_id = ?1 AND NOT implied)
 ORDER BY name`
 
 	rows, err := tx.Query(sql, tagId)
@@ -193,6 +207,116 @@ ORDER BY name`
 	return readValues(rows, make(entities.Values, 0, 10))
 }
 
+// Retrieves the values whose names begin with the prefix or, if a tag is
+// specified, those of the values for that tag that do.
+func ValuesByPrefix(tx *Tx, tagId entities.TagId, prefix string, ignoreCase bool) (entities.Values, error) {
+	collation := collationFor(ignoreCase)
+
+	// a range rather than LIKE so that the index on the name is used
+	sql := `
+SELECT id, name
+FROM value
+WHERE name` + collation + ` >= ? AND name` + collation + ` < ?`
+
+	params := []interface{}{prefix, prefix + string(utf8.MaxRune)}
+
+	if tagId != 0 {
+		sql += ` AND
+      id IN (SELECT value_id
+             FROM file_tag
+             WHERE tag_id = ? AND NOT implied)`
+
+		params = append(params, tagId)
+	}
+
+	sql += `
+ORDER BY name`
+
+	rows, err := tx.Query(sql, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readValues(rows, make(entities.Values, 0, 10))
+}
+
+// Retrieves the number of files tagged with each value of the tag, ordered by
+// value according to the tag's declared type.
+func ValueFileCountsForTag(tx *Tx, tagId entities.TagId) ([]entities.ValueFileCount, error) {
+	sql := `
+SELECT v.id, v.name, count(1)
+FROM file_tag ft
+INNER JOIN tag t ON t.id = ft.tag_id
+INNER JOIN value v ON v.id = ft.value_id
+WHERE ft.tag_id = ?1 AND NOT ft.implied
+GROUP BY v.id, v.name
+ORDER BY ` + typedValueTerm + `, v.name`
+
+	rows, err := tx.Query(sql, tagId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]entities.ValueFileCount, 0, 10)
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var count entities.ValueFileCount
+		if err := rows.Scan(&count.Id, &count.Name, &count.FileCount); err != nil {
+			return nil, err
+		}
+
+		counts = append(counts, count)
+	}
+
+	return counts, nil
+}
+
+// Calculates the mean of the values applied with the tag, weighted by the
+// number of files tagged with each. The mean is not available unless every
+// value is a number: enumeration values are not considered numbers.
+func ValueMeanForTag(tx *Tx, tagId entities.TagId) (float64, bool, error) {
+	sql := `
+SELECT count(1), total(typeof(number) IN ('integer', 'real')), avg(number)
+FROM (SELECT CASE WHEN (SELECT type FROM tag_definition WHERE tag_id = t.id) = 'enum' THEN v.name
+                  ELSE ` + typedValueTerm + `
+             END AS number
+      FROM file_tag ft
+      INNER JOIN tag t ON t.id = ft.tag_id
+      INNER JOIN value v ON v.id = ft.value_id
+      WHERE ft.tag_id = ?1 AND NOT ft.implied)`
+
+	rows, err := tx.Query(sql, tagId)
+	if err != nil {
+		return 0, false, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return 0, false, errors.New("could not calculate mean")
+	}
+	if rows.Err() != nil {
+		return 0, false, rows.Err()
+	}
+
+	var count uint
+	var numberCount float64
+	var mean *float64
+	if err := rows.Scan(&count, &numberCount, &mean); err != nil {
+		return 0, false, err
+	}
+
+	if count == 0 || uint(numberCount) != count || mean == nil {
+		return 0, false, nil
+	}
+
+	return *mean, true, nil
+}
+
 // Adds a value.
 func InsertValue(tx *Tx, name string) (*entities.Value, error) {
 	sql := `
@@ -0,0 +1,84 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"github.com/oniony/TMSU/entities"
+	"time"
+)
+
+// Retrieves the outcome of the most recent verification of each verified file.
+func Verifications(tx *Tx) (entities.Verifications, error) {
+	sql := `
+SELECT file_id, verified_at, passed
+FROM file_verification`
+
+	rows, err := tx.Query(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	verifications := make(entities.Verifications)
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var fileId entities.FileId
+		var verifiedAt time.Time
+		var passed bool
+		if err := rows.Scan(&fileId, &verifiedAt, &passed); err != nil {
+			return nil, err
+		}
+
+		verifications[fileId] = &entities.Verification{fileId, verifiedAt, passed}
+	}
+
+	return verifications, nil
+}
+
+// Records the outcome of a verification of a file, replacing any previous.
+func UpdateVerification(tx *Tx, fileId entities.FileId, verifiedAt time.Time, passed bool) error {
+	sql := `
+INSERT OR REPLACE INTO file_verification (file_id, verified_at, passed)
+VALUES (?, ?, ?)`
+
+	result, err := tx.Exec(sql, fileId, verifiedAt, passed)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected != 1 {
+		panic("expected exactly one row to be affected.")
+	}
+
+	return nil
+}
+
+// Removes the verification state of a file.
+func DeleteVerification(tx *Tx, fileId entities.FileId) error {
+	sql := `
+DELETE FROM file_verification
+WHERE file_id = ?`
+
+	_, err := tx.Exec(sql, fileId)
+	return err
+}
@@ -0,0 +1,115 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"database/sql"
+	"github.com/oniony/TMSU/entities"
+)
+
+// The complete set of controlled vocabularies.
+func Vocabularies(tx *Tx) (entities.Vocabularies, error) {
+	sql := `
+SELECT tag_id, name, canonical
+FROM vocabulary_term
+ORDER BY tag_id, canonical, name`
+
+	rows, err := tx.Query(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readVocabularies(rows, make(entities.Vocabularies, 0, 10))
+}
+
+// Retrieves the controlled vocabulary of the specified tag, or nil if it has none.
+func Vocabulary(tx *Tx, tagId entities.TagId) (*entities.Vocabulary, error) {
+	sql := `
+SELECT tag_id, name, canonical
+FROM vocabulary_term
+WHERE tag_id = ?
+ORDER BY canonical, name`
+
+	rows, err := tx.Query(sql, tagId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	vocabularies, err := readVocabularies(rows, make(entities.Vocabularies, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+
+	return vocabularies.ForTag(tagId), nil
+}
+
+// Replaces the controlled vocabulary of a tag.
+func UpdateVocabulary(tx *Tx, tagId entities.TagId, terms []entities.VocabularyTerm) (*entities.Vocabulary, error) {
+	if err := DeleteVocabulary(tx, tagId); err != nil {
+		return nil, err
+	}
+
+	sql := `
+INSERT INTO vocabulary_term (tag_id, name, canonical)
+VALUES (?, ?, ?)`
+
+	for _, term := range terms {
+		if _, err := tx.Exec(sql, tagId, term.Name, term.Canonical); err != nil {
+			return nil, err
+		}
+	}
+
+	return &entities.Vocabulary{tagId, terms}, nil
+}
+
+// Deletes the controlled vocabulary of a tag.
+func DeleteVocabulary(tx *Tx, tagId entities.TagId) error {
+	sql := `
+DELETE FROM vocabulary_term
+WHERE tag_id = ?`
+
+	_, err := tx.Exec(sql, tagId)
+	return err
+}
+
+// unexported
+
+func readVocabularies(rows *sql.Rows, vocabularies entities.Vocabularies) (entities.Vocabularies, error) {
+	var vocabulary *entities.Vocabulary
+
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var tagId entities.TagId
+		var name, canonical string
+		if err := rows.Scan(&tagId, &name, &canonical); err != nil {
+			return nil, err
+		}
+
+		if vocabulary == nil || vocabulary.TagId != tagId {
+			vocabulary = &entities.Vocabulary{tagId, make([]entities.VocabularyTerm, 0, 10)}
+			vocabularies = append(vocabularies, vocabulary)
+		}
+
+		vocabulary.Terms = append(vocabulary.Terms, entities.VocabularyTerm{name, canonical})
+	}
+
+	return vocabularies, nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package database
+
+import (
+	"database/sql"
+	"github.com/oniony/TMSU/entities"
+)
+
+// The prefix of the stored directory of a file on a volume, which is followed by
+// the volume's id and the directory relative to the volume's root, e.g.
+// 'volume:2/photos'.
+const VolumePathPrefix = "volume:"
+
+// Retrieves the volumes, ordered by name.
+func Volumes(tx *Tx) (entities.Volumes, error) {
+	sql := `
+SELECT id, name, uuid, path
+FROM volume
+ORDER BY name`
+
+	rows, err := tx.Query(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return readVolumes(rows, make(entities.Volumes, 0, 10))
+}
+
+// Adds a volume.
+func InsertVolume(tx *Tx, name, uuid, path string) (*entities.Volume, error) {
+	sql := `
+INSERT INTO volume (name, uuid, path)
+VALUES (?, ?, ?)`
+
+	result, err := tx.Exec(sql, name, uuid, path)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return &entities.Volume{entities.VolumeId(id), name, uuid, path}, nil
+}
+
+// Updates the path at which a volume was last mounted.
+func UpdateVolumePath(tx *Tx, id entities.VolumeId, path string) error {
+	sql := `
+UPDATE volume
+SET path = ?
+WHERE id = ?`
+
+	_, err := tx.Exec(sql, path, id)
+	return err
+}
+
+// Removes a volume.
+func DeleteVolume(tx *Tx, id entities.VolumeId) error {
+	sql := `
+DELETE FROM volume
+WHERE id = ?`
+
+	result, err := tx.Exec(sql, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return NoSuchVolumeError{id}
+	}
+
+	return nil
+}
+
+// unexported
+
+func readVolumes(rows *sql.Rows, volumes entities.Volumes) (entities.Volumes, error) {
+	for rows.Next() {
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+
+		var id entities.VolumeId
+		var name, uuid, path string
+		if err := rows.Scan(&id, &name, &uuid, &path); err != nil {
+			return nil, err
+		}
+
+		volumes = append(volumes, &entities.Volume{id, name, uuid, path})
+	}
+
+	return volumes, nil
+}
@@ -18,6 +18,7 @@ package storage
 import (
 	"fmt"
 	"github.com/oniony/TMSU/entities"
+	"strings"
 )
 
 type AbsolutePathResolutionError struct {
@@ -38,3 +39,26 @@ type FileTagDoesNotExist struct {
 func (err FileTagDoesNotExist) Error() string {
 	return fmt.Sprintf("File-tag for file #%v, tag #%v and value #%v does not exist", err.FileId, err.TagId, err.ValueId)
 }
+
+type UnknownTermError struct {
+	ValueName   string
+	Suggestions []string
+}
+
+func (err UnknownTermError) Error() string {
+	message := fmt.Sprintf("'%v' is not in the vocabulary", err.ValueName)
+	if len(err.Suggestions) > 0 {
+		message += fmt.Sprintf(": did you mean '%v'?", strings.Join(err.Suggestions, "', '"))
+	}
+
+	return message
+}
+
+type TagPermissionError struct {
+	TagName  string
+	UserName string
+}
+
+func (err TagPermissionError) Error() string {
+	return fmt.Sprintf("user '%v' is not permitted to apply or remove tag '%v'", err.UserName, err.TagName)
+}
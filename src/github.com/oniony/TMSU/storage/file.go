@@ -40,10 +40,16 @@ func (store *Storage) Files(tx *Tx, sort string) (entities.Files, error) {
 }
 
 // Retrieves a specific file.
+//
+// Files under excluded paths are not returned.
 func (store *Storage) File(tx *Tx, id entities.FileId) (*entities.File, error) {
 	file, err := database.File(tx.tx, id)
 	store.absPath(file)
 
+	if file != nil && store.isExcluded(file.Path()) {
+		return nil, err
+	}
+
 	return file, err
 }
 
@@ -57,12 +63,26 @@ func (store *Storage) FileByPath(tx *Tx, path string) (*entities.File, error) {
 	return file, err
 }
 
+// Retrieves the files with the specified paths, in no particular order. Paths
+// that are not in the database are ignored.
+func (store *Storage) FilesByPaths(tx *Tx, paths []string) (entities.Files, error) {
+	relPaths := make([]string, len(paths))
+	for index, path := range paths {
+		relPaths[index] = store.relPath(path)
+	}
+
+	files, err := database.FilesByPaths(tx.tx, relPaths)
+	store.absPaths(files)
+
+	return files, err
+}
+
 // Retrieves all files that are under the specified directory.
 func (store *Storage) FilesByDirectory(tx *Tx, path string) (entities.Files, error) {
 	relPath := store.relPath(path)
 	pathContainsRoot := store.pathContainsRoot(relPath)
 
-	files, err := database.FilesByDirectory(tx.tx, relPath, pathContainsRoot)
+	files, err := database.FilesByDirectory(tx.tx, relPath, pathContainsRoot, store.volumePathsUnder(path))
 	store.absPaths(files)
 
 	return files, err
@@ -76,7 +96,7 @@ func (store *Storage) FilesByDirectories(tx *Tx, paths []string) (entities.Files
 		relPath := store.relPath(path)
 		pathContainsRoot := store.pathContainsRoot(relPath)
 
-		pathFiles, err := database.FilesByDirectory(tx.tx, relPath, pathContainsRoot)
+		pathFiles, err := database.FilesByDirectory(tx.tx, relPath, pathContainsRoot, store.volumePathsUnder(path))
 		if err != nil {
 			return nil, fmt.Errorf("'%v': could not retrieve files for directory: %v", path, err)
 		}
@@ -89,6 +109,13 @@ func (store *Storage) FilesByDirectories(tx *Tx, paths []string) (entities.Files
 	return files, nil
 }
 
+// Retrieves the names of the files directly within the specified directory
+// that have at least one tag applied, so that the directory's contents can be
+// checked without a query per file.
+func (store *Storage) TaggedFileNamesByDirectory(tx *Tx, path string) (map[string]bool, error) {
+	return database.TaggedFileNamesByDirectory(tx.tx, store.relPath(path))
+}
+
 // Retrieves the number of files with the specified fingerprint.
 func (store *Storage) FileCountByFingerprint(tx *Tx, fingerprint fingerprint.Fingerprint) (uint, error) {
 	return database.FileCountByFingerprint(tx.tx, fingerprint)
@@ -108,48 +135,155 @@ func (store *Storage) UntaggedFiles(tx *Tx) (entities.Files, error) {
 	return files, err
 }
 
-// Retrieves the count of files that match the specified query and matching the specified path.
-func (store *Storage) FileCountForQuery(tx *Tx, expression query.Expression, path string, explicitOnly, ignoreCase bool) (uint, error) {
+// Retrieves the count of files that match the specified query and matching the
+// specified path, optionally only the directories or only the other files.
+func (store *Storage) FileCountForQuery(tx *Tx, expression query.Expression, path string, dirOnly, fileOnly, explicitOnly, ignoreCase bool) (uint, error) {
+	if err := store.checkContentIndexed(tx, expression); err != nil {
+		return 0, err
+	}
+
+	expression, ignoreCase, err := store.NormalizeQuery(tx, expression, ignoreCase)
+	if err != nil {
+		return 0, err
+	}
+
+	// the files related to are looked up by their stored paths
+	expression = query.MapPaths(expression, store.relPath)
+
 	relPath := store.relPath(path)
 
 	pathContainsRoot := store.pathContainsRoot(relPath)
 
-	return database.FileCountForQuery(tx.tx, expression, relPath, pathContainsRoot, explicitOnly, ignoreCase)
+	excludedPaths, excludedPathsContainRoot := store.relExcludedPaths()
+
+	return database.FileCountForQuery(tx.tx, expression, relPath, pathContainsRoot, store.volumePathsUnder(path), excludedPaths, excludedPathsContainRoot, dirOnly, fileOnly, explicitOnly, ignoreCase)
 }
 
 // Retrieves the set of files that match the specified query.
 func (store *Storage) FilesForQuery(tx *Tx, expression query.Expression, path string, explicitOnly, ignoreCase bool, sort string) (entities.Files, error) {
+	files := make(entities.Files, 0, 10)
+
+	err := store.EachFileForQuery(tx, expression, path, explicitOnly, ignoreCase, sort, func(file *entities.File) error {
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// Passes each of the files that match the specified query to the specified
+// function as it is read from the database, so that large result sets can be
+// processed in constant memory.
+func (store *Storage) EachFileForQuery(tx *Tx, expression query.Expression, path string, explicitOnly, ignoreCase bool, sort string, fileFunc func(*entities.File) error) error {
+	if err := store.checkContentIndexed(tx, expression); err != nil {
+		return err
+	}
+
+	expression, ignoreCase, err := store.NormalizeQuery(tx, expression, ignoreCase)
+	if err != nil {
+		return err
+	}
+
+	expression = query.MapPaths(expression, store.relPath)
+
 	relPath := store.relPath(path)
 
 	pathContainsRoot := store.pathContainsRoot(relPath)
 
-	files, err := database.FilesForQuery(tx.tx, expression, relPath, pathContainsRoot, explicitOnly, ignoreCase, sort)
-	store.absPaths(files)
-	return files, err
+	excludedPaths, excludedPathsContainRoot := store.relExcludedPaths()
+
+	return database.EachFileForQuery(tx.tx, expression, relPath, pathContainsRoot, store.volumePathsUnder(path), excludedPaths, excludedPathsContainRoot, explicitOnly, ignoreCase, sort, func(file *entities.File) error {
+		store.absPath(file)
+		return fileFunc(file)
+	})
 }
 
-// Retrieves the sets of duplicate files within the database.
-func (store *Storage) DuplicateFiles(tx *Tx) ([]entities.Files, error) {
-	fileSets, err := database.DuplicateFiles(tx.tx)
+// Explains how the query for the files that match the specified query is run
+// and times it.
+func (store *Storage) ExplainQuery(tx *Tx, expression query.Expression, path string, explicitOnly, ignoreCase bool, sort string) (*entities.QueryExplanation, error) {
+	if err := store.checkContentIndexed(tx, expression); err != nil {
+		return nil, err
+	}
 
-	for _, fileSet := range fileSets {
-		store.absPaths(fileSet)
+	expression, ignoreCase, err := store.NormalizeQuery(tx, expression, ignoreCase)
+	if err != nil {
+		return nil, err
 	}
 
-	return fileSets, err
+	expression = query.MapPaths(expression, store.relPath)
+
+	relPath := store.relPath(path)
+
+	pathContainsRoot := store.pathContainsRoot(relPath)
+
+	excludedPaths, excludedPathsContainRoot := store.relExcludedPaths()
+
+	return database.ExplainQuery(tx.tx, expression, relPath, pathContainsRoot, store.volumePathsUnder(path), excludedPaths, excludedPathsContainRoot, explicitOnly, ignoreCase, sort)
+}
+
+// Retrieves the sets of duplicate files within the database, optionally
+// restricted to those under the specified path and of at least the specified
+// size. Each set is passed to the specified function as it is read.
+func (store *Storage) DuplicateFiles(tx *Tx, path string, minSize int64, fileSetFunc func(entities.Files) error) error {
+	relPath := store.relPath(path)
+	pathContainsRoot := store.pathContainsRoot(relPath)
+
+	return database.DuplicateFiles(tx.tx, relPath, pathContainsRoot, store.volumePathsUnder(path), minSize, func(fileSet entities.Files) error {
+		store.absPaths(fileSet)
+		return fileSetFunc(fileSet)
+	})
+}
+
+// Retrieves the number of fingerprinted files of the specified size.
+func (store *Storage) FileCountBySize(tx *Tx, size int64) (uint, error) {
+	return database.FileCountBySize(tx.tx, size)
 }
 
 // Adds a file to the database.
 func (store *Storage) AddFile(tx *Tx, path string, fingerprint fingerprint.Fingerprint, modTime time.Time, size int64, isDir bool) (*entities.File, error) {
 	relPath := store.relPath(path)
 	file, err := database.InsertFile(tx.tx, relPath, fingerprint, modTime, size, isDir)
+	if err != nil {
+		return nil, err
+	}
+
 	store.absPath(file)
 
-	return file, err
+	if fingerprint != "" {
+		// the file may have been tagged by fingerprint before it was added
+		if _, err := database.AttachFingerprintTags(tx.tx, fingerprint, file.Id); err != nil {
+			return nil, err
+		}
+
+		if err := store.refreshImpliedFileTags(tx, file.Id); err != nil {
+			return nil, err
+		}
+	}
+
+	return file, nil
 }
 
 // Updates a file in the database.
 func (store *Storage) UpdateFile(tx *Tx, fileId entities.FileId, path string, fingerprint fingerprint.Fingerprint, modTime time.Time, size int64, isDir bool) (*entities.File, error) {
+	// the file's contents may have changed so its perceptual hash must be recalculated
+	if err := database.DeletePerceptualHash(tx.tx, fileId); err != nil {
+		return nil, err
+	}
+
+	// a verification no longer applies once a new fingerprint is accepted
+	existing, err := database.File(tx.tx, fileId)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil && existing.Fingerprint != fingerprint {
+		if err := database.DeleteVerification(tx.tx, fileId); err != nil {
+			return nil, err
+		}
+	}
+
 	relPath := store.relPath(path)
 	file, err := database.UpdateFile(tx.tx, fileId, relPath, fingerprint, modTime, size, isDir)
 	store.absPath(file)
@@ -157,6 +291,45 @@ func (store *Storage) UpdateFile(tx *Tx, fileId entities.FileId, path string, fi
 	return file, err
 }
 
+// Rewrites the paths of the file at fromPath and of the files beneath it so
+// that they are at toPath instead, e.g. when a volume is mounted elsewhere. The
+// files themselves are not examined. The relocated files are returned.
+func (store *Storage) RelocateFiles(tx *Tx, fromPath, toPath string) (entities.Files, error) {
+	files := make(entities.Files, 0, 10)
+
+	file, err := store.FileByPath(tx, fromPath)
+	if err != nil {
+		return nil, err
+	}
+	if file != nil {
+		files = append(files, file)
+	}
+
+	filesBeneath, err := store.FilesByDirectory(tx, fromPath)
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, filesBeneath...)
+
+	for _, file := range files {
+		relPath, err := filepath.Rel(fromPath, file.Path())
+		if err != nil {
+			return nil, err
+		}
+
+		path := filepath.Join(toPath, relPath)
+
+		if err := database.UpdateFilePath(tx.tx, file.Id, store.relPath(path)); err != nil {
+			return nil, fmt.Errorf("could not relocate '%v' to '%v': %v", file.Path(), path, err)
+		}
+
+		file.Directory, file.Name = filepath.Split(path)
+		file.Directory = filepath.Clean(file.Directory)
+	}
+
+	return files, nil
+}
+
 // Deletes a file from the database.
 func (store *Storage) DeleteFile(tx *Tx, fileId entities.FileId) error {
 	return database.DeleteFile(tx.tx, fileId)
@@ -182,14 +355,46 @@ func (store *Storage) DeleteUntaggedFiles(tx *Tx, fileIds entities.FileIds) erro
 	return database.DeleteUntaggedFiles(tx.tx, fileIds)
 }
 
+// The path of the file at the absolute path as stored: relative to the root,
+// or to the volume it is on, where possible, so that the same file can be
+// identified in another database.
+func (store *Storage) StoredPath(path string) string {
+	return store.relPath(path)
+}
+
+// The absolute path of a path as stored, such as by another database.
+func (store *Storage) AbsStoredPath(path string) string {
+	return store.absStoredPath(path)
+}
+
 // unexported
 
+// The path as stored: relative to the root where possible and with forward
+// slashes on all platforms.
 func (store *Storage) relPath(path string) string {
 	if path == "" {
 		return "" // don't alter empty paths
 	}
 
-	return _path.RelTo(path, store.RootPath)
+	if volumePath := store.volumeRelPath(path); volumePath != "" {
+		return volumePath
+	}
+
+	return filepath.ToSlash(_path.RelTo(path, store.RootPath))
+}
+
+// The absolute path of a stored path.
+func (store *Storage) absStoredPath(path string) string {
+	if volumePath := store.volumeAbsPath(path); volumePath != "" {
+		return volumePath
+	}
+
+	path = filepath.FromSlash(path)
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(store.RootPath, path)
 }
 
 func (store *Storage) absPaths(files entities.Files) {
@@ -199,27 +404,53 @@ func (store *Storage) absPaths(files entities.Files) {
 }
 
 func (store *Storage) absPath(file *entities.File) {
-	if file == nil || file.Directory == "" || file.Directory[0] == filepath.Separator {
+	if file == nil || file.Directory == "" {
 		return
 	}
 
-	file.Directory = filepath.Join(store.RootPath, file.Directory)
+	file.Directory = store.absStoredPath(file.Directory)
+}
+
+func (store *Storage) relExcludedPaths() ([]string, bool) {
+	if len(store.excludedPaths) == 0 {
+		return nil, false
+	}
+
+	relPaths := make([]string, len(store.excludedPaths))
+	containsRoot := false
+
+	for index, path := range store.excludedPaths {
+		relPaths[index] = store.relPath(path)
+		containsRoot = containsRoot || store.pathContainsRoot(relPaths[index])
+	}
+
+	return relPaths, containsRoot
+}
+
+func (store *Storage) isExcluded(path string) bool {
+	for _, excludedPath := range store.excludedPaths {
+		if _path.Equal(path, excludedPath) || _path.HasPrefix(path, excludedPath+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (store *Storage) pathContainsRoot(path string) bool {
-	if !filepath.IsAbs(path) {
+	if !filepath.IsAbs(filepath.FromSlash(path)) {
 		return false
 	}
 
-	path = filepath.Clean(path)
+	path = filepath.Clean(filepath.FromSlash(path))
 	checkPath := store.RootPath
 	file := ""
 
 	for {
-		if checkPath == path {
+		if _path.Equal(checkPath, path) {
 			return true
 		}
-		if checkPath == string(filepath.Separator) && file == "" {
+		if _path.IsRoot(checkPath) && file == "" {
 			return false
 		}
 
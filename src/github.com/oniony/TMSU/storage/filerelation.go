@@ -0,0 +1,36 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage/database"
+)
+
+// Retrieves the relations from and to the specified file.
+func (store *Storage) FileRelationsByFileId(tx *Tx, fileId entities.FileId) (entities.FileRelations, error) {
+	return database.FileRelationsByFileId(tx.tx, fileId)
+}
+
+// Relates one file to another.
+func (store *Storage) AddFileRelation(tx *Tx, fileId, relatedFileId entities.FileId, relation string) (*entities.FileRelation, error) {
+	return database.AddFileRelation(tx.tx, fileId, relatedFileId, relation)
+}
+
+// Removes a relation between files.
+func (store *Storage) DeleteFileRelation(tx *Tx, fileId, relatedFileId entities.FileId, relation string) error {
+	return database.DeleteFileRelation(tx.tx, fileId, relatedFileId, relation)
+}
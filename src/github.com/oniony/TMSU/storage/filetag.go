@@ -18,6 +18,8 @@ package storage
 import (
 	"github.com/oniony/TMSU/entities"
 	"github.com/oniony/TMSU/storage/database"
+	slashpath "path"
+	"path/filepath"
 )
 
 // Determines whether the specified file has the specified tag applied.
@@ -43,6 +45,17 @@ func (storage *Storage) FileTagCount(tx *Tx) (uint, error) {
 	return database.FileTagCount(tx.tx)
 }
 
+// Retrieves the number of file tags applied by each author.
+func (storage *Storage) FileTagCountsByAuthor(tx *Tx) ([]entities.AuthorFileTagCount, error) {
+	return database.FileTagCountsByAuthor(tx.tx)
+}
+
+// Retrieves the IDs of the files with file tags applied by the specified
+// author.
+func (storage *Storage) FileIdsTaggedBy(tx *Tx, author string) (entities.FileIds, error) {
+	return database.FileIdsTaggedBy(tx.tx, author)
+}
+
 // Retrieves the complete set of file tags.
 func (storage *Storage) FileTags(tx *Tx) (entities.FileTags, error) {
 	return database.FileTags(tx.tx)
@@ -112,7 +125,17 @@ func (storage *Storage) FileTagsByFileId(tx *Tx, fileId entities.FileId, explici
 	}
 
 	if !explicitOnly {
-		var err error
+		file, err := database.File(tx.tx, fileId)
+		if err != nil {
+			return nil, err
+		}
+		if file != nil {
+			fileTags, err = storage.addInheritedFileTags(tx, fileTags, fileId, file.Directory)
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		fileTags, err = storage.addImpliedFileTags(tx, fileTags)
 		if err != nil {
 			return nil, err
@@ -122,9 +145,90 @@ func (storage *Storage) FileTagsByFileId(tx *Tx, fileId entities.FileId, explici
 	return fileTags, nil
 }
 
+// Retrieves the file tags for the specified files, by file ID, looking up the
+// tags they inherit and those implied once for each directory and tag rather
+// than for each file.
+func (storage *Storage) FileTagsByFileIds(tx *Tx, files entities.Files, explicitOnly bool) (map[entities.FileId]entities.FileTags, error) {
+	fileIds := make(entities.FileIds, len(files))
+	for index, file := range files {
+		fileIds[index] = file.Id
+	}
+
+	fileTags, err := database.FileTagsByFileIds(tx.tx, fileIds)
+	if err != nil {
+		return nil, err
+	}
+
+	fileTagsByFileId := make(map[entities.FileId]entities.FileTags, len(files))
+	for _, fileTag := range fileTags {
+		fileTagsByFileId[fileTag.FileId] = append(fileTagsByFileId[fileTag.FileId], fileTag)
+	}
+
+	if explicitOnly {
+		return fileTagsByFileId, nil
+	}
+
+	inherited := make(map[string]entities.TagIdValueIdPairs)
+	implications := make(implicationCache)
+
+	for _, file := range files {
+		pairs, ok := inherited[file.Directory]
+		if !ok {
+			pairs, err = database.InheritedTagValuePairs(tx.tx, storage.storedDirectory(file.Directory))
+			if err != nil {
+				return nil, err
+			}
+
+			inherited[file.Directory] = pairs
+		}
+
+		fileTags := addInheritedPairs(fileTagsByFileId[file.Id], file.Id, pairs)
+
+		fileTags, err = storage.addCachedImpliedFileTags(tx, fileTags, implications)
+		if err != nil {
+			return nil, err
+		}
+
+		fileTagsByFileId[file.Id] = fileTags
+	}
+
+	return fileTagsByFileId, nil
+}
+
+// Retrieves the file tags that a file at the specified path, which need not be
+// in the database, inherits from the directories above it, together with those
+// these imply.
+func (storage *Storage) InheritedFileTags(tx *Tx, path string) (entities.FileTags, error) {
+	fileTags, err := storage.addInheritedFileTags(tx, entities.FileTags{}, 0, storage.storedDirectory(filepath.Dir(path)))
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.addImpliedFileTags(tx, fileTags)
+}
+
+// Sets whether a directory's file tag is inherited by the directory's contents,
+// including those added to the directory later.
+func (storage *Storage) SetFileTagInheritance(tx *Tx, fileId entities.FileId, tagId entities.TagId, valueId entities.ValueId, inherit bool) error {
+	return database.SetFileTagInheritance(tx.tx, fileId, tagId, valueId, inherit)
+}
+
 // Adds a file tag.
 func (storage *Storage) AddFileTag(tx *Tx, fileId entities.FileId, tagId entities.TagId, valueId entities.ValueId) (*entities.FileTag, error) {
-	return database.AddFileTag(tx.tx, fileId, tagId, valueId)
+	if err := storage.checkTagPermissions(tx, tagId); err != nil {
+		return nil, err
+	}
+
+	fileTag, err := database.AddFileTag(tx.tx, fileId, tagId, valueId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := storage.refreshImpliedFileTags(tx, fileId); err != nil {
+		return nil, err
+	}
+
+	return fileTag, nil
 }
 
 // Delete file tag.
@@ -137,10 +241,18 @@ func (storage *Storage) DeleteFileTag(tx *Tx, fileId entities.FileId, tagId enti
 		return FileTagDoesNotExist{fileId, tagId, valueId}
 	}
 
+	if err := storage.checkTagPermissions(tx, tagId); err != nil {
+		return err
+	}
+
 	if err := database.DeleteFileTag(tx.tx, fileId, tagId, valueId); err != nil {
 		return err
 	}
 
+	if err := storage.refreshImpliedFileTags(tx, fileId); err != nil {
+		return err
+	}
+
 	if err := storage.DeleteFileIfUntagged(tx, fileId); err != nil {
 		return err
 	}
@@ -150,6 +262,15 @@ func (storage *Storage) DeleteFileTag(tx *Tx, fileId entities.FileId, tagId enti
 
 // Deletes all of the file tags for the specified file.
 func (storage *Storage) DeleteFileTagsByFileId(tx *Tx, fileId entities.FileId) error {
+	fileTags, err := database.FileTagsByFileId(tx.tx, fileId)
+	if err != nil {
+		return err
+	}
+
+	if err := storage.checkTagPermissions(tx, fileTags.TagIds()...); err != nil {
+		return err
+	}
+
 	if err := database.DeleteFileTagsByFileId(tx.tx, fileId); err != nil {
 		return err
 	}
@@ -163,6 +284,10 @@ func (storage *Storage) DeleteFileTagsByFileId(tx *Tx, fileId entities.FileId) e
 
 // Deletes all of the file tags for the specified tag.
 func (storage *Storage) DeleteFileTagsByTagId(tx *Tx, tagId entities.TagId) error {
+	if err := storage.checkTagPermissions(tx, tagId); err != nil {
+		return err
+	}
+
 	fileTags, err := database.FileTagsByTagId(tx.tx, tagId)
 	if err != nil {
 		return err
@@ -172,6 +297,10 @@ func (storage *Storage) DeleteFileTagsByTagId(tx *Tx, tagId entities.TagId) erro
 		return err
 	}
 
+	if err := storage.refreshImpliedFileTags(tx, 0); err != nil {
+		return err
+	}
+
 	if err := storage.DeleteUntaggedFiles(tx, fileTags.FileIds()); err != nil {
 		return err
 	}
@@ -186,10 +315,18 @@ func (storage *Storage) DeleteFileTagsByValueId(tx *Tx, valueId entities.ValueId
 		return err
 	}
 
+	if err := storage.checkTagPermissions(tx, fileTags.TagIds()...); err != nil {
+		return err
+	}
+
 	if err := database.DeleteFileTagsByValueId(tx.tx, valueId); err != nil {
 		return err
 	}
 
+	if err := storage.refreshImpliedFileTags(tx, 0); err != nil {
+		return err
+	}
+
 	if err := storage.DeleteUntaggedFiles(tx, fileTags.FileIds()); err != nil {
 		return err
 	}
@@ -199,19 +336,108 @@ func (storage *Storage) DeleteFileTagsByValueId(tx *Tx, valueId entities.ValueId
 
 // Copies file tags from one tag to another.
 func (storage *Storage) CopyFileTags(tx *Tx, sourceTagId, destTagId entities.TagId) error {
-	return database.CopyFileTags(tx.tx, sourceTagId, destTagId)
+	if err := storage.checkTagPermissions(tx, destTagId); err != nil {
+		return err
+	}
+
+	// the copies would otherwise collide with file tags implied of the destination tag
+	if err := database.DeleteImpliedFileTags(tx.tx, 0); err != nil {
+		return err
+	}
+
+	if err := database.CopyFileTags(tx.tx, sourceTagId, destTagId); err != nil {
+		return err
+	}
+
+	return storage.refreshImpliedFileTags(tx, 0)
+}
+
+// Rebuilds the implied file tags materialised for every file, so that they
+// reflect the current implications, returning the number materialised. Should
+// the 'materializeImplications' setting be disabled the implied file tags are
+// instead removed.
+func (storage *Storage) RematerializeImplications(tx *Tx) (uint, error) {
+	if err := database.DeleteImpliedFileTags(tx.tx, 0); err != nil {
+		return 0, err
+	}
+
+	materializing, err := storage.materializingImplications(tx)
+	if err != nil || !materializing {
+		return 0, err
+	}
+
+	return database.AddImpliedFileTags(tx.tx, 0)
 }
 
 // unexported
 
+// The directory as stored as that of the files within it, e.g. 'dir' rather
+// than './dir'.
+func (storage *Storage) storedDirectory(directory string) string {
+	return slashpath.Clean(storage.relPath(directory))
+}
+
+func (storage *Storage) addInheritedFileTags(tx *Tx, fileTags entities.FileTags, fileId entities.FileId, directory string) (entities.FileTags, error) {
+	pairs, err := database.InheritedTagValuePairs(tx.tx, directory)
+	if err != nil {
+		return nil, err
+	}
+
+	return addInheritedPairs(fileTags, fileId, pairs), nil
+}
+
+func addInheritedPairs(fileTags entities.FileTags, fileId entities.FileId, pairs entities.TagIdValueIdPairs) entities.FileTags {
+	for _, pair := range pairs {
+		predicate := func(ft entities.FileTag) bool {
+			return ft.TagId == pair.TagId && ft.ValueId == pair.ValueId
+		}
+
+		if fileTags.Where(predicate).Single() == nil {
+			fileTags = append(fileTags, &entities.FileTag{fileId, pair.TagId, pair.ValueId, false, true})
+		}
+	}
+
+	return fileTags
+}
+
+// Rebuilds the implied file tags materialised for the specified file, or for
+// every file should the file ID be zero, when implications are materialised.
+func (storage *Storage) refreshImpliedFileTags(tx *Tx, fileId entities.FileId) error {
+	materializing, err := storage.materializingImplications(tx)
+	if err != nil || !materializing {
+		return err
+	}
+
+	if err := database.DeleteImpliedFileTags(tx.tx, fileId); err != nil {
+		return err
+	}
+
+	_, err = database.AddImpliedFileTags(tx.tx, fileId)
+	return err
+}
+
 func (storage *Storage) addImpliedFileTags(tx *Tx, fileTags entities.FileTags) (entities.FileTags, error) {
+	return storage.addCachedImpliedFileTags(tx, fileTags, make(implicationCache))
+}
+
+// The implications of each tag and value pair already looked up.
+type implicationCache map[entities.TagIdValueIdPair]entities.Implications
+
+func (storage *Storage) addCachedImpliedFileTags(tx *Tx, fileTags entities.FileTags, cache implicationCache) (entities.FileTags, error) {
 	// WARN: this cannot use 'range' as fileTags is expanded within the loop
 	for index := 0; index < len(fileTags); index++ {
 		fileTag := fileTags[index]
 
-		implications, err := storage.ImplicationsFor(tx, fileTag.ToTagIdValueIdPair())
-		if err != nil {
-			return nil, err
+		pair := fileTag.ToTagIdValueIdPair()
+		implications, ok := cache[pair]
+		if !ok {
+			var err error
+			implications, err = storage.ImplicationsFor(tx, pair)
+			if err != nil {
+				return nil, err
+			}
+
+			cache[pair] = implications
 		}
 
 		for _, implication := range implications {
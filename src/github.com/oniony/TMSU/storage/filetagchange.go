@@ -0,0 +1,42 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage/database"
+	"time"
+)
+
+// Retrieves the most recent change to each file tag.
+func (store *Storage) FileTagChanges(tx *Tx) (entities.FileTagChanges, error) {
+	return database.FileTagChanges(tx.tx)
+}
+
+// Retrieves the changes made at or after the specified time, oldest first.
+func (store *Storage) FileTagChangesSince(tx *Tx, since time.Time) (entities.FileTagChanges, error) {
+	return database.FileTagChangesSince(tx.tx, since)
+}
+
+// Records a change to a file tag, such as one received from another database.
+func (store *Storage) UpdateFileTagChange(tx *Tx, change entities.FileTagChange) error {
+	return database.UpdateFileTagChange(tx.tx, change)
+}
+
+// The absolute path of the file a change applies to.
+func (store *Storage) FileTagChangePath(change entities.FileTagChange) string {
+	return store.absStoredPath(change.Path())
+}
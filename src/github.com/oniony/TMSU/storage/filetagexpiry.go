@@ -0,0 +1,33 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage/database"
+	"time"
+)
+
+// Retrieves the expiries of the file tags, soonest first.
+func (store *Storage) FileTagExpiries(tx *Tx) (entities.FileTagExpiries, error) {
+	return database.FileTagExpiries(tx.tx)
+}
+
+// Sets when an explicitly applied file tag expires. Returns false if the file
+// tag is not explicitly applied.
+func (store *Storage) SetFileTagExpiry(tx *Tx, fileId entities.FileId, tagId entities.TagId, valueId entities.ValueId, expiresAt time.Time) (bool, error) {
+	return database.SetFileTagExpiry(tx.tx, fileId, tagId, valueId, expiresAt)
+}
@@ -0,0 +1,44 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"github.com/oniony/TMSU/common/fingerprint"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage/database"
+)
+
+// Retrieves the taggings of files not yet in the database, by fingerprint.
+func (store *Storage) FingerprintTags(tx *Tx) (entities.FingerprintTags, error) {
+	return database.FingerprintTags(tx.tx)
+}
+
+// Tags the file with the specified fingerprint, calculated by the specified
+// algorithm, once it is added to the database.
+func (store *Storage) AddFingerprintTag(tx *Tx, algorithm string, fingerprint fingerprint.Fingerprint, tagId entities.TagId, valueId entities.ValueId) (*entities.FingerprintTag, error) {
+	return database.AddFingerprintTag(tx.tx, algorithm, fingerprint, tagId, valueId)
+}
+
+// Applies the taggings for the specified fingerprint to a file, returning the
+// number applied.
+func (store *Storage) AttachFingerprintTags(tx *Tx, fingerprint fingerprint.Fingerprint, fileId entities.FileId) (uint, error) {
+	return database.AttachFingerprintTags(tx.tx, fingerprint, fileId)
+}
+
+// Removes a fingerprint tagging, returning whether it existed.
+func (store *Storage) DeleteFingerprintTag(tx *Tx, fingerprint fingerprint.Fingerprint, tagId entities.TagId, valueId entities.ValueId) (bool, error) {
+	return database.DeleteFingerprintTag(tx.tx, fingerprint, tagId, valueId)
+}
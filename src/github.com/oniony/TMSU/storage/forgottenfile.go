@@ -0,0 +1,115 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"github.com/oniony/TMSU/common/fingerprint"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage/database"
+	"time"
+)
+
+// Retrieves the forgotten files, most recently forgotten first.
+func (store *Storage) ForgottenFiles(tx *Tx) (entities.ForgottenFiles, error) {
+	files, err := database.ForgottenFiles(tx.tx)
+	store.absForgottenPaths(files)
+
+	return files, err
+}
+
+// Retrieves the files forgotten at the specified path, most recently forgotten
+// first.
+func (store *Storage) ForgottenFilesByPath(tx *Tx, path string) (entities.ForgottenFiles, error) {
+	files, err := database.ForgottenFilesByPath(tx.tx, store.relPath(path))
+	store.absForgottenPaths(files)
+
+	return files, err
+}
+
+// Removes a file from the database, retaining its details and tags so that
+// they can be restored should the file reappear.
+func (store *Storage) ForgetFile(tx *Tx, fileId entities.FileId) (*entities.ForgottenFile, error) {
+	file, err := database.ForgetFile(tx.tx, fileId, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := store.DeleteFileTagsByFileId(tx, fileId); err != nil {
+		return nil, err
+	}
+
+	store.absForgottenPath(file)
+
+	return file, nil
+}
+
+// Restores a forgotten file at the specified path, where it now resides, along
+// with its tags. A file already in the database at the path gains the
+// forgotten file's tags.
+func (store *Storage) RestoreFile(tx *Tx, forgottenFile *entities.ForgottenFile, path string, fingerprint fingerprint.Fingerprint, modTime time.Time, size int64, isDir bool) (*entities.File, error) {
+	file, err := store.FileByPath(tx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if file == nil {
+		file, err = store.AddFile(tx, path, fingerprint, modTime, size, isDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// restored file tags the file has by implication are to become explicit
+	if err := database.DeleteImpliedFileTags(tx.tx, file.Id); err != nil {
+		return nil, err
+	}
+
+	if err := database.RestoreForgottenFileTags(tx.tx, forgottenFile.Id, file.Id); err != nil {
+		return nil, err
+	}
+
+	if err := store.refreshImpliedFileTags(tx, file.Id); err != nil {
+		return nil, err
+	}
+
+	if err := database.DeleteForgottenFile(tx.tx, forgottenFile.Id); err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// Permanently removes the files forgotten before the specified time, returning
+// the number removed.
+func (store *Storage) PurgeForgottenFiles(tx *Tx, before time.Time) (uint, error) {
+	return database.DeleteForgottenFilesBefore(tx.tx, before.UTC())
+}
+
+// unexported
+
+func (store *Storage) absForgottenPaths(files entities.ForgottenFiles) {
+	for _, file := range files {
+		store.absForgottenPath(file)
+	}
+}
+
+func (store *Storage) absForgottenPath(file *entities.ForgottenFile) {
+	if file == nil {
+		return
+	}
+
+	file.Directory = store.absStoredPath(file.Directory)
+}
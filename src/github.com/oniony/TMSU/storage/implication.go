@@ -51,6 +51,12 @@ func (storage *Storage) ImplicationsFor(tx *Tx, pairs ...entities.TagIdValueIdPa
 	return resultantImplications, nil
 }
 
+// Retrieves the implications made directly by the specified tag and value
+// pair, excluding those that follow transitively.
+func (storage *Storage) DirectImplicationsFor(tx *Tx, pair entities.TagIdValueIdPair) (entities.Implications, error) {
+	return database.ImplicationsFor(tx.tx, entities.TagIdValueIdPairs{pair})
+}
+
 // Retrieves the set of implications that imply the specified tag and value pairs.
 func (storage *Storage) ImplicationsImplying(tx *Tx, pairs ...entities.TagIdValueIdPair) (entities.Implications, error) {
 	resultantImplications := make(entities.Implications, 0)
@@ -78,6 +84,10 @@ func (storage *Storage) ImplicationsImplying(tx *Tx, pairs ...entities.TagIdValu
 
 // Adds the specified implication.
 func (storage Storage) AddImplication(tx *Tx, pair, impliedPair entities.TagIdValueIdPair) error {
+	if err := storage.checkTagPermissions(tx, impliedPair.TagId); err != nil {
+		return err
+	}
+
 	implications, err := storage.ImplicationsFor(tx, impliedPair)
 	if err != nil {
 		return err
@@ -89,20 +99,110 @@ func (storage Storage) AddImplication(tx *Tx, pair, impliedPair entities.TagIdVa
 		}
 	}
 
-	return database.AddImplication(tx.tx, pair, impliedPair)
+	if err := database.AddImplication(tx.tx, pair, impliedPair); err != nil {
+		return err
+	}
+
+	return storage.refreshImpliedFileTags(tx, 0)
+}
+
+// Adds an implication that applies to those values of the tag that match the glob pattern.
+func (storage Storage) AddPatternImplication(tx *Tx, tagId entities.TagId, pattern string, impliedPair entities.TagIdValueIdPair) error {
+	if err := storage.checkTagPermissions(tx, impliedPair.TagId); err != nil {
+		return err
+	}
+
+	implications, err := storage.ImplicationsFor(tx, impliedPair)
+	if err != nil {
+		return err
+	}
+
+	// values are not known in advance so any route back to the tag could form a cycle
+	if impliedPair.TagId == tagId || implications.Any(func(implication entities.Implication) bool {
+		return implication.ImpliedTag.Id == tagId
+	}) {
+		return fmt.Errorf("implication would create a cycle")
+	}
+
+	if err := database.AddPatternImplication(tx.tx, tagId, pattern, impliedPair); err != nil {
+		return err
+	}
+
+	return storage.refreshImpliedFileTags(tx, 0)
 }
 
 // Deletes the specified implication
 func (storage Storage) DeleteImplication(tx *Tx, pair, impliedPair entities.TagIdValueIdPair) error {
-	return database.DeleteImplication(tx.tx, pair, impliedPair)
+	if err := storage.checkTagPermissions(tx, impliedPair.TagId); err != nil {
+		return err
+	}
+
+	if err := database.DeleteImplication(tx.tx, pair, impliedPair); err != nil {
+		return err
+	}
+
+	return storage.refreshImpliedFileTags(tx, 0)
+}
+
+// Deletes the specified pattern implication
+func (storage Storage) DeletePatternImplication(tx *Tx, tagId entities.TagId, pattern string, impliedPair entities.TagIdValueIdPair) error {
+	if err := storage.checkTagPermissions(tx, impliedPair.TagId); err != nil {
+		return err
+	}
+
+	if err := database.DeletePatternImplication(tx.tx, tagId, pattern, impliedPair); err != nil {
+		return err
+	}
+
+	return storage.refreshImpliedFileTags(tx, 0)
 }
 
 // Deletes implications for the specified tag.
 func (storage Storage) DeleteImplicationsByTagId(tx *Tx, tagId entities.TagId) error {
-	return database.DeleteImplicationsByTagId(tx.tx, tagId)
+	if err := storage.checkImpliedTagPermissions(tx, func(implication entities.Implication) bool {
+		return implication.ImplyingTag.Id == tagId
+	}); err != nil {
+		return err
+	}
+
+	if err := database.DeleteImplicationsByTagId(tx.tx, tagId); err != nil {
+		return err
+	}
+
+	return storage.refreshImpliedFileTags(tx, 0)
 }
 
 // Deletes implications for the specified value.
 func (storage Storage) DeleteImplicationsByValueId(tx *Tx, valueId entities.ValueId) error {
-	return database.DeleteImplicationsByValueId(tx.tx, valueId)
+	if err := storage.checkImpliedTagPermissions(tx, func(implication entities.Implication) bool {
+		return implication.ImplyingValue.Id == valueId
+	}); err != nil {
+		return err
+	}
+
+	if err := database.DeleteImplicationsByValueId(tx.tx, valueId); err != nil {
+		return err
+	}
+
+	return storage.refreshImpliedFileTags(tx, 0)
+}
+
+// unexported
+
+// Checks that the operating system user may apply and remove the tags implied
+// by the matching implications, as removing these removes the implied tags.
+func (storage *Storage) checkImpliedTagPermissions(tx *Tx, predicate func(entities.Implication) bool) error {
+	implications, err := database.Implications(tx.tx)
+	if err != nil {
+		return err
+	}
+
+	tagIds := make([]entities.TagId, 0)
+	for _, implication := range implications {
+		if predicate(*implication) {
+			tagIds = append(tagIds, implication.ImpliedTag.Id)
+		}
+	}
+
+	return storage.checkTagPermissions(tx, tagIds...)
 }
@@ -0,0 +1,79 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage/database"
+)
+
+// Runs SQLite's own check of the database file, returning the problems found.
+func (storage *Storage) IntegrityCheck(tx *Tx) ([]string, error) {
+	return database.IntegrityCheck(tx.tx)
+}
+
+// Counts the rows that refer to missing files, tags or values.
+func (storage *Storage) OrphanCounts(tx *Tx) ([]database.Orphans, error) {
+	return database.OrphanCounts(tx.tx)
+}
+
+// Deletes the rows that refer to missing files, tags or values.
+func (storage *Storage) DeleteOrphans(tx *Tx) error {
+	return database.DeleteOrphans(tx.tx)
+}
+
+// Counts the implications that refer to missing tags or values.
+func (storage *Storage) ImplicationOrphanCounts(tx *Tx) ([]database.Orphans, error) {
+	return database.ImplicationOrphanCounts(tx.tx)
+}
+
+// Deletes the implications that refer to missing tags or values.
+func (storage *Storage) DeleteImplicationOrphans(tx *Tx) error {
+	return database.DeleteImplicationOrphans(tx.tx)
+}
+
+// Retrieves the tags that have the same name as a tag created before them.
+func (storage *Storage) DuplicateTags(tx *Tx) (entities.Tags, error) {
+	return database.DuplicateTags(tx.tx)
+}
+
+// Rebuilds the database file, reclaiming unused space, and refreshes the
+// statistics used to plan queries. This must not be called within a
+// transaction.
+func (storage *Storage) Compact() error {
+	return storage.db.Compact()
+}
+
+// Copies the database to a new file at the specified path, consistently even
+// whilst other processes modify it. This must not be called within a
+// transaction.
+func (storage *Storage) Backup(path string) error {
+	return storage.db.Backup(path)
+}
+
+// Checks that the backup at the specified path can be restored over the
+// database.
+func (storage *Storage) CheckBackup(path string) error {
+	return storage.db.CheckBackup(path)
+}
+
+// Replaces the content of the database with that of the backup at the
+// specified path, which other processes using the database, such as the
+// virtual filesystem, then see. This must not be called within a transaction
+// and the storage should be closed afterwards.
+func (storage *Storage) Restore(path string) error {
+	return storage.db.Restore(path)
+}
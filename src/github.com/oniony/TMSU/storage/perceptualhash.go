@@ -0,0 +1,44 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"github.com/oniony/TMSU/common/fingerprint"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage/database"
+)
+
+// Retrieves the perceptual hashes of the files that are images.
+func (store *Storage) PerceptualHashes(tx *Tx) (map[entities.FileId]fingerprint.PerceptualHash, error) {
+	return database.PerceptualHashes(tx.tx)
+}
+
+// Retrieves the files that have not yet been perceptually hashed.
+func (store *Storage) FilesWithoutPerceptualHash(tx *Tx) (entities.Files, error) {
+	files, err := database.FilesWithoutPerceptualHash(tx.tx)
+	if err != nil {
+		return nil, err
+	}
+
+	store.absPaths(files)
+
+	return files, nil
+}
+
+// Records the perceptual hash of a file, or that it is not an image if hash is nil.
+func (store *Storage) UpdatePerceptualHash(tx *Tx, fileId entities.FileId, hash *fingerprint.PerceptualHash) error {
+	return database.UpdatePerceptualHash(tx.tx, fileId, hash)
+}
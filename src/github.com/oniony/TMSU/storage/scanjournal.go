@@ -0,0 +1,53 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"github.com/oniony/TMSU/storage/database"
+	"time"
+)
+
+// Retrieves the modification time of each directory when last examined by a
+// repair, by the directory's absolute path.
+func (store *Storage) ScanJournal(tx *Tx) (map[string]time.Time, error) {
+	journal, err := database.ScanJournal(tx.tx)
+	if err != nil {
+		return nil, err
+	}
+
+	absJournal := make(map[string]time.Time, len(journal))
+	for directory, modTime := range journal {
+		absJournal[store.absStoredPath(directory)] = modTime
+	}
+
+	return absJournal, nil
+}
+
+// Records the modification time of a directory examined by a repair.
+func (store *Storage) UpdateScanJournal(tx *Tx, directory string, modTime time.Time) error {
+	return database.UpdateScanJournal(tx.tx, store.relPath(directory), modTime.UTC())
+}
+
+// Removes a directory from the scan journal, so that the next incremental
+// repair examines it.
+func (store *Storage) DeleteScanJournalEntry(tx *Tx, directory string) error {
+	return database.DeleteScanJournalEntry(tx.tx, store.relPath(directory))
+}
+
+// Empties the scan journal.
+func (store *Storage) ClearScanJournal(tx *Tx) error {
+	return database.ClearScanJournal(tx.tx)
+}
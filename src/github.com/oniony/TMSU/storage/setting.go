@@ -16,7 +16,9 @@
 package storage
 
 import (
+	"fmt"
 	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/query"
 	"github.com/oniony/TMSU/storage/database"
 	"sort"
 )
@@ -24,10 +26,33 @@ import (
 var defaultSettings = entities.Settings{
 	&entities.Setting{"autoCreateTags", "yes"},
 	&entities.Setting{"autoCreateValues", "yes"},
+	&entities.Setting{"autoTags", ""},
+	&entities.Setting{"closedVocabulary", "no"},
+	&entities.Setting{"contentExtractors", `pdf:pdftotext -q "$1" -,docx:docx2txt "$1" -,odt:odt2txt "$1"`},
+	&entities.Setting{"defaultSort", "name"},
+	&entities.Setting{"deleteThreshold", "10"},
 	&entities.Setting{"directoryFingerprintAlgorithm", "none"},
 	&entities.Setting{"fileFingerprintAlgorithm", "dynamic:SHA256"},
+	&entities.Setting{"forgottenRetention", "30"},
+	&entities.Setting{"hooks", ""},
+	&entities.Setting{"ignoreCase", "no"},
+	&entities.Setting{"ignorePatterns", ".git,.hg,.svn,node_modules"},
+	&entities.Setting{"journalMode", "wal"},
+	&entities.Setting{"materializeImplications", "no"},
+	&entities.Setting{"metadataMapping", "album:album,artist:artist,author:author,camera:camera,year:year"},
+	&entities.Setting{"nameQuoting", "backslash"},
+	&entities.Setting{"newTagPatterns", ""},
+	&entities.Setting{"normalizeNames", "no"},
+	&entities.Setting{"pathTemplates", ""},
+	&entities.Setting{"plugins", ""},
+	&entities.Setting{"propagateDuplicateTags", "no"},
+	&entities.Setting{"queryMacros", ""},
 	&entities.Setting{"reportDuplicates", "yes"},
-	&entities.Setting{"symlinkFingerprintAlgorithm", "follow"}}
+	&entities.Setting{"searchPaths", ""},
+	&entities.Setting{"strictVocabularies", "no"},
+	&entities.Setting{"symlinkFingerprintAlgorithm", "follow"},
+	&entities.Setting{"symlinkPolicy", "follow"},
+	&entities.Setting{"tagPermissions", ""}}
 
 // The complete set of settings.
 func (storage *Storage) Settings(tx *Tx) (entities.Settings, error) {
@@ -54,6 +79,10 @@ func (storage *Storage) Setting(tx *Tx, name string) (*entities.Setting, error)
 		return nil, err
 	}
 	if setting == nil {
+		if !defaultSettings.ContainsName(name) {
+			return nil, nil
+		}
+
 		value := defaultSettings.Value(name)
 		setting = &entities.Setting{name, value}
 	}
@@ -62,5 +91,117 @@ func (storage *Storage) Setting(tx *Tx, name string) (*entities.Setting, error)
 }
 
 func (storage *Storage) UpdateSetting(tx *Tx, name, value string) (*entities.Setting, error) {
-	return database.UpdateSetting(tx.tx, name, value)
+	setting, err := database.UpdateSetting(tx.tx, name, value)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := storage.settingChanged(tx, name); err != nil {
+		return nil, err
+	}
+
+	return setting, nil
+}
+
+// Reverts a setting to its default value.
+func (storage *Storage) ResetSetting(tx *Tx, name string) error {
+	if err := database.DeleteSetting(tx.tx, name); err != nil {
+		return err
+	}
+
+	return storage.settingChanged(tx, name)
+}
+
+// The macros queries may call, as defined by the 'queryMacros' setting.
+func (storage *Storage) QueryMacros(tx *Tx) ([]query.Macro, error) {
+	setting, err := storage.Setting(tx, "queryMacros")
+	if err != nil {
+		return nil, err
+	}
+
+	macros, err := entities.Settings{setting}.QueryMacros()
+	if err != nil {
+		return nil, fmt.Errorf("invalid query macros: %v", err)
+	}
+
+	return macros, nil
+}
+
+// Transforms a query so that its tag and value names match those stored,
+// returning also whether the names must be matched without regard to case.
+func (storage *Storage) NormalizeQuery(tx *Tx, expression query.Expression, ignoreCase bool) (query.Expression, bool, error) {
+	normalizing, err := storage.normalizingNames(tx)
+	if err != nil {
+		return nil, false, err
+	}
+	if !normalizing {
+		return expression, ignoreCase, nil
+	}
+
+	expression, err = query.MapNames(expression, entities.NormalizeName)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return expression, true, nil
+}
+
+// unexported
+
+// Brings the database into line with a changed setting.
+func (storage *Storage) settingChanged(tx *Tx, name string) error {
+	switch name {
+	case "materializeImplications":
+		_, err := storage.RematerializeImplications(tx)
+		return err
+	}
+
+	return nil
+}
+
+func (storage *Storage) materializingImplications(tx *Tx) (bool, error) {
+	setting, err := storage.Setting(tx, "materializeImplications")
+	if err != nil {
+		return false, err
+	}
+
+	return entities.Settings{setting}.MaterializeImplications(), nil
+}
+
+func (storage *Storage) normalizingNames(tx *Tx) (bool, error) {
+	setting, err := storage.Setting(tx, "normalizeNames")
+	if err != nil {
+		return false, err
+	}
+
+	return entities.Settings{setting}.NormalizeNames(), nil
+}
+
+func (storage *Storage) normalizeName(tx *Tx, name string, ignoreCase bool) (string, bool, error) {
+	normalizing, err := storage.normalizingNames(tx)
+	if err != nil {
+		return "", false, err
+	}
+	if !normalizing {
+		return name, ignoreCase, nil
+	}
+
+	return entities.NormalizeName(name), true, nil
+}
+
+func (storage *Storage) normalizeNames(tx *Tx, names []string, ignoreCase bool) ([]string, bool, error) {
+	normalizing, err := storage.normalizingNames(tx)
+	if err != nil {
+		return nil, false, err
+	}
+	if !normalizing {
+		return names, ignoreCase, nil
+	}
+
+	normalized := make([]string, len(names))
+	for index, name := range names {
+		normalized[index] = entities.NormalizeName(name)
+	}
+
+	return normalized, true, nil
 }
@@ -13,27 +13,103 @@
 // You should have received a copy of the GNU General Public License
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
+// Package storage provides access to a TMSU database: its files, tags, values,
+// implications and settings. It may be used by other programs to query and
+// modify a database without running the tmsu command.
+//
+// Every operation takes a transaction, which is bound to a context so that it
+// can be abandoned part way through:
+//
+//	store, err := storage.OpenAt(path, 0, false)
+//	if err != nil {
+//		return err
+//	}
+//	defer store.Close()
+//
+//	tx, err := store.BeginContext(ctx)
+//	if err != nil {
+//		return err
+//	}
+//
+//	expression, err := query.Parse("music and year > 2000")
+//	if err != nil {
+//		tx.Rollback()
+//		return err
+//	}
+//
+//	files, err := store.FilesForQuery(tx, expression, "", false, false, "name")
+//	if err != nil {
+//		tx.Rollback()
+//		return err
+//	}
+//
+//	return tx.Commit()
+//
+// Paths are stored relative to the directory containing the database's '.tmsu'
+// directory, where there is one, but are always presented as absolute paths.
+// Errors are returned rather than reported: outside of dry-run mode the package
+// neither writes warnings to standard error nor exits the process.
 package storage
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
+	"github.com/oniony/TMSU/common/filesystem"
+	"github.com/oniony/TMSU/common/ignore"
 	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/entities"
 	"github.com/oniony/TMSU/storage/database"
 	"path/filepath"
+	"sync"
+	"time"
 )
 
 type Storage struct {
-	db       *database.Database
-	DbPath   string
-	RootPath string
+	db            *database.Database
+	DbPath        string
+	RootPath      string
+	excludedPaths []string
+	batchTx       *database.Tx
+	ignore        *ignore.Matcher
+	volumes       entities.Volumes
+	volumeMounts  map[entities.VolumeId]string
+	mounts        filesystem.Mounts
+	ctx           context.Context
 }
 
-func CreateAt(path string) error {
-	return database.CreateAt(path)
+// Retrieves the names of the backends available to hold storage.
+func Backends() []string {
+	return database.Backends()
 }
 
-func OpenAt(path string) (*Storage, error) {
-	db, err := database.OpenAt(path)
+// Creates new storage at the specified path held by the named backend. An
+// empty backend name selects database.DefaultBackend.
+func CreateAt(path, backend string) error {
+	if err := database.CreateAt(path, backend); err != nil {
+		return err
+	}
+
+	// the journal mode is set whilst nothing else can be using the database
+	db, err := database.OpenAt(path, 0, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.SetJournalMode(defaultSettings.Value("journalMode"))
+}
+
+// Opens another application's Sqlite database for reading, such as that of a
+// tagging tool whose tags are to be imported.
+func OpenForeignDatabase(path string) (*sql.DB, error) {
+	return database.OpenSqliteReadOnly(path)
+}
+
+// Opens the storage at the specified path, waiting up to lockWait for locks
+// held by other processes. A read-only storage rejects all changes.
+func OpenAt(path string, lockWait time.Duration, readOnly bool) (*Storage, error) {
+	db, err := database.OpenAt(path, lockWait, readOnly)
 	if err != nil {
 		return nil, err
 	}
@@ -43,22 +119,169 @@ func OpenAt(path string) (*Storage, error) {
 		return nil, err
 	}
 
-	log.Infof(2, "files are stored relative to root path '%v'", rootPath)
+	log.Debugf("files are stored relative to root path '%v'", rootPath)
+
+	storage := &Storage{db, path, rootPath, nil, nil, nil, nil, nil, nil, context.Background()}
+
+	if err := storage.loadVolumes(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not load volumes: %v", err)
+	}
 
-	return &Storage{db, path, rootPath}, nil
+	if _, applied := journalModeApplied.LoadOrStore(path, true); !applied {
+		if err := storage.applyJournalMode(); err != nil {
+			log.Warnf("could not apply journal mode: %v", err)
+		}
+	}
+
+	return storage, nil
+}
+
+// Hides files under the specified absolute paths from all subsequent queries.
+func (storage *Storage) ExcludePaths(paths ...string) {
+	for _, path := range paths {
+		storage.excludedPaths = append(storage.excludedPaths, filepath.Clean(path))
+	}
+}
+
+// Determines whether the file or directory at the specified absolute path is
+// ignored, either by the 'ignorePatterns' setting or by an ignore file within
+// the root path, so should not be added when searching the file-system.
+func (storage *Storage) Ignored(tx *Tx, absPath string, isDir bool) (bool, error) {
+	if storage.ignore == nil {
+		settings, err := storage.Settings(tx)
+		if err != nil {
+			return false, err
+		}
+
+		storage.ignore, err = ignore.NewMatcher(storage.RootPath, settings.IgnorePatterns())
+		if err != nil {
+			return false, fmt.Errorf("invalid ignore patterns: %v", err)
+		}
+	}
+
+	return storage.ignore.Ignored(absPath, isDir), nil
+}
+
+// The name of the backend in which the storage is held.
+func (storage *Storage) Backend() string {
+	return storage.db.Backend()
+}
+
+// Whether the database is read-only, in which case only queries can be made.
+func (storage *Storage) ReadOnly() bool {
+	return storage.db.ReadOnly()
+}
+
+// Enables dry-run mode, in which changes are reported rather than committed.
+func (storage *Storage) SetDryRun(dryRun bool) {
+	storage.db.SetDryRun(dryRun, storage.absStoredPath)
+}
+
+// Sets the command line reported to other processes whilst this one holds the
+// database lock.
+func (storage *Storage) SetCommand(command string) {
+	storage.db.SetCommand(command)
+}
+
+// Sets the user to whom changes are attributed in the audit trail.
+func (storage *Storage) SetUser(user string) {
+	storage.db.SetUser(user)
+}
+
+// Sets the context to which the transactions begun by Begin, BeginRead and
+// BeginBatch are bound, so that cancelling it abandons and rolls back whatever
+// transaction is in progress.
+func (storage *Storage) SetContext(ctx context.Context) {
+	storage.ctx = ctx
+}
+
+// The context to which the storage's transactions are bound.
+func (storage *Storage) Context() context.Context {
+	return storage.ctx
+}
+
+// Retrieves a number that changes whenever the database is modified.
+func (storage *Storage) DataVersion() (int64, error) {
+	return storage.db.DataVersion()
 }
 
 func (storage *Storage) Begin() (*Tx, error) {
-	tx, err := storage.db.Begin()
+	return storage.BeginContext(storage.ctx)
+}
+
+// Begins a transaction bound to the context: once the context is cancelled the
+// transaction's statements fail and its changes are rolled back.
+func (storage *Storage) BeginContext(ctx context.Context) (*Tx, error) {
+	if storage.batchTx != nil {
+		return &Tx{storage.batchTx, true}, nil
+	}
+
+	tx, err := storage.db.BeginContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tx{tx, false}, nil
+}
+
+// Begins a transaction that will only query the database. Unlike a transaction
+// begun by Begin it neither waits for nor blocks other processes' changes.
+func (storage *Storage) BeginRead() (*Tx, error) {
+	return storage.BeginReadContext(storage.ctx)
+}
+
+// Begins a read transaction bound to the context.
+func (storage *Storage) BeginReadContext(ctx context.Context) (*Tx, error) {
+	if storage.batchTx != nil {
+		return &Tx{storage.batchTx, true}, nil
+	}
+
+	tx, err := storage.db.BeginReadContext(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Tx{tx}, nil
+	return &Tx{tx, false}, nil
+}
+
+// Begins a batch: until EndBatch is called every transaction begun shares a
+// single database transaction, so that their changes are committed or rolled
+// back together.
+func (storage *Storage) BeginBatch() error {
+	if storage.batchTx != nil {
+		return fmt.Errorf("batch already in progress")
+	}
+
+	tx, err := storage.db.BeginContext(storage.ctx)
+	if err != nil {
+		return err
+	}
+
+	storage.batchTx = tx
+
+	return nil
+}
+
+// Ends the batch, committing or rolling back the changes made within it.
+func (storage *Storage) EndBatch(commit bool) error {
+	tx := storage.batchTx
+	if tx == nil {
+		return fmt.Errorf("no batch in progress")
+	}
+
+	storage.batchTx = nil
+
+	if commit {
+		return tx.Commit()
+	}
+
+	return tx.Rollback()
 }
 
 func (storage *Storage) Close() error {
-	if storage.db == nil {
+	if storage.db == nil || storage.batchTx != nil {
+		// a batch's storage is closed by its owner once the batch has ended
 		return nil
 	}
 
@@ -73,14 +296,38 @@ func (storage *Storage) Close() error {
 }
 
 type Tx struct {
-	tx *database.Tx
+	tx      *database.Tx
+	batched bool
+}
+
+// The context the transaction is bound to.
+func (tx *Tx) Context() context.Context {
+	return tx.tx.Context()
 }
 
 func (tx *Tx) Commit() error {
+	if tx.batched {
+		return nil
+	}
+
 	return tx.tx.Commit()
 }
 
+// Commits the changes made so far and continues in a new transaction. Within a
+// batch the changes are instead committed with the rest of the batch.
+func (tx *Tx) Checkpoint() error {
+	if tx.batched {
+		return nil
+	}
+
+	return tx.tx.Checkpoint()
+}
+
 func (tx *Tx) Rollback() error {
+	if tx.batched {
+		return nil
+	}
+
 	return tx.tx.Rollback()
 }
 
@@ -97,5 +344,27 @@ func determineRootPath(dbPath string) (string, error) {
 		return filepath.Dir(absDbDirPath), nil
 	}
 
-	return string(filepath.Separator), nil //TODO Windows
+	// the root of the volume, e.g. 'C:\' on Windows
+	return filepath.VolumeName(absDbPath) + string(filepath.Separator), nil
+}
+
+// the databases whose journal mode has been applied by this process, which is
+// done only when each is first opened
+var journalModeApplied sync.Map
+
+// Switches the database to the journal mode configured in its settings, so that
+// existing databases are migrated to the write-ahead log.
+func (storage *Storage) applyJournalMode() error {
+	tx, err := storage.BeginRead()
+	if err != nil {
+		return err
+	}
+
+	setting, err := storage.Setting(tx, "journalMode")
+	tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return storage.db.SetJournalMode(setting.Value)
 }
@@ -16,6 +16,7 @@
 package storage
 
 import (
+	"github.com/oniony/TMSU/common/text"
 	"github.com/oniony/TMSU/entities"
 	"github.com/oniony/TMSU/storage/database"
 )
@@ -30,6 +31,28 @@ func (storage *Storage) Tags(tx *Tx) (entities.Tags, error) {
 	return database.Tags(tx.tx)
 }
 
+// Finds the tags whose names are similar to name, closest first: those that
+// name is a prefix of and those a few edits away.
+func (storage *Storage) FuzzyTagNames(tx *Tx, name string) ([]text.Match, error) {
+	tags, err := database.Tags(tx.tx)
+	if err != nil {
+		return nil, err
+	}
+
+	return text.FuzzyMatch(name, tags.Names(), text.MisspellingDistance(name)), nil
+}
+
+// Suggests the existing tags that a misspelt tag name may have been intended
+// to be.
+func (storage *Storage) SuggestTagNames(tx *Tx, name string) ([]string, error) {
+	tags, err := database.Tags(tx.tx)
+	if err != nil {
+		return nil, err
+	}
+
+	return text.Suggest(name, tags.Names(), maxSuggestions), nil
+}
+
 // Retrieves a specific tag.
 func (storage Storage) Tag(tx *Tx, id entities.TagId) (*entities.Tag, error) {
 	return database.Tag(tx.tx, id)
@@ -47,6 +70,11 @@ func (storage Storage) TagByName(tx *Tx, name string) (*entities.Tag, error) {
 
 // Retrieves a specific tag with specified case-sensitivity.
 func (storage Storage) TagByCasedName(tx *Tx, name string, ignoreCase bool) (*entities.Tag, error) {
+	name, ignoreCase, err := storage.normalizeName(tx, name, ignoreCase)
+	if err != nil {
+		return nil, err
+	}
+
 	return database.TagByName(tx.tx, name, ignoreCase)
 }
 
@@ -57,11 +85,21 @@ func (storage Storage) TagsByNames(tx *Tx, names []string) (entities.Tags, error
 
 // Retrieves the set of named tags.
 func (storage Storage) TagsByCasedNames(tx *Tx, names []string, ignoreCase bool) (entities.Tags, error) {
+	names, ignoreCase, err := storage.normalizeNames(tx, names, ignoreCase)
+	if err != nil {
+		return nil, err
+	}
+
 	return database.TagsByNames(tx.tx, names, ignoreCase)
 }
 
 // Adds a tag.
 func (storage *Storage) AddTag(tx *Tx, name string) (*entities.Tag, error) {
+	name, _, err := storage.normalizeName(tx, name, false)
+	if err != nil {
+		return nil, err
+	}
+
 	if err := entities.ValidateTagName(name); err != nil {
 		return nil, err
 	}
@@ -71,19 +109,41 @@ func (storage *Storage) AddTag(tx *Tx, name string) (*entities.Tag, error) {
 
 // Renames a tag.
 func (storage Storage) RenameTag(tx *Tx, tagId entities.TagId, name string) (*entities.Tag, error) {
+	name, _, err := storage.normalizeName(tx, name, false)
+	if err != nil {
+		return nil, err
+	}
+
 	if err := entities.ValidateTagName(name); err != nil {
 		return nil, err
 	}
 
+	if err := storage.checkTagPermissions(tx, tagId); err != nil {
+		return nil, err
+	}
+
+	if err := storage.checkTagNamePermissions(tx, name); err != nil {
+		return nil, err
+	}
+
 	return database.RenameTag(tx.tx, tagId, name)
 }
 
 // Copies a tag.
 func (storage Storage) CopyTag(tx *Tx, sourceTagId entities.TagId, name string) (*entities.Tag, error) {
+	name, _, err := storage.normalizeName(tx, name, false)
+	if err != nil {
+		return nil, err
+	}
+
 	if err := entities.ValidateTagName(name); err != nil {
 		return nil, err
 	}
 
+	if err := storage.checkTagNamePermissions(tx, name); err != nil {
+		return nil, err
+	}
+
 	tag, err := database.InsertTag(tx.tx, name)
 	if err != nil {
 		return nil, err
@@ -94,6 +154,36 @@ func (storage Storage) CopyTag(tx *Tx, sourceTagId entities.TagId, name string)
 		return nil, err
 	}
 
+	definition, err := database.TagDefinition(tx.tx, sourceTagId)
+	if err != nil {
+		return nil, err
+	}
+	if definition != nil {
+		if _, err := database.UpdateTagDefinition(tx.tx, tag.Id, definition.Type, definition.Values); err != nil {
+			return nil, err
+		}
+	}
+
+	vocabulary, err := database.Vocabulary(tx.tx, sourceTagId)
+	if err != nil {
+		return nil, err
+	}
+	if vocabulary != nil {
+		if _, err := database.UpdateVocabulary(tx.tx, tag.Id, vocabulary.Terms); err != nil {
+			return nil, err
+		}
+	}
+
+	info, err := database.TagInfo(tx.tx, sourceTagId)
+	if err != nil {
+		return nil, err
+	}
+	if info != nil {
+		if err := database.UpdateTagInfo(tx.tx, tag.Id, info.Description, info.Colour); err != nil {
+			return nil, err
+		}
+	}
+
 	return tag, nil
 }
 
@@ -107,6 +197,26 @@ func (storage Storage) DeleteTag(tx *Tx, tagId entities.TagId) error {
 		return err
 	}
 
+	if err := database.DeleteForgottenFileTagsByTagId(tx.tx, tagId); err != nil {
+		return err
+	}
+
+	if err := database.DeleteFingerprintTagsByTagId(tx.tx, tagId); err != nil {
+		return err
+	}
+
+	if err := database.DeleteTagDefinition(tx.tx, tagId); err != nil {
+		return err
+	}
+
+	if err := database.DeleteVocabulary(tx.tx, tagId); err != nil {
+		return err
+	}
+
+	if err := database.DeleteTagInfo(tx.tx, tagId); err != nil {
+		return err
+	}
+
 	if err := database.DeleteTag(tx.tx, tagId); err != nil {
 		return err
 	}
@@ -114,7 +224,19 @@ func (storage Storage) DeleteTag(tx *Tx, tagId entities.TagId) error {
 	return nil
 }
 
+// Retrieves the set of unused tags, optionally disregarding their use in
+// implications.
+func (storage Storage) UnusedTags(tx *Tx, ignoreImplications bool) (entities.Tags, error) {
+	return database.UnusedTags(tx.tx, ignoreImplications)
+}
+
 // Retrieves the tag usage.
 func (storage Storage) TagUsage(tx *Tx) ([]entities.TagFileCount, error) {
 	return database.TagUsage(tx.tx)
 }
+
+// Retrieves the tags, with the number of files to which each is applied, in
+// the specified order, omitting those applied to fewer than minCount files.
+func (storage Storage) TagFileCounts(tx *Tx, sort string, minCount uint) ([]entities.TagFileCount, error) {
+	return database.TagFileCounts(tx.tx, sort, minCount)
+}
@@ -0,0 +1,107 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage/database"
+)
+
+// The complete set of tag definitions.
+func (storage *Storage) TagDefinitions(tx *Tx) (entities.TagDefinitions, error) {
+	return database.TagDefinitions(tx.tx)
+}
+
+// Retrieves the definition of a tag, or nil if the tag is untyped.
+func (storage *Storage) TagDefinition(tx *Tx, tagId entities.TagId) (*entities.TagDefinition, error) {
+	return database.TagDefinition(tx.tx, tagId)
+}
+
+// Defines the type of a tag's values.
+//
+// The tag's existing values, including those it is implied with, must already
+// conform to the new type.
+func (storage *Storage) DefineTag(tx *Tx, tagId entities.TagId, tagType string, values []string) (*entities.TagDefinition, error) {
+	if err := entities.ValidateTagType(tagType); err != nil {
+		return nil, err
+	}
+
+	switch tagType {
+	case entities.EnumType:
+		if len(values) == 0 {
+			return nil, fmt.Errorf("enumeration must have at least one value")
+		}
+
+		for _, value := range values {
+			if err := entities.ValidateValueName(value); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		if len(values) != 0 {
+			return nil, fmt.Errorf("only enumerations may specify values")
+		}
+	}
+
+	definition := entities.TagDefinition{tagId, tagType, values}
+
+	existingValues, err := database.ValuesByTagId(tx.tx, tagId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, value := range existingValues {
+		if err := definition.ValidateValue(value.Name); err != nil {
+			return nil, fmt.Errorf("existing %v", err)
+		}
+	}
+
+	implications, err := database.Implications(tx.tx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, implication := range implications {
+		if implication.ImpliedTag.Id != tagId {
+			continue
+		}
+
+		if err := definition.ValidateValue(implication.ImpliedValue.Name); err != nil {
+			return nil, fmt.Errorf("implied %v", err)
+		}
+	}
+
+	return database.UpdateTagDefinition(tx.tx, tagId, tagType, values)
+}
+
+// Removes the definition of a tag so that it accepts any value.
+func (storage *Storage) UndefineTag(tx *Tx, tagId entities.TagId) error {
+	return database.DeleteTagDefinition(tx.tx, tagId)
+}
+
+// Validates that the named value may be applied with the specified tag.
+func (storage *Storage) ValidateTagValue(tx *Tx, tagId entities.TagId, valueName string) error {
+	definition, err := storage.TagDefinition(tx, tagId)
+	if err != nil {
+		return err
+	}
+	if definition == nil {
+		return nil
+	}
+
+	return definition.ValidateValue(valueName)
+}
@@ -0,0 +1,40 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage/database"
+)
+
+// The details of every tag that has them.
+func (storage *Storage) TagInfos(tx *Tx) (entities.TagInfos, error) {
+	return database.TagInfos(tx.tx)
+}
+
+// Retrieves the details of a tag, or nil if it has none.
+func (storage *Storage) TagInfo(tx *Tx, tagId entities.TagId) (*entities.TagInfo, error) {
+	return database.TagInfo(tx.tx, tagId)
+}
+
+// Sets the description and colour of a tag.
+func (storage *Storage) UpdateTagInfo(tx *Tx, tagId entities.TagId, description, colour string) error {
+	if err := entities.ValidateTagColour(colour); err != nil {
+		return err
+	}
+
+	return database.UpdateTagInfo(tx.tx, tagId, description, colour)
+}
@@ -0,0 +1,46 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"github.com/oniony/TMSU/storage/database"
+)
+
+// Retrieves the absolute paths of the directories recorded as finished by the
+// tagging operation.
+func (store *Storage) TagJournal(tx *Tx, operation string) ([]string, error) {
+	directories, err := database.TagJournal(tx.tx, operation)
+	if err != nil {
+		return nil, err
+	}
+
+	for index, directory := range directories {
+		directories[index] = store.absStoredPath(directory)
+	}
+
+	return directories, nil
+}
+
+// Records that the tagging operation has finished the directory.
+func (store *Storage) AddTagJournalEntry(tx *Tx, operation, directory string) error {
+	return database.AddTagJournalEntry(tx.tx, operation, store.relPath(directory))
+}
+
+// Forgets the directories finished by the tagging operation, once it has
+// completed.
+func (store *Storage) ClearTagJournal(tx *Tx, operation string) error {
+	return database.ClearTagJournal(tx.tx, operation)
+}
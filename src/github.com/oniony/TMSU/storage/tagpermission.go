@@ -0,0 +1,130 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage/database"
+	"os/user"
+	"sync"
+)
+
+// unexported
+
+// the operating system user, against which the 'tagPermissions' setting is
+// checked, and the names of their groups
+var accountOnce sync.Once
+var accountName string
+var accountGroups []string
+
+func currentAccount() (string, []string) {
+	accountOnce.Do(func() {
+		account, err := user.Current()
+		if err != nil {
+			return
+		}
+		accountName = account.Username
+
+		groupIds, err := account.GroupIds()
+		if err != nil {
+			return
+		}
+
+		for _, groupId := range groupIds {
+			if group, err := user.LookupGroupId(groupId); err == nil {
+				accountGroups = append(accountGroups, group.Name)
+			}
+		}
+	})
+
+	return accountName, accountGroups
+}
+
+func (storage *Storage) tagPermissions(tx *Tx) ([]entities.TagPermission, error) {
+	setting, err := storage.Setting(tx, "tagPermissions")
+	if err != nil || setting == nil {
+		return nil, err
+	}
+
+	return entities.Settings{setting}.TagPermissions()
+}
+
+// Checks that the operating system user may apply and remove the tags.
+func (storage *Storage) checkTagPermissions(tx *Tx, tagIds ...entities.TagId) error {
+	if len(tagIds) == 0 {
+		return nil
+	}
+
+	permissions, err := storage.tagPermissions(tx)
+	if err != nil || len(permissions) == 0 {
+		return err
+	}
+
+	tags, err := database.TagsByIds(tx.tx, tagIds)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		if err := checkTagNamePermission(permissions, tag.Name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Checks that the operating system user may apply and remove the tags with
+// the names, which need not exist.
+func (storage *Storage) checkTagNamePermissions(tx *Tx, tagNames ...string) error {
+	permissions, err := storage.tagPermissions(tx)
+	if err != nil {
+		return err
+	}
+
+	for _, tagName := range tagNames {
+		if err := checkTagNamePermission(permissions, tagName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// A tag may be changed by anyone unless rules match it, in which case the user
+// must be permitted by one of them.
+func checkTagNamePermission(permissions []entities.TagPermission, tagName string) error {
+	matched := false
+	userName, groupNames := currentAccount()
+
+	for _, permission := range permissions {
+		if !permission.Matches(tagName) {
+			continue
+		}
+
+		if permission.Permits(userName, groupNames) {
+			return nil
+		}
+
+		matched = true
+	}
+
+	if matched {
+		return TagPermissionError{tagName, userName}
+	}
+
+	return nil
+}
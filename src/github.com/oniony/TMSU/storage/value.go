@@ -18,6 +18,8 @@ package storage
 import (
 	"github.com/oniony/TMSU/entities"
 	"github.com/oniony/TMSU/storage/database"
+	"sort"
+	"strings"
 )
 
 // Retrievse the count of values.
@@ -40,9 +42,10 @@ func (storage Storage) ValuesByIds(tx *Tx, ids entities.ValueIds) (entities.Valu
 	return database.ValuesByIds(tx.tx, ids)
 }
 
-// Retrieves the set of unused values.
-func (storage *Storage) UnusedValues(tx *Tx) (entities.Values, error) {
-	return database.UnusedValues(tx.tx)
+// Retrieves the set of unused values, optionally disregarding their use in
+// implications.
+func (storage *Storage) UnusedValues(tx *Tx, ignoreImplications bool) (entities.Values, error) {
+	return database.UnusedValues(tx.tx, ignoreImplications)
 }
 
 // Retrieves a specific value by name.
@@ -56,6 +59,11 @@ func (storage *Storage) ValueByCasedName(tx *Tx, name string, ignoreCase bool) (
 		return &entities.Value{0, ""}, nil
 	}
 
+	name, ignoreCase, err := storage.normalizeName(tx, name, ignoreCase)
+	if err != nil {
+		return nil, err
+	}
+
 	return database.ValueByName(tx.tx, name, ignoreCase)
 }
 
@@ -66,16 +74,110 @@ func (storage *Storage) ValuesByNames(tx *Tx, names []string) (entities.Values,
 
 // Retrieves the set of values with the specified names.
 func (storage *Storage) ValuesByCasedNames(tx *Tx, names []string, ignoreCase bool) (entities.Values, error) {
+	names, ignoreCase, err := storage.normalizeNames(tx, names, ignoreCase)
+	if err != nil {
+		return nil, err
+	}
+
 	return database.ValuesByNames(tx.tx, names, ignoreCase)
 }
 
-// Retrieves the set of values for the specified tag.
+// Summarises the values applied with the tag: the number of files tagged with
+// each and, if they are numbers, their mean.
+func (storage *Storage) ValueStatistics(tx *Tx, tagId entities.TagId) (*entities.ValueStatistics, error) {
+	counts, err := database.ValueFileCountsForTag(tx.tx, tagId)
+	if err != nil {
+		return nil, err
+	}
+
+	mean, hasMean, err := database.ValueMeanForTag(tx.tx, tagId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entities.ValueStatistics{counts, mean, hasMean}, nil
+}
+
+// Retrieves the set of values for the specified tag, ordered by the tag's type.
 func (storage *Storage) ValuesByTag(tx *Tx, tagId entities.TagId) (entities.Values, error) {
-	return database.ValuesByTagId(tx.tx, tagId)
+	values, err := database.ValuesByTagId(tx.tx, tagId)
+	if err != nil {
+		return nil, err
+	}
+
+	definition, err := database.TagDefinition(tx.tx, tagId)
+	if err != nil {
+		return nil, err
+	}
+	if definition != nil {
+		definition.SortValues(values)
+	}
+
+	return values, nil
+}
+
+// Retrieves the names of the values beginning with the prefix. If a tag is
+// specified these are the values applied with the tag together with those
+// permitted by its enumeration type and vocabulary, ordered by the tag's type.
+func (storage *Storage) ValueNamesByPrefix(tx *Tx, tagId entities.TagId, prefix string, ignoreCase bool) ([]string, error) {
+	values, err := database.ValuesByPrefix(tx.tx, tagId, prefix, ignoreCase)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(values))
+	seen := make(map[string]bool, len(values))
+	addName := func(name string) {
+		if !seen[name] && hasPrefix(name, prefix, ignoreCase) {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	for _, value := range values {
+		addName(value.Name)
+	}
+
+	if tagId == 0 {
+		return names, nil
+	}
+
+	definition, err := database.TagDefinition(tx.tx, tagId)
+	if err != nil {
+		return nil, err
+	}
+	if definition != nil {
+		for _, name := range definition.Values {
+			addName(name)
+		}
+	}
+
+	vocabulary, err := database.Vocabulary(tx.tx, tagId)
+	if err != nil {
+		return nil, err
+	}
+	if vocabulary != nil {
+		for _, name := range vocabulary.CanonicalNames() {
+			addName(name)
+		}
+	}
+
+	if definition != nil {
+		sort.SliceStable(names, func(i, j int) bool { return definition.Less(names[i], names[j]) })
+	} else {
+		sort.Strings(names)
+	}
+
+	return names, nil
 }
 
 // Adds a value.
 func (storage *Storage) AddValue(tx *Tx, name string) (*entities.Value, error) {
+	name, _, err := storage.normalizeName(tx, name, false)
+	if err != nil {
+		return nil, err
+	}
+
 	if err := entities.ValidateValueName(name); err != nil {
 		return nil, err
 	}
@@ -85,6 +187,11 @@ func (storage *Storage) AddValue(tx *Tx, name string) (*entities.Value, error) {
 
 // Renames a value.
 func (storage *Storage) RenameValue(tx *Tx, valueId entities.ValueId, newName string) (*entities.Value, error) {
+	newName, _, err := storage.normalizeName(tx, newName, false)
+	if err != nil {
+		return nil, err
+	}
+
 	if err := entities.ValidateValueName(newName); err != nil {
 		return nil, err
 	}
@@ -102,9 +209,27 @@ func (storage *Storage) DeleteValue(tx *Tx, valueId entities.ValueId) error {
 		return err
 	}
 
+	if err := database.DeleteForgottenFileTagsByValueId(tx.tx, valueId); err != nil {
+		return err
+	}
+
+	if err := database.DeleteFingerprintTagsByValueId(tx.tx, valueId); err != nil {
+		return err
+	}
+
 	if err := database.DeleteValue(tx.tx, valueId); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// unexported
+
+func hasPrefix(name, prefix string, ignoreCase bool) bool {
+	if ignoreCase {
+		return len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix)
+	}
+
+	return strings.HasPrefix(name, prefix)
+}
@@ -0,0 +1,37 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage/database"
+	"time"
+)
+
+// Retrieves the outcome of the most recent verification of each verified file.
+func (store *Storage) Verifications(tx *Tx) (entities.Verifications, error) {
+	return database.Verifications(tx.tx)
+}
+
+// Records whether a file's content matched its fingerprint when verified.
+func (store *Storage) UpdateVerification(tx *Tx, fileId entities.FileId, verifiedAt time.Time, passed bool) error {
+	return database.UpdateVerification(tx.tx, fileId, verifiedAt, passed)
+}
+
+// Forgets the verification state of a file.
+func (store *Storage) DeleteVerification(tx *Tx, fileId entities.FileId) error {
+	return database.DeleteVerification(tx.tx, fileId)
+}
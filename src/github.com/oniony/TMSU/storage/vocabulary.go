@@ -0,0 +1,108 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage/database"
+)
+
+// The maximum number of alternatives suggested for a tag that does not exist or
+// a value that is not in a vocabulary.
+const maxSuggestions = 3
+
+// The complete set of controlled vocabularies.
+func (storage *Storage) Vocabularies(tx *Tx) (entities.Vocabularies, error) {
+	return database.Vocabularies(tx.tx)
+}
+
+// Retrieves the controlled vocabulary of a tag, or nil if it has none.
+func (storage *Storage) Vocabulary(tx *Tx, tagId entities.TagId) (*entities.Vocabulary, error) {
+	return database.Vocabulary(tx.tx, tagId)
+}
+
+// Replaces the controlled vocabulary of a tag.
+//
+// Each canonical value is added as a term in its own right if not already present.
+func (storage *Storage) UpdateVocabulary(tx *Tx, tagId entities.TagId, terms []entities.VocabularyTerm) (*entities.Vocabulary, error) {
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("vocabulary must have at least one term")
+	}
+
+	canonicalByName := make(map[string]string, len(terms))
+	allTerms := make([]entities.VocabularyTerm, 0, len(terms))
+
+	addTerm := func(term entities.VocabularyTerm) error {
+		if canonical, ok := canonicalByName[term.Name]; ok {
+			if canonical != term.Canonical {
+				return fmt.Errorf("term '%v' cannot be a synonym of both '%v' and '%v'", term.Name, canonical, term.Canonical)
+			}
+
+			return nil
+		}
+
+		if err := entities.ValidateValueName(term.Name); err != nil {
+			return err
+		}
+
+		canonicalByName[term.Name] = term.Canonical
+		allTerms = append(allTerms, term)
+
+		return nil
+	}
+
+	for _, term := range terms {
+		if err := storage.ValidateTagValue(tx, tagId, term.Canonical); err != nil {
+			return nil, err
+		}
+
+		if err := addTerm(entities.VocabularyTerm{term.Canonical, term.Canonical}); err != nil {
+			return nil, err
+		}
+		if err := addTerm(term); err != nil {
+			return nil, err
+		}
+	}
+
+	return database.UpdateVocabulary(tx.tx, tagId, allTerms)
+}
+
+// Removes the controlled vocabulary of a tag.
+func (storage *Storage) DeleteVocabulary(tx *Tx, tagId entities.TagId) error {
+	return database.DeleteVocabulary(tx.tx, tagId)
+}
+
+// Retrieves the canonical form of a value name for the tag.
+//
+// If the tag has a controlled vocabulary that does not include the value name
+// then the value name is returned along with an UnknownTermError.
+func (storage *Storage) CanonicalValueName(tx *Tx, tagId entities.TagId, valueName string) (string, error) {
+	vocabulary, err := storage.Vocabulary(tx, tagId)
+	if err != nil {
+		return valueName, err
+	}
+	if vocabulary == nil {
+		return valueName, nil
+	}
+
+	canonical, ok := vocabulary.Canonicalise(valueName)
+	if !ok {
+		return valueName, UnknownTermError{valueName, vocabulary.Suggest(valueName, maxSuggestions)}
+	}
+
+	return canonical, nil
+}
@@ -0,0 +1,276 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"fmt"
+	"github.com/oniony/TMSU/common/filesystem"
+	"github.com/oniony/TMSU/common/log"
+	_path "github.com/oniony/TMSU/common/path"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage/database"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Retrieves the volumes.
+func (store *Storage) Volumes(tx *Tx) (entities.Volumes, error) {
+	return database.Volumes(tx.tx)
+}
+
+// Adds a volume mounted at the specified path. The files already in the
+// database beneath the path are moved onto the volume.
+func (store *Storage) AddVolume(tx *Tx, name, uuid, path string) (*entities.Volume, error) {
+	path = filepath.Clean(path)
+
+	files, err := store.FilesByDirectory(tx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	volume, err := database.InsertVolume(tx.tx, name, uuid, path)
+	if err != nil {
+		return nil, err
+	}
+
+	store.volumes = append(store.volumes, volume)
+	store.volumeMounts[volume.Id] = store.resolveVolumeMount(volume)
+
+	if err := store.restoreFilePaths(tx, files); err != nil {
+		return nil, err
+	}
+
+	return volume, nil
+}
+
+// Removes a volume. The paths of its files are stored as absolute paths at the
+// volume's current, or otherwise last, mount path.
+func (store *Storage) DeleteVolume(tx *Tx, volume *entities.Volume) error {
+	files, err := store.FilesByDirectory(tx, store.VolumePath(volume))
+	if err != nil {
+		return err
+	}
+
+	if err := database.DeleteVolume(tx.tx, volume.Id); err != nil {
+		return err
+	}
+
+	store.unloadVolume(volume.Id)
+
+	return store.restoreFilePaths(tx, files)
+}
+
+// Whether the volume is currently mounted.
+func (store *Storage) VolumeMounted(volume *entities.Volume) bool {
+	return store.volumeMounts[volume.Id] != ""
+}
+
+// The path at which the volume is currently mounted or, if it is not mounted,
+// at which it was last mounted.
+func (store *Storage) VolumePath(volume *entities.Volume) string {
+	if mountPath := store.volumeMounts[volume.Id]; mountPath != "" {
+		return mountPath
+	}
+
+	return volume.Path
+}
+
+// The volume that the file at the specified absolute path is on, or nil if it
+// is not on a volume.
+func (store *Storage) VolumeForPath(path string) *entities.Volume {
+	var found *entities.Volume
+
+	for _, volume := range store.volumes {
+		volumePath := store.VolumePath(volume)
+		if !_path.HasPrefix(path, volumePath+string(filepath.Separator)) {
+			continue
+		}
+
+		if found == nil || len(volumePath) > len(store.VolumePath(found)) {
+			found = volume
+		}
+	}
+
+	return found
+}
+
+// Records the paths at which the volumes are now mounted, where these differ
+// from those at which they were last mounted.
+func (store *Storage) UpdateVolumePaths(tx *Tx) error {
+	for _, volume := range store.volumes {
+		mountPath := store.volumeMounts[volume.Id]
+		if mountPath == "" || mountPath == volume.Path {
+			continue
+		}
+
+		log.Debugf("volume '%v' is now mounted at '%v'", volume.Name, mountPath)
+
+		if err := database.UpdateVolumePath(tx.tx, volume.Id, mountPath); err != nil {
+			return fmt.Errorf("could not update path of volume '%v': %v", volume.Name, err)
+		}
+
+		volume.Path = mountPath
+	}
+
+	return nil
+}
+
+// unexported
+
+func (store *Storage) loadVolumes() error {
+	tx, err := store.db.BeginRead()
+	if err != nil {
+		return err
+	}
+	defer tx.Commit()
+
+	volumes, err := database.Volumes(tx)
+	if err != nil {
+		return err
+	}
+
+	store.volumes = volumes
+	store.volumeMounts = make(map[entities.VolumeId]string, len(volumes))
+
+	for _, volume := range volumes {
+		store.volumeMounts[volume.Id] = store.resolveVolumeMount(volume)
+	}
+
+	return nil
+}
+
+func (store *Storage) unloadVolume(id entities.VolumeId) {
+	for index, volume := range store.volumes {
+		if volume.Id == id {
+			store.volumes = append(store.volumes[:index], store.volumes[index+1:]...)
+			break
+		}
+	}
+
+	delete(store.volumeMounts, id)
+}
+
+// Determines where a volume is mounted, or the empty string if it is not. A
+// volume with a UUID is found by its UUID, otherwise it is assumed to be
+// mounted if its last mount path is a mount point or, where mounts cannot be
+// listed, exists.
+func (store *Storage) resolveVolumeMount(volume *entities.Volume) string {
+	if store.mounts == nil {
+		mounts, err := filesystem.ListMounts()
+		if err != nil {
+			log.Debugf("could not list mounted file-systems: %v", err)
+			mounts = filesystem.Mounts{}
+		}
+
+		store.mounts = mounts
+	}
+
+	if volume.Uuid != "" {
+		if mount := store.mounts.ByUuid(volume.Uuid); mount != nil {
+			return mount.Path
+		}
+
+		if len(store.mounts) > 0 {
+			return ""
+		}
+	}
+
+	if _, err := os.Stat(volume.Path); err != nil {
+		return ""
+	}
+
+	if len(store.mounts) > 0 {
+		mount := store.mounts.Containing(volume.Path)
+		if mount == nil || !_path.Equal(mount.Path, volume.Path) {
+			return ""
+		}
+	}
+
+	return volume.Path
+}
+
+// Rewrites the stored paths of the files so that they reflect the current
+// volumes.
+func (store *Storage) restoreFilePaths(tx *Tx, files entities.Files) error {
+	for _, file := range files {
+		if err := database.UpdateFilePath(tx.tx, file.Id, store.relPath(file.Path())); err != nil {
+			return fmt.Errorf("could not update path of '%v': %v", file.Path(), err)
+		}
+	}
+
+	return nil
+}
+
+// The stored path of an absolute path on a volume, or the empty string if it is
+// not on a volume.
+func (store *Storage) volumeRelPath(path string) string {
+	volume := store.VolumeForPath(path)
+	if volume == nil {
+		return ""
+	}
+
+	relPath, err := filepath.Rel(store.VolumePath(volume), path)
+	if err != nil {
+		return ""
+	}
+
+	return database.VolumePathPrefix + strconv.Itoa(int(volume.Id)) + "/" + filepath.ToSlash(relPath)
+}
+
+// The stored paths of the volumes mounted at or beneath the specified absolute
+// path.
+func (store *Storage) volumePathsUnder(path string) []string {
+	if path == "" || !filepath.IsAbs(path) {
+		return nil
+	}
+
+	var volumePaths []string
+	for _, volume := range store.volumes {
+		volumePath := store.VolumePath(volume)
+		if _path.Equal(volumePath, path) || _path.HasPrefix(volumePath, path+string(filepath.Separator)) || _path.IsRoot(path) {
+			volumePaths = append(volumePaths, database.VolumePathPrefix+strconv.Itoa(int(volume.Id)))
+		}
+	}
+
+	return volumePaths
+}
+
+// The absolute path of a stored path on a volume, or the empty string if it is
+// not on a volume.
+func (store *Storage) volumeAbsPath(path string) string {
+	if !strings.HasPrefix(path, database.VolumePathPrefix) {
+		return ""
+	}
+
+	idText, relPath := path[len(database.VolumePathPrefix):], ""
+	if index := strings.Index(idText, "/"); index != -1 {
+		idText, relPath = idText[:index], idText[index+1:]
+	}
+
+	id, err := strconv.Atoi(idText)
+	if err != nil {
+		return ""
+	}
+
+	volume := store.volumes.ById(entities.VolumeId(id))
+	if volume == nil {
+		return ""
+	}
+
+	return filepath.Join(store.VolumePath(volume), filepath.FromSlash(relPath))
+}
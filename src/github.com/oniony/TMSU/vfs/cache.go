@@ -0,0 +1,165 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// +build !windows
+
+package vfs
+
+import (
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/storage"
+	"sync"
+)
+
+// the number of results cached before the cache is emptied
+const maxCachedResults = 10000
+
+// Caches the directory listings, attributes and link targets served from the
+// database so that a file manager examining every entry of a directory does
+// not query the database for each. The cache is emptied whenever the database
+// changes, whether by this or another process.
+type resultCache struct {
+	mutex   sync.Mutex
+	version int64
+	entries map[string]cachedEntries
+	attrs   map[string]cachedAttr
+	links   map[string]string
+	files   map[entities.FileId]*entities.File
+}
+
+type cachedEntries struct {
+	entries []fuse.DirEntry
+	status  fuse.Status
+}
+
+type cachedAttr struct {
+	attr   *fuse.Attr
+	status fuse.Status
+}
+
+func newResultCache() *resultCache {
+	cache := resultCache{}
+	cache.clear()
+
+	return &cache
+}
+
+// Empties the cache if the database has changed since the results were cached.
+func (cache *resultCache) validate(store *storage.Storage) {
+	version, err := store.DataVersion()
+	if err != nil {
+		log.Warnf("could not retrieve database version: %v", err)
+
+		cache.mutex.Lock()
+		cache.clear()
+		cache.mutex.Unlock()
+
+		return
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if version != cache.version || cache.size() > maxCachedResults {
+		log.Debugf("emptying result cache")
+
+		cache.clear()
+		cache.version = version
+	}
+}
+
+func (cache *resultCache) dirEntries(name string) ([]fuse.DirEntry, fuse.Status, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cached, ok := cache.entries[name]
+	return cached.entries, cached.status, ok
+}
+
+func (cache *resultCache) putDirEntries(name string, entries []fuse.DirEntry, status fuse.Status) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.entries[name] = cachedEntries{entries, status}
+}
+
+// Retrieves the cached attributes, returning a copy as the caller may modify them.
+func (cache *resultCache) attr(name string) (*fuse.Attr, fuse.Status, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cached, ok := cache.attrs[name]
+	if !ok || cached.attr == nil {
+		return nil, cached.status, ok
+	}
+
+	attr := *cached.attr
+	return &attr, cached.status, true
+}
+
+func (cache *resultCache) putAttr(name string, attr *fuse.Attr, status fuse.Status) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if attr != nil {
+		copied := *attr
+		attr = &copied
+	}
+
+	cache.attrs[name] = cachedAttr{attr, status}
+}
+
+func (cache *resultCache) link(name string) (string, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	target, ok := cache.links[name]
+	return target, ok
+}
+
+func (cache *resultCache) putLink(name, target string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.links[name] = target
+}
+
+func (cache *resultCache) file(fileId entities.FileId) (*entities.File, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	file, ok := cache.files[fileId]
+	return file, ok
+}
+
+func (cache *resultCache) putFile(fileId entities.FileId, file *entities.File) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.files[fileId] = file
+}
+
+func (cache *resultCache) clear() {
+	cache.entries = make(map[string]cachedEntries)
+	cache.attrs = make(map[string]cachedAttr)
+	cache.links = make(map[string]string)
+	cache.files = make(map[entities.FileId]*entities.File)
+}
+
+func (cache *resultCache) size() int {
+	return len(cache.entries) + len(cache.attrs) + len(cache.links) + len(cache.files)
+}
@@ -18,6 +18,7 @@
 package vfs
 
 import (
+	"context"
 	"fmt"
 	"github.com/hanwen/go-fuse/fuse"
 	"github.com/hanwen/go-fuse/fuse/nodefs"
@@ -60,6 +61,8 @@ The tags directory also allows some operations to be performed:
   * Rename a tag by renaming the tag directory
   * Untag a file by deleting the file symlink from the tag directory
   * Delete an unused tag by deleting the directory
+  * Tag a file by copying its symlink into another tag directory (cp -P),
+    if mounted with the 'tagcopies' option
 
 (This file will hide once you have created a few tags.)`
 
@@ -87,42 +90,80 @@ as this will untag the contained files.
 
 (This file will hide once you have created a query.)`
 
+// returned when a request cannot be serviced within the request timeout
+const timedOut = fuse.Status(syscall.ETIMEDOUT)
+
+// returned when a request would modify a read-only database
+const readOnly = fuse.Status(syscall.EROFS)
+
 type FuseVfs struct {
 	store     *storage.Storage
 	mountPath string
 	server    *fuse.Server
+	pool      *workerPool
+	cache     *resultCache
+	writeBack WriteBack
 }
 
-func MountVfs(store *storage.Storage, mountPath string, options []string) (*FuseVfs, error) {
-	fuseVfs := FuseVfs{nil, "", nil}
+// The changes to the tagging that may be made through the virtual filesystem
+// when the database is writable.
+type WriteBack struct {
+	// copying or linking a file into a tag directory applies the directory's tags
+	Tag bool
 
-	pathFs := pathfs.NewPathNodeFs(&fuseVfs, nil)
-	conn := nodefs.NewFileSystemConnector(pathFs.Root(), nil)
-	mountOptions := &fuse.MountOptions{Options: options}
+	// removing a file from a tag directory removes the directory's tag
+	Untag bool
 
-	server, err := fuse.NewServer(conn.RawFS(), mountPath, mountOptions)
-	if err != nil {
-		return nil, fmt.Errorf("could not mount virtual filesystem at '%v': %v", mountPath, err)
-	}
+	// removing an empty tag directory deletes the tag
+	DeleteTags bool
+}
 
+// The user and group shown as owning every entry of the virtual filesystem,
+// by default those of the process hosting it.
+type Owner struct {
+	Uid uint32
+	Gid uint32
+}
+
+// The user and group of the process hosting the virtual filesystem.
+func CurrentOwner() Owner {
+	owner := fuse.CurrentOwner()
+	return Owner{owner.Uid, owner.Gid}
+}
+
+// Mounts the virtual filesystem.
+//
+// At most 'workers' requests will access the database concurrently and
+// requests not serviced within 'timeout' fail with ETIMEDOUT. A zero timeout
+// disables the timeout. If 'cache' is set the results served from the database
+// are cached until the database is next changed. 'writeBack' determines which
+// filesystem operations change the tagging and 'owner' the ownership shown.
+func MountVfs(store *storage.Storage, mountPath string, options []string, workers uint, timeout time.Duration, cache bool, writeBack WriteBack, owner Owner) (*FuseVfs, error) {
 	absMountPath, err := filepath.Abs(mountPath)
 	if err != nil {
 		return nil, fmt.Errorf("could not convert mount path '%v' to absolute: %v", mountPath, err)
 	}
 
-	fuseVfs.store = store
-	fuseVfs.mountPath = absMountPath
+	fuseVfs := newFuseVfs(store, absMountPath, workers, timeout, cache, writeBack)
+
+	server, err := mount(fuseVfs, mountPath, options, owner)
+	if err != nil {
+		return nil, err
+	}
+
 	fuseVfs.server = server
 
-	return &fuseVfs, nil
+	return fuseVfs, nil
 }
 
 func (vfs FuseVfs) Unmount() {
 	vfs.server.Unmount()
 }
 
-func (vfs FuseVfs) Serve() {
-	vfs.server.Serve()
+// Serves the virtual filesystem until it is unmounted or the context is
+// cancelled, whereupon it is unmounted.
+func (vfs FuseVfs) Serve(ctx context.Context) {
+	serve(vfs.server, ctx)
 }
 
 func (vfs FuseVfs) SetDebug(debug bool) {
@@ -130,37 +171,68 @@ func (vfs FuseVfs) SetDebug(debug bool) {
 }
 
 func (vfs FuseVfs) Access(name string, mode uint32, context *fuse.Context) fuse.Status {
-	log.Infof(2, "BEGIN Access(%v, %v)", name, mode)
-	defer log.Infof(2, "END Access(%v, %v)", name, mode)
+	log.Debugf("BEGIN Access(%v, %v)", name, mode)
+	defer log.Debugf("END Access(%v, %v)", name, mode)
 
 	return fuse.ENOSYS
 }
 
 func (vfs FuseVfs) Chmod(name string, mode uint32, context *fuse.Context) fuse.Status {
-	log.Infof(2, "BEGIN Chmod(%v, %v)", name, mode)
-	defer log.Infof(2, "BEGIN Chmod(%v, %v)", name, mode)
+	log.Debugf("BEGIN Chmod(%v, %v)", name, mode)
+	defer log.Debugf("END Chmod(%v, %v)", name, mode)
 
 	return fuse.ENOSYS
 }
 
 func (vfs FuseVfs) Chown(name string, uid uint32, gid uint32, context *fuse.Context) fuse.Status {
-	log.Infof(2, "BEGIN Chown(%v, %v, %v)", name, uid, gid)
-	defer log.Infof(2, "BEGIN Chown(%v, %v)", name, uid, gid)
+	log.Debugf("BEGIN Chown(%v, %v, %v)", name, uid, gid)
+	defer log.Debugf("END Chown(%v, %v, %v)", name, uid, gid)
 
 	return fuse.ENOSYS
 }
 
 func (vfs FuseVfs) Create(name string, flags uint32, mode uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
-	log.Infof(2, "BEGIN Create(%v, %v, %v)", name, flags, mode)
-	defer log.Infof(2, "BEGIN Create(%v, %v)", name, flags, mode)
+	log.Debugf("BEGIN Create(%v, %v, %v)", name, flags, mode)
+	defer log.Debugf("END Create(%v, %v, %v)", name, flags, mode)
 
 	return nil, fuse.ENOSYS
 }
 
 func (vfs FuseVfs) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
-	log.Infof(2, "BEGIN GetAttr(%v)", name)
-	defer log.Infof(2, "END GetAttr(%v)", name)
+	log.Debugf("BEGIN GetAttr(%v)", name)
+	defer log.Debugf("END GetAttr(%v)", name)
 
+	var attr *fuse.Attr
+	status := timedOut
+	if !vfs.pool.run("GetAttr("+name+")", func() { attr, status = vfs.cachedAttr(name) }) {
+		return nil, timedOut
+	}
+
+	return attr, status
+}
+
+// The attributes of the file symbolic links are not cached as they reflect the
+// current size and modification time of the file.
+func (vfs FuseVfs) cachedAttr(name string) (*fuse.Attr, fuse.Status) {
+	if vfs.cache == nil {
+		return vfs.getAttr(name)
+	}
+
+	vfs.cache.validate(vfs.store)
+
+	if attr, status, ok := vfs.cache.attr(name); ok {
+		return attr, status
+	}
+
+	attr, status := vfs.getAttr(name)
+	if attr == nil || attr.Mode&syscall.S_IFMT != fuse.S_IFLNK {
+		vfs.cache.putAttr(name, attr, status)
+	}
+
+	return attr, status
+}
+
+func (vfs FuseVfs) getAttr(name string) (*fuse.Attr, fuse.Status) {
 	switch name {
 	case databaseFilename:
 		return vfs.getDatabaseFileAttr()
@@ -185,29 +257,42 @@ func (vfs FuseVfs) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse
 }
 
 func (vfs FuseVfs) GetXAttr(name string, attr string, context *fuse.Context) ([]byte, fuse.Status) {
-	log.Infof(2, "BEGIN GetXAttr(%v, %v)", name, attr)
-	defer log.Infof(2, "END GetAttr(%v, %v)", name, attr)
+	log.Debugf("BEGIN GetXAttr(%v, %v)", name, attr)
+	defer log.Debugf("END GetAttr(%v, %v)", name, attr)
 
 	return nil, fuse.ENOSYS
 }
 
 func (vfs FuseVfs) Link(oldName string, newName string, context *fuse.Context) fuse.Status {
-	log.Infof(2, "BEGIN Link(%v, %v)", oldName, newName)
-	defer log.Infof(2, "END Link(%v, %v)", oldName, newName)
+	log.Debugf("BEGIN Link(%v, %v)", oldName, newName)
+	defer log.Debugf("END Link(%v, %v)", oldName, newName)
 
 	return fuse.ENOSYS
 }
 
 func (vfs FuseVfs) ListXAttr(name string, context *fuse.Context) ([]string, fuse.Status) {
-	log.Infof(2, "BEGIN ListXAttr(%v)", name)
-	defer log.Infof(2, "END ListXAttr(%v)", name)
+	log.Debugf("BEGIN ListXAttr(%v)", name)
+	defer log.Debugf("END ListXAttr(%v)", name)
 
 	return nil, fuse.ENOSYS
 }
 
 func (vfs FuseVfs) Mkdir(name string, mode uint32, context *fuse.Context) fuse.Status {
-	log.Infof(2, "BEGIN Mkdir(%v)", name)
-	defer log.Infof(2, "END Mkdir(%v)", name)
+	log.Debugf("BEGIN Mkdir(%v)", name)
+	defer log.Debugf("END Mkdir(%v)", name)
+
+	status := timedOut
+	if !vfs.pool.run("Mkdir("+name+")", func() { status = vfs.mkdir(name, mode) }) {
+		return timedOut
+	}
+
+	return status
+}
+
+func (vfs FuseVfs) mkdir(name string, mode uint32) fuse.Status {
+	if vfs.store.ReadOnly() {
+		return readOnly
+	}
 
 	path := vfs.splitPath(name)
 
@@ -242,25 +327,25 @@ func (vfs FuseVfs) Mkdir(name string, mode uint32, context *fuse.Context) fuse.S
 }
 
 func (vfs FuseVfs) Mknod(name string, mode uint32, dev uint32, context *fuse.Context) fuse.Status {
-	log.Infof(2, "BEGIN Mknod(%v)", name)
-	defer log.Infof(2, "END Mknod(%v)", name)
+	log.Debugf("BEGIN Mknod(%v)", name)
+	defer log.Debugf("END Mknod(%v)", name)
 
 	return fuse.ENOSYS
 }
 
 func (vfs FuseVfs) OnMount(nodeFs *pathfs.PathNodeFs) {
-	log.Infof(2, "BEGIN OnMount()")
-	defer log.Infof(2, "END OnMount()")
+	log.Debugf("BEGIN OnMount()")
+	defer log.Debugf("END OnMount()")
 }
 
 func (vfs FuseVfs) OnUnmount() {
-	log.Infof(2, "BEGIN OnUnmount()")
-	defer log.Infof(2, "END OnUnmount()")
+	log.Debugf("BEGIN OnUnmount()")
+	defer log.Debugf("END OnUnmount()")
 }
 
 func (vfs FuseVfs) Open(name string, flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
-	log.Infof(2, "BEGIN Open(%v)", name)
-	defer log.Infof(2, "END Open(%v)", name)
+	log.Debugf("BEGIN Open(%v)", name)
+	defer log.Debugf("END Open(%v)", name)
 
 	switch name {
 	case filepath.Join(queriesDir, helpFilename):
@@ -273,10 +358,37 @@ func (vfs FuseVfs) Open(name string, flags uint32, context *fuse.Context) (nodef
 }
 
 func (vfs FuseVfs) OpenDir(name string, context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
-	log.Infof(2, "BEGIN OpenDir(%v)", name)
-	defer log.Infof(2, "END OpenDir(%v)", name)
+	log.Debugf("BEGIN OpenDir(%v)", name)
+	defer log.Debugf("END OpenDir(%v)", name)
 
-	tx, err := vfs.store.Begin()
+	var entries []fuse.DirEntry
+	status := timedOut
+	if !vfs.pool.run("OpenDir("+name+")", func() { entries, status = vfs.cachedDirEntries(name) }) {
+		return nil, timedOut
+	}
+
+	return entries, status
+}
+
+func (vfs FuseVfs) cachedDirEntries(name string) ([]fuse.DirEntry, fuse.Status) {
+	if vfs.cache == nil {
+		return vfs.openDir(name)
+	}
+
+	vfs.cache.validate(vfs.store)
+
+	if entries, status, ok := vfs.cache.dirEntries(name); ok {
+		return entries, status
+	}
+
+	entries, status := vfs.openDir(name)
+	vfs.cache.putDirEntries(name, entries, status)
+
+	return entries, status
+}
+
+func (vfs FuseVfs) openDir(name string) ([]fuse.DirEntry, fuse.Status) {
+	tx, err := vfs.store.BeginRead()
 	if err != nil {
 		log.Fatalf("could not begin transaction: %v", err)
 	}
@@ -304,10 +416,39 @@ func (vfs FuseVfs) OpenDir(name string, context *fuse.Context) ([]fuse.DirEntry,
 }
 
 func (vfs FuseVfs) Readlink(name string, context *fuse.Context) (string, fuse.Status) {
-	log.Infof(2, "BEGIN Readlink(%v)", name)
-	defer log.Infof(2, "END Readlink(%v)", name)
+	log.Debugf("BEGIN Readlink(%v)", name)
+	defer log.Debugf("END Readlink(%v)", name)
 
-	tx, err := vfs.store.Begin()
+	var target string
+	status := timedOut
+	if !vfs.pool.run("Readlink("+name+")", func() { target, status = vfs.cachedLink(name) }) {
+		return "", timedOut
+	}
+
+	return target, status
+}
+
+func (vfs FuseVfs) cachedLink(name string) (string, fuse.Status) {
+	if vfs.cache == nil {
+		return vfs.readlink(name)
+	}
+
+	vfs.cache.validate(vfs.store)
+
+	if target, ok := vfs.cache.link(name); ok {
+		return target, fuse.OK
+	}
+
+	target, status := vfs.readlink(name)
+	if status == fuse.OK {
+		vfs.cache.putLink(name, target)
+	}
+
+	return target, status
+}
+
+func (vfs FuseVfs) readlink(name string) (string, fuse.Status) {
+	tx, err := vfs.store.BeginRead()
 	if err != nil {
 		log.Fatalf("could not begin transaction: %v", err)
 	}
@@ -327,15 +468,28 @@ func (vfs FuseVfs) Readlink(name string, context *fuse.Context) (string, fuse.St
 }
 
 func (vfs FuseVfs) RemoveXAttr(name string, attr string, context *fuse.Context) fuse.Status {
-	log.Infof(2, "BEGIN RemoveXAttr(%v, %v)", name, attr)
-	defer log.Infof(2, "END RemoveXAttr(%v, %v)", name, attr)
+	log.Debugf("BEGIN RemoveXAttr(%v, %v)", name, attr)
+	defer log.Debugf("END RemoveXAttr(%v, %v)", name, attr)
 
 	return fuse.ENOSYS
 }
 
 func (vfs FuseVfs) Rename(oldName string, newName string, context *fuse.Context) fuse.Status {
-	log.Infof(2, "BEGIN Rename(%v, %v)", oldName, newName)
-	defer log.Infof(2, "END Rename(%v, %v)", oldName, newName)
+	log.Debugf("BEGIN Rename(%v, %v)", oldName, newName)
+	defer log.Debugf("END Rename(%v, %v)", oldName, newName)
+
+	status := timedOut
+	if !vfs.pool.run("Rename("+oldName+", "+newName+")", func() { status = vfs.rename(oldName, newName) }) {
+		return timedOut
+	}
+
+	return status
+}
+
+func (vfs FuseVfs) rename(oldName string, newName string) fuse.Status {
+	if vfs.store.ReadOnly() {
+		return readOnly
+	}
 
 	tx, err := vfs.store.Begin()
 	if err != nil {
@@ -377,8 +531,21 @@ func (vfs FuseVfs) Rename(oldName string, newName string, context *fuse.Context)
 }
 
 func (vfs FuseVfs) Rmdir(name string, context *fuse.Context) fuse.Status {
-	log.Infof(2, "BEGIN Rmdir(%v)", name)
-	defer log.Infof(2, "END Rmdir(%v)", name)
+	log.Debugf("BEGIN Rmdir(%v)", name)
+	defer log.Debugf("END Rmdir(%v)", name)
+
+	status := timedOut
+	if !vfs.pool.run("Rmdir("+name+")", func() { status = vfs.rmdir(name) }) {
+		return timedOut
+	}
+
+	return status
+}
+
+func (vfs FuseVfs) rmdir(name string) fuse.Status {
+	if vfs.store.ReadOnly() {
+		return readOnly
+	}
 
 	tx, err := vfs.store.Begin()
 	if err != nil {
@@ -395,6 +562,10 @@ func (vfs FuseVfs) Rmdir(name string, context *fuse.Context) fuse.Status {
 			return fuse.EPERM
 		}
 
+		if !vfs.writeBack.DeleteTags {
+			return fuse.EPERM
+		}
+
 		tagName := unescape(path[1])
 		tag, err := vfs.store.TagByName(tx, tagName)
 		if err != nil {
@@ -427,7 +598,7 @@ func (vfs FuseVfs) Rmdir(name string, context *fuse.Context) fuse.Status {
 			return fuse.EPERM
 		}
 
-		text := path[1]
+		text := unescape(path[1])
 
 		if err := vfs.store.DeleteQuery(tx, text); err != nil {
 			log.Fatalf("could not remove tag '%v': %v", name, err)
@@ -444,15 +615,15 @@ func (vfs FuseVfs) Rmdir(name string, context *fuse.Context) fuse.Status {
 }
 
 func (vfs FuseVfs) SetXAttr(name string, attr string, data []byte, flags int, context *fuse.Context) fuse.Status {
-	log.Infof(2, "BEGIN SetXAttr(%v, %v)", name, attr)
-	defer log.Infof(2, "END SetXAttr(%v, %v)", name, attr)
+	log.Debugf("BEGIN SetXAttr(%v, %v)", name, attr)
+	defer log.Debugf("END SetXAttr(%v, %v)", name, attr)
 
 	return fuse.ENOSYS
 }
 
 func (vfs FuseVfs) StatFs(name string) *fuse.StatfsOut {
-	log.Infof(2, "BEGIN StatFs(%v)", name)
-	defer log.Infof(2, "END StatFs(%v)", name)
+	log.Debugf("BEGIN StatFs(%v)", name)
+	defer log.Debugf("END StatFs(%v)", name)
 
 	return &fuse.StatfsOut{}
 }
@@ -462,22 +633,40 @@ func (vfs FuseVfs) String() string {
 }
 
 func (vfs FuseVfs) Symlink(value string, linkName string, context *fuse.Context) fuse.Status {
-	log.Infof(2, "BEGIN Symlink(%v, %v)", value, linkName)
-	defer log.Infof(2, "END Symlink(%v, %v)", value, linkName)
+	log.Debugf("BEGIN Symlink(%v, %v)", value, linkName)
+	defer log.Debugf("END Symlink(%v, %v)", value, linkName)
 
-	return fuse.ENOSYS
+	status := timedOut
+	if !vfs.pool.run("Symlink("+value+", "+linkName+")", func() { status = vfs.tagLinkedFile(linkName) }) {
+		return timedOut
+	}
+
+	return status
 }
 
 func (vfs FuseVfs) Truncate(name string, offset uint64, context *fuse.Context) fuse.Status {
-	log.Infof(2, "BEGIN Truncate(%v)", name)
-	defer log.Infof(2, "END Truncate(%v)", name)
+	log.Debugf("BEGIN Truncate(%v)", name)
+	defer log.Debugf("END Truncate(%v)", name)
 
 	return fuse.ENOSYS
 }
 
 func (vfs FuseVfs) Unlink(name string, context *fuse.Context) fuse.Status {
-	log.Infof(2, "BEGIN Unlink(%v)", name)
-	defer log.Infof(2, "END Unlink(%v)", name)
+	log.Debugf("BEGIN Unlink(%v)", name)
+	defer log.Debugf("END Unlink(%v)", name)
+
+	status := timedOut
+	if !vfs.pool.run("Unlink("+name+")", func() { status = vfs.unlink(name) }) {
+		return timedOut
+	}
+
+	return status
+}
+
+func (vfs FuseVfs) unlink(name string) fuse.Status {
+	if vfs.store.ReadOnly() {
+		return readOnly
+	}
 
 	tx, err := vfs.store.Begin()
 	if err != nil {
@@ -503,35 +692,45 @@ func (vfs FuseVfs) Unlink(name string, context *fuse.Context) fuse.Status {
 
 	switch path[0] {
 	case tagsDir:
-		dirName := path[len(path)-2]
-
-		var tagName, valueName string
-		if dirName[0] == '=' {
-			tagName = unescape(path[len(path)-3])
-			valueName = unescape(dirName[1:])
-		} else {
-			tagName = unescape(dirName)
-			valueName = ""
+		if !vfs.writeBack.Untag {
+			return fuse.EPERM
 		}
 
-		tag, err := vfs.store.TagByName(tx, tagName)
-		if err != nil {
-			log.Fatal(err)
+		// the tag removed is that of the innermost tag directory
+		names := pathToTagValueNames(path[1 : len(path)-1])
+		if len(names) == 0 {
+			return fuse.EPERM
 		}
-		if tag == nil {
-			log.Fatalf("could not retrieve tag '%v'.", tagName)
+
+		pairs, status := vfs.tagValuePairs(tx, names[len(names)-1:])
+		if status != fuse.OK {
+			return status
 		}
 
-		value, err := vfs.store.ValueByName(tx, valueName)
+		fileTags, err := vfs.store.FileTagsByFileId(tx, fileId, true)
 		if err != nil {
-			log.Fatal(err)
-		}
-		if value == nil {
-			log.Fatalf("could not retrieve value '%v'.", valueName)
+			log.Fatalf("could not retrieve tags for file '%v': %v", fileId, err)
 		}
 
-		if err = vfs.store.DeleteFileTag(tx, fileId, tag.Id, value.Id); err != nil {
-			log.Fatal(err)
+		removed := false
+		for _, fileTag := range fileTags {
+			if !matchesPair(*fileTag, pairs[0]) {
+				continue
+			}
+
+			if err := vfs.store.DeleteFileTag(tx, fileId, fileTag.TagId, fileTag.ValueId); err != nil {
+				if _, ok := err.(storage.TagPermissionError); ok {
+					return fuse.EACCES
+				}
+
+				log.Fatalf("could not untag file '%v': %v", fileId, err)
+			}
+
+			removed = true
+		}
+		if !removed {
+			// the tag is implied or inherited so cannot be removed here
+			return fuse.EPERM
 		}
 
 		if err := tx.Commit(); err != nil {
@@ -552,6 +751,49 @@ func (vfs FuseVfs) Utimens(name string, Atime *time.Time, Mtime *time.Time, cont
 
 // unexported
 
+func newFuseVfs(store *storage.Storage, absMountPath string, workers uint, timeout time.Duration, cache bool, writeBack WriteBack) *FuseVfs {
+	var results *resultCache
+	if cache {
+		results = newResultCache()
+	}
+
+	return &FuseVfs{store, absMountPath, nil, newWorkerPool(workers, timeout), results, writeBack}
+}
+
+func mount(fileSystem pathfs.FileSystem, mountPath string, options []string, owner Owner) (*fuse.Server, error) {
+	pathFs := pathfs.NewPathNodeFs(fileSystem, nil)
+	nodeOptions := nodefs.NewOptions()
+	nodeOptions.Owner = &fuse.Owner{Uid: owner.Uid, Gid: owner.Gid}
+	conn := nodefs.NewFileSystemConnector(pathFs.Root(), nodeOptions)
+	mountOptions := &fuse.MountOptions{Options: options}
+
+	server, err := fuse.NewServer(conn.RawFS(), mountPath, mountOptions)
+	if err != nil {
+		return nil, fmt.Errorf("could not mount virtual filesystem at '%v': %v", mountPath, err)
+	}
+
+	return server, nil
+}
+
+func serve(server *fuse.Server, ctx context.Context) {
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			log.Debug("unmounting virtual filesystem")
+
+			if err := server.Unmount(); err != nil {
+				log.Warnf("could not unmount virtual filesystem: %v", err)
+			}
+		case <-done:
+		}
+	}()
+
+	server.Serve()
+}
+
 func (vfs FuseVfs) splitPath(path string) []string {
 	return strings.Split(path, string(filepath.Separator))
 }
@@ -578,8 +820,8 @@ func (vfs FuseVfs) parseFileId(name string) entities.FileId {
 }
 
 func (vfs FuseVfs) topFiles() ([]fuse.DirEntry, fuse.Status) {
-	log.Infof(2, "BEGIN topFiles")
-	defer log.Infof(2, "END topFiles")
+	log.Debugf("BEGIN topFiles")
+	defer log.Debugf("END topFiles")
 
 	entries := []fuse.DirEntry{
 		{Name: databaseFilename, Mode: fuse.S_IFLNK},
@@ -589,8 +831,8 @@ func (vfs FuseVfs) topFiles() ([]fuse.DirEntry, fuse.Status) {
 }
 
 func (vfs FuseVfs) tagDirectories(tx *storage.Tx) ([]fuse.DirEntry, fuse.Status) {
-	log.Infof(2, "BEGIN tagDirectories")
-	defer log.Infof(2, "END tagDirectories")
+	log.Debugf("BEGIN tagDirectories")
+	defer log.Debugf("END tagDirectories")
 
 	tags, err := vfs.store.Tags(tx)
 	if err != nil {
@@ -617,8 +859,8 @@ func (vfs FuseVfs) tagDirectories(tx *storage.Tx) ([]fuse.DirEntry, fuse.Status)
 }
 
 func (vfs FuseVfs) queriesDirectories(tx *storage.Tx) ([]fuse.DirEntry, fuse.Status) {
-	log.Infof(2, "BEGIN queriesDirectories")
-	defer log.Infof(2, "END queriesDirectories")
+	log.Debugf("BEGIN queriesDirectories")
+	defer log.Debugf("END queriesDirectories")
 
 	queries, err := vfs.store.Queries(tx)
 	if err != nil {
@@ -627,7 +869,7 @@ func (vfs FuseVfs) queriesDirectories(tx *storage.Tx) ([]fuse.DirEntry, fuse.Sta
 
 	entries := make([]fuse.DirEntry, len(queries))
 	for index, query := range queries {
-		entries[index] = fuse.DirEntry{Name: query.Text, Mode: fuse.S_IFDIR}
+		entries[index] = fuse.DirEntry{Name: escape(query.Text), Mode: fuse.S_IFDIR}
 	}
 
 	if len(queries) < 1 {
@@ -638,18 +880,18 @@ func (vfs FuseVfs) queriesDirectories(tx *storage.Tx) ([]fuse.DirEntry, fuse.Sta
 }
 
 func (vfs FuseVfs) getFilesAttr(path []string) (*fuse.Attr, fuse.Status) {
-	log.Infof(2, "BEGIN getFilesAttr")
-	defer log.Infof(2, "END getFilesAttr")
+	log.Debugf("BEGIN getFilesAttr")
+	defer log.Debugf("END getFilesAttr")
 
 	now := time.Now()
 	return &fuse.Attr{Mode: fuse.S_IFDIR | 0755, Nlink: 2, Size: 0, Mtime: uint64(now.Unix()), Mtimensec: uint32(now.Nanosecond())}, fuse.OK
 }
 
 func (vfs FuseVfs) getTagsAttr() (*fuse.Attr, fuse.Status) {
-	log.Infof(2, "BEGIN getTagsAttr")
-	defer log.Infof(2, "END getTagsAttr")
+	log.Debugf("BEGIN getTagsAttr")
+	defer log.Debugf("END getTagsAttr")
 
-	tx, err := vfs.store.Begin()
+	tx, err := vfs.store.BeginRead()
 	if err != nil {
 		log.Fatalf("could not begin transaction: %v", err)
 	}
@@ -665,16 +907,16 @@ func (vfs FuseVfs) getTagsAttr() (*fuse.Attr, fuse.Status) {
 }
 
 func (vfs FuseVfs) getQueryAttr() (*fuse.Attr, fuse.Status) {
-	log.Infof(2, "BEGIN getQueryAttr")
-	defer log.Infof(2, "END getQueryAttr")
+	log.Debugf("BEGIN getQueryAttr")
+	defer log.Debugf("END getQueryAttr")
 
 	now := time.Now()
 	return &fuse.Attr{Mode: fuse.S_IFDIR | 0755, Nlink: 2, Size: 0, Mtime: uint64(now.Unix()), Mtimensec: uint32(now.Nanosecond())}, fuse.OK
 }
 
 func (vfs FuseVfs) getTaggedEntryAttr(path []string) (*fuse.Attr, fuse.Status) {
-	log.Infof(2, "BEGIN getTaggedEntryAttr(%v)", path)
-	defer log.Infof(2, "END getTaggedEntryAttr(%v)", path)
+	log.Debugf("BEGIN getTaggedEntryAttr(%v)", path)
+	defer log.Debugf("END getTaggedEntryAttr(%v)", path)
 
 	if len(path) == 1 && path[0] == helpFilename {
 		now := time.Now()
@@ -689,6 +931,12 @@ func (vfs FuseVfs) getTaggedEntryAttr(path []string) (*fuse.Attr, fuse.Status) {
 
 	fileId := vfs.parseFileId(name)
 	if fileId != 0 {
+		// a file only appears in the directories of the tags it has, so that a
+		// copy into another tag directory is not mistaken for the file itself
+		if !vfs.isTaggedWith(fileId, path[:len(path)-1]) {
+			return nil, fuse.ENOENT
+		}
+
 		return vfs.getFileEntryAttr(fileId)
 	}
 
@@ -700,7 +948,7 @@ func (vfs FuseVfs) getTaggedEntryAttr(path []string) (*fuse.Attr, fuse.Status) {
 		}
 	}
 
-	tx, err := vfs.store.Begin()
+	tx, err := vfs.store.BeginRead()
 	if err != nil {
 		log.Fatalf("could not begin transaction: %v", err)
 	}
@@ -719,8 +967,8 @@ func (vfs FuseVfs) getTaggedEntryAttr(path []string) (*fuse.Attr, fuse.Status) {
 }
 
 func (vfs FuseVfs) getQueryEntryAttr(path []string) (*fuse.Attr, fuse.Status) {
-	log.Infof(2, "BEGIN getQueryEntryAttr(%v)", path)
-	defer log.Infof(2, "END getQueryEntryAttr(%v)", path)
+	log.Debugf("BEGIN getQueryEntryAttr(%v)", path)
+	defer log.Debugf("END getQueryEntryAttr(%v)", path)
 
 	if len(path) == 1 && path[0] == helpFilename {
 		now := time.Now()
@@ -738,24 +986,24 @@ func (vfs FuseVfs) getQueryEntryAttr(path []string) (*fuse.Attr, fuse.Status) {
 		return nil, fuse.ENOENT
 	}
 
-	queryText := path[0]
+	queryText := unescape(path[0])
 
 	if queryText[len(queryText)-1] == ' ' {
 		// prevent multiple entries for same query when typing path in a GUI
 		return nil, fuse.ENOENT
 	}
 
-	expression, err := query.Parse(queryText)
-	if err != nil {
-		return nil, fuse.ENOENT
-	}
-
 	tx, err := vfs.store.Begin()
 	if err != nil {
 		log.Fatalf("could not begin transaction: %v", err)
 	}
 	defer tx.Commit()
 
+	expression, err := vfs.parseQuery(tx, queryText)
+	if err != nil {
+		return nil, fuse.ENOENT
+	}
+
 	tagNames, err := query.TagNames(expression)
 	if err != nil {
 		log.Fatalf("could not identify tag names: %v", err)
@@ -772,7 +1020,7 @@ func (vfs FuseVfs) getQueryEntryAttr(path []string) (*fuse.Attr, fuse.Status) {
 	if err != nil {
 		log.Fatalf("could not retrieve query '%v': %v", queryText, err)
 	}
-	if q == nil {
+	if q == nil && !vfs.store.ReadOnly() {
 		_, err = vfs.store.AddQuery(tx, queryText)
 		if err != nil {
 			log.Fatalf("could not add query '%v': %v", queryText, err)
@@ -797,16 +1045,7 @@ func (vfs FuseVfs) getDatabaseFileAttr() (*fuse.Attr, fuse.Status) {
 }
 
 func (vfs FuseVfs) getFileEntryAttr(fileId entities.FileId) (*fuse.Attr, fuse.Status) {
-	tx, err := vfs.store.Begin()
-	if err != nil {
-		log.Fatalf("could not begin transaction: %v", err)
-	}
-	defer tx.Commit()
-
-	file, err := vfs.store.File(tx, fileId)
-	if err != nil {
-		log.Fatalf("could not retrieve file #%v: %v", fileId, err)
-	}
+	file := vfs.file(fileId)
 	if file == nil {
 		return &fuse.Attr{Mode: fuse.S_IFREG}, fuse.ENOENT
 	}
@@ -825,9 +1064,35 @@ func (vfs FuseVfs) getFileEntryAttr(fileId entities.FileId) (*fuse.Attr, fuse.St
 	return &fuse.Attr{Mode: fuse.S_IFLNK | 0755, Size: uint64(size), Mtime: uint64(modTime.Unix()), Mtimensec: uint32(modTime.Nanosecond())}, fuse.OK
 }
 
+// Retrieves the file from the cache or, if not cached, the database.
+func (vfs FuseVfs) file(fileId entities.FileId) *entities.File {
+	if vfs.cache != nil {
+		if file, ok := vfs.cache.file(fileId); ok {
+			return file
+		}
+	}
+
+	tx, err := vfs.store.BeginRead()
+	if err != nil {
+		log.Fatalf("could not begin transaction: %v", err)
+	}
+	defer tx.Commit()
+
+	file, err := vfs.store.File(tx, fileId)
+	if err != nil {
+		log.Fatalf("could not retrieve file #%v: %v", fileId, err)
+	}
+
+	if vfs.cache != nil {
+		vfs.cache.putFile(fileId, file)
+	}
+
+	return file
+}
+
 func (vfs FuseVfs) openTaggedEntryDir(tx *storage.Tx, path []string) ([]fuse.DirEntry, fuse.Status) {
-	log.Infof(2, "BEGIN openTaggedEntryDir(%v)", path)
-	defer log.Infof(2, "END openTaggedEntryDir(%v)", path)
+	log.Debugf("BEGIN openTaggedEntryDir(%v)", path)
+	defer log.Debugf("END openTaggedEntryDir(%v)", path)
 
 	lastPathElement := path[len(path)-1]
 
@@ -895,8 +1160,8 @@ func (vfs FuseVfs) openTaggedEntryDir(tx *storage.Tx, path []string) ([]fuse.Dir
 }
 
 func (vfs FuseVfs) openTaggedEntryFilesDir(tx *storage.Tx, path []string) ([]fuse.DirEntry, fuse.Status) {
-	log.Infof(2, "BEGIN openTaggedEntryFilesDir(%v)", path)
-	defer log.Infof(2, "END openTaggedEntryFilesDir(%v)", path)
+	log.Debugf("BEGIN openTaggedEntryFilesDir(%v)", path)
+	defer log.Debugf("END openTaggedEntryFilesDir(%v)", path)
 
 	expression := pathToExpression(path)
 	files, err := vfs.store.FilesForQuery(tx, expression, "", false, false, "name")
@@ -914,13 +1179,137 @@ func (vfs FuseVfs) openTaggedEntryFilesDir(tx *storage.Tx, path []string) ([]fus
 	return entries, fuse.OK
 }
 
+// Whether the file has the tags, and values, represented by the directories of
+// the path within the tags directory.
+func (vfs FuseVfs) isTaggedWith(fileId entities.FileId, path []string) bool {
+	tx, err := vfs.store.BeginRead()
+	if err != nil {
+		log.Fatalf("could not begin transaction: %v", err)
+	}
+	defer tx.Commit()
+
+	pairs, status := vfs.tagValuePairs(tx, pathToTagValueNames(path))
+	if status != fuse.OK {
+		return false
+	}
+
+	fileTags, err := vfs.store.FileTagsByFileId(tx, fileId, false)
+	if err != nil {
+		log.Fatalf("could not retrieve tags for file '%v': %v", fileId, err)
+	}
+
+	for _, pair := range pairs {
+		if !fileTags.Any(func(fileTag entities.FileTag) bool { return matchesPair(fileTag, pair) }) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Applies the tags represented by the directories of the path of a link
+// created within the tags directory to the file linked to, which is identified
+// by the link's name: only a copy of an existing file entry can be linked.
+func (vfs FuseVfs) tagLinkedFile(linkName string) fuse.Status {
+	if vfs.store.ReadOnly() {
+		return readOnly
+	}
+
+	path := vfs.splitPath(linkName)
+	if len(path) < 3 || path[0] != tagsDir || !vfs.writeBack.Tag {
+		return fuse.EPERM
+	}
+
+	tx, err := vfs.store.Begin()
+	if err != nil {
+		log.Fatalf("could not begin transaction: %v", err)
+	}
+	defer tx.Commit()
+
+	fileId := vfs.parseFileId(path[len(path)-1])
+	if fileId == 0 {
+		return fuse.EPERM
+	}
+
+	file, err := vfs.store.File(tx, fileId)
+	if err != nil {
+		log.Fatalf("could not retrieve file for '%v': %v", linkName, err)
+	}
+	if file == nil {
+		return fuse.ENOENT
+	}
+
+	pairs, status := vfs.tagValuePairs(tx, pathToTagValueNames(path[1:len(path)-1]))
+	if status != fuse.OK {
+		return status
+	}
+
+	for _, pair := range pairs {
+		if _, err := vfs.store.AddFileTag(tx, file.Id, pair.TagId, pair.ValueId); err != nil {
+			if _, ok := err.(storage.TagPermissionError); ok {
+				return fuse.EACCES
+			}
+
+			log.Fatalf("could not tag file '%v': %v", file.Path(), err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Fatalf("could not commit transaction: %v", err)
+	}
+
+	return fuse.OK
+}
+
+// Looks up the tags and values named.
+func (vfs FuseVfs) tagValuePairs(tx *storage.Tx, names []tagValueName) (entities.TagIdValueIdPairs, fuse.Status) {
+	pairs := make(entities.TagIdValueIdPairs, len(names))
+
+	for index, name := range names {
+		tag, err := vfs.store.TagByName(tx, name.tagName)
+		if err != nil {
+			log.Fatalf("could not retrieve tag '%v': %v", name.tagName, err)
+		}
+		if tag == nil {
+			return nil, fuse.ENOENT
+		}
+
+		pairs[index].TagId = tag.Id
+
+		if name.valueName != "" {
+			value, err := vfs.store.ValueByName(tx, name.valueName)
+			if err != nil {
+				log.Fatalf("could not retrieve value '%v': %v", name.valueName, err)
+			}
+			if value == nil {
+				return nil, fuse.ENOENT
+			}
+
+			pairs[index].ValueId = value.Id
+		}
+	}
+
+	return pairs, fuse.OK
+}
+
+// Parses the query, expanding calls to the macros defined by the
+// 'queryMacros' setting.
+func (vfs FuseVfs) parseQuery(tx *storage.Tx, queryText string) (query.Expression, error) {
+	macros, err := vfs.store.QueryMacros(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	return query.ParseWithMacros(queryText, macros)
+}
+
 func (vfs FuseVfs) openQueryEntryDir(tx *storage.Tx, path []string) ([]fuse.DirEntry, fuse.Status) {
-	log.Infof(2, "BEGIN openQueryEntryDir(%v)", path)
-	defer log.Infof(2, "END openQueryEntryDir(%v)", path)
+	log.Debugf("BEGIN openQueryEntryDir(%v)", path)
+	defer log.Debugf("END openQueryEntryDir(%v)", path)
 
-	queryText := path[0]
+	queryText := unescape(path[0])
 
-	expression, err := query.Parse(queryText)
+	expression, err := vfs.parseQuery(tx, queryText)
 	if err != nil {
 		log.Fatalf("could not parse query: %v", err)
 	}
@@ -952,15 +1341,15 @@ func (vfs FuseVfs) openQueryEntryDir(tx *storage.Tx, path []string) ([]fuse.DirE
 }
 
 func (vfs FuseVfs) readDatabaseFileLink() (string, fuse.Status) {
-	log.Infof(2, "BEGIN readDatabaseFileLink()")
-	defer log.Infof(2, "END readDatabaseFileLink()")
+	log.Debugf("BEGIN readDatabaseFileLink()")
+	defer log.Debugf("END readDatabaseFileLink()")
 
 	return vfs.store.DbPath, fuse.OK
 }
 
 func (vfs FuseVfs) readTaggedEntryLink(tx *storage.Tx, path []string) (string, fuse.Status) {
-	log.Infof(2, "BEGIN readTaggedEntryLink(%v)", path)
-	defer log.Infof(2, "END readTaggedEntryLink(%v)", path)
+	log.Debugf("BEGIN readTaggedEntryLink(%v)", path)
+	defer log.Debugf("END readTaggedEntryLink(%v)", path)
 
 	name := path[len(path)-1]
 
@@ -1122,6 +1511,37 @@ func pathToExpression(path []string) query.Expression {
 	return expression
 }
 
+// A tag, and optionally value, represented by a directory.
+type tagValueName struct {
+	tagName   string
+	valueName string
+}
+
+// The tags and values represented by the directories of a path within the
+// tags directory, e.g. 'year/=2017/photo/files' represents 'year=2017' and
+// 'photo'.
+func pathToTagValueNames(path []string) []tagValueName {
+	names := make([]tagValueName, 0, len(path))
+
+	for index, element := range path {
+		switch {
+		case element == filesDir && index == len(path)-1:
+			// the directory listing the files
+		case element[0] == '=' && len(names) > 0:
+			names[len(names)-1].valueName = unescape(element[1:])
+		default:
+			names = append(names, tagValueName{unescape(element), ""})
+		}
+	}
+
+	return names
+}
+
+// Whether the file tag is for the tag, and value if one is specified.
+func matchesPair(fileTag entities.FileTag, pair entities.TagIdValueIdPair) bool {
+	return fileTag.TagId == pair.TagId && (pair.ValueId == 0 || fileTag.ValueId == pair.ValueId)
+}
+
 func fileIdToAscii(fileId entities.FileId) string {
 	return strconv.FormatUint(uint64(fileId), 10)
 }
@@ -1151,12 +1571,16 @@ func containsString(values []string, value string) bool {
 	return false
 }
 
+// Encodes the slashes and backslashes in a tag or value name, or in the text
+// of a query, which cannot appear in a directory entry, as lookalike characters
+// preceded by a zero-width space.
 func escape(name string) string {
 	name = strings.Replace(name, `/`, "\u200B\u2215", -1)
 	name = strings.Replace(name, `\`, "\u200B\u2216", -1)
 	return name
 }
 
+// Decodes a directory entry name encoded by escape.
 func unescape(name string) string {
 	name = strings.Replace(name, "\u200B\u2215", `/`, -1)
 	name = strings.Replace(name, "\u200B\u2216", `\`, -1)
@@ -55,14 +55,54 @@ func GetMountTable() ([]Mount, error) {
 
 		mountpoint := path.UnescapeOctal(parts[1])
 
-		databaseSymlink := filepath.Join(mountpoint, ".database")
-		databasePath, err := os.Readlink(databaseSymlink)
+		databasePaths, err := mountedDatabasePaths(mountpoint)
 		if err != nil {
 			return nil, err
 		}
 
-		mountTable = append(mountTable, Mount{databasePath, mountpoint})
+		for _, databasePath := range databasePaths {
+			mountTable = append(mountTable, Mount{databasePath, mountpoint})
+		}
 	}
 
 	return mountTable, nil
 }
+
+// unexported
+
+// The paths of the databases mounted at the mountpoint: that linked to from
+// the root of the mountpoint or, where several databases are mounted, those
+// linked to from each database's directory.
+func mountedDatabasePaths(mountpoint string) ([]string, error) {
+	databasePath, linkErr := os.Readlink(filepath.Join(mountpoint, databaseFilename))
+	if linkErr == nil {
+		return []string{databasePath}, nil
+	}
+
+	dirNames, err := readDirNames(mountpoint)
+	if err != nil || len(dirNames) == 0 {
+		return nil, linkErr
+	}
+
+	databasePaths := make([]string, len(dirNames))
+	for index, dirName := range dirNames {
+		databasePath, err := os.Readlink(filepath.Join(mountpoint, dirName, databaseFilename))
+		if err != nil {
+			return nil, linkErr
+		}
+
+		databasePaths[index] = databasePath
+	}
+
+	return databasePaths, nil
+}
+
+func readDirNames(path string) ([]string, error) {
+	dir, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	return dir.Readdirnames(0)
+}
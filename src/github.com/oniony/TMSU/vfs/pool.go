@@ -0,0 +1,76 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// +build !windows
+
+package vfs
+
+import (
+	"github.com/oniony/TMSU/common/log"
+	"time"
+)
+
+// Bounds the number of requests concurrently accessing the database and the
+// time any one request may take, so that a burst of requests cannot exhaust
+// database connections or leave callers blocked indefinitely.
+type workerPool struct {
+	slots   chan struct{}
+	timeout time.Duration
+}
+
+func newWorkerPool(workers uint, timeout time.Duration) *workerPool {
+	if workers == 0 {
+		workers = 1
+	}
+
+	return &workerPool{make(chan struct{}, workers), timeout}
+}
+
+// Runs the work on a worker, returning false if the work could not be completed within the timeout.
+//
+// Work that times out continues in the background and retains its worker until it completes.
+func (pool *workerPool) run(description string, work func()) bool {
+	var deadline <-chan time.Time
+	if pool.timeout > 0 {
+		timer := time.NewTimer(pool.timeout)
+		defer timer.Stop()
+
+		deadline = timer.C
+	}
+
+	select {
+	case pool.slots <- struct{}{}:
+	case <-deadline:
+		log.Warnf("%v: timed out waiting for a worker", description)
+		return false
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		defer func() { <-pool.slots }()
+		defer close(done)
+
+		work()
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-deadline:
+		log.Warnf("%v: timed out after %v", description, pool.timeout)
+		return false
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// +build !windows
+
+package vfs
+
+import (
+	"context"
+	"fmt"
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/storage"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A virtual filesystem presenting several databases, each as the virtual
+// filesystem of that database within a directory of its own.
+type UnionVfs struct {
+	pathfs.FileSystem
+	names     []string
+	databases map[string]*FuseVfs
+	server    *fuse.Server
+}
+
+// Mounts the virtual filesystem of each of the databases within a directory
+// named after the database.
+//
+// The options are as for MountVfs and apply to each database separately, so
+// that at most 'workers' requests will access each database concurrently.
+func MountUnionVfs(stores []*storage.Storage, mountPath string, options []string, workers uint, timeout time.Duration, cache bool, writeBack WriteBack, owner Owner) (*UnionVfs, error) {
+	absMountPath, err := filepath.Abs(mountPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not convert mount path '%v' to absolute: %v", mountPath, err)
+	}
+
+	unionVfs := UnionVfs{pathfs.NewDefaultFileSystem(), make([]string, len(stores)), make(map[string]*FuseVfs, len(stores)), nil}
+
+	for index, store := range stores {
+		name := databaseDirName(store.DbPath)
+		for suffix := 2; unionVfs.databases[name] != nil; suffix++ {
+			name = fmt.Sprintf("%v-%v", databaseDirName(store.DbPath), suffix)
+		}
+
+		log.Debugf("presenting database '%v' as '%v'", store.DbPath, name)
+
+		unionVfs.names[index] = name
+		unionVfs.databases[name] = newFuseVfs(store, filepath.Join(absMountPath, name), workers, timeout, cache, writeBack)
+	}
+
+	server, err := mount(&unionVfs, mountPath, options, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	unionVfs.server = server
+
+	return &unionVfs, nil
+}
+
+func (vfs UnionVfs) Unmount() {
+	vfs.server.Unmount()
+}
+
+// Serves the virtual filesystem until it is unmounted or the context is
+// cancelled, whereupon it is unmounted.
+func (vfs UnionVfs) Serve(ctx context.Context) {
+	serve(vfs.server, ctx)
+}
+
+func (vfs UnionVfs) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
+	if name == "" {
+		now := time.Now()
+		return &fuse.Attr{Mode: fuse.S_IFDIR | 0755, Nlink: 2, Size: uint64(0), Mtime: uint64(now.Unix()), Mtimensec: uint32(now.Nanosecond())}, fuse.OK
+	}
+
+	database, path := vfs.route(name)
+	if database == nil {
+		return nil, fuse.ENOENT
+	}
+
+	return database.GetAttr(path, context)
+}
+
+func (vfs UnionVfs) Create(name string, flags uint32, mode uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	database, path := vfs.route(name)
+	if database == nil {
+		return nil, fuse.EPERM
+	}
+
+	return database.Create(path, flags, mode, context)
+}
+
+func (vfs UnionVfs) Mkdir(name string, mode uint32, context *fuse.Context) fuse.Status {
+	database, path := vfs.route(name)
+	if database == nil || path == "" {
+		return fuse.EPERM
+	}
+
+	return database.Mkdir(path, mode, context)
+}
+
+func (vfs UnionVfs) Open(name string, flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	database, path := vfs.route(name)
+	if database == nil {
+		return nil, fuse.ENOENT
+	}
+
+	return database.Open(path, flags, context)
+}
+
+func (vfs UnionVfs) OpenDir(name string, context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
+	if name == "" {
+		entries := make([]fuse.DirEntry, len(vfs.names))
+		for index, name := range vfs.names {
+			entries[index] = fuse.DirEntry{Name: name, Mode: fuse.S_IFDIR}
+		}
+
+		return entries, fuse.OK
+	}
+
+	database, path := vfs.route(name)
+	if database == nil {
+		return nil, fuse.ENOENT
+	}
+
+	return database.OpenDir(path, context)
+}
+
+func (vfs UnionVfs) Readlink(name string, context *fuse.Context) (string, fuse.Status) {
+	database, path := vfs.route(name)
+	if database == nil {
+		return "", fuse.ENOENT
+	}
+
+	return database.Readlink(path, context)
+}
+
+func (vfs UnionVfs) Rename(oldName string, newName string, context *fuse.Context) fuse.Status {
+	// not EXDEV, lest the move be attempted by copying, as that would create
+	// tags in the other database
+	oldDatabase, oldPath := vfs.route(oldName)
+	newDatabase, newPath := vfs.route(newName)
+	if oldDatabase == nil || oldDatabase != newDatabase || oldPath == "" || newPath == "" {
+		return fuse.EPERM
+	}
+
+	return oldDatabase.Rename(oldPath, newPath, context)
+}
+
+func (vfs UnionVfs) Rmdir(name string, context *fuse.Context) fuse.Status {
+	database, path := vfs.route(name)
+	if database == nil || path == "" {
+		return fuse.EPERM
+	}
+
+	return database.Rmdir(path, context)
+}
+
+func (vfs UnionVfs) StatFs(name string) *fuse.StatfsOut {
+	return &fuse.StatfsOut{}
+}
+
+func (vfs UnionVfs) String() string {
+	return "tmsu"
+}
+
+func (vfs UnionVfs) Symlink(value string, linkName string, context *fuse.Context) fuse.Status {
+	database, path := vfs.route(linkName)
+	if database == nil {
+		return fuse.EPERM
+	}
+
+	return database.Symlink(value, path, context)
+}
+
+func (vfs UnionVfs) Unlink(name string, context *fuse.Context) fuse.Status {
+	database, path := vfs.route(name)
+	if database == nil {
+		return fuse.EPERM
+	}
+
+	return database.Unlink(path, context)
+}
+
+// unexported
+
+// Identifies the virtual filesystem of the database whose directory contains
+// the path and the path within that filesystem.
+func (vfs UnionVfs) route(name string) (*FuseVfs, string) {
+	parts := strings.SplitN(name, string(filepath.Separator), 2)
+
+	database := vfs.databases[parts[0]]
+	if database == nil || len(parts) == 1 {
+		return database, ""
+	}
+
+	return database, parts[1]
+}
+
+// The name of the directory presenting the database: for a database in the
+// default location, '.tmsu/db', that of the directory containing '.tmsu',
+// otherwise the database's file name without its extension.
+func databaseDirName(dbPath string) string {
+	absDbPath, err := filepath.Abs(dbPath)
+	if err != nil {
+		absDbPath = dbPath
+	}
+
+	dirPath, fileName := filepath.Split(absDbPath)
+	if fileName == "db" && filepath.Base(dirPath) == ".tmsu" {
+		if name := filepath.Base(filepath.Dir(filepath.Clean(dirPath))); name != string(filepath.Separator) {
+			return name
+		}
+	}
+
+	if name := strings.TrimSuffix(fileName, filepath.Ext(fileName)); name != "" {
+		return name
+	}
+
+	return fileName
+}
@@ -0,0 +1,373 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// +build !windows
+
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/storage"
+	"golang.org/x/net/webdav"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Creates an HTTP handler serving the virtual filesystem over WebDAV, for
+// machines that cannot mount it with FUSE. The tag and query directories are
+// as in the mounted virtual filesystem but the files are served with the
+// content of the files they link to, as WebDAV has no symbolic links.
+//
+// Directories may be created, renamed and removed as in the mounted virtual
+// filesystem, subject to 'writeBack', unless 'readOnly' is set. The other
+// options are as for MountVfs.
+func NewWebdavHandler(store *storage.Storage, workers uint, timeout time.Duration, cache bool, writeBack WriteBack, readOnly bool) http.Handler {
+	fileSystem := webdavFileSystem{newFuseVfs(store, "/", workers, timeout, cache, writeBack), readOnly}
+
+	return &webdav.Handler{
+		FileSystem: fileSystem,
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(request *http.Request, err error) {
+			if err != nil {
+				log.Debugf("%v %v: %v", request.Method, request.URL, err)
+			} else {
+				log.Debugf("%v %v", request.Method, request.URL)
+			}
+		},
+	}
+}
+
+// unexported
+
+type webdavFileSystem struct {
+	vfs      *FuseVfs
+	readOnly bool
+}
+
+func (fileSystem webdavFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if fileSystem.readOnly {
+		return os.ErrPermission
+	}
+
+	return statusError(fileSystem.vfs.Mkdir(vfsName(name), uint32(perm), &fuse.Context{}))
+}
+
+func (fileSystem webdavFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, os.ErrPermission
+	}
+
+	name = vfsName(name)
+
+	attr, status := fileSystem.vfs.GetAttr(name, &fuse.Context{})
+	if status != fuse.OK {
+		return nil, statusError(status)
+	}
+
+	switch attr.Mode & syscall.S_IFMT {
+	case syscall.S_IFDIR:
+		return &webdavDirectory{fileSystem, name, attrInfo{path.Base("/" + name), attr}, nil}, nil
+	case syscall.S_IFLNK:
+		target, err := fileSystem.target(name)
+		if err != nil {
+			return nil, err
+		}
+
+		file, err := os.Open(target)
+		if err != nil {
+			return nil, err
+		}
+
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		return &webdavLink{file, linkInfo{path.Base(name), info}}, nil
+	}
+
+	file, status := fileSystem.vfs.Open(name, uint32(os.O_RDONLY), &fuse.Context{})
+	if status != fuse.OK {
+		return nil, statusError(status)
+	}
+	defer file.Release()
+
+	buffer := make([]byte, attr.Size)
+	result, status := file.Read(buffer, 0)
+	if status != fuse.OK {
+		return nil, statusError(status)
+	}
+
+	data, status := result.Bytes(buffer)
+	if status != fuse.OK {
+		return nil, statusError(status)
+	}
+
+	return &webdavData{bytes.NewReader(data), attrInfo{path.Base(name), attr}}, nil
+}
+
+func (fileSystem webdavFileSystem) RemoveAll(ctx context.Context, name string) error {
+	if fileSystem.readOnly {
+		return os.ErrPermission
+	}
+
+	name = vfsName(name)
+
+	attr, status := fileSystem.vfs.GetAttr(name, &fuse.Context{})
+	if status != fuse.OK {
+		return statusError(status)
+	}
+
+	if attr.Mode&syscall.S_IFMT == syscall.S_IFDIR {
+		return statusError(fileSystem.vfs.Rmdir(name, &fuse.Context{}))
+	}
+
+	return statusError(fileSystem.vfs.Unlink(name, &fuse.Context{}))
+}
+
+func (fileSystem webdavFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	if fileSystem.readOnly {
+		return os.ErrPermission
+	}
+
+	return statusError(fileSystem.vfs.Rename(vfsName(oldName), vfsName(newName), &fuse.Context{}))
+}
+
+func (fileSystem webdavFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	return fileSystem.stat(vfsName(name))
+}
+
+// The details of the entry of the virtual filesystem or, for a link, of the
+// file it links to.
+func (fileSystem webdavFileSystem) stat(name string) (os.FileInfo, error) {
+	attr, status := fileSystem.vfs.GetAttr(name, &fuse.Context{})
+	if status != fuse.OK {
+		return nil, statusError(status)
+	}
+
+	if attr.Mode&syscall.S_IFMT != syscall.S_IFLNK {
+		return attrInfo{path.Base("/" + name), attr}, nil
+	}
+
+	target, err := fileSystem.target(name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return nil, err
+	}
+
+	return linkInfo{path.Base(name), info}, nil
+}
+
+// The absolute path of the file the link links to.
+func (fileSystem webdavFileSystem) target(name string) (string, error) {
+	target, status := fileSystem.vfs.Readlink(name, &fuse.Context{})
+	if status != fuse.OK {
+		return "", statusError(status)
+	}
+
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(fileSystem.vfs.mountPath, filepath.Dir(name), target)
+	}
+
+	return target, nil
+}
+
+type webdavDirectory struct {
+	fileSystem webdavFileSystem
+	name       string
+	info       os.FileInfo
+	remaining  []os.FileInfo
+}
+
+func (directory *webdavDirectory) Close() error {
+	return nil
+}
+
+func (directory *webdavDirectory) Read(buffer []byte) (int, error) {
+	return 0, syscall.EISDIR
+}
+
+func (directory *webdavDirectory) Seek(offset int64, whence int) (int64, error) {
+	return 0, syscall.EISDIR
+}
+
+func (directory *webdavDirectory) Write(buffer []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (directory *webdavDirectory) Stat() (os.FileInfo, error) {
+	return directory.info, nil
+}
+
+// Lists the directory's entries. The files whose links cannot be followed,
+// such as those that are missing, are omitted.
+func (directory *webdavDirectory) Readdir(count int) ([]os.FileInfo, error) {
+	if directory.remaining == nil {
+		entries, status := directory.fileSystem.vfs.OpenDir(directory.name, &fuse.Context{})
+		if status != fuse.OK {
+			return nil, statusError(status)
+		}
+
+		directory.remaining = make([]os.FileInfo, 0, len(entries))
+		for _, entry := range entries {
+			info, err := directory.fileSystem.stat(filepath.Join(directory.name, entry.Name))
+			if err != nil {
+				log.Debugf("%v: omitting entry: %v", filepath.Join(directory.name, entry.Name), err)
+				continue
+			}
+
+			directory.remaining = append(directory.remaining, info)
+		}
+	}
+
+	if count <= 0 {
+		infos := directory.remaining
+		directory.remaining = directory.remaining[len(infos):]
+		return infos, nil
+	}
+
+	if len(directory.remaining) == 0 {
+		return nil, io.EOF
+	}
+
+	if count > len(directory.remaining) {
+		count = len(directory.remaining)
+	}
+
+	infos := directory.remaining[:count]
+	directory.remaining = directory.remaining[count:]
+
+	return infos, nil
+}
+
+// A file served in place of a link to it.
+type webdavLink struct {
+	*os.File
+	info os.FileInfo
+}
+
+func (link *webdavLink) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, syscall.ENOTDIR
+}
+
+func (link *webdavLink) Stat() (os.FileInfo, error) {
+	return link.info, nil
+}
+
+func (link *webdavLink) Write(buffer []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+// A file of the virtual filesystem itself, such as a help file.
+type webdavData struct {
+	*bytes.Reader
+	info os.FileInfo
+}
+
+func (data *webdavData) Close() error {
+	return nil
+}
+
+func (data *webdavData) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, syscall.ENOTDIR
+}
+
+func (data *webdavData) Stat() (os.FileInfo, error) {
+	return data.info, nil
+}
+
+func (data *webdavData) Write(buffer []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+// The details of an entry of the virtual filesystem.
+type attrInfo struct {
+	name string
+	attr *fuse.Attr
+}
+
+func (info attrInfo) Name() string {
+	return info.name
+}
+
+func (info attrInfo) Size() int64 {
+	if info.IsDir() {
+		return 0
+	}
+
+	return int64(info.attr.Size)
+}
+
+func (info attrInfo) Mode() os.FileMode {
+	if info.IsDir() {
+		return os.ModeDir | 0755
+	}
+
+	return 0444
+}
+
+func (info attrInfo) ModTime() time.Time {
+	return time.Unix(int64(info.attr.Mtime), int64(info.attr.Mtimensec))
+}
+
+func (info attrInfo) IsDir() bool {
+	return info.attr.Mode&syscall.S_IFMT == syscall.S_IFDIR
+}
+
+func (info attrInfo) Sys() interface{} {
+	return nil
+}
+
+// The details of the file a link links to, named as the link.
+type linkInfo struct {
+	name string
+	os.FileInfo
+}
+
+func (info linkInfo) Name() string {
+	return info.name
+}
+
+// The name within the virtual filesystem of the WebDAV path.
+func vfsName(name string) string {
+	return filepath.FromSlash(strings.TrimPrefix(path.Clean("/"+name), "/"))
+}
+
+func statusError(status fuse.Status) error {
+	switch status {
+	case fuse.OK:
+		return nil
+	case fuse.ENOENT:
+		return os.ErrNotExist
+	case fuse.EPERM, fuse.EACCES, readOnly:
+		return os.ErrPermission
+	}
+
+	return syscall.Errno(status)
+}
@@ -0,0 +1,434 @@
+// Copyright 2011-2018 Paul Ruane.
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package web
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"github.com/oniony/TMSU/common/log"
+	"github.com/oniony/TMSU/entities"
+	"github.com/oniony/TMSU/query"
+	"github.com/oniony/TMSU/storage"
+	"io/fs"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+//go:embed static
+var staticFiles embed.FS
+
+// Serves a web interface to the database: a tag cloud, a query box, the list
+// of matching files and, unless read-only, the editing of their tags.
+type Server struct {
+	store    *storage.Storage
+	readOnly bool
+	mutex    sync.Mutex
+	mux      *http.ServeMux
+}
+
+func NewServer(store *storage.Storage, readOnly bool) *Server {
+	server := &Server{store: store, readOnly: readOnly, mux: http.NewServeMux()}
+
+	static, err := fs.Sub(staticFiles, "static")
+	if err != nil {
+		panic(fmt.Sprintf("could not open embedded static files: %v", err))
+	}
+
+	server.mux.Handle("/", http.FileServer(http.FS(static)))
+	server.mux.HandleFunc("/api/tags", server.handleTags)
+	server.mux.HandleFunc("/api/files", server.handleFiles)
+	server.mux.HandleFunc("/api/filetag", server.handleFileTag)
+	server.mux.HandleFunc("/content", server.handleContent)
+
+	return server
+}
+
+// Serves the web interface on the specified address until an error occurs or
+// the context is cancelled, whereupon the requests in progress are completed
+// before returning. A request's transaction is abandoned should its client
+// disconnect.
+func (server *Server) ListenAndServe(ctx context.Context, address string) error {
+	httpServer := &http.Server{Addr: address, Handler: server}
+
+	go func() {
+		<-ctx.Done()
+		httpServer.Shutdown(context.Background())
+	}()
+
+	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+func (server *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	log.Debugf("%v %v", request.Method, request.URL)
+
+	// the database is not safe for concurrent use
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.mux.ServeHTTP(writer, request)
+}
+
+// unexported
+
+type tagCount struct {
+	Name        string     `json:"name"`
+	Count       uint       `json:"count"`
+	Description string     `json:"description,omitempty"`
+	Colour      string     `json:"colour,omitempty"`
+	Created     *time.Time `json:"created,omitempty"`
+}
+
+type fileTag struct {
+	Tag      string `json:"tag"`
+	Value    string `json:"value"`
+	Explicit bool   `json:"explicit"`
+}
+
+type file struct {
+	Path  string    `json:"path"`
+	IsDir bool      `json:"isDir"`
+	Tags  []fileTag `json:"tags"`
+}
+
+type files struct {
+	Files    []file `json:"files"`
+	ReadOnly bool   `json:"readOnly"`
+}
+
+func (server *Server) handleTags(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		writeError(writer, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+		return
+	}
+
+	tx, err := server.store.BeginContext(request.Context())
+	if err != nil {
+		writeError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Commit()
+
+	usages, err := server.store.TagUsage(tx)
+	if err != nil {
+		writeError(writer, http.StatusInternalServerError, fmt.Errorf("could not retrieve tags: %v", err))
+		return
+	}
+
+	infos, err := server.store.TagInfos(tx)
+	if err != nil {
+		writeError(writer, http.StatusInternalServerError, fmt.Errorf("could not retrieve tag details: %v", err))
+		return
+	}
+
+	counts := make([]tagCount, len(usages))
+	for index, usage := range usages {
+		counts[index] = tagCount{usage.Name, usage.FileCount, "", "", nil}
+
+		if info := infos.ForTag(usage.Id); info != nil {
+			counts[index].Description = info.Description
+			counts[index].Colour = info.Colour
+			if !info.Created.IsZero() {
+				created := info.Created
+				counts[index].Created = &created
+			}
+		}
+	}
+
+	writeJson(writer, counts)
+}
+
+func (server *Server) handleFiles(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		writeError(writer, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+		return
+	}
+
+	tx, err := server.store.BeginContext(request.Context())
+	if err != nil {
+		writeError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Commit()
+
+	macros, err := server.store.QueryMacros(tx)
+	if err != nil {
+		writeError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	expression, err := query.ParseWithMacros(request.FormValue("query"), macros)
+	if err != nil {
+		writeError(writer, http.StatusBadRequest, fmt.Errorf("could not parse query: %v", err))
+		return
+	}
+
+	dbFiles, err := server.store.FilesForQuery(tx, expression, "", false, false, "name")
+	if err != nil {
+		writeError(writer, http.StatusInternalServerError, fmt.Errorf("could not query files: %v", err))
+		return
+	}
+
+	result := files{make([]file, len(dbFiles)), server.readOnly}
+	for index, dbFile := range dbFiles {
+		tags, err := server.fileTags(tx, dbFile.Id)
+		if err != nil {
+			writeError(writer, http.StatusInternalServerError, err)
+			return
+		}
+
+		result.Files[index] = file{dbFile.Path(), dbFile.IsDir, tags}
+	}
+
+	writeJson(writer, result)
+}
+
+// Applies (POST) or removes (DELETE) a tag to or from a file.
+func (server *Server) handleFileTag(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost && request.Method != http.MethodDelete {
+		writeError(writer, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+		return
+	}
+	if server.readOnly {
+		writeError(writer, http.StatusForbidden, fmt.Errorf("tags cannot be edited: the server is read-only"))
+		return
+	}
+
+	path := request.FormValue("path")
+	tagName := request.FormValue("tag")
+	valueName := request.FormValue("value")
+
+	tx, err := server.store.BeginContext(request.Context())
+	if err != nil {
+		writeError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	dbFile, err := server.store.FileByPath(tx, path)
+	if err != nil {
+		tx.Rollback()
+		writeError(writer, http.StatusInternalServerError, fmt.Errorf("could not retrieve file: %v", err))
+		return
+	}
+	if dbFile == nil {
+		tx.Rollback()
+		writeError(writer, http.StatusNotFound, fmt.Errorf("%v: file is not tagged", path))
+		return
+	}
+
+	if request.Method == http.MethodPost {
+		err = server.applyTag(tx, dbFile, tagName, valueName)
+	} else {
+		err = server.removeTag(tx, dbFile, tagName, valueName)
+	}
+	if err != nil {
+		tx.Rollback()
+		writeError(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	tags, err := server.fileTags(tx, dbFile.Id)
+	if err != nil {
+		tx.Rollback()
+		writeError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJson(writer, file{dbFile.Path(), dbFile.IsDir, tags})
+}
+
+// Serves the content of a tagged file so that it can be viewed in the browser.
+func (server *Server) handleContent(writer http.ResponseWriter, request *http.Request) {
+	path := request.FormValue("path")
+
+	tx, err := server.store.BeginContext(request.Context())
+	if err != nil {
+		writeError(writer, http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Commit()
+
+	// only tagged files are served, not arbitrary paths
+	dbFile, err := server.store.FileByPath(tx, path)
+	if err != nil {
+		writeError(writer, http.StatusInternalServerError, fmt.Errorf("could not retrieve file: %v", err))
+		return
+	}
+	if dbFile == nil || dbFile.IsDir {
+		writeError(writer, http.StatusNotFound, fmt.Errorf("%v: no such tagged file", path))
+		return
+	}
+
+	http.ServeFile(writer, request, dbFile.Path())
+}
+
+func (server *Server) applyTag(tx *storage.Tx, dbFile *entities.File, tagName, valueName string) error {
+	settings, err := server.store.Settings(tx)
+	if err != nil {
+		return err
+	}
+
+	tag, err := server.store.TagByName(tx, tagName)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tag '%v': %v", tagName, err)
+	}
+	if tag == nil {
+		if !settings.AutoCreateTags() {
+			return fmt.Errorf("no such tag '%v'", tagName)
+		}
+		if !settings.PermitsNewTag(tagName) {
+			return fmt.Errorf("no such tag '%v': the vocabulary is closed", tagName)
+		}
+		if err := entities.ValidateTagName(tagName); err != nil {
+			return err
+		}
+
+		tag, err = server.store.AddTag(tx, tagName)
+		if err != nil {
+			return fmt.Errorf("could not create tag '%v': %v", tagName, err)
+		}
+	}
+
+	value, err := server.store.ValueByName(tx, valueName)
+	if err != nil {
+		return fmt.Errorf("could not retrieve value '%v': %v", valueName, err)
+	}
+	if value == nil {
+		if !settings.AutoCreateValues() {
+			return fmt.Errorf("no such value '%v'", valueName)
+		}
+		if err := entities.ValidateValueName(valueName); err != nil {
+			return err
+		}
+
+		value, err = server.store.AddValue(tx, valueName)
+		if err != nil {
+			return fmt.Errorf("could not create value '%v': %v", valueName, err)
+		}
+	}
+
+	exists, err := server.store.FileTagExists(tx, dbFile.Id, tag.Id, value.Id, true)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	if _, err := server.store.AddFileTag(tx, dbFile.Id, tag.Id, value.Id); err != nil {
+		return fmt.Errorf("could not apply tag '%v': %v", tagName, err)
+	}
+
+	return nil
+}
+
+func (server *Server) removeTag(tx *storage.Tx, dbFile *entities.File, tagName, valueName string) error {
+	tag, err := server.store.TagByName(tx, tagName)
+	if err != nil {
+		return fmt.Errorf("could not retrieve tag '%v': %v", tagName, err)
+	}
+	if tag == nil {
+		return fmt.Errorf("no such tag '%v'", tagName)
+	}
+
+	value, err := server.store.ValueByName(tx, valueName)
+	if err != nil {
+		return fmt.Errorf("could not retrieve value '%v': %v", valueName, err)
+	}
+	if value == nil {
+		return fmt.Errorf("no such value '%v'", valueName)
+	}
+
+	exists, err := server.store.FileTagExists(tx, dbFile.Id, tag.Id, value.Id, true)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("tag '%v' is not applied explicitly", tagName)
+	}
+
+	if err := server.store.DeleteFileTag(tx, dbFile.Id, tag.Id, value.Id); err != nil {
+		return fmt.Errorf("could not remove tag '%v': %v", tagName, err)
+	}
+
+	return server.store.DeleteFileIfUntagged(tx, dbFile.Id)
+}
+
+// The explicit and implied tags of the file, sorted by name.
+func (server *Server) fileTags(tx *storage.Tx, fileId entities.FileId) ([]fileTag, error) {
+	dbFileTags, err := server.store.FileTagsByFileId(tx, fileId, false)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve file tags: %v", err)
+	}
+
+	tags := make([]fileTag, 0, len(dbFileTags))
+	for _, dbFileTag := range dbFileTags {
+		tag, err := server.store.Tag(tx, dbFileTag.TagId)
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve tag #%v: %v", dbFileTag.TagId, err)
+		}
+
+		value, err := server.store.Value(tx, dbFileTag.ValueId)
+		if err != nil {
+			return nil, fmt.Errorf("could not retrieve value #%v: %v", dbFileTag.ValueId, err)
+		}
+
+		valueName := ""
+		if value != nil {
+			valueName = value.Name
+		}
+
+		tags = append(tags, fileTag{tag.Name, valueName, dbFileTag.Explicit})
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Tag != tags[j].Tag {
+			return tags[i].Tag < tags[j].Tag
+		}
+		return tags[i].Value < tags[j].Value
+	})
+
+	return tags, nil
+}
+
+func writeJson(writer http.ResponseWriter, body interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(writer).Encode(body); err != nil {
+		log.Warnf("could not write response: %v", err)
+	}
+}
+
+func writeError(writer http.ResponseWriter, status int, err error) {
+	log.Debugf("request failed: %v", err)
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+
+	json.NewEncoder(writer).Encode(map[string]string{"error": err.Error()})
+}
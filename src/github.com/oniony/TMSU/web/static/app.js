@@ -0,0 +1,136 @@
+"use strict";
+
+const queryInput = document.getElementById("query");
+const tagsElement = document.getElementById("tags");
+const filesElement = document.getElementById("files");
+const statusElement = document.getElementById("status");
+const fileTemplate = document.getElementById("file-template");
+
+async function request(method, url, params) {
+  if (params) {
+    url += "?" + new URLSearchParams(params);
+  }
+
+  const response = await fetch(url, { method: method });
+  const body = await response.json();
+  if (!response.ok) {
+    throw new Error(body.error);
+  }
+
+  return body;
+}
+
+function showStatus(text, isError) {
+  statusElement.textContent = text;
+  statusElement.className = isError ? "error" : "";
+}
+
+// quotes the characters that are significant within a query
+function escapeName(name) {
+  return name.replace(/[\\= ()!<>]/g, "\\$&");
+}
+
+// splits 'tag=value' upon the first unescaped equals sign
+function parseTag(text) {
+  const match = text.match(/^((?:\\.|[^=])*)(?:=(.*))?$/);
+  const unescape = (name) => (name || "").replace(/\\(.)/g, "$1");
+
+  return { tag: unescape(match[1]), value: unescape(match[2]) };
+}
+
+async function loadTags() {
+  const tags = await request("GET", "api/tags");
+  const maximum = Math.max(1, ...tags.map((tag) => tag.count));
+
+  tagsElement.replaceChildren();
+  for (const tag of tags) {
+    const link = document.createElement("a");
+    link.textContent = tag.name;
+    link.title = tag.count + " file(s)" + (tag.description ? ": " + tag.description : "");
+    if (tag.colour) {
+      link.style.color = tag.colour;
+    }
+    link.style.fontSize = (0.8 + tag.count / maximum) + "em";
+    link.addEventListener("click", () => search(escapeName(tag.name)));
+    tagsElement.append(link, " ");
+  }
+}
+
+function renderTags(element, file, readOnly) {
+  element.replaceChildren();
+
+  for (const fileTag of file.tags) {
+    const tagElement = document.createElement("span");
+    tagElement.className = fileTag.explicit ? "tag" : "tag implied";
+    tagElement.textContent = fileTag.value ? fileTag.tag + "=" + fileTag.value : fileTag.tag;
+
+    if (fileTag.explicit && !readOnly) {
+      const removeButton = document.createElement("button");
+      removeButton.textContent = "×";
+      removeButton.title = "remove tag";
+      removeButton.addEventListener("click", () => editTag("DELETE", element, file, fileTag));
+      tagElement.append(removeButton);
+    }
+
+    element.append(tagElement);
+  }
+}
+
+async function editTag(method, element, file, fileTag) {
+  try {
+    const updated = await request(method, "api/filetag", { path: file.path, tag: fileTag.tag, value: fileTag.value });
+    file.tags = updated.tags;
+    renderTags(element, file, false);
+    await loadTags();
+  } catch (error) {
+    showStatus(error.message, true);
+  }
+}
+
+async function search(queryText) {
+  queryInput.value = queryText;
+  history.replaceState(null, "", "?query=" + encodeURIComponent(queryText));
+
+  try {
+    const result = await request("GET", "api/files", { query: queryText });
+    showStatus(result.files.length + " file(s)", false);
+
+    filesElement.replaceChildren();
+    for (const file of result.files) {
+      const item = fileTemplate.content.cloneNode(true);
+
+      const link = item.querySelector(".path");
+      link.textContent = file.path;
+      if (!file.isDir) {
+        link.href = "content?path=" + encodeURIComponent(file.path);
+      }
+
+      const tagsElement = item.querySelector(".tags");
+      renderTags(tagsElement, file, result.readOnly);
+
+      const form = item.querySelector(".add-tag");
+      if (result.readOnly) {
+        form.remove();
+      } else {
+        form.addEventListener("submit", (event) => {
+          event.preventDefault();
+          const input = form.querySelector("input");
+          editTag("POST", tagsElement, file, parseTag(input.value.trim()));
+          input.value = "";
+        });
+      }
+
+      filesElement.append(item);
+    }
+  } catch (error) {
+    showStatus(error.message, true);
+  }
+}
+
+document.getElementById("query-form").addEventListener("submit", (event) => {
+  event.preventDefault();
+  search(queryInput.value);
+});
+
+loadTags().catch((error) => showStatus(error.message, true));
+search(new URLSearchParams(location.search).get("query") || "");